@@ -1,12 +1,30 @@
 // Run using-
 // go run benchmark_redis.go --host localhost --port 6379 --total-requests 1000000 --clients 200
+// go run benchmark_redis.go --total-requests 100000 --clients 50 --command "SET key:__rand__ value" --keyspace 10000
+// go run benchmark_redis.go --total-requests 100000 --clients 50 --latency
+// go run benchmark_redis.go --total-requests 1000000 --clients 50 --pipeline 16
+// go run benchmark_redis.go --mode subscribe --channel foo --total-requests 100000 --clients 10
+// go run benchmark_redis.go --mode publish --channel foo --total-requests 100000 --clients 50
+// go run benchmark_redis.go --unixsocket /tmp/redis.sock --total-requests 1000000 --clients 200
+// go run benchmark_redis.go --mode wait --command "SET key:__rand__ value" --total-requests 100000 --clients 50 --pipeline 16
+// go run benchmark_redis.go --total-requests 100000 --clients 50 --latency --output csv --output-file results.csv
+// go run benchmark_redis.go --total-requests 1000000 --clients 50 --max-retries 10
+// go run benchmark_redis.go --total-requests 1000000 --clients 200 --idle-clients 10000
 package main
 
 import (
 	"bufio"
+	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math/rand"
 	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,16 +34,234 @@ var (
 	port          = flag.Int("port", 6379, "Redis server port")
 	totalRequests = flag.Int("total-requests", 0, "Total number of commands to send (required)")
 	clients       = flag.Int("clients", 0, "Number of concurrent clients (required)")
+	command       = flag.String("command", "PING", "Command template to send; every __rand__ is replaced by a random integer in [0, keyspace)")
+	keyspace      = flag.Int("keyspace", 100000, "Range of the random integers substituted for __rand__")
+	// Every sample is kept in memory (8 bytes per request, i.e. ~8 MB for 1M requests), so that exact percentiles can be computed
+	latency = flag.Bool("latency", false, "Record the latency of every request and report its percentiles")
+	// With pipelining, every request of a batch is recorded with the round-trip latency of the whole batch
+	pipeline = flag.Int("pipeline", 1, "Number of commands written back-to-back before reading their replies")
+	// In publish mode the command is PUBLISH to the channel, and in subscribe mode every client expects to receive
+	// `total-requests` messages, i.e. all the messages of a publish run with the same total
+	mode    = flag.String("mode", "command", "What the clients do: command (send the command), publish, subscribe or wait")
+	channel = flag.String("channel", "benchmark", "Channel to publish to or subscribe to")
+	payload = flag.String("payload", "message", "Message published to the channel")
+
+	// Connecting through a Unix socket skips the TCP stack, to compare the two
+	unixsocket = flag.String("unixsocket", "", "Path of the Unix socket to connect to instead of host and port")
+
+	// Idle connections cost the server nothing but the tasks waiting on them, which is what e.g. io-threads is
+	// compared under
+	idleClients = flag.Int("idle-clients", 0, "Number of connections opened before the run and left idle during it")
+
+	// In wait mode every batch of commands is followed by WAIT, whose latency is how long the replicas took to
+	// acknowledge the batch, i.e. the replication lag as seen by the client
+	waitReplicas = flag.Int("wait-replicas", 1, "Number of replicas WAIT waits for in wait mode")
+	waitTimeout  = flag.Int("wait-timeout", 1000, "Milliseconds after which WAIT gives up in wait mode")
+
+	// The CSV line is meant for tracking the performance over time, e.g. in CI; its percentiles are in milliseconds
+	// and are left empty without --latency
+	output     = flag.String("output", "text", "Format of the report: text, or csv for a single line with the results")
+	outputFile = flag.String("output-file", "", "File to append the CSV line to instead of printing it; a new file gets a header first")
+
+	// Retrying lets a run go on through a restart or a failover of the server, e.g. for resilience testing; the
+	// requests whose replies were lost along with the connection count as errors and aren't sent again
+	maxRetries = flag.Int("max-retries", 0, "Number of times a client retries connecting, at the start or after losing its connection, before giving up; 0 never reconnects")
+)
+
+// Delay before the first retry to connect, which doubles with every failed attempt up to maxBackoff
+const (
+	initialBackoff = 100 * time.Millisecond
+	maxBackoff     = 5 * time.Second
 )
 
-// respPing is the raw Redis PING command in RESP format
-var respPing = []byte("*1\r\n$4\r\nPING\r\n")
+// csvHeader names the columns of the CSV line
+var csvHeader = []string{"timestamp", "command", "clients", "pipeline", "total_requests", "elapsed_s", "throughput", "p50", "p95", "p99", "errors", "reconnects"}
+
+// randToken is the placeholder in the command template which gets replaced by a random integer
+const randToken = "__rand__"
+
+// encodeCommand converts the space separated command arguments into a RESP array of bulk strings
+func encodeCommand(args []string) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return []byte(sb.String())
+}
+
+// commandBuilder returns a function which produces the RESP encoded command for every request.
+// If the template doesn't contain any random token, then the command is encoded only once.
+func commandBuilder(args []string, rng *rand.Rand) func() []byte {
+	if !strings.Contains(strings.Join(args, " "), randToken) {
+		encoded := encodeCommand(args)
+		return func() []byte { return encoded }
+	}
 
-func client(host string, port int, n int, wg *sync.WaitGroup) {
+	substituted := make([]string, len(args))
+	return func() []byte {
+		for i, arg := range args {
+			if strings.Contains(arg, randToken) {
+				arg = strings.ReplaceAll(arg, randToken, strconv.Itoa(rng.Intn(*keyspace)))
+			}
+			substituted[i] = arg
+		}
+		return encodeCommand(substituted)
+	}
+}
+
+// readLine reads a single CRLF terminated line and returns it without the CRLF
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", fmt.Errorf("malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// readReply consumes exactly one complete RESP reply (including nested arrays) from the reader
+func readReply(reader *bufio.Reader) error {
+	line, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+	if len(line) == 0 {
+		return errors.New("empty reply line")
+	}
+
+	switch line[0] {
+	case '+', '-', ':':
+		return nil
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("invalid bulk length %q", line)
+		}
+		if length < 0 {
+			return nil // Null bulk string
+		}
+		// Payload followed by CRLF
+		_, err = reader.Discard(length + 2)
+		return err
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("invalid array length %q", line)
+		}
+		for i := 0; i < count; i++ { // Null array has negative count and so no elements
+			if err := readReply(reader); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected reply type %q", line)
+	}
+}
+
+// readInteger reads a RESP integer reply, such as the number of replicas replied by WAIT
+func readInteger(reader *bufio.Reader) (int, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return 0, err
+	}
+	if len(line) == 0 || line[0] != ':' {
+		return 0, fmt.Errorf("expected an integer, got %q", line)
+	}
+	return strconv.Atoi(line[1:])
+}
+
+// readBulkString reads a RESP bulk string reply, such as the one of INFO
+func readBulkString(reader *bufio.Reader) (string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return "", fmt.Errorf("expected a bulk string, got %q", line)
+	}
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("invalid bulk length %q", line)
+	}
+	data := make([]byte, length+2)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return "", err
+	}
+	return string(data[:length]), nil
+}
+
+// readArray reads a RESP array whose elements are bulk strings or integers, which is the shape of the
+// messages and of the confirmations a subscriber receives, and returns the elements as strings
+func readArray(reader *bufio.Reader) ([]string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	// RESP3 pushes are arrays as well
+	if len(line) == 0 || (line[0] != '*' && line[0] != '>') {
+		return nil, fmt.Errorf("expected an array, got %q", line)
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid array length %q", line)
+	}
+
+	elements := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			return nil, errors.New("empty reply line")
+		}
+		switch line[0] {
+		case ':':
+			elements = append(elements, line[1:])
+		case '$':
+			length, err := strconv.Atoi(line[1:])
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid bulk length %q", line)
+			}
+			// Payload followed by CRLF, which may be split across multiple TCP reads
+			data := make([]byte, length+2)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return nil, err
+			}
+			elements = append(elements, string(data[:length]))
+		default:
+			return nil, fmt.Errorf("unexpected element type %q", line)
+		}
+	}
+	return elements, nil
+}
+
+// serverAddr returns the network and address to connect to: the Unix socket if one is given, else host and port
+func serverAddr(host string, port int) (string, string) {
+	if *unixsocket != "" {
+		return "unix", *unixsocket
+	}
+	return "tcp", net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// subscriber subscribes to the channel and reads messages until `n` of them were received, storing the time the
+// first and the last of them arrived in `first` and `last`, and the number received in `received`
+func subscriber(host string, port int, n int, subscribed *sync.WaitGroup, first, last *time.Time, received *int, wg *sync.WaitGroup) {
 	defer wg.Done()
+	isSubscribed := false
+	// The other subscribers mustn't wait for this one if it fails before subscribing
+	defer func() {
+		if !isSubscribed {
+			subscribed.Done()
+		}
+	}()
 
-	addr := fmt.Sprintf("%s:%d", host, port)
-	conn, err := net.Dial("tcp", addr)
+	network, addr := serverAddr(host, port)
+	conn, err := net.Dial(network, addr)
 	if err != nil {
 		fmt.Printf("Error connecting to %s: %v\n", addr, err)
 		return
@@ -33,28 +269,449 @@ func client(host string, port int, n int, wg *sync.WaitGroup) {
 	defer conn.Close()
 
 	reader := bufio.NewReader(conn)
-
-	for i := 0; i < n; i++ {
-		// send PING
-		if _, err := conn.Write(respPing); err != nil {
-			fmt.Printf("Write error: %v\n", err)
+	if _, err := conn.Write(encodeCommand([]string{"SUBSCRIBE", *channel})); err != nil {
+		fmt.Printf("Write error: %v\n", err)
+		return
+	}
+	for *received < n {
+		message, err := readArray(reader)
+		if err != nil {
+			fmt.Printf("Read error: %v\n", err)
 			return
 		}
-		// read response line
-		if _, err := reader.ReadBytes('\n'); err != nil {
-			fmt.Printf("Read error: %v\n", err)
+		switch {
+		case len(message) == 3 && message[0] == "subscribe":
+			isSubscribed = true
+			subscribed.Done()
+		case len(message) == 3 && message[0] == "message":
+			*last = time.Now()
+			if *received == 0 {
+				*first = *last
+			}
+			*received++
+		default:
+			fmt.Printf("Unexpected message: %q\n", message)
 			return
 		}
 	}
 }
 
+// runSubscribers runs the subscribers until every one of them received `n` messages, and prints the rate at which
+// they were received, measured from the first message received by any subscriber to the last one
+func runSubscribers(n int) {
+	var subscribed, wg sync.WaitGroup
+	subscribed.Add(*clients)
+	wg.Add(*clients)
+	firsts := make([]time.Time, *clients)
+	lasts := make([]time.Time, *clients)
+	received := make([]int, *clients)
+	for i := 0; i < *clients; i++ {
+		go subscriber(*host, *port, n, &subscribed, &firsts[i], &lasts[i], &received[i], &wg)
+	}
+	subscribed.Wait()
+	fmt.Printf("Subscribed %d clients to %s, waiting for %d messages each\n", *clients, *channel, n)
+	wg.Wait()
+
+	var first, last time.Time
+	total := 0
+	for i := range received {
+		if received[i] == 0 {
+			continue
+		}
+		if first.IsZero() || firsts[i].Before(first) {
+			first = firsts[i]
+		}
+		if lasts[i].After(last) {
+			last = lasts[i]
+		}
+		total += received[i]
+	}
+
+	fmt.Printf("Channel        : %s\n", *channel)
+	fmt.Printf("Total clients  : %d\n", *clients)
+	fmt.Printf("Received       : %d messages\n", total)
+	if total == 0 {
+		return
+	}
+	elapsed := last.Sub(first)
+	fmt.Printf("Elapsed time   : %.3f s\n", elapsed.Seconds())
+	if elapsed > 0 {
+		fmt.Printf("Receive rate   : %.0f msgs/sec\n", float64(total)/elapsed.Seconds())
+	}
+}
+
+// clientStats counts the outcomes of the requests of a client
+type clientStats struct {
+	succeeded  int // Requests which got a reply other than an error
+	failed     int // Requests which got an error reply, or none as the connection was lost
+	reconnects int // Times the client connected again after losing its connection
+}
+
+// connect dials the server, retrying up to --max-retries times while it can't be reached, with an exponential
+// backoff between the attempts
+func connect(network, addr string) (net.Conn, error) {
+	conn, err := net.Dial(network, addr)
+	backoff := initialBackoff
+	for retry := 0; err != nil && retry < *maxRetries; retry++ {
+		fmt.Printf("Error connecting to %s: %v, retrying in %v\n", addr, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxBackoff)
+		conn, err = net.Dial(network, addr)
+	}
+	return conn, err
+}
+
+// readReplies reads the replies to a batch of `count` commands and returns how many of them were read, counting
+// the error replies, such as -LOADING while the server restarts, as failed requests
+func readReplies(reader *bufio.Reader, count int, stats *clientStats) (int, error) {
+	for i := 0; i < count; i++ {
+		kind, err := reader.Peek(1)
+		if err != nil {
+			return i, err
+		}
+		isError := kind[0] == '-'
+		if err := readReply(reader); err != nil {
+			return i, err
+		}
+		if isError {
+			stats.failed++
+		} else {
+			stats.succeeded++
+		}
+	}
+	return count, nil
+}
+
+// client sends `n` commands in batches of `pipeline` commands and, if latency recording is enabled,
+// stores the per-request latencies in `latencies`
+// In wait mode every batch is followed by WAIT, whose latencies are stored in `waits`, counting in `timedOut` those
+// which gave up before enough replicas acknowledged the batch.
+// A client which loses its connection connects again, unless --max-retries is 0, and goes on with the next batch,
+// and gives up once it can't, counting all the requests it didn't get a reply to as failed in `stats`.
+func client(host string, port int, n int, args []string, seed int64, latencies, waits *[]time.Duration, timedOut *int, stats *clientStats, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	network, addr := serverAddr(host, port)
+	conn, err := connect(network, addr)
+	if err != nil {
+		fmt.Printf("Error connecting to %s: %v\n", addr, err)
+		stats.failed += n
+		return
+	}
+	// The connection is replaced whenever the client reconnects
+	defer func() { conn.Close() }()
+
+	reader := bufio.NewReader(conn)
+	nextCommand := commandBuilder(args, rand.New(rand.NewSource(seed)))
+	if *latency {
+		*latencies = make([]time.Duration, 0, n)
+	}
+	wait := encodeCommand([]string{"WAIT", strconv.Itoa(*waitReplicas), strconv.Itoa(*waitTimeout)})
+	// reconnect replaces the lost connection, returning false if the client has to give up
+	reconnect := func(lost error) bool {
+		fmt.Printf("Connection error: %v\n", lost)
+		conn.Close()
+		if *maxRetries == 0 {
+			return false
+		}
+		newConn, err := connect(network, addr)
+		if err != nil {
+			fmt.Printf("Giving up on %s: %v\n", addr, err)
+			return false
+		}
+		conn = newConn
+		reader = bufio.NewReader(conn)
+		stats.reconnects++
+		return true
+	}
+
+	var batch []byte
+	for sent := 0; sent < n; {
+		batchSize := min(*pipeline, n-sent)
+		batch = batch[:0]
+		for i := 0; i < batchSize; i++ {
+			batch = append(batch, nextCommand()...)
+		}
+
+		start := time.Now()
+		// send the whole batch in a single write, then read exactly one complete response per command; the buffered
+		// reader blocks until a reply which is split across multiple TCP reads is available in full
+		replied := 0
+		_, err := conn.Write(batch)
+		if err == nil {
+			replied, err = readReplies(reader, batchSize, stats)
+		}
+		sent += batchSize
+		if err != nil {
+			stats.failed += batchSize - replied
+			if !reconnect(err) {
+				stats.failed += n - sent
+				return
+			}
+			continue
+		}
+		if *latency {
+			elapsed := time.Since(start)
+			for i := 0; i < batchSize; i++ {
+				*latencies = append(*latencies, elapsed)
+			}
+		}
+
+		if *mode == "wait" {
+			start := time.Now()
+			var acked int
+			_, err := conn.Write(wait)
+			if err == nil {
+				acked, err = readInteger(reader)
+			}
+			if err != nil {
+				if !reconnect(err) {
+					stats.failed += n - sent
+					return
+				}
+				continue
+			}
+			*waits = append(*waits, time.Since(start))
+			if acked < *waitReplicas {
+				*timedOut++
+			}
+		}
+	}
+}
+
+// openIdle opens `n` connections which send nothing, for the server to hold during the run
+func openIdle(host string, port int, n int) ([]net.Conn, error) {
+	network, addr := serverAddr(host, port)
+	conns := make([]net.Conn, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// replicationOffsets extracts from the reply of INFO replication the offset of the master and the offsets which
+// the replicas acknowledged, from the lines like `slave0:ip=127.0.0.1,port=6380,state=online,offset=42,lag=0`
+func replicationOffsets(info string) (int64, []int64, error) {
+	var master int64 = -1
+	var replicas []int64
+	for _, line := range strings.Split(info, "\r\n") {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		if name == "master_repl_offset" {
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, nil, fmt.Errorf("invalid master offset %q", value)
+			}
+			master = offset
+		} else if strings.HasPrefix(name, "slave") {
+			for _, field := range strings.Split(value, ",") {
+				if offset, found := strings.CutPrefix(field, "offset="); found {
+					offset, err := strconv.ParseInt(offset, 10, 64)
+					if err != nil {
+						return 0, nil, fmt.Errorf("invalid replica offset in %q", line)
+					}
+					replicas = append(replicas, offset)
+				}
+			}
+		}
+	}
+	if master < 0 {
+		return 0, nil, errors.New("no master_repl_offset in INFO replication")
+	}
+	return master, replicas, nil
+}
+
+// printReplicationLag prints from INFO replication how far behind the offset of the master every replica is, by the
+// offset it last acknowledged
+// Replicas acknowledge their offset when WAIT asks them to, with a REPLCONF GETACK which itself counts towards the
+// offset of the master, so replicas which caught up are still behind by the length of the GETACKs they answered last.
+func printReplicationLag(host string, port int) {
+	network, addr := serverAddr(host, port)
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		fmt.Printf("Error connecting to %s: %v\n", addr, err)
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	if _, err := conn.Write(encodeCommand([]string{"INFO", "replication"})); err != nil {
+		fmt.Printf("Write error: %v\n", err)
+		return
+	}
+	reply, err := readBulkString(reader)
+	if err != nil {
+		fmt.Printf("Read error: %v\n", err)
+		return
+	}
+	master, replicas, err := replicationOffsets(reply)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Printf("Master offset  : %d\n", master)
+	for i, offset := range replicas {
+		fmt.Printf("  replica %-4d : offset %d, %d bytes behind\n", i, offset, master-offset)
+	}
+}
+
+// percentile returns the value below which `p` percent of the sorted samples fall (nearest-rank method)
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(float64(len(sorted))*p/100+0.5) - 1
+	rank = max(0, min(rank, len(sorted)-1))
+	return sorted[rank]
+}
+
+// mergeLatencies merges the latencies of all the clients, sorted
+func mergeLatencies(perClient [][]time.Duration) []time.Duration {
+	var samples []time.Duration
+	for _, latencies := range perClient {
+		samples = append(samples, latencies...)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples
+}
+
+// printLatencyReport merges the latencies of all the clients and prints their summary under the title along with a
+// histogram
+func printLatencyReport(title string, perClient [][]time.Duration) {
+	samples := mergeLatencies(perClient)
+	if len(samples) == 0 {
+		fmt.Println("No latency samples recorded")
+		return
+	}
+
+	var total time.Duration
+	for _, sample := range samples {
+		total += sample
+	}
+
+	fmt.Printf("%-15s:\n", title)
+	fmt.Printf("  min          : %v\n", samples[0])
+	fmt.Printf("  mean         : %v\n", total/time.Duration(len(samples)))
+	fmt.Printf("  p50          : %v\n", percentile(samples, 50))
+	fmt.Printf("  p95          : %v\n", percentile(samples, 95))
+	fmt.Printf("  p99          : %v\n", percentile(samples, 99))
+	fmt.Printf("  max          : %v\n", samples[len(samples)-1])
+
+	// Histogram with buckets whose upper bounds double, starting from 100us
+	fmt.Println("Histogram      :")
+	bucket := 100 * time.Microsecond
+	below := 0
+	for below < len(samples) {
+		count := sort.Search(len(samples), func(i int) bool { return samples[i] > bucket }) - below
+		if count > 0 {
+			fmt.Printf("  <= %-9v : %6.2f%% (%d)\n", bucket, 100*float64(count)/float64(len(samples)), count)
+		}
+		below += count
+		bucket *= 2
+	}
+}
+
+// sumStats adds up the outcomes of the requests of all the clients
+func sumStats(perClient []clientStats) clientStats {
+	var total clientStats
+	for _, stats := range perClient {
+		total.succeeded += stats.succeeded
+		total.failed += stats.failed
+		total.reconnects += stats.reconnects
+	}
+	return total
+}
+
+// writeCSV prints the results as a CSV line, or appends it to the output file, writing the header first if the file
+// is new or empty, and refusing a file whose header names other columns
+// The throughput only counts the requests which succeeded.
+func writeCSV(start time.Time, args []string, elapsed time.Duration, perClient [][]time.Duration, stats clientStats) error {
+	samples := mergeLatencies(perClient)
+	percentiles := make([]string, 3)
+	if len(samples) > 0 {
+		for i, p := range []float64{50, 95, 99} {
+			percentiles[i] = strconv.FormatFloat(float64(percentile(samples, p))/float64(time.Millisecond), 'f', 3, 64)
+		}
+	}
+	record := append([]string{
+		start.UTC().Format(time.RFC3339),
+		strings.Join(args, " "),
+		strconv.Itoa(*clients),
+		strconv.Itoa(*pipeline),
+		strconv.Itoa(*totalRequests),
+		strconv.FormatFloat(elapsed.Seconds(), 'f', 3, 64),
+		strconv.FormatFloat(float64(stats.succeeded)/elapsed.Seconds(), 'f', 0, 64),
+	}, percentiles...)
+	record = append(record, strconv.Itoa(stats.failed), strconv.Itoa(stats.reconnects))
+
+	if *outputFile == "" {
+		writer := csv.NewWriter(os.Stdout)
+		writer.Write(record)
+		writer.Flush()
+		return writer.Error()
+	}
+	file, err := os.OpenFile(*outputFile, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	if info.Size() == 0 {
+		writer.Write(csvHeader)
+	} else {
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = -1
+		header, err := reader.Read()
+		if err != nil {
+			return err
+		}
+		if strings.Join(header, ",") != strings.Join(csvHeader, ",") {
+			return fmt.Errorf("%s has the columns %s instead of %s, use a new output file", *outputFile, strings.Join(header, ","), strings.Join(csvHeader, ","))
+		}
+	}
+	writer.Write(record)
+	writer.Flush()
+	return writer.Error()
+}
+
 func main() {
 	flag.Parse()
 
-	if *totalRequests <= 0 || *clients <= 0 {
+	args := strings.Fields(*command)
+	if *mode == "publish" {
+		args = []string{"PUBLISH", *channel, *payload}
+	}
+	isValidMode := *mode == "command" || *mode == "publish" || *mode == "subscribe" || *mode == "wait"
+	// Subscribers don't send requests, so they have no line to report
+	isValidOutput := *output == "text" || (*output == "csv" && *mode != "subscribe")
+	if !isValidMode || !isValidOutput || *totalRequests <= 0 || *clients <= 0 || len(args) == 0 || *keyspace <= 0 || *pipeline <= 0 || *waitReplicas < 0 || *waitTimeout < 0 || *maxRetries < 0 || *idleClients < 0 {
 		flag.Usage()
 		return
 	}
+	idle, err := openIdle(*host, *port, *idleClients)
+	if err != nil {
+		fmt.Printf("Error opening the idle connections: %v\n", err)
+		return
+	}
+	defer func() {
+		for _, conn := range idle {
+			conn.Close()
+		}
+	}()
+	if *mode == "subscribe" {
+		runSubscribers(*totalRequests)
+		return
+	}
 
 	// divide requests among clients
 	perClient := *totalRequests / *clients
@@ -62,6 +719,10 @@ func main() {
 
 	var wg sync.WaitGroup
 	wg.Add(*clients)
+	latencies := make([][]time.Duration, *clients)
+	waits := make([][]time.Duration, *clients)
+	timedOut := make([]int, *clients)
+	stats := make([]clientStats, *clients)
 
 	start := time.Now()
 
@@ -70,15 +731,50 @@ func main() {
 		if i < extra {
 			cnt++
 		}
-		go client(*host, *port, cnt, &wg)
+		go client(*host, *port, cnt, args, start.UnixNano()+int64(i), &latencies[i], &waits[i], &timedOut[i], &stats[i], &wg)
 	}
 
 	wg.Wait()
 	elapsed := time.Since(start)
+	total := sumStats(stats)
+
+	if *output == "csv" {
+		if err := writeCSV(start, args, elapsed, latencies, total); err != nil {
+			fmt.Printf("Error writing the CSV output: %v\n", err)
+		}
+		return
+	}
 
+	fmt.Printf("Command        : %s\n", strings.Join(args, " "))
 	fmt.Printf("Total requests : %d\n", *totalRequests)
 	fmt.Printf("Total clients  : %d\n", *clients)
+	if *idleClients > 0 {
+		fmt.Printf("Idle clients   : %d\n", *idleClients)
+	}
+	fmt.Printf("Pipeline       : %d\n", *pipeline)
 	fmt.Printf("Elapsed time   : %.3f s\n", elapsed.Seconds())
-	ops := float64(*totalRequests) / elapsed.Seconds()
-	fmt.Printf("Throughput     : %.0f ops/sec\n", ops)
+	// Requests which failed don't count, so that a run which lost connections doesn't look faster than it was
+	ops := float64(total.succeeded) / elapsed.Seconds()
+	if *mode == "publish" {
+		fmt.Printf("Publish rate   : %.0f ops/sec\n", ops)
+	} else {
+		fmt.Printf("Throughput     : %.0f ops/sec\n", ops)
+	}
+	fmt.Printf("Errors         : %d\n", total.failed)
+	fmt.Printf("Reconnects     : %d\n", total.reconnects)
+	fmt.Printf("Success rate   : %.2f%%\n", 100*float64(total.succeeded)/float64(*totalRequests))
+
+	if *latency {
+		printLatencyReport("Latency", latencies)
+	}
+	if *mode == "wait" {
+		totalTimedOut := 0
+		for _, count := range timedOut {
+			totalTimedOut += count
+		}
+		fmt.Printf("WAIT replicas  : %d, timeout %d ms\n", *waitReplicas, *waitTimeout)
+		fmt.Printf("WAIT timed out : %d\n", totalTimedOut)
+		printLatencyReport("WAIT latency", waits)
+		printReplicationLag(*host, *port)
+	}
 }
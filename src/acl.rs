@@ -0,0 +1,396 @@
+//! ACL: the users which the clients authenticate as, along with the commands and the keys each of them may access
+//! Like in Redis, connections start authenticated as the `default` user, which may run everything without a
+//! password unless `requirepass` gives it one. Passwords are only kept hashed with SHA-256.
+
+use std::{
+    collections::{BTreeMap, BTreeSet, HashSet},
+    sync::Mutex,
+};
+
+use crate::{
+    clients::Clients,
+    command::{self, CommandSpec},
+    glob,
+    resp::RespValue,
+    sha256,
+};
+
+/// Name of the user which the connections start authenticated as
+pub const DEFAULT_USER: &str = "default";
+
+/// Length of a password hash in hexadecimal
+const HASH_LEN: usize = 64;
+
+/// A user and its permissions, as set by ACL SETUSER
+#[derive(Clone)]
+struct User {
+    /// Whether clients may authenticate as the user (`on`/`off`)
+    enabled: bool,
+    /// Whether any password is accepted (`nopass`)
+    nopass: bool,
+    /// SHA-256 hashes of the accepted passwords, in hexadecimal
+    passwords: BTreeSet<String>,
+    /// Rules which gave the allowed commands, in the order they were applied, e.g. `+@all -keys`
+    command_rules: String,
+    /// Commands which the user may run
+    allowed_commands: HashSet<&'static str>,
+    /// Glob-style patterns of the keys which the user may access
+    key_patterns: Vec<Vec<u8>>,
+}
+
+impl User {
+    /// A user as created by ACL SETUSER, which can't do anything until it is given permissions
+    fn new() -> Self {
+        Self {
+            enabled: false,
+            nopass: false,
+            passwords: BTreeSet::new(),
+            command_rules: "-@all".to_owned(),
+            allowed_commands: HashSet::new(),
+            key_patterns: Vec::new(),
+        }
+    }
+
+    /// The default user, which may do anything without a password
+    fn default_user() -> Self {
+        let mut user = Self::new();
+        user.enabled = true;
+        user.nopass = true;
+        user.key_patterns.push(b"*".to_vec());
+        user.apply_command_rule("+@all").unwrap();
+        user
+    }
+
+    /// Apply a rule of ACL SETUSER, returning why it is invalid if it is
+    fn apply(&mut self, rule: &str) -> Result<(), &'static str> {
+        match rule.to_lowercase().as_str() {
+            "on" => self.enabled = true,
+            "off" => self.enabled = false,
+            "nopass" => {
+                self.nopass = true;
+                self.passwords.clear();
+            }
+            "resetpass" => {
+                self.nopass = false;
+                self.passwords.clear();
+            }
+            "allkeys" => self.key_patterns = vec![b"*".to_vec()],
+            "resetkeys" => self.key_patterns.clear(),
+            "allcommands" => self.apply_command_rule("+@all")?,
+            "nocommands" => self.apply_command_rule("-@all")?,
+            "reset" => *self = Self::new(),
+            _ => self.apply_argument_rule(rule)?,
+        }
+        Ok(())
+    }
+
+    /// Apply a rule made of a prefix and an argument, e.g. `>password` or `~pattern`
+    fn apply_argument_rule(&mut self, rule: &str) -> Result<(), &'static str> {
+        let Some(argument) = rule.get(1..) else {
+            return Err("Syntax error");
+        };
+        match rule.as_bytes()[0] {
+            b'>' => {
+                self.nopass = false;
+                self.passwords
+                    .insert(sha256::hex_digest(argument.as_bytes()));
+            }
+            b'#' => {
+                let is_hash = argument.len() == HASH_LEN
+                    && argument
+                        .bytes()
+                        .all(|c| matches!(c, b'0'..=b'9' | b'a'..=b'f'));
+                if !is_hash {
+                    return Err("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters");
+                }
+                self.nopass = false;
+                self.passwords.insert(argument.to_owned());
+            }
+            prefix @ (b'<' | b'!') => {
+                let hash = if prefix == b'<' {
+                    sha256::hex_digest(argument.as_bytes())
+                } else {
+                    argument.to_owned()
+                };
+                if !self.passwords.remove(&hash) {
+                    return Err(
+                        "The password you are trying to remove from the user does not exist",
+                    );
+                }
+            }
+            b'~' => {
+                if self.key_patterns.iter().any(|pattern| pattern == b"*") {
+                    return Err("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns");
+                }
+                self.key_patterns.push(argument.as_bytes().to_vec());
+            }
+            b'+' | b'-' => self.apply_command_rule(rule)?,
+            _ => return Err("Syntax error"),
+        }
+        Ok(())
+    }
+
+    /// Allow (`+`) or disallow (`-`) a command, or all the commands of a category (`@category`)
+    fn apply_command_rule(&mut self, rule: &str) -> Result<(), &'static str> {
+        let (is_allowed, name) = (rule.starts_with('+'), rule[1..].to_lowercase());
+        let commands = name
+            .strip_prefix('@')
+            .map_or_else(
+                || command::canonical_name(name.as_bytes()).map(|name| vec![name]),
+                command::category_commands,
+            )
+            .ok_or("Unknown command or category name in ACL")?;
+        for command in commands {
+            if is_allowed {
+                self.allowed_commands.insert(command);
+            } else {
+                self.allowed_commands.remove(command);
+            }
+        }
+        // Like in Redis, all the commands being allowed or disallowed makes the previous rules moot
+        let rule = format!("{}{name}", &rule[..1]);
+        if name == "@all" {
+            self.command_rules = rule;
+        } else {
+            self.command_rules = format!("{} {rule}", self.command_rules);
+        }
+        Ok(())
+    }
+
+    /// Describe the user on a line of ACL LIST, as rules which would recreate it
+    fn describe(&self, name: &str) -> String {
+        let mut rules = vec![
+            "user".to_owned(),
+            name.to_owned(),
+            if self.enabled { "on" } else { "off" }.to_owned(),
+        ];
+        if self.nopass {
+            rules.push("nopass".to_owned());
+        }
+        rules.extend(self.passwords.iter().map(|hash| format!("#{hash}")));
+        rules.extend(self.key_patterns_rules());
+        rules.push(self.command_rules.clone());
+        rules.join(" ")
+    }
+
+    /// Rules giving the key patterns, e.g. `~*`
+    fn key_patterns_rules(&self) -> Vec<String> {
+        self.key_patterns
+            .iter()
+            .map(|pattern| format!("~{}", String::from_utf8_lossy(pattern)))
+            .collect()
+    }
+
+    /// Describe the user as ACL GETUSER does, by its flags, password hashes, command rules and key patterns
+    fn describe_fields(&self) -> RespValue {
+        let mut flags = vec![if self.enabled { "on" } else { "off" }];
+        if self.nopass {
+            flags.push("nopass");
+        }
+        let field = |name: &str, val: RespValue| (RespValue::BulkString(name.into()), val);
+        RespValue::Map(vec![
+            field(
+                "flags",
+                RespValue::bulk_string_array(flags.into_iter().map(Vec::from)),
+            ),
+            field(
+                "passwords",
+                RespValue::bulk_string_array(
+                    self.passwords.iter().map(|hash| hash.clone().into_bytes()),
+                ),
+            ),
+            field(
+                "commands",
+                RespValue::BulkString(self.command_rules.clone().into_bytes()),
+            ),
+            field(
+                "keys",
+                RespValue::BulkString(self.key_patterns_rules().join(" ").into_bytes()),
+            ),
+        ])
+    }
+}
+
+/// The users, by name
+pub struct Acl {
+    /// Every user, by name; the default user is always there
+    users: BTreeMap<String, User>,
+}
+
+impl Acl {
+    /// Create the ACL with only the default user, whose password is `requirepass` if it isn't empty
+    pub fn new(requirepass: &str) -> Self {
+        let mut acl = Self {
+            users: BTreeMap::from([(DEFAULT_USER.to_owned(), User::default_user())]),
+        };
+        acl.set_requirepass(requirepass);
+        acl
+    }
+
+    /// Make `requirepass` the only password of the default user, or let it go without a password if it is empty
+    pub fn set_requirepass(&mut self, requirepass: &str) {
+        let default_user = self.users.get_mut(DEFAULT_USER).unwrap();
+        let rule = if requirepass.is_empty() {
+            "nopass".to_owned()
+        } else {
+            format!(">{requirepass}")
+        };
+        default_user.apply("resetpass").unwrap();
+        default_user.apply(&rule).unwrap();
+    }
+
+    /// Whether new connections are authenticated as the default user right away, as it needs no password
+    pub fn authenticates_by_default(&self) -> bool {
+        let default_user = &self.users[DEFAULT_USER];
+        default_user.enabled && default_user.nopass
+    }
+
+    /// Whether the default user needs no password, in which case AUTH without a user name makes no sense
+    pub fn default_user_has_nopass(&self) -> bool {
+        self.users[DEFAULT_USER].nopass
+    }
+
+    /// Check whether a client may authenticate as the user with the password
+    pub fn authenticate(&self, user_name: &str, password: &[u8]) -> bool {
+        self.users.get(user_name).is_some_and(|user| {
+            user.enabled && (user.nopass || user.passwords.contains(&sha256::hex_digest(password)))
+        })
+    }
+
+    /// Check that the user may run the command on its keys, returning the NOPERM error if it may not
+    pub fn check(
+        &self,
+        user_name: &str,
+        command: &CommandSpec,
+        parsed_command: &[Vec<u8>],
+    ) -> Result<(), String> {
+        let name = command.name();
+        let Some(user) = self
+            .users
+            .get(user_name)
+            .filter(|user| user.allowed_commands.contains(name))
+        else {
+            return Err(format!(
+                "NOPERM User {user_name} has no permissions to run the '{name}' command"
+            ));
+        };
+        let is_accessible = |key: &[u8]| {
+            user.key_patterns
+                .iter()
+                .any(|pattern| glob::matches(pattern, key))
+        };
+        if command.keys(parsed_command).into_iter().all(is_accessible) {
+            Ok(())
+        } else {
+            Err("NOPERM No permissions to access a key".to_owned())
+        }
+    }
+
+    /// Create the user or change its permissions with the rules of ACL SETUSER; either all of the rules are applied
+    /// or none is
+    fn set_user(&mut self, name: &str, rules: &[Vec<u8>]) -> Result<(), String> {
+        let mut user = self.users.get(name).cloned().unwrap_or_else(User::new);
+        for rule in rules {
+            let rule = String::from_utf8_lossy(rule);
+            user.apply(&rule)
+                .map_err(|err| format!("ERR Error in ACL SETUSER modifier '{rule}': {err}"))?;
+        }
+        self.users.insert(name.to_owned(), user);
+        Ok(())
+    }
+}
+
+/// Lines of ACL HELP
+const HELP: &[&str] = &[
+    "DELUSER <username> [<username> ...]",
+    "    Delete a list of users.",
+    "GETUSER <username>",
+    "    Get the user's details.",
+    "LIST",
+    "    Show users details in config file format.",
+    "SETUSER <username> <attribute> [<attribute> ...]",
+    "    Create or modify a user with the specified attributes.",
+    "USERS",
+    "    List all the registered usernames.",
+    "WHOAMI",
+    "    Return the current connection username.",
+];
+
+/// Compute output of the ACL WHOAMI/LIST/USERS/SETUSER/GETUSER/DELUSER/HELP subcommands, for a client authenticated as
+/// `user_name`
+/// The clients authenticated as a deleted user are disconnected.
+pub fn acl(
+    acl: &Mutex<Acl>,
+    clients: &Mutex<Clients>,
+    user_name: &str,
+    parsed_command: &[Vec<u8>],
+) -> RespValue {
+    let subcommand = String::from_utf8_lossy(&parsed_command[1]).to_lowercase();
+    let args = &parsed_command[2..];
+    match subcommand.as_str() {
+        "whoami" if args.is_empty() => RespValue::BulkString(user_name.into()),
+        "list" if args.is_empty() => {
+            let acl = acl.lock().unwrap();
+            let lines = acl
+                .users
+                .iter()
+                .map(|(name, user)| user.describe(name).into_bytes())
+                .collect::<Vec<_>>();
+            drop(acl);
+            RespValue::bulk_string_array(lines)
+        }
+        "users" if args.is_empty() => {
+            let acl = acl.lock().unwrap();
+            let names = acl
+                .users
+                .keys()
+                .map(|name| name.clone().into_bytes())
+                .collect::<Vec<_>>();
+            drop(acl);
+            RespValue::bulk_string_array(names)
+        }
+        "setuser" if !args.is_empty() => {
+            let name = String::from_utf8_lossy(&args[0]);
+            let result = acl.lock().unwrap().set_user(&name, &args[1..]);
+            match result {
+                Ok(()) => RespValue::simple("OK"),
+                Err(err) => RespValue::Error(err),
+            }
+        }
+        "getuser" if args.len() == 1 => acl
+            .lock()
+            .unwrap()
+            .users
+            .get(String::from_utf8_lossy(&args[0]).as_ref())
+            .map_or(RespValue::NullBulkString, User::describe_fields),
+        "deluser" if !args.is_empty() => {
+            let names: Vec<String> = args
+                .iter()
+                .map(|name| String::from_utf8_lossy(name).into_owned())
+                .collect();
+            if names.iter().any(|name| name == DEFAULT_USER) {
+                return RespValue::error("ERR The 'default' user cannot be removed");
+            }
+            let mut acl = acl.lock().unwrap();
+            let deleted: Vec<&String> = names
+                .iter()
+                .filter(|&name| acl.users.remove(name).is_some())
+                .collect();
+            drop(acl);
+            let mut clients = clients.lock().unwrap();
+            for name in &deleted {
+                clients.kill_authenticated_as(name);
+            }
+            drop(clients);
+            RespValue::Integer(i64::try_from(deleted.len()).unwrap())
+        }
+        "help" if args.is_empty() => RespValue::help("ACL", HELP),
+        "whoami" | "list" | "users" | "setuser" | "getuser" | "deluser" | "help" => {
+            command::wrong_arity(&format!("acl|{subcommand}"))
+        }
+        _ => RespValue::Error(format!(
+            "ERR unknown subcommand '{}'. Try ACL HELP.",
+            String::from_utf8_lossy(&parsed_command[1])
+        )),
+    }
+}
@@ -0,0 +1,611 @@
+//! AOF persistence, i.e. a log of the write commands in RESP which is replayed on startup
+//! Commands whose effect depends on the current time are logged with absolute times instead, so that
+//! replaying them later has the same effect. The database of the commands is switched by SELECT, which is logged
+//! whenever the next command runs against another database than the previous one. BGREWRITEAOF compacts the log into
+//! the commands recreating the keyspace.
+
+use std::{
+    fs::{self, File, OpenOptions},
+    io::{self, Write as _},
+    path::{Path, PathBuf},
+    process,
+    sync::{
+        atomic::{AtomicBool, Ordering},
+        Arc, Mutex,
+    },
+    time::{Duration, SystemTime},
+};
+
+use thiserror::Error;
+use tokio::{sync::watch, task, time};
+
+use crate::{
+    command,
+    config::AppendFsync,
+    consumer_group::ConsumerGroup,
+    parse_redis_int,
+    resp::{encode_command, RespError, RespReader},
+    store::{self, Entry, KeyValStore, RedisType},
+    stream::{Stream, StreamId},
+    unix_time_ms,
+};
+
+/// Maximum number of elements added by a single command of a rewritten AOF (same as Redis)
+const REWRITE_ITEMS_PER_COMMAND: usize = 64;
+/// Interval between two flushes of the AOF to the disk with `appendfsync everysec`
+const FSYNC_INTERVAL: Duration = Duration::from_secs(1);
+
+/// A write command along with the index of the database it ran against, as propagated to the AOF and the replicas
+pub type PropagatedCommand = (usize, Vec<Vec<u8>>);
+
+/// Whether a rewrite of the AOF is running; only one may run at a time
+static REWRITE_IN_PROGRESS: AtomicBool = AtomicBool::new(false);
+
+/// Errors which may occur while loading an AOF
+#[derive(Debug, Error)]
+pub enum AofError {
+    /// The file isn't a sequence of commands in RESP
+    #[error("invalid RESP in the AOF: {0}")]
+    Resp(#[from] RespError),
+    /// The file contains a command which isn't supported or has a wrong number of arguments
+    #[error("invalid command '{0}' in the AOF")]
+    InvalidCommand(String),
+    /// Error while reading the file
+    #[error(transparent)]
+    Io(#[from] io::Error),
+}
+
+/// The AOF to which the write commands are appended
+pub struct Aof {
+    /// The file, positioned at its end
+    file: File,
+    /// How often the file is flushed to the disk
+    fsync: AppendFsync,
+    /// Database which the commands appended so far leave selected, if known
+    selected_db: Option<usize>,
+    /// Commands appended since a rewrite started, which must be appended to the rewritten file as well
+    rewrite_buffer: Option<Vec<u8>>,
+    /// Replication offset right after the last commands appended, which WAITAOF compares to that of its client
+    appended_offset: u64,
+    /// Replication offset up to which the appended commands are flushed to the disk
+    fsynced_offset: watch::Sender<u64>,
+}
+
+impl Aof {
+    /// Open the AOF for appending, creating it if it doesn't exist; its commands leave the given database selected
+    pub fn open(path: &Path, fsync: AppendFsync, selected_db: usize) -> io::Result<Self> {
+        Ok(Self {
+            file: OpenOptions::new().create(true).append(true).open(path)?,
+            fsync,
+            selected_db: Some(selected_db),
+            rewrite_buffer: None,
+            appended_offset: 0,
+            fsynced_offset: watch::channel(0).0,
+        })
+    }
+
+    /// Append the commands, which end at the given replication offset, to the file, flushing it to the disk right
+    /// away with `appendfsync always`
+    pub fn append(&mut self, commands: &[PropagatedCommand], offset: u64) -> io::Result<()> {
+        let bytes = encode_propagated(commands, &mut self.selected_db);
+        if let Some(ref mut rewrite_buffer) = self.rewrite_buffer {
+            rewrite_buffer.extend_from_slice(&bytes);
+        }
+
+        self.file.write_all(&bytes)?;
+        self.appended_offset = offset;
+        if self.fsync == AppendFsync::Always {
+            self.sync()?;
+        }
+        Ok(())
+    }
+
+    /// Flush the appended commands to the disk, whatever `appendfsync` is
+    pub fn sync(&self) -> io::Result<()> {
+        self.file.sync_data()?;
+        self.fsynced_offset.send_replace(self.appended_offset);
+        Ok(())
+    }
+
+    /// Replication offset up to which the appended commands are flushed to the disk
+    pub fn fsynced_offset(&self) -> u64 {
+        *self.fsynced_offset.borrow()
+    }
+
+    /// Watch the replication offset up to which the appended commands are flushed to the disk
+    pub fn watch_fsynced_offset(&self) -> watch::Receiver<u64> {
+        self.fsynced_offset.subscribe()
+    }
+}
+
+/// Encode the commands in RESP, preceding each of them by a SELECT of its database unless that is already selected
+/// The replication stream is encoded the same way.
+pub fn encode_propagated(
+    commands: &[PropagatedCommand],
+    selected_db: &mut Option<usize>,
+) -> Vec<u8> {
+    let mut bytes = Vec::new();
+    for &(db, ref parsed_command) in commands {
+        if *selected_db != Some(db) {
+            bytes.extend(encode_command(&[
+                b"SELECT".to_vec(),
+                db.to_string().into_bytes(),
+            ]));
+            *selected_db = Some(db);
+        }
+        bytes.extend(encode_command(parsed_command));
+    }
+    bytes
+}
+
+/// Absolute time in milliseconds for a time in the given unit, relative to the given base time
+fn absolute_ms(time: &[u8], unit_ms: i64, base_ms: i64) -> Option<i64> {
+    parse_redis_int(time)?
+        .checked_mul(unit_ms)?
+        .checked_add(base_ms)
+}
+
+/// Rewrite a command which succeeded to use absolute times instead of times relative to now, so that it has
+/// the same effect whenever it is replayed; other commands are returned as they are
+/// EXPIRE/PEXPIRE/EXPIREAT become PEXPIREAT and the `EX`/`PX`/`EXAT` options of SET become `PXAT`, same as Redis;
+/// SETEX/PSETEX become SET with `PXAT`, GETEX becomes PEXPIREAT or PERSIST, and RESTORE gets `ABSTTL`.
+pub fn with_absolute_time(parsed_command: &[Vec<u8>]) -> Vec<Vec<u8>> {
+    let now_ms = unix_time_ms(SystemTime::now());
+    let name = String::from_utf8_lossy(&parsed_command[0]).to_lowercase();
+
+    match name.as_str() {
+        "expire" | "pexpire" | "expireat" => {
+            let (unit_ms, base_ms) = match name.as_str() {
+                "expire" => (1000, now_ms),
+                "pexpire" => (1, now_ms),
+                _ => (1000, 0),
+            };
+            let Some(expires_at_ms) = absolute_ms(&parsed_command[2], unit_ms, base_ms) else {
+                return parsed_command.to_vec();
+            };
+            let mut absolute_command = vec![
+                b"PEXPIREAT".to_vec(),
+                parsed_command[1].clone(),
+                expires_at_ms.to_string().into_bytes(),
+            ];
+            absolute_command.extend_from_slice(&parsed_command[3..]);
+            absolute_command
+        }
+        "set" => {
+            let mut absolute_command = parsed_command[..3].to_vec();
+            let mut options = parsed_command[3..].iter();
+            while let Some(option) = options.next() {
+                let (unit_ms, base_ms) =
+                    match String::from_utf8_lossy(option).to_lowercase().as_str() {
+                        "ex" => (1000, now_ms),
+                        "px" => (1, now_ms),
+                        "exat" => (1000, 0),
+                        _ => {
+                            absolute_command.push(option.clone());
+                            continue;
+                        }
+                    };
+                let Some(expires_at_ms) = options
+                    .next()
+                    .and_then(|time| absolute_ms(time, unit_ms, base_ms))
+                else {
+                    return parsed_command.to_vec();
+                };
+                absolute_command.push(b"PXAT".to_vec());
+                absolute_command.push(expires_at_ms.to_string().into_bytes());
+            }
+            absolute_command
+        }
+        "setex" | "psetex" => {
+            let unit_ms = if name == "setex" { 1000 } else { 1 };
+            let Some(expires_at_ms) = absolute_ms(&parsed_command[2], unit_ms, now_ms) else {
+                return parsed_command.to_vec();
+            };
+            vec![
+                b"SET".to_vec(),
+                parsed_command[1].clone(),
+                parsed_command[3].clone(),
+                b"PXAT".to_vec(),
+                expires_at_ms.to_string().into_bytes(),
+            ]
+        }
+        // Only the effect on the TTL needs replaying, same as Redis
+        "getex" if parsed_command.len() == 4 => {
+            let (unit_ms, base_ms) = match String::from_utf8_lossy(&parsed_command[2])
+                .to_lowercase()
+                .as_str()
+            {
+                "ex" => (1000, now_ms),
+                "px" => (1, now_ms),
+                "exat" => (1000, 0),
+                _ => (1, 0),
+            };
+            let Some(expires_at_ms) = absolute_ms(&parsed_command[3], unit_ms, base_ms) else {
+                return parsed_command.to_vec();
+            };
+            vec![
+                b"PEXPIREAT".to_vec(),
+                parsed_command[1].clone(),
+                expires_at_ms.to_string().into_bytes(),
+            ]
+        }
+        "getex" if parsed_command.len() == 3 => {
+            vec![b"PERSIST".to_vec(), parsed_command[1].clone()]
+        }
+        "restore"
+            if parsed_command[2] != b"0"
+                && !parsed_command[4..]
+                    .iter()
+                    .any(|option| option.eq_ignore_ascii_case(b"absttl")) =>
+        {
+            let Some(expires_at_ms) = absolute_ms(&parsed_command[2], 1, now_ms) else {
+                return parsed_command.to_vec();
+            };
+            let mut absolute_command = parsed_command.to_vec();
+            absolute_command[2] = expires_at_ms.to_string().into_bytes();
+            absolute_command.push(b"ABSTTL".to_vec());
+            absolute_command
+        }
+        _ => parsed_command.to_vec(),
+    }
+}
+
+/// Append commands adding the items to the key, with at most `REWRITE_ITEMS_PER_COMMAND` items per command
+/// Every item is made up of one or more arguments, e.g. a field and its value.
+fn write_batched(
+    out: &mut Vec<u8>,
+    name: &[u8],
+    key: &[u8],
+    items: impl IntoIterator<Item = Vec<Vec<u8>>>,
+) {
+    let mut items = items.into_iter().peekable();
+    while items.peek().is_some() {
+        let mut parsed_command = vec![name.to_vec(), key.to_vec()];
+        for item in items.by_ref().take(REWRITE_ITEMS_PER_COMMAND) {
+            parsed_command.extend(item);
+        }
+        out.extend(encode_command(&parsed_command));
+    }
+}
+
+/// Serialize the fewest commands which recreate the entries of every database, as the contents of a rewritten AOF,
+/// returning them along with the database they leave selected
+/// The database of the replayed commands is the first one until selected otherwise.
+fn rewrite_commands(databases: &[Vec<Entry>]) -> (Vec<u8>, usize) {
+    let mut out = Vec::new();
+    let mut selected_db = 0;
+    for (db, entries) in databases.iter().enumerate() {
+        if entries.is_empty() {
+            continue;
+        }
+        if db != selected_db {
+            out.extend(encode_command(&[
+                b"SELECT".to_vec(),
+                db.to_string().into_bytes(),
+            ]));
+            selected_db = db;
+        }
+        write_entries(&mut out, entries);
+    }
+    (out, selected_db)
+}
+
+/// Serialize the commands which recreate the entries of a database
+fn write_entries(out: &mut Vec<u8>, entries: &[Entry]) {
+    for &(ref key, ref value, expires_at) in entries {
+        match *value {
+            RedisType::Val(ref val) => {
+                out.extend(encode_command(&[b"SET".to_vec(), key.clone(), val.clone()]));
+            }
+            RedisType::List(ref list) => write_batched(
+                out,
+                b"RPUSH",
+                key,
+                list.iter().map(|element| vec![element.clone()]),
+            ),
+            RedisType::Hash(ref hash) => write_batched(
+                out,
+                b"HSET",
+                key,
+                hash.iter()
+                    .map(|(field, val)| vec![field.clone(), val.clone()]),
+            ),
+            RedisType::Set(ref set) => write_batched(
+                out,
+                b"SADD",
+                key,
+                set.iter().map(|member| vec![member.clone()]),
+            ),
+            RedisType::SortedSet(ref sorted_set) => write_batched(
+                out,
+                b"ZADD",
+                key,
+                sorted_set
+                    .iter()
+                    .map(|pair| vec![pair.0.to_string().into_bytes(), pair.1.clone()]),
+            ),
+            RedisType::Stream(ref stream) => write_stream(out, key, stream),
+        }
+        if let Some(expires_at) = expires_at {
+            out.extend(encode_command(&[
+                b"PEXPIREAT".to_vec(),
+                key.clone(),
+                unix_time_ms(expires_at).to_string().into_bytes(),
+            ]));
+        }
+    }
+}
+
+/// Write the commands recreating the stream at the key, its entries, its IDs and its consumer groups
+/// XADD adds a single entry, so every entry takes a command of its own, along with its ID. XSETID then restores the
+/// ID of the last entry ever added, which entries deleted since may have had, along with the number of entries ever
+/// added and the greatest deleted ID.
+fn write_stream(out: &mut Vec<u8>, key: &[u8], stream: &Stream) {
+    for (id, fields) in stream.iter() {
+        let mut command = vec![b"XADD".to_vec(), key.to_vec(), id.to_bytes()];
+        command.extend(
+            fields
+                .iter()
+                .flat_map(|pair| [pair.0.clone(), pair.1.clone()]),
+        );
+        out.extend(encode_command(&command));
+    }
+    let last_id = stream.last_id();
+    let is_created = stream.len() > 0 || last_id > StreamId::MIN;
+    if stream.len() == 0 && is_created {
+        // An empty stream is created by adding an entry which is trimmed right away, same as Redis
+        out.extend(encode_command(&[
+            b"XADD".to_vec(),
+            key.to_vec(),
+            b"MAXLEN".to_vec(),
+            b"0".to_vec(),
+            last_id.to_bytes(),
+            b"x".to_vec(),
+            b"y".to_vec(),
+        ]));
+    }
+    if is_created {
+        out.extend(encode_command(&[
+            b"XSETID".to_vec(),
+            key.to_vec(),
+            last_id.to_bytes(),
+            b"ENTRIESADDED".to_vec(),
+            stream.entries_added().to_string().into_bytes(),
+            b"MAXDELETEDID".to_vec(),
+            stream.max_deleted_id().to_bytes(),
+        ]));
+    }
+    for (name, group) in stream.groups() {
+        write_group(out, key, name, group, is_created);
+    }
+}
+
+/// Write the commands recreating the consumer group of the stream at the key, its consumers and their pending
+/// entries, after those recreating the stream, which is created by the group if it wasn't already
+fn write_group(
+    out: &mut Vec<u8>,
+    key: &[u8],
+    name: &[u8],
+    group: &ConsumerGroup,
+    is_stream_created: bool,
+) {
+    let mut create = vec![
+        b"XGROUP".to_vec(),
+        b"CREATE".to_vec(),
+        key.to_vec(),
+        name.to_vec(),
+        group.last_delivered_id().to_bytes(),
+    ];
+    if !is_stream_created {
+        create.push(b"MKSTREAM".to_vec());
+    }
+    out.extend(encode_command(&create));
+    for (consumer_name, consumer) in group.consumers() {
+        out.extend(encode_command(&[
+            b"XGROUP".to_vec(),
+            b"CREATECONSUMER".to_vec(),
+            key.to_vec(),
+            name.to_vec(),
+            consumer_name.clone(),
+        ]));
+        for id in &consumer.pending {
+            let pending_entry = &group.pending()[id];
+            out.extend(encode_command(&[
+                b"XCLAIM".to_vec(),
+                key.to_vec(),
+                name.to_vec(),
+                consumer_name.clone(),
+                b"0".to_vec(),
+                id.to_bytes(),
+                b"TIME".to_vec(),
+                pending_entry.delivery_time_ms.to_string().into_bytes(),
+                b"RETRYCOUNT".to_vec(),
+                pending_entry.delivery_count.to_string().into_bytes(),
+                b"JUSTID".to_vec(),
+                b"FORCE".to_vec(),
+            ]));
+        }
+    }
+}
+
+/// Write the commands recreating the entries of every database to a temporary file next to the AOF, returning the
+/// file and its path along with the database the commands leave selected
+fn write_temp(path: &Path, databases: &[Vec<Entry>]) -> io::Result<(File, PathBuf, usize)> {
+    let temp_path = path.with_file_name(format!("temp-rewriteaof-{}.aof", process::id()));
+    let mut file = File::create(&temp_path)?;
+    let (commands, selected_db) = rewrite_commands(databases);
+    file.write_all(&commands)?;
+    Ok((file, temp_path, selected_db))
+}
+
+/// Replace the AOF with the commands recreating the entries of every database, waiting until it is written
+/// Returns the database which the commands leave selected.
+pub fn rewrite(path: &Path, databases: &[Vec<Entry>]) -> io::Result<usize> {
+    let (file, temp_path, selected_db) = write_temp(path, databases)?;
+    file.sync_data()?;
+    fs::rename(&temp_path, path)?;
+    Ok(selected_db)
+}
+
+/// Finish a rewrite in the background: write the snapshot, then the commands appended in the meantime,
+/// and replace the AOF with the result
+fn rewrite_in_background(
+    aof: &Mutex<Option<Aof>>,
+    path: &Path,
+    databases: &[Vec<Entry>],
+) -> io::Result<()> {
+    let (mut file, temp_path, _) = write_temp(path, databases)?;
+
+    // Appends wait until the rewritten file replaces the AOF, so that none of them is lost
+    let mut aof = aof.lock().unwrap();
+    if let Some(rewrite_buffer) = aof.as_mut().and_then(|aof| aof.rewrite_buffer.take()) {
+        file.write_all(&rewrite_buffer)?;
+    }
+    file.sync_data()?;
+    fs::rename(&temp_path, path)?;
+    // The file is already positioned at its end, so later commands are appended to it
+    if let Some(ref mut aof) = *aof {
+        aof.file = file;
+        aof.fsynced_offset.send_replace(aof.appended_offset);
+    }
+    drop(aof);
+    Ok(())
+}
+
+/// BGREWRITEAOF: rewrite the AOF in the background as the commands recreating the current keyspace
+/// Commands appended in the meantime are buffered, so that they end up in the rewritten file as well.
+/// This works when the AOF is disabled too, in which case the file is just written once.
+pub fn bgrewriteaof(
+    aof: &Arc<Mutex<Option<Aof>>>,
+    databases: &[Arc<Mutex<KeyValStore>>],
+    path: PathBuf,
+) -> Result<(), &'static str> {
+    if REWRITE_IN_PROGRESS.swap(true, Ordering::AcqRel) {
+        return Err("ERR Background append only file rewriting already in progress");
+    }
+
+    // The buffer starts along with the snapshot, so that every write ends up in exactly one of them
+    let mut aof_guard = aof.lock().unwrap();
+    if let Some(ref mut aof) = *aof_guard {
+        aof.rewrite_buffer = Some(Vec::new());
+        // The buffer is appended to the snapshot, so it must start by selecting its database
+        aof.selected_db = None;
+    }
+    let snapshot = store::snapshot_databases(databases);
+    drop(aof_guard);
+
+    let aof = Arc::clone(aof);
+    task::spawn_blocking(move || {
+        if let Err(err) = rewrite_in_background(&aof, &path, &snapshot) {
+            eprintln!("error: rewriting the AOF failed: {err}");
+            if let Some(ref mut aof) = *aof.lock().unwrap() {
+                aof.rewrite_buffer = None;
+            }
+        }
+        REWRITE_IN_PROGRESS.store(false, Ordering::Release);
+    });
+    Ok(())
+}
+
+/// Flush the AOF to the disk without holding up the appends, returning false if it is disabled
+/// The file is flushed through a duplicate handle, so only the commands appended until then are known to be flushed.
+pub async fn flush(aof: &Mutex<Option<Aof>>) -> io::Result<bool> {
+    let Some((file, offset)) = aof
+        .lock()
+        .unwrap()
+        .as_ref()
+        .map(|aof| (aof.file.try_clone(), aof.appended_offset))
+    else {
+        return Ok(false);
+    };
+    let file = file?;
+    task::spawn_blocking(move || file.sync_data())
+        .await
+        .unwrap()?;
+    if let Some(ref aof) = *aof.lock().unwrap() {
+        aof.fsynced_offset.send_if_modified(|fsynced_offset| {
+            let is_advanced = offset > *fsynced_offset;
+            if is_advanced {
+                *fsynced_offset = offset;
+            }
+            is_advanced
+        });
+    }
+    Ok(true)
+}
+
+/// Flush the AOF to the disk every second for `appendfsync everysec`, until a shutdown is signalled
+pub async fn fsync_every_second(aof: Arc<Mutex<Option<Aof>>>, mut shutdown: watch::Receiver<()>) {
+    let mut interval = time::interval(FSYNC_INTERVAL);
+    loop {
+        tokio::select! {
+            _ = interval.tick() => {}
+            _ = shutdown.changed() => return,
+        }
+
+        if let Err(err) = flush(&aof).await {
+            eprintln!("error: flushing the AOF failed: {err}");
+        }
+    }
+}
+
+/// Wait until the commands of the AOF up to the replication offset are flushed to the disk, or the timeout elapses,
+/// returning whether they are; they never are if the AOF is disabled
+/// With `appendfsync no` only a rewrite or a shutdown flushes them.
+pub async fn wait_for_fsync(
+    aof: &Mutex<Option<Aof>>,
+    offset: u64,
+    timeout: Option<Duration>,
+) -> bool {
+    let Some(mut fsynced_offset) = aof.lock().unwrap().as_ref().map(Aof::watch_fsynced_offset)
+    else {
+        return false;
+    };
+    let fsynced = fsynced_offset.wait_for(|&fsynced_offset| fsynced_offset >= offset);
+    match timeout {
+        Some(timeout) => matches!(time::timeout(timeout, fsynced).await, Ok(Ok(_))),
+        None => fsynced.await.is_ok(),
+    }
+}
+
+/// Read the commands to be replayed from the AOF, if it exists, along with whether its end was truncated
+/// The end is truncated when the server stopped in the middle of an append; the incomplete command is skipped,
+/// as are the commands of a transaction whose EXEC is missing, so that a transaction is replayed in full or not at all.
+pub async fn read_commands(path: &Path) -> Result<Option<(Vec<Vec<Vec<u8>>>, bool)>, AofError> {
+    let bytes = match fs::read(path) {
+        Ok(bytes) => bytes,
+        Err(err) if err.kind() == io::ErrorKind::NotFound => return Ok(None),
+        Err(err) => return Err(err.into()),
+    };
+
+    let mut resp_reader = RespReader::new(bytes.as_slice());
+    let mut commands = Vec::new();
+    // Commands logged since MULTI, if a transaction is open
+    let mut transaction: Option<Vec<Vec<Vec<u8>>>> = None;
+    let is_truncated = loop {
+        let parsed_command = match resp_reader.read_command().await {
+            Ok(Some(parsed_command)) => parsed_command,
+            Ok(None) => break transaction.is_some(),
+            Err(RespError::UnexpectedEof) => break true,
+            Err(err) => return Err(err.into()),
+        };
+        if command::validate(&parsed_command).is_err() {
+            return Err(AofError::InvalidCommand(
+                String::from_utf8_lossy(&parsed_command[0]).into_owned(),
+            ));
+        }
+
+        match String::from_utf8_lossy(&parsed_command[0])
+            .to_lowercase()
+            .as_str()
+        {
+            "multi" => transaction = Some(Vec::new()),
+            "exec" => commands.extend(transaction.take().unwrap_or_default()),
+            _ => match transaction {
+                Some(ref mut transaction) => transaction.push(parsed_command),
+                None => commands.push(parsed_command),
+            },
+        }
+    };
+    Ok(Some((commands, is_truncated)))
+}
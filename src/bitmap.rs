@@ -0,0 +1,252 @@
+//! Commands on strings as arrays of bits, where bit 0 is the most significant bit of the first byte
+//! Offsets of SETBIT and GETBIT are in bits, the ranges of BITCOUNT and BITPOS in bytes unless `BIT` is given.
+
+use std::sync::{Arc, Mutex};
+
+use crate::{
+    command::WRONG_ARITY,
+    notify::EventClass,
+    parse_redis_int,
+    store::{KeyValStore, RedisType},
+    string::MAX_STRING_LEN,
+};
+
+/// Error of an offset which is negative, or past the maximum length of a string
+const INVALID_OFFSET: &str = "ERR bit offset is not an integer or out of range";
+
+/// Parse the offset of a bit, which must be within the maximum length of a string
+fn parse_offset(arg: &[u8]) -> Result<usize, &'static str> {
+    parse_redis_int(arg)
+        .and_then(|offset| usize::try_from(offset).ok())
+        .filter(|&offset| offset < MAX_STRING_LEN * 8)
+        .ok_or(INVALID_OFFSET)
+}
+
+/// Mask of the bits of the byte at the index which are within the inclusive range of bits
+fn range_mask(byte_index: usize, start_bit: usize, end_bit: usize) -> u8 {
+    let first = start_bit.saturating_sub(byte_index * 8);
+    let last = (end_bit - byte_index * 8).min(7);
+    (u8::MAX >> first) & (u8::MAX << (7 - last))
+}
+
+/// Parse the optional `start end [BYTE|BIT]` arguments into the inclusive range of bits of a string of the length,
+/// or None if the range is empty
+/// The offsets count from the end of the string when negative and are clamped to it, in bytes unless `BIT` is given.
+/// BITPOS may leave out the end, which is the end of the string then.
+fn parse_range(
+    args: &[Vec<u8>],
+    len: usize,
+    is_end_optional: bool,
+) -> Result<Option<(usize, usize)>, &'static str> {
+    let is_bit_unit = match *args {
+        [] | [_, _] => false,
+        [_] if is_end_optional => false,
+        [_, _, ref unit] if unit.eq_ignore_ascii_case(b"bit") => true,
+        [_, _, ref unit] if unit.eq_ignore_ascii_case(b"byte") => false,
+        _ => return Err("ERR syntax error"),
+    };
+    let total = i64::try_from(if is_bit_unit { len * 8 } else { len }).unwrap();
+    let mut offsets = args
+        .iter()
+        .take(2)
+        .map(|arg| parse_redis_int(arg).ok_or("ERR value is not an integer or out of range"));
+    let start = offsets.next().transpose()?.unwrap_or(0);
+    let end = offsets.next().transpose()?.unwrap_or(-1);
+
+    if total == 0 {
+        return Ok(None);
+    }
+    let start = if start < 0 { start + total } else { start }.max(0);
+    let end = if end < 0 { end + total } else { end }.clamp(0, total - 1);
+    if start > end {
+        return Ok(None);
+    }
+    let (start, end) = (
+        usize::try_from(start).unwrap(),
+        usize::try_from(end).unwrap(),
+    );
+    Ok(Some(if is_bit_unit {
+        (start, end)
+    } else {
+        (start * 8, end * 8 + 7)
+    }))
+}
+
+/// SETBIT: set or clear the bit, growing the string with zero bytes as needed, and return the bit it replaced
+/// A missing key is created as a string of zero bytes.
+pub fn setbit(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<u8, &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+    let offset = parse_offset(&parsed_command[2])?;
+    let is_set = match parsed_command[3].as_slice() {
+        b"0" => false,
+        b"1" => true,
+        _ => return Err("ERR bit is not an integer or out of range"),
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let val = store.get_or_insert_typed::<Vec<u8>>(&parsed_command[1])?;
+    let (byte_index, mask) = (offset / 8, 0x80 >> (offset % 8));
+    if val.len() <= byte_index {
+        val.resize(byte_index + 1, 0);
+    }
+    let old_bit = u8::from(val[byte_index] & mask != 0);
+    if is_set {
+        val[byte_index] |= mask;
+    } else {
+        val[byte_index] &= !mask;
+    }
+    store.notify(EventClass::String, "setbit", &parsed_command[1]);
+    drop(store);
+    Ok(old_bit)
+}
+
+/// GETBIT: get the bit, which is 0 past the end of the string or for a missing key
+pub fn getbit(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<u8, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(WRONG_ARITY);
+    }
+    let offset = parse_offset(&parsed_command[2])?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let bit = store
+        .get_typed::<Vec<u8>>(&parsed_command[1])?
+        .and_then(|val| val.get(offset / 8))
+        .map_or(0, |&byte| u8::from(byte & (0x80 >> (offset % 8)) != 0));
+    drop(store);
+    Ok(bit)
+}
+
+/// BITCOUNT: count the set bits of the string, or of the range of it
+pub fn bitcount(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let val = store
+        .get_typed::<Vec<u8>>(&parsed_command[1])?
+        .map_or(&[][..], Vec::as_slice);
+    let Some((start_bit, end_bit)) = parse_range(&parsed_command[2..], val.len(), false)? else {
+        return Ok(0);
+    };
+    let count = (start_bit / 8..=end_bit / 8)
+        .map(|byte_index| {
+            (val[byte_index] & range_mask(byte_index, start_bit, end_bit)).count_ones() as usize
+        })
+        .sum();
+    drop(store);
+    Ok(count)
+}
+
+/// BITPOS: find the position of the first bit set to the given value in the string, or in the range of it, or -1
+/// if there is none
+/// Without an end, the string is considered padded with zero bytes when looking for a clear bit, so the position
+/// past the end of the string is returned if all its bits are set, same as Redis.
+pub fn bitpos(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<i64, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+    let is_set = match parsed_command[2].as_slice() {
+        b"0" => false,
+        b"1" => true,
+        _ => return Err("ERR The bit argument must be 1 or 0."),
+    };
+    let is_end_given = parsed_command.len() > 4;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(val) = store.get_typed::<Vec<u8>>(&parsed_command[1])? else {
+        // The bits of a missing key are all clear
+        parse_range(&parsed_command[3..], 0, true)?;
+        return Ok(if is_set { -1 } else { 0 });
+    };
+    let Some((start_bit, end_bit)) = parse_range(&parsed_command[3..], val.len(), true)? else {
+        return Ok(-1);
+    };
+    let position = (start_bit / 8..=end_bit / 8).find_map(|byte_index| {
+        let byte = if is_set {
+            val[byte_index]
+        } else {
+            !val[byte_index]
+        };
+        let matching_bits = byte & range_mask(byte_index, start_bit, end_bit);
+        (matching_bits != 0).then(|| byte_index * 8 + matching_bits.leading_zeros() as usize)
+    });
+    drop(store);
+    Ok(match position {
+        Some(position) => i64::try_from(position).unwrap(),
+        None if !is_set && !is_end_given => i64::try_from(end_bit + 1).unwrap(),
+        None => -1,
+    })
+}
+
+/// BITOP: store the bitwise AND/OR/XOR of the strings, or the bitwise NOT of a single string, at the destination
+/// key and return its length
+/// The strings shorter than the longest one are padded with zero bytes, and missing keys are empty strings. An
+/// empty result removes the destination key instead.
+pub fn bitop(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 4 {
+        return Err(WRONG_ARITY);
+    }
+    let operation = String::from_utf8_lossy(&parsed_command[1]).to_lowercase();
+    // NOT has no operator, as it only takes a single string
+    let operator: Option<fn(u8, u8) -> u8> = match operation.as_str() {
+        "and" => Some(|a, b| a & b),
+        "or" => Some(|a, b| a | b),
+        "xor" => Some(|a, b| a ^ b),
+        "not" if parsed_command.len() != 4 => {
+            return Err("ERR BITOP NOT must be called with a single source key.")
+        }
+        "not" => None,
+        _ => return Err("ERR syntax error"),
+    };
+    let destination = &parsed_command[2];
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let mut sources = Vec::with_capacity(parsed_command.len() - 3);
+    for key in &parsed_command[3..] {
+        sources.push(
+            store
+                .get_typed::<Vec<u8>>(key)?
+                .cloned()
+                .unwrap_or_default(),
+        );
+    }
+    let len = sources.iter().map(Vec::len).max().unwrap_or(0);
+    let result: Vec<u8> = (0..len)
+        .map(|index| {
+            let mut bytes = sources
+                .iter()
+                .map(|source| source.get(index).copied().unwrap_or(0));
+            let first = bytes.next().unwrap_or(0);
+            operator.map_or(!first, |operator| bytes.fold(first, operator))
+        })
+        .collect();
+
+    if result.is_empty() {
+        if store.remove(destination).is_some() {
+            store.notify(EventClass::Generic, "del", destination);
+        }
+    } else {
+        store.insert(destination.clone(), RedisType::Val(result), None);
+        store.notify(EventClass::String, "set", destination);
+    }
+    drop(store);
+    Ok(len)
+}
@@ -0,0 +1,217 @@
+//! Clients blocked on keys (e.g. by BLPOP, BZPOPMIN or XREAD) until another client adds elements to one of the keys
+
+use std::{
+    collections::{HashMap, VecDeque},
+    mem,
+    sync::{Arc, Mutex},
+};
+
+use tokio::sync::oneshot;
+
+use crate::{
+    notify::EventClass,
+    store::{KeyValStore, ListEnd},
+    zset::SortedSetEnd,
+};
+
+/// Element handed over to a blocked client, along with the key it was popped from and its score if it was popped
+/// from a sorted set
+pub type Handoff = (Vec<u8>, Vec<u8>, Option<f64>);
+
+/// A key along with the index of its database
+type DbKey = (usize, Vec<u8>);
+
+/// How a blocked client pops the element it waits for, which also tells the type of value it waits on
+#[derive(Clone, Copy)]
+pub enum Pop {
+    /// From the end of a list, by BLPOP/BRPOP/BLMOVE
+    List(ListEnd),
+    /// The member with the lowest or highest score of a sorted set, by BZPOPMIN/BZPOPMAX
+    SortedSet(SortedSetEnd),
+    /// Nothing, as XREAD leaves the entries of a stream in place and reads the new ones once served
+    Stream,
+}
+
+impl Pop {
+    /// Record the keyspace event of a pop from the value at the key
+    pub fn notify(self, store: &mut KeyValStore, key: &[u8]) {
+        match self {
+            Self::List(end) => store.notify(EventClass::List, end.pop_event(), key),
+            Self::SortedSet(end) => store.notify(EventClass::SortedSet, end.pop_event(), key),
+            // Reading doesn't change the stream
+            Self::Stream => {}
+        }
+    }
+}
+
+/// Value whose elements can be handed over to blocked clients
+pub trait Poppable {
+    /// Whether there is no element left
+    fn is_empty(&self) -> bool;
+
+    /// Pop an element along with its score the way given, or None if the value is popped differently
+    fn pop_for(&mut self, pop: Pop) -> Option<(Vec<u8>, Option<f64>)>;
+
+    /// Put back an element popped the way given, as if it was never popped
+    fn put_back(&mut self, pop: Pop, val: Vec<u8>, score: Option<f64>);
+}
+
+impl Poppable for VecDeque<Vec<u8>> {
+    fn is_empty(&self) -> bool {
+        Self::is_empty(self)
+    }
+
+    fn pop_for(&mut self, pop: Pop) -> Option<(Vec<u8>, Option<f64>)> {
+        match pop {
+            Pop::List(end) => end.pop(self).map(|val| (val, None)),
+            Pop::SortedSet(_) | Pop::Stream => None,
+        }
+    }
+
+    fn put_back(&mut self, pop: Pop, val: Vec<u8>, _score: Option<f64>) {
+        if let Pop::List(end) = pop {
+            end.push(self, val);
+        }
+    }
+}
+
+/// All the clients blocked on keys, which are told apart by the index of their database
+/// When both are needed, the key-val store must be locked before this, so that no push is missed
+/// between checking a key and blocking on it.
+#[derive(Default)]
+pub struct BlockedClients {
+    /// IDs of the clients blocked on every key of every database along with how they pop, in the order they
+    /// blocked
+    waiters: HashMap<DbKey, VecDeque<(u64, Pop)>>,
+    /// Channel of every blocked client; removed once the client is served, so that it is served only once
+    /// even if it is blocked on multiple keys
+    senders: HashMap<u64, oneshot::Sender<Handoff>>,
+    /// ID of the next blocked client
+    next_id: u64,
+    /// Databases and keys from which elements were handed over along with how they were popped, so that the pops
+    /// can be propagated after the command which caused them
+    handed_over: Vec<(usize, Vec<u8>, Pop)>,
+}
+
+impl BlockedClients {
+    /// Block a client on the keys of the database; it is served by the first key which receives an element
+    pub fn block(
+        blocked_clients: &Arc<Mutex<Self>>,
+        db: usize,
+        keys: &[Vec<u8>],
+        pop: Pop,
+    ) -> BlockedClient {
+        let (sender, receiver) = oneshot::channel();
+        let mut this = blocked_clients.lock().unwrap();
+        let id = this.next_id;
+        this.next_id += 1;
+        this.senders.insert(id, sender);
+        for key in keys {
+            this.waiters
+                .entry((db, key.clone()))
+                .or_default()
+                .push_back((id, pop));
+        }
+        drop(this);
+
+        BlockedClient {
+            blocked_clients: Arc::clone(blocked_clients),
+            id,
+            db,
+            keys: keys.to_vec(),
+            receiver,
+        }
+    }
+
+    /// Remove the client from all the keys of the database it is blocked on
+    fn unblock(&mut self, id: u64, db: usize, keys: &[Vec<u8>]) {
+        self.senders.remove(&id);
+        for key in keys {
+            let waited_key = (db, key.clone());
+            if let Some(waiters) = self.waiters.get_mut(&waited_key) {
+                waiters.retain(|&(waiter_id, _)| waiter_id != id);
+                if waiters.is_empty() {
+                    self.waiters.remove(&waited_key);
+                }
+            }
+        }
+    }
+
+    /// Keys of the database which clients are blocked on, in no particular order
+    pub fn waited_keys(&self, db: usize) -> Vec<Vec<u8>> {
+        self.waiters
+            .keys()
+            .filter(|waited_key| waited_key.0 == db)
+            .map(|waited_key| waited_key.1.clone())
+            .collect()
+    }
+
+    /// Hand over the elements of the value at the key of the database to the clients blocked on it, one element
+    /// per client in the order they blocked
+    /// Clients waiting for another type of value stay blocked. Returns how every element handed over was popped.
+    pub fn serve(&mut self, db: usize, key: &[u8], value: &mut impl Poppable) -> Vec<Pop> {
+        let mut served_pops = Vec::new();
+        let waited_key = (db, key.to_vec());
+        let Some(waiters) = self.waiters.get_mut(&waited_key) else {
+            return served_pops;
+        };
+
+        let mut index = 0;
+        while index < waiters.len() && !value.is_empty() {
+            let (id, pop) = waiters[index];
+            // The client may have been served through another key already
+            if !self.senders.contains_key(&id) {
+                waiters.remove(index);
+                continue;
+            }
+            let Some((val, score)) = value.pop_for(pop) else {
+                index += 1;
+                continue;
+            };
+            waiters.remove(index);
+            let sender = self.senders.remove(&id).unwrap();
+            // The client has timed out or disconnected in the meantime, so put the element back
+            match sender.send((key.to_vec(), val, score)) {
+                Ok(()) => {
+                    self.handed_over.push((db, key.to_vec(), pop));
+                    served_pops.push(pop);
+                }
+                Err((_, val, score)) => value.put_back(pop, val, score),
+            }
+        }
+
+        if waiters.is_empty() {
+            self.waiters.remove(&waited_key);
+        }
+        served_pops
+    }
+
+    /// Take the databases and keys from which elements were handed over since this was last called, along with
+    /// how they were popped
+    pub fn take_handed_over(&mut self) -> Vec<(usize, Vec<u8>, Pop)> {
+        mem::take(&mut self.handed_over)
+    }
+}
+
+/// A client blocked on some keys; it is unblocked from all of them when this is dropped
+pub struct BlockedClient {
+    /// Registry the client is blocked in
+    blocked_clients: Arc<Mutex<BlockedClients>>,
+    /// ID of the client in the registry
+    id: u64,
+    /// Database of the keys
+    db: usize,
+    /// Keys the client is blocked on
+    keys: Vec<Vec<u8>>,
+    /// Receives the element once a push to any of the keys serves the client
+    pub receiver: oneshot::Receiver<Handoff>,
+}
+
+impl Drop for BlockedClient {
+    fn drop(&mut self) {
+        self.blocked_clients
+            .lock()
+            .unwrap()
+            .unblock(self.id, self.db, &self.keys);
+    }
+}
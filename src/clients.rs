@@ -0,0 +1,516 @@
+//! Registry of the client connections, which CLIENT describes and kills
+//! Every connection is registered while it is served, until it closes or becomes the link of a replica.
+//! CLIENT PAUSE is kept here too, as it delays the commands of every connection.
+
+use std::{
+    collections::BTreeMap,
+    fmt::{self, Display, Write as _},
+    net::{IpAddr, SocketAddr},
+    path::PathBuf,
+    str,
+    sync::{Arc, Mutex},
+    time::{Duration, Instant},
+};
+
+use tokio::sync::{watch, Notify};
+
+use crate::{
+    acl, command, parse_redis_int,
+    pubsub::SubscriptionKind,
+    resp::{Protocol, RespValue},
+    transaction::Transaction,
+    ClientState,
+};
+
+/// Address of an end of a connection
+#[derive(Clone)]
+pub enum ClientAddr {
+    /// IP and port of a TCP connection
+    Tcp(SocketAddr),
+    /// Path of the Unix socket which the connection was accepted on, which both ends share
+    Unix(PathBuf),
+}
+
+impl ClientAddr {
+    /// IP address of a TCP connection
+    pub const fn ip(&self) -> Option<IpAddr> {
+        match *self {
+            Self::Tcp(addr) => Some(addr.ip()),
+            Self::Unix(_) => None,
+        }
+    }
+}
+
+/// Formatted like CLIENT LIST shows it, where the port of a Unix socket is 0, same as Redis
+impl Display for ClientAddr {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match *self {
+            Self::Tcp(addr) => write!(f, "{addr}"),
+            Self::Unix(ref path) => write!(f, "{}:0", path.display()),
+        }
+    }
+}
+
+/// A connection as described by CLIENT LIST
+struct ClientInfo {
+    /// Address of the client
+    addr: ClientAddr,
+    /// Address of the server which the client connected to
+    local_addr: ClientAddr,
+    /// Name given by CLIENT SETNAME, empty if it has none
+    name: Vec<u8>,
+    /// When the connection was accepted
+    created_at: Instant,
+    /// When the client last sent a command
+    last_interaction: Instant,
+    /// Full name of the last command of the client, e.g. `client|list`
+    last_command: String,
+    /// Index of the database selected by the client
+    db: usize,
+    /// Number of channels the client is subscribed to
+    channels: usize,
+    /// Number of patterns the client is subscribed to
+    patterns: usize,
+    /// Number of commands queued since MULTI, if a transaction is open
+    queued: Option<usize>,
+    /// Protocol version used for the replies to the client
+    protocol: Protocol,
+    /// Set by CLIENT NO-EVICT
+    no_evict: bool,
+    /// Name of the ACL user which the client is authenticated as
+    user: String,
+    /// Notified to close the connection, by CLIENT KILL
+    kill: Arc<Notify>,
+}
+
+impl ClientInfo {
+    /// Describe the connection on a line of CLIENT LIST
+    fn describe(&self, id: u64) -> String {
+        let mut flags = String::new();
+        if self.queued.is_some() {
+            flags.push('x');
+        }
+        if self.channels + self.patterns > 0 {
+            flags.push('P');
+        }
+        if self.no_evict {
+            flags.push('e');
+        }
+        if matches!(self.addr, ClientAddr::Unix(_)) {
+            flags.push('U');
+        }
+        if flags.is_empty() {
+            flags.push('N');
+        }
+        let multi = self
+            .queued
+            .map_or_else(|| "-1".to_owned(), |queued| queued.to_string());
+        let resp = match self.protocol {
+            Protocol::Resp2 => 2,
+            Protocol::Resp3 => 3,
+        };
+        format!(
+            "id={id} addr={} laddr={} name={} age={} idle={} flags={flags} db={} sub={} psub={} multi={multi} cmd={} user={} resp={resp}\n",
+            self.addr,
+            self.local_addr,
+            String::from_utf8_lossy(&self.name),
+            self.created_at.elapsed().as_secs(),
+            self.last_interaction.elapsed().as_secs(),
+            self.db,
+            self.channels,
+            self.patterns,
+            self.last_command,
+            self.user,
+        )
+    }
+}
+
+/// Pause of the clients by CLIENT PAUSE
+#[derive(Clone, Copy)]
+pub struct Pause {
+    /// When the pause ends
+    until: Instant,
+    /// Whether only the commands which may write are delayed, rather than all of them
+    is_write_only: bool,
+}
+
+impl Pause {
+    /// Time until which the command must wait, if it is delayed by the pause
+    /// `is_write` tells whether the command may write, e.g. EXEC of a transaction with writes. CLIENT PAUSE and
+    /// UNPAUSE themselves are never delayed, so that the pause can always be lifted.
+    pub fn delays(&self, parsed_command: &[Vec<u8>], is_write: bool) -> Option<Instant> {
+        let is_pause_command = parsed_command.len() >= 2
+            && parsed_command[0].eq_ignore_ascii_case(b"client")
+            && (parsed_command[1].eq_ignore_ascii_case(b"pause")
+                || parsed_command[1].eq_ignore_ascii_case(b"unpause"));
+        let is_delayed = (is_write || !self.is_write_only) && !is_pause_command;
+        (is_delayed && self.until > Instant::now()).then_some(self.until)
+    }
+}
+
+/// The connections being served, by ID
+pub struct Clients {
+    /// Description of every connection, by ID
+    connections: BTreeMap<u64, ClientInfo>,
+    /// Current pause by CLIENT PAUSE, if any, which the delayed commands watch to learn when it is lifted
+    pause: watch::Sender<Option<Pause>>,
+}
+
+impl Default for Clients {
+    fn default() -> Self {
+        Self {
+            connections: BTreeMap::new(),
+            pause: watch::Sender::new(None),
+        }
+    }
+}
+
+impl Clients {
+    /// Watch the pause by CLIENT PAUSE
+    pub fn watch_pause(&self) -> watch::Receiver<Option<Pause>> {
+        self.pause.subscribe()
+    }
+
+    /// Register a newly accepted connection, returning what is notified when it must be closed
+    pub fn register(&mut self, id: u64, addr: ClientAddr, local_addr: ClientAddr) -> Arc<Notify> {
+        let kill = Arc::new(Notify::new());
+        let now = Instant::now();
+        self.connections.insert(
+            id,
+            ClientInfo {
+                addr,
+                local_addr,
+                name: Vec::new(),
+                created_at: now,
+                last_interaction: now,
+                last_command: "NULL".to_owned(),
+                db: 0,
+                channels: 0,
+                patterns: 0,
+                queued: None,
+                protocol: Protocol::default(),
+                no_evict: false,
+                user: acl::DEFAULT_USER.to_owned(),
+                kill: Arc::clone(&kill),
+            },
+        );
+        kill
+    }
+
+    /// Remove a connection which closed or became the link of a replica
+    pub fn unregister(&mut self, id: u64) {
+        self.connections.remove(&id);
+    }
+
+    /// Number of registered connections
+    pub fn count(&self) -> usize {
+        self.connections.len()
+    }
+
+    /// Name of the client given by CLIENT SETNAME, empty if it has none
+    pub fn name(&self, id: u64) -> Vec<u8> {
+        self.connections
+            .get(&id)
+            .map(|info| info.name.clone())
+            .unwrap_or_default()
+    }
+
+    /// Give the client the name, which must be valid
+    pub fn set_name(&mut self, id: u64, name: &[u8]) {
+        if let Some(info) = self.connections.get_mut(&id) {
+            info.name = name.to_vec();
+        }
+    }
+
+    /// Record the command which the client is about to run
+    pub fn record_command(&mut self, id: u64, parsed_command: &[Vec<u8>]) {
+        if let Some(info) = self.connections.get_mut(&id) {
+            info.last_interaction = Instant::now();
+            info.last_command = command::full_name(parsed_command);
+        }
+    }
+
+    /// Record the state of the client once its command ran
+    /// Returns false if the connection of the client was closed in the meantime, e.g. by the command itself.
+    pub fn record_state(&mut self, client: &ClientState) -> bool {
+        let Some(info) = self.connections.get_mut(&client.id) else {
+            return false;
+        };
+        info.db = client.db;
+        info.protocol = client.protocol;
+        info.user.clone_from(&client.user);
+        info.queued = client.transaction.as_ref().map(Transaction::queued_count);
+        let subscribed_count = |kind| {
+            client
+                .subscription
+                .as_ref()
+                .map_or(0, |subscription| subscription.subscribed_count(kind))
+        };
+        info.channels = subscribed_count(SubscriptionKind::Channel);
+        info.patterns = subscribed_count(SubscriptionKind::Pattern);
+        true
+    }
+
+    /// Forget the name and the NO-EVICT flag given by the client, for RESET
+    pub fn reset(&mut self, id: u64) {
+        if let Some(info) = self.connections.get_mut(&id) {
+            info.name.clear();
+            info.no_evict = false;
+        }
+    }
+
+    /// Close the connections authenticated as the user, e.g. once it is deleted
+    pub fn kill_authenticated_as(&mut self, user: &str) {
+        let filter = KillFilter {
+            id: None,
+            addr: None,
+            user: Some(user.to_owned()),
+            skip_me: false,
+        };
+        // The own connection isn't skipped, so whose it is doesn't matter
+        self.kill(&filter, 0);
+    }
+
+    /// Close the connections matching the filter, returning how many were closed
+    fn kill(&mut self, filter: &KillFilter, own_id: u64) -> usize {
+        let killed: Vec<u64> = self
+            .connections
+            .iter()
+            .filter(|&(&id, info)| {
+                filter.id.is_none_or(|filter_id| filter_id == id)
+                    && filter
+                        .addr
+                        .as_ref()
+                        .is_none_or(|addr| *addr == info.addr.to_string())
+                    && filter.user.as_ref().is_none_or(|user| *user == info.user)
+                    && !(filter.skip_me && id == own_id)
+            })
+            .map(|(&id, _)| id)
+            .collect();
+        // Removed right away, so that a connection which is closing isn't killed twice
+        for id in &killed {
+            if let Some(info) = self.connections.remove(id) {
+                info.kill.notify_one();
+            }
+        }
+        killed.len()
+    }
+}
+
+/// Pause the clients for the milliseconds given to CLIENT PAUSE, optionally followed by WRITE or ALL
+/// Like in Redis, a pause which is already in effect is only ever made longer or stricter.
+fn pause(pause: &watch::Sender<Option<Pause>>, args: &[Vec<u8>]) -> Result<(), &'static str> {
+    let timeout_ms =
+        parse_redis_int(&args[0]).ok_or("ERR timeout is not an integer or out of range")?;
+    if timeout_ms < 0 {
+        return Err("ERR timeout is negative");
+    }
+    let is_write_only = match args.get(1) {
+        None => false,
+        Some(mode) if mode.eq_ignore_ascii_case(b"write") => true,
+        Some(mode) if mode.eq_ignore_ascii_case(b"all") => false,
+        Some(_) => return Err("ERR syntax error"),
+    };
+    let until = Instant::now() + Duration::from_millis(timeout_ms.unsigned_abs());
+    pause.send_modify(|current| {
+        let new = match *current {
+            Some(current) if current.until > Instant::now() => Pause {
+                until: until.max(current.until),
+                is_write_only: is_write_only && current.is_write_only,
+            },
+            _ => Pause {
+                until,
+                is_write_only,
+            },
+        };
+        *current = Some(new);
+    });
+    Ok(())
+}
+
+/// Connections to be closed by CLIENT KILL; every given condition must match
+struct KillFilter {
+    /// `ID`: the connection with the ID
+    id: Option<u64>,
+    /// `ADDR`: the connection from the address, as `ip:port`
+    addr: Option<String>,
+    /// `USER`: the connections authenticated as the ACL user
+    user: Option<String>,
+    /// `SKIPME`: spare the connection sending CLIENT KILL
+    skip_me: bool,
+}
+
+/// Parse the filters of CLIENT KILL, given as pairs of a filter and its value
+fn parse_kill_filter(args: &[Vec<u8>]) -> Result<KillFilter, &'static str> {
+    let mut filter = KillFilter {
+        id: None,
+        addr: None,
+        user: None,
+        skip_me: true,
+    };
+    if !args.len().is_multiple_of(2) {
+        return Err("ERR syntax error");
+    }
+    for pair in args.chunks_exact(2) {
+        let (name, value) = (&pair[0], &pair[1]);
+        if name.eq_ignore_ascii_case(b"id") {
+            let id = str::from_utf8(value)
+                .ok()
+                .and_then(|id| id.parse().ok())
+                .filter(|&id| id > 0)
+                .ok_or("ERR client-id should be greater than 0")?;
+            filter.id = Some(id);
+        } else if name.eq_ignore_ascii_case(b"addr") {
+            filter.addr = Some(String::from_utf8_lossy(value).into_owned());
+        } else if name.eq_ignore_ascii_case(b"user") {
+            filter.user = Some(String::from_utf8_lossy(value).into_owned());
+        } else if name.eq_ignore_ascii_case(b"skipme") && value.eq_ignore_ascii_case(b"yes") {
+            filter.skip_me = true;
+        } else if name.eq_ignore_ascii_case(b"skipme") && value.eq_ignore_ascii_case(b"no") {
+            filter.skip_me = false;
+        } else {
+            return Err("ERR syntax error");
+        }
+    }
+    Ok(filter)
+}
+
+/// Lines of CLIENT HELP
+const HELP: &[&str] = &[
+    "GETNAME",
+    "    Return the name of the current connection.",
+    "ID",
+    "    Return the ID of the current connection.",
+    "KILL <ip:port>",
+    "    Kill connection made from <ip:port>.",
+    "KILL <option> <value> [<option> <value> [...]]",
+    "    Kill connections. Options are:",
+    "    * ADDR (<ip:port>|<unixsocket>:0)",
+    "      Kill connections made from the specified address",
+    "    * ID <client-id>",
+    "      Kill connections by client id.",
+    "    * USER <username>",
+    "      Kill connections authenticated by <username>.",
+    "    * SKIPME (YES|NO)",
+    "      Skip killing current connection (default: yes).",
+    "LIST",
+    "    Return information about client connections.",
+    "NO-EVICT (ON|OFF)",
+    "    Protect current client connection from eviction.",
+    "PAUSE <timeout> [WRITE|ALL]",
+    "    Suspend all, or just write, clients for <timeout> milliseconds.",
+    "SETINFO <option> <value>",
+    "    Set client meta attr. Options are:",
+    "    * LIB-NAME: the client lib name.",
+    "    * LIB-VER: the client lib version.",
+    "SETNAME <name>",
+    "    Assign the name <name> to the current connection.",
+    "UNPAUSE",
+    "    Stop the current client pause, resuming traffic.",
+];
+
+/// Check that the name can be given to a client by CLIENT SETNAME or HELLO
+/// The name shows up in CLIENT LIST, whose fields are separated by spaces.
+pub fn validate_name(name: &[u8]) -> Result<(), &'static str> {
+    if name.iter().any(|&c| !c.is_ascii_graphic()) {
+        return Err("ERR Client names cannot contain spaces, newlines or special characters.");
+    }
+    Ok(())
+}
+
+/// Compute output of the CLIENT ID/GETNAME/SETNAME/LIST/NO-EVICT/KILL/PAUSE/UNPAUSE/SETINFO/HELP subcommands
+/// `CLIENT KILL addr` closes the connection from the address, including the own one, and fails if there is none;
+/// with filters it replies the number of connections closed instead.
+pub fn client(
+    clients: &Mutex<Clients>,
+    client: &ClientState,
+    parsed_command: &[Vec<u8>],
+) -> RespValue {
+    let subcommand = String::from_utf8_lossy(&parsed_command[1]).to_lowercase();
+    let args = &parsed_command[2..];
+    match subcommand.as_str() {
+        "id" if args.is_empty() => RespValue::Integer(i64::try_from(client.id).unwrap()),
+        "getname" if args.is_empty() => {
+            let name = clients.lock().unwrap().name(client.id);
+            if name.is_empty() {
+                RespValue::NullBulkString
+            } else {
+                RespValue::BulkString(name)
+            }
+        }
+        "setname" if args.len() == 1 => {
+            let name = &args[0];
+            if let Err(err) = validate_name(name) {
+                return RespValue::error(err);
+            }
+            clients.lock().unwrap().set_name(client.id, name);
+            RespValue::simple("OK")
+        }
+        "list" if args.is_empty() => {
+            let clients = clients.lock().unwrap();
+            let list = clients
+                .connections
+                .iter()
+                .fold(String::new(), |mut list, (&id, info)| {
+                    let _ = write!(list, "{}", info.describe(id));
+                    list
+                });
+            drop(clients);
+            RespValue::BulkString(list.into_bytes())
+        }
+        "no-evict" if args.len() == 1 => {
+            let mode = &args[0];
+            let no_evict = if mode.eq_ignore_ascii_case(b"on") {
+                true
+            } else if mode.eq_ignore_ascii_case(b"off") {
+                false
+            } else {
+                return RespValue::error("ERR syntax error");
+            };
+            if let Some(info) = clients.lock().unwrap().connections.get_mut(&client.id) {
+                info.no_evict = no_evict;
+            }
+            RespValue::simple("OK")
+        }
+        "kill" if args.len() == 1 => {
+            let addr = &args[0];
+            let filter = KillFilter {
+                id: None,
+                addr: Some(String::from_utf8_lossy(addr).into_owned()),
+                user: None,
+                skip_me: false,
+            };
+            if clients.lock().unwrap().kill(&filter, client.id) == 0 {
+                RespValue::error("ERR No such client")
+            } else {
+                RespValue::simple("OK")
+            }
+        }
+        "kill" if !args.is_empty() => match parse_kill_filter(args) {
+            Ok(filter) => {
+                let killed = clients.lock().unwrap().kill(&filter, client.id);
+                RespValue::Integer(i64::try_from(killed).unwrap())
+            }
+            Err(err) => RespValue::error(err),
+        },
+        "pause" if matches!(args.len(), 1 | 2) => {
+            match pause(&clients.lock().unwrap().pause, args) {
+                Ok(()) => RespValue::simple("OK"),
+                Err(err) => RespValue::error(err),
+            }
+        }
+        "unpause" if args.is_empty() => {
+            clients.lock().unwrap().pause.send_replace(None);
+            RespValue::simple("OK")
+        }
+        // The library name and version reported by the client libraries aren't needed
+        "setinfo" if args.len() == 2 => RespValue::simple("OK"),
+        "help" if args.is_empty() => RespValue::help("CLIENT", HELP),
+        "id" | "getname" | "setname" | "list" | "no-evict" | "kill" | "pause" | "unpause"
+        | "setinfo" | "help" => command::wrong_arity(&format!("client|{subcommand}")),
+        _ => RespValue::Error(format!(
+            "ERR unknown subcommand '{}'. Try CLIENT HELP.",
+            String::from_utf8_lossy(&parsed_command[1])
+        )),
+    }
+}
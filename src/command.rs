@@ -0,0 +1,1950 @@
+//! Static information about the supported commands
+
+use std::{collections::HashMap, ops::Range, sync::LazyLock};
+
+use crate::{handlers, resp::RespValue, ClientState, Execution, Server};
+
+use Flag::{Admin, Blocking, DenyOom, Fast, Loading, Pubsub, Readonly, Stale, Write};
+
+/// Flag of a command, as reported by COMMAND
+#[derive(Clone, Copy, PartialEq, Eq)]
+enum Flag {
+    /// May modify the keyspace, so it is logged to the AOF and rejected by a replica
+    Write,
+    /// May increase the memory used, so it is rejected once `maxmemory` is exceeded and nothing can be evicted
+    DenyOom,
+    /// Only reads the keyspace
+    Readonly,
+    /// Runs in constant or logarithmic time
+    Fast,
+    /// May block the client
+    Blocking,
+    /// Administers the server
+    Admin,
+    /// Acts on the Pub/Sub channels
+    Pubsub,
+    /// Allowed while the database is loading
+    Loading,
+    /// Allowed while a replica has stale data
+    Stale,
+}
+
+impl Flag {
+    /// Name of the flag in the replies
+    const fn name(self) -> &'static str {
+        match self {
+            Self::Write => "write",
+            Self::DenyOom => "denyoom",
+            Self::Readonly => "readonly",
+            Self::Fast => "fast",
+            Self::Blocking => "blocking",
+            Self::Admin => "admin",
+            Self::Pubsub => "pubsub",
+            Self::Loading => "loading",
+            Self::Stale => "stale",
+        }
+    }
+}
+
+/// Group of a command in the documentation of Redis
+#[derive(Clone, Copy)]
+enum Group {
+    /// Commands acting on keys of any type
+    Generic,
+    /// Commands of the strings
+    String,
+    /// Commands of the strings as arrays of bits
+    Bitmap,
+    /// Commands of the lists
+    List,
+    /// Commands of the hashes
+    Hash,
+    /// Commands of the sets
+    Set,
+    /// Commands of the sorted sets
+    SortedSet,
+    /// Commands of the streams
+    Stream,
+    /// Commands of the sorted sets as geospatial indexes
+    Geo,
+    /// Commands of the strings as estimators of the number of distinct elements
+    HyperLogLog,
+    /// MULTI, EXEC and the like
+    Transactions,
+    /// Commands of the Pub/Sub channels
+    Pubsub,
+    /// Commands acting on the connection
+    Connection,
+    /// Commands administering the server
+    Server,
+}
+
+impl Group {
+    /// Name of the group in the replies
+    const fn name(self) -> &'static str {
+        match self {
+            Self::Generic => "generic",
+            Self::String => "string",
+            Self::Bitmap => "bitmap",
+            Self::List => "list",
+            Self::Hash => "hash",
+            Self::Set => "set",
+            Self::SortedSet => "sorted-set",
+            Self::Stream => "stream",
+            Self::Geo => "geo",
+            Self::HyperLogLog => "hyperloglog",
+            Self::Transactions => "transactions",
+            Self::Pubsub => "pubsub",
+            Self::Connection => "connection",
+            Self::Server => "server",
+        }
+    }
+}
+
+/// Positions of the first and last key arguments and the step between two keys, as defined by Redis
+/// A negative last position counts from the end, e.g. `-1` is the last argument.
+type KeyPositions = (i64, i64, i64);
+
+/// The command doesn't take keys, or takes them where only `movable_keys` finds them
+const NO_KEYS: KeyPositions = (0, 0, 0);
+/// The first argument is the only key
+const ONE_KEY: KeyPositions = (1, 1, 1);
+/// All the arguments are keys
+const ALL_KEYS: KeyPositions = (1, -1, 1);
+
+/// Function running a command of the client, given the command along with its arguments and whether it may block
+pub type Handler = fn(&Server, &mut ClientState, &[Vec<u8>], bool) -> Execution;
+
+/// Static information about a supported command
+pub struct CommandSpec {
+    /// Name of the command, in lowercase
+    name: &'static str,
+    /// Number of arguments including the command name; a negative arity `-n` means that at least `n` arguments are
+    /// required
+    arity: i64,
+    /// Flags of the command
+    flags: &'static [Flag],
+    /// Positions of the keys among the arguments
+    keys: KeyPositions,
+    /// Group of the command in the documentation
+    group: Group,
+    /// Function running the command
+    handler: Handler,
+}
+
+/// Describe a command for the table
+const fn spec(
+    name: &'static str,
+    arity: i64,
+    flags: &'static [Flag],
+    keys: KeyPositions,
+    group: Group,
+    handler: Handler,
+) -> CommandSpec {
+    CommandSpec {
+        name,
+        arity,
+        flags,
+        keys,
+        group,
+        handler,
+    }
+}
+
+/// Every supported command; this is what both the validation of the commands and COMMAND rely on
+const COMMANDS: &[CommandSpec] = &[
+    spec(
+        "ping",
+        -1,
+        &[Fast],
+        NO_KEYS,
+        Group::Connection,
+        handlers::ping_command,
+    ),
+    spec(
+        "hello",
+        -1,
+        &[Loading, Stale, Fast],
+        NO_KEYS,
+        Group::Connection,
+        handlers::hello_command,
+    ),
+    spec(
+        "echo",
+        2,
+        &[Fast],
+        NO_KEYS,
+        Group::Connection,
+        handlers::echo_command,
+    ),
+    spec(
+        "client",
+        -2,
+        &[],
+        NO_KEYS,
+        Group::Connection,
+        handlers::client_command,
+    ),
+    spec(
+        "reset",
+        1,
+        &[Loading, Stale, Fast],
+        NO_KEYS,
+        Group::Connection,
+        handlers::handled_by_dispatch,
+    ),
+    spec(
+        "auth",
+        -2,
+        &[Loading, Stale, Fast],
+        NO_KEYS,
+        Group::Connection,
+        handlers::auth_command,
+    ),
+    spec(
+        "acl",
+        -2,
+        &[Admin],
+        NO_KEYS,
+        Group::Server,
+        handlers::acl_command,
+    ),
+    spec(
+        "select",
+        2,
+        &[Loading, Stale, Fast],
+        NO_KEYS,
+        Group::Connection,
+        handlers::select_command,
+    ),
+    spec(
+        "quit",
+        -1,
+        &[Loading, Stale, Fast],
+        NO_KEYS,
+        Group::Connection,
+        handlers::handled_by_dispatch,
+    ),
+    spec(
+        "set",
+        -3,
+        &[Write, DenyOom],
+        ONE_KEY,
+        Group::String,
+        handlers::set_command,
+    ),
+    spec(
+        "get",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::String,
+        handlers::get_command,
+    ),
+    spec(
+        "setex",
+        4,
+        &[Write, DenyOom],
+        ONE_KEY,
+        Group::String,
+        handlers::setex_command,
+    ),
+    spec(
+        "psetex",
+        4,
+        &[Write, DenyOom],
+        ONE_KEY,
+        Group::String,
+        handlers::setex_command,
+    ),
+    spec(
+        "setnx",
+        3,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::String,
+        handlers::setnx_command,
+    ),
+    spec(
+        "mset",
+        -3,
+        &[Write, DenyOom],
+        (1, -1, 2),
+        Group::String,
+        handlers::mset_command,
+    ),
+    spec(
+        "msetnx",
+        -3,
+        &[Write, DenyOom],
+        (1, -1, 2),
+        Group::String,
+        handlers::msetnx_command,
+    ),
+    spec(
+        "mget",
+        -2,
+        &[Readonly, Fast],
+        ALL_KEYS,
+        Group::String,
+        handlers::mget_command,
+    ),
+    spec(
+        "incr",
+        2,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::String,
+        handlers::incr_command,
+    ),
+    spec(
+        "decr",
+        2,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::String,
+        handlers::incr_command,
+    ),
+    spec(
+        "incrby",
+        3,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::String,
+        handlers::incr_command,
+    ),
+    spec(
+        "decrby",
+        3,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::String,
+        handlers::incr_command,
+    ),
+    spec(
+        "incrbyfloat",
+        3,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::String,
+        handlers::incrbyfloat_command,
+    ),
+    spec(
+        "getdel",
+        2,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::String,
+        handlers::getdel_command,
+    ),
+    spec(
+        "getex",
+        -2,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::String,
+        handlers::getex_command,
+    ),
+    spec(
+        "getrange",
+        4,
+        &[Readonly],
+        ONE_KEY,
+        Group::String,
+        handlers::getrange_command,
+    ),
+    spec(
+        "lcs",
+        -3,
+        &[Readonly],
+        (1, 2, 1),
+        Group::String,
+        handlers::lcs_command,
+    ),
+    spec(
+        "setrange",
+        4,
+        &[Write, DenyOom],
+        ONE_KEY,
+        Group::String,
+        handlers::setrange_command,
+    ),
+    spec(
+        "append",
+        3,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::String,
+        handlers::append_command,
+    ),
+    spec(
+        "setbit",
+        4,
+        &[Write, DenyOom],
+        ONE_KEY,
+        Group::Bitmap,
+        handlers::setbit_command,
+    ),
+    spec(
+        "getbit",
+        3,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Bitmap,
+        handlers::getbit_command,
+    ),
+    spec(
+        "bitcount",
+        -2,
+        &[Readonly],
+        ONE_KEY,
+        Group::Bitmap,
+        handlers::bitcount_command,
+    ),
+    spec(
+        "bitpos",
+        -3,
+        &[Readonly],
+        ONE_KEY,
+        Group::Bitmap,
+        handlers::bitpos_command,
+    ),
+    spec(
+        "bitop",
+        -4,
+        &[Write, DenyOom],
+        (2, -1, 1),
+        Group::Bitmap,
+        handlers::bitop_command,
+    ),
+    spec(
+        "pfadd",
+        -2,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::HyperLogLog,
+        handlers::pfadd_command,
+    ),
+    spec(
+        "pfcount",
+        -2,
+        &[Readonly],
+        ALL_KEYS,
+        Group::HyperLogLog,
+        handlers::pfcount_command,
+    ),
+    spec(
+        "pfmerge",
+        -2,
+        &[Write, DenyOom],
+        ALL_KEYS,
+        Group::HyperLogLog,
+        handlers::pfmerge_command,
+    ),
+    spec(
+        "strlen",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::String,
+        handlers::append_command,
+    ),
+    spec(
+        "ttl",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Generic,
+        handlers::ttl_command,
+    ),
+    spec(
+        "pttl",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Generic,
+        handlers::ttl_command,
+    ),
+    spec(
+        "sort",
+        -2,
+        &[Write, DenyOom],
+        ONE_KEY,
+        Group::Generic,
+        handlers::sort_command,
+    ),
+    spec(
+        "sort_ro",
+        -2,
+        &[Readonly],
+        ONE_KEY,
+        Group::Generic,
+        handlers::sort_command,
+    ),
+    spec(
+        "expiretime",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Generic,
+        handlers::expiretime_command,
+    ),
+    spec(
+        "pexpiretime",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Generic,
+        handlers::expiretime_command,
+    ),
+    spec(
+        "expire",
+        -3,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Generic,
+        handlers::expire_command,
+    ),
+    spec(
+        "pexpire",
+        -3,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Generic,
+        handlers::expire_command,
+    ),
+    spec(
+        "expireat",
+        -3,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Generic,
+        handlers::expire_command,
+    ),
+    spec(
+        "pexpireat",
+        -3,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Generic,
+        handlers::expire_command,
+    ),
+    spec(
+        "persist",
+        2,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Generic,
+        handlers::persist_command,
+    ),
+    spec(
+        "del",
+        -2,
+        &[Write],
+        ALL_KEYS,
+        Group::Generic,
+        handlers::del_command,
+    ),
+    spec(
+        "unlink",
+        -2,
+        &[Write, Fast],
+        ALL_KEYS,
+        Group::Generic,
+        handlers::del_command,
+    ),
+    spec(
+        "exists",
+        -2,
+        &[Readonly, Fast],
+        ALL_KEYS,
+        Group::Generic,
+        handlers::exists_command,
+    ),
+    spec(
+        "touch",
+        -2,
+        &[Readonly, Fast],
+        ALL_KEYS,
+        Group::Generic,
+        handlers::exists_command,
+    ),
+    spec(
+        "dump",
+        2,
+        &[Readonly],
+        ONE_KEY,
+        Group::Generic,
+        handlers::dump_command,
+    ),
+    spec(
+        "restore",
+        -4,
+        &[Write, DenyOom],
+        ONE_KEY,
+        Group::Generic,
+        handlers::restore_command,
+    ),
+    spec(
+        "copy",
+        -3,
+        &[Write, DenyOom],
+        (1, 2, 1),
+        Group::Generic,
+        handlers::copy_command,
+    ),
+    spec(
+        "move",
+        3,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Generic,
+        handlers::move_command,
+    ),
+    spec(
+        "rename",
+        3,
+        &[Write],
+        (1, 2, 1),
+        Group::Generic,
+        handlers::rename_command,
+    ),
+    spec(
+        "renamenx",
+        3,
+        &[Write, Fast],
+        (1, 2, 1),
+        Group::Generic,
+        handlers::renamenx_command,
+    ),
+    spec(
+        "swapdb",
+        3,
+        &[Write, Fast],
+        NO_KEYS,
+        Group::Server,
+        handlers::swapdb_command,
+    ),
+    spec(
+        "flushdb",
+        -1,
+        &[Write],
+        NO_KEYS,
+        Group::Server,
+        handlers::flushdb_command,
+    ),
+    spec(
+        "flushall",
+        -1,
+        &[Write],
+        NO_KEYS,
+        Group::Server,
+        handlers::flushall_command,
+    ),
+    spec(
+        "dbsize",
+        1,
+        &[Readonly, Fast],
+        NO_KEYS,
+        Group::Server,
+        handlers::dbsize_command,
+    ),
+    spec(
+        "randomkey",
+        1,
+        &[Readonly],
+        NO_KEYS,
+        Group::Generic,
+        handlers::randomkey_command,
+    ),
+    spec(
+        "keys",
+        2,
+        &[Readonly],
+        NO_KEYS,
+        Group::Generic,
+        handlers::keys_command,
+    ),
+    spec(
+        "type",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Generic,
+        handlers::type_command,
+    ),
+    spec(
+        "object",
+        -2,
+        &[],
+        NO_KEYS,
+        Group::Generic,
+        handlers::object_command,
+    ),
+    spec(
+        "memory",
+        -2,
+        &[],
+        NO_KEYS,
+        Group::Server,
+        handlers::memory_command,
+    ),
+    spec(
+        "scan",
+        -2,
+        &[Readonly],
+        NO_KEYS,
+        Group::Generic,
+        handlers::scan_command,
+    ),
+    spec(
+        "rpush",
+        -3,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::List,
+        handlers::rpush_command,
+    ),
+    spec(
+        "lpush",
+        -3,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::List,
+        handlers::rpush_command,
+    ),
+    spec(
+        "lrange",
+        4,
+        &[Readonly],
+        ONE_KEY,
+        Group::List,
+        handlers::lrange_command,
+    ),
+    spec(
+        "llen",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::List,
+        handlers::llen_command,
+    ),
+    spec(
+        "lpop",
+        -2,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::List,
+        handlers::lpop_command,
+    ),
+    spec(
+        "rpop",
+        -2,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::List,
+        handlers::rpop_command,
+    ),
+    spec(
+        "lpos",
+        -3,
+        &[Readonly],
+        ONE_KEY,
+        Group::List,
+        handlers::lpos_command,
+    ),
+    spec(
+        "lset",
+        4,
+        &[Write, DenyOom],
+        ONE_KEY,
+        Group::List,
+        handlers::lset_command,
+    ),
+    spec(
+        "linsert",
+        5,
+        &[Write, DenyOom],
+        ONE_KEY,
+        Group::List,
+        handlers::linsert_command,
+    ),
+    spec(
+        "lrem",
+        4,
+        &[Write],
+        ONE_KEY,
+        Group::List,
+        handlers::lrem_command,
+    ),
+    spec(
+        "ltrim",
+        4,
+        &[Write],
+        ONE_KEY,
+        Group::List,
+        handlers::ltrim_command,
+    ),
+    // Like in Redis, the blocking pops are writes, as they pop when they don't block
+    spec(
+        "blpop",
+        -3,
+        &[Write, Blocking],
+        (1, -2, 1),
+        Group::List,
+        handlers::blpop_command,
+    ),
+    spec(
+        "brpop",
+        -3,
+        &[Write, Blocking],
+        (1, -2, 1),
+        Group::List,
+        handlers::blpop_command,
+    ),
+    spec(
+        "lmpop",
+        -4,
+        &[Write],
+        NO_KEYS,
+        Group::List,
+        handlers::lmpop_command,
+    ),
+    spec(
+        "blmpop",
+        -5,
+        &[Write, Blocking],
+        NO_KEYS,
+        Group::List,
+        handlers::lmpop_command,
+    ),
+    spec(
+        "lmove",
+        5,
+        &[Write, DenyOom],
+        (1, 2, 1),
+        Group::List,
+        handlers::lmove_command,
+    ),
+    spec(
+        "rpoplpush",
+        3,
+        &[Write, DenyOom],
+        (1, 2, 1),
+        Group::List,
+        handlers::lmove_command,
+    ),
+    spec(
+        "blmove",
+        6,
+        &[Write, DenyOom, Blocking],
+        (1, 2, 1),
+        Group::List,
+        handlers::lmove_command,
+    ),
+    spec(
+        "hset",
+        -4,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hset_command,
+    ),
+    spec(
+        "hsetnx",
+        4,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hsetnx_command,
+    ),
+    spec(
+        "hget",
+        3,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hget_command,
+    ),
+    spec(
+        "hdel",
+        -3,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hdel_command,
+    ),
+    spec(
+        "hgetall",
+        2,
+        &[Readonly],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hgetall_command,
+    ),
+    spec(
+        "hlen",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hlen_command,
+    ),
+    spec(
+        "hexists",
+        3,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hexists_command,
+    ),
+    spec(
+        "hstrlen",
+        3,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hstrlen_command,
+    ),
+    spec(
+        "hincrby",
+        4,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hincrby_command,
+    ),
+    spec(
+        "hincrbyfloat",
+        4,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hincrbyfloat_command,
+    ),
+    spec(
+        "hrandfield",
+        -2,
+        &[Readonly],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hrandfield_command,
+    ),
+    spec(
+        "hscan",
+        -3,
+        &[Readonly],
+        ONE_KEY,
+        Group::Hash,
+        handlers::hscan_command,
+    ),
+    spec(
+        "sadd",
+        -3,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::Set,
+        handlers::sadd_command,
+    ),
+    spec(
+        "srem",
+        -3,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Set,
+        handlers::srem_command,
+    ),
+    spec(
+        "smembers",
+        2,
+        &[Readonly],
+        ONE_KEY,
+        Group::Set,
+        handlers::smembers_command,
+    ),
+    spec(
+        "sismember",
+        3,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Set,
+        handlers::sismember_command,
+    ),
+    spec(
+        "smismember",
+        -3,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Set,
+        handlers::smismember_command,
+    ),
+    spec(
+        "scard",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Set,
+        handlers::scard_command,
+    ),
+    spec(
+        "srandmember",
+        -2,
+        &[Readonly],
+        ONE_KEY,
+        Group::Set,
+        handlers::srandmember_command,
+    ),
+    spec(
+        "spop",
+        -2,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Set,
+        handlers::spop_command,
+    ),
+    spec(
+        "sinter",
+        -2,
+        &[Readonly],
+        ALL_KEYS,
+        Group::Set,
+        handlers::sinter_command,
+    ),
+    spec(
+        "sunion",
+        -2,
+        &[Readonly],
+        ALL_KEYS,
+        Group::Set,
+        handlers::sinter_command,
+    ),
+    spec(
+        "sdiff",
+        -2,
+        &[Readonly],
+        ALL_KEYS,
+        Group::Set,
+        handlers::sinter_command,
+    ),
+    spec(
+        "sinterstore",
+        -3,
+        &[Write, DenyOom],
+        ALL_KEYS,
+        Group::Set,
+        handlers::sinterstore_command,
+    ),
+    spec(
+        "sunionstore",
+        -3,
+        &[Write, DenyOom],
+        ALL_KEYS,
+        Group::Set,
+        handlers::sinterstore_command,
+    ),
+    spec(
+        "sdiffstore",
+        -3,
+        &[Write, DenyOom],
+        ALL_KEYS,
+        Group::Set,
+        handlers::sinterstore_command,
+    ),
+    spec(
+        "sintercard",
+        -3,
+        &[Readonly],
+        NO_KEYS,
+        Group::Set,
+        handlers::sintercard_command,
+    ),
+    spec(
+        "sscan",
+        -3,
+        &[Readonly],
+        ONE_KEY,
+        Group::Set,
+        handlers::hscan_command,
+    ),
+    spec(
+        "zadd",
+        -4,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zadd_command,
+    ),
+    spec(
+        "zscore",
+        3,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zscore_command,
+    ),
+    spec(
+        "zincrby",
+        4,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zincrby_command,
+    ),
+    spec(
+        "zrem",
+        -3,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zrem_command,
+    ),
+    spec(
+        "zintercard",
+        -3,
+        &[Readonly],
+        NO_KEYS,
+        Group::SortedSet,
+        handlers::zintercard_command,
+    ),
+    spec(
+        "zcard",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zcard_command,
+    ),
+    spec(
+        "zcount",
+        4,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zcount_command,
+    ),
+    spec(
+        "zpopmin",
+        -2,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zpopmin_command,
+    ),
+    spec(
+        "zpopmax",
+        -2,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zpopmax_command,
+    ),
+    spec(
+        "geoadd",
+        -5,
+        &[Write, DenyOom],
+        ONE_KEY,
+        Group::Geo,
+        handlers::geoadd_command,
+    ),
+    spec(
+        "geopos",
+        -2,
+        &[Readonly],
+        ONE_KEY,
+        Group::Geo,
+        handlers::geopos_command,
+    ),
+    spec(
+        "geodist",
+        -4,
+        &[Readonly],
+        ONE_KEY,
+        Group::Geo,
+        handlers::geodist_command,
+    ),
+    spec(
+        "geosearch",
+        -7,
+        &[Readonly],
+        ONE_KEY,
+        Group::Geo,
+        handlers::geosearch_command,
+    ),
+    spec(
+        "zmpop",
+        -4,
+        &[Write],
+        NO_KEYS,
+        Group::SortedSet,
+        handlers::lmpop_command,
+    ),
+    spec(
+        "bzmpop",
+        -5,
+        &[Write, Blocking],
+        NO_KEYS,
+        Group::SortedSet,
+        handlers::lmpop_command,
+    ),
+    spec(
+        "bzpopmin",
+        -3,
+        &[Write, Blocking],
+        (1, -2, 1),
+        Group::SortedSet,
+        handlers::blpop_command,
+    ),
+    spec(
+        "bzpopmax",
+        -3,
+        &[Write, Blocking],
+        (1, -2, 1),
+        Group::SortedSet,
+        handlers::blpop_command,
+    ),
+    spec(
+        "zrank",
+        -3,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zrank_command,
+    ),
+    spec(
+        "zrevrank",
+        -3,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zrank_command,
+    ),
+    spec(
+        "zrange",
+        -4,
+        &[Readonly],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zrange_command,
+    ),
+    spec(
+        "zrangebyscore",
+        -4,
+        &[Readonly],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zrangebyscore_command,
+    ),
+    spec(
+        "zrevrangebyscore",
+        -4,
+        &[Readonly],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zrangebyscore_command,
+    ),
+    spec(
+        "zrangebylex",
+        -4,
+        &[Readonly],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zrangebyscore_command,
+    ),
+    spec(
+        "zrevrangebylex",
+        -4,
+        &[Readonly],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::zrangebyscore_command,
+    ),
+    spec(
+        "zscan",
+        -3,
+        &[Readonly],
+        ONE_KEY,
+        Group::SortedSet,
+        handlers::hscan_command,
+    ),
+    spec(
+        "xadd",
+        -5,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::Stream,
+        handlers::xadd_command,
+    ),
+    spec(
+        "xrange",
+        -4,
+        &[Readonly],
+        ONE_KEY,
+        Group::Stream,
+        handlers::xrange_command,
+    ),
+    spec(
+        "xlen",
+        2,
+        &[Readonly, Fast],
+        ONE_KEY,
+        Group::Stream,
+        handlers::xlen_command,
+    ),
+    spec(
+        "xdel",
+        -3,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Stream,
+        handlers::xdel_command,
+    ),
+    spec(
+        "xtrim",
+        -4,
+        &[Write],
+        ONE_KEY,
+        Group::Stream,
+        handlers::xtrim_command,
+    ),
+    spec(
+        "xsetid",
+        -3,
+        &[Write, DenyOom, Fast],
+        ONE_KEY,
+        Group::Stream,
+        handlers::xsetid_command,
+    ),
+    spec(
+        "xinfo",
+        -2,
+        &[Readonly],
+        (2, 2, 1),
+        Group::Stream,
+        handlers::xinfo_command,
+    ),
+    // The keys come after `STREAMS`, so they have no fixed positions
+    spec(
+        "xread",
+        -4,
+        &[Readonly, Blocking],
+        NO_KEYS,
+        Group::Stream,
+        handlers::xread_command,
+    ),
+    spec(
+        "xgroup",
+        -2,
+        &[Write],
+        (2, 2, 1),
+        Group::Stream,
+        handlers::xgroup_command,
+    ),
+    spec(
+        "xreadgroup",
+        -7,
+        &[Write, Blocking],
+        NO_KEYS,
+        Group::Stream,
+        handlers::xreadgroup_command,
+    ),
+    spec(
+        "xack",
+        -4,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Stream,
+        handlers::xack_command,
+    ),
+    spec(
+        "xpending",
+        -3,
+        &[Readonly],
+        ONE_KEY,
+        Group::Stream,
+        handlers::xpending_command,
+    ),
+    spec(
+        "xclaim",
+        -6,
+        &[Write, Fast],
+        ONE_KEY,
+        Group::Stream,
+        handlers::xclaim_command,
+    ),
+    spec(
+        "multi",
+        1,
+        &[Loading, Stale, Fast],
+        NO_KEYS,
+        Group::Transactions,
+        handlers::handled_by_dispatch,
+    ),
+    spec(
+        "exec",
+        1,
+        &[Loading, Stale],
+        NO_KEYS,
+        Group::Transactions,
+        handlers::handled_by_dispatch,
+    ),
+    spec(
+        "discard",
+        1,
+        &[Loading, Stale, Fast],
+        NO_KEYS,
+        Group::Transactions,
+        handlers::handled_by_dispatch,
+    ),
+    spec(
+        "watch",
+        -2,
+        &[Loading, Stale, Fast],
+        ALL_KEYS,
+        Group::Transactions,
+        handlers::watch_command,
+    ),
+    spec(
+        "unwatch",
+        1,
+        &[Loading, Stale, Fast],
+        NO_KEYS,
+        Group::Transactions,
+        handlers::unwatch_command,
+    ),
+    spec(
+        "subscribe",
+        -2,
+        &[Pubsub, Loading, Stale],
+        NO_KEYS,
+        Group::Pubsub,
+        handlers::subscribe_command,
+    ),
+    spec(
+        "unsubscribe",
+        -1,
+        &[Pubsub, Loading, Stale],
+        NO_KEYS,
+        Group::Pubsub,
+        handlers::unsubscribe_command,
+    ),
+    spec(
+        "psubscribe",
+        -2,
+        &[Pubsub, Loading, Stale],
+        NO_KEYS,
+        Group::Pubsub,
+        handlers::subscribe_command,
+    ),
+    spec(
+        "punsubscribe",
+        -1,
+        &[Pubsub, Loading, Stale],
+        NO_KEYS,
+        Group::Pubsub,
+        handlers::unsubscribe_command,
+    ),
+    spec(
+        "publish",
+        3,
+        &[Pubsub, Loading, Stale, Fast],
+        NO_KEYS,
+        Group::Pubsub,
+        handlers::publish_command,
+    ),
+    spec(
+        "save",
+        1,
+        &[Admin],
+        NO_KEYS,
+        Group::Server,
+        handlers::save_command,
+    ),
+    spec(
+        "lastsave",
+        1,
+        &[Loading, Stale, Fast],
+        NO_KEYS,
+        Group::Server,
+        handlers::lastsave_command,
+    ),
+    spec(
+        "bgsave",
+        -1,
+        &[Admin],
+        NO_KEYS,
+        Group::Server,
+        handlers::bgsave_command,
+    ),
+    spec(
+        "bgrewriteaof",
+        1,
+        &[Admin],
+        NO_KEYS,
+        Group::Server,
+        handlers::bgrewriteaof_command,
+    ),
+    spec(
+        "replconf",
+        -1,
+        &[Admin, Loading, Stale],
+        NO_KEYS,
+        Group::Server,
+        handlers::replconf_command,
+    ),
+    spec(
+        "psync",
+        -3,
+        &[Admin],
+        NO_KEYS,
+        Group::Server,
+        handlers::psync_command,
+    ),
+    spec(
+        "wait",
+        3,
+        &[],
+        NO_KEYS,
+        Group::Generic,
+        handlers::wait_command,
+    ),
+    spec(
+        "waitaof",
+        4,
+        &[],
+        NO_KEYS,
+        Group::Generic,
+        handlers::waitaof_command,
+    ),
+    spec(
+        "config",
+        -2,
+        &[],
+        NO_KEYS,
+        Group::Server,
+        handlers::config_command,
+    ),
+    spec(
+        "shutdown",
+        -1,
+        &[Admin, Loading, Stale],
+        NO_KEYS,
+        Group::Server,
+        handlers::shutdown_command,
+    ),
+    spec(
+        "info",
+        -1,
+        &[Loading, Stale],
+        NO_KEYS,
+        Group::Server,
+        handlers::info_command,
+    ),
+    spec(
+        "time",
+        1,
+        &[Loading, Stale, Fast],
+        NO_KEYS,
+        Group::Server,
+        handlers::time_command,
+    ),
+    spec(
+        "command",
+        -1,
+        &[Loading, Stale],
+        NO_KEYS,
+        Group::Server,
+        handlers::command_command,
+    ),
+    spec(
+        "debug",
+        -2,
+        &[Admin, Loading, Stale],
+        NO_KEYS,
+        Group::Server,
+        handlers::debug_command,
+    ),
+    spec(
+        "monitor",
+        1,
+        &[Admin, Loading, Stale],
+        NO_KEYS,
+        Group::Server,
+        handlers::handled_by_dispatch,
+    ),
+    spec(
+        "failover",
+        -1,
+        &[Admin, Stale],
+        NO_KEYS,
+        Group::Server,
+        handlers::failover_command,
+    ),
+    spec(
+        "slowlog",
+        -2,
+        &[Admin],
+        NO_KEYS,
+        Group::Server,
+        handlers::slowlog_command,
+    ),
+];
+
+/// Commands whose first argument is a subcommand
+const CONTAINERS: &[&str] = &[
+    "client", "config", "object", "memory", "command", "debug", "acl", "slowlog",
+];
+
+/// Every supported command by its name
+static BY_NAME: LazyLock<HashMap<&'static [u8], &'static CommandSpec>> = LazyLock::new(|| {
+    COMMANDS
+        .iter()
+        .map(|command| (command.name.as_bytes(), command))
+        .collect()
+});
+
+/// Find the command with the given name, in any case
+fn find(name: &[u8]) -> Option<&'static CommandSpec> {
+    BY_NAME.get(name.to_ascii_lowercase().as_slice()).copied()
+}
+
+impl CommandSpec {
+    /// Name of the command, in lowercase
+    pub const fn name(&self) -> &'static str {
+        self.name
+    }
+
+    /// Check whether the command may modify the keyspace
+    pub fn is_write(&self) -> bool {
+        self.flags.contains(&Write)
+    }
+
+    /// Check whether the command may increase the memory used
+    pub fn is_denyoom(&self) -> bool {
+        self.flags.contains(&DenyOom)
+    }
+
+    /// Check whether the command administers the server
+    pub fn is_admin(&self) -> bool {
+        self.flags.contains(&Admin)
+    }
+
+    /// Keys among the arguments of the command, as given by its key positions or else found by `movable_keys`
+    pub fn keys<'a>(&self, parsed_command: &'a [Vec<u8>]) -> Vec<&'a [u8]> {
+        if let Some(keys) = movable_keys(self.name, parsed_command) {
+            return keys;
+        }
+        let (first_key, last_key, step) = self.keys;
+        let len = i64::try_from(parsed_command.len()).unwrap();
+        let last_key = if last_key < 0 {
+            len + last_key
+        } else {
+            last_key
+        };
+        if first_key == 0 || last_key < first_key {
+            return Vec::new();
+        }
+        (first_key..=last_key.min(len - 1))
+            .step_by(usize::try_from(step).unwrap())
+            .map(|i| parsed_command[usize::try_from(i).unwrap()].as_slice())
+            .collect()
+    }
+
+    /// Run the command of the client with its handler, naming the command in the generic `WRONG_ARITY` error
+    pub fn run(
+        &self,
+        server: &Server,
+        client: &mut ClientState,
+        parsed_command: &[Vec<u8>],
+        can_block: bool,
+    ) -> Execution {
+        match (self.handler)(server, client, parsed_command, can_block) {
+            Execution::Reply(reply) => Execution::Reply(name_arity_error(self.name, reply)),
+            execution => execution,
+        }
+    }
+}
+
+/// Error of a command given a wrong number of arguments, which `name_arity_error` completes with the name of the
+/// command
+pub const WRONG_ARITY: &str = "ERR wrong number of arguments for command";
+
+/// Maximum length of the arguments quoted by the error for an unknown command (same as Redis)
+const MAX_QUOTED_ARGS_LENGTH: usize = 128;
+
+/// Reply to a command given a wrong number of arguments; `name` is the lowercase name of the command, followed by
+/// `|` and the name of the subcommand for a subcommand, e.g. `config|get`
+pub fn wrong_arity(name: &str) -> RespValue {
+    RespValue::Error(format!(
+        "ERR wrong number of arguments for '{name}' command"
+    ))
+}
+
+/// Name the command in its reply if it is the generic `WRONG_ARITY` error, like Redis does
+fn name_arity_error(name: &str, reply: RespValue) -> RespValue {
+    match reply {
+        RespValue::Error(ref err) if err == WRONG_ARITY => wrong_arity(name),
+        reply => reply,
+    }
+}
+
+/// Reply to a command which isn't supported, quoting the first of its arguments
+pub fn unknown_command(parsed_command: &[Vec<u8>]) -> RespValue {
+    let mut args = String::new();
+    for arg in &parsed_command[1..] {
+        let Some(room) = MAX_QUOTED_ARGS_LENGTH.checked_sub(args.len()) else {
+            break;
+        };
+        let arg = String::from_utf8_lossy(arg);
+        let quoted: String = arg.chars().take(room).collect();
+        args = args + "'" + &quoted + "' ";
+    }
+    let name: String = String::from_utf8_lossy(&parsed_command[0])
+        .chars()
+        .take(MAX_QUOTED_ARGS_LENGTH)
+        .collect();
+    RespValue::Error(format!(
+        "ERR unknown command '{name}', with args beginning with: {args}"
+    ))
+}
+
+/// Check that the command is supported and has a valid number of arguments, without running it, returning the
+/// command
+pub fn validate(parsed_command: &[Vec<u8>]) -> Result<&'static CommandSpec, RespValue> {
+    let Some(command) = find(&parsed_command[0]) else {
+        return Err(unknown_command(parsed_command));
+    };
+    let arity = command.arity;
+
+    let args_count = i64::try_from(parsed_command.len()).unwrap_or(i64::MAX);
+    let is_valid = if arity < 0 {
+        args_count >= -arity
+    } else {
+        args_count == arity
+    };
+    if is_valid {
+        Ok(command)
+    } else {
+        Err(wrong_arity(command.name))
+    }
+}
+
+/// Full name of the command in lowercase, which includes the subcommand for a container, e.g. `client|list`
+pub fn full_name(parsed_command: &[Vec<u8>]) -> String {
+    let name = String::from_utf8_lossy(&parsed_command[0]).to_lowercase();
+    match parsed_command.get(1) {
+        Some(subcommand) if CONTAINERS.contains(&name.as_str()) => {
+            format!(
+                "{name}|{}",
+                String::from_utf8_lossy(subcommand).to_lowercase()
+            )
+        }
+        _ => name,
+    }
+}
+
+/// ACL categories of the command, derived from its flags and its group like Redis assigns them
+fn categories(command: &CommandSpec) -> Vec<&'static str> {
+    let mut categories = Vec::new();
+    for &flag in command.flags {
+        match flag {
+            Write => categories.push("write"),
+            Readonly => categories.push("read"),
+            Fast => categories.push("fast"),
+            Blocking => categories.push("blocking"),
+            Admin => categories.extend(["admin", "dangerous"]),
+            Pubsub => categories.push("pubsub"),
+            DenyOom | Loading | Stale => {}
+        }
+    }
+    if !command.flags.contains(&Fast) {
+        categories.push("slow");
+    }
+    let group_category = match command.group {
+        Group::Generic => Some("keyspace"),
+        Group::String => Some("string"),
+        Group::Bitmap => Some("bitmap"),
+        Group::List => Some("list"),
+        Group::Hash => Some("hash"),
+        Group::Set => Some("set"),
+        Group::SortedSet => Some("sortedset"),
+        Group::Stream => Some("stream"),
+        Group::Geo => Some("geo"),
+        Group::HyperLogLog => Some("hyperloglog"),
+        Group::Transactions => Some("transaction"),
+        Group::Connection => Some("connection"),
+        Group::Pubsub | Group::Server => None,
+    };
+    categories.extend(group_category);
+    categories
+}
+
+/// Name of the supported command, in lowercase, if it is supported
+pub fn canonical_name(name: &[u8]) -> Option<&'static str> {
+    find(name).map(|command| command.name)
+}
+
+/// Names of the commands of the ACL category, given without `@`, or None if there is no such category
+/// `all` is the category of every command.
+pub fn category_commands(category: &str) -> Option<Vec<&'static str>> {
+    let commands: Vec<&'static str> = COMMANDS
+        .iter()
+        .filter(|&command| category == "all" || categories(command).contains(&category))
+        .map(|command| command.name)
+        .collect();
+    (!commands.is_empty()).then_some(commands)
+}
+
+/// Keys of a command which takes them after their number or after a keyword, or whose subcommand takes one, which
+/// key positions can't describe; None for the other commands
+/// The keys of a command whose arguments are invalid are the ones it would have if the command got that far, e.g.
+/// none for a number of keys which isn't a number.
+fn movable_keys<'a>(name: &str, parsed_command: &'a [Vec<u8>]) -> Option<Vec<&'a [u8]>> {
+    let args = |range: Range<usize>| {
+        parsed_command
+            .get(range.start..range.end.min(parsed_command.len()))
+            .unwrap_or_default()
+            .iter()
+            .map(Vec::as_slice)
+            .collect()
+    };
+    let numkeys_index = match name {
+        "lmpop" | "zmpop" | "sintercard" | "zintercard" => 1,
+        "blmpop" | "bzmpop" => 2,
+        // The keys are the first half of the arguments after STREAMS, the IDs being the second half; the keyword is
+        // looked for after the group and the consumer of XREADGROUP, which may be named STREAMS themselves
+        "xread" | "xreadgroup" => {
+            let options_start = if name == "xread" { 1 } else { 4 };
+            let streams = parsed_command
+                .iter()
+                .skip(options_start)
+                .position(|arg| arg.eq_ignore_ascii_case(b"streams"))
+                .map_or(parsed_command.len(), |i| options_start + i + 1);
+            let streams_count = (parsed_command.len() - streams) / 2;
+            return Some(args(streams..streams + streams_count));
+        }
+        "object" | "memory" => {
+            let subcommand = parsed_command.get(1).map(|arg| arg.to_ascii_lowercase());
+            let takes_key = match subcommand.as_deref() {
+                Some(b"encoding" | b"freq" | b"idletime" | b"refcount") => name == "object",
+                Some(b"usage") => name == "memory",
+                _ => false,
+            };
+            return Some(if takes_key { args(2..3) } else { Vec::new() });
+        }
+        _ => return None,
+    };
+    let numkeys = parsed_command
+        .get(numkeys_index)
+        .and_then(|arg| String::from_utf8_lossy(arg).parse::<usize>().ok())
+        .unwrap_or_default();
+    let first_key = numkeys_index + 1;
+    Some(args(first_key..first_key.saturating_add(numkeys)))
+}
+
+/// Reply to COMMAND GETKEYS: the keys among the arguments of the command given as its arguments
+fn getkeys(args: &[Vec<u8>]) -> RespValue {
+    if find(&args[0]).is_none() {
+        return RespValue::error("ERR Invalid command specified");
+    }
+    let Ok(command) = validate(args) else {
+        return RespValue::error("ERR Invalid number of arguments specified for command");
+    };
+    let keys = command.keys(args);
+    if keys.is_empty() {
+        return RespValue::error("ERR Invalid arguments specified for command");
+    }
+    RespValue::Array(
+        keys.into_iter()
+            .map(|key| RespValue::BulkString(key.to_vec()))
+            .collect(),
+    )
+}
+
+/// Describe the command as COMMAND INFO does: its name, arity, flags and key positions, followed by its ACL
+/// categories, tips, key specifications and subcommands, which are all empty
+fn describe(command: &CommandSpec) -> RespValue {
+    let (first_key, last_key, step) = command.keys;
+    RespValue::Array(vec![
+        RespValue::BulkString(command.name.into()),
+        RespValue::Integer(command.arity),
+        RespValue::Set(
+            command
+                .flags
+                .iter()
+                .map(|flag| RespValue::simple(flag.name()))
+                .collect(),
+        ),
+        RespValue::Integer(first_key),
+        RespValue::Integer(last_key),
+        RespValue::Integer(step),
+        RespValue::Set(Vec::new()),
+        RespValue::Set(Vec::new()),
+        RespValue::Array(Vec::new()),
+        RespValue::Array(Vec::new()),
+    ])
+}
+
+/// Lines of COMMAND HELP
+const HELP: &[&str] = &[
+    "(no subcommand)",
+    "    Return details about all Redis commands.",
+    "COUNT",
+    "    Return the total number of commands in this Redis server.",
+    "DOCS [<command-name> ...]",
+    "    Return documentation details about multiple Redis commands.",
+    "    If no command names are given, documentation details for all",
+    "    commands are returned.",
+    "GETKEYS <full-command>",
+    "    Return the keys from a full Redis command.",
+    "INFO [<command-name> ...]",
+    "    Return details about multiple Redis commands.",
+    "    If no command names are given, documentation details for all",
+    "    commands are returned.",
+];
+
+/// Compute output of the COMMAND command and its COUNT, INFO, DOCS, GETKEYS and HELP subcommands
+/// INFO and DOCS describe all the commands if none is given; INFO replies null for an unknown command while DOCS
+/// skips it. The documentation only has the group of every command.
+pub fn command(parsed_command: &[Vec<u8>]) -> RespValue {
+    let Some(subcommand) = parsed_command.get(1) else {
+        return RespValue::Array(COMMANDS.iter().map(describe).collect());
+    };
+    let names = &parsed_command[2..];
+    match String::from_utf8_lossy(subcommand).to_lowercase().as_str() {
+        "count" if names.is_empty() => RespValue::Integer(i64::try_from(COMMANDS.len()).unwrap()),
+        "info" if names.is_empty() => RespValue::Array(COMMANDS.iter().map(describe).collect()),
+        "info" => RespValue::Array(
+            names
+                .iter()
+                .map(|name| find(name).map_or(RespValue::NullArray, describe))
+                .collect(),
+        ),
+        "docs" => {
+            let commands: Vec<_> = if names.is_empty() {
+                COMMANDS.iter().collect()
+            } else {
+                names.iter().filter_map(|name| find(name)).collect()
+            };
+            let docs = commands.into_iter().map(|command| {
+                let group = (
+                    RespValue::BulkString(b"group".to_vec()),
+                    RespValue::BulkString(command.group.name().into()),
+                );
+                (
+                    RespValue::BulkString(command.name.into()),
+                    RespValue::Map(vec![group]),
+                )
+            });
+            RespValue::Map(docs.collect())
+        }
+        "getkeys" if !names.is_empty() => getkeys(names),
+        "help" if names.is_empty() => RespValue::help("COMMAND", HELP),
+        "count" => wrong_arity("command|count"),
+        "help" => wrong_arity("command|help"),
+        "getkeys" => wrong_arity("command|getkeys"),
+        _ => RespValue::Error(format!(
+            "ERR unknown subcommand '{}'. Try COMMAND HELP.",
+            String::from_utf8_lossy(subcommand)
+        )),
+    }
+}
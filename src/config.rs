@@ -0,0 +1,506 @@
+//! Server configuration, given on the command line and changed at runtime with CONFIG SET
+//! The parameters are named like those of Redis; the command line options are the parameter names prefixed by `--`.
+
+use std::path::PathBuf;
+
+use crate::{encoding::ListpackLimits, glob, notify::NotifyFlags, output::OutputLimits, replicas};
+
+/// Names of the parameters, in the order CONFIG GET lists them
+const PARAMETERS: [&str; 29] = [
+    "port",
+    "unixsocket",
+    "databases",
+    "io-threads",
+    "dir",
+    "dbfilename",
+    "appendonly",
+    "appendfilename",
+    "appendfsync",
+    "replicaof",
+    "notify-keyspace-events",
+    "maxmemory",
+    "maxmemory-policy",
+    "save",
+    "hash-max-listpack-entries",
+    "hash-max-listpack-value",
+    "zset-max-listpack-entries",
+    "zset-max-listpack-value",
+    "set-max-intset-entries",
+    "set-max-listpack-entries",
+    "set-max-listpack-value",
+    "list-max-listpack-size",
+    "requirepass",
+    "timeout",
+    "tcp-keepalive",
+    "client-output-buffer-limit",
+    "repl-backlog-size",
+    "slowlog-log-slower-than",
+    "slowlog-max-len",
+];
+
+/// Parameters which the server is set up with on startup, so they can't be changed by CONFIG SET
+const IMMUTABLE_PARAMETERS: [&str; 8] = [
+    "port",
+    "unixsocket",
+    "databases",
+    "io-threads",
+    "appendonly",
+    "appendfilename",
+    "appendfsync",
+    "replicaof",
+];
+
+/// Configuration of the server
+#[derive(Clone)]
+pub struct Config {
+    /// Port to listen on over TCP, or 0 not to listen over TCP
+    pub port: u16,
+    /// Path of the Unix socket to listen on as well, if any
+    pub unixsocket: Option<PathBuf>,
+    /// Number of logical databases, which are selected by their index from 0
+    pub databases: usize,
+    /// Number of threads serving the connections, or 0 for one per CPU core
+    pub io_threads: usize,
+    /// Directory in which the RDB file is stored
+    pub dir: PathBuf,
+    /// Name of the RDB file
+    pub dbfilename: String,
+    /// Whether write commands are logged to the AOF, which is then loaded on startup instead of the RDB file
+    pub appendonly: bool,
+    /// Name of the AOF, stored in `dir` as well
+    pub appendfilename: String,
+    /// How often the AOF is flushed to the disk
+    pub appendfsync: AppendFsync,
+    /// Host and port of the master, if the server is a replica
+    pub replicaof: Option<(String, u16)>,
+    /// Keyspace events which are published to Pub/Sub channels
+    pub notify_keyspace_events: NotifyFlags,
+    /// Limit of the memory used for the data in bytes, or 0 for no limit
+    pub maxmemory: u64,
+    /// How keys are evicted once `maxmemory` is reached
+    pub maxmemory_policy: MaxMemoryPolicy,
+    /// Save points as pairs of seconds and changes: a snapshot is taken in the background once the keyspace had that
+    /// many changes and that many seconds elapsed since the last snapshot; unlike Redis there are none by default
+    pub save_points: Vec<(u64, u64)>,
+    /// Limits up to which values are reported to be encoded as listpacks
+    pub listpack_limits: ListpackLimits,
+    /// Password which the clients must give with AUTH before running other commands, or empty for no password
+    pub requirepass: String,
+    /// Seconds after which a client waiting idly for its next command is disconnected, or 0 to never disconnect
+    /// idle clients
+    pub timeout: u64,
+    /// Seconds of inactivity after which TCP keepalive probes are sent to detect dead peers, or 0 not to send any
+    pub tcp_keepalive: u64,
+    /// Bytes which may be queued for a client of every class before it is disconnected
+    pub client_output_buffer_limit: OutputLimits,
+    /// Bytes of the replication stream kept for the replicas which reconnect to continue it
+    pub repl_backlog_size: usize,
+    /// Microseconds a command must take to run to be logged in the slow log; 0 logs every command and a negative
+    /// value none
+    pub slowlog_log_slower_than: i64,
+    /// Number of entries the slow log holds
+    pub slowlog_max_len: usize,
+}
+
+/// Policy for flushing the AOF to the disk, trading durability for throughput
+#[derive(Clone, Copy, PartialEq, Eq)]
+pub enum AppendFsync {
+    /// After every write command, so that no acknowledged write is ever lost
+    Always,
+    /// Once every second in the background, so that at most a second of writes is lost
+    EverySec,
+    /// Whenever the operating system decides to
+    No,
+}
+
+/// Policy for evicting keys once `maxmemory` is reached
+#[derive(Clone, Copy, PartialEq, Eq)]
+pub enum MaxMemoryPolicy {
+    /// Nothing is evicted; the write commands fail instead
+    NoEviction,
+    /// The least recently used keys
+    AllKeysLru,
+    /// The least recently used keys with an expiry
+    VolatileLru,
+    /// The least frequently used keys
+    AllKeysLfu,
+    /// The least frequently used keys with an expiry
+    VolatileLfu,
+    /// Random keys
+    AllKeysRandom,
+    /// Random keys with an expiry
+    VolatileRandom,
+    /// The keys with an expiry which expire the soonest
+    VolatileTtl,
+}
+
+impl MaxMemoryPolicy {
+    /// All the policies along with their names
+    const NAMES: [(Self, &'static str); 8] = [
+        (Self::NoEviction, "noeviction"),
+        (Self::AllKeysLru, "allkeys-lru"),
+        (Self::VolatileLru, "volatile-lru"),
+        (Self::AllKeysLfu, "allkeys-lfu"),
+        (Self::VolatileLfu, "volatile-lfu"),
+        (Self::AllKeysRandom, "allkeys-random"),
+        (Self::VolatileRandom, "volatile-random"),
+        (Self::VolatileTtl, "volatile-ttl"),
+    ];
+
+    /// Whether the policy only evicts the keys with an expiry
+    pub const fn is_volatile(self) -> bool {
+        matches!(
+            self,
+            Self::VolatileLru | Self::VolatileLfu | Self::VolatileRandom | Self::VolatileTtl
+        )
+    }
+
+    /// Whether the policy evicts by access frequency, which OBJECT FREQ then replies
+    pub const fn is_lfu(self) -> bool {
+        matches!(self, Self::AllKeysLfu | Self::VolatileLfu)
+    }
+
+    /// Name of the policy
+    fn name(self) -> &'static str {
+        Self::NAMES
+            .iter()
+            .find(|&&(policy, _)| policy == self)
+            .map(|&(_, name)| name)
+            .unwrap()
+    }
+}
+
+impl Default for Config {
+    fn default() -> Self {
+        Self {
+            port: 6379,
+            unixsocket: None,
+            databases: 16,
+            io_threads: 0,
+            dir: PathBuf::from("."),
+            dbfilename: "dump.rdb".to_string(),
+            appendonly: false,
+            appendfilename: "appendonly.aof".to_string(),
+            appendfsync: AppendFsync::EverySec,
+            replicaof: None,
+            notify_keyspace_events: NotifyFlags::default(),
+            maxmemory: 0,
+            maxmemory_policy: MaxMemoryPolicy::NoEviction,
+            save_points: Vec::new(),
+            listpack_limits: ListpackLimits::default(),
+            requirepass: String::new(),
+            timeout: 0,
+            tcp_keepalive: 300,
+            client_output_buffer_limit: OutputLimits::default(),
+            repl_backlog_size: replicas::DEFAULT_BACKLOG_SIZE,
+            slowlog_log_slower_than: 10_000,
+            slowlog_max_len: 128,
+        }
+    }
+}
+
+impl Config {
+    /// Parse the command line arguments, excluding the program name
+    /// The port may be given as the first argument on its own, or with `--port` like the other options.
+    pub fn from_args(args: impl IntoIterator<Item = String>) -> Result<Self, String> {
+        let mut config = Self::default();
+        let mut args = args.into_iter().peekable();
+
+        if let Some(port) = args.next_if(|arg| !arg.starts_with("--")) {
+            config.port = parse_port(&port).map_err(|err| format!("{err} '{port}'"))?;
+        }
+        while let Some(option) = args.next() {
+            let mut value = args
+                .next()
+                .ok_or_else(|| format!("missing value for option '{option}'"))?;
+            let name = option
+                .strip_prefix("--")
+                .filter(|name| PARAMETERS.contains(name))
+                .ok_or_else(|| format!("unknown option '{option}'"))?;
+            // The host and the port may be given as a single argument, e.g. `--replicaof "localhost 6379"`
+            if name == "replicaof" && value.split_whitespace().nth(1).is_none() {
+                let port = args
+                    .next()
+                    .ok_or_else(|| format!("missing master port for option '{option}'"))?;
+                value = format!("{value} {port}");
+            }
+            config
+                .apply(name, &value)
+                .map_err(|err| format!("invalid value '{value}' for option '{option}': {err}"))?;
+        }
+        Ok(config)
+    }
+
+    /// Path of the RDB file
+    pub fn db_path(&self) -> PathBuf {
+        self.dir.join(&self.dbfilename)
+    }
+
+    /// Path of the AOF
+    pub fn aof_path(&self) -> PathBuf {
+        self.dir.join(&self.appendfilename)
+    }
+
+    /// Names and values of the parameters whose names match any of the glob-style patterns, as CONFIG GET replies
+    pub fn matching(&self, patterns: &[Vec<u8>]) -> Vec<(&'static str, String)> {
+        PARAMETERS
+            .into_iter()
+            .filter(|name| {
+                patterns
+                    .iter()
+                    .any(|pattern| glob::matches(&pattern.to_ascii_lowercase(), name.as_bytes()))
+            })
+            .map(|name| (name, self.get(name)))
+            .collect()
+    }
+
+    /// Value of a parameter, formatted like CONFIG GET replies it
+    fn get(&self, name: &str) -> String {
+        match name {
+            "port" => self.port.to_string(),
+            "unixsocket" => self
+                .unixsocket
+                .as_ref()
+                .map_or_else(String::new, |path| path.display().to_string()),
+            "databases" => self.databases.to_string(),
+            "io-threads" => self.io_threads.to_string(),
+            "dir" => self.dir.display().to_string(),
+            "dbfilename" => self.dbfilename.clone(),
+            "appendonly" => if self.appendonly { "yes" } else { "no" }.to_string(),
+            "appendfilename" => self.appendfilename.clone(),
+            "appendfsync" => match self.appendfsync {
+                AppendFsync::Always => "always",
+                AppendFsync::EverySec => "everysec",
+                AppendFsync::No => "no",
+            }
+            .to_string(),
+            "replicaof" => self
+                .replicaof
+                .as_ref()
+                .map_or_else(String::new, |&(ref host, port)| format!("{host} {port}")),
+            "notify-keyspace-events" => self.notify_keyspace_events.to_string(),
+            "maxmemory" => self.maxmemory.to_string(),
+            "maxmemory-policy" => self.maxmemory_policy.name().to_string(),
+            "save" => self
+                .save_points
+                .iter()
+                .map(|&(seconds, changes)| format!("{seconds} {changes}"))
+                .collect::<Vec<_>>()
+                .join(" "),
+            "hash-max-listpack-entries" => self.listpack_limits.hash_entries.to_string(),
+            "hash-max-listpack-value" => self.listpack_limits.hash_value.to_string(),
+            "zset-max-listpack-entries" => self.listpack_limits.zset_entries.to_string(),
+            "zset-max-listpack-value" => self.listpack_limits.zset_value.to_string(),
+            "set-max-intset-entries" => self.listpack_limits.set_intset_entries.to_string(),
+            "set-max-listpack-entries" => self.listpack_limits.set_entries.to_string(),
+            "set-max-listpack-value" => self.listpack_limits.set_value.to_string(),
+            "list-max-listpack-size" => self.listpack_limits.list_size.to_string(),
+            "requirepass" => self.requirepass.clone(),
+            "timeout" => self.timeout.to_string(),
+            "tcp-keepalive" => self.tcp_keepalive.to_string(),
+            "client-output-buffer-limit" => self.client_output_buffer_limit.to_string(),
+            "repl-backlog-size" => self.repl_backlog_size.to_string(),
+            "slowlog-log-slower-than" => self.slowlog_log_slower_than.to_string(),
+            "slowlog-max-len" => self.slowlog_max_len.to_string(),
+            _ => unreachable!("unknown parameter '{name}'"),
+        }
+    }
+
+    /// Set parameters from the alternating names and values, as CONFIG SET does; either all of them are set or
+    /// none is
+    pub fn set(&mut self, args: &[Vec<u8>]) -> Result<(), String> {
+        let mut config = self.clone();
+        for pair in args.chunks_exact(2) {
+            let (name, value) = (String::from_utf8_lossy(&pair[0]).to_lowercase(), &pair[1]);
+            let Some(&name) = PARAMETERS.iter().find(|&&parameter| parameter == name) else {
+                return Err(format!(
+                    "ERR Unknown option or number of arguments for CONFIG SET - '{name}'"
+                ));
+            };
+            let failed = |err: &str| {
+                format!("ERR CONFIG SET failed (possibly related to argument '{name}') - {err}")
+            };
+            if IMMUTABLE_PARAMETERS.contains(&name) {
+                return Err(failed("can't set immutable config"));
+            }
+            config
+                .apply(name, &String::from_utf8_lossy(value))
+                .map_err(failed)?;
+        }
+        *self = config;
+        Ok(())
+    }
+
+    /// Set a parameter from its value, which is validated
+    fn apply(&mut self, name: &str, value: &str) -> Result<(), &'static str> {
+        if let Some(limit) = self.listpack_limits.size_mut(name) {
+            *limit = parse_size(value)?;
+            return Ok(());
+        }
+        match name {
+            "port" => self.port = parse_port(value)?,
+            "unixsocket" => self.unixsocket = (!value.is_empty()).then(|| PathBuf::from(value)),
+            "databases" => {
+                self.databases = value
+                    .parse()
+                    .ok()
+                    .filter(|databases| (1..=2_147_483_647).contains(databases))
+                    .ok_or("argument must be between 1 and 2147483647 inclusive")?;
+            }
+            "io-threads" => {
+                self.io_threads = value
+                    .parse()
+                    .ok()
+                    .filter(|&io_threads| io_threads <= 128)
+                    .ok_or("argument must be between 0 and 128 inclusive")?;
+            }
+            "dir" => {
+                let dir = PathBuf::from(value);
+                if !dir.is_dir() {
+                    return Err("No such file or directory");
+                }
+                self.dir = dir;
+            }
+            // The files are always stored in `dir`
+            "dbfilename" if value.contains('/') => {
+                return Err("dbfilename can't be a path, just a filename")
+            }
+            "dbfilename" => self.dbfilename = value.to_string(),
+            "appendfilename" if value.contains('/') => {
+                return Err("appendfilename can't be a path, just a filename")
+            }
+            "appendfilename" => self.appendfilename = value.to_string(),
+            "appendonly" => self.appendonly = parse_yes_no(value)?,
+            "appendfsync" => {
+                self.appendfsync = match value.to_lowercase().as_str() {
+                    "always" => AppendFsync::Always,
+                    "everysec" => AppendFsync::EverySec,
+                    "no" => AppendFsync::No,
+                    _ => {
+                        return Err(
+                            "argument(s) must be one of the following: always, everysec, no",
+                        )
+                    }
+                };
+            }
+            "replicaof" => {
+                let mut master = value.split_whitespace();
+                let (Some(host), Some(port), None) = (master.next(), master.next(), master.next())
+                else {
+                    return Err("the master must be given as '<host> <port>'");
+                };
+                self.replicaof = Some((host.to_owned(), parse_port(port)?));
+            }
+            "notify-keyspace-events" => {
+                self.notify_keyspace_events = NotifyFlags::parse(value)
+                    .ok_or("Invalid event class character. Use 'Ag$lshzxet'.")?;
+            }
+            "maxmemory" => self.maxmemory = parse_memory(value)?,
+            "maxmemory-policy" => {
+                self.maxmemory_policy = MaxMemoryPolicy::NAMES
+                    .iter()
+                    .find(|&&(_, policy_name)| value.eq_ignore_ascii_case(policy_name))
+                    .map(|&(policy, _)| policy)
+                    .ok_or("argument(s) must be one of the following: noeviction, allkeys-lru, volatile-lru, allkeys-lfu, volatile-lfu, allkeys-random, volatile-random, volatile-ttl")?;
+            }
+            "save" => self.save_points = parse_save_points(value)?,
+            "list-max-listpack-size" => {
+                self.listpack_limits.list_size = value
+                    .parse()
+                    .ok()
+                    .filter(|&list_size| list_size >= -5)
+                    .ok_or("argument must be between -5 and 9223372036854775807 inclusive")?;
+            }
+            "requirepass" => value.clone_into(&mut self.requirepass),
+            "timeout" => self.timeout = parse_seconds(value)?,
+            "tcp-keepalive" => self.tcp_keepalive = parse_seconds(value)?,
+            "client-output-buffer-limit" => self.client_output_buffer_limit.parse(value)?,
+            "repl-backlog-size" => {
+                self.repl_backlog_size = parse_memory(value)
+                    .ok()
+                    .filter(|&size| size >= 1)
+                    .and_then(|size| usize::try_from(size).ok())
+                    .ok_or("argument must be between 1 and 9223372036854775807 inclusive")?;
+            }
+            "slowlog-log-slower-than" => {
+                self.slowlog_log_slower_than = value
+                    .parse()
+                    .map_err(|_| "argument couldn't be parsed into an integer")?;
+            }
+            "slowlog-max-len" => self.slowlog_max_len = parse_size(value)?,
+            _ => unreachable!("unknown parameter '{name}'"),
+        }
+        Ok(())
+    }
+}
+
+/// Parse a port number
+fn parse_port(port: &str) -> Result<u16, &'static str> {
+    port.parse().map_err(|_| "invalid port")
+}
+
+/// Parse the value of a boolean parameter
+fn parse_yes_no(value: &str) -> Result<bool, &'static str> {
+    match value.to_lowercase().as_str() {
+        "yes" => Ok(true),
+        "no" => Ok(false),
+        _ => Err("argument must be 'yes' or 'no'"),
+    }
+}
+
+/// Parse a non-negative number of elements or bytes
+fn parse_size(value: &str) -> Result<usize, &'static str> {
+    value
+        .parse()
+        .map_err(|_| "argument couldn't be parsed into an integer")
+}
+
+/// Parse a duration in seconds, which Redis bounds like a 32-bit integer
+fn parse_seconds(value: &str) -> Result<u64, &'static str> {
+    value
+        .parse()
+        .ok()
+        .filter(|&seconds| seconds <= 2_147_483_647)
+        .ok_or("argument must be between 0 and 2147483647 inclusive")
+}
+
+/// Parse save points given as `<seconds> <changes>` pairs, e.g. `3600 1 300 100`, or none if empty
+fn parse_save_points(value: &str) -> Result<Vec<(u64, u64)>, &'static str> {
+    let numbers = value
+        .split_whitespace()
+        .map(str::parse)
+        .collect::<Result<Vec<u64>, _>>()
+        .map_err(|_| "Invalid save parameters")?;
+    if !numbers.len().is_multiple_of(2) {
+        return Err("Invalid save parameters");
+    }
+    Ok(numbers
+        .chunks_exact(2)
+        .map(|pair| (pair[0], pair[1]))
+        .collect())
+}
+
+/// Parse an amount of memory in bytes, optionally with a unit like Redis accepts, e.g. `100mb` or `1g`
+/// The units without `b` are powers of 1000, and the ones with it are powers of 1024.
+pub fn parse_memory(value: &str) -> Result<u64, &'static str> {
+    let value = value.to_lowercase();
+    let digits_end = value
+        .find(|c: char| !c.is_ascii_digit())
+        .unwrap_or(value.len());
+    let (amount, unit) = value.split_at(digits_end);
+    let multiplier: u64 = match unit {
+        "" | "b" => 1,
+        "k" => 1000,
+        "kb" => 1024,
+        "m" => 1000 * 1000,
+        "mb" => 1024 * 1024,
+        "g" => 1000 * 1000 * 1000,
+        "gb" => 1024 * 1024 * 1024,
+        _ => return Err("argument must be a memory value"),
+    };
+    amount
+        .parse::<u64>()
+        .ok()
+        .and_then(|amount| amount.checked_mul(multiplier))
+        .ok_or("argument must be a memory value")
+}
@@ -0,0 +1,896 @@
+//! Consumer groups of streams, which deliver every entry to only one of their consumers and keep track of the
+//! entries delivered but not acknowledged yet, i.e. the pending entries list (PEL)
+
+use std::{
+    collections::{BTreeMap, BTreeSet},
+    ops::Bound,
+    sync::{Arc, Mutex},
+};
+
+use crate::{
+    blocking::{BlockedClients, Pop},
+    command::WRONG_ARITY,
+    notify::EventClass,
+    parse_redis_int,
+    resp::RespValue,
+    store::KeyValStore,
+    stream::{self, Fields, ReadArgs, Stream, StreamId, StreamsEntries, Xread, INVALID_COUNT},
+};
+
+/// Error of XGROUP on a key which doesn't exist
+const NO_KEY: &str = "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use \
+                      the MKSTREAM option to create an empty stream automatically.";
+
+/// Entry delivered to a consumer of a group, which it didn't acknowledge yet
+#[derive(Clone)]
+pub struct PendingEntry {
+    /// Name of the consumer which the entry is pending for
+    pub consumer: Vec<u8>,
+    /// Unix time in milliseconds of the last delivery of the entry
+    pub delivery_time_ms: u64,
+    /// Number of times the entry was delivered
+    pub delivery_count: u64,
+}
+
+/// Consumer of a group
+#[derive(Clone, Default)]
+pub struct Consumer {
+    /// Unix time in milliseconds of the last time the consumer tried to read or claim entries
+    pub seen_time_ms: u64,
+    /// Unix time in milliseconds of the last time the consumer got entries, if it ever did
+    pub active_time_ms: Option<u64>,
+    /// IDs of the entries pending for the consumer
+    pub pending: BTreeSet<StreamId>,
+}
+
+/// Consumer group of a stream
+#[derive(Clone)]
+pub struct ConsumerGroup {
+    /// ID of the last entry delivered to the group; the entries after it were never delivered
+    last_delivered_id: StreamId,
+    /// Entries delivered to the consumers and not acknowledged yet, by their IDs
+    pending: BTreeMap<StreamId, PendingEntry>,
+    /// Consumers by their names
+    consumers: BTreeMap<Vec<u8>, Consumer>,
+}
+
+impl ConsumerGroup {
+    /// Create a group without consumers, which delivers the entries after the ID
+    const fn new(last_delivered_id: StreamId) -> Self {
+        Self {
+            last_delivered_id,
+            pending: BTreeMap::new(),
+            consumers: BTreeMap::new(),
+        }
+    }
+
+    /// Assemble a group from the ID of its last delivered entry, its pending entries and its consumers, whose pending
+    /// entries must be the ones pending for them in the group
+    pub const fn from_parts(
+        last_delivered_id: StreamId,
+        pending: BTreeMap<StreamId, PendingEntry>,
+        consumers: BTreeMap<Vec<u8>, Consumer>,
+    ) -> Self {
+        Self {
+            last_delivered_id,
+            pending,
+            consumers,
+        }
+    }
+
+    /// ID of the last entry delivered to the group
+    pub const fn last_delivered_id(&self) -> StreamId {
+        self.last_delivered_id
+    }
+
+    /// Entries pending for the consumers, by their IDs
+    pub const fn pending(&self) -> &BTreeMap<StreamId, PendingEntry> {
+        &self.pending
+    }
+
+    /// Consumers by their names
+    pub const fn consumers(&self) -> &BTreeMap<Vec<u8>, Consumer> {
+        &self.consumers
+    }
+
+    /// Record that the consumer tried to get entries, creating it if it doesn't exist; returns whether it was created
+    fn touch_consumer(&mut self, name: &[u8], now_ms: u64) -> bool {
+        let is_created = !self.consumers.contains_key(name);
+        self.consumers
+            .entry(name.to_vec())
+            .or_default()
+            .seen_time_ms = now_ms;
+        is_created
+    }
+
+    /// Deliver the entry to the consumer, which must exist, taking it from the consumer it was pending for if any
+    fn deliver(
+        &mut self,
+        id: StreamId,
+        consumer: &[u8],
+        delivery_time_ms: u64,
+        delivery_count: u64,
+    ) {
+        let pending_entry = PendingEntry {
+            consumer: consumer.to_vec(),
+            delivery_time_ms,
+            delivery_count,
+        };
+        if let Some(previous) = self.pending.insert(id, pending_entry) {
+            if let Some(owner) = self.consumers.get_mut(&previous.consumer) {
+                owner.pending.remove(&id);
+            }
+        }
+        if let Some(owner) = self.consumers.get_mut(consumer) {
+            owner.pending.insert(id);
+        }
+    }
+
+    /// Record that entries were delivered to the consumer
+    fn mark_active(&mut self, consumer: &[u8], now_ms: u64) {
+        if let Some(consumer) = self.consumers.get_mut(consumer) {
+            consumer.active_time_ms = Some(now_ms);
+        }
+    }
+
+    /// Acknowledge the entry, returning whether it was pending
+    fn ack(&mut self, id: StreamId) -> bool {
+        let Some(pending_entry) = self.pending.remove(&id) else {
+            return false;
+        };
+        if let Some(owner) = self.consumers.get_mut(&pending_entry.consumer) {
+            owner.pending.remove(&id);
+        }
+        true
+    }
+
+    /// Remove the consumer along with its pending entries, returning how many it had, or None if it doesn't exist
+    fn remove_consumer(&mut self, name: &[u8]) -> Option<usize> {
+        let consumer = self.consumers.remove(name)?;
+        for id in &consumer.pending {
+            self.pending.remove(id);
+        }
+        Some(consumer.pending.len())
+    }
+}
+
+/// Error of a key or a group which doesn't exist
+fn no_group(key: &[u8], group: &[u8]) -> String {
+    format!(
+        "NOGROUP No such key '{}' or consumer group '{}'",
+        String::from_utf8_lossy(key),
+        String::from_utf8_lossy(group)
+    )
+}
+
+/// Parse the ID of the last delivered entry given to XGROUP CREATE/SETID; None for `$`, i.e. the last entry
+fn parse_last_delivered_id(arg: &[u8]) -> Result<Option<StreamId>, &'static str> {
+    match arg {
+        b"$" => Ok(None),
+        id => StreamId::parse(id, 0).map(Some),
+    }
+}
+
+/// Group with the name of the stream at the key, if both exist
+fn group_mut<'a>(
+    store: &'a mut KeyValStore,
+    key: &[u8],
+    group: &[u8],
+) -> Result<Option<&'a mut ConsumerGroup>, &'static str> {
+    Ok(store
+        .get_typed_mut::<Stream>(key)?
+        .and_then(|stream| stream.groups_mut().get_mut(group)))
+}
+
+/// Lines of XGROUP HELP
+const HELP: &[&str] = &[
+    "CREATE <key> <groupname> <id|$> [option]",
+    "    Create a new consumer group. Options are:",
+    "    * MKSTREAM",
+    "      Create the empty stream if it does not exist.",
+    "CREATECONSUMER <key> <groupname> <consumer>",
+    "    Create a new consumer in the specified group.",
+    "DELCONSUMER <key> <groupname> <consumer>",
+    "    Remove the specified consumer.",
+    "DESTROY <key> <groupname>",
+    "    Remove the specified group.",
+    "SETID <key> <groupname> <id|$>",
+    "    Set the current group ID.",
+];
+
+/// XGROUP CREATE/SETID/DESTROY/CREATECONSUMER/DELCONSUMER/HELP: manage the consumer groups of a stream and their
+/// consumers
+/// CREATE with `MKSTREAM` creates an empty stream if the key doesn't exist.
+pub fn xgroup(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<RespValue, String> {
+    let Some(subcommand) = parsed_command.get(1) else {
+        return Err(WRONG_ARITY.to_owned());
+    };
+    let subcommand = String::from_utf8_lossy(subcommand).to_lowercase();
+    let len = parsed_command.len();
+    let is_arity_valid = match subcommand.as_str() {
+        "create" => len == 5 || len == 6,
+        "setid" | "createconsumer" | "delconsumer" => len == 5,
+        "destroy" => len == 4,
+        "help" if len == 2 => return Ok(RespValue::help("XGROUP", HELP)),
+        _ => {
+            return Err(format!(
+                "ERR unknown subcommand '{}'. Try XGROUP HELP.",
+                String::from_utf8_lossy(&parsed_command[1])
+            ))
+        }
+    };
+    if !is_arity_valid {
+        return Err(format!(
+            "ERR wrong number of arguments for 'xgroup|{subcommand}' command"
+        ));
+    }
+    let (key, group_name) = (&parsed_command[2], &parsed_command[3]);
+    let is_mkstream = match parsed_command.get(5) {
+        Some(option) if option.eq_ignore_ascii_case(b"mkstream") => true,
+        Some(_) => return Err("ERR syntax error".to_owned()),
+        None => false,
+    };
+    let last_delivered_id = match subcommand.as_str() {
+        "create" | "setid" => parse_last_delivered_id(&parsed_command[4])?,
+        _ => None,
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let stream = if is_mkstream {
+        store.get_or_insert_typed::<Stream>(key)?
+    } else {
+        store.get_typed_mut::<Stream>(key)?.ok_or(NO_KEY)?
+    };
+    let last_delivered_id = last_delivered_id.unwrap_or_else(|| stream.last_id());
+    let no_consumer_group = || {
+        format!(
+            "NOGROUP No such consumer group '{}' for key name '{}'",
+            String::from_utf8_lossy(group_name),
+            String::from_utf8_lossy(key)
+        )
+    };
+    let (reply, event) = match subcommand.as_str() {
+        "create" => {
+            if stream.groups().contains_key(group_name.as_slice()) {
+                return Err("BUSYGROUP Consumer Group name already exists".to_owned());
+            }
+            stream
+                .groups_mut()
+                .insert(group_name.clone(), ConsumerGroup::new(last_delivered_id));
+            (RespValue::simple("OK"), Some("xgroup-create"))
+        }
+        "setid" => {
+            let group = stream
+                .groups_mut()
+                .get_mut(group_name.as_slice())
+                .ok_or_else(no_consumer_group)?;
+            group.last_delivered_id = last_delivered_id;
+            (RespValue::simple("OK"), Some("xgroup-setid"))
+        }
+        "destroy" => match stream.groups_mut().remove(group_name.as_slice()) {
+            Some(_) => (RespValue::Integer(1), Some("xgroup-destroy")),
+            None => (RespValue::Integer(0), None),
+        },
+        "createconsumer" => {
+            let group = stream
+                .groups_mut()
+                .get_mut(group_name.as_slice())
+                .ok_or_else(no_consumer_group)?;
+            if group.touch_consumer(&parsed_command[4], stream::now_ms()) {
+                (RespValue::Integer(1), Some("xgroup-createconsumer"))
+            } else {
+                (RespValue::Integer(0), None)
+            }
+        }
+        _ => {
+            let group = stream
+                .groups_mut()
+                .get_mut(group_name.as_slice())
+                .ok_or_else(no_consumer_group)?;
+            group.remove_consumer(&parsed_command[4]).map_or(
+                (RespValue::Integer(0), None),
+                |pending| {
+                    (
+                        RespValue::Integer(i64::try_from(pending).unwrap()),
+                        Some("xgroup-delconsumer"),
+                    )
+                },
+            )
+        }
+    };
+    if let Some(event) = event {
+        store.notify(EventClass::Stream, event, key);
+    }
+    drop(store);
+    Ok(reply)
+}
+
+/// ID given to XREADGROUP for a stream
+#[derive(Clone, Copy)]
+enum GroupReadId {
+    /// `>`: the entries never delivered to the group
+    New,
+    /// The entries pending for the consumer, after the ID
+    History(StreamId),
+}
+
+/// Group and consumer which XREADGROUP reads for, along with the options of the read
+pub struct GroupRead {
+    /// Name of the group
+    group: Vec<u8>,
+    /// Name of the consumer
+    consumer: Vec<u8>,
+    /// Maximum number of entries read from every stream, if any
+    count: Option<usize>,
+    /// Whether the entries delivered are acknowledged right away, i.e. they don't become pending
+    noack: bool,
+}
+
+impl GroupRead {
+    /// Read the entries of the stream at the key for the consumer, which is created if it doesn't exist
+    /// For `>` these are the entries never delivered to the group, which are delivered to the consumer. Otherwise
+    /// these are the entries pending for the consumer after the ID, which are delivered again. None if the stream or
+    /// the group doesn't exist.
+    fn read(
+        &self,
+        store: &mut KeyValStore,
+        key: &[u8],
+        id: GroupReadId,
+        now_ms: u64,
+    ) -> Result<Option<Vec<(StreamId, Fields)>>, &'static str> {
+        let Some((group, entries)) = store
+            .get_typed_mut::<Stream>(key)?
+            .and_then(|stream| stream.group_with_entries(&self.group))
+        else {
+            return Ok(None);
+        };
+        let is_created = group.touch_consumer(&self.consumer, now_ms);
+        let count = self.count.unwrap_or(usize::MAX);
+        let read: Vec<_> = match id {
+            GroupReadId::New => entries
+                .range((Bound::Excluded(group.last_delivered_id), Bound::Unbounded))
+                .take(count)
+                .map(|(&entry_id, fields)| (entry_id, fields.clone()))
+                .collect(),
+            // Entries pending for the consumer which no longer exist are left out
+            GroupReadId::History(after) => group.consumers[self.consumer.as_slice()]
+                .pending
+                .range((Bound::Excluded(after), Bound::Unbounded))
+                .filter_map(|&entry_id| Some((entry_id, entries.get(&entry_id)?.clone())))
+                .take(count)
+                .collect(),
+        };
+        for &(entry_id, _) in &read {
+            match id {
+                GroupReadId::New => {
+                    group.last_delivered_id = entry_id;
+                    if !self.noack {
+                        group.deliver(entry_id, &self.consumer, now_ms, 1);
+                    }
+                }
+                GroupReadId::History(_) => {
+                    let delivery_count = group.pending[&entry_id].delivery_count + 1;
+                    group.deliver(entry_id, &self.consumer, now_ms, delivery_count);
+                }
+            }
+        }
+        if !read.is_empty() {
+            group.mark_active(&self.consumer, now_ms);
+        }
+        if is_created {
+            store.notify(EventClass::Stream, "xgroup-createconsumer", key);
+        }
+        Ok(Some(read))
+    }
+
+    /// Entries of the stream at the key which served the client blocked by XREADGROUP, delivered to the consumer
+    /// They may have been delivered to another consumer already, right after the client was served.
+    pub fn read_served(
+        &self,
+        redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+        key: &[u8],
+    ) -> Result<StreamsEntries, String> {
+        let mut store = redis_key_val_store.lock().unwrap();
+        let entries = self
+            .read(&mut store, key, GroupReadId::New, stream::now_ms())?
+            .ok_or_else(|| no_group(key, &self.group))?;
+        drop(store);
+        Ok(if entries.is_empty() {
+            Vec::new()
+        } else {
+            vec![(key.to_vec(), entries)]
+        })
+    }
+
+    /// XREADGROUP of the new entries of the stream at the key without blocking, which delivers the same entries as
+    /// `read_served` when replayed
+    pub fn command(&self, key: &[u8]) -> Vec<Vec<u8>> {
+        let mut command = vec![
+            b"XREADGROUP".to_vec(),
+            b"GROUP".to_vec(),
+            self.group.clone(),
+            self.consumer.clone(),
+        ];
+        if let Some(count) = self.count {
+            command.extend([b"COUNT".to_vec(), count.to_string().into_bytes()]);
+        }
+        if self.noack {
+            command.push(b"NOACK".to_vec());
+        }
+        command.extend([b"STREAMS".to_vec(), key.to_vec(), b">".to_vec()]);
+        command
+    }
+}
+
+/// XREADGROUP: read the entries of the streams for the consumer of the group, up to `COUNT` of them per stream
+/// `>` reads the entries never delivered to the group, which then become pending for the consumer unless `NOACK` is
+/// given; with `BLOCK` the client waits until the timeout in milliseconds for a new entry when there is none right
+/// away. Any other ID reads the entries pending for the consumer after it.
+pub fn xreadgroup(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    blocked_clients: &Arc<Mutex<BlockedClients>>,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Result<Xread<GroupRead>, String> {
+    let ReadArgs {
+        count,
+        is_blocking,
+        timeout,
+        group,
+        noack,
+        keys,
+        ids,
+    } = ReadArgs::parse(parsed_command, true)?;
+    let (group, consumer) = group.unwrap();
+    let ids = ids
+        .iter()
+        .map(|id| match id.as_slice() {
+            b">" => Ok(GroupReadId::New),
+            b"$" => Err("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of \
+                         this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would \
+                         just return an empty result set."),
+            id => StreamId::parse(id, 0).map(GroupReadId::History),
+        })
+        .collect::<Result<Vec<_>, _>>()?;
+    let group_read = GroupRead {
+        group: group.to_vec(),
+        consumer: consumer.to_vec(),
+        count,
+        noack,
+    };
+    let now_ms = stream::now_ms();
+
+    // The store stays locked while blocking, so that a new entry in between can't be missed
+    let mut store = redis_key_val_store.lock().unwrap();
+    // Nothing is read unless all the groups exist
+    for key in keys {
+        if group_mut(&mut store, key, group)?.is_none() {
+            return Err(format!(
+                "{} in XREADGROUP with GROUP option",
+                no_group(key, group)
+            ));
+        }
+    }
+    let mut streams_entries = Vec::new();
+    for (key, &id) in keys.iter().zip(&ids) {
+        let entries = group_read
+            .read(&mut store, key, id, now_ms)?
+            .unwrap_or_default();
+        // The history of the consumer is replied even if it is empty
+        if !entries.is_empty() || matches!(id, GroupReadId::History(_)) {
+            streams_entries.push((key.clone(), entries));
+        }
+    }
+    if !streams_entries.is_empty() {
+        return Ok(Xread::Read(streams_entries));
+    }
+
+    if !is_blocking || !can_block {
+        return Ok(Xread::Empty);
+    }
+    let blocked_client = BlockedClients::block(blocked_clients, db, keys, Pop::Stream);
+    drop(store);
+    Ok(Xread::Blocked(blocked_client, timeout, group_read))
+}
+
+/// XACK: acknowledge the entries pending in the group, returning how many were pending
+pub fn xack(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 4 {
+        return Err(WRONG_ARITY);
+    }
+    let ids = parsed_command[3..]
+        .iter()
+        .map(|id| StreamId::parse(id, 0))
+        .collect::<Result<Vec<_>, _>>()?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let acked = group_mut(&mut store, &parsed_command[1], &parsed_command[2])?.map_or(0, |group| {
+        ids.into_iter().filter(|&id| group.ack(id)).count()
+    });
+    drop(store);
+    Ok(acked)
+}
+
+/// Output of XPENDING
+pub enum XpendingOutput {
+    /// The number of pending entries, the smallest and greatest of their IDs and the number of them pending for
+    /// every consumer which has any
+    Summary(usize, Option<(StreamId, StreamId)>, Vec<(Vec<u8>, usize)>),
+    /// Pending entries, each as its ID, its consumer, the milliseconds since it was last delivered and the number of
+    /// times it was delivered
+    Entries(Vec<(StreamId, Vec<u8>, u64, u64)>),
+}
+
+impl XpendingOutput {
+    /// Convert the output to RESP
+    pub fn into_resp(self) -> RespValue {
+        match self {
+            Self::Summary(0, ..) => RespValue::Array(vec![
+                RespValue::Integer(0),
+                RespValue::NullBulkString,
+                RespValue::NullBulkString,
+                RespValue::NullArray,
+            ]),
+            Self::Summary(len, bounds, consumers) => {
+                let (first, last) = bounds.unwrap_or((StreamId::MIN, StreamId::MIN));
+                RespValue::Array(vec![
+                    RespValue::Integer(i64::try_from(len).unwrap()),
+                    RespValue::BulkString(first.to_bytes()),
+                    RespValue::BulkString(last.to_bytes()),
+                    RespValue::Array(
+                        consumers
+                            .into_iter()
+                            .map(|(name, pending)| {
+                                // The count is a string, same as Redis
+                                RespValue::bulk_string_array([
+                                    name,
+                                    pending.to_string().into_bytes(),
+                                ])
+                            })
+                            .collect(),
+                    ),
+                ])
+            }
+            Self::Entries(entries) => RespValue::Array(
+                entries
+                    .into_iter()
+                    .map(|(id, consumer, idle_ms, delivery_count)| {
+                        RespValue::Array(vec![
+                            RespValue::BulkString(id.to_bytes()),
+                            RespValue::BulkString(consumer),
+                            RespValue::Integer(i64::try_from(idle_ms).unwrap_or(i64::MAX)),
+                            RespValue::Integer(i64::try_from(delivery_count).unwrap_or(i64::MAX)),
+                        ])
+                    })
+                    .collect(),
+            ),
+        }
+    }
+}
+
+/// XPENDING: get a summary of the entries pending in the group, or with a range, the pending entries with IDs from
+/// the start to the end, up to the count, optionally only those of a consumer or idle for at least `IDLE`
+/// milliseconds
+pub fn xpending(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<XpendingOutput, String> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY.to_owned());
+    }
+    let (min_idle_ms, range) = match parsed_command[3..] {
+        [ref option, ref min_idle_ms, ref range @ ..] if option.eq_ignore_ascii_case(b"idle") => {
+            let min_idle_ms = parse_redis_int(min_idle_ms).ok_or(INVALID_COUNT)?;
+            (u64::try_from(min_idle_ms).unwrap_or(0), range)
+        }
+        ref range => (0, range),
+    };
+    let range = match *range {
+        [] if min_idle_ms == 0 && parsed_command.len() == 3 => None,
+        [ref start, ref end, ref count] => Some((start, end, count, None)),
+        [ref start, ref end, ref count, ref consumer] => Some((start, end, count, Some(consumer))),
+        _ => return Err("ERR syntax error".to_owned()),
+    };
+    let range = range
+        .map(|(start, end, count, consumer)| {
+            let (start, end) = stream::parse_range(start, end)?;
+            let count = parse_redis_int(count).ok_or(INVALID_COUNT)?;
+            // A negative count returns nothing, same as Redis
+            Ok::<_, &str>((start, end, usize::try_from(count).unwrap_or(0), consumer))
+        })
+        .transpose()?;
+    let now_ms = stream::now_ms();
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let (key, group_name) = (&parsed_command[1], &parsed_command[2]);
+    let group = group_mut(&mut store, key, group_name)?.ok_or_else(|| no_group(key, group_name))?;
+    let output = match range {
+        None => XpendingOutput::Summary(
+            group.pending.len(),
+            group
+                .pending
+                .first_key_value()
+                .zip(group.pending.last_key_value())
+                .map(|(first, last)| (*first.0, *last.0)),
+            group
+                .consumers
+                .iter()
+                .filter(|consumer| !consumer.1.pending.is_empty())
+                .map(|(name, consumer)| (name.clone(), consumer.pending.len()))
+                .collect(),
+        ),
+        Some((start, end, count, consumer)) => XpendingOutput::Entries(
+            (start <= end)
+                .then(|| group.pending.range(start..=end))
+                .into_iter()
+                .flatten()
+                .filter(|pair| consumer.is_none_or(|consumer| pair.1.consumer == *consumer))
+                .map(|(&id, pending_entry)| {
+                    let idle_ms = now_ms.saturating_sub(pending_entry.delivery_time_ms);
+                    (id, pending_entry, idle_ms)
+                })
+                .filter(|&(_, _, idle_ms)| idle_ms >= min_idle_ms)
+                .take(count)
+                .map(|(id, pending_entry, idle_ms)| {
+                    (
+                        id,
+                        pending_entry.consumer.clone(),
+                        idle_ms,
+                        pending_entry.delivery_count,
+                    )
+                })
+                .collect(),
+        ),
+    };
+    drop(store);
+    Ok(output)
+}
+
+/// Output of XCLAIM
+pub enum XclaimOutput {
+    /// IDs of the entries claimed, with `JUSTID`
+    Ids(Vec<StreamId>),
+    /// Entries claimed
+    Entries(Vec<(StreamId, Fields)>),
+}
+
+impl XclaimOutput {
+    /// Convert the output to RESP
+    pub fn into_resp(self) -> RespValue {
+        match self {
+            Self::Ids(ids) => RespValue::bulk_string_array(ids.into_iter().map(StreamId::to_bytes)),
+            Self::Entries(entries) => stream::entries_resp(entries),
+        }
+    }
+}
+
+/// Options of XCLAIM
+#[derive(Default)]
+struct XclaimOptions {
+    /// Unix time in milliseconds to set as the last delivery of the claimed entries, given by `IDLE` or `TIME`
+    delivery_time_ms: Option<u64>,
+    /// Number of deliveries to set for the claimed entries, by `RETRYCOUNT`
+    retry_count: Option<u64>,
+    /// Whether entries which aren't pending are claimed as well, by `FORCE`
+    is_forced: bool,
+    /// Whether only the IDs are replied and the numbers of deliveries aren't incremented, by `JUSTID`
+    is_just_id: bool,
+    /// ID which the last delivered ID of the group is raised to, by `LASTID`
+    last_id: Option<StreamId>,
+}
+
+impl XclaimOptions {
+    /// Parse the options following the IDs of XCLAIM
+    fn parse(args: &[Vec<u8>], now_ms: u64) -> Result<Self, String> {
+        let mut options = Self::default();
+        let parse_ms = |arg: &[u8], err: &'static str| {
+            parse_redis_int(arg)
+                .map(|ms| u64::try_from(ms).unwrap_or(0))
+                .ok_or(err)
+        };
+        let mut args = args;
+        loop {
+            args = match *args {
+                [] => break,
+                [ref option, ref rest @ ..] if option.eq_ignore_ascii_case(b"force") => {
+                    options.is_forced = true;
+                    rest
+                }
+                [ref option, ref rest @ ..] if option.eq_ignore_ascii_case(b"justid") => {
+                    options.is_just_id = true;
+                    rest
+                }
+                [ref option, ref idle_ms, ref rest @ ..]
+                    if option.eq_ignore_ascii_case(b"idle") =>
+                {
+                    let idle_ms = parse_ms(idle_ms, "ERR Invalid IDLE option argument for XCLAIM")?;
+                    options.delivery_time_ms = Some(now_ms.saturating_sub(idle_ms));
+                    rest
+                }
+                [ref option, ref time_ms, ref rest @ ..]
+                    if option.eq_ignore_ascii_case(b"time") =>
+                {
+                    let time_ms = parse_ms(time_ms, "ERR Invalid TIME option argument for XCLAIM")?;
+                    options.delivery_time_ms = Some(time_ms);
+                    rest
+                }
+                [ref option, ref retry_count, ref rest @ ..]
+                    if option.eq_ignore_ascii_case(b"retrycount") =>
+                {
+                    options.retry_count = Some(parse_ms(
+                        retry_count,
+                        "ERR Invalid RETRYCOUNT option argument for XCLAIM",
+                    )?);
+                    rest
+                }
+                [ref option, ref last_id, ref rest @ ..]
+                    if option.eq_ignore_ascii_case(b"lastid") =>
+                {
+                    options.last_id = Some(StreamId::parse(last_id, 0)?);
+                    rest
+                }
+                [ref option, ..] => {
+                    return Err(format!(
+                        "ERR Unrecognized XCLAIM option '{}'",
+                        String::from_utf8_lossy(option)
+                    ))
+                }
+            };
+        }
+        // A delivery in the future is taken as one right now
+        options.delivery_time_ms = options.delivery_time_ms.map(|ms| ms.min(now_ms));
+        Ok(options)
+    }
+}
+
+/// XCLAIM: make the consumer the owner of the pending entries which were last delivered at least the minimum idle
+/// time ago, in milliseconds, counting this as a new delivery
+/// `FORCE` also claims the entries of the stream which aren't pending. Entries pending but no longer in the stream
+/// are acknowledged instead.
+pub fn xclaim(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<XclaimOutput, String> {
+    if parsed_command.len() < 6 {
+        return Err(WRONG_ARITY.to_owned());
+    }
+    let (key, group_name, consumer) = (&parsed_command[1], &parsed_command[2], &parsed_command[3]);
+    let min_idle_ms = parse_redis_int(&parsed_command[4])
+        .ok_or("ERR Invalid min-idle-time argument for XCLAIM")?;
+    let min_idle_ms = u64::try_from(min_idle_ms).unwrap_or(0);
+    // The IDs end at the first argument which isn't one
+    let ids: Vec<_> = parsed_command[5..]
+        .iter()
+        .map_while(|id| StreamId::parse(id, 0).ok())
+        .collect();
+    let now_ms = stream::now_ms();
+    let options = XclaimOptions::parse(&parsed_command[5 + ids.len()..], now_ms)?;
+    let delivery_time_ms = options.delivery_time_ms.unwrap_or(now_ms);
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let (group, entries) = store
+        .get_typed_mut::<Stream>(key)?
+        .and_then(|stream| stream.group_with_entries(group_name))
+        .ok_or_else(|| no_group(key, group_name))?;
+    if let Some(last_id) = options.last_id {
+        group.last_delivered_id = group.last_delivered_id.max(last_id);
+    }
+    let is_created = group.touch_consumer(consumer, now_ms);
+    let mut claimed = Vec::new();
+    for id in ids {
+        let is_in_stream = entries.contains_key(&id);
+        let delivery_count = match group.pending.get(&id) {
+            Some(_) if !is_in_stream => {
+                group.ack(id);
+                continue;
+            }
+            Some(pending_entry) => {
+                let idle_ms = now_ms.saturating_sub(pending_entry.delivery_time_ms);
+                if idle_ms < min_idle_ms {
+                    continue;
+                }
+                pending_entry.delivery_count
+            }
+            // A forced entry counts as delivered once, same as Redis
+            None if options.is_forced && is_in_stream => 1,
+            None => continue,
+        };
+        let delivery_count = options.retry_count.unwrap_or(if options.is_just_id {
+            delivery_count
+        } else {
+            delivery_count + 1
+        });
+        group.deliver(id, consumer, delivery_time_ms, delivery_count);
+        claimed.push(id);
+    }
+    if !claimed.is_empty() {
+        group.mark_active(consumer, now_ms);
+    }
+    let output = if options.is_just_id {
+        XclaimOutput::Ids(claimed)
+    } else {
+        XclaimOutput::Entries(
+            claimed
+                .into_iter()
+                .map(|id| (id, entries[&id].clone()))
+                .collect(),
+        )
+    };
+    if is_created {
+        store.notify(EventClass::Stream, "xgroup-createconsumer", key);
+    }
+    drop(store);
+    Ok(output)
+}
+
+/// XCLAIM as propagated, i.e. of the entries it claimed at the time of their delivery, given its reply, so that
+/// replaying it claims the same entries no matter how idle they are by then; None if it failed
+pub fn xclaim_as_claimed(parsed_command: &[Vec<u8>], reply: &RespValue) -> Option<Vec<Vec<u8>>> {
+    let RespValue::Array(ref claimed) = *reply else {
+        return None;
+    };
+    let mut ids: Vec<_> = claimed
+        .iter()
+        .filter_map(|claimed| match *claimed {
+            RespValue::BulkString(ref id) => Some(id.clone()),
+            RespValue::Array(ref entry) => entry.first().and_then(|id| match *id {
+                RespValue::BulkString(ref id) => Some(id.clone()),
+                _ => None,
+            }),
+            _ => None,
+        })
+        .collect();
+    // Claiming nothing still creates the consumer and raises the last delivered ID, as it does when no entry is
+    // idle enough
+    let min_idle_ms = if ids.is_empty() {
+        ids.push(StreamId::MIN.to_bytes());
+        i64::MAX.to_string().into_bytes()
+    } else {
+        b"0".to_vec()
+    };
+    let mut command = parsed_command[..4].to_vec();
+    command.push(min_idle_ms);
+    command.extend(ids);
+    let given_ids = parsed_command[5..]
+        .iter()
+        .take_while(|id| StreamId::parse(id, 0).is_ok())
+        .count();
+    let now_ms = stream::now_ms();
+    let mut delivery_time_ms = now_ms.to_string().into_bytes();
+    let mut options = &parsed_command[5 + given_ids..];
+    loop {
+        options = match *options {
+            [] => break,
+            [ref option, ref idle_ms, ref rest @ ..] if option.eq_ignore_ascii_case(b"idle") => {
+                let idle_ms =
+                    parse_redis_int(idle_ms).map_or(0, |ms| u64::try_from(ms).unwrap_or(0));
+                delivery_time_ms = now_ms.saturating_sub(idle_ms).to_string().into_bytes();
+                rest
+            }
+            [ref option, ref time_ms, ref rest @ ..] if option.eq_ignore_ascii_case(b"time") => {
+                delivery_time_ms.clone_from(time_ms);
+                rest
+            }
+            [ref option, ref val, ref rest @ ..]
+                if option.eq_ignore_ascii_case(b"retrycount")
+                    || option.eq_ignore_ascii_case(b"lastid") =>
+            {
+                command.extend([option.clone(), val.clone()]);
+                rest
+            }
+            [ref option, ref rest @ ..] => {
+                command.push(option.clone());
+                rest
+            }
+        };
+    }
+    command.extend([b"TIME".to_vec(), delivery_time_ms]);
+    Some(command)
+}
@@ -0,0 +1,170 @@
+//! Logical databases: the keyspace is split into `databases` independent stores, and every connection runs its
+//! commands against the one it selected, which is the first one to begin with
+
+use std::{
+    collections::VecDeque,
+    sync::{Arc, Mutex, MutexGuard},
+};
+
+use tokio::task;
+
+use crate::{
+    blocking::BlockedClients, command::WRONG_ARITY, notify::EventClass, parse_redis_int,
+    store::KeyValStore, zset,
+};
+
+/// Parse the index of a database, which must be one of the configured databases
+pub fn parse_index(
+    arg: &[u8],
+    databases_count: usize,
+    not_integer_error: &'static str,
+) -> Result<usize, &'static str> {
+    let db = parse_redis_int(arg).ok_or(not_integer_error)?;
+    usize::try_from(db)
+        .ok()
+        .filter(|&db| db < databases_count)
+        .ok_or("ERR DB index is out of range")
+}
+
+/// Lock the stores of two different databases, returning them in the order of the given indexes
+/// The store with the lower index is always locked first, so that two commands locking the same pair can't deadlock.
+pub fn lock_pair(
+    databases: &[Arc<Mutex<KeyValStore>>],
+    first: usize,
+    second: usize,
+) -> (MutexGuard<'_, KeyValStore>, MutexGuard<'_, KeyValStore>) {
+    if first < second {
+        let first_store = databases[first].lock().unwrap();
+        (first_store, databases[second].lock().unwrap())
+    } else {
+        let second_store = databases[second].lock().unwrap();
+        (databases[first].lock().unwrap(), second_store)
+    }
+}
+
+/// MOVE: move the key along with its TTL to another database, returning whether it was moved
+/// Nothing is moved if the key doesn't exist or if the other database already has it.
+pub fn move_key(
+    databases: &[Arc<Mutex<KeyValStore>>],
+    db: usize,
+    parsed_command: &[Vec<u8>],
+) -> Result<bool, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(WRONG_ARITY);
+    }
+    let destination_db = parse_index(
+        &parsed_command[2],
+        databases.len(),
+        "ERR value is not an integer or out of range",
+    )?;
+    if destination_db == db {
+        return Err("ERR source and destination objects are the same");
+    }
+    let key = &parsed_command[1];
+
+    let (mut store, mut destination_store) = lock_pair(databases, db, destination_db);
+    if store.get(key).is_none() || destination_store.get(key).is_some() {
+        return Ok(false);
+    }
+    let expires_at = store.expires_at(key);
+    let data = store.remove(key).unwrap();
+    store.notify(EventClass::Generic, "move_from", key);
+    destination_store.insert(key.clone(), data, expires_at);
+    destination_store.notify(EventClass::Generic, "move_to", key);
+    drop(store);
+    drop(destination_store);
+    Ok(true)
+}
+
+/// SWAPDB: swap the keys of two databases, so that the clients connected to either one see the keys of the other
+/// right away
+/// The clients blocked on keys of either database are served if the keys now hold lists or sorted sets.
+pub fn swapdb(
+    databases: &[Arc<Mutex<KeyValStore>>],
+    blocked_clients: &Mutex<BlockedClients>,
+    parsed_command: &[Vec<u8>],
+) -> Result<(), &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(WRONG_ARITY);
+    }
+    let first = parse_index(
+        &parsed_command[1],
+        databases.len(),
+        "ERR invalid first DB index",
+    )?;
+    let second = parse_index(
+        &parsed_command[2],
+        databases.len(),
+        "ERR invalid second DB index",
+    )?;
+    if first == second {
+        return Ok(());
+    }
+
+    let (mut first_store, mut second_store) = lock_pair(databases, first, second);
+    first_store.swap_keys(&mut second_store);
+    serve_blocked_clients(&mut first_store, first, blocked_clients);
+    serve_blocked_clients(&mut second_store, second, blocked_clients);
+    drop(first_store);
+    drop(second_store);
+    Ok(())
+}
+
+/// Hand over the elements of the lists and sorted sets of the database to the clients blocked on them, removing the
+/// values which that empties
+fn serve_blocked_clients(
+    store: &mut KeyValStore,
+    db: usize,
+    blocked_clients: &Mutex<BlockedClients>,
+) {
+    let waited_keys = blocked_clients.lock().unwrap().waited_keys(db);
+    for key in waited_keys {
+        serve_blocked_key(store, db, &key, blocked_clients);
+    }
+}
+
+/// Hand over the elements of the key to the clients blocked on it, if it now holds a list or a sorted set, removing
+/// the value if that empties it
+pub fn serve_blocked_key(
+    store: &mut KeyValStore,
+    db: usize,
+    key: &[u8],
+    blocked_clients: &Mutex<BlockedClients>,
+) {
+    if let Ok(Some(list)) = store.get_typed_mut::<VecDeque<Vec<u8>>>(key) {
+        let served_pops = blocked_clients.lock().unwrap().serve(db, key, list);
+        let is_list_empty = list.is_empty();
+        for served_pop in served_pops {
+            served_pop.notify(store, key);
+        }
+        if is_list_empty {
+            store.remove(key);
+            store.notify(EventClass::Generic, "del", key);
+        }
+    } else {
+        zset::serve_blocked_clients(store, blocked_clients, db, key);
+    }
+}
+
+/// FLUSHDB, FLUSHALL: remove all the keys of the given databases
+/// With `ASYNC` the removed values are freed in the background, as that takes time proportional to their size.
+pub fn flush(
+    stores: &[Arc<Mutex<KeyValStore>>],
+    parsed_command: &[Vec<u8>],
+) -> Result<(), &'static str> {
+    let is_lazy = match *parsed_command {
+        [_] => false,
+        [_, ref mode] if mode.eq_ignore_ascii_case(b"async") => true,
+        [_, ref mode] if mode.eq_ignore_ascii_case(b"sync") => false,
+        _ => return Err("ERR syntax error"),
+    };
+
+    let removed_values: Vec<_> = stores
+        .iter()
+        .flat_map(|store| store.lock().unwrap().clear())
+        .collect();
+    if is_lazy {
+        task::spawn_blocking(move || drop(removed_values));
+    }
+    Ok(())
+}
@@ -0,0 +1,214 @@
+//! Encodings of the values, as reported by OBJECT ENCODING
+//! Values are always stored the same way here, whereas Redis keeps small values in compact encodings such as
+//! listpacks and converts them once they outgrow the limits of `*-max-listpack-*`. Clients and test suites check
+//! those encodings, so the encoding Redis would use is tracked for every value: it is updated from the value before
+//! every write to it, so that the conversions happen at the same writes as in Redis.
+
+use crate::{parse_redis_int, store::RedisType};
+
+/// Longest string which Redis embeds in the object holding it
+const EMBSTR_MAX_LEN: usize = 44;
+/// Size of the header and of the terminator of a listpack in bytes
+const LISTPACK_OVERHEAD: usize = 7;
+
+/// Limits up to which values are encoded as listpacks, set by the `*-max-listpack-*` parameters, along with the one
+/// of intsets
+#[derive(Clone, Copy)]
+pub struct ListpackLimits {
+    /// `hash-max-listpack-entries`: number of fields of a hash
+    pub hash_entries: usize,
+    /// `hash-max-listpack-value`: length of every field and value of a hash
+    pub hash_value: usize,
+    /// `zset-max-listpack-entries`: number of members of a sorted set
+    pub zset_entries: usize,
+    /// `zset-max-listpack-value`: length of every member of a sorted set
+    pub zset_value: usize,
+    /// `set-max-intset-entries`: number of members of a set of integers, up to which it is an intset instead
+    pub set_intset_entries: usize,
+    /// `set-max-listpack-entries`: number of members of a set
+    pub set_entries: usize,
+    /// `set-max-listpack-value`: length of every member of a set
+    pub set_value: usize,
+    /// `list-max-listpack-size`: number of elements of a list if positive, or else its size from -1 for 4 KB to -5
+    /// for 64 KB
+    pub list_size: i64,
+    /// Length above which an element of a list is stored on its own, so that the list can't be a listpack, as set
+    /// by DEBUG QUICKLIST-PACKED-THRESHOLD
+    pub list_packed_threshold: usize,
+}
+
+impl Default for ListpackLimits {
+    fn default() -> Self {
+        Self {
+            hash_entries: 128,
+            hash_value: 64,
+            zset_entries: 128,
+            zset_value: 64,
+            set_intset_entries: 512,
+            set_entries: 128,
+            set_value: 64,
+            list_size: -2,
+            list_packed_threshold: 1 << 30,
+        }
+    }
+}
+
+impl ListpackLimits {
+    /// Limit set by the parameter, for the limits which are a number of elements or bytes
+    pub fn size_mut(&mut self, parameter: &str) -> Option<&mut usize> {
+        match parameter {
+            "hash-max-listpack-entries" => Some(&mut self.hash_entries),
+            "hash-max-listpack-value" => Some(&mut self.hash_value),
+            "zset-max-listpack-entries" => Some(&mut self.zset_entries),
+            "zset-max-listpack-value" => Some(&mut self.zset_value),
+            "set-max-intset-entries" => Some(&mut self.set_intset_entries),
+            "set-max-listpack-entries" => Some(&mut self.set_entries),
+            "set-max-listpack-value" => Some(&mut self.set_value),
+            _ => None,
+        }
+    }
+}
+
+/// Encoding of a value
+#[derive(Clone, Copy, PartialEq, Eq)]
+pub enum Encoding {
+    /// A string holding an integer
+    Int,
+    /// A short string, embedded in its object
+    EmbStr,
+    /// A long string
+    Raw,
+    /// A set of integers, stored as a sorted array
+    Intset,
+    /// A small list, hash or sorted set, stored in a single compact block
+    Listpack,
+    /// A list of linked listpacks
+    Quicklist,
+    /// A hash table for a hash
+    Hashtable,
+    /// A skip list along with a hash table for a sorted set
+    Skiplist,
+    /// A radix tree of listpacks, which streams always are
+    Stream,
+}
+
+impl Encoding {
+    /// Name of the encoding, as replied by OBJECT ENCODING
+    pub const fn name(self) -> &'static str {
+        match self {
+            Self::Int => "int",
+            Self::EmbStr => "embstr",
+            Self::Raw => "raw",
+            Self::Intset => "intset",
+            Self::Listpack => "listpack",
+            Self::Quicklist => "quicklist",
+            Self::Hashtable => "hashtable",
+            Self::Skiplist => "skiplist",
+            Self::Stream => "stream",
+        }
+    }
+
+    /// Encoding of the value, given the encoding it had so far, if any
+    /// Like in Redis before 7.2, values are never converted back to compact encodings once they outgrew them.
+    pub fn of(data: &RedisType, current: Option<Self>, limits: &ListpackLimits) -> Self {
+        match *data {
+            RedisType::Val(ref val) if val.len() <= 20 && parse_redis_int(val).is_some() => {
+                Self::Int
+            }
+            RedisType::Val(ref val) if val.len() <= EMBSTR_MAX_LEN => Self::EmbStr,
+            RedisType::Val(_) => Self::Raw,
+            RedisType::List(_) if current == Some(Self::Quicklist) => Self::Quicklist,
+            RedisType::List(ref list) => {
+                let fits = list
+                    .iter()
+                    .all(|element| element.len() <= limits.list_packed_threshold)
+                    && fits_listpack(list.iter().map(Vec::as_slice), list.len(), limits.list_size);
+                if fits {
+                    Self::Listpack
+                } else {
+                    Self::Quicklist
+                }
+            }
+            RedisType::Hash(_) | RedisType::Set(_) if current == Some(Self::Hashtable) => {
+                Self::Hashtable
+            }
+            RedisType::Hash(ref hash) => {
+                let fits = hash.len() <= limits.hash_entries
+                    && hash.iter().all(|(field, val)| {
+                        field.len() <= limits.hash_value && val.len() <= limits.hash_value
+                    });
+                if fits {
+                    Self::Listpack
+                } else {
+                    Self::Hashtable
+                }
+            }
+            RedisType::Set(ref set) => {
+                let is_integers = set.iter().all(|member| parse_redis_int(member).is_some());
+                let fits = set.len() <= limits.set_entries
+                    && set.iter().all(|member| member.len() <= limits.set_value);
+                match current {
+                    // A set is only ever an intset from its creation, and one outgrowing the entries of an intset
+                    // becomes a hash table, like in Redis
+                    None | Some(Self::Intset)
+                        if is_integers && set.len() <= limits.set_intset_entries =>
+                    {
+                        Self::Intset
+                    }
+                    Some(Self::Intset) if is_integers => Self::Hashtable,
+                    _ if fits => Self::Listpack,
+                    _ => Self::Hashtable,
+                }
+            }
+            RedisType::SortedSet(_) if current == Some(Self::Skiplist) => Self::Skiplist,
+            RedisType::SortedSet(ref sorted_set) => {
+                let fits = sorted_set.len() <= limits.zset_entries
+                    && sorted_set
+                        .iter()
+                        .all(|pair| pair.1.len() <= limits.zset_value);
+                if fits {
+                    Self::Listpack
+                } else {
+                    Self::Skiplist
+                }
+            }
+            RedisType::Stream(_) => Self::Stream,
+        }
+    }
+}
+
+/// Whether the elements of a list fit in a single listpack under `list-max-listpack-size`
+/// The size of a listpack is estimated from the lengths of the elements, ignoring the compact encoding of the
+/// integers; the elements are only summed up until the limit is exceeded, so that this is cheap for long lists.
+fn fits_listpack<'a>(
+    mut elements: impl Iterator<Item = &'a [u8]>,
+    len: usize,
+    list_size: i64,
+) -> bool {
+    if let Ok(max_len) = usize::try_from(list_size) {
+        return len <= max_len;
+    }
+    let max_size = match list_size {
+        -1 => 4096,
+        -2 => 8192,
+        -3 => 16384,
+        -4 => 32768,
+        _ => 65536,
+    };
+    let mut size = LISTPACK_OVERHEAD;
+    elements.all(|element| {
+        let header_len = match element.len() {
+            0..64 => 1,
+            64..4096 => 2,
+            _ => 5,
+        };
+        let entry_len = header_len + element.len();
+        let backlen_len = match entry_len {
+            0..128 => 1,
+            128..16384 => 2,
+            _ => 3,
+        };
+        size += entry_len + backlen_len;
+        size <= max_size
+    })
+}
@@ -0,0 +1,238 @@
+//! Eviction of keys once the memory used for the data exceeds `maxmemory`, as chosen by `maxmemory-policy`
+//! Like Redis, the LRU, LFU and TTL policies are approximated: a few keys are sampled from every database and the
+//! best candidate among them is evicted, until the memory used is back under the limit. The memory used is the sum
+//! of the estimated sizes of the keys, rather than what the process actually allocated; the size of a collection is
+//! estimated from a few of its elements, so that a write to a large one doesn't go through all of it again.
+
+use std::{
+    sync::{Arc, Mutex},
+    time::{Duration, Instant, SystemTime},
+};
+
+use rand::Rng as _;
+
+use crate::{
+    config::MaxMemoryPolicy,
+    notify::EventClass,
+    store::{KeyValStore, RedisType},
+};
+
+/// Number of keys sampled from every database to pick the key to evict, same as `maxmemory-samples` of Redis by
+/// default
+const SAMPLES: usize = 5;
+/// Number of elements of a collection whose sizes stand for the others in its estimated size, same as MEMORY USAGE
+/// samples by default
+const SIZE_SAMPLES: usize = 5;
+/// Estimated overhead of a key in the keyspace, for its entry in the hash table and the headers of the key and of
+/// the value
+pub const KEY_OVERHEAD: usize = 64;
+/// Estimated overhead of an element of a collection
+const ELEMENT_OVERHEAD: usize = 16;
+/// Access frequency of a new key, so that it isn't evicted right away by the LFU policies (same as Redis)
+const LFU_INIT_VAL: u8 = 5;
+/// How hard it is for the access frequency to grow, same as `lfu-log-factor` of Redis by default
+const LFU_LOG_FACTOR: f64 = 10.0;
+/// Time for the access frequency to drop by 1, same as `lfu-decay-time` of Redis by default
+const LFU_DECAY_TIME: Duration = Duration::from_secs(60);
+/// Error of a command which may use more memory while the limit is exceeded
+pub const OOM: &str = "OOM command not allowed when used memory > 'maxmemory'.";
+
+/// Last access of a key along with its logarithmic access frequency, which is what the LRU and LFU policies evict by
+#[derive(Clone, Copy)]
+pub struct KeyAccess {
+    /// Time of the last access
+    accessed_at: Instant,
+    /// Logarithmic counter of the accesses, as of the last access; it decays over time without accesses
+    lfu_counter: u8,
+}
+
+impl KeyAccess {
+    /// Access of a new key
+    pub fn new() -> Self {
+        Self {
+            accessed_at: Instant::now(),
+            lfu_counter: LFU_INIT_VAL,
+        }
+    }
+
+    /// Record an access of the key
+    /// The counter grows with a probability which drops as it gets higher, so that it can count up to millions of
+    /// accesses in 8 bits.
+    pub fn touch(&mut self) {
+        let mut counter = self.frequency();
+        if counter < u8::MAX {
+            let base = f64::from(counter.saturating_sub(LFU_INIT_VAL));
+            if rand::rng().random::<f64>() < 1.0 / base.mul_add(LFU_LOG_FACTOR, 1.0) {
+                counter += 1;
+            }
+        }
+        self.lfu_counter = counter;
+        self.accessed_at = Instant::now();
+    }
+
+    /// Time since the last access
+    pub fn idle_time(&self) -> Duration {
+        self.accessed_at.elapsed()
+    }
+
+    /// Logarithmic access frequency, as replied by OBJECT FREQ
+    pub fn frequency(&self) -> u8 {
+        let decay = self.idle_time().as_secs() / LFU_DECAY_TIME.as_secs();
+        self.lfu_counter
+            .saturating_sub(u8::try_from(decay).unwrap_or(u8::MAX))
+    }
+}
+
+/// Estimated memory used by the key along with its value, in bytes
+/// This takes constant time whatever the size of the value, as only `SIZE_SAMPLES` of its elements are measured.
+pub fn estimated_size(key: &[u8], data: &RedisType) -> usize {
+    sampled_size(key, data, SIZE_SAMPLES)
+}
+
+/// Estimated size of the `len` elements of a collection from the sizes of up to `samples` of them, which stand for
+/// the others
+fn sampled(len: usize, sizes: impl Iterator<Item = usize>, samples: usize) -> usize {
+    let (count, total) = sizes
+        .take(samples)
+        .fold((0, 0), |(count, total), size| (count + 1, total + size));
+    if count == 0 {
+        0
+    } else {
+        total * len / count
+    }
+}
+
+/// Estimated memory used by the key along with its value, the size of the elements of a collection being estimated
+/// from only the first `samples` of them, as MEMORY USAGE does
+pub fn sampled_size(key: &[u8], data: &RedisType, samples: usize) -> usize {
+    let value_size = match *data {
+        RedisType::Val(ref val) => val.len(),
+        RedisType::List(ref list) => sampled(
+            list.len(),
+            list.iter().map(|element| element.len() + ELEMENT_OVERHEAD),
+            samples,
+        ),
+        RedisType::Hash(ref hash) => sampled(
+            hash.len(),
+            hash.iter()
+                .map(|(field, val)| field.len() + val.len() + 2 * ELEMENT_OVERHEAD),
+            samples,
+        ),
+        RedisType::Set(ref set) => sampled(
+            set.len(),
+            set.iter().map(|member| member.len() + ELEMENT_OVERHEAD),
+            samples,
+        ),
+        // The score of a member is stored along with it, and the member is indexed twice
+        RedisType::SortedSet(ref sorted_set) => sampled(
+            sorted_set.len(),
+            sorted_set
+                .iter()
+                .map(|pair| 2 * pair.1.len() + size_of::<f64>() + 2 * ELEMENT_OVERHEAD),
+            samples,
+        ),
+        // The consumer groups are few, so they are never sampled
+        RedisType::Stream(ref stream) => {
+            let entries_size = sampled(
+                stream.len(),
+                stream.iter().map(|(_, fields)| {
+                    fields
+                        .iter()
+                        .map(|pair| pair.0.len() + pair.1.len())
+                        .sum::<usize>()
+                        + 2 * size_of::<u64>()
+                        + ELEMENT_OVERHEAD
+                }),
+                samples,
+            );
+            let groups_size: usize = stream
+                .groups()
+                .iter()
+                .map(|(name, group)| {
+                    let consumers_size: usize = group
+                        .consumers()
+                        .keys()
+                        .map(|consumer| consumer.len() + ELEMENT_OVERHEAD)
+                        .sum();
+                    name.len() + consumers_size + group.pending().len() * 4 * ELEMENT_OVERHEAD
+                })
+                .sum();
+            entries_size + groups_size
+        }
+    };
+    KEY_OVERHEAD + key.len() + value_size
+}
+
+/// How good a candidate for eviction the key is by the policy, the higher the better, or None if the policy never
+/// evicts it
+/// `expires_at` is the absolute expiry time of the key, if it has a TTL.
+pub fn eviction_score(
+    policy: MaxMemoryPolicy,
+    access: &KeyAccess,
+    expires_at: Option<SystemTime>,
+) -> Option<u128> {
+    if policy.is_volatile() && expires_at.is_none() {
+        return None;
+    }
+    match policy {
+        MaxMemoryPolicy::NoEviction => None,
+        MaxMemoryPolicy::AllKeysLru | MaxMemoryPolicy::VolatileLru => {
+            Some(access.idle_time().as_nanos())
+        }
+        MaxMemoryPolicy::AllKeysLfu | MaxMemoryPolicy::VolatileLfu => {
+            Some(u128::from(u8::MAX - access.frequency()))
+        }
+        // Any key which can be evicted is as good as any other, whatever its database
+        MaxMemoryPolicy::AllKeysRandom | MaxMemoryPolicy::VolatileRandom => {
+            Some(rand::rng().random())
+        }
+        MaxMemoryPolicy::VolatileTtl => expires_at.map(|expires_at| {
+            let ttl = expires_at
+                .duration_since(SystemTime::now())
+                .unwrap_or_default();
+            u128::MAX - ttl.as_millis()
+        }),
+    }
+}
+
+/// Evict keys from the databases by the policy until the memory used is within the limit, which is none if 0
+/// Returns the evicted keys along with the index of their database, and whether the memory used is still over the
+/// limit, as there is nothing left to evict by the policy.
+pub fn evict(
+    databases: &[Arc<Mutex<KeyValStore>>],
+    maxmemory: u64,
+    policy: MaxMemoryPolicy,
+) -> (Vec<(usize, Vec<u8>)>, bool) {
+    let mut evicted = Vec::new();
+    if maxmemory == 0 {
+        return (evicted, false);
+    }
+    let limit = usize::try_from(maxmemory).unwrap_or(usize::MAX);
+    loop {
+        let used_memory: usize = databases
+            .iter()
+            .map(|store| store.lock().unwrap().used_memory())
+            .sum();
+        if used_memory <= limit {
+            return (evicted, false);
+        }
+
+        let mut best: Option<(u128, usize, Vec<u8>)> = None;
+        for (db, store) in databases.iter().enumerate() {
+            let samples = store.lock().unwrap().sample_for_eviction(policy, SAMPLES);
+            for (score, key) in samples {
+                if best.as_ref().is_none_or(|best| score > best.0) {
+                    best = Some((score, db, key));
+                }
+            }
+        }
+        let Some((_, db, key)) = best else {
+            return (evicted, true);
+        };
+        let mut store = databases[db].lock().unwrap();
+        store.remove(&key);
+        store.notify(EventClass::Evicted, "evicted", &key);
+        drop(store);
+        evicted.push((db, key));
+    }
+}
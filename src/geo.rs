@@ -0,0 +1,471 @@
+//! Geospatial commands, on sorted sets whose scores are the geohashes of the positions of their members
+//! A geohash interleaves the bits of the longitude and latitude of a position, 26 bits each, so that it fits exactly
+//! in a score and close positions mostly have close scores. Positions are encoded and decoded the same way as Redis,
+//! so that the scores are interchangeable with those of Redis.
+
+use std::sync::{Arc, Mutex};
+
+use crate::{
+    blocking::BlockedClients,
+    command::WRONG_ARITY,
+    parse_redis_float, parse_redis_int,
+    resp::RespValue,
+    store::KeyValStore,
+    zset::{self, SortedSet},
+};
+
+/// Number of bits of each coordinate in a geohash
+const STEP: u32 = 26;
+/// Range of the longitudes
+const LONGITUDE_RANGE: (f64, f64) = (-180.0, 180.0);
+/// Range of the latitudes, which excludes the poles as Redis does, like the Web Mercator projection
+const LATITUDE_RANGE: (f64, f64) = (-85.051_128_78, 85.051_128_78);
+/// Radius of the Earth in meters, as used by Redis for the distances
+const EARTH_RADIUS: f64 = 6_372_797.560_856;
+
+/// Spread the bits of the coordinate cell so that they take the even bits of the result
+fn spread(cell: u32) -> u64 {
+    (0..STEP).fold(0, |spread, bit| {
+        spread | (u64::from((cell >> bit) & 1) << (2 * bit))
+    })
+}
+
+/// Gather the even bits of the geohash into a coordinate cell, undoing `spread`
+fn squash(hash: u64) -> u32 {
+    (0..STEP).fold(0, |cell, bit| {
+        cell | (u32::from((hash >> (2 * bit)) & 1 == 1) << bit)
+    })
+}
+
+/// Index of the cell which the coordinate falls in, among the 2^26 cells of its range
+#[expect(
+    clippy::cast_possible_truncation,
+    clippy::cast_sign_loss,
+    reason = "The coordinate is within its range, so the cell is within 0..2^26"
+)]
+fn cell(coordinate: f64, (min, max): (f64, f64)) -> u32 {
+    let cell = ((coordinate - min) / (max - min) * f64::from(1_u32 << STEP)) as u32;
+    cell.min((1 << STEP) - 1)
+}
+
+/// Center of the cell of the coordinate, among the 2^26 cells of its range
+fn cell_center(cell: u32, (min, max): (f64, f64)) -> f64 {
+    let cells = f64::from(1_u32 << STEP);
+    let low = (f64::from(cell) / cells).mul_add(max - min, min);
+    let high = ((f64::from(cell) + 1.0) / cells).mul_add(max - min, min);
+    f64::midpoint(low, high).clamp(min, max)
+}
+
+/// Score of the position, i.e. its geohash
+#[expect(
+    clippy::cast_precision_loss,
+    reason = "A geohash has 52 bits, which the mantissa of a score holds exactly"
+)]
+fn encode((longitude, latitude): (f64, f64)) -> f64 {
+    let hash =
+        spread(cell(latitude, LATITUDE_RANGE)) | (spread(cell(longitude, LONGITUDE_RANGE)) << 1);
+    hash as f64
+}
+
+/// Position at the center of the area of the geohash which is the score, as its longitude and latitude
+#[expect(
+    clippy::cast_possible_truncation,
+    clippy::cast_sign_loss,
+    reason = "Scores of geospatial members are geohashes, i.e. integers of 52 bits"
+)]
+fn decode(score: f64) -> (f64, f64) {
+    let hash = score as u64;
+    (
+        cell_center(squash(hash >> 1), LONGITUDE_RANGE),
+        cell_center(squash(hash), LATITUDE_RANGE),
+    )
+}
+
+/// Distance in meters between the two positions along the surface of the Earth, by the haversine formula
+fn distance(from: (f64, f64), to: (f64, f64)) -> f64 {
+    let (from_longitude, from_latitude) = (from.0.to_radians(), from.1.to_radians());
+    let (to_longitude, to_latitude) = (to.0.to_radians(), to.1.to_radians());
+    let u = ((to_latitude - from_latitude) / 2.0).sin();
+    let v = ((to_longitude - from_longitude) / 2.0).sin();
+    2.0 * EARTH_RADIUS
+        * (u * u + from_latitude.cos() * to_latitude.cos() * v * v)
+            .sqrt()
+            .asin()
+}
+
+/// Parse the longitude and latitude of a position, which must be within their ranges
+fn parse_position(longitude: &[u8], latitude: &[u8]) -> Result<(f64, f64), String> {
+    let (Some(longitude), Some(latitude)) =
+        (parse_redis_float(longitude), parse_redis_float(latitude))
+    else {
+        return Err("ERR value is not a valid float".to_string());
+    };
+    let is_in = |coordinate: f64, (min, max): (f64, f64)| (min..=max).contains(&coordinate);
+    if !is_in(longitude, LONGITUDE_RANGE) || !is_in(latitude, LATITUDE_RANGE) {
+        return Err(format!(
+            "ERR invalid longitude,latitude pair {longitude:.6},{latitude:.6}"
+        ));
+    }
+    Ok((longitude, latitude))
+}
+
+/// Parse a unit of distance, returning the number of meters in it
+fn parse_unit(unit: &[u8]) -> Result<f64, &'static str> {
+    match String::from_utf8_lossy(unit).to_lowercase().as_str() {
+        "m" => Ok(1.0),
+        "km" => Ok(1000.0),
+        "ft" => Ok(0.3048),
+        "mi" => Ok(1609.34),
+        _ => Err("ERR unsupported unit provided. please use M, KM, FT, MI"),
+    }
+}
+
+/// Format a distance the way Redis replies it, with 4 decimals
+pub fn format_distance(distance: f64) -> String {
+    format!("{distance:.4}")
+}
+
+/// GEOADD: add the members at their positions, or move the existing members, as ZADD would with their geohashes as
+/// scores
+/// Returns the number of members added (and moved, with `CH`).
+pub fn geoadd(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    blocked_clients: &Mutex<BlockedClients>,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, String> {
+    if parsed_command.len() < 5 {
+        return Err(WRONG_ARITY.to_string());
+    }
+    let options_len = parsed_command[2..]
+        .iter()
+        .take_while(|arg| {
+            [&b"nx"[..], b"xx", b"ch"]
+                .iter()
+                .any(|option| arg.eq_ignore_ascii_case(option))
+        })
+        .count();
+    let positions = &parsed_command[2 + options_len..];
+    if positions.is_empty() || !positions.len().is_multiple_of(3) {
+        return Err("ERR syntax error".to_string());
+    }
+
+    let mut zadd_command = vec![b"zadd".to_vec(), parsed_command[1].clone()];
+    zadd_command.extend_from_slice(&parsed_command[2..2 + options_len]);
+    for position in positions.chunks_exact(3) {
+        let score = encode(parse_position(&position[0], &position[1])?);
+        zadd_command.push(score.to_string().into_bytes());
+        zadd_command.push(position[2].clone());
+    }
+    zset::zadd(redis_key_val_store, blocked_clients, db, &zadd_command).map_err(str::to_string)
+}
+
+/// GEOPOS: get the positions of the members as their longitudes and latitudes, None for a missing member
+pub fn geopos(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Vec<Option<(f64, f64)>>, &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let sorted_set = store.get_typed::<SortedSet>(&parsed_command[1])?;
+    let positions = parsed_command[2..]
+        .iter()
+        .map(|member| {
+            sorted_set
+                .and_then(|sorted_set| sorted_set.score(member))
+                .map(decode)
+        })
+        .collect();
+    drop(store);
+    Ok(positions)
+}
+
+/// GEODIST: get the distance between the two members in the unit, meters by default, or None if either is missing
+pub fn geodist(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Option<f64>, &'static str> {
+    let unit = match parsed_command.len() {
+        4 => 1.0,
+        5 => parse_unit(&parsed_command[4])?,
+        _ => return Err(WRONG_ARITY),
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(sorted_set) = store.get_typed::<SortedSet>(&parsed_command[1])? else {
+        return Ok(None);
+    };
+    let positions = sorted_set
+        .score(&parsed_command[2])
+        .zip(sorted_set.score(&parsed_command[3]));
+    drop(store);
+    Ok(positions.map(|(from, to)| distance(decode(from), decode(to)) / unit))
+}
+
+/// Area searched by GEOSEARCH, with its dimensions in meters
+#[derive(Clone, Copy)]
+enum Shape {
+    /// Circle of the radius around the center
+    Radius(f64),
+    /// Rectangle of the width and height, centered on the center and aligned with the meridians
+    Box(f64, f64),
+}
+
+impl Shape {
+    /// Distance of the position from the center if it is within the area
+    fn distance_if_within(self, center: (f64, f64), position: (f64, f64)) -> Option<f64> {
+        let center_distance = distance(center, position);
+        match self {
+            Self::Radius(radius) => (center_distance <= radius).then_some(center_distance),
+            Self::Box(width, height) => {
+                let latitude_distance =
+                    EARTH_RADIUS * (position.1.to_radians() - center.1.to_radians()).abs();
+                let longitude_distance = distance((position.0, position.1), (center.0, position.1));
+                (latitude_distance <= height / 2.0 && longitude_distance <= width / 2.0)
+                    .then_some(center_distance)
+            }
+        }
+    }
+}
+
+/// Center of the area searched by GEOSEARCH
+enum Center {
+    /// Position of a member of the sorted set
+    Member(Vec<u8>),
+    /// Longitude and latitude
+    Position((f64, f64)),
+}
+
+/// Member found by GEOSEARCH
+struct Found {
+    /// Name of the member
+    member: Vec<u8>,
+    /// Distance from the center in meters
+    distance: f64,
+    /// Score of the member, i.e. its geohash
+    score: f64,
+}
+
+/// Output of the GEOSEARCH command
+pub struct GeosearchOutput {
+    /// Members found
+    found: Vec<Found>,
+    /// Number of meters in the unit of the distances
+    unit: f64,
+    /// `WITHDIST`: reply the distances from the center
+    with_dist: bool,
+    /// `WITHHASH`: reply the geohashes
+    with_hash: bool,
+    /// `WITHCOORD`: reply the positions
+    with_coord: bool,
+}
+
+impl GeosearchOutput {
+    /// Convert to RESP: the names of the members, or for each of them an array of its name, distance, geohash and
+    /// position, with only the ones asked for
+    #[expect(
+        clippy::cast_possible_truncation,
+        reason = "Scores of geospatial members are geohashes, i.e. integers of 52 bits"
+    )]
+    pub fn into_resp(self) -> RespValue {
+        let is_plain = !self.with_dist && !self.with_hash && !self.with_coord;
+        RespValue::Array(
+            self.found
+                .into_iter()
+                .map(|found| {
+                    if is_plain {
+                        return RespValue::BulkString(found.member);
+                    }
+                    let mut fields = vec![RespValue::BulkString(found.member)];
+                    if self.with_dist {
+                        let distance = format_distance(found.distance / self.unit);
+                        fields.push(RespValue::BulkString(distance.into_bytes()));
+                    }
+                    if self.with_hash {
+                        fields.push(RespValue::Integer(found.score as i64));
+                    }
+                    if self.with_coord {
+                        let (longitude, latitude) = decode(found.score);
+                        fields.push(RespValue::Array(vec![
+                            RespValue::Double(longitude),
+                            RespValue::Double(latitude),
+                        ]));
+                    }
+                    RespValue::Array(fields)
+                })
+                .collect(),
+        )
+    }
+}
+
+/// Parsed options of GEOSEARCH
+#[derive(Default)]
+#[expect(
+    clippy::struct_excessive_bools,
+    reason = "Every option of GEOSEARCH is an independent flag"
+)]
+struct GeosearchOptions {
+    /// `FROMMEMBER` or `FROMLONLAT`, which must be given once
+    center: Option<Center>,
+    /// `BYRADIUS` or `BYBOX` along with the number of meters in their unit, which must be given once
+    shape: Option<(Shape, f64)>,
+    /// `ASC` or `DESC`: order the members by their distance from the center, farthest first if true
+    is_descending: Option<bool>,
+    /// `COUNT`: maximum number of members
+    count: Option<usize>,
+    /// `ANY`: stop searching as soon as `COUNT` members are found, rather than finding the closest ones
+    any: bool,
+    /// `WITHDIST`
+    with_dist: bool,
+    /// `WITHHASH`
+    with_hash: bool,
+    /// `WITHCOORD`
+    with_coord: bool,
+}
+
+impl GeosearchOptions {
+    /// Parse the arguments of GEOSEARCH after the key
+    fn parse(args: &[Vec<u8>]) -> Result<Self, String> {
+        let mut options = Self::default();
+        let mut is_center_repeated = false;
+        let mut is_shape_repeated = false;
+        let mut args = args.iter();
+        let mut next = || args.next().ok_or_else(|| "ERR syntax error".to_string());
+        while let Ok(option) = next() {
+            match String::from_utf8_lossy(option).to_lowercase().as_str() {
+                "frommember" => {
+                    is_center_repeated |= options.center.is_some();
+                    options.center = Some(Center::Member(next()?.clone()));
+                }
+                "fromlonlat" => {
+                    is_center_repeated |= options.center.is_some();
+                    let longitude = next()?;
+                    options.center = Some(Center::Position(parse_position(longitude, next()?)?));
+                }
+                "byradius" => {
+                    is_shape_repeated |= options.shape.is_some();
+                    let radius = parse_redis_float(next()?).ok_or("ERR need numeric radius")?;
+                    if radius < 0.0 {
+                        return Err("ERR radius cannot be negative".to_string());
+                    }
+                    let unit = parse_unit(next()?)?;
+                    options.shape = Some((Shape::Radius(radius * unit), unit));
+                }
+                "bybox" => {
+                    is_shape_repeated |= options.shape.is_some();
+                    let width = parse_redis_float(next()?).ok_or("ERR need numeric width")?;
+                    let height = parse_redis_float(next()?).ok_or("ERR need numeric height")?;
+                    if width < 0.0 || height < 0.0 {
+                        return Err("ERR height or width cannot be negative".to_string());
+                    }
+                    let unit = parse_unit(next()?)?;
+                    options.shape = Some((Shape::Box(width * unit, height * unit), unit));
+                }
+                "asc" => options.is_descending = Some(false),
+                "desc" => options.is_descending = Some(true),
+                "count" => {
+                    let count = parse_redis_int(next()?)
+                        .ok_or("ERR value is not an integer or out of range")?;
+                    options.count = Some(
+                        usize::try_from(count)
+                            .ok()
+                            .filter(|&count| count > 0)
+                            .ok_or("ERR COUNT must be > 0")?,
+                    );
+                }
+                "any" => options.any = true,
+                "withdist" => options.with_dist = true,
+                "withhash" => options.with_hash = true,
+                "withcoord" => options.with_coord = true,
+                _ => return Err("ERR syntax error".to_string()),
+            }
+        }
+
+        if options.center.is_none() || is_center_repeated {
+            return Err(
+                "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"
+                    .to_string(),
+            );
+        }
+        if options.shape.is_none() || is_shape_repeated {
+            return Err(
+                "ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH".to_string(),
+            );
+        }
+        if options.any && options.count.is_none() {
+            return Err("ERR the ANY argument requires COUNT argument".to_string());
+        }
+        Ok(options)
+    }
+}
+
+/// GEOSEARCH: find the members within the circle or the rectangle around a member or a position
+/// Every member of the sorted set is checked, rather than only those in the geohash areas around the center as Redis
+/// does. With `COUNT` the closest members are found unless `ANY` is given, in which case the first ones found are;
+/// the members are in no particular order unless `ASC` or `DESC` is given, or `COUNT` without `ANY`.
+pub fn geosearch(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<GeosearchOutput, String> {
+    if parsed_command.len() < 7 {
+        return Err(WRONG_ARITY.to_string());
+    }
+    let options = GeosearchOptions::parse(&parsed_command[2..])?;
+    let (shape, unit) = options.shape.unwrap();
+    let mut output = GeosearchOutput {
+        found: Vec::new(),
+        unit,
+        with_dist: options.with_dist,
+        with_hash: options.with_hash,
+        with_coord: options.with_coord,
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(sorted_set) = store.get_typed::<SortedSet>(&parsed_command[1])? else {
+        return Ok(output);
+    };
+    let center = match options.center {
+        Some(Center::Member(ref member)) => decode(
+            sorted_set
+                .score(member)
+                .ok_or("ERR could not decode requested zset member")?,
+        ),
+        Some(Center::Position(position)) => position,
+        None => unreachable!("GEOSEARCH requires a center"),
+    };
+    let found = sorted_set.iter().filter_map(|&(score, ref member)| {
+        shape
+            .distance_if_within(center, decode(score))
+            .map(|distance| Found {
+                member: member.clone(),
+                distance,
+                score,
+            })
+    });
+    output.found = match options.count {
+        Some(count) if options.any => found.take(count).collect(),
+        _ => found.collect(),
+    };
+    drop(store);
+
+    let is_descending = options
+        .is_descending
+        .or_else(|| (options.count.is_some() && !options.any).then_some(false));
+    if let Some(is_descending) = is_descending {
+        output.found.sort_by(|a, b| {
+            let by_distance = a.distance.total_cmp(&b.distance);
+            if is_descending {
+                by_distance.reverse()
+            } else {
+                by_distance
+            }
+        });
+    }
+    if let Some(count) = options.count {
+        output.found.truncate(count);
+    }
+    Ok(output)
+}
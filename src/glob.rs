@@ -0,0 +1,86 @@
+//! Glob-style pattern matching, as used by Redis for pattern subscriptions and for matching keys
+//! `*` matches any sequence of bytes, `?` any single byte and `[...]` any byte of a class such as `[abc]`, `[a-z]`
+//! or `[^a]`; `\` escapes the next byte.
+
+/// Match the byte against a class, given the pattern right after its `[`, returning whether it matched along with
+/// the length of the class up to and including its `]`
+/// An unterminated class ends with the pattern.
+fn match_class(class: &[u8], byte: u8) -> (bool, usize) {
+    let mut position = 0;
+    let is_negated = class.first() == Some(&b'^');
+    if is_negated {
+        position += 1;
+    }
+
+    let mut is_match = false;
+    while position < class.len() && class[position] != b']' {
+        match class[position] {
+            b'\\' if position + 1 < class.len() => {
+                position += 1;
+                is_match |= class[position] == byte;
+            }
+            start if class.get(position + 1) == Some(&b'-') && position + 2 < class.len() => {
+                let end = class[position + 2];
+                // Like Redis, a reversed range such as `[z-a]` is the same as `[a-z]`
+                let (low, high) = if start <= end {
+                    (start, end)
+                } else {
+                    (end, start)
+                };
+                is_match |= (low..=high).contains(&byte);
+                position += 2;
+            }
+            other => is_match |= other == byte,
+        }
+        position += 1;
+    }
+    // Skip the closing `]`, if any
+    (is_match != is_negated, (position + 1).min(class.len()))
+}
+
+/// Match a single byte against the element at the start of the pattern, which isn't `*`, returning the length of
+/// the element if it matched
+fn match_element(pattern: &[u8], byte: u8) -> Option<usize> {
+    match *pattern {
+        [b'?', ..] => Some(1),
+        [b'\\', escaped, ..] => (escaped == byte).then_some(2),
+        [b'[', ref class @ ..] => {
+            let (is_match, class_len) = match_class(class, byte);
+            is_match.then_some(1 + class_len)
+        }
+        [literal, ..] => (literal == byte).then_some(1),
+        [] => None,
+    }
+}
+
+/// Check whether the whole string matches the glob-style pattern
+pub fn matches(pattern: &[u8], string: &[u8]) -> bool {
+    let mut pattern_position = 0;
+    let mut string_position = 0;
+    // Position right after the last `*` seen, along with the position in the string it was matched up to so far;
+    // backtracking to the last `*` alone is enough, as it can absorb anything the earlier ones did.
+    let mut last_star = None;
+
+    while string_position < string.len() {
+        if pattern.get(pattern_position) == Some(&b'*') {
+            pattern_position += 1;
+            last_star = Some((pattern_position, string_position));
+            continue;
+        }
+        if let Some(element_len) =
+            match_element(&pattern[pattern_position..], string[string_position])
+        {
+            pattern_position += element_len;
+            string_position += 1;
+            continue;
+        }
+        // Let the last `*` absorb one more byte and try again from there
+        let Some((star_pattern_position, star_string_position)) = last_star else {
+            return false;
+        };
+        pattern_position = star_pattern_position;
+        string_position = star_string_position + 1;
+        last_star = Some((star_pattern_position, string_position));
+    }
+    pattern[pattern_position..].iter().all(|&byte| byte == b'*')
+}
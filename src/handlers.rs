@@ -0,0 +1,2236 @@
+//! Handlers of the commands, which run a command of a client and convert the output of its command function to RESP
+//! Every command of `command::COMMANDS` names its handler there, which is how the commands are dispatched.
+
+use std::{
+    collections::VecDeque,
+    slice,
+    time::{SystemTime, UNIX_EPOCH},
+};
+
+use crate::{
+    acl, aof, auth, bitmap, blocking_pop, clients, command, config, consumer_group, copy,
+    databases, debug, del, exists, expire, expire_time, failover, geo, handoff_reply, hash, hello,
+    hyperloglog, incr_by, incr_by_float, info, lcs_reply, list, lmove, lrange, memory, mpop,
+    object, persist, pop, pubsub, push, rdb, rename, replconf, restore, scan, select, set,
+    shutdown, slowlog, sort, store, stream, string, ttl, wait, waitaof, zset, BlockedAction,
+    BlockingPop, ClientAddr, ClientState, Execution, ListEnd, Pop, Protocol, RedisType, RespValue,
+    Server, SetOutput, SortOutput, SortedSetEnd, SubscriptionKind, WatchedKeys, Xread,
+};
+
+/// PING: reply PONG
+/// A RESP2 client in subscriber mode can't tell a reply from a message, so it gets a message-like reply
+pub fn ping_command(
+    _server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(
+        if client.subscription.is_some() && client.protocol == Protocol::Resp2 {
+            RespValue::bulk_string_array([
+                b"pong".to_vec(),
+                parsed_command.get(1).cloned().unwrap_or_default(),
+            ])
+        } else {
+            RespValue::simple("PONG")
+        },
+    )
+}
+
+/// HELLO: switch the protocol of the client, authenticate and name it, and reply with the properties of the server
+pub fn hello_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(hello(server, client, parsed_command).unwrap_or_else(RespValue::error))
+}
+
+/// AUTH: authenticate the client as a user
+pub fn auth_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(
+        auth(server, client, parsed_command)
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// ECHO: reply with the message
+pub fn echo_command(
+    _server: &Server,
+    _client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(RespValue::BulkString(parsed_command[1].clone()))
+}
+
+/// CLIENT: describe, name and kill the client connections
+pub fn client_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(clients::client(&server.clients, client, parsed_command))
+}
+
+/// ACL: administer the users and their permissions
+pub fn acl_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(acl::acl(
+        &server.acl,
+        &server.clients,
+        &client.user,
+        parsed_command,
+    ))
+}
+
+/// REPLCONF: configure the link of a replica
+pub fn replconf_command(
+    _server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(
+        replconf(client, parsed_command)
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// INFO: reply with the report about the server
+pub fn info_command(
+    server: &Server,
+    _client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(RespValue::BulkString(
+        info::info(server, parsed_command).into_bytes(),
+    ))
+}
+
+/// TIME: reply with the Unix time in seconds and the microseconds elapsed within the current second
+pub fn time_command(
+    _server: &Server,
+    _client: &mut ClientState,
+    _parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default();
+    Execution::Reply(RespValue::bulk_string_array([
+        now.as_secs().to_string().into_bytes(),
+        now.subsec_micros().to_string().into_bytes(),
+    ]))
+}
+
+/// SET: set the string, replying OK, or the old string with `GET`
+pub fn set_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    // Convert to RESP and return the result
+    Execution::Reply(match set(redis_key_val_store, parsed_command) {
+        Ok(SetOutput::Written) => RespValue::simple("OK"),
+        Ok(SetOutput::NotWritten | SetOutput::OldValue(None)) => RespValue::NullBulkString,
+        Ok(SetOutput::OldValue(Some(val))) => RespValue::BulkString(val),
+        Err(err) => RespValue::error(err),
+    })
+}
+
+/// GET: reply with the string, or null for a missing key
+pub fn get_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    // Expired keys are removed by the store on access; this is called "PASSIVE EXPIRY" in Redis
+    Execution::Reply(
+        redis_key_val_store
+            .lock()
+            .unwrap()
+            .get_typed::<Vec<u8>>(&parsed_command[1])
+            .map_or_else(RespValue::error, |val| {
+                // Return "Null bulk string" if the input key does not exist or has expired
+                val.map_or(RespValue::NullBulkString, |val| {
+                    RespValue::BulkString(val.clone())
+                })
+            }),
+    )
+}
+
+/// TTL/PTTL: reply with the remaining time to live of the key, in seconds or milliseconds respectively
+pub fn ttl_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let ttl_ms = ttl(redis_key_val_store, &parsed_command[1]);
+    // TTL is reported in seconds (rounded off) while PTTL is in milliseconds
+    let returned_value = if ttl_ms >= 0 && parsed_command[0].eq_ignore_ascii_case(b"ttl") {
+        (ttl_ms + 500) / 1000
+    } else {
+        ttl_ms
+    };
+    Execution::Reply(RespValue::Integer(returned_value))
+}
+
+/// SORT/`SORT_RO`: reply with the sorted elements, or store them in a destination key with `STORE`
+pub fn sort_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let blocked_clients = &server.blocked_clients;
+    Execution::Reply(
+        sort::sort(
+            redis_key_val_store,
+            blocked_clients,
+            client.db,
+            parsed_command,
+        )
+        .map_or_else(RespValue::error, SortOutput::into_resp),
+    )
+}
+
+/// EXPIRETIME/PEXPIRETIME: reply with the Unix time at which the key expires, in seconds or milliseconds respectively
+pub fn expiretime_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let expire_time_ms = expire_time(redis_key_val_store, &parsed_command[1]);
+    // Like Redis, EXPIRETIME truncates the time to seconds
+    let returned_value =
+        if expire_time_ms >= 0 && parsed_command[0].eq_ignore_ascii_case(b"expiretime") {
+            expire_time_ms / 1000
+        } else {
+            expire_time_ms
+        };
+    Execution::Reply(RespValue::Integer(returned_value))
+}
+
+/// EXPIRE/PEXPIRE/EXPIREAT/PEXPIREAT: set the TTL of the key, replying whether it was set
+pub fn expire_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        expire(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |set| RespValue::Integer(set.into())),
+    )
+}
+
+/// PERSIST: remove the TTL of the key, replying whether it had one
+pub fn persist_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        persist(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |removed| {
+            RespValue::Integer(removed.into())
+        }),
+    )
+}
+
+/// DEL/UNLINK: remove the keys, replying with how many existed
+pub fn del_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        del(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |removed| {
+            RespValue::Integer(i64::try_from(removed).unwrap())
+        }),
+    )
+}
+
+/// DUMP: reply with the value of the key serialized as RESTORE takes it
+pub fn dump_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let mut store = redis_key_val_store.lock().unwrap();
+    Execution::Reply(
+        store
+            .get(&parsed_command[1])
+            .map_or(RespValue::NullBulkString, |data| {
+                RespValue::BulkString(rdb::dump(data))
+            }),
+    )
+}
+
+/// RESTORE: create the key from a value serialized by DUMP
+pub fn restore_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let blocked_clients = &server.blocked_clients;
+    Execution::Reply(
+        restore(
+            redis_key_val_store,
+            client.db,
+            blocked_clients,
+            parsed_command,
+        )
+        .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// COPY: copy the value of the key to another key, replying whether it was copied
+pub fn copy_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(
+        copy(
+            &server.databases,
+            client.db,
+            &server.blocked_clients,
+            parsed_command,
+        )
+        .map_or_else(RespValue::error, |copied| RespValue::Integer(copied.into())),
+    )
+}
+
+/// RENAME: rename the key, replacing the new key if it exists
+pub fn rename_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let blocked_clients = &server.blocked_clients;
+    Execution::Reply(
+        rename(
+            redis_key_val_store,
+            client.db,
+            blocked_clients,
+            parsed_command,
+        )
+        .map_or_else(RespValue::error, |_| RespValue::simple("OK")),
+    )
+}
+
+/// RENAMENX: rename the key unless the new key exists, replying whether it was renamed
+pub fn renamenx_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let blocked_clients = &server.blocked_clients;
+    Execution::Reply(
+        rename(
+            redis_key_val_store,
+            client.db,
+            blocked_clients,
+            parsed_command,
+        )
+        .map_or_else(RespValue::error, |renamed| {
+            RespValue::Integer(renamed.into())
+        }),
+    )
+}
+
+/// MOVE: move the key to another database, replying whether it was moved
+pub fn move_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(
+        databases::move_key(&server.databases, client.db, parsed_command)
+            .map_or_else(RespValue::error, |moved| RespValue::Integer(moved.into())),
+    )
+}
+
+/// SELECT: switch the database of the client
+pub fn select_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(
+        select(server, client, parsed_command)
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// SWAPDB: swap the contents of two databases
+pub fn swapdb_command(
+    server: &Server,
+    _client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let blocked_clients = &server.blocked_clients;
+    Execution::Reply(
+        databases::swapdb(&server.databases, blocked_clients, parsed_command)
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// FLUSHDB: remove all the keys of the database of the client
+pub fn flushdb_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        databases::flush(slice::from_ref(redis_key_val_store), parsed_command)
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// FLUSHALL: remove all the keys of every database
+pub fn flushall_command(
+    server: &Server,
+    _client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(
+        databases::flush(&server.databases, parsed_command)
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// EXISTS/TOUCH: reply with how many of the keys exist
+pub fn exists_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        exists(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |count| {
+            RespValue::Integer(i64::try_from(count).unwrap())
+        }),
+    )
+}
+
+/// INCR/DECR/INCRBY/DECRBY: add to the integer of the string, replying with the new value
+pub fn incr_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    // Convert to RESP and return the result
+    Execution::Reply(match incr_by(redis_key_val_store, parsed_command) {
+        Ok(new_value) => RespValue::Integer(new_value),
+        Err(err) => RespValue::error(err),
+    })
+}
+
+/// INCRBYFLOAT: add to the number of the string, replying with the new value
+pub fn incrbyfloat_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        incr_by_float(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |val| {
+            RespValue::BulkString(val.into_bytes())
+        }),
+    )
+}
+
+/// GETRANGE: reply with the substring in the range of offsets
+pub fn getrange_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        string::getrange(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, RespValue::BulkString),
+    )
+}
+
+/// LCS: reply with the longest common subsequence of two strings, or its length or matches
+pub fn lcs_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        string::lcs(redis_key_val_store, parsed_command).map_or_else(RespValue::error, lcs_reply),
+    )
+}
+
+/// SETRANGE: overwrite the string from the offset on, replying with its new length
+pub fn setrange_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        string::setrange(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |len| {
+                RespValue::Integer(i64::try_from(len).unwrap())
+            }),
+    )
+}
+
+/// SETEX/PSETEX: set the string along with its TTL
+pub fn setex_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        string::setex(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// SETNX: set the string unless the key exists, replying whether it was set
+pub fn setnx_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        string::setnx(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |set| RespValue::Integer(set.into())),
+    )
+}
+
+/// MSET: set the strings of every key and value pair
+pub fn mset_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        string::mset(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |_| RespValue::simple("OK")),
+    )
+}
+
+/// MSETNX: set the strings of every key and value pair unless any of the keys exists, replying whether they
+/// were set
+pub fn msetnx_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        string::mset(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |set| RespValue::Integer(set.into())),
+    )
+}
+
+/// MGET: reply with the string of every key, null for a missing one
+pub fn mget_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        string::mget(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |vals| {
+            RespValue::Array(
+                vals.into_iter()
+                    .map(|val| val.map_or(RespValue::NullBulkString, RespValue::BulkString))
+                    .collect(),
+            )
+        }),
+    )
+}
+
+/// GETDEL: reply with the string and remove the key
+pub fn getdel_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        string::getdel(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |val| {
+            val.map_or(RespValue::NullBulkString, RespValue::BulkString)
+        }),
+    )
+}
+
+/// GETEX: reply with the string and update the TTL of the key
+pub fn getex_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        string::getex(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |val| {
+            val.map_or(RespValue::NullBulkString, RespValue::BulkString)
+        }),
+    )
+}
+
+/// SETBIT: set the bit at the offset, replying with its former value
+pub fn setbit_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        bitmap::setbit(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |bit| RespValue::Integer(bit.into())),
+    )
+}
+
+/// GETBIT: reply with the bit at the offset
+pub fn getbit_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        bitmap::getbit(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |bit| RespValue::Integer(bit.into())),
+    )
+}
+
+/// BITCOUNT: reply with the number of set bits of the string, or of the range of it
+pub fn bitcount_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        bitmap::bitcount(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |count| {
+                RespValue::Integer(i64::try_from(count).unwrap())
+            }),
+    )
+}
+
+/// BITOP: store the bitwise operation of the strings in the destination key, replying with its length
+pub fn bitop_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        bitmap::bitop(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |len| {
+            RespValue::Integer(i64::try_from(len).unwrap())
+        }),
+    )
+}
+
+/// BITPOS: reply with the position of the first bit set to the given value
+pub fn bitpos_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        bitmap::bitpos(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, RespValue::Integer),
+    )
+}
+
+/// PFADD: add the elements to the HLL, replying whether its estimate may have changed
+pub fn pfadd_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hyperloglog::pfadd(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |is_changed| {
+                RespValue::Integer(i64::from(is_changed))
+            }),
+    )
+}
+
+/// PFCOUNT: reply with the estimated number of distinct elements of the union of the HLLs
+pub fn pfcount_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hyperloglog::pfcount(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |count| {
+                RespValue::Integer(i64::try_from(count).unwrap())
+            }),
+    )
+}
+
+/// PFMERGE: store the union of the HLLs in the destination key
+pub fn pfmerge_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hyperloglog::pfmerge(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// APPEND/STRLEN: append to the string or get its length, replying with its length
+pub fn append_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let len = if parsed_command[0].eq_ignore_ascii_case(b"append") {
+        string::append(redis_key_val_store, parsed_command)
+    } else {
+        string::strlen(redis_key_val_store, parsed_command)
+    };
+    Execution::Reply(len.map_or_else(RespValue::error, |len| {
+        RespValue::Integer(i64::try_from(len).unwrap())
+    }))
+}
+
+/// TYPE: reply with the type of the value of the key
+pub fn type_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let type_name = redis_key_val_store
+        .lock()
+        .unwrap()
+        .get(&parsed_command[1])
+        .map_or("none", RedisType::type_name);
+    Execution::Reply(RespValue::simple(type_name))
+}
+
+/// OBJECT: describe the internals of the value of the key, e.g. its encoding
+pub fn object_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let policy = server.config.read().unwrap().maxmemory_policy;
+    Execution::Reply(object(redis_key_val_store, policy, parsed_command))
+}
+
+/// MEMORY: report the memory used for the data
+pub fn memory_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(memory::memory(server, client.db, parsed_command))
+}
+
+/// DEBUG: the subcommands meant for testing the server
+pub fn debug_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Execution {
+    debug(server, client.db, parsed_command, can_block)
+}
+
+/// KEYS: reply with the keys matching the pattern
+pub fn keys_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let keys = redis_key_val_store.lock().unwrap().keys(&parsed_command[1]);
+    Execution::Reply(RespValue::Array(
+        keys.into_iter().map(RespValue::BulkString).collect(),
+    ))
+}
+
+/// SCAN: reply with the next cursor and a batch of the keys of the database
+pub fn scan_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        scan::scan(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, scan::ScanOutput::into_resp),
+    )
+}
+
+/// HSCAN/SSCAN/ZSCAN: reply with the next cursor and a batch of the elements of the value
+pub fn hscan_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        scan::scan_value(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, scan::ScanOutput::into_resp),
+    )
+}
+
+/// RANDOMKEY: reply with a random key, or null if the database is empty
+pub fn randomkey_command(
+    server: &Server,
+    client: &mut ClientState,
+    _parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        redis_key_val_store
+            .lock()
+            .unwrap()
+            .random_key()
+            .map_or(RespValue::NullBulkString, RespValue::BulkString),
+    )
+}
+
+/// DBSIZE: reply with the number of keys of the database
+pub fn dbsize_command(
+    server: &Server,
+    client: &mut ClientState,
+    _parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let dbsize = redis_key_val_store.lock().unwrap().dbsize();
+    Execution::Reply(RespValue::Integer(i64::try_from(dbsize).unwrap()))
+}
+
+/// RPUSH/LPUSH: push the elements at the tail or head of the list, replying with its length
+pub fn rpush_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let blocked_clients = &server.blocked_clients;
+    let end = if parsed_command[0].eq_ignore_ascii_case(b"lpush") {
+        ListEnd::Left
+    } else {
+        ListEnd::Right
+    };
+    // Convert to RESP and return the result
+    Execution::Reply(
+        match push(
+            redis_key_val_store,
+            blocked_clients,
+            client.db,
+            parsed_command,
+            end,
+        ) {
+            Ok(len) => RespValue::Integer(i64::try_from(len).unwrap()),
+            Err(err) => RespValue::error(err),
+        },
+    )
+}
+
+/// LRANGE: reply with the elements in the range of indices
+pub fn lrange_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    // Convert to RESP and return the result
+    Execution::Reply(match lrange(redis_key_val_store, parsed_command) {
+        Ok(output_array) => RespValue::bulk_string_array(output_array),
+        Err(err) => RespValue::error(err),
+    })
+}
+
+/// LLEN: reply with the length of the list
+pub fn llen_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    if parsed_command.len() != 2 {
+        return Execution::Reply(RespValue::error(command::WRONG_ARITY));
+    }
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        redis_key_val_store
+            .lock()
+            .unwrap()
+            .get_typed::<VecDeque<Vec<u8>>>(&parsed_command[1])
+            .map_or_else(RespValue::error, |list| {
+                RespValue::Integer(i64::try_from(list.map_or(0, VecDeque::len)).unwrap())
+            }),
+    )
+}
+
+/// LPOP: pop elements from the head of the list
+pub fn lpop_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(pop(redis_key_val_store, parsed_command, ListEnd::Left))
+}
+
+/// RPOP: pop elements from the tail of the list
+pub fn rpop_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(pop(redis_key_val_store, parsed_command, ListEnd::Right))
+}
+
+/// LPOS: reply with the index of the element in the list, or the indices of its matches with `COUNT`
+pub fn lpos_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        list::lpos(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, list::LposOutput::into_resp),
+    )
+}
+
+/// LSET: replace the element at the index
+pub fn lset_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        list::lset(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// LINSERT: insert the element before or after the pivot, replying with the length of the list
+pub fn linsert_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        list::linsert(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, RespValue::Integer),
+    )
+}
+
+/// LREM: remove the occurrences of the element, replying with how many were removed
+pub fn lrem_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        list::lrem(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |removed| {
+            RespValue::Integer(i64::try_from(removed).unwrap())
+        }),
+    )
+}
+
+/// LTRIM: keep only the elements in the range of indices
+pub fn ltrim_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        list::ltrim(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// HSET: set the fields, replying with how many didn't exist before
+pub fn hset_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hash::hset(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |new_fields| {
+                RespValue::Integer(i64::try_from(new_fields).unwrap())
+            }),
+    )
+}
+
+/// HSETNX: set the field unless it exists, replying whether it was set
+pub fn hsetnx_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hash::hsetnx(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |is_set| RespValue::Integer(is_set.into())),
+    )
+}
+
+/// HGET: reply with the value of the field
+pub fn hget_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hash::hget(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |val| {
+            val.map_or(RespValue::NullBulkString, RespValue::BulkString)
+        }),
+    )
+}
+
+/// HDEL: remove the fields, replying with how many existed
+pub fn hdel_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hash::hdel(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |removed_fields| {
+                RespValue::Integer(i64::try_from(removed_fields).unwrap())
+            }),
+    )
+}
+
+/// HGETALL: reply with all the fields and values
+/// Replied as a map to RESP3 clients and as a flat array of fields and values otherwise
+pub fn hgetall_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hash::hgetall(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |pairs| {
+            RespValue::Map(
+                pairs
+                    .into_iter()
+                    .map(|(field, val)| (RespValue::BulkString(field), RespValue::BulkString(val)))
+                    .collect(),
+            )
+        }),
+    )
+}
+
+/// HLEN: reply with the number of fields
+pub fn hlen_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hash::hlen(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |len| {
+            RespValue::Integer(i64::try_from(len).unwrap())
+        }),
+    )
+}
+
+/// HINCRBY: add to the integer of the field, replying with the new value
+pub fn hincrby_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hash::hincrby(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, RespValue::Integer),
+    )
+}
+
+/// HINCRBYFLOAT: add to the number of the field, replying with the new value
+pub fn hincrbyfloat_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hash::hincrbyfloat(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |val| {
+                RespValue::BulkString(val.into_bytes())
+            }),
+    )
+}
+
+/// HRANDFIELD: reply with random fields, along with their values with `WITHVALUES`
+pub fn hrandfield_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hash::hrandfield(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |output| output.into_resp(client.protocol)),
+    )
+}
+
+/// HEXISTS: reply whether the field exists
+pub fn hexists_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hash::hexists(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |exists| RespValue::Integer(exists.into())),
+    )
+}
+
+/// HSTRLEN: reply with the length of the value of the field
+pub fn hstrlen_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        hash::hstrlen(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |len| {
+            RespValue::Integer(i64::try_from(len).unwrap())
+        }),
+    )
+}
+
+/// SADD: add the members, replying with how many weren't in the set before
+pub fn sadd_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        set::sadd(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |added_members| {
+                RespValue::Integer(i64::try_from(added_members).unwrap())
+            }),
+    )
+}
+
+/// SREM: remove the members, replying with how many were in the set
+pub fn srem_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        set::srem(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |removed_members| {
+                RespValue::Integer(i64::try_from(removed_members).unwrap())
+            }),
+    )
+}
+
+/// SMEMBERS: reply with all the members
+/// Replied as a set to RESP3 clients and as an array otherwise
+pub fn smembers_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        set::smembers(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |members| {
+                RespValue::Set(members.into_iter().map(RespValue::BulkString).collect())
+            }),
+    )
+}
+
+/// SPOP: remove random members, replying with them
+pub fn spop_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        set::spop(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |output| output.into_resp(client.protocol)),
+    )
+}
+
+/// SRANDMEMBER: reply with random members
+pub fn srandmember_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        set::srandmember(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |output| output.into_resp(client.protocol)),
+    )
+}
+
+/// SISMEMBER: reply whether the member is in the set
+pub fn sismember_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        set::sismember(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |is_member| {
+                RespValue::Integer(is_member.into())
+            }),
+    )
+}
+
+/// SMISMEMBER: reply whether every one of the members is in the set
+pub fn smismember_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        set::smismember(redis_key_val_store, parsed_command).map_or_else(
+            RespValue::error,
+            |are_members| {
+                RespValue::Array(
+                    are_members
+                        .into_iter()
+                        .map(|is_member| RespValue::Integer(is_member.into()))
+                        .collect(),
+                )
+            },
+        ),
+    )
+}
+
+/// SINTER/SUNION/SDIFF: reply with the members of the combination of the sets
+pub fn sinter_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        set::combine(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |members| {
+                RespValue::Set(members.into_iter().map(RespValue::BulkString).collect())
+            }),
+    )
+}
+
+/// SINTERSTORE/SUNIONSTORE/SDIFFSTORE: store the combination of the sets in the destination key, replying with its size
+pub fn sinterstore_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        set::combine_store(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |len| {
+                RespValue::Integer(i64::try_from(len).unwrap())
+            }),
+    )
+}
+
+/// SINTERCARD: reply with the size of the intersection of the sets
+pub fn sintercard_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        set::sintercard(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |count| {
+                RespValue::Integer(i64::try_from(count).unwrap())
+            }),
+    )
+}
+
+/// SCARD: reply with the number of members
+pub fn scard_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        set::scard(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |len| {
+            RespValue::Integer(i64::try_from(len).unwrap())
+        }),
+    )
+}
+
+/// ZADD: add or update the members with their scores
+pub fn zadd_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let blocked_clients = &server.blocked_clients;
+    Execution::Reply(
+        zset::zadd(
+            redis_key_val_store,
+            blocked_clients,
+            client.db,
+            parsed_command,
+        )
+        .map_or_else(RespValue::error, |count| {
+            RespValue::Integer(i64::try_from(count).unwrap())
+        }),
+    )
+}
+
+/// GEOADD: add the members at their positions
+pub fn geoadd_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let blocked_clients = &server.blocked_clients;
+    Execution::Reply(
+        geo::geoadd(
+            redis_key_val_store,
+            blocked_clients,
+            client.db,
+            parsed_command,
+        )
+        .map_or_else(RespValue::Error, |count| {
+            RespValue::Integer(i64::try_from(count).unwrap())
+        }),
+    )
+}
+
+/// GEOPOS: reply with the positions of the members
+pub fn geopos_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        geo::geopos(redis_key_val_store, parsed_command).map_or_else(
+            RespValue::error,
+            |positions| {
+                RespValue::Array(
+                    positions
+                        .into_iter()
+                        .map(|position| {
+                            position.map_or(RespValue::NullArray, |(longitude, latitude)| {
+                                RespValue::Array(vec![
+                                    RespValue::Double(longitude),
+                                    RespValue::Double(latitude),
+                                ])
+                            })
+                        })
+                        .collect(),
+                )
+            },
+        ),
+    )
+}
+
+/// GEODIST: reply with the distance between two members
+pub fn geodist_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        geo::geodist(redis_key_val_store, parsed_command).map_or_else(
+            RespValue::error,
+            |distance| {
+                distance.map_or(RespValue::NullBulkString, |distance| {
+                    RespValue::BulkString(geo::format_distance(distance).into_bytes())
+                })
+            },
+        ),
+    )
+}
+
+/// GEOSEARCH: reply with the members within the area
+pub fn geosearch_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        geo::geosearch(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::Error, geo::GeosearchOutput::into_resp),
+    )
+}
+
+/// ZSCORE: reply with the score of the member
+pub fn zscore_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        zset::zscore(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |score| {
+            score.map_or(RespValue::NullBulkString, RespValue::Double)
+        }),
+    )
+}
+
+/// ZINCRBY: add to the score of the member, replying with the new score
+pub fn zincrby_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let blocked_clients = &server.blocked_clients;
+    Execution::Reply(
+        zset::zincrby(
+            redis_key_val_store,
+            blocked_clients,
+            client.db,
+            parsed_command,
+        )
+        .map_or_else(RespValue::error, RespValue::Double),
+    )
+}
+
+/// ZREM: remove the members, replying with how many were in the sorted set
+pub fn zrem_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        zset::zrem(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |removed| {
+            RespValue::Integer(i64::try_from(removed).unwrap())
+        }),
+    )
+}
+
+/// ZINTERCARD: reply with the size of the intersection of the sorted sets
+pub fn zintercard_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        zset::zintercard(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |count| {
+                RespValue::Integer(i64::try_from(count).unwrap())
+            }),
+    )
+}
+
+/// ZCARD: reply with the number of members
+pub fn zcard_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        zset::zcard(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |len| {
+            RespValue::Integer(i64::try_from(len).unwrap())
+        }),
+    )
+}
+
+/// ZCOUNT: reply with the number of members between the minimum and the maximum score
+pub fn zcount_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        zset::zcount(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |count| {
+            RespValue::Integer(i64::try_from(count).unwrap())
+        }),
+    )
+}
+
+/// ZRANK/ZREVRANK: reply with the rank of the member
+pub fn zrank_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let reverse = parsed_command[0].eq_ignore_ascii_case(b"zrevrank");
+    Execution::Reply(
+        zset::zrank(redis_key_val_store, parsed_command, reverse)
+            .map_or_else(RespValue::error, zset::ZrankOutput::into_resp),
+    )
+}
+
+/// ZRANGEBYSCORE/ZREVRANGEBYSCORE/ZRANGEBYLEX/ZREVRANGEBYLEX: reply with the members in the range of scores or of
+/// members
+pub fn zrangebyscore_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        zset::zrange_by(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |output| output.into_resp(client.protocol)),
+    )
+}
+
+/// ZRANGE: reply with the members in the range
+pub fn zrange_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        zset::zrange(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |output| output.into_resp(client.protocol)),
+    )
+}
+
+/// XADD: append the entry to the stream, replying with its ID
+pub fn xadd_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let blocked_clients = &server.blocked_clients;
+    Execution::Reply(
+        stream::xadd(
+            redis_key_val_store,
+            blocked_clients,
+            client.db,
+            parsed_command,
+        )
+        .map_or_else(RespValue::error, |id| RespValue::BulkString(id.to_bytes())),
+    )
+}
+
+/// XREAD: reply with the entries of the streams after the given IDs, blocking until there are some with `BLOCK`
+pub fn xread_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let blocked_clients = &server.blocked_clients;
+    match stream::xread(
+        redis_key_val_store,
+        blocked_clients,
+        client.db,
+        parsed_command,
+        can_block,
+    ) {
+        Ok(Xread::Read(streams_entries)) => {
+            Execution::Reply(stream::streams_entries_resp(streams_entries))
+        }
+        Ok(Xread::Blocked(blocked_client, timeout, reads)) => {
+            Execution::Blocked(blocked_client, timeout, BlockedAction::Read(reads))
+        }
+        // Behaves as if the timeout elapsed right away
+        Ok(Xread::Empty) => Execution::Reply(RespValue::NullArray),
+        Err(err) => Execution::Reply(RespValue::error(err)),
+    }
+}
+
+/// XREADGROUP: deliver entries of the streams to the consumer of the group, blocking until there are some with `BLOCK`
+pub fn xreadgroup_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    let blocked_clients = &server.blocked_clients;
+    match consumer_group::xreadgroup(
+        redis_key_val_store,
+        blocked_clients,
+        client.db,
+        parsed_command,
+        can_block,
+    ) {
+        Ok(Xread::Read(streams_entries)) => {
+            Execution::Reply(stream::streams_entries_resp(streams_entries))
+        }
+        Ok(Xread::Blocked(blocked_client, timeout, group_read)) => Execution::Blocked(
+            blocked_client,
+            timeout,
+            BlockedAction::ReadGroup(group_read),
+        ),
+        Ok(Xread::Empty) => Execution::Reply(RespValue::NullArray),
+        Err(err) => Execution::Reply(RespValue::Error(err)),
+    }
+}
+
+/// XGROUP: administer the consumer groups of the stream
+pub fn xgroup_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        consumer_group::xgroup(redis_key_val_store, parsed_command)
+            .unwrap_or_else(RespValue::Error),
+    )
+}
+
+/// XACK: acknowledge the entries pending in the group, replying with how many were pending
+pub fn xack_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        consumer_group::xack(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |acked| {
+                RespValue::Integer(i64::try_from(acked).unwrap())
+            }),
+    )
+}
+
+/// XPENDING: reply with the entries pending in the group
+pub fn xpending_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        consumer_group::xpending(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::Error, consumer_group::XpendingOutput::into_resp),
+    )
+}
+
+/// XCLAIM: hand the pending entries over to another consumer
+pub fn xclaim_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        consumer_group::xclaim(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::Error, consumer_group::XclaimOutput::into_resp),
+    )
+}
+
+/// XRANGE: reply with the entries in the range of IDs
+pub fn xrange_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        stream::xrange(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, stream::entries_resp),
+    )
+}
+
+/// XLEN: reply with the number of entries
+pub fn xlen_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        stream::xlen(redis_key_val_store, parsed_command).map_or_else(RespValue::error, |len| {
+            RespValue::Integer(i64::try_from(len).unwrap())
+        }),
+    )
+}
+
+/// XDEL: remove the entries, replying with how many existed
+pub fn xdel_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        stream::xdel(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |deleted| {
+                RespValue::Integer(i64::try_from(deleted).unwrap())
+            }),
+    )
+}
+
+/// XTRIM: remove the oldest entries, replying with how many were removed
+pub fn xtrim_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        stream::xtrim(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |trimmed| {
+                RespValue::Integer(i64::try_from(trimmed).unwrap())
+            }),
+    )
+}
+
+/// XSETID: set the ID of the last entry of the stream
+pub fn xsetid_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        stream::xsetid(redis_key_val_store, parsed_command)
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// XINFO: describe the stream, its groups or their consumers
+pub fn xinfo_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        stream::xinfo(redis_key_val_store, parsed_command).unwrap_or_else(RespValue::Error),
+    )
+}
+
+/// ZPOPMIN: pop the members with the lowest scores
+pub fn zpopmin_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        zset::zpop(redis_key_val_store, parsed_command, SortedSetEnd::Min)
+            .map_or_else(RespValue::error, |output| output.into_resp(client.protocol)),
+    )
+}
+
+/// ZPOPMAX: pop the members with the highest scores
+pub fn zpopmax_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(
+        zset::zpop(redis_key_val_store, parsed_command, SortedSetEnd::Max)
+            .map_or_else(RespValue::error, |output| output.into_resp(client.protocol)),
+    )
+}
+
+/// LMOVE/RPOPLPUSH/BLMOVE: pop an element from a list and push it to another one
+pub fn lmove_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Execution {
+    lmove(
+        &server.databases[client.db],
+        &server.blocked_clients,
+        client.db,
+        parsed_command,
+        can_block,
+    )
+}
+
+/// LMPOP/ZMPOP/BLMPOP/BZMPOP: pop elements from the first of the keys which isn't empty
+pub fn lmpop_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Execution {
+    mpop(
+        &server.databases[client.db],
+        &server.blocked_clients,
+        client.db,
+        parsed_command,
+        can_block,
+    )
+}
+
+/// BLPOP/BRPOP/BZPOPMIN/BZPOPMAX: pop an element from the first of the keys which isn't empty, blocking until there
+/// is one
+pub fn blpop_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Execution {
+    let pop = match String::from_utf8_lossy(&parsed_command[0])
+        .to_lowercase()
+        .as_str()
+    {
+        "blpop" => Pop::List(ListEnd::Left),
+        "brpop" => Pop::List(ListEnd::Right),
+        "bzpopmin" => Pop::SortedSet(SortedSetEnd::Min),
+        _ => Pop::SortedSet(SortedSetEnd::Max),
+    };
+
+    match blocking_pop(
+        &server.databases[client.db],
+        &server.blocked_clients,
+        client.db,
+        parsed_command,
+        pop,
+        can_block,
+    ) {
+        Ok(BlockingPop::Popped(handoff)) => Execution::Reply(handoff_reply(handoff)),
+        Ok(BlockingPop::Blocked(blocked_client, timeout)) => {
+            Execution::Blocked(blocked_client, timeout, BlockedAction::Pop(pop))
+        }
+        // Behaves as if the timeout elapsed right away
+        Ok(BlockingPop::Empty) => Execution::Reply(RespValue::NullArray),
+        Err(err) => Execution::Reply(RespValue::error(err)),
+    }
+}
+
+/// WATCH: watch the keys, so that the next transaction fails if any of them is modified
+pub fn watch_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let redis_key_val_store = &server.databases[client.db];
+    Execution::Reply(if parsed_command.len() < 2 {
+        RespValue::error(command::WRONG_ARITY)
+    } else {
+        client
+            .watched_keys
+            .get_or_insert_with(WatchedKeys::default)
+            .watch(redis_key_val_store, &parsed_command[1..]);
+        RespValue::simple("OK")
+    })
+}
+
+/// LASTSAVE: reply with the Unix time of the last snapshot
+pub fn lastsave_command(
+    _server: &Server,
+    _client: &mut ClientState,
+    _parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(RespValue::Integer(i64::try_from(rdb::last_save()).unwrap()))
+}
+
+/// SAVE: save the keyspace to the RDB file
+pub fn save_command(
+    server: &Server,
+    _client: &mut ClientState,
+    _parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(
+        rdb::save(&server.databases, &server.config.read().unwrap().db_path())
+            .map_or_else(RespValue::error, |()| RespValue::simple("OK")),
+    )
+}
+
+/// BGSAVE: save the keyspace to the RDB file in the background
+/// Saving never has to wait for an AOF rewrite here, so `SCHEDULE` makes no difference
+pub fn bgsave_command(
+    server: &Server,
+    _client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(
+        if parsed_command.len() > 2
+            || parsed_command
+                .get(1)
+                .is_some_and(|arg| !arg.eq_ignore_ascii_case(b"schedule"))
+        {
+            RespValue::error("ERR syntax error")
+        } else {
+            rdb::bgsave(&server.databases, server.config.read().unwrap().db_path())
+                .map_or_else(RespValue::error, |()| {
+                    RespValue::simple("Background saving started")
+                })
+        },
+    )
+}
+
+/// BGREWRITEAOF: compact the AOF in the background
+pub fn bgrewriteaof_command(
+    server: &Server,
+    _client: &mut ClientState,
+    _parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(
+        aof::bgrewriteaof(
+            &server.aof,
+            &server.databases,
+            server.config.read().unwrap().aof_path(),
+        )
+        .map_or_else(RespValue::error, |()| {
+            RespValue::simple("Background append only file rewriting started")
+        }),
+    )
+}
+
+/// PSYNC: turn the connection into the link of a replica, continuing its stream from the backlog or sending it a
+/// snapshot
+pub fn psync_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let ip = client.addr.as_ref().and_then(ClientAddr::ip);
+    let continued = server.replicas.lock().unwrap().try_continue(
+        &parsed_command[1],
+        &parsed_command[2],
+        ip,
+        client.listening_port,
+    );
+    if let Some(new_replica) = continued {
+        return Execution::Replica(new_replica);
+    }
+    let snapshot = store::snapshot_databases(&server.databases);
+    let new_replica = server.replicas.lock().unwrap().register(
+        &rdb::encode(&snapshot),
+        ip,
+        client.listening_port,
+    );
+    Execution::Replica(new_replica)
+}
+
+/// SUBSCRIBE/PSUBSCRIBE: subscribe the client to the channels or patterns
+pub fn subscribe_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let kind = if parsed_command[0].eq_ignore_ascii_case(b"subscribe") {
+        SubscriptionKind::Channel
+    } else {
+        SubscriptionKind::Pattern
+    };
+    Execution::Replies(pubsub::subscribe(
+        &server.pubsub,
+        &mut client.subscription,
+        client.id,
+        &client.output,
+        kind,
+        &parsed_command[1..],
+    ))
+}
+
+/// UNSUBSCRIBE/PUNSUBSCRIBE: unsubscribe the client from the channels or patterns, or from all of them
+pub fn unsubscribe_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let kind = if parsed_command[0].eq_ignore_ascii_case(b"unsubscribe") {
+        SubscriptionKind::Channel
+    } else {
+        SubscriptionKind::Pattern
+    };
+    Execution::Replies(pubsub::unsubscribe(
+        &server.pubsub,
+        &mut client.subscription,
+        client.id,
+        kind,
+        &parsed_command[1..],
+    ))
+}
+
+/// PUBLISH: publish the message to the channel, replying with how many clients received it
+pub fn publish_command(
+    server: &Server,
+    _client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    let receivers = server
+        .pubsub
+        .lock()
+        .unwrap()
+        .publish(&parsed_command[1], &parsed_command[2]);
+    Execution::Reply(RespValue::Integer(i64::try_from(receivers).unwrap()))
+}
+
+/// CONFIG: get and set the parameters of the configuration
+pub fn config_command(
+    server: &Server,
+    _client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(config(server, parsed_command))
+}
+
+/// SHUTDOWN: shut the server down, saving the keyspace unless `NOSAVE` is given
+pub fn shutdown_command(
+    _server: &Server,
+    _client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    shutdown::parse_save(parsed_command).map_or_else(
+        |err| Execution::Reply(RespValue::error(err)),
+        Execution::Shutdown,
+    )
+}
+
+/// COMMAND: describe the supported commands
+pub fn command_command(
+    _server: &Server,
+    _client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(command::command(parsed_command))
+}
+
+/// SLOWLOG: get and reset the log of the slow commands
+pub fn slowlog_command(
+    server: &Server,
+    _client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(slowlog::slowlog(&server.slowlog, parsed_command))
+}
+
+/// WAIT: wait until enough replicas acknowledged the writes of the client
+pub fn wait_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Execution {
+    wait(server, client.write_offset, parsed_command, can_block)
+        .unwrap_or_else(|err| Execution::Reply(RespValue::error(err)))
+}
+
+/// FAILOVER: hand the role of master over to a replica, which isn't supported
+pub fn failover_command(
+    server: &Server,
+    _client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(RespValue::error(failover(server, parsed_command)))
+}
+
+/// WAITAOF: wait until the writes of the client are flushed to the AOF locally and on enough replicas
+pub fn waitaof_command(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Execution {
+    waitaof(server, client.write_offset, parsed_command, can_block)
+        .unwrap_or_else(|err| Execution::Reply(RespValue::error(err)))
+}
+
+/// UNWATCH: forget the watched keys
+/// Inside a transaction this has no effect, as EXEC unwatches the keys anyway
+pub fn unwatch_command(
+    _server: &Server,
+    client: &mut ClientState,
+    _parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    client.watched_keys = None;
+    Execution::Reply(RespValue::simple("OK"))
+}
+
+/// MULTI/EXEC/DISCARD, QUIT, RESET and MONITOR: reply with the arity error, as `dispatch` handles their valid calls
+/// since they act on the state of the connection
+pub fn handled_by_dispatch(
+    _server: &Server,
+    _client: &mut ClientState,
+    _parsed_command: &[Vec<u8>],
+    _can_block: bool,
+) -> Execution {
+    Execution::Reply(RespValue::error(command::WRONG_ARITY))
+}
@@ -0,0 +1,290 @@
+//! Commands on the hash data type, i.e. a map of fields to values
+//! A hash is created by the first field set in it and removed along with its last field.
+
+use std::{
+    collections::HashMap,
+    sync::{Arc, Mutex},
+};
+
+use crate::{
+    command::WRONG_ARITY,
+    format_redis_float,
+    notify::EventClass,
+    parse_redis_float, parse_redis_int,
+    sample::{self, SampleOutput},
+    store::KeyValStore,
+    NOT_FINITE,
+};
+
+/// Fields of a hash mapped to their values
+type Hash = HashMap<Vec<u8>, Vec<u8>>;
+/// Field/value pairs of a hash
+type FieldValuePairs = Vec<(Vec<u8>, Vec<u8>)>;
+
+/// HSET: set the field/value pairs, returning the number of fields which didn't exist before
+pub fn hset(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    // Every field must be followed by its value
+    if parsed_command.len() < 4 || !parsed_command.len().is_multiple_of(2) {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let hash = store.get_or_insert_typed::<Hash>(&parsed_command[1])?;
+
+    let new_fields = parsed_command[2..]
+        .chunks_exact(2)
+        .filter(|pair| hash.insert(pair[0].clone(), pair[1].clone()).is_none())
+        .count();
+    store.notify(EventClass::Hash, "hset", &parsed_command[1]);
+    drop(store);
+    Ok(new_fields)
+}
+
+/// HSETNX: set the field only if it doesn't exist, returning whether it was set
+/// The hash is created if the key doesn't exist.
+pub fn hsetnx(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<bool, &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let exists = store
+        .get_typed::<Hash>(&parsed_command[1])?
+        .is_some_and(|hash| hash.contains_key(&parsed_command[2]));
+    if exists {
+        return Ok(false);
+    }
+    store
+        .get_or_insert_typed::<Hash>(&parsed_command[1])?
+        .insert(parsed_command[2].clone(), parsed_command[3].clone());
+    store.notify(EventClass::Hash, "hset", &parsed_command[1]);
+    drop(store);
+    Ok(true)
+}
+
+/// HGET: get the value of the field
+pub fn hget(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Option<Vec<u8>>, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let val = store
+        .get_typed::<Hash>(&parsed_command[1])?
+        .and_then(|hash| hash.get(&parsed_command[2]).cloned());
+    drop(store);
+    Ok(val)
+}
+
+/// HDEL: remove the fields, returning the number of fields which existed
+/// The key is removed along with the last field.
+pub fn hdel(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(hash) = store.get_typed_mut::<Hash>(&parsed_command[1])? else {
+        return Ok(0);
+    };
+    let removed_fields = parsed_command[2..]
+        .iter()
+        .filter(|field| hash.remove(*field).is_some())
+        .count();
+    let is_hash_empty = hash.is_empty();
+    if removed_fields > 0 {
+        store.notify(EventClass::Hash, "hdel", &parsed_command[1]);
+    }
+    if is_hash_empty {
+        store.remove(&parsed_command[1]);
+        store.notify(EventClass::Generic, "del", &parsed_command[1]);
+    }
+    drop(store);
+    Ok(removed_fields)
+}
+
+/// HGETALL: get all the field/value pairs
+pub fn hgetall(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<FieldValuePairs, &'static str> {
+    if parsed_command.len() != 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let pairs = store
+        .get_typed::<Hash>(&parsed_command[1])?
+        .map_or_else(Vec::new, |hash| {
+            hash.iter()
+                .map(|(field, val)| (field.clone(), val.clone()))
+                .collect()
+        });
+    drop(store);
+    Ok(pairs)
+}
+
+/// HLEN: get the number of fields
+pub fn hlen(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() != 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let len = store
+        .get_typed::<Hash>(&parsed_command[1])?
+        .map_or(0, HashMap::len);
+    drop(store);
+    Ok(len)
+}
+
+/// HEXISTS: check whether the field exists
+pub fn hexists(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<bool, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let exists = store
+        .get_typed::<Hash>(&parsed_command[1])?
+        .is_some_and(|hash| hash.contains_key(&parsed_command[2]));
+    drop(store);
+    Ok(exists)
+}
+
+/// HSTRLEN: get the length of the value of the field, 0 if it doesn't exist
+pub fn hstrlen(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let len = store
+        .get_typed::<Hash>(&parsed_command[1])?
+        .and_then(|hash| hash.get(&parsed_command[2]))
+        .map_or(0, Vec::len);
+    drop(store);
+    Ok(len)
+}
+
+/// HRANDFIELD: get a random field, or random fields with a count, like `sample::sample` picks them, along with their
+/// values with `WITHVALUES`
+pub fn hrandfield(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<SampleOutput, &'static str> {
+    let count = match parsed_command.len() {
+        2 => None,
+        3 | 4 => Some(
+            parse_redis_int(&parsed_command[2])
+                .ok_or("ERR value is not an integer or out of range")?,
+        ),
+        _ => return Err(WRONG_ARITY),
+    };
+    let with_values = match parsed_command.get(3) {
+        Some(option) if option.eq_ignore_ascii_case(b"withvalues") => true,
+        Some(_) => return Err("ERR syntax error"),
+        None => false,
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let pairs: FieldValuePairs =
+        store
+            .get_typed::<Hash>(&parsed_command[1])?
+            .map_or_else(Vec::new, |hash| {
+                sample::sample(hash, count.unwrap_or(1))
+                    .into_iter()
+                    .map(|(field, val)| (field.clone(), val.clone()))
+                    .collect()
+            });
+    drop(store);
+    Ok(match count {
+        None => SampleOutput::Single(pairs.into_iter().next().map(|(field, _)| field)),
+        Some(_) if with_values => SampleOutput::WithValues(pairs),
+        Some(_) => SampleOutput::Many(pairs.into_iter().map(|(field, _)| field).collect()),
+    })
+}
+
+/// HINCRBY: add the integer to the value of the field, returning the new value
+/// A missing field is initialized to 0 before adding, and the hash is created if the key doesn't exist.
+pub fn hincrby(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<i64, &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+    let delta =
+        parse_redis_int(&parsed_command[3]).ok_or("ERR value is not an integer or out of range")?;
+
+    // The lock is held for the whole read-modify-write, so that concurrent updates are not lost
+    let mut store = redis_key_val_store.lock().unwrap();
+    let hash = store.get_or_insert_typed::<Hash>(&parsed_command[1])?;
+    let current_value = match hash.get(&parsed_command[2]) {
+        Some(val) => parse_redis_int(val).ok_or("ERR hash value is not an integer")?,
+        None => 0,
+    };
+    let new_value = current_value
+        .checked_add(delta)
+        .ok_or("ERR increment or decrement would overflow")?;
+    hash.insert(
+        parsed_command[2].clone(),
+        new_value.to_string().into_bytes(),
+    );
+    store.notify(EventClass::Hash, "hincrby", &parsed_command[1]);
+    drop(store);
+    Ok(new_value)
+}
+
+/// HINCRBYFLOAT: add the float to the value of the field, returning the new value as Redis formats it
+/// A missing field is initialized to 0 before adding, and the hash is created if the key doesn't exist.
+pub fn hincrbyfloat(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<String, &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+    let delta = parse_redis_float(&parsed_command[3]).ok_or("ERR value is not a valid float")?;
+    // Checked before touching the store, so that a missing key isn't created as an empty hash
+    if !delta.is_finite() {
+        return Err(NOT_FINITE);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let hash = store.get_or_insert_typed::<Hash>(&parsed_command[1])?;
+    let current_value = match hash.get(&parsed_command[2]) {
+        Some(val) => parse_redis_float(val).ok_or("ERR hash value is not a float")?,
+        None => 0.0,
+    };
+    let new_value = current_value + delta;
+    if !new_value.is_finite() {
+        return Err(NOT_FINITE);
+    }
+    let new_value = format_redis_float(new_value);
+    hash.insert(parsed_command[2].clone(), new_value.clone().into_bytes());
+    store.notify(EventClass::Hash, "hincrbyfloat", &parsed_command[1]);
+    drop(store);
+    Ok(new_value)
+}
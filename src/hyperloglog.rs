@@ -0,0 +1,381 @@
+//! `HyperLogLog` (HLL) commands, estimating the number of distinct elements added to a string with a standard error of 0.81%
+//! The estimate is kept in 16384 registers of 6 bits, stored in a string laid out exactly as in Redis: a header
+//! followed by the registers either packed in 12 KB ("dense") or run-length encoded ("sparse"), which takes much
+//! less space while most registers are 0. A string is sparse until it outgrows `SPARSE_MAX_LEN`, and dense from then
+//! on. Elements are hashed the same way as Redis, so that the same elements give the same estimates.
+
+use std::sync::{Arc, Mutex};
+
+use crate::{
+    command::WRONG_ARITY,
+    notify::EventClass,
+    store::{KeyValStore, RedisType},
+};
+
+/// Number of bits of the hash of an element choosing its register
+const P: u32 = 14;
+/// Number of registers
+const REGISTERS: usize = 1 << P;
+/// Number of bits of the hash of an element left to count the zeros of
+const Q: u32 = 64 - P;
+/// Number of bits of a register in the dense encoding
+const REGISTER_BITS: usize = 6;
+/// Length of the header: the magic, the encoding, 3 unused bytes and the cached estimate
+const HEADER_LEN: usize = 16;
+/// Magic at the start of the header
+const MAGIC: &[u8] = b"HYLL";
+/// Encoding byte of the dense encoding
+const DENSE: u8 = 0;
+/// Encoding byte of the sparse encoding
+const SPARSE: u8 = 1;
+/// Length of a dense string
+const DENSE_LEN: usize = HEADER_LEN + REGISTERS * REGISTER_BITS / 8;
+/// Maximum length of a sparse string, past which it becomes dense (same as the default `hll-sparse-max-bytes` of
+/// Redis)
+const SPARSE_MAX_LEN: usize = 3000;
+/// Maximum value of a register in the sparse encoding
+const SPARSE_MAX_VALUE: u8 = 32;
+/// Seed of the hash of the elements (same as Redis)
+const HASH_SEED: u64 = 0xadc8_3b19;
+/// Constant of the estimator for a large number of registers, i.e. `1 / (2 ln 2)`
+const ALPHA: f64 = 0.721_347_520_444_481_7;
+/// Error of a string which isn't an HLL
+const NOT_HLL: &str = "WRONGTYPE Key is not a valid HyperLogLog string value.";
+/// Error of a sparse string whose runs don't add up
+const CORRUPTED: &str = "INVALIDOBJ Corrupted HLL object detected";
+
+/// 64-bit `MurmurHash2` of the bytes, as Redis hashes the elements
+fn murmur_hash_64a(bytes: &[u8], seed: u64) -> u64 {
+    const M: u64 = 0xc6a4_a793_5bd1_e995;
+    const R: u32 = 47;
+    let mut hash = seed ^ u64::try_from(bytes.len()).unwrap().wrapping_mul(M);
+    let chunks = bytes.chunks_exact(8);
+    let tail = chunks.remainder();
+    for chunk in chunks {
+        let mut k = u64::from_le_bytes(chunk.try_into().unwrap()).wrapping_mul(M);
+        k ^= k >> R;
+        hash ^= k.wrapping_mul(M);
+        hash = hash.wrapping_mul(M);
+    }
+    if !tail.is_empty() {
+        for (i, &byte) in tail.iter().enumerate() {
+            hash ^= u64::from(byte) << (8 * i);
+        }
+        hash = hash.wrapping_mul(M);
+    }
+    hash ^= hash >> R;
+    hash = hash.wrapping_mul(M);
+    hash ^ (hash >> R)
+}
+
+/// `sigma` function of the estimator of Ertl, as used by Redis
+#[expect(
+    clippy::float_cmp,
+    reason = "The series is summed until a term no longer changes the sum, as in Redis"
+)]
+fn sigma(mut x: f64) -> f64 {
+    if x == 1.0 {
+        return f64::INFINITY;
+    }
+    let (mut y, mut z) = (1.0, x);
+    loop {
+        x *= x;
+        let previous = z;
+        z += x * y;
+        y += y;
+        if previous == z {
+            return z;
+        }
+    }
+}
+
+/// `tau` function of the estimator of Ertl, as used by Redis
+#[expect(
+    clippy::float_cmp,
+    reason = "The series is summed until a term no longer changes the sum, as in Redis"
+)]
+fn tau(mut x: f64) -> f64 {
+    if x == 0.0 || x == 1.0 {
+        return 0.0;
+    }
+    let (mut y, mut z) = (1.0, 1.0 - x);
+    loop {
+        x = x.sqrt();
+        let previous = z;
+        y *= 0.5;
+        z -= (1.0 - x).powi(2) * y;
+        if previous == z {
+            return z / 3.0;
+        }
+    }
+}
+
+/// The registers of an HLL along with its encoding and cached estimate
+struct HyperLogLog {
+    /// Value of every register, i.e. the longest run of trailing zeros plus one among the hashes it counts
+    registers: Vec<u8>,
+    /// Whether the string is dense, which it stays once it is
+    is_dense: bool,
+    /// Estimate cached in the header, unless a change made it stale
+    cached_count: Option<u64>,
+}
+
+impl HyperLogLog {
+    /// An empty HLL, which is sparse
+    fn new() -> Self {
+        Self {
+            registers: vec![0; REGISTERS],
+            is_dense: false,
+            cached_count: Some(0),
+        }
+    }
+
+    /// Parse the string, which must be a valid HLL
+    fn parse(bytes: &[u8]) -> Result<Self, &'static str> {
+        if bytes.len() < HEADER_LEN || !bytes.starts_with(MAGIC) {
+            return Err(NOT_HLL);
+        }
+        let (header, body) = bytes.split_at(HEADER_LEN);
+        let cache = u64::from_le_bytes(header[8..].try_into().unwrap());
+        // The most significant bit of the cache is set once it is stale
+        let cached_count = (cache >> 63 == 0).then_some(cache);
+        let registers = match header[4] {
+            DENSE if bytes.len() == DENSE_LEN => (0..REGISTERS)
+                .map(|index| {
+                    (0..REGISTER_BITS).fold(0, |register, bit| {
+                        let position = index * REGISTER_BITS + bit;
+                        register | (((body[position / 8] >> (position % 8)) & 1) << bit)
+                    })
+                })
+                .collect(),
+            SPARSE => Self::parse_sparse(body)?,
+            _ => return Err(NOT_HLL),
+        };
+        Ok(Self {
+            registers,
+            is_dense: header[4] == DENSE,
+            cached_count,
+        })
+    }
+
+    /// Parse the runs of the sparse encoding into the registers
+    /// A run is encoded as `00xxxxxx` for up to 64 zeros, `01xxxxxx yyyyyyyy` for up to 16384 zeros, or `1vvvvvxx`
+    /// for up to 4 registers of a value up to 32.
+    fn parse_sparse(body: &[u8]) -> Result<Vec<u8>, &'static str> {
+        let mut registers = Vec::with_capacity(REGISTERS);
+        let mut bytes = body.iter();
+        while let Some(&byte) = bytes.next() {
+            let (value, run) = match byte >> 6 {
+                0b00 => (0, usize::from(byte & 0x3f) + 1),
+                0b01 => {
+                    let &low = bytes.next().ok_or(CORRUPTED)?;
+                    (0, (usize::from(byte & 0x3f) << 8 | usize::from(low)) + 1)
+                }
+                _ => (((byte >> 2) & 0x1f) + 1, usize::from(byte & 0b11) + 1),
+            };
+            if registers.len() + run > REGISTERS {
+                return Err(CORRUPTED);
+            }
+            registers.resize(registers.len() + run, value);
+        }
+        if registers.len() != REGISTERS {
+            return Err(CORRUPTED);
+        }
+        Ok(registers)
+    }
+
+    /// Serialize as a string, sparse unless it is dense already or doesn't fit the sparse encoding
+    fn serialize(&self) -> Vec<u8> {
+        let sparse = (!self.is_dense).then(|| self.serialize_sparse()).flatten();
+        let mut bytes = MAGIC.to_vec();
+        bytes.extend([if sparse.is_some() { SPARSE } else { DENSE }, 0, 0, 0]);
+        bytes.extend(self.cached_count.unwrap_or(1 << 63).to_le_bytes());
+        if let Some(sparse) = sparse {
+            bytes.extend(sparse);
+            return bytes;
+        }
+        bytes.resize(DENSE_LEN, 0);
+        for (index, &register) in self.registers.iter().enumerate() {
+            for bit in 0..REGISTER_BITS {
+                let position = index * REGISTER_BITS + bit;
+                bytes[HEADER_LEN + position / 8] |= ((register >> bit) & 1) << (position % 8);
+            }
+        }
+        bytes
+    }
+
+    /// Runs of the sparse encoding of the registers, or None if a register is too large for it or the runs are too
+    /// long
+    fn serialize_sparse(&self) -> Option<Vec<u8>> {
+        let mut runs = Vec::new();
+        let mut index = 0;
+        while index < REGISTERS {
+            let value = self.registers[index];
+            let run = self.registers[index..]
+                .iter()
+                .take_while(|&&register| register == value)
+                .count();
+            index += run;
+            if value > SPARSE_MAX_VALUE {
+                return None;
+            }
+            if value == 0 {
+                // Both run lengths are stored minus one, in 6 bits or in 14 bits
+                let run_minus_one = u16::try_from(run - 1).unwrap();
+                if run <= 64 {
+                    runs.push(u8::try_from(run_minus_one).unwrap());
+                } else {
+                    runs.extend((run_minus_one | 0x4000).to_be_bytes());
+                }
+                continue;
+            }
+            for chunk in (0..run).collect::<Vec<_>>().chunks(4) {
+                runs.push(0x80 | (value - 1) << 2 | u8::try_from(chunk.len() - 1).unwrap());
+            }
+        }
+        (HEADER_LEN + runs.len() <= SPARSE_MAX_LEN).then_some(runs)
+    }
+
+    /// Count the element, returning whether a register changed
+    fn add(&mut self, element: &[u8]) -> bool {
+        let hash = murmur_hash_64a(element, HASH_SEED);
+        let index = usize::try_from(hash).unwrap() & (REGISTERS - 1);
+        // The bit past the hash bounds the count, even for a hash of only zeros
+        let count = u8::try_from(((hash >> P) | (1 << Q)).trailing_zeros()).unwrap() + 1;
+        if count <= self.registers[index] {
+            return false;
+        }
+        self.registers[index] = count;
+        self.cached_count = None;
+        true
+    }
+
+    /// Count the elements counted by the other HLL too
+    fn merge(&mut self, other: &Self) {
+        for (register, &other_register) in self.registers.iter_mut().zip(&other.registers) {
+            *register = (*register).max(other_register);
+        }
+        self.is_dense |= other.is_dense;
+        self.cached_count = None;
+    }
+
+    /// Estimate the number of distinct elements counted, by the estimator of Ertl as Redis does
+    #[expect(
+        clippy::cast_possible_truncation,
+        clippy::cast_sign_loss,
+        reason = "The estimate is a positive number well within the range of u64"
+    )]
+    fn count(&self) -> u64 {
+        let mut histogram = [0_u32; Q as usize + 2];
+        for &register in &self.registers {
+            histogram[usize::from(register)] += 1;
+        }
+        let m = f64::from(u32::try_from(REGISTERS).unwrap());
+        let mut z = m * tau((m - f64::from(histogram[Q as usize + 1])) / m);
+        for &count in histogram[1..=Q as usize].iter().rev() {
+            z = (z + f64::from(count)) * 0.5;
+        }
+        z += m * sigma(f64::from(histogram[0]) / m);
+        (ALPHA * m * m / z).round() as u64
+    }
+}
+
+/// Parse the HLL at the key, if it exists
+fn get(store: &mut KeyValStore, key: &[u8]) -> Result<Option<HyperLogLog>, &'static str> {
+    store
+        .get_typed::<Vec<u8>>(key)?
+        .map(|bytes| HyperLogLog::parse(bytes))
+        .transpose()
+}
+
+/// PFADD: count the elements in the HLL, creating it if the key doesn't exist, returning whether its
+/// registers changed or it was created
+pub fn pfadd(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<bool, &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(WRONG_ARITY);
+    }
+    let key = &parsed_command[1];
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let (mut hll, mut is_changed) =
+        get(&mut store, key)?.map_or_else(|| (HyperLogLog::new(), true), |hll| (hll, false));
+    for element in &parsed_command[2..] {
+        is_changed |= hll.add(element);
+    }
+    if is_changed {
+        // The existing TTL is kept
+        *store.get_or_insert_typed::<Vec<u8>>(key)? = hll.serialize();
+        store.notify(EventClass::String, "pfadd", key);
+    }
+    drop(store);
+    Ok(is_changed)
+}
+
+/// PFCOUNT: estimate the number of distinct elements counted by the union of the HLLs, skipping the missing
+/// keys
+/// The estimate of a single HLL is cached in its header, but isn't a change of its value.
+pub fn pfcount(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<u64, &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    if let [_, ref key] = *parsed_command {
+        let Some(mut hll) = get(&mut store, key)? else {
+            return Ok(0);
+        };
+        if let Some(count) = hll.cached_count {
+            return Ok(count);
+        }
+        let count = hll.count();
+        hll.cached_count = Some(count);
+        if let Some(bytes) = store.get_typed_mut::<Vec<u8>>(key)? {
+            *bytes = hll.serialize();
+        }
+        return Ok(count);
+    }
+    let mut union = HyperLogLog::new();
+    for key in &parsed_command[1..] {
+        if let Some(hll) = get(&mut store, key)? {
+            union.merge(&hll);
+        }
+    }
+    drop(store);
+    Ok(union.count())
+}
+
+/// PFMERGE: store the union of the source HLLs and the destination one, if it exists, at the destination
+/// key
+/// The union is dense if any of them is.
+pub fn pfmerge(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<(), &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(WRONG_ARITY);
+    }
+    let destination = &parsed_command[1];
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let mut union = get(&mut store, destination)?.unwrap_or_else(HyperLogLog::new);
+    for key in &parsed_command[2..] {
+        if let Some(hll) = get(&mut store, key)? {
+            union.merge(&hll);
+        }
+    }
+    union.cached_count = None;
+    match store.get_typed_mut::<Vec<u8>>(destination)? {
+        // The existing TTL is kept
+        Some(bytes) => *bytes = union.serialize(),
+        None => store.insert(destination.clone(), RedisType::Val(union.serialize()), None),
+    }
+    store.notify(EventClass::String, "pfadd", destination);
+    drop(store);
+    Ok(())
+}
@@ -0,0 +1,129 @@
+//! INFO: a report about the server for monitoring, made of sections of `field:value` lines
+//! Every section starts with a `# Name` header and is separated from the next one by an empty line.
+
+use std::{fmt::Write as _, process};
+
+use crate::{Server, REDIS_VERSION};
+
+/// Lines of a section of the report
+type Section = fn(&Server) -> Vec<String>;
+
+/// Sections of the report in the order they are written, by name and by title
+const SECTIONS: &[(&str, &str, Section)] = &[
+    ("server", "Server", server_section),
+    ("clients", "Clients", clients_section),
+    ("replication", "Replication", replication_section),
+    ("keyspace", "Keyspace", keyspace_section),
+];
+
+/// Compute output of the INFO command: the requested sections, or all of them if none or `default`, `all` or
+/// `everything` is requested
+/// Unknown sections are skipped, so the report may be empty.
+pub fn info(server: &Server, parsed_command: &[Vec<u8>]) -> String {
+    let requested: Vec<String> = parsed_command[1..]
+        .iter()
+        .map(|section| String::from_utf8_lossy(section).to_lowercase())
+        .collect();
+    let is_everything = requested.is_empty()
+        || requested
+            .iter()
+            .any(|section| matches!(section.as_str(), "default" | "all" | "everything"));
+
+    let mut report = String::new();
+    for &(name, title, section) in SECTIONS {
+        if !is_everything && !requested.iter().any(|section| section == name) {
+            continue;
+        }
+        if !report.is_empty() {
+            report.push_str("\r\n");
+        }
+        let _ = write!(report, "# {title}\r\n");
+        for line in section(server) {
+            let _ = write!(report, "{line}\r\n");
+        }
+    }
+    report
+}
+
+/// `# Server`: the version and the identity of this run
+fn server_section(server: &Server) -> Vec<String> {
+    let uptime = server.started_at.elapsed().as_secs();
+    vec![
+        format!("redis_version:{REDIS_VERSION}"),
+        format!("process_id:{}", process::id()),
+        format!("run_id:{}", server.run_id),
+        format!("tcp_port:{}", server.config.read().unwrap().port),
+        format!("uptime_in_seconds:{uptime}"),
+        format!("uptime_in_days:{}", uptime / 86400),
+    ]
+}
+
+/// `# Clients`: the client connections, which don't include the links of the replicas
+fn clients_section(server: &Server) -> Vec<String> {
+    vec![format!(
+        "connected_clients:{}",
+        server.clients.lock().unwrap().count()
+    )]
+}
+
+/// `# Replication`: the master followed by this server if it is a replica, and the replicas following it
+/// A replica reports the replication ID of its master and the offset of its stream processed so far, like Redis.
+fn replication_section(server: &Server) -> Vec<String> {
+    let replication = server.replication.lock().unwrap();
+    let (mut lines, master_position) = match server.config.read().unwrap().replicaof {
+        Some((ref host, port)) => {
+            let mut lines = vec![
+                "role:slave".to_owned(),
+                format!("master_host:{host}"),
+                format!("master_port:{port}"),
+            ];
+            lines.extend(replication.describe());
+            let master_position = (
+                replication.replid().map(str::to_owned),
+                replication.offset(),
+            );
+            (lines, Some(master_position))
+        }
+        None => (vec!["role:master".to_owned()], None),
+    };
+    drop(replication);
+    let replicas = server.replicas.lock().unwrap();
+    let replica_links = replicas.describe_links();
+    lines.push(format!("connected_slaves:{}", replica_links.len()));
+    for (i, link) in replica_links.iter().enumerate() {
+        lines.push(format!("slave{i}:{link}"));
+    }
+    // Until it synchronized, a replica has no replication ID but its own
+    let (replid, offset) = master_position.map_or_else(
+        || (replicas.replid().to_owned(), replicas.offset()),
+        |(replid, offset)| {
+            (
+                replid.unwrap_or_else(|| replicas.replid().to_owned()),
+                offset,
+            )
+        },
+    );
+    lines.push(format!("master_replid:{replid}"));
+    let (replid2, second_offset) = replicas.replid2();
+    lines.push(format!("master_replid2:{replid2}"));
+    lines.push(format!("master_repl_offset:{offset}"));
+    lines.push(format!("second_repl_offset:{second_offset}"));
+    lines.extend(replicas.describe_backlog());
+    drop(replicas);
+    lines
+}
+
+/// `# Keyspace`: the number of keys of every database which has any
+fn keyspace_section(server: &Server) -> Vec<String> {
+    server
+        .databases
+        .iter()
+        .enumerate()
+        .filter_map(|(db, store)| {
+            let store = store.lock().unwrap();
+            let (keys, expires) = (store.len(), store.volatile_len());
+            drop(store);
+            (keys > 0).then(|| format!("db{db}:keys={keys},expires={expires},avg_ttl=0"))
+        })
+        .collect()
+}
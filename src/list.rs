@@ -0,0 +1,251 @@
+//! Commands searching and editing the list data type in place: LPOS, LSET, LINSERT, LREM and LTRIM
+//! Indices count from the end of the list when they are negative, as in every list command.
+
+use std::{
+    collections::VecDeque,
+    ops::RangeInclusive,
+    sync::{Arc, Mutex},
+};
+
+use crate::{
+    command::WRONG_ARITY, notify::EventClass, parse_redis_int, resp::RespValue, store::KeyValStore,
+};
+
+/// Elements of a list, from its head to its tail
+type List = VecDeque<Vec<u8>>;
+
+/// Error of an index which isn't an integer
+const NOT_AN_INTEGER: &str = "ERR value is not an integer or out of range";
+
+/// Indices of the elements from `start` to `stop` in a list of the length, as given to LRANGE and LTRIM, or None if
+/// there isn't any
+/// Negative indices count from the end of the list; out of range indices are clamped to the list.
+pub fn clamp_range(start: i64, stop: i64, len: usize) -> Option<RangeInclusive<usize>> {
+    // Crash if aren't able to go from usize to i64
+    let len = i64::try_from(len).unwrap();
+    let start = if start < 0 { start + len } else { start }.max(0);
+    let stop = if stop < 0 { stop + len } else { stop }.min(len - 1);
+    // Both indices lie within the list when they aren't crossed, so they always fit in usize
+    (start <= stop).then(|| usize::try_from(start).unwrap()..=usize::try_from(stop).unwrap())
+}
+
+/// Index of the element at `index` in a list of the length, where negative indices count from the end, or None if
+/// it is out of range
+fn element_index(index: i64, len: usize) -> Option<usize> {
+    let len = i64::try_from(len).unwrap();
+    let index = if index < 0 { index + len } else { index };
+    usize::try_from(index)
+        .ok()
+        .filter(|&index| i64::try_from(index).unwrap() < len)
+}
+
+/// Output of LPOS
+pub enum LposOutput {
+    /// Index of the match, without `COUNT`
+    Index(Option<usize>),
+    /// Indices of the matches, with `COUNT`
+    Indices(Vec<usize>),
+}
+
+impl LposOutput {
+    /// Convert the output to RESP
+    pub fn into_resp(self) -> RespValue {
+        let integer = |index: usize| RespValue::Integer(i64::try_from(index).unwrap());
+        match self {
+            Self::Index(index) => index.map_or(RespValue::NullBulkString, integer),
+            Self::Indices(indices) => RespValue::Array(indices.into_iter().map(integer).collect()),
+        }
+    }
+}
+
+/// LPOS: find the indices of the element in the list
+/// `RANK` skips the first matches, counting from the tail when negative; `COUNT` returns up to that many matches,
+/// all of them if 0, rather than the first one; `MAXLEN` compares only that many elements, all of them if 0.
+pub fn lpos(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<LposOutput, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+    let (mut rank, mut count, mut maxlen) = (1, None, 0);
+    for option in parsed_command[3..].chunks(2) {
+        let [ref name, ref value] = *option else {
+            return Err("ERR syntax error");
+        };
+        let value = parse_redis_int(value).ok_or(NOT_AN_INTEGER)?;
+        if name.eq_ignore_ascii_case(b"rank") {
+            if value == 0 {
+                return Err("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list");
+            }
+            if value == i64::MIN {
+                return Err("ERR value is out of range, value must between -9223372036854775807 and 9223372036854775807");
+            }
+            rank = value;
+        } else if name.eq_ignore_ascii_case(b"count") {
+            count = Some(usize::try_from(value).map_err(|_| "ERR COUNT can't be negative")?);
+        } else if name.eq_ignore_ascii_case(b"maxlen") {
+            maxlen = usize::try_from(value).map_err(|_| "ERR MAXLEN can't be negative")?;
+        } else {
+            return Err("ERR syntax error");
+        }
+    }
+    let skipped = usize::try_from(rank.unsigned_abs() - 1).unwrap_or(usize::MAX);
+    let limit = match count {
+        None => 1,
+        Some(0) => usize::MAX,
+        Some(count) => count,
+    };
+    let maxlen = if maxlen == 0 { usize::MAX } else { maxlen };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let list = store.get_typed::<List>(&parsed_command[1])?;
+    let indices: Vec<usize> = list.map_or_else(Vec::new, |list| {
+        // A negative rank scans from the tail
+        let len = list.len();
+        (0..len)
+            .map(|i| if rank < 0 { len - 1 - i } else { i })
+            .take(maxlen)
+            .filter(|&index| list[index] == parsed_command[2])
+            .skip(skipped)
+            .take(limit)
+            .collect()
+    });
+    drop(store);
+    Ok(match count {
+        None => LposOutput::Index(indices.first().copied()),
+        Some(_) => LposOutput::Indices(indices),
+    })
+}
+
+/// LSET: replace the element at the index
+pub fn lset(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<(), &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+    let index = parse_redis_int(&parsed_command[2]).ok_or(NOT_AN_INTEGER)?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let list = store
+        .get_typed_mut::<List>(&parsed_command[1])?
+        .ok_or("ERR no such key")?;
+    let index = element_index(index, list.len()).ok_or("ERR index out of range")?;
+    list[index].clone_from(&parsed_command[3]);
+    store.notify(EventClass::List, "lset", &parsed_command[1]);
+    drop(store);
+    Ok(())
+}
+
+/// LINSERT: insert the element before or after the first occurrence of the pivot, returning the length of the list
+/// after the insertion, 0 if the key doesn't exist or -1 if the pivot isn't in the list
+pub fn linsert(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<i64, &'static str> {
+    if parsed_command.len() != 5 {
+        return Err(WRONG_ARITY);
+    }
+    let is_after = if parsed_command[2].eq_ignore_ascii_case(b"after") {
+        true
+    } else if parsed_command[2].eq_ignore_ascii_case(b"before") {
+        false
+    } else {
+        return Err("ERR syntax error");
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(list) = store.get_typed_mut::<List>(&parsed_command[1])? else {
+        return Ok(0);
+    };
+    let Some(pivot_index) = list.iter().position(|val| *val == parsed_command[3]) else {
+        return Ok(-1);
+    };
+    list.insert(
+        pivot_index + usize::from(is_after),
+        parsed_command[4].clone(),
+    );
+    let len = list.len();
+    store.notify(EventClass::List, "linsert", &parsed_command[1]);
+    drop(store);
+    Ok(i64::try_from(len).unwrap())
+}
+
+/// LREM: remove the first `count` occurrences of the element from the head of the list, or from its tail if `count` is
+/// negative, or all of them if it is 0, returning the number of removed elements
+/// The key is removed along with the last element.
+pub fn lrem(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+    let count = parse_redis_int(&parsed_command[2]).ok_or(NOT_AN_INTEGER)?;
+    let limit = usize::try_from(count.unsigned_abs()).unwrap_or(usize::MAX);
+    let limit = if count == 0 { usize::MAX } else { limit };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(list) = store.get_typed_mut::<List>(&parsed_command[1])? else {
+        return Ok(0);
+    };
+    let mut removed = 0;
+    let mut is_kept = |val: &Vec<u8>| {
+        let is_removed = removed < limit && *val == parsed_command[3];
+        removed += usize::from(is_removed);
+        !is_removed
+    };
+    // Occurrences are counted from the end the removal starts at
+    *list = if count < 0 {
+        let mut kept: List = list.drain(..).rev().filter(&mut is_kept).collect();
+        kept.make_contiguous().reverse();
+        kept
+    } else {
+        list.drain(..).filter(is_kept).collect()
+    };
+    let is_list_empty = list.is_empty();
+    if removed > 0 {
+        store.notify(EventClass::List, "lrem", &parsed_command[1]);
+    }
+    if is_list_empty {
+        store.remove(&parsed_command[1]);
+        store.notify(EventClass::Generic, "del", &parsed_command[1]);
+    }
+    drop(store);
+    Ok(removed)
+}
+
+/// LTRIM: keep only the elements from `start` to `stop`, with the indices of LRANGE
+/// The key is removed if no element is kept.
+pub fn ltrim(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<(), &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+    let start = parse_redis_int(&parsed_command[2]).ok_or(NOT_AN_INTEGER)?;
+    let stop = parse_redis_int(&parsed_command[3]).ok_or(NOT_AN_INTEGER)?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(list) = store.get_typed_mut::<List>(&parsed_command[1])? else {
+        return Ok(());
+    };
+    match clamp_range(start, stop, list.len()) {
+        Some(range) => {
+            list.truncate(*range.end() + 1);
+            list.drain(..*range.start());
+        }
+        None => list.clear(),
+    }
+    let is_list_empty = list.is_empty();
+    store.notify(EventClass::List, "ltrim", &parsed_command[1]);
+    if is_list_empty {
+        store.remove(&parsed_command[1]);
+        store.notify(EventClass::Generic, "del", &parsed_command[1]);
+    }
+    drop(store);
+    Ok(())
+}
@@ -0,0 +1,206 @@
+//! Listpacks, i.e. the compact serialization of a sequence of strings and integers used by Redis, which RDB files
+//! store streams in
+//! A listpack is its total size and its number of elements, followed by the elements and a terminator. Every element
+//! is its encoding, its data and then the length of both, so that it can also be walked backwards.
+
+use std::str;
+
+/// Size of the header, i.e. the total size in bytes as a u32 and the number of elements as a u16
+const HEADER_LEN: usize = 6;
+/// Terminator of a listpack
+const EOF: u8 = 0xFF;
+/// Number of elements in the header of a listpack which has too many of them to be counted there
+const UNKNOWN_COUNT: u16 = u16::MAX;
+
+/// Encoding of a string of up to 63 bytes, in the 6 low bits
+const ENCODING_6BIT_STR: u8 = 0x80;
+/// Encoding of a signed 13-bit integer, in the 5 low bits and the next byte
+const ENCODING_13BIT_INT: u8 = 0xC0;
+/// Encoding of a string of up to 4095 bytes, in the 4 low bits and the next byte
+const ENCODING_12BIT_STR: u8 = 0xE0;
+/// Encoding of a string whose length is in the next 4 bytes
+const ENCODING_32BIT_STR: u8 = 0xF0;
+/// Encoding of a signed 16-bit integer
+const ENCODING_16BIT_INT: u8 = 0xF1;
+/// Encoding of a signed 24-bit integer
+const ENCODING_24BIT_INT: u8 = 0xF2;
+/// Encoding of a signed 32-bit integer
+const ENCODING_32BIT_INT: u8 = 0xF3;
+/// Encoding of a signed 64-bit integer
+const ENCODING_64BIT_INT: u8 = 0xF4;
+
+/// Element of a listpack
+pub enum Element {
+    /// An integer, in the smallest encoding which holds it
+    Int(i64),
+    /// A string
+    Str(Vec<u8>),
+}
+
+impl Element {
+    /// The element as an integer, parsing it if it is a string
+    pub fn as_int(&self) -> Option<i64> {
+        match *self {
+            Self::Int(int) => Some(int),
+            Self::Str(ref string) => str::from_utf8(string).ok()?.parse().ok(),
+        }
+    }
+
+    /// The element as a string, with integers in decimal
+    pub fn into_bytes(self) -> Vec<u8> {
+        match self {
+            Self::Int(int) => int.to_string().into_bytes(),
+            Self::Str(string) => string,
+        }
+    }
+}
+
+/// Number of bytes taken by the length of an element of the given size, which follows it
+const fn backlen_len(entry_len: usize) -> usize {
+    match entry_len {
+        0..128 => 1,
+        128..16383 => 2,
+        16383..2_097_151 => 3,
+        2_097_151..268_435_455 => 4,
+        _ => 5,
+    }
+}
+
+/// Write the length of an element of the given size: 7 bits per byte, most significant first, where all bytes but
+/// the first one have their high bit set
+fn write_backlen(out: &mut Vec<u8>, entry_len: usize) {
+    let len = backlen_len(entry_len);
+    for index in (0..len).rev() {
+        // Only the low 7 bits are kept, so this never truncates
+        let bits = u8::try_from((entry_len >> (7 * index)) & 0x7F).unwrap();
+        out.push(if index == len - 1 { bits } else { bits | 0x80 });
+    }
+}
+
+/// Write the encoding and the data of an element
+fn write_element(out: &mut Vec<u8>, element: &Element) {
+    match *element {
+        Element::Int(int @ 0..=127) => out.push(u8::try_from(int).unwrap()),
+        Element::Int(int @ -4096..=4095) => {
+            // Two's complement on 13 bits
+            let bits = u16::try_from(int.rem_euclid(1 << 13)).unwrap();
+            let [high, low] = bits.to_be_bytes();
+            out.extend_from_slice(&[ENCODING_13BIT_INT | high, low]);
+        }
+        Element::Int(int) => {
+            if let Ok(int) = i16::try_from(int) {
+                out.push(ENCODING_16BIT_INT);
+                out.extend_from_slice(&int.to_le_bytes());
+            } else if (-(1 << 23)..1 << 23).contains(&int) {
+                out.push(ENCODING_24BIT_INT);
+                out.extend_from_slice(&int.to_le_bytes()[..3]);
+            } else if let Ok(int) = i32::try_from(int) {
+                out.push(ENCODING_32BIT_INT);
+                out.extend_from_slice(&int.to_le_bytes());
+            } else {
+                out.push(ENCODING_64BIT_INT);
+                out.extend_from_slice(&int.to_le_bytes());
+            }
+        }
+        Element::Str(ref string) => {
+            match string.len() {
+                len @ 0..64 => out.push(ENCODING_6BIT_STR | u8::try_from(len).unwrap()),
+                len @ 64..4096 => {
+                    let [high, low] = u16::try_from(len).unwrap().to_be_bytes();
+                    out.extend_from_slice(&[ENCODING_12BIT_STR | high, low]);
+                }
+                len => {
+                    out.push(ENCODING_32BIT_STR);
+                    out.extend_from_slice(&u32::try_from(len).unwrap().to_le_bytes());
+                }
+            }
+            out.extend_from_slice(string);
+        }
+    }
+}
+
+/// Serialize the elements into a listpack
+pub fn encode(elements: &[Element]) -> Vec<u8> {
+    let mut out = vec![0; HEADER_LEN];
+    let mut entry = Vec::new();
+    for element in elements {
+        entry.clear();
+        write_element(&mut entry, element);
+        out.extend_from_slice(&entry);
+        write_backlen(&mut out, entry.len());
+    }
+    out.push(EOF);
+
+    let total_len = u32::try_from(out.len()).unwrap();
+    let count = u16::try_from(elements.len())
+        .ok()
+        .filter(|&count| count < UNKNOWN_COUNT)
+        .unwrap_or(UNKNOWN_COUNT);
+    out[..4].copy_from_slice(&total_len.to_le_bytes());
+    out[4..HEADER_LEN].copy_from_slice(&count.to_le_bytes());
+    out
+}
+
+/// Sign-extend the integer made of the low `bits` bits of the value
+const fn sign_extend(value: u64, bits: u32) -> i64 {
+    let shift = 64 - bits;
+    (value << shift).cast_signed() >> shift
+}
+
+/// Read the element at the start of the bytes, returning it along with the size of its encoding and data
+fn read_element(bytes: &[u8]) -> Option<(Element, usize)> {
+    let first_byte = *bytes.first()?;
+    let int_of = |len: usize| {
+        let data = bytes.get(1..=len)?;
+        let mut le_bytes = [0; 8];
+        le_bytes[..len].copy_from_slice(data);
+        let bits = u32::try_from(len * 8).unwrap();
+        Some((
+            Element::Int(sign_extend(u64::from_le_bytes(le_bytes), bits)),
+            1 + len,
+        ))
+    };
+    let string_of = |start: usize, len: usize| {
+        let string = bytes.get(start..start.checked_add(len)?)?;
+        Some((Element::Str(string.to_vec()), start + len))
+    };
+    match first_byte {
+        0x00..=0x7F => Some((Element::Int(i64::from(first_byte)), 1)),
+        0x80..=0xBF => string_of(1, usize::from(first_byte & 0x3F)),
+        0xC0..=0xDF => {
+            let value = u64::from(u16::from_be_bytes([first_byte & 0x1F, *bytes.get(1)?]));
+            Some((Element::Int(sign_extend(value, 13)), 2))
+        }
+        0xE0..=0xEF => {
+            let len = u16::from_be_bytes([first_byte & 0x0F, *bytes.get(1)?]);
+            string_of(2, usize::from(len))
+        }
+        ENCODING_32BIT_STR => {
+            let len = u32::from_le_bytes(bytes.get(1..5)?.try_into().unwrap());
+            string_of(5, usize::try_from(len).ok()?)
+        }
+        ENCODING_16BIT_INT => int_of(2),
+        ENCODING_24BIT_INT => int_of(3),
+        ENCODING_32BIT_INT => int_of(4),
+        ENCODING_64BIT_INT => int_of(8),
+        _ => None,
+    }
+}
+
+/// Deserialize the elements of a listpack, or None if it is malformed
+pub fn decode(bytes: &[u8]) -> Option<Vec<Element>> {
+    let total_len = u32::from_le_bytes(bytes.get(..4)?.try_into().unwrap());
+    if usize::try_from(total_len).ok()? != bytes.len() || bytes.last() != Some(&EOF) {
+        return None;
+    }
+
+    let mut elements = Vec::new();
+    let mut position = HEADER_LEN;
+    while bytes.get(position) != Some(&EOF) {
+        let (element, entry_len) = read_element(bytes.get(position..)?)?;
+        elements.push(element);
+        position += entry_len + backlen_len(entry_len);
+    }
+    // An element may not run into the terminator
+    (position == bytes.len() - 1).then_some(elements)
+}
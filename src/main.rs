@@ -21,538 +21,3272 @@
     clippy::dbg_macro
 )]
 
+mod acl;
+mod aof;
+mod bitmap;
+mod blocking;
+mod clients;
+mod command;
+mod config;
+mod consumer_group;
+mod databases;
+mod encoding;
+mod eviction;
+mod geo;
+mod glob;
+mod handlers;
+mod hash;
+mod hyperloglog;
+mod info;
+mod list;
+mod listpack;
+mod memory;
+mod monitor;
+mod notify;
+mod output;
+mod pubsub;
+mod rdb;
+mod replicas;
+mod replication;
+mod resp;
+mod sample;
+mod scan;
+mod set;
+mod sha256;
+mod shutdown;
+mod slowlog;
+mod sort;
+mod store;
+mod stream;
+mod string;
+mod transaction;
+mod zset;
+
 use std::{
-    borrow::Cow,
-    collections::{HashMap, VecDeque},
-    env, str,
-    sync::{Arc, Mutex},
-    time::{Duration, SystemTime},
+    collections::VecDeque,
+    env,
+    fmt::Display,
+    fs, future, io,
+    path::Path,
+    process, slice, str,
+    sync::{
+        atomic::{AtomicBool, AtomicU64, Ordering},
+        Arc, Mutex, RwLock,
+    },
+    thread,
+    time::{Duration, Instant, SystemTime, UNIX_EPOCH},
 };
 
-use tokio::{
-    io::{AsyncReadExt as _, AsyncWriteExt as _},
-    net::{TcpListener, TcpStream},
-    sync::oneshot::{Receiver, Sender},
-    sync::Mutex as TMutex,
-};
+use socket2::{SockRef, TcpKeepalive};
+use tokio::{
+    io::{AsyncRead, AsyncWrite},
+    net::{TcpListener, TcpStream, UnixListener, UnixStream},
+    runtime,
+    signal::unix::{self, SignalKind},
+    sync::{mpsc, oneshot, watch, Notify},
+    task, time,
+};
+
+use acl::Acl;
+use aof::{Aof, PropagatedCommand};
+use blocking::{BlockedClient, BlockedClients, Handoff, Pop, Poppable};
+use clients::{ClientAddr, Clients};
+use command::CommandSpec;
+use config::{AppendFsync, Config, MaxMemoryPolicy};
+use consumer_group::GroupRead;
+use monitor::Monitors;
+use notify::EventClass;
+use output::{ClientOutput, OutputReceiver, OutputSender};
+use pubsub::{PubSub, Subscription, SubscriptionKind};
+use replicas::{NewReplica, Replicas};
+use replication::ReplicationState;
+use resp::{Protocol, RespReader, RespValue};
+use shutdown::ShutdownRequest;
+use slowlog::SlowLog;
+use sort::SortOutput;
+use store::{KeyValStore, ListEnd, RedisType, TypedValue};
+use stream::{StreamReads, Xread};
+use string::Lcs;
+use transaction::{Transaction, WatchedKeys};
+use zset::{SortedSet, SortedSetEnd};
+
+/// Version of Redis whose behaviour is implemented; reported to the clients
+const REDIS_VERSION: &str = "7.4.0";
+/// Number of elements above which UNLINK frees the removed values in the background (same as Redis)
+const LAZYFREE_THRESHOLD: usize = 64;
+
+/// State of the server shared by all the client connections
+struct Server {
+    /// Key-val store of every logical database, by index
+    databases: Vec<Arc<Mutex<KeyValStore>>>,
+    /// Clients blocked on keys by the blocking commands
+    blocked_clients: Arc<Mutex<BlockedClients>>,
+    /// AOF to which the write commands are logged, if it is enabled
+    aof: Arc<Mutex<Option<Aof>>>,
+    /// Replicas to which the write commands are propagated
+    replicas: Mutex<Replicas>,
+    /// Position of this server in the stream of its master, if it is a replica
+    replication: Mutex<ReplicationState>,
+    /// Subscribers of the Pub/Sub channels
+    pubsub: Mutex<PubSub>,
+    /// Clients streaming the processed commands, by MONITOR
+    monitors: Mutex<Monitors>,
+    /// Commands which ran slowly, by SLOWLOG
+    slowlog: Mutex<SlowLog>,
+    /// Configuration given on the command line and changed by CONFIG SET
+    config: RwLock<Config>,
+    /// Random ID of this run of the server
+    run_id: String,
+    /// When the server started
+    started_at: Instant,
+    /// Client connections, not counting the links of the replicas
+    clients: Mutex<Clients>,
+    /// Users which the clients authenticate as, with their permissions
+    acl: Mutex<Acl>,
+    /// Channel to the accept loop, which runs the shutdowns requested by SHUTDOWN
+    shutdown_requests: mpsc::UnboundedSender<ShutdownRequest>,
+    /// Whether the expired keys are removed in the background, as toggled by DEBUG SET-ACTIVE-EXPIRE
+    active_expire: AtomicBool,
+}
+
+/// ID assigned to the next client connection; IDs are never reused
+static NEXT_CLIENT_ID: AtomicU64 = AtomicU64::new(1);
+
+/// State of a single client connection
+struct ClientState {
+    /// Unique ID of the connection
+    id: u64,
+    /// Protocol version used for the replies to this client
+    protocol: Protocol,
+    /// Index of the database the commands of this client run against
+    db: usize,
+    /// Offset of the replication stream right after the last write of this client, which WAIT waits for
+    write_offset: u64,
+    /// Address from which the client connected, if it has one
+    addr: Option<ClientAddr>,
+    /// Port on which the client listens if it is a replica, as given by `REPLCONF listening-port`
+    listening_port: u16,
+    /// Commands queued since MULTI, if a transaction is open
+    transaction: Option<Transaction>,
+    /// Keys watched for the next transaction, if any
+    watched_keys: Option<WatchedKeys>,
+    /// The connection is the link of this replica to its master, so its writes are applied
+    is_master: bool,
+    /// Whether the client may run commands; it must authenticate with AUTH first when the default user has a password
+    authenticated: bool,
+    /// Name of the ACL user which the client is authenticated as, whose permissions apply to its commands
+    user: String,
+    /// Pub/Sub channels the client is subscribed to, if it is in subscriber mode
+    subscription: Option<Subscription>,
+    /// Lines describing the commands processed by the server, if the client is in monitor mode
+    monitor: Option<OutputReceiver<RespValue>>,
+    /// Output of the client, from which the queues of its replies, messages and lines are created
+    output: ClientOutput,
+}
+
+impl ClientState {
+    /// Create the state of a newly accepted connection
+    fn new() -> Self {
+        Self {
+            id: NEXT_CLIENT_ID.fetch_add(1, Ordering::Relaxed),
+            protocol: Protocol::default(),
+            db: 0,
+            write_offset: 0,
+            addr: None,
+            listening_port: 0,
+            transaction: None,
+            watched_keys: None,
+            is_master: false,
+            // The connections which need to authenticate are reset by `process`
+            authenticated: true,
+            user: acl::DEFAULT_USER.to_owned(),
+            subscription: None,
+            monitor: None,
+            output: ClientOutput::default(),
+        }
+    }
+}
+
+/// Condition under which the SET command writes the value
+enum SetCondition {
+    /// `NX`: only set the key if it does not already exist
+    NotExists,
+    /// `XX`: only set the key if it already exists
+    Exists,
+}
+
+/// How the SET command treats the TTL of the key
+enum SetExpiry {
+    /// `EX`/`PX`/`EXAT`/`PXAT`: expire the key at the given time
+    At(SystemTime),
+    /// `KEEPTTL`: retain the TTL of the existing key
+    KeepTtl,
+}
+
+/// Parsed options of the SET command
+#[derive(Default)]
+struct SetOptions {
+    /// `NX`/`XX` condition
+    condition: Option<SetCondition>,
+    /// `GET`: return the old value stored at the key
+    get: bool,
+    /// `EX`/`PX`/`EXAT`/`PXAT`/`KEEPTTL`; the TTL is removed if this isn't present
+    expiry: Option<SetExpiry>,
+}
+
+/// Output of the SET command in human readable form
+enum SetOutput {
+    /// The value was written
+    Written,
+    /// The value was not written as the `NX`/`XX` condition was not met
+    NotWritten,
+    /// Reply of the `GET` option, i.e. the old string stored at the key (if any)
+    OldValue(Option<Vec<u8>>),
+}
+
+/// Parse the options of the SET command, i.e. everything after `SET key value`
+fn parse_set_options(options: &[Vec<u8>]) -> Result<SetOptions, &'static str> {
+    let mut set_options = SetOptions::default();
+    let mut options_it = options.iter();
+
+    while let Some(option) = options_it.next() {
+        match String::from_utf8_lossy(option).to_lowercase().as_str() {
+            "nx" | "xx" if set_options.condition.is_some() => return Err("ERR syntax error"),
+            "nx" => set_options.condition = Some(SetCondition::NotExists),
+            "xx" => set_options.condition = Some(SetCondition::Exists),
+            "get" => set_options.get = true,
+            "keepttl" if set_options.expiry.is_some() => return Err("ERR syntax error"),
+            "keepttl" => set_options.expiry = Some(SetExpiry::KeepTtl),
+            unit @ ("ex" | "px" | "exat" | "pxat") => {
+                if set_options.expiry.is_some() {
+                    return Err("ERR syntax error");
+                }
+                let expires_at = parse_expiry_option(
+                    unit,
+                    options_it.next(),
+                    "ERR invalid expire time in 'set' command",
+                )?;
+                set_options.expiry = Some(SetExpiry::At(expires_at));
+            }
+            _ => return Err("ERR syntax error"),
+        }
+    }
+    Ok(set_options)
+}
+
+/// Parse the time of an `EX`/`PX`/`EXAT`/`PXAT` option, given in lowercase, into the expiry time it sets
+/// `invalid_time_error` is the error of the command for a time out of range.
+fn parse_expiry_option(
+    unit: &str,
+    time: Option<&Vec<u8>>,
+    invalid_time_error: &'static str,
+) -> Result<SystemTime, &'static str> {
+    let time = parse_redis_int(time.ok_or("ERR syntax error")?)
+        .ok_or("ERR value is not an integer or out of range")?;
+    // Redis rejects zero and negative times even for the absolute variants
+    if time <= 0 {
+        return Err(invalid_time_error);
+    }
+
+    let unit_ms = if matches!(unit, "ex" | "exat") {
+        1000
+    } else {
+        1
+    };
+    let base_ms = if unit.ends_with("at") {
+        0
+    } else {
+        unix_time_ms(SystemTime::now())
+    };
+    // Like the EXPIRE family, the expiry time in milliseconds has to fit in an i64
+    let expires_at_ms = time
+        .checked_mul(unit_ms)
+        .and_then(|time_ms| time_ms.checked_add(base_ms))
+        .ok_or(invalid_time_error)?;
+    UNIX_EPOCH
+        .checked_add(Duration::from_millis(expires_at_ms.unsigned_abs()))
+        .ok_or(invalid_time_error)
+}
+
+/// Compute output of the SET command in human readable form, or an error
+fn set(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<SetOutput, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(command::WRONG_ARITY);
+    }
+    let set_options = parse_set_options(&parsed_command[3..])?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let key_exists = store.get(&parsed_command[1]).is_some();
+    let old_value = if set_options.get {
+        store.get_typed::<Vec<u8>>(&parsed_command[1])?.cloned()
+    } else {
+        None
+    };
+    let output = if set_options.get {
+        SetOutput::OldValue(old_value)
+    } else {
+        SetOutput::Written
+    };
+
+    let condition_met = match set_options.condition {
+        Some(SetCondition::NotExists) => !key_exists,
+        Some(SetCondition::Exists) => key_exists,
+        None => true,
+    };
+    if !condition_met {
+        return Ok(if set_options.get {
+            output
+        } else {
+            SetOutput::NotWritten
+        });
+    }
+
+    let expires_at = match set_options.expiry {
+        Some(SetExpiry::At(expires_at)) => Some(expires_at),
+        Some(SetExpiry::KeepTtl) => store.expires_at(&parsed_command[1]),
+        None => None, // Infinite TTL
+    };
+
+    // A key whose expiry time has already passed is deleted right away instead of being written
+    if expires_at.is_some_and(|expires_at| SystemTime::now() >= expires_at) {
+        if store.remove(&parsed_command[1]).is_some() {
+            store.notify(EventClass::Generic, "del", &parsed_command[1]);
+        }
+        return Ok(output);
+    }
+
+    // This overwrites the value if the key already exists
+    store.insert(
+        parsed_command[1].clone(),
+        RedisType::Val(parsed_command[2].clone()),
+        expires_at,
+    );
+    store.notify(EventClass::String, "set", &parsed_command[1]);
+    if matches!(set_options.expiry, Some(SetExpiry::At(_))) {
+        store.notify(EventClass::Generic, "expire", &parsed_command[1]);
+    }
+    drop(store);
+    Ok(output)
+}
+
+/// Compute the remaining time to live of a key in milliseconds
+/// Returns -2 if the key does not exist and -1 if the key exists but has no associated expiry
+fn ttl(redis_key_val_store: &Arc<Mutex<KeyValStore>>, key: &[u8]) -> i64 {
+    let mut store = redis_key_val_store.lock().unwrap();
+    // Expired keys which haven't been removed yet are removed here and reported as non-existent
+    if store.get(key).is_none() {
+        return -2;
+    }
+    store.expires_at(key).map_or(-1, |expires_at| {
+        let ttl_left = expires_at
+            .duration_since(SystemTime::now())
+            .unwrap_or_default(); // When the key expires in between, set the duration to default of 0
+        i64::try_from(ttl_left.as_millis()).unwrap_or(i64::MAX)
+    })
+}
+
+/// Compute the absolute Unix time in milliseconds at which a key expires
+/// Returns -2 if the key does not exist and -1 if the key exists but has no associated expiry
+fn expire_time(redis_key_val_store: &Arc<Mutex<KeyValStore>>, key: &[u8]) -> i64 {
+    let mut store = redis_key_val_store.lock().unwrap();
+    if store.get(key).is_none() {
+        return -2;
+    }
+    store.expires_at(key).map_or(-1, unix_time_ms)
+}
+
+/// Milliseconds elapsed since the Unix epoch at the given time
+fn unix_time_ms(time: SystemTime) -> i64 {
+    time.duration_since(UNIX_EPOCH).map_or(0, |elapsed| {
+        i64::try_from(elapsed.as_millis()).unwrap_or(i64::MAX)
+    })
+}
+
+/// Parsed options of the EXPIRE family of commands
+#[derive(Default)]
+#[expect(
+    clippy::struct_excessive_bools,
+    reason = "Every option of EXPIRE is an independent flag"
+)]
+struct ExpireOptions {
+    /// `NX`: only set the expiry if the key has no expiry
+    nx: bool,
+    /// `XX`: only set the expiry if the key already has an expiry
+    xx: bool,
+    /// `GT`: only set the expiry if it is later than the current one
+    gt: bool,
+    /// `LT`: only set the expiry if it is earlier than the current one
+    lt: bool,
+}
+
+impl ExpireOptions {
+    /// Parse the options, i.e. everything after `EXPIRE key time`
+    fn parse(options: &[Vec<u8>]) -> Result<Self, &'static str> {
+        let mut expire_options = Self::default();
+        for option in options {
+            match String::from_utf8_lossy(option).to_lowercase().as_str() {
+                "nx" => expire_options.nx = true,
+                "xx" => expire_options.xx = true,
+                "gt" => expire_options.gt = true,
+                "lt" => expire_options.lt = true,
+                _ => return Err("ERR syntax error"),
+            }
+        }
+
+        if expire_options.nx && (expire_options.xx || expire_options.gt || expire_options.lt) {
+            return Err("ERR NX and XX, GT or LT options at the same time are not compatible");
+        }
+        if expire_options.gt && expire_options.lt {
+            return Err("ERR GT and LT options at the same time are not compatible");
+        }
+        Ok(expire_options)
+    }
+
+    /// Whether the expiry may be changed from the current one (`None` being infinite) to the new one
+    const fn allows_update(&self, current_ms: Option<i64>, expires_at_ms: i64) -> bool {
+        match current_ms {
+            None => !self.xx && !self.gt,
+            Some(_) if self.nx => false,
+            Some(current_ms) if self.gt => expires_at_ms > current_ms,
+            Some(current_ms) if self.lt => expires_at_ms < current_ms,
+            Some(_) => true,
+        }
+    }
+}
+
+/// Compute output of the EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT commands, or an error
+/// Returns whether the expiry was set; a time in the past deletes the key.
+fn expire(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<bool, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(command::WRONG_ARITY);
+    }
+    // Multiplier to get milliseconds, whether the time is absolute and the error for an out of range time
+    let (unit_ms, is_absolute, invalid_time_error) =
+        match String::from_utf8_lossy(&parsed_command[0])
+            .to_lowercase()
+            .as_str()
+        {
+            "expire" => (1000, false, "ERR invalid expire time in 'expire' command"),
+            "pexpire" => (1, false, "ERR invalid expire time in 'pexpire' command"),
+            "expireat" => (1000, true, "ERR invalid expire time in 'expireat' command"),
+            _ => (1, true, "ERR invalid expire time in 'pexpireat' command"),
+        };
+    let time =
+        parse_redis_int(&parsed_command[2]).ok_or("ERR value is not an integer or out of range")?;
+    let expire_options = ExpireOptions::parse(&parsed_command[3..])?;
+
+    let now_ms = unix_time_ms(SystemTime::now());
+    let base_ms = if is_absolute { 0 } else { now_ms };
+    let expires_at_ms = time
+        .checked_mul(unit_ms)
+        .and_then(|time_ms| time_ms.checked_add(base_ms))
+        .ok_or(invalid_time_error)?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let key = &parsed_command[1];
+    if store.get(key).is_none() {
+        return Ok(false);
+    }
+    let current_ms = store.expires_at(key).map(unix_time_ms);
+    if !expire_options.allows_update(current_ms, expires_at_ms) {
+        return Ok(false);
+    }
+
+    // A key whose expiry time has already passed is deleted right away
+    if expires_at_ms <= now_ms {
+        store.remove(key);
+        store.notify(EventClass::Generic, "del", key);
+    } else {
+        // The expiry is in the future, so it is positive
+        let expires_at = UNIX_EPOCH
+            .checked_add(Duration::from_millis(expires_at_ms.unsigned_abs()))
+            .ok_or(invalid_time_error)?;
+        store.set_expires_at(key, Some(expires_at));
+        store.notify(EventClass::Generic, "expire", key);
+    }
+    drop(store);
+    Ok(true)
+}
+
+/// Compute output of the PERSIST command, i.e. whether the key had a TTL which was removed
+fn persist(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<bool, &'static str> {
+    if parsed_command.len() != 2 {
+        return Err(command::WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    // Expired keys which haven't been removed yet are removed here and reported as non-existent
+    if store.get(&parsed_command[1]).is_none() || store.expires_at(&parsed_command[1]).is_none() {
+        return Ok(false);
+    }
+    store.set_expires_at(&parsed_command[1], None);
+    store.notify(EventClass::Generic, "persist", &parsed_command[1]);
+    drop(store);
+    Ok(true)
+}
+
+/// Compute output of the DEL and UNLINK commands, i.e. the number of keys which existed and were removed
+/// UNLINK removes the keys right away as well, but the values with many elements are freed in the background, as
+/// that takes time proportional to their size.
+fn del(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(command::WRONG_ARITY);
+    }
+    let is_lazy = parsed_command[0].eq_ignore_ascii_case(b"unlink");
+    let mut store = redis_key_val_store.lock().unwrap();
+    let mut removed_values = Vec::new();
+    for key in &parsed_command[1..] {
+        // Expired keys which haven't been removed yet are removed here and don't count
+        if store.get(key).is_none() {
+            continue;
+        }
+        removed_values.extend(store.remove(key));
+        store.notify(EventClass::Generic, "del", key);
+    }
+    drop(store);
+
+    let removed = removed_values.len();
+    let freeing_effort: usize = removed_values.iter().map(RedisType::element_count).sum();
+    if is_lazy && freeing_effort > LAZYFREE_THRESHOLD {
+        task::spawn_blocking(move || drop(removed_values));
+    }
+    Ok(removed)
+}
+
+/// Compute output of the COPY command, i.e. whether the value and TTL of the source key were copied to the
+/// destination key; unless `REPLACE` is given, an existing destination isn't overwritten
+/// The value is cloned, so the copy doesn't share anything with the source. The destination key is in the database
+/// given by `DB`, which is the current one by default, and its blocked clients are served if it now holds a list or a
+/// sorted set.
+fn copy(
+    databases: &[Arc<Mutex<KeyValStore>>],
+    db: usize,
+    blocked_clients: &Mutex<BlockedClients>,
+    parsed_command: &[Vec<u8>],
+) -> Result<bool, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(command::WRONG_ARITY);
+    }
+    let mut replace = false;
+    let mut destination_db = db;
+    let mut options = parsed_command[3..].iter();
+    while let Some(option) = options.next() {
+        match String::from_utf8_lossy(option).to_lowercase().as_str() {
+            "replace" => replace = true,
+            "db" => {
+                destination_db = databases::parse_index(
+                    options.next().ok_or("ERR syntax error")?,
+                    databases.len(),
+                    "ERR value is not an integer or out of range",
+                )?;
+            }
+            _ => return Err("ERR syntax error"),
+        }
+    }
+    let (source, destination) = (&parsed_command[1], &parsed_command[2]);
+    if source == destination && destination_db == db {
+        return Err("ERR source and destination objects are the same");
+    }
+
+    let (mut store, mut other_store) = if destination_db == db {
+        (databases[db].lock().unwrap(), None)
+    } else {
+        let (store, destination_store) = databases::lock_pair(databases, db, destination_db);
+        (store, Some(destination_store))
+    };
+    let Some(data) = store.get(source).cloned() else {
+        return Ok(false);
+    };
+    let expires_at = store.expires_at(source);
+    let destination_store = other_store.as_deref_mut().unwrap_or(&mut *store);
+    if destination_store.get(destination).is_some() && !replace {
+        return Ok(false);
+    }
+    destination_store.insert(destination.clone(), data, expires_at);
+    destination_store.notify(EventClass::Generic, "copy_to", destination);
+    databases::serve_blocked_key(
+        destination_store,
+        destination_db,
+        destination,
+        blocked_clients,
+    );
+    drop(other_store);
+    drop(store);
+    Ok(true)
+}
+
+/// Compute output of the RENAME/RENAMENX commands, i.e. whether the value and TTL of the key were moved to the new
+/// key; RENAME overwrites an existing new key while RENAMENX leaves it as it is
+/// The clients blocked on the new key are served if it now holds a list or a sorted set.
+fn rename(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    db: usize,
+    blocked_clients: &Mutex<BlockedClients>,
+    parsed_command: &[Vec<u8>],
+) -> Result<bool, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(command::WRONG_ARITY);
+    }
+    let (key, new_key) = (&parsed_command[1], &parsed_command[2]);
+    let is_nx = parsed_command[0].eq_ignore_ascii_case(b"renamenx");
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    if store.get(key).is_none() {
+        return Err("ERR no such key");
+    }
+    // Renaming a key to itself is a no-op, which RENAMENX reports as the new key existing
+    if key == new_key {
+        return Ok(!is_nx);
+    }
+    if is_nx && store.get(new_key).is_some() {
+        return Ok(false);
+    }
+    let expires_at = store.expires_at(key);
+    let data = store.remove(key).unwrap();
+    store.notify(EventClass::Generic, "rename_from", key);
+    store.insert(new_key.clone(), data, expires_at);
+    store.notify(EventClass::Generic, "rename_to", new_key);
+    databases::serve_blocked_key(&mut store, db, new_key, blocked_clients);
+    drop(store);
+    Ok(true)
+}
+
+/// Compute output of the RESTORE command: create the key from the value serialized by DUMP, which expires after the
+/// TTL in milliseconds unless 0, or at the TTL as a Unix time in milliseconds with `ABSTTL`
+/// An existing key is only overwritten with `REPLACE`. A TTL in the past deletes the key instead, same as Redis.
+fn restore(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    db: usize,
+    blocked_clients: &Mutex<BlockedClients>,
+    parsed_command: &[Vec<u8>],
+) -> Result<(), &'static str> {
+    if parsed_command.len() < 4 {
+        return Err(command::WRONG_ARITY);
+    }
+    let (mut replace, mut absttl) = (false, false);
+    for option in &parsed_command[4..] {
+        match String::from_utf8_lossy(option).to_lowercase().as_str() {
+            "replace" => replace = true,
+            "absttl" => absttl = true,
+            _ => return Err("ERR syntax error"),
+        }
+    }
+    let ttl_ms =
+        parse_redis_int(&parsed_command[2]).ok_or("ERR value is not an integer or out of range")?;
+    let ttl_ms = u64::try_from(ttl_ms).map_err(|_| "ERR Invalid TTL value, must be >= 0")?;
+    let expires_at = match ttl_ms {
+        0 => None,
+        _ if absttl => UNIX_EPOCH.checked_add(Duration::from_millis(ttl_ms)),
+        _ => SystemTime::now().checked_add(Duration::from_millis(ttl_ms)),
+    };
+    let key = &parsed_command[1];
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    if !replace && store.get(key).is_some() {
+        return Err("BUSYKEY Target key name already exists.");
+    }
+    let data = rdb::restore(&parsed_command[3]).map_err(|_| "ERR Bad data format")?;
+    if expires_at.is_some_and(|expires_at| expires_at <= SystemTime::now()) {
+        if store.remove(key).is_some() {
+            store.notify(EventClass::Generic, "del", key);
+        }
+        return Ok(());
+    }
+    store.insert(key.clone(), data, expires_at);
+    store.notify(EventClass::Generic, "restore", key);
+    databases::serve_blocked_key(&mut store, db, key, blocked_clients);
+    drop(store);
+    Ok(())
+}
+
+/// Compute output of the EXISTS and TOUCH commands, i.e. the number of the keys which exist; a key given several
+/// times is counted as many times
+/// Both count as an access of the keys, which is all that TOUCH is for, e.g. to keep them from being evicted.
+fn exists(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(command::WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let count = parsed_command[1..]
+        .iter()
+        .filter(|key| store.get(key).is_some())
+        .count();
+    drop(store);
+    Ok(count)
+}
+
+/// Parse a string as a 64-bit signed integer the way Redis does
+/// Unlike `str::parse`, this rejects a leading `+` and leading zeros
+fn parse_redis_int(input: &[u8]) -> Option<i64> {
+    str::from_utf8(input)
+        .ok()?
+        .parse::<i64>()
+        .ok()
+        .filter(|number| number.to_string().as_bytes() == input)
+}
+
+/// Parse a string as a double the way Redis does
+/// Infinities (`inf`, `+inf`, `-inf`) are accepted but NaN isn't
+fn parse_redis_float(input: &[u8]) -> Option<f64> {
+    str::from_utf8(input)
+        .ok()?
+        .parse::<f64>()
+        .ok()
+        .filter(|number| !number.is_nan())
+}
+
+/// Error of INCRBYFLOAT and HINCRBYFLOAT when the new value can't be stored
+const NOT_FINITE: &str = "ERR increment would produce NaN or Infinity";
+
+/// Format a double the way Redis formats the results of its float increments and its doubles, i.e. without an
+/// exponent nor trailing zeros, and as `inf`, `-inf` or `nan` if it isn't finite
+/// This gives the shortest digits which parse back into the same double, e.g. 0.30000000000000004 for 0.1 + 0.2.
+fn format_redis_float(number: f64) -> String {
+    if number.is_nan() {
+        "nan".to_owned()
+    } else {
+        number.to_string()
+    }
+}
+
+/// Compute output of the INCR/DECR/INCRBY/DECRBY commands in human readable form, or an error
+fn incr_by(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<i64, &'static str> {
+    let command = String::from_utf8_lossy(&parsed_command[0]).to_lowercase();
+    let expected_len = if command.ends_with("by") { 3 } else { 2 };
+    if parsed_command.len() != expected_len {
+        return Err(command::WRONG_ARITY);
+    }
+
+    let delta = match command.as_str() {
+        "incr" => 1,
+        "decr" => -1,
+        "incrby" => parse_redis_int(&parsed_command[2])
+            .ok_or("ERR value is not an integer or out of range")?,
+        _ => parse_redis_int(&parsed_command[2])
+            .and_then(i64::checked_neg)
+            .ok_or("ERR value is not an integer or out of range")?,
+    };
+
+    // The lock is held for the whole read-modify-write, so that concurrent updates are not lost
+    let mut store = redis_key_val_store.lock().unwrap();
+    let incr_result = match store.get_typed_mut::<Vec<u8>>(&parsed_command[1]) {
+        Ok(Some(val)) => parse_redis_int(val)
+            .and_then(|current_value| current_value.checked_add(delta))
+            .ok_or("ERR value is not an integer or out of range")
+            .inspect(|new_value| {
+                // Overwriting in place retains the TTL of the key
+                *val = new_value.to_string().into_bytes();
+            }),
+        Ok(None) => {
+            // A missing key is initialized to 0 before applying the delta
+            store.insert(
+                parsed_command[1].clone(),
+                RedisType::Val(delta.to_string().into_bytes()),
+                None,
+            );
+            Ok(delta)
+        }
+        Err(err) => Err(err),
+    };
+    // Like Redis, all of INCR/DECR/INCRBY/DECRBY are reported as `incrby`
+    if incr_result.is_ok() {
+        store.notify(EventClass::String, "incrby", &parsed_command[1]);
+    }
+    drop(store);
+    incr_result
+}
+
+/// Compute output of the INCRBYFLOAT command, i.e. the new value as Redis formats it, or an error
+/// Like for INCRBY, a missing key is initialized to 0 before adding, and the TTL of the key is retained.
+fn incr_by_float(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<String, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(command::WRONG_ARITY);
+    }
+    let delta = parse_redis_float(&parsed_command[2]).ok_or("ERR value is not a valid float")?;
+    let format = |value: f64| {
+        if value.is_finite() {
+            Ok(format_redis_float(value))
+        } else {
+            Err(NOT_FINITE)
+        }
+    };
+
+    // The lock is held for the whole read-modify-write, so that concurrent updates are not lost
+    let mut store = redis_key_val_store.lock().unwrap();
+    let new_value = if let Some(val) = store.get_typed_mut::<Vec<u8>>(&parsed_command[1])? {
+        let current_value = parse_redis_float(val).ok_or("ERR value is not a valid float")?;
+        let new_value = format(current_value + delta)?;
+        // Overwriting in place retains the TTL of the key
+        *val = new_value.clone().into_bytes();
+        new_value
+    } else {
+        let new_value = format(delta)?;
+        store.insert(
+            parsed_command[1].clone(),
+            RedisType::Val(new_value.clone().into_bytes()),
+            None,
+        );
+        new_value
+    };
+    store.notify(EventClass::String, "incrbyfloat", &parsed_command[1]);
+    drop(store);
+    Ok(new_value)
+}
+
+/// Compute output of the HELLO command, switching the protocol of the client, authenticating it with `AUTH` and
+/// naming it with `SETNAME` if requested
+/// Reply is a map describing the server, or an error; all the arguments are checked before any of them takes effect,
+/// so a failing HELLO leaves the client as it was.
+fn hello(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+) -> Result<RespValue, &'static str> {
+    let protocol = match parsed_command
+        .get(1)
+        .map(|version| parse_redis_int(version))
+    {
+        None => client.protocol,
+        Some(Some(2)) => Protocol::Resp2,
+        Some(Some(3)) => Protocol::Resp3,
+        Some(Some(_)) => return Err("NOPROTO unsupported protocol version"),
+        Some(None) => return Err("ERR Protocol version is not an integer or out of range"),
+    };
+    let mut credentials = None;
+    let mut name = None;
+    let mut options = parsed_command.iter().skip(2);
+    while let Some(option) = options.next() {
+        if option.eq_ignore_ascii_case(b"auth") {
+            let (Some(user), Some(password)) = (options.next(), options.next()) else {
+                return Err("ERR syntax error");
+            };
+            credentials = Some((user, password));
+        } else if option.eq_ignore_ascii_case(b"setname") {
+            let new_name = options.next().ok_or("ERR syntax error")?;
+            clients::validate_name(new_name)?;
+            name = Some(new_name);
+        } else {
+            return Err("ERR syntax error");
+        }
+    }
+    match credentials {
+        Some((user, password)) => {
+            authenticate(
+                server,
+                client,
+                String::from_utf8_lossy(user).into_owned(),
+                password,
+            )?;
+        }
+        None if !client.authenticated => {
+            return Err("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time");
+        }
+        None => {}
+    }
+    if let Some(name) = name {
+        server.clients.lock().unwrap().set_name(client.id, name);
+    }
+    client.protocol = protocol;
+
+    let role = if server.config.read().unwrap().replicaof.is_some() {
+        "replica"
+    } else {
+        "master"
+    };
+    let proto = match client.protocol {
+        Protocol::Resp2 => 2,
+        Protocol::Resp3 => 3,
+    };
+    let field = |name: &str, val: RespValue| (RespValue::BulkString(name.into()), val);
+    Ok(RespValue::Map(vec![
+        field("server", RespValue::BulkString(b"redis".to_vec())),
+        field("version", RespValue::BulkString(REDIS_VERSION.into())),
+        field("proto", RespValue::Integer(proto)),
+        field("id", RespValue::Integer(i64::try_from(client.id).unwrap())),
+        field("mode", RespValue::BulkString(b"standalone".to_vec())),
+        field("role", RespValue::BulkString(role.into())),
+        field("modules", RespValue::Array(Vec::new())),
+    ]))
+}
+
+/// Authenticate the client by AUTH, given the password alone for the default user or along with the user name
+fn auth(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+) -> Result<(), &'static str> {
+    let (user, password) = match *parsed_command {
+        [_, _] if server.acl.lock().unwrap().default_user_has_nopass() => {
+            return Err("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?");
+        }
+        [_, ref password] => (acl::DEFAULT_USER.to_owned(), password),
+        [_, ref user, ref password] => (String::from_utf8_lossy(user).into_owned(), password),
+        _ => return Err("ERR syntax error"),
+    };
+    authenticate(server, client, user, password)
+}
+
+/// Authenticate the client as the user, as AUTH and the `AUTH` option of HELLO do
+fn authenticate(
+    server: &Server,
+    client: &mut ClientState,
+    user: String,
+    password: &[u8],
+) -> Result<(), &'static str> {
+    let is_authenticated = server.acl.lock().unwrap().authenticate(&user, password);
+    if !is_authenticated {
+        return Err("WRONGPASS invalid username-password pair or user is disabled.");
+    }
+    client.user = user;
+    client.authenticated = true;
+    Ok(())
+}
+
+/// Record the options which a replica gives with REPLCONF before PSYNC; the other options are accepted and ignored
+fn replconf(client: &mut ClientState, parsed_command: &[Vec<u8>]) -> Result<(), &'static str> {
+    if let [_, ref option, ref port] = *parsed_command {
+        if option.eq_ignore_ascii_case(b"listening-port") {
+            client.listening_port = str::from_utf8(port)
+                .ok()
+                .and_then(|port| port.parse().ok())
+                .ok_or("ERR value is not an integer or out of range")?;
+        }
+    }
+    Ok(())
+}
+
+/// Switch the database which the following commands of the client run against for SELECT
+fn select(
+    server: &Server,
+    client: &mut ClientState,
+    parsed_command: &[Vec<u8>],
+) -> Result<(), &'static str> {
+    if parsed_command.len() != 2 {
+        return Err(command::WRONG_ARITY);
+    }
+    client.db = databases::parse_index(
+        &parsed_command[1],
+        server.databases.len(),
+        "ERR value is not an integer or out of range",
+    )?;
+    Ok(())
+}
+
+/// Hand the parameters over to the stores and to the queues of the subscribers, the monitors and the replicas, which
+/// keep their own copy of the parameters they apply themselves
+fn apply_config(server: &Server, config: &Config) {
+    for redis_key_val_store in &server.databases {
+        let mut store = redis_key_val_store.lock().unwrap();
+        store.set_notify_flags(config.notify_keyspace_events);
+        store.set_listpack_limits(config.listpack_limits);
+        drop(store);
+    }
+    let limits = config.client_output_buffer_limit;
+    server
+        .pubsub
+        .lock()
+        .unwrap()
+        .set_output_limit(limits.pubsub);
+    server
+        .monitors
+        .lock()
+        .unwrap()
+        .set_output_limit(limits.normal);
+    server
+        .replicas
+        .lock()
+        .unwrap()
+        .set_output_limit(limits.replica);
+    server
+        .replicas
+        .lock()
+        .unwrap()
+        .set_backlog_size(config.repl_backlog_size);
+}
+
+/// Lines of CONFIG HELP
+const CONFIG_HELP: &[&str] = &[
+    "GET <pattern>",
+    "    Return parameters matching the glob-like <pattern> and their values.",
+    "SET <directive> <value>",
+    "    Set the configuration <directive> to <value>.",
+];
+
+/// Compute output of the CONFIG GET/SET/HELP subcommands
+/// GET replies a map of the parameters matching any of the patterns, and SET changes all the given parameters or
+/// none of them.
+fn config(server: &Server, parsed_command: &[Vec<u8>]) -> RespValue {
+    let subcommand = String::from_utf8_lossy(&parsed_command[1]).to_lowercase();
+    match subcommand.as_str() {
+        "get" if parsed_command.len() > 2 => {
+            let parameters = server.config.read().unwrap().matching(&parsed_command[2..]);
+            RespValue::Map(
+                parameters
+                    .into_iter()
+                    .map(|(name, value)| {
+                        (
+                            RespValue::BulkString(name.into()),
+                            RespValue::BulkString(value.into_bytes()),
+                        )
+                    })
+                    .collect(),
+            )
+        }
+        "set" if parsed_command.len() > 2 && parsed_command.len().is_multiple_of(2) => {
+            let mut config = server.config.write().unwrap();
+            if let Err(err) = config.set(&parsed_command[2..]) {
+                return RespValue::Error(err);
+            }
+            let is_requirepass_set = parsed_command[2..]
+                .chunks_exact(2)
+                .any(|pair| pair[0].eq_ignore_ascii_case(b"requirepass"));
+            if is_requirepass_set {
+                server
+                    .acl
+                    .lock()
+                    .unwrap()
+                    .set_requirepass(&config.requirepass);
+            }
+            apply_config(server, &config);
+            drop(config);
+            RespValue::simple("OK")
+        }
+        "help" if parsed_command.len() == 2 => RespValue::help("CONFIG", CONFIG_HELP),
+        "get" | "set" | "help" => command::wrong_arity(&format!("config|{subcommand}")),
+        _ => RespValue::Error(format!(
+            "ERR unknown subcommand '{}'. Try CONFIG HELP.",
+            String::from_utf8_lossy(&parsed_command[1])
+        )),
+    }
+}
+
+/// Lines of OBJECT HELP
+const OBJECT_HELP: &[&str] = &[
+    "ENCODING <key>",
+    "    Return the kind of internal representation used in order to store the value",
+    "    associated with a <key>.",
+    "FREQ <key>",
+    "    Return the access frequency index of the <key>. The returned integer is",
+    "    proportional to the logarithm of the recent access frequency of the key.",
+    "IDLETIME <key>",
+    "    Return the idle time of the <key>, that is the approximated number of",
+    "    seconds elapsed since the last access to the key.",
+    "REFCOUNT <key>",
+    "    Return the number of references of the value associated with the specified",
+    "    <key>.",
+];
+
+/// Compute output of the OBJECT ENCODING/REFCOUNT/IDLETIME/FREQ/HELP subcommands, which are null for a missing key
+/// Values are never shared, so the reference count is always 1. Like in Redis, the idle time is only replied with
+/// an LRU policy and the access frequency with an LFU one, as the other is meaningless for eviction then.
+fn object(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    policy: MaxMemoryPolicy,
+    parsed_command: &[Vec<u8>],
+) -> RespValue {
+    let subcommand = String::from_utf8_lossy(&parsed_command[1]).to_lowercase();
+    match subcommand.as_str() {
+        "encoding" | "refcount" | "idletime" | "freq" if parsed_command.len() == 3 => {
+            let mut store = redis_key_val_store.lock().unwrap();
+            let key = &parsed_command[2];
+            let Some((encoding, access)) = store
+                .encoding(key)
+                .zip(store.peek(key).map(|(_, access)| access))
+            else {
+                return RespValue::NullBulkString;
+            };
+            drop(store);
+            match subcommand.as_str() {
+                "encoding" => RespValue::BulkString(encoding.name().into()),
+                "refcount" => RespValue::Integer(1),
+                "idletime" if policy.is_lfu() => RespValue::error("ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."),
+                "idletime" => {
+                    RespValue::Integer(i64::try_from(access.idle_time().as_secs()).unwrap())
+                }
+                _ if !policy.is_lfu() => RespValue::error("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."),
+                _ => RespValue::Integer(access.frequency().into()),
+            }
+        }
+        "help" if parsed_command.len() == 2 => RespValue::help("OBJECT", OBJECT_HELP),
+        "encoding" | "refcount" | "idletime" | "freq" | "help" => {
+            command::wrong_arity(&format!("object|{subcommand}"))
+        }
+        _ => RespValue::Error(format!(
+            "ERR unknown subcommand '{}'. Try OBJECT HELP.",
+            String::from_utf8_lossy(&parsed_command[1])
+        )),
+    }
+}
+
+/// Lines of DEBUG HELP
+const DEBUG_HELP: &[&str] = &[
+    "CHANGE-REPL-ID",
+    "    Change the replication IDs of the instance.",
+    "    Dangerous: should be used only for testing the replication subsystem.",
+    "OBJECT <key>",
+    "    Show low level info about the <key> and associated value.",
+    "QUICKLIST-PACKED-THRESHOLD <size>",
+    "    Sets the threshold for elements to be inserted as plain vs packed nodes",
+    "RELOAD",
+    "    Save the RDB on disk and reload it back to memory.",
+    "SET-ACTIVE-EXPIRE <0|1>",
+    "    Setting it to 0 disables expiring keys in background when they are not",
+    "    accessed (otherwise the Redis behavior). Setting it to 1 reenables back the",
+    "    default.",
+    "SLEEP <seconds>",
+    "    Stop the server for <seconds>. Decimals allowed.",
+];
+
+/// Run the DEBUG SLEEP/OBJECT/RELOAD/SET-ACTIVE-EXPIRE/QUICKLIST-PACKED-THRESHOLD/CHANGE-REPL-ID/HELP subcommands,
+/// which help testing the server
+/// Unlike in Redis, SLEEP only holds up the calling client, unless it can't block—e.g. in a transaction—in which case
+/// it holds up the whole server.
+fn debug(server: &Server, db: usize, parsed_command: &[Vec<u8>], can_block: bool) -> Execution {
+    let subcommand = String::from_utf8_lossy(&parsed_command[1]).to_lowercase();
+    let reply = match subcommand.as_str() {
+        "sleep" if parsed_command.len() == 3 => {
+            let Some(duration) = parse_redis_float(&parsed_command[2])
+                .and_then(|seconds| Duration::try_from_secs_f64(seconds.max(0.0)).ok())
+            else {
+                return Execution::Reply(RespValue::error("ERR value is not a valid float"));
+            };
+            if can_block {
+                return Execution::Sleep(duration);
+            }
+            thread::sleep(duration);
+            RespValue::simple("OK")
+        }
+        "object" if parsed_command.len() == 3 => {
+            let key = &parsed_command[2];
+            let mut store = server.databases[db].lock().unwrap();
+            let Some((serialized_len, access)) = store
+                .peek(key)
+                .map(|(data, access)| (rdb::serialized_len(data), access))
+            else {
+                return Execution::Reply(RespValue::error("ERR no such key"));
+            };
+            let encoding = store.encoding(key).unwrap();
+            drop(store);
+            // Values aren't shared nor have an address to report, and the LRU clock isn't a value of its own
+            RespValue::SimpleString(format!(
+                "Value at:0x0 refcount:1 encoding:{} serializedlength:{serialized_len} lru:0 lru_seconds_idle:{}",
+                encoding.name(),
+                access.idle_time().as_secs()
+            ))
+        }
+        "reload" if parsed_command.len() == 2 => {
+            rdb::reload(&server.databases, &server.config.read().unwrap().db_path())
+                .map_or_else(RespValue::error, |()| RespValue::simple("OK"))
+        }
+        "set-active-expire" if parsed_command.len() == 3 => {
+            let Some(enabled) = parse_redis_int(&parsed_command[2]) else {
+                return Execution::Reply(RespValue::error(
+                    "ERR value is not an integer or out of range",
+                ));
+            };
+            server.active_expire.store(enabled != 0, Ordering::Relaxed);
+            RespValue::simple("OK")
+        }
+        "quicklist-packed-threshold" if parsed_command.len() == 3 => {
+            let Some(threshold) = str::from_utf8(&parsed_command[2])
+                .ok()
+                .and_then(|threshold| config::parse_memory(threshold).ok())
+                .filter(|threshold| (1..1 << 32).contains(threshold))
+            else {
+                return Execution::Reply(RespValue::error(
+                    "ERR argument must be a memory value bigger than 1 and smaller than 4gb",
+                ));
+            };
+            let mut config = server.config.write().unwrap();
+            config.listpack_limits.list_packed_threshold = usize::try_from(threshold).unwrap();
+            for redis_key_val_store in &server.databases {
+                redis_key_val_store
+                    .lock()
+                    .unwrap()
+                    .set_listpack_limits(config.listpack_limits);
+            }
+            drop(config);
+            RespValue::simple("OK")
+        }
+        // The replicas continue the stream under the new ID once they reconnect
+        "change-repl-id" if parsed_command.len() == 2 => {
+            server.replicas.lock().unwrap().change_replid();
+            RespValue::simple("OK")
+        }
+        "help" if parsed_command.len() == 2 => RespValue::help("DEBUG", DEBUG_HELP),
+        "sleep"
+        | "object"
+        | "reload"
+        | "set-active-expire"
+        | "quicklist-packed-threshold"
+        | "change-repl-id"
+        | "help" => command::wrong_arity(&format!("debug|{subcommand}")),
+        _ => RespValue::Error(format!(
+            "ERR unknown subcommand '{}'. Try DEBUG HELP.",
+            String::from_utf8_lossy(&parsed_command[1])
+        )),
+    };
+    Execution::Reply(reply)
+}
+
+/// Compute output of the LPUSH/RPUSH commands, i.e. the length of the list after the push, or an error
+/// The pushed elements are handed over to the clients blocked on the list, if any.
+fn push(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    blocked_clients: &Mutex<BlockedClients>,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+    end: ListEnd,
+) -> Result<usize, &'static str> {
+    // Checked before touching the store, so that a missing key isn't created as an empty list
+    if parsed_command.len() <= 2 {
+        return Err(command::WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let len = push_and_serve(
+        &mut store,
+        blocked_clients,
+        db,
+        &parsed_command[1],
+        end,
+        parsed_command[2..].iter().cloned(),
+    )?;
+    drop(store);
+    Ok(len)
+}
+
+/// Push the elements to the end of the list at the key, creating it if it doesn't exist, then hand them over to the
+/// clients blocked on it, returning the length of the list before any element is handed over, same as Redis
+fn push_and_serve(
+    store: &mut KeyValStore,
+    blocked_clients: &Mutex<BlockedClients>,
+    db: usize,
+    key: &[u8],
+    end: ListEnd,
+    vals: impl IntoIterator<Item = Vec<u8>>,
+) -> Result<usize, &'static str> {
+    let list = store.get_or_insert_typed::<VecDeque<Vec<u8>>>(key)?;
+    // Elements are pushed one after the other, so LPUSH reverses their order
+    for val in vals {
+        end.push(list, val);
+    }
+    let len = list.len();
+    let served_pops = blocked_clients.lock().unwrap().serve(db, key, list);
+    let is_list_empty = list.is_empty();
+    notify_list_events(store, key, end, &served_pops, is_list_empty);
+    if is_list_empty {
+        store.remove(key);
+    }
+    Ok(len)
+}
+
+/// Record the keyspace events of a push to the list at the key, followed by the pops of the elements handed over to
+/// blocked clients and the removal of the key if that emptied the list
+fn notify_list_events(
+    store: &mut KeyValStore,
+    key: &[u8],
+    end: ListEnd,
+    served_pops: &[Pop],
+    is_list_empty: bool,
+) {
+    store.notify(EventClass::List, end.push_event(), key);
+    for served_pop in served_pops {
+        served_pop.notify(store, key);
+    }
+    if is_list_empty {
+        store.notify(EventClass::Generic, "del", key);
+    }
+}
+
+/// Compute output of the LPOP/RPOP commands, i.e. the element popped from the end of the list, or an array of up to
+/// `count` elements when it is given
+/// The key is removed along with the last element.
+fn pop(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+    end: ListEnd,
+) -> RespValue {
+    if parsed_command.len() > 3 {
+        return RespValue::error(command::WRONG_ARITY);
+    }
+    let count = parsed_command.get(2).map(|count| {
+        parse_redis_int(count)
+            .and_then(|count| usize::try_from(count).ok())
+            .ok_or("ERR value is out of range, must be positive")
+    });
+    let count = match count.transpose() {
+        Ok(count) => count,
+        Err(err) => return RespValue::error(err),
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let list = match store.get_typed_mut::<VecDeque<Vec<u8>>>(&parsed_command[1]) {
+        Ok(Some(list)) => list,
+        Ok(None) if count.is_some() => return RespValue::NullArray,
+        Ok(None) => return RespValue::NullBulkString,
+        Err(err) => return RespValue::error(err),
+    };
+    let popped: Vec<Vec<u8>> = (0..count.unwrap_or(1))
+        .map_while(|_| end.pop(list))
+        .collect();
+    let is_list_empty = list.is_empty();
+    if !popped.is_empty() {
+        store.notify(EventClass::List, end.pop_event(), &parsed_command[1]);
+    }
+    // Remove the key from the store if its list has become empty
+    if is_list_empty {
+        store.remove(&parsed_command[1]);
+        store.notify(EventClass::Generic, "del", &parsed_command[1]);
+    }
+    drop(store);
+    if count.is_some() {
+        RespValue::bulk_string_array(popped)
+    } else {
+        popped
+            .into_iter()
+            .next()
+            .map_or(RespValue::NullBulkString, RespValue::BulkString)
+    }
+}
+
+/// Parse the end of a list given to LMOVE/BLMOVE, i.e. LEFT or RIGHT
+const fn parse_list_end(end: &[u8]) -> Result<ListEnd, &'static str> {
+    if end.eq_ignore_ascii_case(b"left") {
+        Ok(ListEnd::Left)
+    } else if end.eq_ignore_ascii_case(b"right") {
+        Ok(ListEnd::Right)
+    } else {
+        Err("ERR syntax error")
+    }
+}
+
+/// Move the element at an end of the source list to an end of the destination list, for LMOVE/RPOPLPUSH/BLMOVE,
+/// returning it, or None if the source list is empty
+/// The source and the destination may be the same list, which rotates it.
+fn list_move(
+    store: &mut KeyValStore,
+    blocked_clients: &Mutex<BlockedClients>,
+    db: usize,
+    source: &[u8],
+    destination: &[u8],
+    (from, to): (ListEnd, ListEnd),
+) -> Result<Option<Vec<u8>>, &'static str> {
+    if store.get_typed::<VecDeque<Vec<u8>>>(source)?.is_none() {
+        return Ok(None);
+    }
+    // Checked before popping, so that nothing is popped when the destination can't take the element
+    store.get_typed::<VecDeque<Vec<u8>>>(destination)?;
+    let Some(val) = store
+        .get_typed_mut::<VecDeque<Vec<u8>>>(source)?
+        .and_then(|list| from.pop(list))
+    else {
+        return Ok(None);
+    };
+    store.notify(EventClass::List, from.pop_event(), source);
+    push_and_serve(store, blocked_clients, db, destination, to, [val.clone()])?;
+    // Remove the key from the store if its list has become empty, which it hasn't if it was also the destination
+    if store
+        .get_typed::<VecDeque<Vec<u8>>>(source)?
+        .is_some_and(VecDeque::is_empty)
+    {
+        store.remove(source);
+        store.notify(EventClass::Generic, "del", source);
+    }
+    Ok(Some(val))
+}
+
+/// Compute output of the LMOVE/RPOPLPUSH/BLMOVE commands, given the source and destination lists and their ends
+/// BLMOVE blocks the client on the source list while it is empty, unless it can't block.
+fn lmove(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    blocked_clients: &Arc<Mutex<BlockedClients>>,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Execution {
+    let name = String::from_utf8_lossy(&parsed_command[0]).to_lowercase();
+    let ends = match (name.as_str(), parsed_command.len()) {
+        ("rpoplpush", 3) => Ok((ListEnd::Right, ListEnd::Left)),
+        ("lmove", 5) | ("blmove", 6) => parse_list_end(&parsed_command[3])
+            .and_then(|from| parse_list_end(&parsed_command[4]).map(|to| (from, to))),
+        _ => Err(command::WRONG_ARITY),
+    };
+    let timeout = if name == "blmove" {
+        ends.and_then(|_| parse_timeout(&parsed_command[5]))
+    } else {
+        ends.map(|_| None)
+    };
+    let (ends, timeout) = match ends.and_then(|ends| timeout.map(|timeout| (ends, timeout))) {
+        Ok(parsed) => parsed,
+        Err(err) => return Execution::Reply(RespValue::error(err)),
+    };
+    let (source, destination) = (&parsed_command[1], &parsed_command[2]);
+
+    // The store stays locked while blocking, so that a push in between can't be missed
+    let mut store = redis_key_val_store.lock().unwrap();
+    let reply = match list_move(&mut store, blocked_clients, db, source, destination, ends) {
+        Ok(Some(val)) => RespValue::BulkString(val),
+        Ok(None) if name == "blmove" && can_block => {
+            let blocked_client = BlockedClients::block(
+                blocked_clients,
+                db,
+                slice::from_ref(source),
+                Pop::List(ends.0),
+            );
+            let action = BlockedAction::Move(ends.0, destination.clone(), ends.1);
+            return Execution::Blocked(blocked_client, timeout, action);
+        }
+        Ok(None) => RespValue::NullBulkString,
+        Err(err) => RespValue::error(err),
+    };
+    drop(store);
+    Execution::Reply(reply)
+}
+
+/// Outcome of BLPOP/BRPOP/BZPOPMIN/BZPOPMAX before waiting for any push
+enum BlockingPop {
+    /// An element was available right away
+    Popped(Handoff),
+    /// All the values are empty, so wait for a push until the timeout, which is infinite if absent
+    Blocked(BlockedClient, Option<Duration>),
+    /// All the values are empty and the client isn't allowed to block, e.g. inside a transaction
+    Empty,
+}
+
+/// Parse the timeout of a blocking command, given in (fractional) seconds; 0 means to block forever
+fn parse_timeout(timeout: &[u8]) -> Result<Option<Duration>, &'static str> {
+    let timeout = str::from_utf8(timeout)
+        .ok()
+        .and_then(|timeout| timeout.parse::<f64>().ok())
+        .filter(|timeout| timeout.is_finite())
+        .ok_or("ERR timeout is not a float or out of range")?;
+    if timeout < 0.0 {
+        return Err("ERR timeout is negative");
+    }
+    if timeout == 0.0 {
+        return Ok(None);
+    }
+    Duration::try_from_secs_f64(timeout)
+        .map(Some)
+        .map_err(|_| "ERR timeout is out of range")
+}
+
+/// Pop from the first non-empty list for BLPOP/BRPOP, or sorted set for BZPOPMIN/BZPOPMAX, or block the client on
+/// all the keys if they are empty
+fn blocking_pop(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    blocked_clients: &Arc<Mutex<BlockedClients>>,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+    pop: Pop,
+    can_block: bool,
+) -> Result<BlockingPop, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(command::WRONG_ARITY);
+    }
+    let (timeout, keys) = parsed_command[1..].split_last().unwrap();
+    let timeout = parse_timeout(timeout)?;
+
+    // The store stays locked while blocking, so that a push in between can't be missed
+    let mut store = redis_key_val_store.lock().unwrap();
+    for key in keys {
+        let handoff = match pop {
+            Pop::List(_) => pop_typed::<VecDeque<Vec<u8>>>(&mut store, key, pop)?,
+            Pop::SortedSet(_) => pop_typed::<SortedSet>(&mut store, key, pop)?,
+            Pop::Stream => unreachable!("XREAD doesn't pop"),
+        };
+        if let Some(handoff) = handoff {
+            return Ok(BlockingPop::Popped(handoff));
+        }
+    }
+
+    if !can_block {
+        return Ok(BlockingPop::Empty);
+    }
+    let blocked_client = BlockedClients::block(blocked_clients, db, keys, pop);
+    drop(store);
+    Ok(BlockingPop::Blocked(blocked_client, timeout))
+}
+
+/// Pop an element from the value of the type at the key, if there is any
+/// The key is removed from the store if its value becomes empty.
+fn pop_typed<T: Poppable + TypedValue>(
+    store: &mut KeyValStore,
+    key: &[u8],
+    pop: Pop,
+) -> Result<Option<Handoff>, &'static str> {
+    let Some(value) = store.get_typed_mut::<T>(key)? else {
+        return Ok(None);
+    };
+    let Some(popped) = value.pop_for(pop) else {
+        return Ok(None);
+    };
+    let is_empty = value.is_empty();
+    pop.notify(store, key);
+    if is_empty {
+        store.remove(key);
+        store.notify(EventClass::Generic, "del", key);
+    }
+    Ok(Some((key.to_vec(), popped.0, popped.1)))
+}
+
+/// Reply to BLPOP/BRPOP with the key and the element, or to BZPOPMIN/BZPOPMAX with the key, the member and its score
+fn handoff_reply((key, val, score): Handoff) -> RespValue {
+    match score {
+        Some(score) => RespValue::Array(vec![
+            RespValue::BulkString(key),
+            RespValue::BulkString(val),
+            RespValue::Double(score),
+        ]),
+        None => RespValue::bulk_string_array([key, val]),
+    }
+}
+
+/// Element popped from a list, or member popped from a sorted set along with its score
+type Popped = (Vec<u8>, Option<f64>);
+
+/// Parse the `numkeys key [key ...] LEFT|RIGHT [COUNT count]` arguments of LMPOP/BLMPOP, or with `MIN|MAX` instead
+/// for ZMPOP/BZMPOP, into the keys, how to pop from them and how many elements to pop at most
+fn parse_mpop(
+    args: &[Vec<u8>],
+    is_sorted_set: bool,
+) -> Result<(&[Vec<u8>], Pop, usize), &'static str> {
+    let numkeys = parse_redis_int(&args[0])
+        .and_then(|numkeys| usize::try_from(numkeys).ok())
+        .filter(|&numkeys| numkeys > 0)
+        .ok_or("ERR numkeys should be greater than 0")?;
+    let keys = args[1..].get(..numkeys).ok_or("ERR syntax error")?;
+    let Some((end, options)) = args[1 + numkeys..].split_first() else {
+        return Err("ERR syntax error");
+    };
+    let pop = if !is_sorted_set {
+        Pop::List(parse_list_end(end)?)
+    } else if end.eq_ignore_ascii_case(b"min") {
+        Pop::SortedSet(SortedSetEnd::Min)
+    } else if end.eq_ignore_ascii_case(b"max") {
+        Pop::SortedSet(SortedSetEnd::Max)
+    } else {
+        return Err("ERR syntax error");
+    };
+    let count = match *options {
+        [] => 1,
+        [ref option, ref count] if option.eq_ignore_ascii_case(b"count") => parse_redis_int(count)
+            .and_then(|count| usize::try_from(count).ok())
+            .filter(|&count| count > 0)
+            .ok_or("ERR count should be greater than 0")?,
+        _ => return Err("ERR syntax error"),
+    };
+    Ok((keys, pop, count))
+}
+
+/// Pop up to `count` elements from the value of the type at the key, along with their scores if it is a sorted set
+/// The key is removed from the store if its value becomes empty.
+fn pop_many<T: Poppable + TypedValue>(
+    store: &mut KeyValStore,
+    key: &[u8],
+    pop: Pop,
+    count: usize,
+) -> Result<Vec<Popped>, &'static str> {
+    let Some(value) = store.get_typed_mut::<T>(key)? else {
+        return Ok(Vec::new());
+    };
+    let popped: Vec<_> = (0..count).map_while(|_| value.pop_for(pop)).collect();
+    let is_empty = value.is_empty();
+    if !popped.is_empty() {
+        pop.notify(store, key);
+    }
+    if is_empty {
+        store.remove(key);
+        store.notify(EventClass::Generic, "del", key);
+    }
+    Ok(popped)
+}
+
+/// Pop up to `count` elements from the list or sorted set at the key as given
+fn pop_many_for(
+    store: &mut KeyValStore,
+    key: &[u8],
+    pop: Pop,
+    count: usize,
+) -> Result<Vec<Popped>, &'static str> {
+    match pop {
+        Pop::List(_) => pop_many::<VecDeque<Vec<u8>>>(store, key, pop, count),
+        Pop::SortedSet(_) => pop_many::<SortedSet>(store, key, pop, count),
+        Pop::Stream => unreachable!("XREAD doesn't pop"),
+    }
+}
+
+/// Reply to LMPOP/BLMPOP with the key and the elements popped from it, or to ZMPOP/BZMPOP with the key and the
+/// pairs of members and scores
+fn mpop_reply(key: Vec<u8>, popped: Vec<Popped>) -> RespValue {
+    let elements = popped
+        .into_iter()
+        .map(|(val, score)| match score {
+            Some(score) => {
+                RespValue::Array(vec![RespValue::BulkString(val), RespValue::Double(score)])
+            }
+            None => RespValue::BulkString(val),
+        })
+        .collect();
+    RespValue::Array(vec![RespValue::BulkString(key), RespValue::Array(elements)])
+}
+
+/// Compute output of the LMPOP/ZMPOP/BLMPOP/BZMPOP commands, which pop up to `COUNT` elements from the first
+/// non-empty list or sorted set among the keys
+/// BLMPOP and BZMPOP block the client on all the keys while they are empty, unless it can't block.
+fn mpop(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    blocked_clients: &Arc<Mutex<BlockedClients>>,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Execution {
+    let name = String::from_utf8_lossy(&parsed_command[0]).to_lowercase();
+    let is_blocking = name.starts_with('b');
+    let parsed = if parsed_command.len() < if is_blocking { 5 } else { 4 } {
+        Err(command::WRONG_ARITY)
+    } else if is_blocking {
+        parse_timeout(&parsed_command[1]).and_then(|timeout| {
+            parse_mpop(&parsed_command[2..], name == "bzmpop").map(|args| (timeout, args))
+        })
+    } else {
+        parse_mpop(&parsed_command[1..], name == "zmpop").map(|args| (None, args))
+    };
+    let (timeout, (keys, pop, count)) = match parsed {
+        Ok(parsed) => parsed,
+        Err(err) => return Execution::Reply(RespValue::error(err)),
+    };
+
+    // The store stays locked while blocking, so that a push in between can't be missed
+    let mut store = redis_key_val_store.lock().unwrap();
+    for key in keys {
+        match pop_many_for(&mut store, key, pop, count) {
+            Ok(popped) if popped.is_empty() => {}
+            Ok(popped) => return Execution::Reply(mpop_reply(key.clone(), popped)),
+            Err(err) => return Execution::Reply(RespValue::error(err)),
+        }
+    }
+    if !is_blocking || !can_block {
+        return Execution::Reply(RespValue::NullArray);
+    }
+    let blocked_client = BlockedClients::block(blocked_clients, db, keys, pop);
+    drop(store);
+    Execution::Blocked(blocked_client, timeout, BlockedAction::Mpop(pop, count))
+}
+
+/// Pop the rest of the elements for the client blocked by BLMPOP/BZMPOP from the key of the element handed over to
+/// it, and reply with all of them
+/// Only a single element is handed over when the client is served, so the others are popped afterwards, which is
+/// propagated as LPOP/RPOP/ZPOPMIN/ZPOPMAX with a count.
+fn mpop_handoff(
+    server: &Server,
+    db: usize,
+    (key, val, popped_score): Handoff,
+    pop: Pop,
+    count: usize,
+) -> RespValue {
+    let exclusive = transaction::ATOMICITY_LOCK.write().unwrap();
+    let mut store = server.databases[db].lock().unwrap();
+    // The key may have been overwritten by a different type in the meantime
+    let rest = pop_many_for(&mut store, &key, pop, count - 1).unwrap_or_default();
+    drop(store);
+    if !rest.is_empty() {
+        publish_keyspace_events(server);
+        let name: &[u8] = match pop {
+            Pop::List(ListEnd::Left) => b"LPOP",
+            Pop::List(ListEnd::Right) => b"RPOP",
+            Pop::SortedSet(SortedSetEnd::Min) => b"ZPOPMIN",
+            Pop::SortedSet(SortedSetEnd::Max) => b"ZPOPMAX",
+            Pop::Stream => unreachable!("XREAD doesn't pop"),
+        };
+        let command = vec![
+            name.to_vec(),
+            key.clone(),
+            rest.len().to_string().into_bytes(),
+        ];
+        propagate(server, &[(db, command)]);
+    }
+    drop(exclusive);
+    let mut popped = vec![(val, popped_score)];
+    popped.extend(rest);
+    mpop_reply(key, popped)
+}
 
-/// Represent different types of possible values for a key.
-enum RedisType {
-    /// Array/list data type.
-    List(VecDeque<String>),
-    /// String data type.
-    Val(String),
-}
-
-/// Represent all the data for a key
-struct RedisValue {
-    /// Creation time of the key
-    creation_time: SystemTime,
-    /// Actual data
-    data: RedisType,
-    /// TTL of the key
-    ttl: Option<u64>, // in ms; it is optional as it may not be present for every key and thus will be infinite
-}
-
-// Periodically remove the expired keys
-// Procedure-
-// 1) Randomly sample the HashMap keys and check its TTL.
-// 2) If TTL is expired, then remove it from the HashMap. (TODO: need to do this efficiently, maybe by storing the keys in a Vec also)
-// 3) Sleep for some time.
-// 4) Repeat from step 1.
-// async fn delete_expired_keys(redis_key_val_store: Arc<Mutex<HashMap<String, RedisValue>>>) {
-//     loop {
-//         // Sleep for 1 second before checking for expired keys
-//         time::sleep(Duration::from_secs(1)).await;
-//         let mut store = redis_key_val_store.lock().unwrap();
-//         let current_time = SystemTime::now();
-//         // let dbsize = store.len();
-//
-//         // Iterate through the keys and remove expired ones
-//         store.retain(|_, value| {
-//             if let Some(ttl) = value.ttl {
-//                 current_time < value.creation_time + Duration::from_millis(ttl)
-//             } else {
-//                 true // Keep keys with no TTL
-//             }
-//         });
-//     }
-// }
+/// What a client blocked on keys does with the element handed over to it
+enum BlockedAction {
+    /// Reply with the element and its key, by BLPOP/BRPOP/BZPOPMIN/BZPOPMAX, which popped it as given
+    Pop(Pop),
+    /// Pop more elements from its key up to the count, and reply with them along with the key, by BLMPOP/BZMPOP,
+    /// which popped it as given
+    Mpop(Pop, usize),
+    /// Push it to the end of the destination list and reply with it, by BLMOVE, which popped it from the end
+    Move(ListEnd, Vec<u8>, ListEnd),
+    /// Reply with the entries added to the stream at the key handed over, by XREAD
+    Read(StreamReads),
+    /// Deliver the entries added to the stream at the key handed over to the consumer and reply with them, by
+    /// XREADGROUP
+    ReadGroup(GroupRead),
+}
 
-/// Compute output of the LRANGE command in human readable form, or an error
-fn lrange(
-    redis_key_val_store: &Arc<Mutex<HashMap<String, RedisValue>>>,
-    parsed_command: &[String],
-) -> Result<Vec<String>, &'static str> {
-    let mut output_array: Vec<String> = Vec::new();
+/// Outcome of waiting for a push to the lists a client is blocked on
+enum BlockedWait {
+    /// A pushed element was handed over to the client
+    Served(Handoff),
+    /// The timeout elapsed without any push
+    TimedOut,
+    /// The client closed the connection; an element handed over right before must be put back
+    ClientClosed(Option<Handoff>),
+}
 
-    if let Some(redis_val) = redis_key_val_store
-        .lock()
-        .unwrap()
-        .get(parsed_command[1].as_str())
-    {
-        let RedisType::List(ref list_at_key) = redis_val.data else {
-            return Err("WRONGTYPE Operation against a key holding the wrong kind of value");
+/// Wait until the client closes the connection or it gets killed by CLIENT KILL
+async fn client_gone<R: AsyncRead + Unpin>(resp_reader: &mut RespReader<R>, kill: &Notify) {
+    tokio::select! {
+        () = resp_reader.closed() => {}
+        () = kill.notified() => {}
+    }
+}
+
+/// Wait until the command of the client isn't delayed by CLIENT PAUSE anymore, returning false if the client got
+/// killed meanwhile
+/// The master of a replica is never paused, like the replicas themselves, whose links aren't served as clients.
+async fn wait_unpaused(
+    server: &Server,
+    client: &ClientState,
+    command: Option<&CommandSpec>,
+    parsed_command: &[Vec<u8>],
+    kill: &Notify,
+) -> bool {
+    if client.is_master {
+        return true;
+    }
+    let is_write = command.is_some_and(CommandSpec::is_write)
+        || (command.is_some_and(|command| command.name() == "exec")
+            && client
+                .transaction
+                .as_ref()
+                .is_some_and(Transaction::has_writes));
+    let mut pause = server.clients.lock().unwrap().watch_pause();
+    loop {
+        let current = *pause.borrow_and_update();
+        let Some(until) = current.and_then(|pause| pause.delays(parsed_command, is_write)) else {
+            return true;
         };
-        let (start_index, stop_index) = (parsed_command[2].clone(), parsed_command[3].clone());
+        // Woken up early when the pause is lifted or changed, and checked again
+        tokio::select! {
+            () = time::sleep(until.saturating_duration_since(Instant::now())) => {}
+            _ = pause.changed() => {}
+            () = kill.notified() => return false,
+        }
+    }
+}
+
+/// Sleep for the duration, or forever if there is none
+async fn sleep_for(duration: Option<Duration>) {
+    match duration {
+        Some(duration) => time::sleep(duration).await,
+        None => future::pending().await,
+    }
+}
+
+/// Wait until the blocked client is served, the timeout elapses or the client goes away
+async fn wait_blocked<R: AsyncRead + Unpin>(
+    resp_reader: &mut RespReader<R>,
+    kill: &Notify,
+    mut blocked_client: BlockedClient,
+    timeout: Option<Duration>,
+) -> BlockedWait {
+    let mut client_closed = false;
+    let handoff = tokio::select! {
+        handoff = &mut blocked_client.receiver => handoff.ok(),
+        () = sleep_for(timeout) => None,
+        () = client_gone(resp_reader, kill) => {
+            client_closed = true;
+            None
+        }
+    };
+    // An element may have been handed over right when the wait ended
+    let handoff = handoff.or_else(|| {
+        blocked_client.receiver.close();
+        blocked_client.receiver.try_recv().ok()
+    });
+    // Unblock the client from all the keys
+    drop(blocked_client);
+
+    match handoff {
+        _ if client_closed => BlockedWait::ClientClosed(handoff),
+        Some(handoff) => BlockedWait::Served(handoff),
+        None => BlockedWait::TimedOut,
+    }
+}
+
+/// Put an element handed over to a client back into its list or sorted set in the database, as the client went away
+/// before receiving it
+fn restore_handoff(server: &Server, db: usize, (key, val, popped_score): Handoff, pop: Pop) {
+    // This is a write, so it is propagated like the commands
+    let _exclusive = transaction::ATOMICITY_LOCK.write().unwrap();
+    let mut store = server.databases[db].lock().unwrap();
+    let restored = match (pop, popped_score) {
+        // Put back at the same end from which it was popped, as if it was never popped
+        (Pop::List(end), _) => push_and_serve(
+            &mut store,
+            &server.blocked_clients,
+            db,
+            &key,
+            end,
+            [val.clone()],
+        )
+        .map(|_| push_command(key, val, end)),
+        (Pop::SortedSet(_), Some(member_score)) => store
+            .get_or_insert_typed::<SortedSet>(&key)
+            .map(|sorted_set| {
+                sorted_set.insert(val.clone(), member_score);
+            })
+            .map(|()| {
+                store.notify(EventClass::SortedSet, "zadd", &key);
+                zset::serve_blocked_clients(&mut store, &server.blocked_clients, db, &key);
+                vec![
+                    b"ZADD".to_vec(),
+                    key,
+                    member_score.to_string().into_bytes(),
+                    val,
+                ]
+            }),
+        (Pop::SortedSet(_), None) => unreachable!("members are handed over with their scores"),
+        (Pop::Stream, _) => unreachable!("XREAD takes nothing from the stream"),
+    };
+    drop(store);
+    // The element is dropped if the key got overwritten by a different type in the meantime
+    if let Ok(restoring_command) = restored {
+        publish_keyspace_events(server);
+        let mut commands = vec![(db, restoring_command)];
+        commands.extend(handed_over_pops(server));
+        propagate(server, &commands);
+    }
+}
+
+/// LPUSH/RPUSH of the element to the end of the list at the key
+fn push_command(key: Vec<u8>, val: Vec<u8>, end: ListEnd) -> Vec<Vec<u8>> {
+    let push = match end {
+        ListEnd::Left => b"LPUSH".to_vec(),
+        ListEnd::Right => b"RPUSH".to_vec(),
+    };
+    vec![push, key, val]
+}
+
+/// Propagate the push of an element to the list at the key of the database, which a blocked client made after
+/// being served, followed by the pops of the elements handed over to the clients blocked on that list
+fn propagate_push(server: &Server, db: usize, key: Vec<u8>, val: Vec<u8>, end: ListEnd) {
+    let mut commands = vec![(db, push_command(key, val, end))];
+    commands.extend(handed_over_pops(server));
+    propagate(server, &commands);
+}
+
+/// Push an element handed over to a client blocked by BLMOVE to the destination list, returning the reply to
+/// BLMOVE
+/// The element is put back into the source list if the destination can't take it anymore.
+fn move_handoff(
+    server: &Server,
+    db: usize,
+    handoff: Handoff,
+    from: ListEnd,
+    (destination, to): (Vec<u8>, ListEnd),
+) -> RespValue {
+    let exclusive = transaction::ATOMICITY_LOCK.write().unwrap();
+    let mut store = server.databases[db].lock().unwrap();
+    let pushed = push_and_serve(
+        &mut store,
+        &server.blocked_clients,
+        db,
+        &destination,
+        to,
+        [handoff.1.clone()],
+    );
+    drop(store);
+    if let Err(err) = pushed {
+        drop(exclusive);
+        restore_handoff(server, db, handoff, Pop::List(from));
+        return RespValue::error(err);
+    }
+    publish_keyspace_events(server);
+    let val = handoff.1;
+    propagate_push(server, db, destination, val.clone(), to);
+    drop(exclusive);
+    RespValue::BulkString(val)
+}
+
+/// Deliver the entries of the stream at the key handed over to the client blocked by XREADGROUP, and reply with
+/// them
+/// The delivery is propagated as XREADGROUP without blocking, which delivers the same entries when replayed.
+fn read_group_handoff(server: &Server, db: usize, key: &[u8], group_read: &GroupRead) -> RespValue {
+    let exclusive = transaction::ATOMICITY_LOCK.write().unwrap();
+    let reply = match group_read.read_served(&server.databases[db], key) {
+        // Another consumer may have been delivered the entries right after the client was served
+        Ok(streams_entries) if streams_entries.is_empty() => RespValue::NullArray,
+        Ok(streams_entries) => {
+            propagate(server, &[(db, group_read.command(key))]);
+            stream::streams_entries_resp(streams_entries)
+        }
+        Err(err) => RespValue::Error(err),
+    };
+    publish_keyspace_events(server);
+    drop(exclusive);
+    reply
+}
+
+/// Reply to WAIT right away if enough replicas acknowledged the last write of the client, or else ask the replicas
+/// to acknowledge their offset and wait for it
+/// A client which didn't write anything yet has nothing to wait for.
+fn wait(
+    server: &Server,
+    offset: u64,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Result<Execution, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(command::WRONG_ARITY);
+    }
+    if server.config.read().unwrap().replicaof.is_some() {
+        return Err("ERR WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.");
+    }
+    let numreplicas =
+        parse_redis_int(&parsed_command[1]).ok_or("ERR value is not an integer or out of range")?;
+    let timeout_ms = parse_redis_int(&parsed_command[2])
+        .ok_or("ERR timeout is not an integer or out of range")?;
+    if timeout_ms < 0 {
+        return Err("ERR timeout is negative");
+    }
 
-        // Crash if aren't able to go from usize to isize
-        let list_length = isize::try_from(list_at_key.len()).unwrap();
-        let mut start_index = start_index.parse::<isize>().unwrap_or(list_length);
-        let mut stop_index = stop_index.parse::<isize>().unwrap_or(list_length);
+    let mut replicas = server.replicas.lock().unwrap();
+    let count = replicas.acked_count(offset);
+    // A negative number of replicas is always reached
+    let numreplicas = usize::try_from(numreplicas).unwrap_or(0);
+    if count >= numreplicas || !can_block {
+        return Ok(Execution::Reply(RespValue::Integer(
+            i64::try_from(count).unwrap(),
+        )));
+    }
+    replicas.request_acks();
+    drop(replicas);
+    // Like for the blocking commands, 0 means to wait forever
+    let timeout = (timeout_ms > 0).then(|| Duration::from_millis(timeout_ms.unsigned_abs()));
+    Ok(Execution::WaitForReplicas(offset, numreplicas, timeout))
+}
 
-        if start_index < 0 {
-            start_index += list_length;
+/// Error replied to FAILOVER, once its arguments are validated along with whether a failover could start
+/// Handing over to a replica isn't supported, so no failover is ever in progress, but clients probing for it get the
+/// same errors as from Redis.
+fn failover(server: &Server, parsed_command: &[Vec<u8>]) -> &'static str {
+    let mut target = None;
+    let mut has_timeout = false;
+    let mut is_forced = false;
+    let mut is_abort = false;
+    let mut args = parsed_command[1..].iter();
+    while let Some(arg) = args.next() {
+        if arg.eq_ignore_ascii_case(b"to") && target.is_none() {
+            let (Some(host), Some(port)) = (args.next(), args.next()) else {
+                return "ERR syntax error";
+            };
+            let Some(port) = parse_redis_int(port).and_then(|port| u16::try_from(port).ok()) else {
+                return "ERR value is not an integer or out of range";
+            };
+            target = Some((String::from_utf8_lossy(host).into_owned(), port));
+        } else if arg.eq_ignore_ascii_case(b"timeout") && !has_timeout {
+            let Some(timeout_ms) = args.next() else {
+                return "ERR syntax error";
+            };
+            match parse_redis_int(timeout_ms) {
+                Some(timeout_ms) if timeout_ms > 0 => has_timeout = true,
+                Some(_) => return "ERR FAILOVER timeout must be greater than 0",
+                None => return "ERR value is not an integer or out of range",
+            }
+        } else if arg.eq_ignore_ascii_case(b"force") && !is_forced {
+            is_forced = true;
+        } else if arg.eq_ignore_ascii_case(b"abort") && !is_abort {
+            is_abort = true;
+        } else {
+            return "ERR syntax error";
         }
-        if stop_index < 0 {
-            stop_index += list_length;
+    }
+
+    if is_abort && (target.is_some() || has_timeout || is_forced) {
+        return "ERR FAILOVER abort cannot be used with other arguments.";
+    }
+    if is_abort {
+        return "ERR No failover in progress.";
+    }
+    if server.config.read().unwrap().replicaof.is_some() {
+        return "ERR FAILOVER is not valid when server is a replica.";
+    }
+    let replicas = server.replicas.lock().unwrap();
+    if replicas.is_empty() {
+        return "ERR FAILOVER requires connected replicas.";
+    }
+    if is_forced && !(has_timeout && target.is_some()) {
+        return "ERR FAILOVER with force option requires both a timeout and target HOST and IP.";
+    }
+    if let Some((ref host, port)) = target {
+        if !replicas.is_connected(host, port) {
+            return "ERR FAILOVER target HOST and PORT is not a replica.";
         }
-        start_index = start_index.max(0);
-        stop_index = stop_index.min(list_length - 1);
+    }
+    drop(replicas);
+    "ERR FAILOVER is not supported by this server."
+}
 
-        if start_index > stop_index {
-            return Ok(output_array);
+/// Reply of LCS; with `IDX` every match is the ranges of both strings, followed by its length with `WITHMATCHLEN`
+fn lcs_reply(lcs: Lcs) -> RespValue {
+    let integer = |n: usize| RespValue::Integer(i64::try_from(n).unwrap());
+    let range = |(start, end)| RespValue::Array(vec![integer(start), integer(end)]);
+    match lcs {
+        Lcs::Subsequence(subsequence) => RespValue::BulkString(subsequence),
+        Lcs::Len(len) => integer(len),
+        Lcs::Matches(matches, len, with_match_len) => {
+            let matches = matches
+                .into_iter()
+                .map(|lcs_match| {
+                    let mut reply = vec![range(lcs_match.a), range(lcs_match.b)];
+                    if with_match_len {
+                        reply.push(integer(lcs_match.len()));
+                    }
+                    RespValue::Array(reply)
+                })
+                .collect();
+            RespValue::Map(vec![
+                (
+                    RespValue::BulkString(b"matches".to_vec()),
+                    RespValue::Array(matches),
+                ),
+                (RespValue::BulkString(b"len".to_vec()), integer(len)),
+            ])
         }
+    }
+}
+
+/// Reply of WAITAOF: whether the local AOF and how many replicas flushed the writes to their AOF
+fn waitaof_reply(is_fsynced: bool, count: usize) -> RespValue {
+    RespValue::Array(vec![
+        RespValue::Integer(i64::from(is_fsynced)),
+        RespValue::Integer(i64::try_from(count).unwrap()),
+    ])
+}
+
+/// Reply to WAITAOF right away if the last write of the client is flushed to the local AOF, when asked for, and to
+/// the AOF of enough replicas, or else ask the replicas to acknowledge their offset and wait for it
+/// The local AOF counts for at most 1, and only a replica with its AOF enabled counts at all.
+fn waitaof(
+    server: &Server,
+    offset: u64,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Result<Execution, &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(command::WRONG_ARITY);
+    }
+    if server.config.read().unwrap().replicaof.is_some() {
+        return Err("ERR WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.");
+    }
+    let numlocal =
+        parse_redis_int(&parsed_command[1]).ok_or("ERR value is not an integer or out of range")?;
+    let numreplicas =
+        parse_redis_int(&parsed_command[2]).ok_or("ERR value is not an integer or out of range")?;
+    let timeout_ms = parse_redis_int(&parsed_command[3])
+        .ok_or("ERR timeout is not an integer or out of range")?;
+    if timeout_ms < 0 {
+        return Err("ERR timeout is negative");
+    }
 
-        #[expect(clippy::cast_sign_loss, reason = "Negative value is clipped above")]
-        let start_index = start_index as usize;
-        #[expect(clippy::cast_sign_loss, reason = "Negative value causes early return")]
-        let stop_index = stop_index as usize;
+    let is_fsynced = match *server.aof.lock().unwrap() {
+        Some(ref aof) => aof.fsynced_offset() >= offset,
+        None if numlocal > 0 => {
+            return Err(
+                "ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.",
+            )
+        }
+        None => false,
+    };
+    let mut replicas = server.replicas.lock().unwrap();
+    let count = replicas.fsynced_count(offset);
+    // A negative number of replicas is always reached
+    let numreplicas = usize::try_from(numreplicas).unwrap_or(0);
+    let is_local_reached = is_fsynced || numlocal <= 0;
+    if (is_local_reached && count >= numreplicas) || !can_block {
+        return Ok(Execution::Reply(waitaof_reply(is_fsynced, count)));
+    }
+    if count < numreplicas {
+        replicas.request_acks();
+    }
+    drop(replicas);
+    let timeout = (timeout_ms > 0).then(|| Duration::from_millis(timeout_ms.unsigned_abs()));
+    Ok(Execution::WaitForAof(
+        offset,
+        numlocal > 0,
+        numreplicas,
+        timeout,
+    ))
+}
 
-        output_array = (start_index..=stop_index)
-            .map(|x| list_at_key.get(x).unwrap().clone())
-            .collect();
+/// Compute output of the LRANGE command in human readable form, or an error
+fn lrange(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Vec<Vec<u8>>, &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(command::WRONG_ARITY);
     }
+    let start_index =
+        parse_redis_int(&parsed_command[2]).ok_or("ERR value is not an integer or out of range")?;
+    let stop_index =
+        parse_redis_int(&parsed_command[3]).ok_or("ERR value is not an integer or out of range")?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let output_array = store
+        .get_typed::<VecDeque<Vec<u8>>>(&parsed_command[1])?
+        .and_then(|list_at_key| {
+            list::clamp_range(start_index, stop_index, list_at_key.len())
+                .map(|range| list_at_key.range(range).cloned().collect())
+        })
+        .unwrap_or_default();
+    drop(store);
     Ok(output_array)
 }
 
-/// A very basic parser for RESP
-/// Currently only handles non-nested arrays
-/// Returns the parsed output in human readable form
-fn parse_command(input: &str) -> Vec<String> {
-    #[expect(
-        clippy::collection_is_never_read,
-        reason = "Will solve this in future when writing proper parser"
-    )]
-    let mut command_list: Vec<String> = Vec::new();
-
-    let mut input_it = input.trim().chars().enumerate();
-    // for (ind, char) in input_it {
-    while let Some((ind, char)) = input_it.next() {
-        #[expect(
-            clippy::single_match,
-            reason = "Will solve this in future when writing proper parser"
-        )]
-        match char {
-            '*' => {
-                let (ind2, _) = input_it.find(|&x| x.1 == '\r').unwrap();
-                command_list.push(String::from(&input[ind..ind2]));
-                input_it.next();
-            }
-            _ => (),
+/// Result of running a command
+enum Execution {
+    /// Reply to be sent to the client
+    Reply(RespValue),
+    /// Multiple replies to be sent to the client, e.g. one for every channel of SUBSCRIBE
+    Replies(Vec<RespValue>),
+    /// The connection is closed after replying OK, by QUIT
+    Quit,
+    /// The server shuts down, saving the keyspace or not, by SHUTDOWN; the client only gets a reply if that fails
+    Shutdown(bool),
+    /// The client is blocked on lists until it is served or the timeout elapses, by BLPOP/BRPOP/BLMOVE
+    Blocked(BlockedClient, Option<Duration>, BlockedAction),
+    /// The connection becomes the link of a replica, by PSYNC
+    Replica(NewReplica),
+    /// The client waits until enough replicas acknowledged the offset or the timeout elapses, by WAIT
+    WaitForReplicas(u64, usize, Option<Duration>),
+    /// The client waits until the offset is flushed to the local AOF, if asked for, and to the AOF of enough replicas,
+    /// or the timeout elapses, by WAITAOF
+    WaitForAof(u64, bool, usize, Option<Duration>),
+    /// The client is replied OK once the duration elapsed, by DEBUG SLEEP
+    Sleep(Duration),
+}
+
+/// Run a single command of the client, logging it in the slow log if it took long enough
+/// Blocking commands return right away—as if their timeout elapsed—when `can_block` is false.
+fn execute_command(
+    command: &CommandSpec,
+    parsed_command: &[Vec<u8>],
+    server: &Server,
+    client: &mut ClientState,
+    can_block: bool,
+) -> Execution {
+    let started_at = Instant::now();
+    let execution = run_command(command, parsed_command, server, client, can_block);
+    let duration = started_at.elapsed();
+
+    let config = server.config.read().unwrap();
+    let (slower_than, max_len) = (config.slowlog_log_slower_than, config.slowlog_max_len);
+    drop(config);
+    if u64::try_from(slower_than)
+        .is_ok_and(|slower_than| duration.as_micros() >= u128::from(slower_than))
+    {
+        let client_addr = client
+            .addr
+            .as_ref()
+            .map(ToString::to_string)
+            .unwrap_or_default();
+        let client_name = server.clients.lock().unwrap().name(client.id);
+        server.slowlog.lock().unwrap().record(
+            parsed_command,
+            duration,
+            (client_addr, client_name),
+            max_len,
+        );
+    }
+    execution
+}
+
+/// Run a single command of the client, as `execute_command` does without the slow log
+fn run_command(
+    command: &CommandSpec,
+    parsed_command: &[Vec<u8>],
+    server: &Server,
+    client: &mut ClientState,
+    can_block: bool,
+) -> Execution {
+    feed_monitors(server, client, command, parsed_command);
+    command.run(server, client, parsed_command, can_block)
+}
+
+/// Pops of the elements handed over to blocked clients since this was last called
+/// Replayed without blocking, these pop the same elements from the same lists and sorted sets.
+fn handed_over_pops(server: &Server) -> Vec<PropagatedCommand> {
+    let handed_over = server.blocked_clients.lock().unwrap().take_handed_over();
+    handed_over
+        .into_iter()
+        .filter_map(|(db, key, pop)| {
+            let command = match pop {
+                Pop::List(ListEnd::Left) => vec![b"BLPOP".to_vec(), key, b"0".to_vec()],
+                Pop::List(ListEnd::Right) => vec![b"BRPOP".to_vec(), key, b"0".to_vec()],
+                Pop::SortedSet(SortedSetEnd::Min) => vec![b"ZPOPMIN".to_vec(), key],
+                Pop::SortedSet(SortedSetEnd::Max) => vec![b"ZPOPMAX".to_vec(), key],
+                // XREAD doesn't change the stream
+                Pop::Stream => return None,
+            };
+            Some((db, command))
+        })
+        .collect()
+}
+
+/// Commands to be propagated for a command which ran against the database with the given reply
+/// These are the command itself (with absolute times) if it is a write which didn't fail, or the removal of the
+/// members SPOP picked, or XADD with the ID it generated, or XCLAIM of the entries it claimed, followed by the pops of the elements it handed over to
+/// blocked clients. SORT is only a write with `STORE`, where it replies the number of values stored.
+fn propagated_commands(
+    server: &Server,
+    db: usize,
+    command: &CommandSpec,
+    parsed_command: &[Vec<u8>],
+    reply: &RespValue,
+) -> Vec<PropagatedCommand> {
+    let mut commands = Vec::new();
+    let name = command.name();
+    if name == "spop" {
+        commands.extend(set::spop_as_srem(&parsed_command[1], reply).map(|srem| (db, srem)));
+    } else if name == "xadd" {
+        commands.extend(stream::xadd_with_id(parsed_command, reply).map(|xadd| (db, xadd)));
+    } else if name == "xclaim" {
+        commands.extend(
+            consumer_group::xclaim_as_claimed(parsed_command, reply).map(|xclaim| (db, xclaim)),
+        );
+    } else if command.is_write()
+        && !matches!(*reply, RespValue::Error(_))
+        && !(name == "sort" && matches!(*reply, RespValue::Array(_)))
+    {
+        commands.push((db, aof::with_absolute_time(parsed_command)));
+    }
+    commands.extend(handed_over_pops(server));
+    commands
+}
+
+/// Log the commands to the AOF, if it is enabled, and send them to the replicas
+/// This must be called while holding `ATOMICITY_LOCK` for writing, so that commands are propagated in the order
+/// they ran.
+/// Returns the offset which the replicas must acknowledge for the commands to be replicated, if there were any.
+fn propagate(server: &Server, commands: &[PropagatedCommand]) -> Option<u64> {
+    if commands.is_empty() {
+        return None;
+    }
+    // MULTI and EXEC wrapping a transaction don't change anything themselves
+    let changes = commands
+        .iter()
+        .filter(|command| command.1 != [b"MULTI"] && command.1 != [b"EXEC"])
+        .count();
+    rdb::record_changes(changes as u64);
+    let mut replicas = server.replicas.lock().unwrap();
+    replicas.feed(commands);
+    let write_offset = replicas.write_offset();
+    drop(replicas);
+    // The AOF tracks the offset of its commands, which WAITAOF waits for like WAIT does for the replicas
+    let mut aof = server.aof.lock().unwrap();
+    if let Some(ref mut aof) = *aof {
+        if let Err(err) = aof.append(commands, write_offset) {
+            eprintln!("error: writing to the AOF failed: {err}");
         }
     }
+    drop(aof);
+    Some(write_offset)
+}
+
+/// Publish the keyspace events recorded by the stores of all the databases since this was last called
+fn publish_keyspace_events(server: &Server) {
+    for (db, redis_key_val_store) in server.databases.iter().enumerate() {
+        let (notify_flags, events) = redis_key_val_store.lock().unwrap().take_events();
+        notify::publish(&server.pubsub, db, notify_flags, events);
+    }
+}
 
-    // Currently, always guaranteed to contain a value
-    // let _vector_length = command_list.pop().unwrap();
+/// Run the commands queued in a transaction, replying with an array of their replies
+/// A command which fails doesn't stop the others; its error is placed in the array.
+/// Nothing is run if any of the watched keys got modified, which is replied with a null array.
+fn exec(transaction: Transaction, server: &Server, client: &mut ClientState) -> RespValue {
+    // The keys are unwatched whether or not the transaction runs
+    let watched_keys = client.watched_keys.take();
+    let commands = match transaction.into_commands() {
+        Ok(commands) => commands,
+        Err(err) => return RespValue::error(err),
+    };
 
-    // Extract only the valid strings for now
-    // The previous code is useless for now, but, might become useful later on
-    let mut command_list: Vec<String> = vec![];
-    for string in input.lines() {
-        if string.starts_with(['*', '$']) {
-            continue;
+    // No command of another client may run in between
+    let _exclusive = transaction::ATOMICITY_LOCK.write().unwrap();
+    // An evicted key counts as modified for the clients watching it
+    let specs: Vec<&CommandSpec> = commands.iter().map(|&(command, _)| command).collect();
+    if let Err(err) = evict_keys(server, client, &specs) {
+        return err;
+    }
+    if watched_keys.is_some_and(|watched_keys| watched_keys.is_modified()) {
+        return RespValue::NullArray;
+    }
+    let mut propagated = Vec::new();
+    let replies = commands
+        .iter()
+        .map(|&(command, ref parsed_command)| {
+            // SELECT may switch the database of the following commands
+            let db = client.db;
+            match execute_command(command, parsed_command, server, client, false) {
+                Execution::Reply(reply) => {
+                    propagated.extend(propagated_commands(
+                        server,
+                        db,
+                        command,
+                        parsed_command,
+                        &reply,
+                    ));
+                    reply
+                }
+                Execution::Blocked(..)
+                | Execution::WaitForReplicas(..)
+                | Execution::WaitForAof(..)
+                | Execution::Sleep(_) => {
+                    unreachable!("blocking is disabled inside transactions")
+                }
+                Execution::Replies(_)
+                | Execution::Replica(_)
+                | Execution::Quit
+                | Execution::Shutdown(_) => {
+                    unreachable!("the command is rejected inside transactions")
+                }
+            }
+        })
+        .collect();
+
+    // Wrapped in a transaction, so that it is replayed either in full or not at all; MULTI and EXEC run against the
+    // databases of their neighbours, so that the transaction doesn't start or end with a SELECT of its own
+    if let (Some(&(first_db, _)), Some(&(last_db, _))) = (propagated.first(), propagated.last()) {
+        propagated.insert(0, (first_db, vec![b"MULTI".to_vec()]));
+        propagated.push((last_db, vec![b"EXEC".to_vec()]));
+        if let Some(write_offset) = propagate(server, &propagated) {
+            client.write_offset = write_offset;
         }
-        command_list.push(String::from(string));
     }
+    RespValue::Array(replies)
+}
+
+/// Evict keys as `maxmemory-policy` chooses while the memory used exceeds `maxmemory`, propagating their removal,
+/// and then check that the commands about to run are allowed to use more memory, returning the OOM error otherwise
+/// A replica evicts nothing, as its master propagates the removal of the keys it evicts. This must be called while
+/// holding `ATOMICITY_LOCK` for writing, so that the removals are propagated before the commands.
+fn evict_keys(
+    server: &Server,
+    client: &mut ClientState,
+    commands: &[&CommandSpec],
+) -> Result<(), RespValue> {
+    let config = server.config.read().unwrap();
+    if config.replicaof.is_some() {
+        return Ok(());
+    }
+    let (maxmemory, policy) = (config.maxmemory, config.maxmemory_policy);
+    drop(config);
+    let (evicted, is_over_limit) = eviction::evict(&server.databases, maxmemory, policy);
+    let removals: Vec<PropagatedCommand> = evicted
+        .into_iter()
+        .map(|(db, key)| (db, vec![b"DEL".to_vec(), key]))
+        .collect();
+    if let Some(write_offset) = propagate(server, &removals) {
+        client.write_offset = write_offset;
+    }
+    let may_use_memory = commands.iter().any(|command| command.is_denyoom());
+    if is_over_limit && may_use_memory {
+        return Err(RespValue::error(eviction::OOM));
+    }
+    Ok(())
+}
 
-    command_list
+/// Revert the connection to the state it had when it was accepted, returning the reply to RESET
+/// The open transaction, if any, is discarded by the caller.
+fn reset(server: &Server, client: &mut ClientState) -> RespValue {
+    client.db = 0;
+    client.protocol = Protocol::default();
+    client.watched_keys = None;
+    acl::DEFAULT_USER.clone_into(&mut client.user);
+    client.authenticated = server.acl.lock().unwrap().authenticates_by_default();
+    pubsub::unsubscribe_all(&server.pubsub, &mut client.subscription, client.id);
+    client.monitor = None;
+    server.monitors.lock().unwrap().remove(client.id);
+    server.clients.lock().unwrap().reset(client.id);
+    RespValue::simple("RESET")
 }
 
-#[expect(
-    clippy::too_many_lines,
-    reason = "Will handle this later by creating a Redis class"
-)]
-/// Process a client connection
-/// This function handles multiple requests from a single client
-async fn process(
-    mut stream: TcpStream,
-    redis_key_val_store: Arc<Mutex<HashMap<String, RedisValue>>>,
-    // oneshot_store: Arc<TMutex<HashMap<String, VecDeque<Sender<()>>>>>,
-    oneshot_store: Arc<Mutex<HashMap<String, VecDeque<Sender<()>>>>>,
+/// Stream the command about to run to the monitors
+/// Like in Redis, the commands administering the server, MONITOR included, aren't streamed.
+fn feed_monitors(
+    server: &Server,
+    client: &ClientState,
+    command: &CommandSpec,
+    parsed_command: &[Vec<u8>],
 ) {
-    // Can handle input string of 1024 bytes
-    let mut buf = [0; 1024];
+    if !command.is_admin() {
+        server
+            .monitors
+            .lock()
+            .unwrap()
+            .feed(client.db, client.addr.as_ref(), parsed_command);
+    }
+}
 
-    while let Ok(bytes_read) = stream.read(&mut buf).await {
-        if bytes_read == 0 {
-            break;
+/// Check that the client may run the command, which is None if it can't run, returning the error otherwise
+/// Only what is needed to authenticate or to leave can run before authenticating, whatever the permissions.
+fn check_access(
+    server: &Server,
+    client: &mut ClientState,
+    command: Option<&CommandSpec>,
+    parsed_command: &[Vec<u8>],
+) -> Result<(), RespValue> {
+    let is_always_allowed =
+        command.is_some_and(|command| matches!(command.name(), "auth" | "quit" | "reset"));
+    // HELLO may authenticate the client itself
+    let is_hello = command.is_some_and(|command| command.name() == "hello");
+    if !client.authenticated && !is_always_allowed && !is_hello {
+        return Err(RespValue::error("NOAUTH Authentication required."));
+    }
+    // The master of a replica isn't a user, and the user of a client which isn't authenticated yet has yet to be
+    // known
+    let Some(command) =
+        command.filter(|_| !is_always_allowed && client.authenticated && !client.is_master)
+    else {
+        return Ok(());
+    };
+    let permitted = server
+        .acl
+        .lock()
+        .unwrap()
+        .check(&client.user, command, parsed_command);
+    permitted.map_err(|err| {
+        // Like any other command which can't run, this aborts the open transaction
+        if let Some(ref mut transaction) = client.transaction {
+            transaction.abort();
         }
-        let parsed_command = parse_command(str::from_utf8(&buf[..bytes_read]).unwrap());
+        RespValue::Error(err)
+    })
+}
 
-        // Main Redis Server functioning
+/// Error for a command which the client can't run in the mode it is in, i.e. monitor mode, or subscriber mode in
+/// RESP2 as RESP3 clients can tell replies from messages
+fn mode_error(client: &ClientState, name: &str) -> Option<RespValue> {
+    if client.monitor.is_some() {
+        return Some(RespValue::Error(format!(
+            "ERR Can't execute '{name}': only QUIT / RESET are allowed in monitor mode"
+        )));
+    }
+    let is_subscriber = client.subscription.is_some() && client.protocol == Protocol::Resp2;
+    let is_allowed = matches!(
+        name,
+        "" | "subscribe" | "unsubscribe" | "psubscribe" | "punsubscribe" | "ping"
+    );
+    (is_subscriber && !is_allowed).then(|| {
+        RespValue::Error(format!(
+            "ERR Can't execute '{name}': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"
+        ))
+    })
+}
 
-        // Redis commands are case insensitive
-        let redis_output = match parsed_command[0].to_lowercase().as_str() {
-            "ping" => "+PONG\r\n",
-            "echo" => &format!("+{}\r\n", parsed_command[1].as_str()), // TODO: why .as_str() doesn't work here???
-            "client" => "+OK\r\n+OK\r\n", // Default redis-rs client sends 2 arrays when creating connection
-            "set" => {
-                // If expiry time parameters are passed then set TTL (in ms) else TTL is infinite
-                let ttl_ms = if parsed_command.len() >= 5 {
-                    Some(parsed_command[4].parse::<u64>().unwrap())
-                } else {
-                    None // Infinite TTL
-                };
-
-                // This overwrites the value if the key already exists
-                redis_key_val_store.lock().unwrap().insert(
-                    parsed_command[1].clone(),
-                    RedisValue {
-                        data: RedisType::Val(parsed_command[2].clone()),
-                        creation_time: SystemTime::now(),
-                        ttl: ttl_ms,
-                    },
-                );
-                "+OK\r\n"
-            }
-            "get" => {
-                let mut store = redis_key_val_store.lock().unwrap();
-                #[expect(
-                    clippy::option_if_let_else,
-                    reason = "Difficult to handle this case as `store` is causing borrow checker issues inside closure"
-                )]
-                let returned_value = match store.get(parsed_command[1].as_str()) {
-                    Some(x) => {
-                        let key_expired = x.ttl.is_some()
-                            && SystemTime::now()
-                                > x.creation_time + Duration::from_millis(x.ttl.unwrap());
-                        if key_expired {
-                            // Remove the key as it has expired
-                            // This is called "PASSIVE EXPIRY" in Redis
-                            store.remove(&parsed_command[1]);
-                            drop(store);
-                            "$-1" // Return "Null bulk string" if the input key has expired and consequently does not exist
-                        } else {
-                            #[expect(clippy::match_wildcard_for_single_variants, reason="As only RedisType::Val is allowed for 'GET' operations")]
-                            match x.data {
-                                // Only accept string values
-                                RedisType::Val(ref val) => &format!("${}\r\n{}", val.len(), val),
-                                _ => "-WRONGTYPE Operation against a key holding the wrong kind of value",
-                            }
-                        }
-                    }
-                    None => "$-1", // Return "Null bulk string" if the input key does not exist
-                };
-                &format!("{returned_value}\r\n")
-            }
-            // In milliseconds
-            "ttl" => {
-                let returned_value = redis_key_val_store
+/// Whether the command is a write which this server rejects as a replica, as only its master may write
+fn is_readonly(server: &Server, client: &ClientState, command: &CommandSpec) -> bool {
+    server.config.read().unwrap().replicaof.is_some() && !client.is_master && command.is_write()
+}
+
+/// Run a command of the client in its open transaction, which is queued unless it acts on the transaction
+/// The command is given as validated by `command::validate`, so that a command which can't run aborts the
+/// transaction.
+fn dispatch_in_transaction(
+    command: Result<&'static CommandSpec, RespValue>,
+    parsed_command: Vec<Vec<u8>>,
+    mut transaction: Transaction,
+    server: &Server,
+    client: &mut ClientState,
+) -> RespValue {
+    let name = command.as_ref().map_or("", |command| command.name());
+    let reply = match name {
+        "multi" => RespValue::error("ERR MULTI calls can not be nested"),
+        "exec" => return exec(transaction, server, client),
+        "discard" => {
+            client.watched_keys = None;
+            return RespValue::simple("OK");
+        }
+        "watch" => RespValue::error("ERR WATCH inside MULTI is not allowed"),
+        "psync" | "subscribe" | "unsubscribe" | "psubscribe" | "punsubscribe" | "shutdown"
+        | "monitor" => {
+            transaction.abort();
+            RespValue::error("ERR Command not allowed inside a transaction")
+        }
+        _ if command
+            .as_ref()
+            .is_ok_and(|command| is_readonly(server, client, command)) =>
+        {
+            transaction.abort();
+            RespValue::error("READONLY You can't write against a read only replica.")
+        }
+        _ => transaction.queue(command, parsed_command),
+    };
+    client.transaction = Some(transaction);
+    reply
+}
+
+/// Run a command of a client which has no open transaction
+fn dispatch_outside_transaction(
+    command: &CommandSpec,
+    parsed_command: &[Vec<u8>],
+    server: &Server,
+    client: &mut ClientState,
+    can_block: bool,
+) -> Execution {
+    match command.name() {
+        "monitor" => {
+            client.monitor = Some(
+                server
+                    .monitors
                     .lock()
                     .unwrap()
-                    .get(parsed_command[1].as_str())
-                    .map_or_else(
-                        || String::from("-1"), // Key does not exist
-                        |x| {
-                            x.ttl.map_or_else(
-                                || String::from("-1"), // Key with no TTL set
-                                |ttl| {
-                                    let ttl_left = (x.creation_time + Duration::from_millis(ttl))
-                                        .duration_since(SystemTime::now())
-                                        .unwrap_or_default(); // When the key has expired, set the duration to default of 0
-                                    ttl_left.as_millis().to_string()
-                                },
-                            )
-                        },
-                    );
-                &format!("+{returned_value}\r\n")
+                    .add(client.id, &client.output),
+            );
+            Execution::Reply(RespValue::simple("OK"))
+        }
+        "multi" => {
+            client.transaction = Some(Transaction::default());
+            Execution::Reply(RespValue::simple("OK"))
+        }
+        "exec" => Execution::Reply(RespValue::error("ERR EXEC without MULTI")),
+        "discard" => Execution::Reply(RespValue::error("ERR DISCARD without MULTI")),
+        _ if is_readonly(server, client, command) => Execution::Reply(RespValue::error(
+            "READONLY You can't write against a read only replica.",
+        )),
+        _ if command.is_write() => run_write(command, parsed_command, server, client, can_block),
+        _ => {
+            let _shared = transaction::ATOMICITY_LOCK.read().unwrap();
+            execute_command(command, parsed_command, server, client, can_block)
+        }
+    }
+}
+
+/// Run a write command, evicting keys first if needed, and propagate it
+/// Writes run one at a time, so that they are propagated in the order they ran.
+fn run_write(
+    command: &CommandSpec,
+    parsed_command: &[Vec<u8>],
+    server: &Server,
+    client: &mut ClientState,
+    can_block: bool,
+) -> Execution {
+    let _exclusive = transaction::ATOMICITY_LOCK.write().unwrap();
+    if let Err(err) = evict_keys(server, client, &[command]) {
+        return Execution::Reply(err);
+    }
+    let db = client.db;
+    let execution = execute_command(command, parsed_command, server, client, can_block);
+    if let Execution::Reply(ref reply) = execution {
+        if let Some(write_offset) = propagate(
+            server,
+            &propagated_commands(server, db, command, parsed_command, reply),
+        ) {
+            client.write_offset = write_offset;
+        }
+    }
+    execution
+}
+
+/// Run a command of the client, including the commands acting on its open transaction, given as validated by
+/// `command::validate`
+/// Writes are rejected by a replica, unless they come from its master.
+fn dispatch(
+    parsed_command: Vec<Vec<u8>>,
+    command: Result<&'static CommandSpec, RespValue>,
+    server: &Server,
+    client: &mut ClientState,
+    can_block: bool,
+) -> Execution {
+    // Transactions are handled here as they act on the state of the connection
+    let command = match command {
+        // Outside of a transaction, a command which can't run is rejected right away
+        Err(err) if client.transaction.is_none() => return Execution::Reply(err),
+        command => command,
+    };
+    let spec = command.as_ref().ok().copied();
+    if let Err(err) = check_access(server, client, spec, &parsed_command) {
+        return Execution::Reply(err);
+    }
+    let name = spec.map_or("", CommandSpec::name);
+    // The other commands are streamed by `execute_command` once they are allowed to run, e.g. when the transaction
+    // they are queued in is executed
+    let is_handled_here = matches!(name, "quit" | "reset" | "multi" | "exec" | "discard");
+    if let Some(spec) = spec.filter(|_| is_handled_here && client.monitor.is_none()) {
+        feed_monitors(server, client, spec, &parsed_command);
+    }
+    let execution = match name {
+        "quit" => Execution::Quit,
+        // Also discards the open transaction
+        "reset" => {
+            client.transaction = None;
+            Execution::Reply(reset(server, client))
+        }
+        _ => {
+            if let Some(err) = mode_error(client, name) {
+                return Execution::Reply(err);
             }
-            "dbsize" => {
-                let dbsize = redis_key_val_store.lock().unwrap().len();
-                &format!(":{dbsize}\r\n")
+            match (client.transaction.take(), command) {
+                (Some(transaction), command) => Execution::Reply(dispatch_in_transaction(
+                    command,
+                    parsed_command,
+                    transaction,
+                    server,
+                    client,
+                )),
+                (None, Ok(command)) => dispatch_outside_transaction(
+                    command,
+                    &parsed_command,
+                    server,
+                    client,
+                    can_block,
+                ),
+                (None, Err(err)) => Execution::Reply(err),
             }
-            "rpush" => {
-                let mut store = redis_key_val_store.lock().unwrap();
-
-                // Get the reference to the value; if the key doesn't exist then create it
-                let redis_val =
-                    store
-                        .entry(parsed_command[1].clone())
-                        .or_insert_with(|| RedisValue {
-                            data: RedisType::List(VecDeque::new()),
-                            creation_time: SystemTime::now(),
-                            ttl: None,
-                        });
-
-                #[expect(
-                    clippy::match_wildcard_for_single_variants,
-                    reason = "rpush command works only on List"
-                )]
-                // Insert the desired data to the referenced value, taking care of errors
-                let insertion_result: Result<usize, &str> = match redis_val.data {
-                    RedisType::List(ref mut list) => {
-                        if parsed_command.len() <= 2 {
-                            Err("ERR wrong number of arguments for command")
-                        } else {
-                            // // for x in parsed_command[2..].to_vec() {  TODO: why not ???
-                            // for x in parsed_command[2..].iter().cloned() {
-                            //     list.push_back(x);
-                            // }
-                            // TODO: Time this with above 3 lines
-                            // list.append(&mut parsed_command[2..].to_vec().into());
-                            // TODO: Time this with above line
-                            list.extend(parsed_command[2..].iter().cloned());
-                            Ok(list.len())
-                        }
-                    }
-                    _ => Err("WRONGTYPE Operation against a key holding the wrong kind of value"),
-                };
-                drop(store);
-
-                // Convert to RESP and return the result
-                match insertion_result {
-                    Ok(len) => &format!(":{len}\r\n"),
-                    Err(err) => &format!("-{err}\r\n"),
-                }
+        }
+    };
+    // Reads count too, as they remove the expired keys
+    publish_keyspace_events(server);
+    execution
+}
+
+/// Send TCP keepalive probes on the connection after the given seconds of inactivity, unless 0
+/// The connection still works without keepalive, a dead peer just goes unnoticed for longer, so failing to set it
+/// up is ignored.
+fn set_tcp_keepalive(stream: &TcpStream, seconds: u64) {
+    if seconds > 0 {
+        let keepalive = TcpKeepalive::new().with_time(Duration::from_secs(seconds));
+        let _ = SockRef::from(stream).set_tcp_keepalive(&keepalive);
+    }
+}
+
+/// Time after which the client is disconnected if it sends no command, as configured by `timeout`
+/// Like in Redis, subscribers and monitors are never idle, as they wait for messages rather than send commands.
+/// A client blocked by a command isn't waiting for its next one, so only the timeout of the command applies then.
+fn idle_timeout(server: &Server, client: &ClientState) -> Option<Duration> {
+    let timeout = server.config.read().unwrap().timeout;
+    (timeout > 0 && client.subscription.is_none() && client.monitor.is_none())
+        .then(|| Duration::from_secs(timeout))
+}
+
+/// Start listening over TCP and on the Unix socket as configured, exiting if that fails
+async fn listen(config: &Config) -> (Option<TcpListener>, Option<UnixListener>) {
+    // Like Redis, port 0 doesn't listen over TCP, which is only possible with a Unix socket
+    if config.port == 0 && config.unixsocket.is_none() {
+        eprintln!("error: configured to not listen anywhere");
+        process::exit(1);
+    }
+    let tcp_listener = if config.port == 0 {
+        None
+    } else {
+        Some(
+            TcpListener::bind(format!("127.0.0.1:{}", config.port))
+                .await
+                .unwrap(),
+        )
+    };
+    let unix_listener = config.unixsocket.as_ref().map(|path| {
+        // A socket left behind by a server which didn't shut down cleanly would fail the binding
+        let _ = fs::remove_file(path);
+        UnixListener::bind(path).unwrap_or_else(|err| {
+            eprintln!("error: can't listen on '{}': {err}", path.display());
+            process::exit(1);
+        })
+    });
+    (tcp_listener, unix_listener)
+}
+
+/// Accept the next connection over TCP, or never when not listening over TCP
+async fn accept_tcp(listener: Option<&TcpListener>) -> io::Result<TcpStream> {
+    match listener {
+        Some(listener) => listener.accept().await.map(|(stream, _)| stream),
+        None => future::pending().await,
+    }
+}
+
+/// Accept the next connection on the Unix socket, or never when not listening on one
+async fn accept_unix(listener: Option<&UnixListener>) -> io::Result<UnixStream> {
+    match listener {
+        Some(listener) => listener.accept().await.map(|(stream, _)| stream),
+        None => future::pending().await,
+    }
+}
+
+/// Process a client connection accepted over TCP
+async fn process_tcp(stream: TcpStream, server: Arc<Server>) {
+    // The connection is already gone if its addresses can't be known
+    let (Ok(addr), Ok(local_addr)) = (stream.peer_addr(), stream.local_addr()) else {
+        return;
+    };
+    set_tcp_keepalive(&stream, server.config.read().unwrap().tcp_keepalive);
+    let (reader, writer) = stream.into_split();
+    let addrs = (ClientAddr::Tcp(addr), ClientAddr::Tcp(local_addr));
+    process(reader, writer, addrs, server).await;
+}
+
+/// Process a client connection accepted on the Unix socket
+async fn process_unix(stream: UnixStream, server: Arc<Server>) {
+    // The socket can't be changed at runtime, so it is the one the connection was accepted on
+    let path = server
+        .config
+        .read()
+        .unwrap()
+        .unixsocket
+        .clone()
+        .unwrap_or_default();
+    let (reader, writer) = stream.into_split();
+    let addrs = (ClientAddr::Unix(path.clone()), ClientAddr::Unix(path));
+    process(reader, writer, addrs, server).await;
+}
+
+/// Process a client connection, given the address of the client and the one it connected to
+/// This function handles multiple requests from a single client. What is sent to the client is queued for a task of
+/// its own to write, so that a client which is slow to read doesn't hold up the processing of its commands.
+async fn process<R, W>(reader: R, writer: W, addrs: (ClientAddr, ClientAddr), server: Arc<Server>)
+where
+    R: AsyncRead + Unpin,
+    W: AsyncWrite + Unpin + Send + 'static,
+{
+    let (addr, local_addr) = addrs;
+    // Commands may be split across reads or pipelined in a single read; the reader yields them one by one
+    let mut resp_reader = RespReader::new(reader);
+    let mut client = ClientState::new();
+    client.addr = Some(addr.clone());
+    client.authenticated = server.acl.lock().unwrap().authenticates_by_default();
+    let kill = server
+        .clients
+        .lock()
+        .unwrap()
+        .register(client.id, addr, local_addr);
+    let (mut output, output_receiver) = client.output.channel();
+    let writing = tokio::spawn(write_output(writer, output_receiver));
+    // Set when the connection becomes the link of a replica, which is served once the loop ends
+    let mut new_replica = None;
+    // Whether what is queued is still written once the loop ends, rather than dropped along with the connection
+    let mut is_flushed = false;
+
+    loop {
+        if !deliver_messages(&server, &mut client, &mut resp_reader, &mut output, &kill).await {
+            break;
+        }
+
+        let read = tokio::select! {
+            read = resp_reader.read_command() => read,
+            () = kill.notified() => break,
+            () = sleep_for(idle_timeout(&server, &client)) => break,
+        };
+        let parsed_command = match read {
+            Ok(Some(parsed_command)) => parsed_command,
+            Ok(None) => {
+                // The client may only have closed its end for writing, so it still gets the replies to its last
+                // commands
+                is_flushed = true;
+                break;
             }
-            "lpush" => {
-                let mut store = redis_key_val_store.lock().unwrap();
-
-                // Get the reference to the value; if the key doesn't exist then create it
-                let redis_val =
-                    store
-                        .entry(parsed_command[1].clone())
-                        .or_insert_with(|| RedisValue {
-                            data: RedisType::List(VecDeque::new()),
-                            creation_time: SystemTime::now(),
-                            ttl: None,
-                        });
-
-                #[expect(
-                    clippy::match_wildcard_for_single_variants,
-                    reason = "lpush command works only on List"
-                )]
-                // Insert the desired data to the referenced value, taking care of errors
-                let insertion_result: Result<usize, &str> = match redis_val.data {
-                    RedisType::List(ref mut list) => {
-                        if parsed_command.len() <= 2 {
-                            Err("ERR wrong number of arguments for command")
-                        } else {
-                            for x in parsed_command[2..].iter().cloned() {
-                                list.push_front(x);
-                            }
-
-                            // Send trigger to the channel for the specified list
-                            // See `lbpop`
-                            // let _a = oneshot_store.lock().unwrap();
-                            if let Some(key) =
-                                oneshot_store.lock().unwrap().get_mut(&parsed_command[1])
-                            {
-                                if let Some(sender) = key.pop_front() {
-                                    sender.send(()).unwrap();
-                                }
-                            }
-
-                            Ok(list.len())
-                        }
-                    }
-                    _ => Err("WRONGTYPE Operation against a key holding the wrong kind of value"),
-                };
-                drop(store);
-
-                // Convert to RESP and return the result
-                match insertion_result {
-                    Ok(len) => &format!(":{len}\r\n"),
-                    Err(err) => &format!("-{err}\r\n"),
+            Err(err) => {
+                eprintln!("error: {err}");
+                // Like Redis, the client is told what was wrong with its input before the connection is closed
+                if err.is_protocol_error() {
+                    let reply = RespValue::Error(format!("ERR {err}"));
+                    is_flushed = queue_replies(&mut output, &server, &client, &[reply]);
                 }
+                break;
             }
-            "lrange" => {
-                let lrange_output = lrange(&redis_key_val_store, &parsed_command);
-
-                // Convert to RESP and return the result
-                match lrange_output {
-                    Ok(output_array) => {
-                        let output_string = format!("*{}\r\n", output_array.len());
-                        &output_array.iter().fold(output_string.clone(), |acc, x| {
-                            acc + "$" + x.len().to_string().as_str() + "\r\n" + x + "\r\n"
-                        })
-                    }
-                    Err(err) => &format!("-{err}\r\n"),
-                }
+        };
+
+        server
+            .clients
+            .lock()
+            .unwrap()
+            .record_command(client.id, &parsed_command);
+        let command = command::validate(&parsed_command);
+        let spec = command.as_ref().ok().copied();
+        if !wait_unpaused(&server, &client, spec, &parsed_command, &kill).await {
+            break;
+        }
+        let execution = dispatch(parsed_command, command, &server, &mut client, true);
+        let is_registered = server.clients.lock().unwrap().record_state(&client);
+
+        let replies = match execution {
+            Execution::Reply(reply) => vec![reply],
+            Execution::Replies(replies) => replies,
+            Execution::Quit => {
+                let reply = RespValue::simple("OK");
+                is_flushed = queue_replies(&mut output, &server, &client, &[reply]);
+                break;
             }
-            "llen" => {
-                let store = redis_key_val_store.lock().unwrap();
-
-                #[expect(clippy::match_wildcard_for_single_variants, reason = "llen command works only on List")]
-                &store
-                    .get(parsed_command[1].as_str())
-                    .map_or(Cow::Borrowed(":0\r\n"), |redis_val| match redis_val.data {
-                        RedisType::List(ref list) => Cow::Owned(format!(":{}\r\n", list.len())),
-                        _ => {
-                            Cow::Borrowed("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
-                        }
-                    })
+            Execution::Replica(replica) => {
+                new_replica = Some(replica);
+                is_flushed = true;
+                break;
             }
-            "lpop" => {
-                let mut store = redis_key_val_store.lock().unwrap();
-                let times_to_pop = parsed_command
-                    .get(2)
-                    .map_or(1, |x| x.parse::<u32>().unwrap());
-
-                if let Some(redis_val) = store.get_mut(parsed_command[1].as_str()) {
-                    #[expect(
-                        clippy::match_wildcard_for_single_variants,
-                        reason = "lpop command works only on List"
-                    )]
-                    match redis_val.data {
-                        RedisType::List(ref mut list) => {
-                            let output_array: Vec<String> =
-                                (1..=times_to_pop).map_while(|_| list.pop_front()).collect();
-
-                            // Remove the key from the store if its list has become empty
-                            if list.is_empty() {
-                                store.remove(&parsed_command[1]);
-                            }
-                            drop(store);
-
-                            if output_array.is_empty() {
-                                "$-1\r\n"
-                            } else if output_array.len() == 1 {
-                                &format!("${}\r\n{}\r\n", output_array[0].len(), output_array[0])
-                            } else {
-                                let output_string = format!("*{}\r\n", output_array.len());
-                                &output_array.iter().fold(output_string.clone(), |acc, x| {
-                                    acc + "$" + x.len().to_string().as_str() + "\r\n" + x + "\r\n"
-                                })
-                            }
-                        }
-                        _ => {
-                            "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
-                        }
-                    }
-                } else {
-                    "$-1\r\n"
+            execution => {
+                match wait_for_reply(execution, &server, client.db, &mut resp_reader, &kill).await {
+                    Some(reply) => vec![reply],
+                    None => break,
                 }
             }
-            "lbpop" => {
-                let db_list_name = &parsed_command[1];
-                // let mut store = redis_key_val_store.lock().unwrap();
-
-                if let Some(redis_val) = redis_key_val_store.lock().unwrap().get_mut(db_list_name) {
-                    #[expect(
-                        clippy::match_wildcard_for_single_variants,
-                        reason = "lbpop command works only on List"
-                    )]
-                    match redis_val.data {
-                        RedisType::List(ref mut list) => {
-                            let val = list.pop_front().unwrap();
-
-                            // Remove the key from the store if its list has become empty
-                            // if list.is_empty() {
-                            //     store.remove(db_list_name);
-                            // }
-                            // drop(store);
-                            &format!("${}\r\n{}\r\n", val.len(), val)
-                        }
-                        _ => {
-                            "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
-                        }
-                    }
+        };
+        if !queue_replies(&mut output, &server, &client, &replies) {
+            break;
+        }
+        // Like in Redis, a client whose connection got closed by its own command, e.g. as it killed itself, still
+        // gets the reply
+        if !is_registered {
+            is_flushed = true;
+            break;
+        }
+    }
+
+    // Closing the queue lets the writer finish, unless the client has to go right away, e.g. as it got killed or went
+    // over its limit, in which case a write stuck on it is dropped too
+    drop(output);
+    if !is_flushed {
+        writing.abort();
+    }
+    // The registry of subscribers must not keep clients which are gone
+    pubsub::unsubscribe_all(&server.pubsub, &mut client.subscription, client.id);
+    server.monitors.lock().unwrap().remove(client.id);
+    server.clients.lock().unwrap().unregister(client.id);
+    if let Some(new_replica) = new_replica {
+        // The replica takes over the connection once the replies before it were written
+        if let Ok(Some(writer)) = writing.await {
+            replicas::serve_replica(&server.replicas, &mut resp_reader, writer, new_replica).await;
+        }
+    }
+}
+
+/// Write what is queued for the client to its connection, returning the connection once the queue is closed and
+/// everything queued was written, or None if it can't be written to anymore
+async fn write_output<W: AsyncWrite + Unpin>(
+    mut writer: W,
+    mut receiver: OutputReceiver<Vec<u8>>,
+) -> Option<W> {
+    output::drain(&mut writer, &mut receiver).await.ok()?;
+    Some(writer)
+}
+
+/// Queue the replies for the client, returning false if it can't be sent anything anymore, as it went away or over
+/// its output buffer limit
+/// The replies to a subscriber count towards the limit of the subscribers, like its messages.
+fn queue_replies(
+    output: &mut OutputSender<Vec<u8>>,
+    server: &Server,
+    client: &ClientState,
+    replies: &[RespValue],
+) -> bool {
+    let bytes: Vec<u8> = replies
+        .iter()
+        .flat_map(|reply| reply.encode(client.protocol))
+        .collect();
+    let limits = server.config.read().unwrap().client_output_buffer_limit;
+    let limit = if client.subscription.is_some() {
+        limits.pubsub
+    } else {
+        limits.normal
+    };
+    let len = bytes.len();
+    output.send(bytes, len, limit)
+}
+
+/// Wait until a command which doesn't reply right away can reply, returning None if the client went away meanwhile
+async fn wait_for_reply<R: AsyncRead + Unpin>(
+    execution: Execution,
+    server: &Server,
+    db: usize,
+    resp_reader: &mut RespReader<R>,
+    kill: &Notify,
+) -> Option<RespValue> {
+    match execution {
+        Execution::Blocked(blocked_client, timeout, action) => {
+            let wait = wait_blocked(resp_reader, kill, blocked_client, timeout).await;
+            reply_to_blocked(server, db, wait, action)
+        }
+        Execution::Shutdown(save) => request_shutdown(server, save).await,
+        Execution::WaitForReplicas(offset, numreplicas, timeout) => {
+            tokio::select! {
+                count = replicas::wait_for_acks(
+                    &server.replicas,
+                    offset,
+                    numreplicas,
+                    timeout,
+                    Replicas::acked_count,
+                ) => Some(RespValue::Integer(i64::try_from(count).unwrap())),
+                () = client_gone(resp_reader, kill) => None,
+            }
+        }
+        Execution::WaitForAof(offset, is_local, numreplicas, timeout) => {
+            // Each side returns once it is reached or the timeout elapses, so the reply is sent when both did
+            let local = async {
+                if is_local {
+                    aof::wait_for_fsync(&server.aof, offset, timeout).await
                 } else {
-                    // let mut oneshot_store = oneshot_store.lock().await;
-                    let mut oneshot_store = oneshot_store.lock().unwrap();
-                    let channel = tokio::sync::oneshot::channel();
-
-                    let oneshot_val = oneshot_store.entry(db_list_name.clone()).or_default();
-                    oneshot_val.push_back(channel.0);
-                    drop(oneshot_store);
-
-                    channel.1.await;
-
-                    // if let Some(redis_val) = store.get_mut(db_list_name) {
-                    //     match redis_val.data {
-                    //         RedisType::List(ref mut list) => {
-                    //             let val = list.pop_front().unwrap();
-                    //
-                    //                 // Remove the key from the store if its list has become empty
-                    //                 if list.is_empty() {
-                    //                     store.remove(db_list_name);
-                    //                 }
-                    //                 drop(store);
-                    //             &format!("${}\r\n{}\r\n", val.len(), val)
-                    //         }
-                    //         _ => {
-                    //             "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
-                    //         }
-                    //     };
-                    // }
-
-                    "$1\r\n"
+                    server
+                        .aof
+                        .lock()
+                        .unwrap()
+                        .as_ref()
+                        .is_some_and(|aof| aof.fsynced_offset() >= offset)
+                }
+            };
+            let replicas = replicas::wait_for_acks(
+                &server.replicas,
+                offset,
+                numreplicas,
+                timeout,
+                Replicas::fsynced_count,
+            );
+            tokio::select! {
+                (is_fsynced, count) = async { tokio::join!(local, replicas) } => {
+                    Some(waitaof_reply(is_fsynced, count))
                 }
+                () = client_gone(resp_reader, kill) => None,
+            }
+        }
+        Execution::Sleep(duration) => {
+            tokio::select! {
+                () = time::sleep(duration) => Some(RespValue::simple("OK")),
+                () = kill.notified() => None,
+            }
+        }
+        Execution::Reply(reply) => Some(reply),
+        Execution::Replies(_) | Execution::Quit | Execution::Replica(_) => {
+            unreachable!("the connection loop handles this itself")
+        }
+    }
+}
+
+/// Deliver the messages to a subscriber, or the lines to a monitor, while it waits for its next command, returning
+/// once it sends one, or right away for other clients
+/// They are moved to the queue of the output of the client, where they keep counting towards the limit of subscribers,
+/// or of normal clients for a monitor, until written. Returns false if the connection can't be used anymore or the
+/// client got killed.
+async fn deliver_messages<R: AsyncRead + Unpin>(
+    server: &Server,
+    client: &mut ClientState,
+    resp_reader: &mut RespReader<R>,
+    output: &mut OutputSender<Vec<u8>>,
+    kill: &Notify,
+) -> bool {
+    let limits = server.config.read().unwrap().client_output_buffer_limit;
+    let protocol = client.protocol;
+    let pending = client
+        .subscription
+        .as_mut()
+        .map(|subscription| (&mut subscription.receiver, limits.pubsub))
+        .or_else(|| {
+            client
+                .monitor
+                .as_mut()
+                .map(|monitor| (monitor, limits.normal))
+        });
+    let Some((receiver, limit)) = pending else {
+        return true;
+    };
+    loop {
+        let message = tokio::select! {
+            message = receiver.recv() => message,
+            () = resp_reader.readable() => return true,
+            () = kill.notified() => return false,
+        };
+        // The queue is closed when the client goes over its output buffer limit, which also stops the writer
+        let Some(message) = message else {
+            return false;
+        };
+        let bytes = message.encode(protocol);
+        let len = bytes.len();
+        if !output.send(bytes, len, limit) {
+            return false;
+        }
+    }
+}
+
+/// Reply to a blocking pop once the wait of the client ended, unless the client went away
+/// An element handed over to a client which went away is put back into its list.
+fn reply_to_blocked(
+    server: &Server,
+    db: usize,
+    wait: BlockedWait,
+    action: BlockedAction,
+) -> Option<RespValue> {
+    match (wait, action) {
+        (BlockedWait::Served(handoff), BlockedAction::Pop(_)) => Some(handoff_reply(handoff)),
+        (BlockedWait::Served(handoff), BlockedAction::Mpop(pop, count)) => {
+            Some(mpop_handoff(server, db, handoff, pop, count))
+        }
+        (BlockedWait::Served(handoff), BlockedAction::Move(from, destination, to)) => {
+            Some(move_handoff(server, db, handoff, from, (destination, to)))
+        }
+        (BlockedWait::Served((key, ..)), BlockedAction::Read(reads)) => {
+            let streams_entries = reads.read_served(&server.databases[db], &key);
+            // The entries are gone if the key was removed right after the client was served
+            Some(if streams_entries.is_empty() {
+                RespValue::NullArray
+            } else {
+                stream::streams_entries_resp(streams_entries)
+            })
+        }
+        (BlockedWait::Served((key, ..)), BlockedAction::ReadGroup(group_read)) => {
+            Some(read_group_handoff(server, db, &key, &group_read))
+        }
+        (
+            BlockedWait::TimedOut,
+            BlockedAction::Pop(_)
+            | BlockedAction::Mpop(..)
+            | BlockedAction::Read(_)
+            | BlockedAction::ReadGroup(_),
+        ) => Some(RespValue::NullArray),
+        (BlockedWait::TimedOut, BlockedAction::Move(..)) => Some(RespValue::NullBulkString),
+        (
+            BlockedWait::ClientClosed(handoff),
+            BlockedAction::Pop(pop) | BlockedAction::Mpop(pop, _),
+        ) => {
+            if let Some(handoff) = handoff {
+                restore_handoff(server, db, handoff, pop);
             }
-            _ => {
-                // Handle case of unknown command
-                let args = parsed_command
-                    .iter()
-                    .skip(1) // First element is the command so skip it
-                    .fold(String::new(), |acc, x| acc + "`" + x + "`, ");
-                &format!(
-                    "-ERR unknown command `{}`, with args beginning with: {}\r\n",
-                    parsed_command[0], args,
-                )
+            None
+        }
+        (BlockedWait::ClientClosed(handoff), BlockedAction::Move(from, ..)) => {
+            if let Some(handoff) = handoff {
+                restore_handoff(server, db, handoff, Pop::List(from));
             }
+            None
+        }
+        // Reading took nothing from the stream
+        (BlockedWait::ClientClosed(_), BlockedAction::Read(_) | BlockedAction::ReadGroup(_)) => {
+            None
+        }
+    }
+}
+
+/// Ask the accept loop to shut down the server, returning the reply to SHUTDOWN if that failed
+async fn request_shutdown(server: &Server, save: bool) -> Option<RespValue> {
+    let (sender, receiver) = oneshot::channel();
+    let _ = server.shutdown_requests.send((save, sender));
+    receiver.await.ok()?;
+    Some(RespValue::error(
+        "ERR Errors trying to SHUTDOWN. Check logs.",
+    ))
+}
+
+/// Replay the commands read from the AOF to rebuild the keyspace, returning the database they leave selected
+fn replay(server: &Server, commands: &[Vec<Vec<u8>>]) -> usize {
+    let mut client = ClientState::new();
+    for parsed_command in commands {
+        // The commands were validated when they were read
+        let Ok(command) = command::validate(parsed_command) else {
+            continue;
         };
+        // Blocking pops were logged only when they popped an element, so they never block here; loading isn't
+        // what the slow log is for
+        run_command(command, parsed_command, server, &mut client, false);
+    }
+    client.db
+}
+
+/// Load the keyspace from the AOF if it is enabled and exists, or else from the RDB file, and then open the AOF
+/// The AOF is rewritten from the loaded keyspace when it is created and when its end was truncated.
+async fn load_keyspace(server: &Server) -> Result<(), String> {
+    // Cloned so that the lock isn't held while loading
+    let config = server.config.read().unwrap().clone();
+    let aof_path = config.aof_path();
+    let loading_error =
+        |path: &Path, err: &dyn Display| format!("loading {} failed: {err}", path.display());
+
+    let aof_commands = if config.appendonly {
+        aof::read_commands(&aof_path)
+            .await
+            .map_err(|err| loading_error(&aof_path, &err))?
+    } else {
+        None
+    };
+    let (needs_rewrite, mut selected_db) = if let Some((commands, is_truncated)) = aof_commands {
+        if is_truncated {
+            eprintln!(
+                "warning: skipping the incomplete commands at the end of {}",
+                aof_path.display()
+            );
+        }
+        let selected_db = replay(server, &commands);
+        (is_truncated, selected_db)
+    } else {
+        rdb::load(&config.db_path(), &server.databases)
+            .map_err(|err| loading_error(&config.db_path(), &err))?;
+        (config.appendonly, 0)
+    };
+
+    if config.appendonly {
+        let writing_error =
+            |err: io::Error| format!("writing {} failed: {err}", aof_path.display());
+        if needs_rewrite {
+            let snapshot = store::snapshot_databases(&server.databases);
+            selected_db = aof::rewrite(&aof_path, &snapshot).map_err(writing_error)?;
+        }
+        let aof = Aof::open(&aof_path, config.appendfsync, selected_db).map_err(writing_error)?;
+        *server.aof.lock().unwrap() = Some(aof);
+    }
+    Ok(())
+}
+
+/// Parse the configuration and serve the clients on as many threads as configured
+fn main() -> ! {
+    let config = Config::from_args(env::args().skip(1)).unwrap_or_else(|err| {
+        eprintln!("error: {err}");
+        process::exit(1);
+    });
 
-        stream.write_all(redis_output.as_bytes()).await.unwrap();
+    // Connections are tasks picked up by the worker threads, so these bound the threads however many clients connect
+    let mut runtime = runtime::Builder::new_multi_thread();
+    if config.io_threads > 0 {
+        runtime.worker_threads(config.io_threads);
     }
+    runtime
+        .enable_all()
+        .build()
+        .unwrap()
+        .block_on(serve(config))
 }
 
-#[tokio::main]
-async fn main() -> ! {
-    // Second argument must be `port`
-    let mut args: Vec<String> = env::args().collect();
-    if args.len() < 2 {
-        args.push("6379".to_string());
+/// Serve the clients with the configuration until the server shuts down
+async fn serve(config: Config) -> ! {
+    let (tcp_listener, unix_listener) = listen(&config).await;
+
+    let databases = (0..config.databases)
+        .map(|_| {
+            let mut store = KeyValStore::default();
+            store.set_listpack_limits(config.listpack_limits);
+            Arc::new(Mutex::new(store))
+        })
+        .collect();
+    let (shutdown_requests, mut requested_shutdowns) = mpsc::unbounded_channel();
+    let server = Server {
+        databases,
+        blocked_clients: Arc::new(Mutex::new(BlockedClients::default())),
+        aof: Arc::new(Mutex::new(None)),
+        replicas: Mutex::new(Replicas::new()),
+        replication: Mutex::new(ReplicationState::default()),
+        pubsub: Mutex::new(PubSub::default()),
+        monitors: Mutex::new(Monitors::default()),
+        slowlog: Mutex::new(SlowLog::default()),
+        config: RwLock::new(config.clone()),
+        run_id: replicas::random_id(),
+        started_at: Instant::now(),
+        clients: Mutex::new(Clients::default()),
+        acl: Mutex::new(Acl::new(&config.requirepass)),
+        shutdown_requests,
+        active_expire: AtomicBool::new(true),
+    };
+    if let Err(err) = load_keyspace(&server).await {
+        eprintln!("error: {err}");
+        process::exit(1);
     }
-    let port = args[1].as_str();
+    rdb::mark_loaded();
+    // Set only after loading, so that the keys being loaded aren't reported
+    apply_config(&server, &config);
+    let server = Arc::new(server);
 
-    let listener = TcpListener::bind(format!("127.0.0.1:{port}"))
-        .await
-        .unwrap();
+    // Signals the background tasks to stop when the server shuts down
+    let (_shutdown_sender, shutdown_receiver) = watch::channel(());
 
-    // Actual Redis key-val store
-    let redis_key_val_store: Arc<Mutex<HashMap<String, RedisValue>>> =
-        Arc::new(Mutex::new(HashMap::new()));
+    if config.appendonly && config.appendfsync == AppendFsync::EverySec {
+        tokio::spawn(aof::fsync_every_second(
+            Arc::clone(&server.aof),
+            shutdown_receiver.clone(),
+        ));
+    }
+
+    tokio::spawn(rdb::save_on_changes(
+        Arc::clone(&server),
+        shutdown_receiver.clone(),
+    ));
 
-    // let oneshot_store: Arc<Mutex<HashMap<String, VecDeque<(Sender<()>, Receiver<()>)>>>> =
-    //     Arc::new(Mutex::new(HashMap::new()));
-    // let oneshot_store: Arc<TMutex<HashMap<String, VecDeque<Sender<()>>>>> =
-    let oneshot_store: Arc<Mutex<HashMap<String, VecDeque<Sender<()>>>>> =
-        Arc::new(Mutex::new(HashMap::new()));
+    if let Some((ref host, port)) = config.replicaof {
+        tokio::spawn(replication::follow_master(
+            Arc::clone(&server),
+            host.clone(),
+            port,
+            shutdown_receiver.clone(),
+        ));
+    }
 
-    // // Handle "ACTIVE EXPIRY" of keys
-    // let store = redis_key_val_store.clone();
-    // tokio::spawn(async move {
-    //     delete_expired_keys(store).await;
-    // });
+    // Handle "ACTIVE EXPIRY" of keys
+    let expiry_server = Arc::clone(&server);
+    let active_expire_server = Arc::clone(&server);
+    tokio::spawn(store::delete_expired_keys(
+        server.databases.clone(),
+        move |db, notify_flags, events| {
+            notify::publish(&expiry_server.pubsub, db, notify_flags, events);
+        },
+        move || active_expire_server.active_expire.load(Ordering::Relaxed),
+        shutdown_receiver,
+    ));
 
+    let mut interrupt = unix::signal(SignalKind::interrupt()).unwrap();
+    let mut terminate = unix::signal(SignalKind::terminate()).unwrap();
     loop {
-        match listener.accept().await {
-            // The second item contains the IP and port of the new connection.
-            Ok((stream, _)) => {
-                let redis_key_val_store = Arc::clone(&redis_key_val_store); // Same as .clone()
-                                                                            // let oneshot_store = oneshot_store.clone();
-                let oneshot_store = Arc::clone(&oneshot_store);
-
-                // A new task is spawned for each inbound socket. The socket is
-                // moved to the new task and processed there.
-                tokio::spawn(async move {
-                    process(stream, redis_key_val_store, oneshot_store).await;
-                });
+        tokio::select! {
+            accepted = accept_tcp(tcp_listener.as_ref()) => match accepted {
+                Ok(stream) => {
+                    // A new task is spawned for each inbound socket. The socket is
+                    // moved to the new task and processed there.
+                    tokio::spawn(process_tcp(stream, Arc::clone(&server)));
+                }
+                Err(e) => {
+                    eprintln!("error: {e}");
+                }
+            },
+            accepted = accept_unix(unix_listener.as_ref()) => match accepted {
+                Ok(stream) => {
+                    tokio::spawn(process_unix(stream, Arc::clone(&server)));
+                }
+                Err(e) => {
+                    eprintln!("error: {e}");
+                }
+            },
+            Some((save, failed)) = requested_shutdowns.recv() => {
+                let err = shutdown::shutdown(&server, save);
+                eprintln!("error: shutting down failed: {err}");
+                let _ = failed.send(());
+            }
+            // Like Redis, the keyspace is saved as by a SHUTDOWN without arguments
+            _ = interrupt.recv() => {
+                let err = shutdown::shutdown(&server, true);
+                eprintln!("error: shutting down on SIGINT failed: {err}");
             }
-            Err(e) => {
-                eprintln!("error: {e}");
+            _ = terminate.recv() => {
+                let err = shutdown::shutdown(&server, true);
+                eprintln!("error: shutting down on SIGTERM failed: {err}");
             }
         }
     }
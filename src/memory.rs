@@ -0,0 +1,148 @@
+//! MEMORY: report the memory used for the data, as estimated for eviction rather than what the process actually
+//! allocated
+//! USAGE estimates a single key, sampling the elements of a collection, while STATS and DOCTOR cover all the
+//! databases.
+
+use crate::{command, eviction, parse_redis_int, resp::RespValue, Server};
+
+/// Number of elements of a collection which MEMORY USAGE samples by default (same as Redis)
+const DEFAULT_SAMPLES: usize = 5;
+/// Memory used below which MEMORY DOCTOR doesn't assess anything, as there is too little data (same as Redis)
+const DOCTOR_MIN_MEMORY: usize = 5 * 1024 * 1024;
+/// Percentage of `maxmemory` above which MEMORY DOCTOR warns about the memory used
+const DOCTOR_MAXMEMORY_PERCENT: u64 = 90;
+
+/// Lines of MEMORY HELP
+const HELP: &[&str] = &[
+    "DOCTOR",
+    "    Return memory problems reports.",
+    "STATS",
+    "    Return information about the memory usage of the server.",
+    "USAGE <key> [SAMPLES <count>]",
+    "    Return memory in bytes used by <key> and its value. Nested values are",
+    "    sampled up to <count> times (default: 5, 0 means sample all).",
+];
+
+/// Number of keys of all the databases along with the memory they use
+fn totals(server: &Server) -> (usize, usize) {
+    server
+        .databases
+        .iter()
+        .fold((0, 0), |(keys, used_memory), store| {
+            let mut store = store.lock().unwrap();
+            let totals = (keys + store.len(), used_memory + store.used_memory());
+            drop(store);
+            totals
+        })
+}
+
+/// MEMORY USAGE: estimated memory used by the key along with its value, null if it doesn't exist
+/// The size of the elements of a collection is estimated from `SAMPLES` of them, 5 by default and all of them if 0.
+/// Like OBJECT, this doesn't count as an access of the key.
+fn usage(
+    server: &Server,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+) -> Result<RespValue, &'static str> {
+    let samples = match parsed_command[3..] {
+        [] => DEFAULT_SAMPLES,
+        [ref option, ref count] if option.eq_ignore_ascii_case(b"samples") => {
+            let count =
+                parse_redis_int(count).ok_or("ERR value is not an integer or out of range")?;
+            match usize::try_from(count) {
+                Ok(0) => usize::MAX,
+                Ok(count) => count,
+                Err(_) => return Err("ERR syntax error"),
+            }
+        }
+        _ => return Err("ERR syntax error"),
+    };
+
+    let key = &parsed_command[2];
+    let mut store = server.databases[db].lock().unwrap();
+    let size = store
+        .peek(key)
+        .map(|(data, _)| eviction::sampled_size(key, data, samples));
+    drop(store);
+    Ok(size.map_or(RespValue::NullBulkString, |size| {
+        RespValue::Integer(i64::try_from(size).unwrap())
+    }))
+}
+
+/// MEMORY STATS: the memory used along with how much of it is the overhead of the keys rather than their values
+#[expect(
+    clippy::cast_precision_loss,
+    reason = "The percentage is only approximate anyway"
+)]
+fn stats(server: &Server) -> RespValue {
+    let (keys, used_memory) = totals(server);
+    let overhead = keys * eviction::KEY_OVERHEAD;
+    let dataset = used_memory.saturating_sub(overhead);
+    let dataset_percentage = if used_memory == 0 {
+        0.0
+    } else {
+        dataset as f64 * 100.0 / used_memory as f64
+    };
+    let integer = |n: usize| RespValue::Integer(i64::try_from(n).unwrap());
+    let stats = [
+        ("total.allocated", integer(used_memory)),
+        ("overhead.total", integer(overhead)),
+        ("keys.count", integer(keys)),
+        (
+            "keys.bytes-per-key",
+            integer(used_memory.checked_div(keys).unwrap_or(0)),
+        ),
+        ("dataset.bytes", integer(dataset)),
+        ("dataset.percentage", RespValue::Double(dataset_percentage)),
+    ];
+    RespValue::Map(
+        stats
+            .into_iter()
+            .map(|(name, value)| (RespValue::BulkString(name.as_bytes().to_vec()), value))
+            .collect(),
+    )
+}
+
+/// MEMORY DOCTOR: a report of the memory issues, which are only looked for once there is enough data
+/// The only issue is the memory used getting close to `maxmemory`, past which keys are evicted or writes refused.
+fn doctor(server: &Server) -> String {
+    let (_, used_memory) = totals(server);
+    let maxmemory = server.config.read().unwrap().maxmemory;
+    let used_memory_u64 = u64::try_from(used_memory).unwrap();
+    if used_memory < DOCTOR_MIN_MEMORY {
+        "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these \
+         conditions. Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be \
+         back to our programming as soon as I finished rebooting."
+            .to_owned()
+    } else if maxmemory > 0 && used_memory_u64 * 100 > maxmemory * DOCTOR_MAXMEMORY_PERCENT {
+        format!(
+            "Sam, I detected a few issues in this Redis instance memory implants:\n\n * High memory usage: the data \
+             uses {used_memory} bytes, over {DOCTOR_MAXMEMORY_PERCENT}% of the maxmemory limit of {maxmemory} \
+             bytes. Keys are about to be evicted, or writes refused, depending on the maxmemory-policy.\n\nI'm here \
+             to keep you safe, Sam. I want to help you.\n"
+        )
+    } else {
+        "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+            .to_owned()
+    }
+}
+
+/// Compute output of the MEMORY USAGE/STATS/DOCTOR/HELP subcommands
+pub fn memory(server: &Server, db: usize, parsed_command: &[Vec<u8>]) -> RespValue {
+    let subcommand = String::from_utf8_lossy(&parsed_command[1]).to_lowercase();
+    match (subcommand.as_str(), parsed_command.len()) {
+        ("usage", len) if len >= 3 => {
+            usage(server, db, parsed_command).unwrap_or_else(RespValue::error)
+        }
+        ("stats", 2) => stats(server),
+        ("doctor", 2) => RespValue::BulkString(doctor(server).into_bytes()),
+        ("help", 2) => RespValue::help("MEMORY", HELP),
+        ("usage" | "stats" | "doctor" | "help", _) => {
+            command::wrong_arity(&format!("memory|{subcommand}"))
+        }
+        _ => RespValue::Error(format!(
+            "ERR unknown subcommand '{}'. Try MEMORY HELP.",
+            String::from_utf8_lossy(&parsed_command[1])
+        )),
+    }
+}
@@ -0,0 +1,128 @@
+//! MONITOR: every command processed by the server is streamed to the monitoring clients, one line per command
+//! Lines are queued for every monitor, so that running a command never waits on a monitor; a monitor which doesn't
+//! drain its queue fast enough is disconnected once it reaches the output buffer limit of the normal clients, like
+//! the subscribers are.
+
+use std::{
+    collections::HashMap,
+    fmt::Write as _,
+    time::{SystemTime, UNIX_EPOCH},
+};
+
+use crate::{
+    clients::ClientAddr,
+    output::{ClientOutput, OutputLimit, OutputReceiver, OutputSender},
+    resp::{Protocol, RespValue},
+};
+
+/// Shown in place of the secrets given to a command
+pub const REDACTED: &[u8] = b"(redacted)";
+
+/// Queue of the lines of every monitoring client, by ID
+#[derive(Default)]
+pub struct Monitors {
+    /// Queue of every monitor; removed once the monitor is disconnected for being slow
+    queues: HashMap<u64, OutputSender<RespValue>>,
+    /// Output buffer limit of the monitors
+    output_limit: OutputLimit,
+}
+
+impl Monitors {
+    /// Start streaming the commands to the client, returning the queue from which it takes the lines, which is one
+    /// of the queues of its output
+    pub fn add(&mut self, client_id: u64, output: &ClientOutput) -> OutputReceiver<RespValue> {
+        let (sender, receiver) = output.channel();
+        self.queues.insert(client_id, sender);
+        receiver
+    }
+
+    /// Stop streaming the commands to the client, e.g. once it disconnects
+    pub fn remove(&mut self, client_id: u64) {
+        self.queues.remove(&client_id);
+    }
+
+    /// Change the output buffer limit of the monitors, which applies from their next line on
+    pub const fn set_output_limit(&mut self, output_limit: OutputLimit) {
+        self.output_limit = output_limit;
+    }
+
+    /// Queue the line describing the command for every monitor
+    /// `addr` is the address of the client running the command, if it has one.
+    pub fn feed(&mut self, db: usize, addr: Option<&ClientAddr>, parsed_command: &[Vec<u8>]) {
+        if self.queues.is_empty() {
+            return;
+        }
+        let line = RespValue::SimpleString(describe(db, addr, parsed_command));
+        let len = line.encode(Protocol::Resp2).len();
+        // The queue of a monitor which can't take the line is dropped: the monitor either went over the limit and gets
+        // disconnected, or is gone
+        let limit = self.output_limit;
+        self.queues
+            .retain(|_, queue| queue.send(line.clone(), len, limit));
+    }
+}
+
+/// Line of a command, as Redis formats it, e.g. `1339518083.107412 [0 127.0.0.1:60866] "set" "foo" "bar"`
+fn describe(db: usize, addr: Option<&ClientAddr>, parsed_command: &[Vec<u8>]) -> String {
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default();
+    let client = addr.map_or_else(
+        || "?".to_owned(),
+        |addr| match *addr {
+            ClientAddr::Tcp(addr) => addr.to_string(),
+            ClientAddr::Unix(ref path) => format!("unix:{}", path.display()),
+        },
+    );
+    let mut line = format!(
+        "{}.{:06} [{db} {client}]",
+        now.as_secs(),
+        now.subsec_micros()
+    );
+    for (i, arg) in parsed_command.iter().enumerate() {
+        let arg = if is_secret(parsed_command, i) {
+            REDACTED
+        } else {
+            arg
+        };
+        line.push(' ');
+        quote(&mut line, arg);
+    }
+    line
+}
+
+/// Check whether the argument at the index is a password or a user name, which mustn't show up in the stream
+pub fn is_secret(parsed_command: &[Vec<u8>], index: usize) -> bool {
+    let name = &parsed_command[0];
+    if name.eq_ignore_ascii_case(b"auth") {
+        return index > 0;
+    }
+    // `HELLO protover AUTH username password`
+    name.eq_ignore_ascii_case(b"hello")
+        && parsed_command
+            .iter()
+            .skip(2)
+            .position(|arg| arg.eq_ignore_ascii_case(b"auth"))
+            .is_some_and(|auth| (auth + 3..auth + 5).contains(&index))
+}
+
+/// Append the argument within double quotes, escaping the quotes, backslashes and unprintable characters
+fn quote(line: &mut String, arg: &[u8]) {
+    line.push('"');
+    for &c in arg {
+        match c {
+            b'\\' => line.push_str("\\\\"),
+            b'"' => line.push_str("\\\""),
+            b'\n' => line.push_str("\\n"),
+            b'\r' => line.push_str("\\r"),
+            b'\t' => line.push_str("\\t"),
+            0x07 => line.push_str("\\a"),
+            0x08 => line.push_str("\\b"),
+            _ if c.is_ascii_graphic() || c == b' ' => line.push(char::from(c)),
+            _ => {
+                let _ = write!(line, "\\x{c:02x}");
+            }
+        }
+    }
+    line.push('"');
+}
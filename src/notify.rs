@@ -0,0 +1,171 @@
+//! Keyspace notifications: modifications of the keys are published to Pub/Sub channels, so that clients can react
+//! to them
+//! Every event is published to `__keyspace@<db>__:<key>` with the event as the message (with the `K` flag) and to
+//! `__keyevent@<db>__:<event>` with the key as the message (with the `E` flag), provided that its class is enabled
+//! by the flags of `notify-keyspace-events` as well; `<db>` is the index of the database of the key.
+
+use std::{fmt, sync::Mutex};
+
+use crate::pubsub::PubSub;
+
+/// `K`: publish to the keyspace channels of the keys
+const KEYSPACE: u16 = 1 << 0;
+/// `E`: publish to the keyevent channels of the events
+const KEYEVENT: u16 = 1 << 1;
+
+/// Class of an event, which is published only if its flag is enabled
+#[derive(Clone, Copy)]
+pub enum EventClass {
+    /// `g`: commands which aren't specific to a type, such as DEL and EXPIRE
+    Generic,
+    /// `$`: commands on strings
+    String,
+    /// `l`: commands on lists
+    List,
+    /// `s`: commands on sets
+    Set,
+    /// `h`: commands on hashes
+    Hash,
+    /// `z`: commands on sorted sets
+    SortedSet,
+    /// `t`: commands on streams
+    Stream,
+    /// `x`: keys removed because they expired
+    Expired,
+    /// `e`: keys evicted because of the memory limit
+    Evicted,
+}
+
+impl EventClass {
+    /// Classes of the `A` flag, i.e. all of them
+    const ALL: [Self; 9] = [
+        Self::Generic,
+        Self::String,
+        Self::List,
+        Self::Set,
+        Self::Hash,
+        Self::SortedSet,
+        Self::Expired,
+        Self::Evicted,
+        Self::Stream,
+    ];
+
+    /// Flag character of the class
+    const fn flag(self) -> char {
+        match self {
+            Self::Generic => 'g',
+            Self::String => '$',
+            Self::List => 'l',
+            Self::Set => 's',
+            Self::Hash => 'h',
+            Self::SortedSet => 'z',
+            Self::Stream => 't',
+            Self::Expired => 'x',
+            Self::Evicted => 'e',
+        }
+    }
+
+    /// Bit of the class in the flags
+    const fn bit(self) -> u16 {
+        match self {
+            Self::Generic => 1 << 2,
+            Self::String => 1 << 3,
+            Self::List => 1 << 4,
+            Self::Set => 1 << 5,
+            Self::Hash => 1 << 6,
+            Self::SortedSet => 1 << 7,
+            Self::Expired => 1 << 8,
+            Self::Evicted => 1 << 9,
+            Self::Stream => 1 << 10,
+        }
+    }
+}
+
+/// Bits of all the classes
+const ALL_CLASSES: u16 = {
+    let mut bits = 0;
+    let mut index = 0;
+    while index < EventClass::ALL.len() {
+        bits |= EventClass::ALL[index].bit();
+        index += 1;
+    }
+    bits
+};
+
+/// Flags of `notify-keyspace-events`; nothing is published by default
+#[derive(Clone, Copy, Default)]
+pub struct NotifyFlags(u16);
+
+impl NotifyFlags {
+    /// Parse the flag characters, e.g. `KEA` or `Elg`; returns `None` for an unknown character
+    pub fn parse(flags: &str) -> Option<Self> {
+        let mut bits = 0;
+        for flag in flags.chars() {
+            bits |= match flag {
+                'K' => KEYSPACE,
+                'E' => KEYEVENT,
+                'A' => ALL_CLASSES,
+                _ => EventClass::ALL
+                    .into_iter()
+                    .find(|class| class.flag() == flag)?
+                    .bit(),
+            };
+        }
+        Some(Self(bits))
+    }
+
+    /// Whether events of the class are published to any channel
+    pub const fn allows(self, class: EventClass) -> bool {
+        self.0 & class.bit() != 0 && self.0 & (KEYSPACE | KEYEVENT) != 0
+    }
+}
+
+// Formatted like Redis does for CONFIG GET, with `A` standing for all the classes
+impl fmt::Display for NotifyFlags {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        if self.0 & ALL_CLASSES == ALL_CLASSES {
+            f.write_str("A")?;
+        } else {
+            for class in EventClass::ALL {
+                if self.0 & class.bit() != 0 {
+                    write!(f, "{}", class.flag())?;
+                }
+            }
+        }
+        if self.0 & KEYSPACE != 0 {
+            f.write_str("K")?;
+        }
+        if self.0 & KEYEVENT != 0 {
+            f.write_str("E")?;
+        }
+        Ok(())
+    }
+}
+
+/// An event which happened to a key, e.g. `set` or `expired`
+pub struct KeyspaceEvent {
+    /// Name of the event
+    pub event: &'static str,
+    /// Key which the event happened to
+    pub key: Vec<u8>,
+}
+
+/// Publish the events of the database to their keyspace and keyevent channels, as enabled by the flags
+pub fn publish(pubsub: &Mutex<PubSub>, db: usize, flags: NotifyFlags, events: Vec<KeyspaceEvent>) {
+    if events.is_empty() {
+        return;
+    }
+    let mut pubsub = pubsub.lock().unwrap();
+    for KeyspaceEvent { event, key } in events {
+        if flags.0 & KEYSPACE != 0 {
+            let mut channel = format!("__keyspace@{db}__:").into_bytes();
+            channel.extend_from_slice(&key);
+            pubsub.publish(&channel, event.as_bytes());
+        }
+        if flags.0 & KEYEVENT != 0 {
+            let channel = format!("__keyevent@{db}__:{event}");
+            pubsub.publish(channel.as_bytes(), &key);
+        }
+    }
+    drop(pubsub);
+}
@@ -0,0 +1,253 @@
+//! Queues of what is sent to a client: the replies to its commands, the messages of Pub/Sub, the lines of MONITOR
+//! and the replication stream of a replica
+//! Queuing never waits on the client, so the bytes queued count towards its output buffer limit instead, which is
+//! configured for every class of clients like `client-output-buffer-limit` of Redis. A client going over its limit
+//! is disconnected rather than letting the queue grow without bounds. The queues of a client all count towards the
+//! same limit, and its connection is written to by a task of its own, so that neither running its commands nor
+//! queuing for it ever waits on a client which is slow to read.
+
+use std::{
+    fmt,
+    sync::{
+        atomic::{AtomicBool, AtomicU64, Ordering},
+        Arc,
+    },
+};
+
+use tokio::{
+    io::{self, AsyncWrite, AsyncWriteExt as _},
+    sync::{mpsc, Notify},
+    time::{Duration, Instant},
+};
+
+use crate::config::parse_memory;
+
+/// Output buffer limit of a class of clients
+#[derive(Clone, Copy, Default, PartialEq, Eq)]
+pub struct OutputLimit {
+    /// Bytes at which the client is disconnected right away, or 0 for no limit
+    pub hard: u64,
+    /// Bytes at which the client is disconnected if it stays above them for `soft_seconds`, or 0 for no limit
+    pub soft: u64,
+    /// Seconds for which the client may stay above the soft limit
+    pub soft_seconds: u64,
+}
+
+impl OutputLimit {
+    /// Check whether the bytes queued exceed the limit; `above_soft_since` tracks since when they are above the soft
+    /// limit, if they are
+    fn is_exceeded(self, queued: u64, above_soft_since: &mut Option<Instant>) -> bool {
+        if self.hard > 0 && queued >= self.hard {
+            return true;
+        }
+        if self.soft == 0 || queued < self.soft {
+            *above_soft_since = None;
+            return false;
+        }
+        let since = *above_soft_since.get_or_insert_with(Instant::now);
+        since.elapsed() > Duration::from_secs(self.soft_seconds)
+    }
+}
+
+/// Output buffer limits of every class of clients
+#[derive(Clone, Copy, PartialEq, Eq)]
+pub struct OutputLimits {
+    /// Limit of the normal clients
+    pub normal: OutputLimit,
+    /// Limit of the replicas
+    pub replica: OutputLimit,
+    /// Limit of the clients subscribed to Pub/Sub channels or patterns
+    pub pubsub: OutputLimit,
+}
+
+impl Default for OutputLimits {
+    /// Same limits as Redis
+    fn default() -> Self {
+        Self {
+            normal: OutputLimit::default(),
+            replica: OutputLimit {
+                hard: 256 * 1024 * 1024,
+                soft: 64 * 1024 * 1024,
+                soft_seconds: 60,
+            },
+            pubsub: OutputLimit {
+                hard: 32 * 1024 * 1024,
+                soft: 8 * 1024 * 1024,
+                soft_seconds: 60,
+            },
+        }
+    }
+}
+
+impl OutputLimits {
+    /// Set the limits of the classes given as `<class> <hard> <soft> <soft seconds>`, possibly several times over,
+    /// where the limits in bytes may have a unit, e.g. `pubsub 32mb 8mb 60`; the other classes keep their limits
+    pub fn parse(&mut self, value: &str) -> Result<(), &'static str> {
+        let words: Vec<&str> = value.split_whitespace().collect();
+        if words.is_empty() || !words.len().is_multiple_of(4) {
+            return Err("Wrong number of arguments in buffer limit configuration.");
+        }
+        let mut limits = *self;
+        for class_limit in words.chunks_exact(4) {
+            let limit = match class_limit[0].to_lowercase().as_str() {
+                "normal" => &mut limits.normal,
+                "replica" | "slave" => &mut limits.replica,
+                "pubsub" => &mut limits.pubsub,
+                _ => return Err("Invalid client class specified in buffer limit configuration."),
+            };
+            let invalid =
+                "Error in hard, soft or soft_seconds setting in buffer limit configuration.";
+            *limit = OutputLimit {
+                hard: parse_memory(class_limit[1]).map_err(|_| invalid)?,
+                soft: parse_memory(class_limit[2]).map_err(|_| invalid)?,
+                soft_seconds: class_limit[3].parse().map_err(|_| invalid)?,
+            };
+        }
+        *self = limits;
+        Ok(())
+    }
+}
+
+impl fmt::Display for OutputLimits {
+    /// Format the limits like CONFIG GET replies them, with the limits in bytes
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let classes = [
+            ("normal", self.normal),
+            ("slave", self.replica),
+            ("pubsub", self.pubsub),
+        ];
+        let classes: Vec<String> = classes
+            .iter()
+            .map(|&(name, limit)| {
+                format!(
+                    "{name} {} {} {}",
+                    limit.hard, limit.soft, limit.soft_seconds
+                )
+            })
+            .collect();
+        f.write_str(&classes.join(" "))
+    }
+}
+
+/// State shared by both ends of a queue
+#[derive(Default)]
+struct Shared {
+    /// Number of bytes queued
+    queued: AtomicU64,
+    /// Whether the client went over its limit
+    is_overflowed: AtomicBool,
+    /// Signalled when the client goes over its limit
+    overflow: Notify,
+}
+
+impl Shared {
+    /// Wait until the client goes over its limit
+    async fn overflowed(&self) {
+        loop {
+            let notified = self.overflow.notified();
+            if self.is_overflowed.load(Ordering::Acquire) {
+                return;
+            }
+            notified.await;
+        }
+    }
+}
+
+/// End of a queue into which the values sent to a client are put
+pub struct OutputSender<T> {
+    /// Values along with their length in bytes
+    sender: mpsc::UnboundedSender<(T, u64)>,
+    /// State shared with the receiver
+    shared: Arc<Shared>,
+    /// Since when the bytes queued are above the soft limit, if they are
+    above_soft_since: Option<Instant>,
+}
+
+impl<T> OutputSender<T> {
+    /// Queue the value, which takes the given number of bytes of output, returning false if the client can't be sent
+    /// anything anymore, as it went away or over the limit; in the latter case it gets disconnected
+    pub fn send(&mut self, value: T, len: usize, limit: OutputLimit) -> bool {
+        let len = u64::try_from(len).unwrap();
+        let queued = self.shared.queued.fetch_add(len, Ordering::AcqRel) + len;
+        if limit.is_exceeded(queued, &mut self.above_soft_since) {
+            self.shared.is_overflowed.store(true, Ordering::Release);
+            // Both the writer of the connection and the connection itself may be waiting
+            self.shared.overflow.notify_waiters();
+            return false;
+        }
+        self.sender.send((value, len)).is_ok()
+    }
+}
+
+/// End of a queue from which the values sent to a client are taken to be written to its connection
+pub struct OutputReceiver<T> {
+    /// Values along with their length in bytes
+    receiver: mpsc::UnboundedReceiver<(T, u64)>,
+    /// State shared with the sender
+    shared: Arc<Shared>,
+}
+
+impl<T> OutputReceiver<T> {
+    /// Take the next value, or None once the sender is gone, which is right away if the client went over its limit
+    pub async fn recv(&mut self) -> Option<T> {
+        tokio::select! {
+            biased;
+            () = self.shared.overflowed() => None,
+            item = self.receiver.recv() => {
+                let (value, len) = item?;
+                self.shared.queued.fetch_sub(len, Ordering::AcqRel);
+                Some(value)
+            }
+        }
+    }
+
+    /// Wait until the client goes over its limit, e.g. to stop writing to a client which doesn't read
+    pub async fn overflowed(&self) {
+        self.shared.overflowed().await;
+    }
+}
+
+/// Output of a client, from which all its queues are created so that their bytes count together towards its limit
+#[derive(Default)]
+pub struct ClientOutput {
+    /// State shared by all the queues of the client
+    shared: Arc<Shared>,
+}
+
+impl ClientOutput {
+    /// Create a queue of the values sent to the client
+    pub fn channel<T>(&self) -> (OutputSender<T>, OutputReceiver<T>) {
+        let (sender, receiver) = mpsc::unbounded_channel();
+        (
+            OutputSender {
+                sender,
+                shared: Arc::clone(&self.shared),
+                above_soft_since: None,
+            },
+            OutputReceiver {
+                receiver,
+                shared: Arc::clone(&self.shared),
+            },
+        )
+    }
+}
+
+/// Create a queue of the values sent to a client which has no other queue
+pub fn channel<T>() -> (OutputSender<T>, OutputReceiver<T>) {
+    ClientOutput::default().channel()
+}
+
+/// Write the bytes taken from the queue to the connection until the queue is closed
+/// The writing stops as soon as the client goes over its limit, even if it is stuck on a client which doesn't read.
+pub async fn drain<W: AsyncWrite + Unpin>(
+    writer: &mut W,
+    receiver: &mut OutputReceiver<Vec<u8>>,
+) -> io::Result<()> {
+    while let Some(bytes) = receiver.recv().await {
+        tokio::select! {
+            written = writer.write_all(&bytes) => written?,
+            () = receiver.overflowed() => break,
+        }
+    }
+    Ok(())
+}
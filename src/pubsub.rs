@@ -0,0 +1,278 @@
+//! Pub/Sub: messages published to a channel are delivered to all the clients subscribed to it, or to a pattern
+//! matching it
+//! Messages are queued for every subscriber, so that publishing never waits on a subscriber; a subscriber which
+//! doesn't drain its queue fast enough is disconnected once it reaches the output buffer limit of its class.
+
+use std::{
+    collections::{HashMap, HashSet},
+    sync::Mutex,
+};
+
+use crate::{
+    glob,
+    output::{ClientOutput, OutputLimit, OutputReceiver, OutputSender},
+    resp::{Protocol, RespValue},
+};
+
+/// What a client subscribes to
+#[derive(Clone, Copy)]
+pub enum SubscriptionKind {
+    /// A single channel, by SUBSCRIBE
+    Channel,
+    /// All the channels matching a glob-style pattern, by PSUBSCRIBE
+    Pattern,
+}
+
+impl SubscriptionKind {
+    /// Kinds of the replies confirming a subscription and an unsubscription
+    const fn reply_kinds(self) -> (&'static str, &'static str) {
+        match self {
+            Self::Channel => ("subscribe", "unsubscribe"),
+            Self::Pattern => ("psubscribe", "punsubscribe"),
+        }
+    }
+}
+
+/// Subscribers of every channel and pattern
+#[derive(Default)]
+pub struct PubSub {
+    /// IDs of the clients subscribed to every channel
+    channels: HashMap<Vec<u8>, HashSet<u64>>,
+    /// IDs of the clients subscribed to every pattern
+    patterns: HashMap<Vec<u8>, HashSet<u64>>,
+    /// Queue of the messages of every subscribed client; removed once the client is disconnected for being slow
+    queues: HashMap<u64, OutputSender<RespValue>>,
+    /// Output buffer limit of the subscribers
+    output_limit: OutputLimit,
+}
+
+/// Channels and patterns to which a client is subscribed; the client is in subscriber mode while it has any
+pub struct Subscription {
+    /// Subscribed channels, in the order they were subscribed to
+    channels: Vec<Vec<u8>>,
+    /// Subscribed patterns, in the order they were subscribed to
+    patterns: Vec<Vec<u8>>,
+    /// Messages delivered to the client; closed when the client is disconnected for being slow
+    pub receiver: OutputReceiver<RespValue>,
+}
+
+impl Subscription {
+    /// Subscribed channels or patterns
+    const fn subscribed_mut(&mut self, kind: SubscriptionKind) -> &mut Vec<Vec<u8>> {
+        match kind {
+            SubscriptionKind::Channel => &mut self.channels,
+            SubscriptionKind::Pattern => &mut self.patterns,
+        }
+    }
+
+    /// Number of channels or patterns subscribed to
+    pub const fn subscribed_count(&self, kind: SubscriptionKind) -> usize {
+        match kind {
+            SubscriptionKind::Channel => self.channels.len(),
+            SubscriptionKind::Pattern => self.patterns.len(),
+        }
+    }
+
+    /// Number of channels and patterns subscribed to
+    const fn count(&self) -> usize {
+        self.channels.len() + self.patterns.len()
+    }
+}
+
+/// Queue the message for each of the subscribers, returning the number of subscribers which received it
+/// The queue of a client which can't take the message anymore is removed: either the client is gone and unsubscribes
+/// on its way out, or it went over the limit and gets disconnected.
+fn deliver(
+    queues: &mut HashMap<u64, OutputSender<RespValue>>,
+    subscribers: &HashSet<u64>,
+    frame: &RespValue,
+    limit: OutputLimit,
+) -> usize {
+    // The frame takes about as many bytes in RESP3, where only its type differs
+    let len = frame.encode(Protocol::Resp2).len();
+    let mut receivers = 0;
+    for client_id in subscribers {
+        let Some(queue) = queues.get_mut(client_id) else {
+            continue;
+        };
+        if queue.send(frame.clone(), len, limit) {
+            receivers += 1;
+        } else {
+            queues.remove(client_id);
+        }
+    }
+    receivers
+}
+
+impl PubSub {
+    /// Subscribers of every channel or pattern
+    const fn subscribers_mut(
+        &mut self,
+        kind: SubscriptionKind,
+    ) -> &mut HashMap<Vec<u8>, HashSet<u64>> {
+        match kind {
+            SubscriptionKind::Channel => &mut self.channels,
+            SubscriptionKind::Pattern => &mut self.patterns,
+        }
+    }
+
+    /// Change the output buffer limit of the subscribers, which applies from their next message on
+    pub const fn set_output_limit(&mut self, output_limit: OutputLimit) {
+        self.output_limit = output_limit;
+    }
+
+    /// Deliver the message to the subscribers of the channel and of the patterns matching it, returning the number
+    /// of deliveries; a client subscribed both ways receives the message once for each
+    pub fn publish(&mut self, channel: &[u8], message: &[u8]) -> usize {
+        let mut receivers = 0;
+        if let Some(subscribers) = self.channels.get(channel) {
+            let frame = RespValue::Push(vec![
+                RespValue::BulkString(b"message".to_vec()),
+                RespValue::BulkString(channel.to_vec()),
+                RespValue::BulkString(message.to_vec()),
+            ]);
+            receivers += deliver(&mut self.queues, subscribers, &frame, self.output_limit);
+        }
+        for (pattern, subscribers) in &self.patterns {
+            if glob::matches(pattern, channel) {
+                let frame = RespValue::Push(vec![
+                    RespValue::BulkString(b"pmessage".to_vec()),
+                    RespValue::BulkString(pattern.clone()),
+                    RespValue::BulkString(channel.to_vec()),
+                    RespValue::BulkString(message.to_vec()),
+                ]);
+                receivers += deliver(&mut self.queues, subscribers, &frame, self.output_limit);
+            }
+        }
+        receivers
+    }
+}
+
+/// Reply confirming a subscription change, along with the number of channels and patterns the client is left
+/// subscribed to
+fn subscription_reply(kind: &str, target: Option<&[u8]>, count: usize) -> RespValue {
+    RespValue::Push(vec![
+        RespValue::BulkString(kind.as_bytes().to_vec()),
+        target.map_or(RespValue::NullBulkString, |target| {
+            RespValue::BulkString(target.to_vec())
+        }),
+        RespValue::Integer(i64::try_from(count).unwrap()),
+    ])
+}
+
+/// Subscribe the client to the channels or patterns, entering subscriber mode; there is one reply for each of them
+/// The queue of its messages is one of the queues of its output.
+pub fn subscribe(
+    pubsub: &Mutex<PubSub>,
+    subscription: &mut Option<Subscription>,
+    client_id: u64,
+    output: &ClientOutput,
+    kind: SubscriptionKind,
+    targets: &[Vec<u8>],
+) -> Vec<RespValue> {
+    let mut pubsub = pubsub.lock().unwrap();
+    let subscription = subscription.get_or_insert_with(|| {
+        let (sender, receiver) = output.channel();
+        pubsub.queues.insert(client_id, sender);
+        Subscription {
+            channels: Vec::new(),
+            patterns: Vec::new(),
+            receiver,
+        }
+    });
+
+    let mut replies = Vec::new();
+    for target in targets {
+        if pubsub
+            .subscribers_mut(kind)
+            .entry(target.clone())
+            .or_default()
+            .insert(client_id)
+        {
+            subscription.subscribed_mut(kind).push(target.clone());
+        }
+        replies.push(subscription_reply(
+            kind.reply_kinds().0,
+            Some(target),
+            subscription.count(),
+        ));
+    }
+    drop(pubsub);
+    replies
+}
+
+/// Unsubscribe the client from the channels or patterns, or from all of them if none is given; there is one reply
+/// for each of them
+/// The client leaves subscriber mode once it isn't subscribed to anything.
+pub fn unsubscribe(
+    pubsub: &Mutex<PubSub>,
+    subscription: &mut Option<Subscription>,
+    client_id: u64,
+    kind: SubscriptionKind,
+    targets: &[Vec<u8>],
+) -> Vec<RespValue> {
+    let reply_kind = kind.reply_kinds().1;
+    let Some(ref mut client_subscription) = *subscription else {
+        // Like Redis, confirm even though the client wasn't subscribed
+        return if targets.is_empty() {
+            vec![subscription_reply(reply_kind, None, 0)]
+        } else {
+            targets
+                .iter()
+                .map(|target| subscription_reply(reply_kind, Some(target), 0))
+                .collect()
+        };
+    };
+    let targets = if targets.is_empty() {
+        client_subscription.subscribed_mut(kind).clone()
+    } else {
+        targets.to_vec()
+    };
+
+    let mut pubsub = pubsub.lock().unwrap();
+    let mut replies = Vec::new();
+    for target in &targets {
+        let subscribed = client_subscription.subscribed_mut(kind);
+        if let Some(position) = subscribed.iter().position(|other| other == target) {
+            subscribed.remove(position);
+            let all_subscribers = pubsub.subscribers_mut(kind);
+            if let Some(subscribers) = all_subscribers.get_mut(target) {
+                subscribers.remove(&client_id);
+                if subscribers.is_empty() {
+                    all_subscribers.remove(target);
+                }
+            }
+        }
+        replies.push(subscription_reply(
+            reply_kind,
+            Some(target),
+            client_subscription.count(),
+        ));
+    }
+    if targets.is_empty() {
+        replies.push(subscription_reply(
+            reply_kind,
+            None,
+            client_subscription.count(),
+        ));
+    }
+    if client_subscription.count() == 0 {
+        pubsub.queues.remove(&client_id);
+        *subscription = None;
+    }
+    drop(pubsub);
+    replies
+}
+
+/// Unsubscribe the client from everything, e.g. once it disconnects
+pub fn unsubscribe_all(
+    pubsub: &Mutex<PubSub>,
+    subscription: &mut Option<Subscription>,
+    client_id: u64,
+) {
+    for kind in [SubscriptionKind::Channel, SubscriptionKind::Pattern] {
+        if subscription.is_some() {
+            unsubscribe(pubsub, subscription, client_id, kind, &[]);
+        }
+    }
+}
@@ -0,0 +1,1090 @@
+//! RDB persistence, i.e. point-in-time snapshots of the keyspace in the file format of Redis
+//! A file is `REDIS` followed by a 4 digit version, auxiliary fields, the keys of every database
+//! and finally the EOF opcode followed by the CRC64 of everything before it.
+//! Besides SAVE and BGSAVE, a snapshot is taken in the background whenever a save point of the `save` parameter is
+//! reached, i.e. enough changes were made since enough seconds after the last snapshot.
+
+use std::{
+    collections::{BTreeMap, BTreeSet, HashMap, HashSet, VecDeque},
+    fs, io,
+    path::{Path, PathBuf},
+    process, str,
+    sync::{
+        atomic::{AtomicBool, AtomicU64, Ordering},
+        Arc, Mutex, MutexGuard,
+    },
+    thread,
+    time::{Duration, SystemTime, UNIX_EPOCH},
+};
+
+use thiserror::Error;
+use tokio::{
+    sync::watch,
+    task,
+    time::{self, Instant},
+};
+
+use crate::{
+    consumer_group::{Consumer, ConsumerGroup, PendingEntry},
+    listpack::{self, Element},
+    store::{self, Entry, KeyValStore, RedisType},
+    stream::{Fields, Stream, StreamId, STREAM_NODE_MAX_ENTRIES},
+    zset::SortedSet,
+    Server, REDIS_VERSION,
+};
+
+/// Magic string at the start of every RDB file
+const MAGIC: &[u8] = b"REDIS";
+/// Version of the format which is written (Redis 7.2); the value types written here are understood by newer versions too
+const RDB_VERSION: u32 = 11;
+/// Newest version of the format which can be read (Redis 7.4)
+const MAX_RDB_VERSION: u32 = 12;
+
+/// Opcode of the LFU access frequency of the next key
+const OPCODE_FREQ: u8 = 0xF9;
+/// Opcode of the LRU idle time of the next key
+const OPCODE_IDLE: u8 = 0xF8;
+/// Opcode of an auxiliary field, i.e. a key-value pair of metadata about the file
+const OPCODE_AUX: u8 = 0xFA;
+/// Opcode of the number of keys and the number of keys with an expiry in the current database
+const OPCODE_RESIZEDB: u8 = 0xFB;
+/// Opcode of the expiry time of the next key in milliseconds
+const OPCODE_EXPIRETIME_MS: u8 = 0xFC;
+/// Opcode of the expiry time of the next key in seconds
+const OPCODE_EXPIRETIME: u8 = 0xFD;
+/// Opcode selecting the database of the following keys
+const OPCODE_SELECTDB: u8 = 0xFE;
+/// Opcode at the end of the file, before the checksum
+const OPCODE_EOF: u8 = 0xFF;
+
+/// Type of a string value
+const TYPE_STRING: u8 = 0;
+/// Type of a list value, stored as a plain sequence of its elements
+const TYPE_LIST: u8 = 1;
+/// Type of a set value, stored as a plain sequence of its members
+const TYPE_SET: u8 = 2;
+/// Type of a hash value, stored as a plain sequence of its field/value pairs
+const TYPE_HASH: u8 = 4;
+/// Type of a sorted set value, stored as a sequence of members with their scores as binary doubles
+const TYPE_ZSET_2: u8 = 5;
+/// Type of a stream value, stored as listpacks of its entries followed by its last ID and its consumer groups
+const TYPE_STREAM_LISTPACKS: u8 = 15;
+/// Same as `TYPE_STREAM_LISTPACKS`, with the first ID, the greatest deleted ID and the number of entries ever added
+/// after the last ID (Redis 7.0)
+const TYPE_STREAM_LISTPACKS_2: u8 = 19;
+/// Same as `TYPE_STREAM_LISTPACKS_2`, with the last active time of every consumer (Redis 7.2)
+const TYPE_STREAM_LISTPACKS_3: u8 = 21;
+
+/// Flag of an entry of a stream listpack which was deleted
+const STREAM_ITEM_FLAG_DELETED: i64 = 1;
+/// Flag of an entry of a stream listpack with the same fields as the master entry, which are then left out
+const STREAM_ITEM_FLAG_SAMEFIELDS: i64 = 2;
+/// Error of a listpack of a stream which doesn't hold entries
+const MALFORMED_STREAM: RdbError = RdbError::Malformed("invalid stream listpack");
+/// Error of a consumer group whose pending entries don't match those of its consumers
+const MALFORMED_GROUP: RdbError = RdbError::Malformed("invalid stream consumer group");
+/// Number of entries read by a group when it isn't known, which Redis then works out on its own
+const UNKNOWN_ENTRIES_READ: u64 = u64::MAX;
+/// Last active time of a consumer which never got any entry
+const NEVER_ACTIVE: u64 = u64::MAX;
+
+/// Special encoding of a string as an 8-bit signed integer
+const ENCODING_INT8: u8 = 0;
+/// Special encoding of a string as a 16-bit signed integer
+const ENCODING_INT16: u8 = 1;
+/// Special encoding of a string as a 32-bit signed integer
+const ENCODING_INT32: u8 = 2;
+/// Special encoding of a string compressed with LZF
+const ENCODING_LZF: u8 = 3;
+
+/// Jones polynomial used by the CRC64 of Redis, in its reflected form
+const CRC64_POLYNOMIAL: u64 = 0x95AC_9329_AC4B_C9B5;
+
+/// Set while a snapshot is being written, so that only one is written at a time
+static SAVE_IN_PROGRESS: AtomicBool = AtomicBool::new(false);
+/// Interval at which saving before a shutdown checks whether the background save it waits for is done
+const SAVE_WAIT_INTERVAL: Duration = Duration::from_millis(10);
+/// Number of changes made to the keyspace since the last snapshot was written
+static CHANGES_SINCE_SAVE: AtomicU64 = AtomicU64::new(0);
+/// Unix time in seconds at which the last snapshot was written, or the keyspace was loaded
+static LAST_SAVE: AtomicU64 = AtomicU64::new(0);
+/// Whether the last background save failed, after which the save points only retry it after a delay
+static LAST_BGSAVE_FAILED: AtomicBool = AtomicBool::new(false);
+/// Interval at which the save points are checked
+const SAVE_POINTS_INTERVAL: Duration = Duration::from_millis(100);
+/// Delay after which a failed background save is retried by the save points (same as Redis)
+const BGSAVE_RETRY_DELAY: Duration = Duration::from_secs(5);
+
+/// Errors while reading an RDB file
+#[derive(Debug, Error)]
+pub enum RdbError {
+    /// The file doesn't start with the magic string
+    #[error("not an RDB file")]
+    BadMagic,
+    /// The file is written in a version of the format which isn't understood
+    #[error("unsupported RDB version {0}")]
+    UnsupportedVersion(u32),
+    /// The file ended in the middle of an item
+    #[error("unexpected end of the RDB file")]
+    UnexpectedEof,
+    /// A value is of a type which isn't supported
+    #[error("unsupported value type {0} in the RDB file")]
+    UnsupportedType(u8),
+    /// The checksum at the end doesn't match the contents
+    #[error("wrong checksum of the RDB file")]
+    ChecksumMismatch,
+    /// Keys are in a database beyond the number of databases of the server
+    #[error("the RDB file has keys in database {0}, beyond the configured number of databases")]
+    DatabaseOutOfRange(usize),
+    /// The contents don't follow the format
+    #[error("malformed RDB file: {0}")]
+    Malformed(&'static str),
+    /// The file could not be read
+    #[error(transparent)]
+    Io(#[from] io::Error),
+}
+
+/// CRC64 of the data as computed by Redis
+fn crc64(data: &[u8]) -> u64 {
+    data.iter().fold(0, |crc, &byte| {
+        (0..8).fold(crc ^ u64::from(byte), |crc, _| {
+            if crc & 1 == 1 {
+                (crc >> 1) ^ CRC64_POLYNOMIAL
+            } else {
+                crc >> 1
+            }
+        })
+    })
+}
+
+/// Decompress data compressed with LZF into exactly the given number of bytes
+/// Every control byte starts either a run of literal bytes or a back reference into the output.
+fn lzf_decompress(compressed: &[u8], decompressed_len: usize) -> Result<Vec<u8>, RdbError> {
+    let mut decompressed = Vec::with_capacity(decompressed_len.min(compressed.len() * 64));
+    let mut input = compressed.iter().copied();
+    let corrupt = || RdbError::Malformed("corrupt LZF compressed string");
+
+    while let Some(control) = input.next() {
+        if control < 0x20 {
+            // Literal run of 1 to 32 bytes
+            for _ in 0..=control {
+                decompressed.push(input.next().ok_or_else(corrupt)?);
+            }
+        } else {
+            // Back reference of 3 or more bytes; the 3 most significant bits give the length
+            let mut len = usize::from(control >> 5);
+            if len == 7 {
+                len += usize::from(input.next().ok_or_else(corrupt)?);
+            }
+            len += 2;
+            let distance = (usize::from(control & 0x1F) << 8)
+                + usize::from(input.next().ok_or_else(corrupt)?)
+                + 1;
+            let start = decompressed
+                .len()
+                .checked_sub(distance)
+                .ok_or_else(corrupt)?;
+            // The reference may overlap with the bytes being written, so they are copied one at a time
+            for index in start..start + len {
+                decompressed.push(decompressed[index]);
+            }
+        }
+        if decompressed.len() > decompressed_len {
+            return Err(corrupt());
+        }
+    }
+
+    if decompressed.len() == decompressed_len {
+        Ok(decompressed)
+    } else {
+        Err(corrupt())
+    }
+}
+
+/// Write a length; the 2 most significant bits of the first byte tell how many bytes it takes
+fn write_length(out: &mut Vec<u8>, len: usize) {
+    write_length_u64(out, u64::try_from(len).unwrap());
+}
+
+/// Write a number in the format of a length, e.g. a part of a stream ID
+fn write_length_u64(out: &mut Vec<u8>, len: u64) {
+    match len {
+        0..0x40 => out.push(u8::try_from(len).unwrap()),
+        0x40..0x4000 => {
+            out.extend_from_slice(&(0x4000 | u16::try_from(len).unwrap()).to_be_bytes());
+        }
+        _ => {
+            if let Ok(len) = u32::try_from(len) {
+                out.push(0x80);
+                out.extend_from_slice(&len.to_be_bytes());
+            } else {
+                out.push(0x81);
+                out.extend_from_slice(&len.to_be_bytes());
+            }
+        }
+    }
+}
+
+/// Write a length-prefixed string
+fn write_string(out: &mut Vec<u8>, string: &[u8]) {
+    write_length(out, string.len());
+    out.extend_from_slice(string);
+}
+
+/// Type of the value as written before its key
+const fn value_type(value: &RedisType) -> u8 {
+    match *value {
+        RedisType::Val(_) => TYPE_STRING,
+        RedisType::List(_) => TYPE_LIST,
+        RedisType::Set(_) => TYPE_SET,
+        RedisType::Hash(_) => TYPE_HASH,
+        RedisType::SortedSet(_) => TYPE_ZSET_2,
+        RedisType::Stream(_) => TYPE_STREAM_LISTPACKS_3,
+    }
+}
+
+/// Write the value alone, without its type
+fn write_value(out: &mut Vec<u8>, value: &RedisType) {
+    match *value {
+        RedisType::Val(ref val) => write_string(out, val),
+        RedisType::List(ref list) => {
+            write_length(out, list.len());
+            for element in list {
+                write_string(out, element);
+            }
+        }
+        RedisType::Set(ref set) => {
+            write_length(out, set.len());
+            for member in set {
+                write_string(out, member);
+            }
+        }
+        RedisType::Hash(ref hash) => {
+            write_length(out, hash.len());
+            for (field, val) in hash {
+                write_string(out, field);
+                write_string(out, val);
+            }
+        }
+        RedisType::SortedSet(ref sorted_set) => {
+            write_length(out, sorted_set.len());
+            for pair in sorted_set.iter() {
+                write_string(out, &pair.1);
+                out.extend_from_slice(&pair.0.to_le_bytes());
+            }
+        }
+        RedisType::Stream(ref stream) => write_stream(out, stream),
+    }
+}
+
+/// Write a stream ID in 16 bytes, as in a key of the radix tree of a stream or in a pending entries list
+fn stream_node_key(id: StreamId) -> Vec<u8> {
+    let mut key = id.ms.to_be_bytes().to_vec();
+    key.extend_from_slice(&id.seq.to_be_bytes());
+    key
+}
+
+/// Elements of the listpack of a stream holding the entries, which must not be empty
+/// The listpack starts with a master entry made of the number of entries, the number of deleted ones and the fields
+/// of the first entry. Every entry is then its flags, its ID relative to the first one, its fields unless they are
+/// the same as the master ones, its values and finally its number of elements.
+fn stream_node_elements(entries: &[(&StreamId, &Fields)]) -> Vec<Element> {
+    let (&master_id, master_fields) = entries[0];
+    let count = |len: usize| Element::Int(i64::try_from(len).unwrap());
+    let mut elements = vec![
+        count(entries.len()),
+        Element::Int(0),
+        count(master_fields.len()),
+    ];
+    elements.extend(
+        master_fields
+            .iter()
+            .map(|pair| Element::Str(pair.0.clone())),
+    );
+    elements.push(Element::Int(0));
+
+    for &(&id, fields) in entries {
+        let is_same_fields = fields.len() == master_fields.len()
+            && fields
+                .iter()
+                .zip(master_fields)
+                .all(|(pair, master_pair)| pair.0 == master_pair.0);
+        let flags = if is_same_fields {
+            STREAM_ITEM_FLAG_SAMEFIELDS
+        } else {
+            0
+        };
+        // The differences wrap around, as Redis adds them back to the master ID with unsigned arithmetic
+        elements.extend([
+            Element::Int(flags),
+            Element::Int(id.ms.wrapping_sub(master_id.ms).cast_signed()),
+            Element::Int(id.seq.wrapping_sub(master_id.seq).cast_signed()),
+        ]);
+        if is_same_fields {
+            elements.extend(fields.iter().map(|pair| Element::Str(pair.1.clone())));
+            elements.push(count(fields.len() + 3));
+        } else {
+            elements.push(count(fields.len()));
+            elements.extend(
+                fields
+                    .iter()
+                    .flat_map(|pair| [Element::Str(pair.0.clone()), Element::Str(pair.1.clone())]),
+            );
+            elements.push(count(2 * fields.len() + 4));
+        }
+    }
+    elements
+}
+
+/// Write a stream as listpacks of its entries along with its metadata, in the format of `TYPE_STREAM_LISTPACKS_3`
+fn write_stream(out: &mut Vec<u8>, stream: &Stream) {
+    let entries: Vec<_> = stream.iter().collect();
+    let nodes = entries.chunks(STREAM_NODE_MAX_ENTRIES);
+    write_length(out, nodes.len());
+    for node in nodes {
+        write_string(out, &stream_node_key(*node[0].0));
+        write_string(out, &listpack::encode(&stream_node_elements(node)));
+    }
+
+    write_length(out, stream.len());
+    let last_id = stream.last_id();
+    let first_id = entries.first().map_or(StreamId::MIN, |&(&id, _)| id);
+    for id in [last_id, first_id, stream.max_deleted_id()] {
+        write_length_u64(out, id.ms);
+        write_length_u64(out, id.seq);
+    }
+    write_length_u64(out, stream.entries_added());
+
+    write_length(out, stream.groups().len());
+    for (name, group) in stream.groups() {
+        write_group(out, name, group);
+    }
+}
+
+/// Write a consumer group of a stream: its name, the ID of its last delivered entry, its pending entries and then
+/// its consumers along with the IDs of their pending entries
+fn write_group(out: &mut Vec<u8>, name: &[u8], group: &ConsumerGroup) {
+    write_string(out, name);
+    let last_delivered_id = group.last_delivered_id();
+    write_length_u64(out, last_delivered_id.ms);
+    write_length_u64(out, last_delivered_id.seq);
+    write_length_u64(out, UNKNOWN_ENTRIES_READ);
+
+    write_length(out, group.pending().len());
+    for (&id, pending_entry) in group.pending() {
+        out.extend_from_slice(&stream_node_key(id));
+        out.extend_from_slice(&pending_entry.delivery_time_ms.to_le_bytes());
+        write_length_u64(out, pending_entry.delivery_count);
+    }
+
+    write_length(out, group.consumers().len());
+    for (consumer_name, consumer) in group.consumers() {
+        write_string(out, consumer_name);
+        out.extend_from_slice(&consumer.seen_time_ms.to_le_bytes());
+        out.extend_from_slice(
+            &consumer
+                .active_time_ms
+                .unwrap_or(NEVER_ACTIVE)
+                .to_le_bytes(),
+        );
+        write_length(out, consumer.pending.len());
+        for &id in &consumer.pending {
+            out.extend_from_slice(&stream_node_key(id));
+        }
+    }
+}
+
+/// Write the type of the value, the key and then the value
+fn write_entry(out: &mut Vec<u8>, key: &[u8], value: &RedisType) {
+    out.push(value_type(value));
+    write_string(out, key);
+    write_value(out, value);
+}
+
+/// Number of bytes taken by the value in an RDB file, as reported by DEBUG OBJECT
+pub fn serialized_len(value: &RedisType) -> usize {
+    let mut out = Vec::new();
+    write_value(&mut out, value);
+    out.len()
+}
+
+/// Serialize the value as DUMP does: its type and the value as in an RDB file, followed by the RDB version in 2
+/// bytes and the CRC64 of it all
+pub fn dump(value: &RedisType) -> Vec<u8> {
+    let mut out = vec![value_type(value)];
+    write_value(&mut out, value);
+    out.extend_from_slice(&u16::try_from(RDB_VERSION).unwrap().to_le_bytes());
+    let checksum = crc64(&out);
+    out.extend_from_slice(&checksum.to_le_bytes());
+    out
+}
+
+/// Deserialize a value serialized by DUMP, which must be of a known RDB version and match its checksum
+pub fn restore(payload: &[u8]) -> Result<RedisType, RdbError> {
+    let contents_len = payload
+        .len()
+        .checked_sub(8)
+        .ok_or(RdbError::UnexpectedEof)?;
+    let (contents, checksum) = payload.split_at(contents_len);
+    if u64::from_le_bytes(checksum.try_into().unwrap()) != crc64(contents) {
+        return Err(RdbError::ChecksumMismatch);
+    }
+    let value_len = contents_len.checked_sub(2).ok_or(RdbError::UnexpectedEof)?;
+    let (value, version) = contents.split_at(value_len);
+    let version = u32::from(u16::from_le_bytes(version.try_into().unwrap()));
+    if version > MAX_RDB_VERSION {
+        return Err(RdbError::UnsupportedVersion(version));
+    }
+
+    let mut reader = RdbReader {
+        bytes: value,
+        position: 0,
+    };
+    let value_type = reader.read_u8()?;
+    let value = reader.read_value(value_type)?;
+    if reader.position != value_len {
+        return Err(RdbError::Malformed("trailing bytes after the value"));
+    }
+    Ok(value)
+}
+
+/// Serialize the entries of every database, by database index, into the contents of an RDB file
+pub fn encode(databases: &[Vec<Entry>]) -> Vec<u8> {
+    let mut out = Vec::new();
+    out.extend_from_slice(MAGIC);
+    out.extend_from_slice(format!("{RDB_VERSION:04}").as_bytes());
+
+    let ctime = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default()
+        .as_secs()
+        .to_string();
+    for (name, value) in [
+        ("redis-ver", REDIS_VERSION),
+        ("redis-bits", "64"),
+        ("ctime", &ctime),
+    ] {
+        out.push(OPCODE_AUX);
+        write_string(&mut out, name.as_bytes());
+        write_string(&mut out, value.as_bytes());
+    }
+
+    for (db, entries) in databases.iter().enumerate() {
+        // Like Redis, an empty database is left out altogether
+        if entries.is_empty() {
+            continue;
+        }
+        out.push(OPCODE_SELECTDB);
+        write_length(&mut out, db);
+        out.push(OPCODE_RESIZEDB);
+        write_length(&mut out, entries.len());
+        write_length(
+            &mut out,
+            entries
+                .iter()
+                .filter(|&&(_, _, expires_at)| expires_at.is_some())
+                .count(),
+        );
+        write_entries(&mut out, entries);
+    }
+
+    out.push(OPCODE_EOF);
+    let checksum = crc64(&out);
+    out.extend_from_slice(&checksum.to_le_bytes());
+    out
+}
+
+/// Serialize the entries of a database, each with its expiry time if it has one
+fn write_entries(out: &mut Vec<u8>, entries: &[Entry]) {
+    for &(ref key, ref value, expires_at) in entries {
+        if let Some(expires_at) = expires_at {
+            let expires_at_ms = expires_at
+                .duration_since(UNIX_EPOCH)
+                .unwrap_or_default()
+                .as_millis();
+            out.push(OPCODE_EXPIRETIME_MS);
+            out.extend_from_slice(
+                &u64::try_from(expires_at_ms)
+                    .unwrap_or(u64::MAX)
+                    .to_le_bytes(),
+            );
+        }
+        write_entry(out, key, value);
+    }
+}
+
+/// What comes in place of a length: either the length itself, or the special encoding of the string which follows
+enum LengthOrEncoding {
+    /// Plain length
+    Length(u64),
+    /// Special encoding of a string
+    Encoding(u8),
+}
+
+/// Cursor over the contents of an RDB file
+struct RdbReader<'a> {
+    /// Contents of the file
+    bytes: &'a [u8],
+    /// Index of the next byte to be read
+    position: usize,
+}
+
+impl<'a> RdbReader<'a> {
+    /// Read the given number of bytes
+    fn read_bytes(&mut self, len: usize) -> Result<&'a [u8], RdbError> {
+        let end = self
+            .position
+            .checked_add(len)
+            .ok_or(RdbError::UnexpectedEof)?;
+        let bytes = self
+            .bytes
+            .get(self.position..end)
+            .ok_or(RdbError::UnexpectedEof)?;
+        self.position = end;
+        Ok(bytes)
+    }
+
+    /// Read a fixed number of bytes
+    fn read_array<const N: usize>(&mut self) -> Result<[u8; N], RdbError> {
+        Ok(self.read_bytes(N)?.try_into().unwrap())
+    }
+
+    /// Read a single byte
+    fn read_u8(&mut self) -> Result<u8, RdbError> {
+        Ok(self.read_bytes(1)?[0])
+    }
+
+    /// Read a length written by `write_length`, or the special encoding of a string in its place
+    /// The special encodings have both of the most significant bits set, with the encoding in the rest.
+    fn read_length_or_encoding(&mut self) -> Result<LengthOrEncoding, RdbError> {
+        let first_byte = self.read_u8()?;
+        let len = match first_byte {
+            0x00..0x40 => u64::from(first_byte),
+            0x40..0x80 => u64::from(u16::from_be_bytes([first_byte & 0x3F, self.read_u8()?])),
+            0x80 => u64::from(u32::from_be_bytes(self.read_array()?)),
+            0x81 => u64::from_be_bytes(self.read_array()?),
+            0xC0..=0xFF => return Ok(LengthOrEncoding::Encoding(first_byte & 0x3F)),
+            _ => return Err(RdbError::Malformed("invalid length encoding")),
+        };
+        Ok(LengthOrEncoding::Length(len))
+    }
+
+    /// Read a number written by `write_length_u64`
+    fn read_length_u64(&mut self) -> Result<u64, RdbError> {
+        match self.read_length_or_encoding()? {
+            LengthOrEncoding::Length(len) => Ok(len),
+            LengthOrEncoding::Encoding(_) => Err(RdbError::Malformed(
+                "expected a length, got a string encoding",
+            )),
+        }
+    }
+
+    /// Read a length written by `write_length`
+    fn read_length(&mut self) -> Result<usize, RdbError> {
+        usize::try_from(self.read_length_u64()?)
+            .map_err(|_| RdbError::Malformed("length out of range"))
+    }
+
+    /// Read a stream ID written as two numbers
+    fn read_stream_id(&mut self) -> Result<StreamId, RdbError> {
+        Ok(StreamId {
+            ms: self.read_length_u64()?,
+            seq: self.read_length_u64()?,
+        })
+    }
+
+    /// Read a stream ID written in 16 bytes, as in a key of the radix tree of a stream
+    fn read_raw_stream_id(&mut self) -> Result<StreamId, RdbError> {
+        let key: [u8; 16] = self.read_array()?;
+        Ok(StreamId {
+            ms: u64::from_be_bytes(key[..8].try_into().unwrap()),
+            seq: u64::from_be_bytes(key[8..].try_into().unwrap()),
+        })
+    }
+
+    /// Read a consumer group of a stream of the type
+    fn read_group(&mut self, value_type: u8) -> Result<(Vec<u8>, ConsumerGroup), RdbError> {
+        let name = self.read_string()?;
+        let last_delivered_id = self.read_stream_id()?;
+        if value_type >= TYPE_STREAM_LISTPACKS_2 {
+            // The number of entries read is worked out again when needed
+            self.read_length_u64()?;
+        }
+
+        let mut pending = BTreeMap::new();
+        for _ in 0..self.read_length()? {
+            let id = self.read_raw_stream_id()?;
+            let delivery_time_ms = u64::from_le_bytes(self.read_array()?);
+            let delivery_count = self.read_length_u64()?;
+            let pending_entry = PendingEntry {
+                // Filled in from the consumers
+                consumer: Vec::new(),
+                delivery_time_ms,
+                delivery_count,
+            };
+            if pending.insert(id, pending_entry).is_some() {
+                return Err(MALFORMED_GROUP);
+            }
+        }
+
+        let mut consumers = BTreeMap::new();
+        for _ in 0..self.read_length()? {
+            let consumer_name = self.read_string()?;
+            let seen_time_ms = u64::from_le_bytes(self.read_array()?);
+            // Before Redis 7.2, a consumer was last active when it was last seen
+            let active_time_ms = if value_type >= TYPE_STREAM_LISTPACKS_3 {
+                u64::from_le_bytes(self.read_array()?)
+            } else {
+                seen_time_ms
+            };
+            let mut consumer = Consumer {
+                seen_time_ms,
+                active_time_ms: (active_time_ms != NEVER_ACTIVE).then_some(active_time_ms),
+                pending: BTreeSet::new(),
+            };
+            for _ in 0..self.read_length()? {
+                let id = self.read_raw_stream_id()?;
+                // Every pending entry belongs to exactly one consumer
+                let pending_entry = pending
+                    .get_mut(&id)
+                    .filter(|pending_entry| pending_entry.consumer.is_empty())
+                    .ok_or(MALFORMED_GROUP)?;
+                pending_entry.consumer.clone_from(&consumer_name);
+                consumer.pending.insert(id);
+            }
+            consumers.insert(consumer_name, consumer);
+        }
+        if pending
+            .values()
+            .any(|pending_entry| pending_entry.consumer.is_empty())
+        {
+            return Err(MALFORMED_GROUP);
+        }
+        Ok((
+            name,
+            ConsumerGroup::from_parts(last_delivered_id, pending, consumers),
+        ))
+    }
+
+    /// Read a stream of one of the `TYPE_STREAM_LISTPACKS` types
+    fn read_stream(&mut self, value_type: u8) -> Result<Stream, RdbError> {
+        let mut entries = BTreeMap::new();
+        let nodes = self.read_length()?;
+        for _ in 0..nodes {
+            let key = self.read_string()?;
+            let master_id = <[u8; 16]>::try_from(key.as_slice())
+                .map(|key| StreamId {
+                    ms: u64::from_be_bytes(key[..8].try_into().unwrap()),
+                    seq: u64::from_be_bytes(key[8..].try_into().unwrap()),
+                })
+                .map_err(|_| MALFORMED_STREAM)?;
+            let elements = listpack::decode(&self.read_string()?).ok_or(MALFORMED_STREAM)?;
+            read_stream_node(master_id, elements, &mut entries).ok_or(MALFORMED_STREAM)?;
+        }
+
+        let len = self.read_length()?;
+        let last_id = self.read_stream_id()?;
+        let (entries_added, max_deleted_id) = if value_type >= TYPE_STREAM_LISTPACKS_2 {
+            // The first ID follows from the entries
+            self.read_stream_id()?;
+            let max_deleted_id = self.read_stream_id()?;
+            (self.read_length_u64()?, max_deleted_id)
+        } else {
+            (u64::try_from(len).unwrap(), StreamId::MIN)
+        };
+        if len != entries.len() {
+            return Err(MALFORMED_STREAM);
+        }
+        let mut groups = BTreeMap::new();
+        for _ in 0..self.read_length()? {
+            let (name, group) = self.read_group(value_type)?;
+            groups.insert(name, group);
+        }
+        Ok(Stream::from_parts(
+            entries,
+            last_id,
+            entries_added,
+            max_deleted_id,
+            groups,
+        ))
+    }
+
+    /// Read a string, which is either length-prefixed or in one of the special encodings
+    fn read_string(&mut self) -> Result<Vec<u8>, RdbError> {
+        let encoding = match self.read_length_or_encoding()? {
+            LengthOrEncoding::Length(len) => {
+                let len =
+                    usize::try_from(len).map_err(|_| RdbError::Malformed("length out of range"))?;
+                return Ok(self.read_bytes(len)?.to_vec());
+            }
+            LengthOrEncoding::Encoding(encoding) => encoding,
+        };
+        // Integers are stored in little endian and turned back into their decimal representation
+        let string = match encoding {
+            ENCODING_INT8 => i8::from_le_bytes(self.read_array()?)
+                .to_string()
+                .into_bytes(),
+            ENCODING_INT16 => i16::from_le_bytes(self.read_array()?)
+                .to_string()
+                .into_bytes(),
+            ENCODING_INT32 => i32::from_le_bytes(self.read_array()?)
+                .to_string()
+                .into_bytes(),
+            ENCODING_LZF => {
+                let compressed_len = self.read_length()?;
+                let decompressed_len = self.read_length()?;
+                lzf_decompress(self.read_bytes(compressed_len)?, decompressed_len)?
+            }
+            _ => return Err(RdbError::Malformed("invalid string encoding")),
+        };
+        Ok(string)
+    }
+
+    /// Read a value of the given type
+    fn read_value(&mut self, value_type: u8) -> Result<RedisType, RdbError> {
+        match value_type {
+            TYPE_STRING => Ok(RedisType::Val(self.read_string()?)),
+            TYPE_LIST => {
+                let len = self.read_length()?;
+                let list = (0..len)
+                    .map(|_| self.read_string())
+                    .collect::<Result<VecDeque<_>, _>>()?;
+                Ok(RedisType::List(list))
+            }
+            TYPE_SET => {
+                let len = self.read_length()?;
+                let set = (0..len)
+                    .map(|_| self.read_string())
+                    .collect::<Result<HashSet<_>, _>>()?;
+                Ok(RedisType::Set(set))
+            }
+            TYPE_HASH => {
+                let len = self.read_length()?;
+                let hash = (0..len)
+                    .map(|_| Ok((self.read_string()?, self.read_string()?)))
+                    .collect::<Result<HashMap<_, _>, RdbError>>()?;
+                Ok(RedisType::Hash(hash))
+            }
+            TYPE_ZSET_2 => {
+                let len = self.read_length()?;
+                let mut sorted_set = SortedSet::default();
+                for _ in 0..len {
+                    let member = self.read_string()?;
+                    let score = f64::from_le_bytes(self.read_array()?);
+                    if score.is_nan() {
+                        return Err(RdbError::Malformed("score is not a number"));
+                    }
+                    sorted_set.insert(member, score);
+                }
+                Ok(RedisType::SortedSet(sorted_set))
+            }
+            TYPE_STREAM_LISTPACKS | TYPE_STREAM_LISTPACKS_2 | TYPE_STREAM_LISTPACKS_3 => {
+                Ok(RedisType::Stream(self.read_stream(value_type)?))
+            }
+            _ => Err(RdbError::UnsupportedType(value_type)),
+        }
+    }
+}
+
+/// Read the entries of the elements of a stream listpack written by `stream_node_elements`, whose IDs are relative
+/// to the master ID; None if they are malformed
+/// Entries flagged as deleted are skipped.
+fn read_stream_node(
+    master_id: StreamId,
+    elements: Vec<Element>,
+    entries: &mut BTreeMap<StreamId, Fields>,
+) -> Option<()> {
+    let mut elements = elements.into_iter();
+    let mut next_int = || elements.next()?.as_int();
+    let count = next_int()?.checked_add(next_int()?)?;
+    let num_fields = usize::try_from(next_int()?).ok()?;
+    let master_fields = (0..num_fields)
+        .map(|_| elements.next().map(Element::into_bytes))
+        .collect::<Option<Vec<_>>>()?;
+    // The master entry ends with a 0
+    (elements.next()?.as_int()? == 0).then_some(())?;
+
+    for _ in 0..count {
+        let mut next_int = || elements.next()?.as_int();
+        let flags = next_int()?;
+        let id = StreamId {
+            ms: master_id.ms.wrapping_add_signed(next_int()?),
+            seq: master_id.seq.wrapping_add_signed(next_int()?),
+        };
+        let fields = if flags & STREAM_ITEM_FLAG_SAMEFIELDS == 0 {
+            let len = usize::try_from(elements.next()?.as_int()?).ok()?;
+            (0..len)
+                .map(|_| Some((elements.next()?.into_bytes(), elements.next()?.into_bytes())))
+                .collect::<Option<Fields>>()?
+        } else {
+            master_fields
+                .iter()
+                .map(|field| Some((field.clone(), elements.next()?.into_bytes())))
+                .collect::<Option<Fields>>()?
+        };
+        // The number of elements of the entry, only needed to walk the listpack backwards
+        elements.next()?;
+        if flags & STREAM_ITEM_FLAG_DELETED == 0 {
+            entries.insert(id, fields);
+        }
+    }
+    elements.next().is_none().then_some(())
+}
+
+/// Deserialize the contents of an RDB file into its entries, each along with the index of its database
+pub fn decode(bytes: &[u8]) -> Result<Vec<(usize, Entry)>, RdbError> {
+    if !bytes.starts_with(MAGIC) {
+        return Err(RdbError::BadMagic);
+    }
+    let mut reader = RdbReader {
+        bytes,
+        position: MAGIC.len(),
+    };
+    let version = str::from_utf8(reader.read_bytes(4)?)
+        .ok()
+        .and_then(|version| version.parse::<u32>().ok())
+        .ok_or(RdbError::Malformed("invalid version"))?;
+    if !(1..=MAX_RDB_VERSION).contains(&version) {
+        return Err(RdbError::UnsupportedVersion(version));
+    }
+
+    let mut entries = Vec::new();
+    // Database of the following keys, which is the first one until selected otherwise
+    let mut db = 0;
+    // Expiry time of the next key, if it has one
+    let mut expires_at = None;
+    loop {
+        match reader.read_u8()? {
+            OPCODE_AUX => {
+                reader.read_string()?;
+                reader.read_string()?;
+            }
+            OPCODE_SELECTDB => db = reader.read_length()?,
+            // The eviction metadata of the keys isn't tracked
+            OPCODE_IDLE => {
+                reader.read_length()?;
+            }
+            OPCODE_FREQ => {
+                reader.read_u8()?;
+            }
+            OPCODE_RESIZEDB => {
+                reader.read_length()?;
+                reader.read_length()?;
+            }
+            OPCODE_EXPIRETIME_MS => {
+                let expires_at_ms = u64::from_le_bytes(reader.read_array()?);
+                expires_at = UNIX_EPOCH.checked_add(Duration::from_millis(expires_at_ms));
+            }
+            OPCODE_EXPIRETIME => {
+                let expires_at_s = u32::from_le_bytes(reader.read_array()?);
+                expires_at = UNIX_EPOCH.checked_add(Duration::from_secs(expires_at_s.into()));
+            }
+            OPCODE_EOF => break,
+            value_type => {
+                let key = reader.read_string()?;
+                let value = reader.read_value(value_type)?;
+                entries.push((db, (key, value, expires_at.take())));
+            }
+        }
+    }
+
+    // The checksum was added in version 5; it is 0 if it was disabled while saving
+    if version >= 5 {
+        let contents_len = reader.position;
+        let checksum = u64::from_le_bytes(reader.read_array()?);
+        if checksum != 0 && checksum != crc64(&bytes[..contents_len]) {
+            return Err(RdbError::ChecksumMismatch);
+        }
+    }
+    Ok(entries)
+}
+
+/// Count changes made to the keyspace, which the save points compare to their number of changes
+pub fn record_changes(changes: u64) {
+    CHANGES_SINCE_SAVE.fetch_add(changes, Ordering::AcqRel);
+}
+
+/// Consider the keyspace just loaded to be saved, so that the save points start from it
+pub fn mark_loaded() {
+    CHANGES_SINCE_SAVE.store(0, Ordering::Release);
+    LAST_SAVE.store(unix_time_s(), Ordering::Release);
+}
+
+/// LASTSAVE: Unix time in seconds at which the last snapshot was written, or the server started
+pub fn last_save() -> u64 {
+    LAST_SAVE.load(Ordering::Acquire)
+}
+
+/// Seconds elapsed since the Unix epoch
+fn unix_time_s() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |elapsed| elapsed.as_secs())
+}
+
+/// Take a snapshot of all the databases, along with the number of changes which it includes
+fn snapshot(databases: &[Arc<Mutex<KeyValStore>>]) -> (Vec<Vec<Entry>>, u64) {
+    // Counted first, as the changes made while the snapshot is taken may not be in it
+    let changes = CHANGES_SINCE_SAVE.load(Ordering::Acquire);
+    (store::snapshot_databases(databases), changes)
+}
+
+/// Write the entries of every database to the RDB file; once it is written, the changes which the snapshot includes
+/// are saved
+/// They are written to a temporary file which then replaces the RDB file, so that it is never partially written.
+fn write(path: &Path, databases: &[Vec<Entry>], changes: u64) -> io::Result<()> {
+    let temp_path = path.with_file_name(format!("temp-{}.rdb", process::id()));
+    fs::write(&temp_path, encode(databases))?;
+    fs::rename(&temp_path, path)?;
+    CHANGES_SINCE_SAVE.fetch_sub(changes, Ordering::AcqRel);
+    LAST_SAVE.store(unix_time_s(), Ordering::Release);
+    Ok(())
+}
+
+/// SAVE: write a snapshot of all the databases to the RDB file, waiting until it is written
+pub fn save(databases: &[Arc<Mutex<KeyValStore>>], path: &Path) -> Result<(), &'static str> {
+    if SAVE_IN_PROGRESS.swap(true, Ordering::AcqRel) {
+        return Err("ERR Background save already in progress");
+    }
+    let (snapshot, changes) = snapshot(databases);
+    let result = write(path, &snapshot, changes);
+    SAVE_IN_PROGRESS.store(false, Ordering::Release);
+
+    result.map_err(|err| {
+        eprintln!("error: writing the RDB file failed: {err}");
+        "ERR"
+    })
+}
+
+/// BGSAVE: write a snapshot of all the databases to the RDB file in the background
+/// The snapshot is a copy of the stores taken right away, so later writes don't end up in the file.
+pub fn bgsave(databases: &[Arc<Mutex<KeyValStore>>], path: PathBuf) -> Result<(), &'static str> {
+    if SAVE_IN_PROGRESS.swap(true, Ordering::AcqRel) {
+        return Err("ERR Background save already in progress");
+    }
+    let (snapshot, changes) = snapshot(databases);
+    task::spawn_blocking(move || {
+        let result = write(&path, &snapshot, changes);
+        if let Err(ref err) = result {
+            eprintln!("error: writing the RDB file failed: {err}");
+        }
+        LAST_BGSAVE_FAILED.store(result.is_err(), Ordering::Release);
+        SAVE_IN_PROGRESS.store(false, Ordering::Release);
+    });
+    Ok(())
+}
+
+/// Start a background save whenever one of the save points is reached, until a shutdown is signalled
+/// A save point `(seconds, changes)` is reached once that many changes were made and that many seconds elapsed since
+/// the last snapshot was written. The save points are read every time, so that CONFIG SET applies to them.
+pub async fn save_on_changes(server: Arc<Server>, mut shutdown: watch::Receiver<()>) {
+    let mut interval = time::interval(SAVE_POINTS_INTERVAL);
+    let mut last_try: Option<Instant> = None;
+    loop {
+        tokio::select! {
+            _ = interval.tick() => {}
+            _ = shutdown.changed() => return,
+        }
+
+        let changes = CHANGES_SINCE_SAVE.load(Ordering::Acquire);
+        let elapsed = unix_time_s().saturating_sub(last_save());
+        let config = server.config.read().unwrap();
+        let is_reached = config
+            .save_points
+            .iter()
+            .any(|&(seconds, min_changes)| changes >= min_changes && elapsed >= seconds);
+        let path = config.db_path();
+        drop(config);
+        let is_retry_delayed = LAST_BGSAVE_FAILED.load(Ordering::Acquire)
+            && last_try.is_some_and(|last_try| last_try.elapsed() < BGSAVE_RETRY_DELAY);
+        // A save already in progress is waited for, as the changes it doesn't include are counted still
+        if changes > 0 && is_reached && !is_retry_delayed && bgsave(&server.databases, path).is_ok()
+        {
+            last_try = Some(Instant::now());
+        }
+    }
+}
+
+/// Write a snapshot of all the databases to the RDB file before the server shuts down
+/// A background save in progress is waited for rather than failing, as it would be cut short by the shutdown.
+pub fn save_on_shutdown(databases: &[Arc<Mutex<KeyValStore>>], path: &Path) -> io::Result<()> {
+    while SAVE_IN_PROGRESS.swap(true, Ordering::AcqRel) {
+        thread::sleep(SAVE_WAIT_INTERVAL);
+    }
+    let (snapshot, changes) = snapshot(databases);
+    let result = write(path, &snapshot, changes);
+    SAVE_IN_PROGRESS.store(false, Ordering::Release);
+    result
+}
+
+/// Load the keys of the RDB file into their databases, if the file exists
+/// Keys which have expired in the meantime are skipped.
+pub fn load(path: &Path, databases: &[Arc<Mutex<KeyValStore>>]) -> Result<(), RdbError> {
+    match fs::read(path) {
+        Ok(bytes) => load_snapshot(&bytes, databases),
+        Err(err) if err.kind() == io::ErrorKind::NotFound => Ok(()),
+        Err(err) => Err(err.into()),
+    }
+}
+
+/// Replace all the keys of every database with the keys of the RDB file contents
+/// The databases are left untouched if the contents are invalid; keys which have expired in the meantime are skipped.
+pub fn load_snapshot(bytes: &[u8], databases: &[Arc<Mutex<KeyValStore>>]) -> Result<(), RdbError> {
+    let entries = decode(bytes)?;
+    if let Some(&(db, _)) = entries.iter().find(|&&(db, _)| db >= databases.len()) {
+        return Err(RdbError::DatabaseOutOfRange(db));
+    }
+    let mut stores: Vec<_> = databases
+        .iter()
+        .map(|store| store.lock().unwrap())
+        .collect();
+    replace_keys(&mut stores, entries);
+    drop(stores);
+    Ok(())
+}
+
+/// Replace all the keys of the locked stores with the entries of their databases, skipping the keys which have
+/// expired in the meantime
+fn replace_keys(stores: &mut [MutexGuard<KeyValStore>], entries: Vec<(usize, Entry)>) {
+    for store in &mut *stores {
+        store.clear();
+    }
+    let now = SystemTime::now();
+    for (db, (key, value, expires_at)) in entries {
+        if expires_at.is_none_or(|expires_at| expires_at > now) {
+            stores[db].insert(key, value, expires_at);
+        }
+    }
+}
+
+/// DEBUG RELOAD: write a snapshot of all the databases to the RDB file and replace all their keys with the keys
+/// loaded back from it, which checks that the keys survive the round trip
+/// The stores are locked throughout, so that no write lands between the saving and the loading.
+pub fn reload(databases: &[Arc<Mutex<KeyValStore>>], path: &Path) -> Result<(), &'static str> {
+    if SAVE_IN_PROGRESS.swap(true, Ordering::AcqRel) {
+        return Err("ERR Background save already in progress");
+    }
+    let mut stores: Vec<_> = databases
+        .iter()
+        .map(|store| store.lock().unwrap())
+        .collect();
+    let changes = CHANGES_SINCE_SAVE.load(Ordering::Acquire);
+    let snapshot: Vec<_> = stores.iter().map(|store| store.snapshot()).collect();
+    let result = write(path, &snapshot, changes);
+    SAVE_IN_PROGRESS.store(false, Ordering::Release);
+    if let Err(err) = result {
+        eprintln!("error: writing the RDB file failed: {err}");
+        return Err("ERR Error trying to save the DB");
+    }
+
+    let entries = fs::read(path)
+        .map_err(RdbError::from)
+        .and_then(|bytes| decode(&bytes))
+        .map_err(|err| {
+            eprintln!("error: loading the RDB file failed: {err}");
+            "ERR Error trying to load the RDB dump, check server logs."
+        })?;
+    replace_keys(&mut stores, entries);
+    drop(stores);
+    Ok(())
+}
@@ -0,0 +1,439 @@
+//! Master side of the replication: the replicas connected to this server and the stream propagated to them
+//! A replica sends PSYNC to get a snapshot of the keyspace as an RDB file, after which every write command is
+//! forwarded to it. Replicas acknowledge the offset they processed when asked with `REPLCONF GETACK`, which is what
+//! WAIT relies on; those with the AOF enabled also acknowledge the offset flushed to it, which WAITAOF relies on.
+//! The end of the stream is kept in a backlog once a replica connected, so that a replica which reconnects with
+//! `PSYNC <replid> <offset>` continues the stream from where it left off if that is still in the backlog.
+
+use std::{
+    collections::{HashMap, VecDeque},
+    future, mem,
+    net::IpAddr,
+    str,
+    sync::Mutex,
+    time::Duration,
+};
+
+use rand::Rng as _;
+use tokio::{
+    io::{AsyncRead, AsyncWrite, AsyncWriteExt as _},
+    sync::watch,
+    time::{self, Instant},
+};
+
+use crate::{
+    aof::{self, PropagatedCommand},
+    output::{self, OutputLimit, OutputReceiver, OutputSender},
+    resp::{encode_command, RespReader},
+};
+
+/// Length of the replication ID and of the run ID, in hexadecimal characters
+const ID_LEN: usize = 40;
+/// Default number of bytes of the stream kept in the backlog (same as Redis)
+pub const DEFAULT_BACKLOG_SIZE: usize = 1024 * 1024;
+
+/// A replica connected to this server
+struct Replica {
+    /// Channel to the task writing the stream to the replica
+    sender: OutputSender<Vec<u8>>,
+    /// Offset of the stream last acknowledged by the replica
+    ack_offset: u64,
+    /// Offset of the stream last acknowledged by the replica as flushed to its AOF
+    fsynced_offset: u64,
+    /// When the replica last acknowledged an offset, or else registered
+    acked_at: Instant,
+    /// Address from which the replica connected, if known
+    ip: Option<IpAddr>,
+    /// Port on which the replica listens for clients, as given by `REPLCONF listening-port`
+    port: u16,
+}
+
+/// Random ID of hexadecimal characters, as used for the replication ID and the run ID of the server
+pub fn random_id() -> String {
+    let mut rng = rand::rng();
+    (0..ID_LEN)
+        .map(|_| char::from_digit(rng.random_range(0..16), 16).unwrap())
+        .collect()
+}
+
+/// All the replicas connected to this server along with the position of the stream propagated to them
+pub struct Replicas {
+    /// Replication ID of this server, random for every run
+    replid: String,
+    /// Former replication ID of this server along with the offset, counted from 1, of the first byte propagated
+    /// under the current one, i.e. the end of the stream which the former ID may continue
+    replid2: Option<(String, u64)>,
+    /// Number of bytes propagated so far
+    offset: u64,
+    /// Offset right after the last propagated write; the `REPLCONF GETACK` sent after it don't count
+    write_offset: u64,
+    /// Database which the stream leaves selected, if every replica has it selected
+    selected_db: Option<usize>,
+    /// Links to the replicas by ID
+    links: HashMap<u64, Replica>,
+    /// ID of the next replica
+    next_id: u64,
+    /// Signalled whenever a replica acknowledges an offset
+    acks: watch::Sender<()>,
+    /// Output buffer limit of the replicas
+    output_limit: OutputLimit,
+    /// Last bytes of the stream, up to `backlog_size` of them, once a replica synchronized
+    backlog: Option<VecDeque<u8>>,
+    /// Maximum number of bytes kept in the backlog
+    backlog_size: usize,
+}
+
+/// A connection which asked for a full resynchronization, until its link is served by `serve_replica`
+pub struct NewReplica {
+    /// ID of the replica in `Replicas`
+    id: u64,
+    /// Reply to PSYNC followed by the RDB file of the snapshot
+    resync: Vec<u8>,
+    /// Stream propagated since the snapshot was taken
+    receiver: OutputReceiver<Vec<u8>>,
+}
+
+impl Replicas {
+    /// Start a new replication stream
+    pub fn new() -> Self {
+        Self {
+            replid: random_id(),
+            replid2: None,
+            offset: 0,
+            write_offset: 0,
+            // A replica starts off with the first database selected
+            selected_db: Some(0),
+            links: HashMap::new(),
+            next_id: 0,
+            acks: watch::channel(()).0,
+            output_limit: OutputLimit::default(),
+            backlog: None,
+            backlog_size: DEFAULT_BACKLOG_SIZE,
+        }
+    }
+
+    /// Change the number of bytes kept in the backlog, dropping the oldest ones if there are too many
+    pub fn set_backlog_size(&mut self, backlog_size: usize) {
+        self.backlog_size = backlog_size;
+        self.trim_backlog();
+    }
+
+    /// Drop the oldest bytes of the backlog which don't fit in it anymore
+    fn trim_backlog(&mut self) {
+        if let Some(ref mut backlog) = self.backlog {
+            let excess = backlog.len().saturating_sub(self.backlog_size);
+            backlog.drain(..excess);
+        }
+    }
+
+    /// Go on with the stream under a new replication ID, e.g. for DEBUG `CHANGE-REPL-ID`
+    /// The former ID is kept as the second one, so that the replicas which followed it up to now can continue.
+    pub fn change_replid(&mut self) {
+        let replid = mem::replace(&mut self.replid, random_id());
+        self.replid2 = Some((replid, self.offset + 1));
+    }
+
+    /// Change the output buffer limit of the replicas, which applies from the next bytes of the stream on
+    pub const fn set_output_limit(&mut self, output_limit: OutputLimit) {
+        self.output_limit = output_limit;
+    }
+
+    /// Send bytes to every replica, advancing the offset
+    /// A replica which can't take them is removed: either its link is closed, or it went over the limit and its link
+    /// gets closed.
+    fn send(&mut self, bytes: &[u8]) {
+        self.offset += bytes.len() as u64;
+        if let Some(ref mut backlog) = self.backlog {
+            backlog.extend(bytes);
+            self.trim_backlog();
+        }
+        let limit = self.output_limit;
+        self.links
+            .retain(|_, replica| replica.sender.send(bytes.to_vec(), bytes.len(), limit));
+    }
+
+    /// Propagate the write commands to the replicas
+    pub fn feed(&mut self, commands: &[PropagatedCommand]) {
+        let bytes = aof::encode_propagated(commands, &mut self.selected_db);
+        self.send(&bytes);
+        self.write_offset = self.offset;
+    }
+
+    /// Add the link of a replica which processed the stream up to the given offset, returning its ID along with the
+    /// end of the channel from which the stream is taken for it
+    fn add_link(
+        &mut self,
+        ip: Option<IpAddr>,
+        port: u16,
+        ack_offset: u64,
+    ) -> (u64, OutputReceiver<Vec<u8>>) {
+        let (sender, receiver) = output::channel();
+        let id = self.next_id;
+        self.next_id += 1;
+        self.links.insert(
+            id,
+            Replica {
+                sender,
+                ack_offset,
+                fsynced_offset: 0,
+                acked_at: Instant::now(),
+                ip,
+                port,
+            },
+        );
+        (id, receiver)
+    }
+
+    /// Register a replica which gets the given snapshot, encoded as an RDB file, followed by the stream
+    /// This must be called while no write can be propagated, so that the replica misses none of them.
+    pub fn register(&mut self, rdb: &[u8], ip: Option<IpAddr>, port: u16) -> NewReplica {
+        // The new replica starts off with the first database selected, unlike the others
+        if self.selected_db != Some(0) {
+            self.selected_db = None;
+        }
+        // The stream is kept from the snapshot on, so that the replica can continue it if it reconnects
+        self.backlog.get_or_insert_with(VecDeque::new);
+        let (id, receiver) = self.add_link(ip, port, 0);
+
+        let mut resync = format!(
+            "+FULLRESYNC {} {}\r\n${}\r\n",
+            self.replid,
+            self.offset,
+            rdb.len()
+        )
+        .into_bytes();
+        resync.extend_from_slice(rdb);
+        NewReplica {
+            id,
+            resync,
+            receiver,
+        }
+    }
+
+    /// Register a replica which asked with PSYNC to continue the stream from the given offset, which is that of the
+    /// first byte it misses counted from 1, if the replication ID is that of the stream, or the second one up to where
+    /// it changed, and the bytes from the offset on are still in the backlog; it gets `+CONTINUE` followed by those
+    /// bytes and then the rest of the stream
+    pub fn try_continue(
+        &mut self,
+        replid: &[u8],
+        offset: &[u8],
+        ip: Option<IpAddr>,
+        port: u16,
+    ) -> Option<NewReplica> {
+        let backlog = self.backlog.as_ref()?;
+        let processed = str::from_utf8(offset)
+            .ok()?
+            .parse::<u64>()
+            .ok()?
+            .checked_sub(1)?;
+        let backlog_start = self.offset - backlog.len() as u64;
+        let is_known_replid = replid == self.replid.as_bytes()
+            || self
+                .replid2
+                .as_ref()
+                .is_some_and(|&(ref replid2, second_offset)| {
+                    replid == replid2.as_bytes() && processed < second_offset
+                });
+        if !is_known_replid || !(backlog_start..=self.offset).contains(&processed) {
+            return None;
+        }
+        let missed = usize::try_from(processed - backlog_start).unwrap();
+        let mut resync = format!("+CONTINUE {}\r\n", self.replid).into_bytes();
+        resync.extend(backlog.range(missed..));
+        let (id, receiver) = self.add_link(ip, port, processed);
+        Some(NewReplica {
+            id,
+            resync,
+            receiver,
+        })
+    }
+
+    /// Describe the backlog as INFO does, e.g. `repl_backlog_active:1`, with the offset of its first byte counted
+    /// from 1
+    pub fn describe_backlog(&self) -> Vec<String> {
+        let histlen = self.backlog.as_ref().map_or(0, VecDeque::len);
+        let first_byte_offset = if self.backlog.is_some() {
+            self.offset - histlen as u64 + 1
+        } else {
+            0
+        };
+        vec![
+            format!("repl_backlog_active:{}", u8::from(self.backlog.is_some())),
+            format!("repl_backlog_size:{}", self.backlog_size),
+            format!("repl_backlog_first_byte_offset:{first_byte_offset}"),
+            format!("repl_backlog_histlen:{histlen}"),
+        ]
+    }
+
+    /// Replication ID of the stream
+    pub fn replid(&self) -> &str {
+        &self.replid
+    }
+
+    /// Number of bytes propagated so far
+    pub const fn offset(&self) -> u64 {
+        self.offset
+    }
+
+    /// Second replication ID and the offset where the stream under it ends, as INFO reports them: all zeros and -1
+    /// until the ID changed
+    pub fn replid2(&self) -> (String, String) {
+        self.replid2.as_ref().map_or_else(
+            || ("0".repeat(ID_LEN), "-1".to_owned()),
+            |&(ref replid2, second_offset)| (replid2.clone(), second_offset.to_string()),
+        )
+    }
+
+    /// Describe every connected replica as INFO does, e.g. `ip=127.0.0.1,port=6380,state=online,offset=42,lag=0`
+    /// The lag is the number of seconds since the replica last acknowledged an offset.
+    pub fn describe_links(&self) -> Vec<String> {
+        let mut links: Vec<_> = self.links.iter().collect();
+        links.sort_unstable_by_key(|&(&id, _)| id);
+        links
+            .into_iter()
+            .map(|(_, replica)| {
+                let ip = replica
+                    .ip
+                    .map_or_else(|| "?".to_owned(), |ip| ip.to_string());
+                format!(
+                    "ip={ip},port={},state=online,offset={},lag={}",
+                    replica.port,
+                    replica.ack_offset,
+                    replica.acked_at.elapsed().as_secs()
+                )
+            })
+            .collect()
+    }
+
+    /// Whether no replica is connected
+    pub fn is_empty(&self) -> bool {
+        self.links.is_empty()
+    }
+
+    /// Whether a replica listening on the port is connected from the host, given as an IP address
+    pub fn is_connected(&self, host: &str, port: u16) -> bool {
+        self.links.values().any(|replica| {
+            replica.port == port && replica.ip.is_some_and(|ip| ip.to_string() == host)
+        })
+    }
+
+    /// Offset which the replicas must acknowledge for all the writes propagated so far to be replicated
+    pub const fn write_offset(&self) -> u64 {
+        self.write_offset
+    }
+
+    /// Number of replicas which acknowledged the given offset
+    pub fn acked_count(&self, offset: u64) -> usize {
+        self.links
+            .values()
+            .filter(|replica| replica.ack_offset >= offset)
+            .count()
+    }
+
+    /// Number of replicas which acknowledged the given offset as flushed to their AOF
+    pub fn fsynced_count(&self, offset: u64) -> usize {
+        self.links
+            .values()
+            .filter(|replica| replica.fsynced_offset >= offset)
+            .count()
+    }
+
+    /// Ask every replica to acknowledge its offset
+    pub fn request_acks(&mut self) {
+        self.send(&encode_command(&[
+            b"REPLCONF".to_vec(),
+            b"GETACK".to_vec(),
+            b"*".to_vec(),
+        ]));
+    }
+}
+
+/// Wait until the given number of replicas acknowledged the offset or the timeout elapses, returning the number of
+/// replicas which acknowledged it; `count_acks` is either `Replicas::acked_count` or `Replicas::fsynced_count`
+pub async fn wait_for_acks(
+    replicas: &Mutex<Replicas>,
+    offset: u64,
+    numreplicas: usize,
+    timeout: Option<Duration>,
+    count_acks: fn(&Replicas, u64) -> usize,
+) -> usize {
+    let mut acks = replicas.lock().unwrap().acks.subscribe();
+    let deadline = timeout.map(|timeout| Instant::now() + timeout);
+    loop {
+        let count = count_acks(&replicas.lock().unwrap(), offset);
+        if count >= numreplicas {
+            return count;
+        }
+        let timed_out = async {
+            match deadline {
+                Some(deadline) => time::sleep_until(deadline).await,
+                None => future::pending().await,
+            }
+        };
+        tokio::select! {
+            _ = acks.changed() => {}
+            () = timed_out => return count_acks(&replicas.lock().unwrap(), offset),
+        }
+    }
+}
+
+/// Serve the link of a replica until it disconnects: send the snapshot followed by the stream, and record the
+/// offsets it acknowledges
+pub async fn serve_replica<R, W>(
+    replicas: &Mutex<Replicas>,
+    resp_reader: &mut RespReader<R>,
+    mut writer: W,
+    new_replica: NewReplica,
+) where
+    R: AsyncRead + Unpin,
+    W: AsyncWrite + Unpin + Send + 'static,
+{
+    let NewReplica {
+        id,
+        resync,
+        mut receiver,
+    } = new_replica;
+    // Written by another task, as reading a command can't be cancelled halfway through; the writing stops as soon
+    // as the replica goes over its limit, even if it is stuck on a replica which doesn't read
+    tokio::spawn(async move {
+        writer.write_all(&resync).await?;
+        output::drain(&mut writer, &mut receiver).await
+    });
+
+    // Replicas only send `REPLCONF ACK <offset>`, optionally followed by `FACK <offset flushed to the AOF>`;
+    // anything else is ignored
+    let parse_offset = |offset: &[u8]| -> Option<u64> { str::from_utf8(offset).ok()?.parse().ok() };
+    while let Ok(Some(parsed_command)) = resp_reader.read_command().await {
+        let offsets = match *parsed_command.as_slice() {
+            [ref replconf, ref ack, ref offset]
+                if replconf.eq_ignore_ascii_case(b"replconf")
+                    && ack.eq_ignore_ascii_case(b"ack") =>
+            {
+                parse_offset(offset).map(|offset| (offset, None))
+            }
+            [ref replconf, ref ack, ref offset, ref fack, ref fsynced_offset]
+                if replconf.eq_ignore_ascii_case(b"replconf")
+                    && ack.eq_ignore_ascii_case(b"ack")
+                    && fack.eq_ignore_ascii_case(b"fack") =>
+            {
+                parse_offset(offset).zip(parse_offset(fsynced_offset).map(Some))
+            }
+            _ => None,
+        };
+        if let Some((ack_offset, fsynced_offset)) = offsets {
+            let mut replicas = replicas.lock().unwrap();
+            if let Some(replica) = replicas.links.get_mut(&id) {
+                replica.ack_offset = ack_offset;
+                if let Some(fsynced_offset) = fsynced_offset {
+                    replica.fsynced_offset = fsynced_offset;
+                }
+                replica.acked_at = Instant::now();
+            }
+            replicas.acks.send_replace(());
+        }
+    }
+
+    // Dropping the channel stops the writing task, unless the replica was already removed for going over its limit
+    replicas.lock().unwrap().links.remove(&id);
+}
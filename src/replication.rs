@@ -0,0 +1,271 @@
+//! Replica side of the replication: the server follows a master, first loading a snapshot of its keyspace
+//! and then applying the stream of write commands propagated by it
+//! The handshake is PING, `REPLCONF listening-port`, `REPLCONF capa` and finally PSYNC, which either starts a full
+//! resynchronization (the master sends an RDB file) or continues the stream from where the replica left off.
+
+use std::{io, net::SocketAddr, sync::Arc, time::Duration};
+
+use thiserror::Error;
+use tokio::{
+    io::{AsyncWrite, AsyncWriteExt as _},
+    net::{tcp::OwnedReadHalf, TcpStream},
+    sync::watch,
+    time,
+};
+
+use crate::{
+    aof,
+    clients::ClientAddr,
+    command, dispatch,
+    rdb::{self, RdbError},
+    resp::{Protocol, RespError, RespReader, RespValue},
+    ClientState, Server,
+};
+
+/// Delay before reconnecting to the master once the link to it is broken
+const RECONNECT_DELAY: Duration = Duration::from_secs(1);
+
+/// Errors which may occur on the link to the master
+#[derive(Debug, Error)]
+pub enum ReplicationError {
+    /// The master replied to the handshake with something unexpected
+    #[error("unexpected reply from the master: {0}")]
+    UnexpectedReply(String),
+    /// The master closed the connection
+    #[error("connection closed by the master")]
+    Closed,
+    /// The master sent something which isn't RESP
+    #[error(transparent)]
+    Resp(#[from] RespError),
+    /// The RDB file sent by the master is invalid
+    #[error("invalid RDB file from the master: {0}")]
+    Rdb(#[from] RdbError),
+    /// Error in the connection
+    #[error(transparent)]
+    Io(#[from] io::Error),
+}
+
+/// Position of the replica in the stream of the master, kept across connections so that the stream can be resumed
+#[derive(Default)]
+pub struct ReplicationState {
+    /// Replication ID of the master, once a full resynchronization happened
+    replid: Option<String>,
+    /// Number of bytes of the stream processed so far
+    offset: u64,
+    /// Database selected by the stream, which carries on when the stream is resumed
+    db: usize,
+    /// Whether the replica is synchronized with the master and applying its stream
+    is_link_up: bool,
+}
+
+impl ReplicationState {
+    /// Replication ID of the master, if the replica synchronized with it
+    pub fn replid(&self) -> Option<&str> {
+        self.replid.as_deref()
+    }
+
+    /// Offset of the stream of the master processed so far
+    pub const fn offset(&self) -> u64 {
+        self.offset
+    }
+
+    /// Replication ID and offset which PSYNC asks the master to continue the stream from
+    /// A replica which has never synchronized asks for a full resynchronization.
+    fn psync_position(&self) -> (String, String) {
+        self.replid.as_ref().map_or_else(
+            || ("?".to_owned(), "-1".to_owned()),
+            |replid| (replid.clone(), (self.offset + 1).to_string()),
+        )
+    }
+
+    /// Lines of INFO describing the link to the master
+    pub fn describe(&self) -> Vec<String> {
+        vec![
+            format!(
+                "master_link_status:{}",
+                if self.is_link_up { "up" } else { "down" }
+            ),
+            format!("slave_repl_offset:{}", self.offset),
+        ]
+    }
+}
+
+/// Follow the master at the given address, reconnecting whenever the link breaks, until a shutdown is signalled
+pub async fn follow_master(
+    server: Arc<Server>,
+    host: String,
+    port: u16,
+    mut shutdown: watch::Receiver<()>,
+) {
+    loop {
+        tokio::select! {
+            result = sync_with_master(&server, &host, port) => {
+                if let Err(err) = result {
+                    eprintln!("error: replication from {host}:{port} failed: {err}");
+                }
+            }
+            _ = shutdown.changed() => return,
+        }
+        server.replication.lock().unwrap().is_link_up = false;
+        time::sleep(RECONNECT_DELAY).await;
+    }
+}
+
+/// Send a command to the master
+async fn send_command(
+    writer: &mut (impl AsyncWrite + Unpin),
+    args: &[&[u8]],
+) -> Result<(), ReplicationError> {
+    let command = RespValue::bulk_string_array(args.iter().map(|arg| arg.to_vec()));
+    writer.write_all(&command.encode(Protocol::Resp2)).await?;
+    Ok(())
+}
+
+/// Read a simple string replied by the master during the handshake
+async fn read_simple_reply(
+    resp_reader: &mut RespReader<OwnedReadHalf>,
+) -> Result<String, ReplicationError> {
+    match resp_reader.read_value().await? {
+        RespValue::SimpleString(reply) => Ok(reply),
+        reply => Err(ReplicationError::UnexpectedReply(format!("{reply:?}"))),
+    }
+}
+
+/// Send a command of the handshake and check that the master replies with the expected simple string
+async fn handshake_step(
+    writer: &mut (impl AsyncWrite + Unpin),
+    resp_reader: &mut RespReader<OwnedReadHalf>,
+    args: &[&[u8]],
+    expected_reply: &str,
+) -> Result<(), ReplicationError> {
+    send_command(writer, args).await?;
+    let reply = read_simple_reply(resp_reader).await?;
+    if reply == expected_reply {
+        Ok(())
+    } else {
+        Err(ReplicationError::UnexpectedReply(reply))
+    }
+}
+
+/// Connect to the master, synchronize with it and then apply its stream until the link breaks
+async fn sync_with_master(server: &Server, host: &str, port: u16) -> Result<(), ReplicationError> {
+    let stream = TcpStream::connect((host, port)).await?;
+    let master_addr = stream.peer_addr()?;
+    let (reader, mut writer) = stream.into_split();
+    let mut resp_reader = RespReader::new(reader);
+
+    let listening_port = server.config.read().unwrap().port.to_string();
+    handshake_step(&mut writer, &mut resp_reader, &[b"PING"], "PONG").await?;
+    handshake_step(
+        &mut writer,
+        &mut resp_reader,
+        &[b"REPLCONF", b"listening-port", listening_port.as_bytes()],
+        "OK",
+    )
+    .await?;
+    handshake_step(
+        &mut writer,
+        &mut resp_reader,
+        &[b"REPLCONF", b"capa", b"psync2"],
+        "OK",
+    )
+    .await?;
+
+    let (replid, offset) = server.replication.lock().unwrap().psync_position();
+    send_command(
+        &mut writer,
+        &[b"PSYNC", replid.as_bytes(), offset.as_bytes()],
+    )
+    .await?;
+    let reply = read_simple_reply(&mut resp_reader).await?;
+    let mut reply_args = reply.split_whitespace();
+    match reply_args.next() {
+        Some("FULLRESYNC") => {
+            let (Some(replid), Some(offset)) = (
+                reply_args.next(),
+                reply_args.next().and_then(|offset| offset.parse().ok()),
+            ) else {
+                return Err(ReplicationError::UnexpectedReply(reply));
+            };
+            let rdb = resp_reader.read_rdb_transfer().await?;
+            load_rdb_transfer(server, &rdb)?;
+            let mut state = server.replication.lock().unwrap();
+            state.replid = Some(replid.to_owned());
+            state.offset = offset;
+            state.db = 0;
+            state.is_link_up = true;
+        }
+        // The master may have a new replication ID, e.g. after a failover
+        Some("CONTINUE") => {
+            let mut state = server.replication.lock().unwrap();
+            if let Some(replid) = reply_args.next() {
+                state.replid = Some(replid.to_owned());
+            }
+            state.is_link_up = true;
+        }
+        _ => return Err(ReplicationError::UnexpectedReply(reply)),
+    }
+
+    apply_stream(server, master_addr, &mut resp_reader, &mut writer).await
+}
+
+/// Replace the keyspace with the RDB file sent by the master
+/// The AOF is rewritten from the new keyspace, as the commands logged before don't apply to it anymore.
+fn load_rdb_transfer(server: &Server, rdb: &[u8]) -> Result<(), ReplicationError> {
+    rdb::load_snapshot(rdb, &server.databases)?;
+
+    let config = server.config.read().unwrap().clone();
+    if config.appendonly {
+        if let Err(err) = aof::bgrewriteaof(&server.aof, &server.databases, config.aof_path()) {
+            eprintln!("error: rewriting the AOF after the resynchronization failed: {err}");
+        }
+    }
+    Ok(())
+}
+
+/// Apply the commands propagated by the master, acknowledging the processed offset whenever the master asks for it
+/// Replies to the commands aren't sent back to the master.
+async fn apply_stream(
+    server: &Server,
+    master_addr: SocketAddr,
+    resp_reader: &mut RespReader<OwnedReadHalf>,
+    writer: &mut (impl AsyncWrite + Unpin),
+) -> Result<(), ReplicationError> {
+    let mut master = ClientState::new();
+    master.is_master = true;
+    master.addr = Some(ClientAddr::Tcp(master_addr));
+    master.db = server.replication.lock().unwrap().db;
+
+    loop {
+        let start = resp_reader.position();
+        let parsed_command = resp_reader
+            .read_command()
+            .await?
+            .ok_or(ReplicationError::Closed)?;
+
+        let is_getack = parsed_command.len() == 3
+            && parsed_command[0].eq_ignore_ascii_case(b"replconf")
+            && parsed_command[1].eq_ignore_ascii_case(b"getack");
+        if is_getack {
+            // The acknowledged offset doesn't include the GETACK itself. With the AOF enabled, the commands
+            // applied so far are flushed to it first, so that it is acknowledged for WAITAOF as well.
+            let offset = server.replication.lock().unwrap().offset.to_string();
+            let is_fsynced = aof::flush(&server.aof).await.unwrap_or_else(|err| {
+                eprintln!("error: flushing the AOF failed: {err}");
+                false
+            });
+            let mut ack: Vec<&[u8]> = vec![b"REPLCONF", b"ACK", offset.as_bytes()];
+            if is_fsynced {
+                ack.extend([b"FACK".as_slice(), offset.as_bytes()]);
+            }
+            send_command(writer, &ack).await?;
+        } else {
+            let command = command::validate(&parsed_command);
+            dispatch(parsed_command, command, server, &mut master, false);
+        }
+        let mut state = server.replication.lock().unwrap();
+        state.offset += resp_reader.position() - start;
+        state.db = master.db;
+        drop(state);
+    }
+}
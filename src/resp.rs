@@ -0,0 +1,543 @@
+//! Parsing and encoding of the Redis serialization protocol (RESP)
+
+use std::{future, io, iter, str};
+
+use thiserror::Error;
+use tokio::io::{AsyncBufReadExt as _, AsyncRead, AsyncReadExt as _, BufReader};
+
+use crate::format_redis_float;
+
+/// Maximum length of a line, i.e. of an inline command or of a length prefix (same as Redis)
+const MAX_LINE_LENGTH: usize = 64 * 1024;
+/// Maximum length of a bulk string (same as the default `proto-max-bulk-len` of Redis)
+const MAX_BULK_LENGTH: usize = 512 * 1024 * 1024;
+/// Maximum number of elements in an array
+const MAX_ARRAY_LENGTH: usize = i32::MAX as usize;
+/// Number of array elements for which space is reserved upfront, so that a huge length prefix can't exhaust memory
+const MAX_PREALLOCATED_ELEMENTS: usize = 1024;
+
+/// Version of the protocol spoken with a client; negotiated with the HELLO command
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum Protocol {
+    /// RESP2, which every client speaks by default
+    #[default]
+    Resp2,
+    /// RESP3, which has native types for maps, sets, doubles, etc.
+    Resp3,
+}
+
+/// A single RESP value, which is either read from a client or sent as a reply
+/// The RESP3 types are downgraded to their RESP2 equivalents when encoding for a RESP2 client.
+#[derive(Debug, Clone, PartialEq)]
+pub enum RespValue {
+    /// `+OK\r\n`
+    SimpleString(String),
+    /// `-ERR message\r\n`
+    Error(String),
+    /// `:1000\r\n`
+    Integer(i64),
+    /// `$5\r\nhello\r\n`
+    BulkString(Vec<u8>),
+    /// `*2\r\n...`; the elements can be arrays themselves
+    Array(Vec<RespValue>),
+    /// `$-1\r\n`
+    NullBulkString,
+    /// `*-1\r\n`
+    NullArray,
+    /// `%1\r\n<key><value>`; flattened into an array of keys and values in RESP2
+    Map(Vec<(RespValue, RespValue)>),
+    /// `~2\r\n...`; an array in RESP2
+    Set(Vec<RespValue>),
+    /// `,1.5\r\n`; a bulk string in RESP2
+    Double(f64),
+    /// `>3\r\n...`; out of band data such as Pub/Sub messages, which is an array in RESP2
+    Push(Vec<RespValue>),
+    /// `#t\r\n`; the integer 1 or 0 in RESP2
+    #[expect(dead_code, reason = "Will be replied by the commands returning a flag")]
+    Boolean(bool),
+    /// `(3492890328409238509324850943850943825024385\r\n`; a bulk string in RESP2
+    #[expect(
+        dead_code,
+        reason = "Will be replied by the commands returning arbitrarily large integers"
+    )]
+    BigNumber(String),
+}
+
+impl RespValue {
+    /// Create a simple string reply
+    pub fn simple(val: &str) -> Self {
+        Self::SimpleString(val.to_owned())
+    }
+
+    /// Create an error reply
+    pub fn error(err: &str) -> Self {
+        Self::Error(err.to_owned())
+    }
+
+    /// Create the reply of the HELP subcommand of the container command, given the lines describing its other
+    /// subcommands
+    /// Like in Redis, the lines are simple strings framed by the syntax of the command and the description of HELP.
+    pub fn help(command: &str, lines: &[&str]) -> Self {
+        let syntax = format!("{command} <subcommand> [<arg> [value] [opt] ...]. Subcommands are:");
+        let help = ["HELP", "    Print this help."];
+        Self::Array(
+            iter::once(syntax.as_str())
+                .chain(lines.iter().copied())
+                .chain(help)
+                .map(Self::simple)
+                .collect(),
+        )
+    }
+
+    /// Create an array reply of bulk strings
+    pub fn bulk_string_array(vals: impl IntoIterator<Item = Vec<u8>>) -> Self {
+        Self::Array(vals.into_iter().map(Self::BulkString).collect())
+    }
+
+    /// Serialize the value into the given version of RESP
+    pub fn encode(&self, protocol: Protocol) -> Vec<u8> {
+        let mut output = Vec::new();
+        self.encode_into(&mut output, protocol);
+        output
+    }
+
+    /// Serialize the value into the given version of RESP and append it to the output
+    fn encode_into(&self, output: &mut Vec<u8>, protocol: Protocol) {
+        let is_resp3 = protocol == Protocol::Resp3;
+        match *self {
+            Self::SimpleString(ref val) => {
+                output.extend_from_slice(format!("+{val}\r\n").as_bytes());
+            }
+            Self::Error(ref err) => {
+                // The error may quote what the client sent, whose line breaks would end the line early and inject
+                // replies of their own
+                let err = err.replace(['\r', '\n'], " ");
+                output.extend_from_slice(format!("-{err}\r\n").as_bytes());
+            }
+            Self::Integer(val) => output.extend_from_slice(format!(":{val}\r\n").as_bytes()),
+            Self::BulkString(ref val) => Self::encode_bulk_string(output, val),
+            Self::Array(ref vals) => Self::encode_aggregate(output, '*', vals, protocol),
+            Self::NullBulkString | Self::NullArray if is_resp3 => {
+                output.extend_from_slice(b"_\r\n");
+            }
+            Self::NullBulkString => output.extend_from_slice(b"$-1\r\n"),
+            Self::NullArray => output.extend_from_slice(b"*-1\r\n"),
+            Self::Map(ref pairs) => {
+                // In RESP2, a map is an array of alternating keys and values
+                let (type_byte, len) = if is_resp3 {
+                    ('%', pairs.len())
+                } else {
+                    ('*', 2 * pairs.len())
+                };
+                output.extend_from_slice(format!("{type_byte}{len}\r\n").as_bytes());
+                for pair in pairs {
+                    pair.0.encode_into(output, protocol);
+                    pair.1.encode_into(output, protocol);
+                }
+            }
+            Self::Set(ref vals) => {
+                Self::encode_aggregate(output, if is_resp3 { '~' } else { '*' }, vals, protocol);
+            }
+            Self::Push(ref vals) => {
+                Self::encode_aggregate(output, if is_resp3 { '>' } else { '*' }, vals, protocol);
+            }
+            Self::Double(val) if is_resp3 => {
+                output.extend_from_slice(format!(",{}\r\n", format_redis_float(val)).as_bytes());
+            }
+            Self::Double(val) => {
+                Self::encode_bulk_string(output, format_redis_float(val).as_bytes());
+            }
+            Self::Boolean(val) if is_resp3 => {
+                output.extend_from_slice(if val { b"#t\r\n" } else { b"#f\r\n" });
+            }
+            Self::Boolean(val) => output.extend_from_slice(if val { b":1\r\n" } else { b":0\r\n" }),
+            Self::BigNumber(ref val) if is_resp3 => {
+                output.extend_from_slice(format!("({val}\r\n").as_bytes());
+            }
+            Self::BigNumber(ref val) => Self::encode_bulk_string(output, val.as_bytes()),
+        }
+    }
+
+    /// Append a bulk string to the output
+    fn encode_bulk_string(output: &mut Vec<u8>, val: &[u8]) {
+        output.extend_from_slice(format!("${}\r\n", val.len()).as_bytes());
+        output.extend_from_slice(val);
+        output.extend_from_slice(b"\r\n");
+    }
+
+    /// Append an aggregate type (array, set, etc.) having the given type byte to the output
+    fn encode_aggregate(output: &mut Vec<u8>, type_byte: char, vals: &[Self], protocol: Protocol) {
+        output.extend_from_slice(format!("{type_byte}{}\r\n", vals.len()).as_bytes());
+        for val in vals {
+            val.encode_into(output, protocol);
+        }
+    }
+}
+
+/// Serialize a command the way clients send it, i.e. as an array of bulk strings
+pub fn encode_command(parsed_command: &[Vec<u8>]) -> Vec<u8> {
+    RespValue::bulk_string_array(parsed_command.iter().cloned()).encode(Protocol::Resp2)
+}
+
+/// Split an inline command into its arguments the way Redis does, or None if its quotes are unbalanced
+/// Arguments are separated by whitespace, and any part of them may be quoted. Double quoted strings support the
+/// escapes `\n`, `\r`, `\t`, `\b`, `\a` and `\xHH`, any other escaped character standing for itself, while single
+/// quoted strings only support `\'`. A closing quote must be followed by whitespace or the end of the line.
+fn split_inline_args(line: &[u8]) -> Option<Vec<Vec<u8>>> {
+    let mut args = Vec::new();
+    let mut rest = line.trim_ascii_start();
+    while !rest.is_empty() {
+        let mut arg = Vec::new();
+        loop {
+            match *rest {
+                [] => break,
+                [byte, ..] if byte.is_ascii_whitespace() => break,
+                [quote @ (b'"' | b'\''), ref tail @ ..] => {
+                    rest = unquote(tail, quote, &mut arg)?;
+                    if rest.first().is_some_and(|byte| !byte.is_ascii_whitespace()) {
+                        return None;
+                    }
+                    break;
+                }
+                [byte, ref tail @ ..] => {
+                    arg.push(byte);
+                    rest = tail;
+                }
+            }
+        }
+        args.push(arg);
+        rest = rest.trim_ascii_start();
+    }
+    Some(args)
+}
+
+/// Append the quoted string which starts the input, right after its opening quote, to the argument, and return
+/// the rest of the input after its closing quote, or None if it has none
+fn unquote<'a>(mut rest: &'a [u8], quote: u8, arg: &mut Vec<u8>) -> Option<&'a [u8]> {
+    let hex_value = |digit: u8| u8::try_from(char::from(digit).to_digit(16).unwrap()).unwrap();
+    loop {
+        match *rest {
+            [] => return None,
+            [byte, ref tail @ ..] if byte == quote => return Some(tail),
+            [b'\\', b'x', high, low, ref tail @ ..]
+                if quote == b'"' && high.is_ascii_hexdigit() && low.is_ascii_hexdigit() =>
+            {
+                arg.push(hex_value(high) * 16 + hex_value(low));
+                rest = tail;
+            }
+            [b'\\', escaped, ref tail @ ..] if quote == b'"' => {
+                arg.push(match escaped {
+                    b'n' => b'\n',
+                    b'r' => b'\r',
+                    b't' => b'\t',
+                    b'b' => 0x08,
+                    b'a' => 0x07,
+                    _ => escaped,
+                });
+                rest = tail;
+            }
+            [b'\\', b'\'', ref tail @ ..] if quote == b'\'' => {
+                arg.push(b'\'');
+                rest = tail;
+            }
+            [byte, ref tail @ ..] => {
+                arg.push(byte);
+                rest = tail;
+            }
+        }
+    }
+}
+
+/// Errors which may occur while reading RESP
+#[derive(Debug, Error)]
+pub enum RespError {
+    /// The stream ended in the middle of a value
+    #[error("unexpected end of stream")]
+    UnexpectedEof,
+    /// Length prefix of an array is not a valid length
+    #[error("Protocol error: invalid multibulk length")]
+    InvalidMultibulkLength,
+    /// Length prefix of a bulk string is not a valid length
+    #[error("Protocol error: invalid bulk length")]
+    InvalidBulkLength,
+    /// A line is longer than the allowed limit
+    #[error("Protocol error: too big inline request")]
+    TooBigInlineRequest,
+    /// A quoted argument of an inline command has no closing quote, or its closing quote is followed by something
+    /// else than whitespace
+    #[error("Protocol error: unbalanced quotes in request")]
+    UnbalancedQuotes,
+    /// The value is not of the expected type, e.g. an element of a command array is not a bulk string
+    #[error("Protocol error: expected '{expected}', got '{got}'")]
+    UnexpectedType {
+        /// Expected type byte
+        expected: char,
+        /// Received type byte
+        got: char,
+    },
+    /// The value is malformed in some other way
+    #[error("Protocol error: {0}")]
+    Malformed(&'static str),
+    /// Error in the underlying stream
+    #[error(transparent)]
+    Io(#[from] io::Error),
+}
+
+impl RespError {
+    /// Whether the error is caused by a malformed input, rather than by the stream failing or ending
+    /// A connection is closed after any error, but the client is only told about protocol errors.
+    pub const fn is_protocol_error(&self) -> bool {
+        !matches!(*self, Self::UnexpectedEof | Self::Io(_))
+    }
+}
+
+/// Incremental RESP parser over a buffered async stream
+/// Values may arrive split across multiple reads or multiple values may arrive in a single read,
+/// so the buffer is consumed exactly one value at a time.
+pub struct RespReader<R> {
+    /// The buffered stream
+    reader: BufReader<R>,
+    /// Number of bytes consumed from the stream so far
+    position: u64,
+}
+
+impl<R: AsyncRead + Unpin> RespReader<R> {
+    /// Wrap the stream
+    pub fn new(reader: R) -> Self {
+        Self {
+            reader: BufReader::new(reader),
+            position: 0,
+        }
+    }
+
+    /// Number of bytes consumed from the stream so far, i.e. the total length of the values read
+    pub const fn position(&self) -> u64 {
+        self.position
+    }
+
+    /// Consume bytes from the buffer, counting them
+    fn consume(&mut self, amount: usize) {
+        self.reader.consume(amount);
+        self.position += u64::try_from(amount).unwrap();
+    }
+
+    /// Read exactly as many bytes as the buffer can hold
+    async fn read_exact(&mut self, buffer: &mut [u8]) -> Result<(), RespError> {
+        self.reader
+            .read_exact(buffer)
+            .await
+            .map_err(|err| match err.kind() {
+                io::ErrorKind::UnexpectedEof => RespError::UnexpectedEof,
+                _ => RespError::Io(err),
+            })?;
+        self.position += u64::try_from(buffer.len()).unwrap();
+        Ok(())
+    }
+
+    /// Resolve once the client closes the stream, without consuming anything from it
+    /// Stays pending forever if more input is already buffered, as it is read only after the current command.
+    pub async fn closed(&mut self) {
+        let is_closed = self.reader.fill_buf().await.map_or(true, <[u8]>::is_empty);
+        if !is_closed {
+            future::pending::<()>().await;
+        }
+    }
+
+    /// Resolve once input is available or the stream ends, without consuming anything
+    /// Unlike reading a command, this can be cancelled without losing any input.
+    pub async fn readable(&mut self) {
+        // Errors are returned by the next read
+        let _ = self.reader.fill_buf().await;
+    }
+
+    /// Read a single line terminated by `\n` (or `\r\n`), returning it without the terminator
+    /// Returns `None` if the stream ends before any byte of the line is read.
+    async fn read_line(&mut self) -> Result<Option<Vec<u8>>, RespError> {
+        let mut line = Vec::new();
+        loop {
+            let buffer = self.reader.fill_buf().await?;
+            if buffer.is_empty() {
+                return if line.is_empty() {
+                    Ok(None)
+                } else {
+                    Err(RespError::UnexpectedEof)
+                };
+            }
+
+            if let Some(position) = buffer.iter().position(|&byte| byte == b'\n') {
+                line.extend_from_slice(&buffer[..position]);
+                self.consume(position + 1);
+                break;
+            }
+            let buffer_len = buffer.len();
+            line.extend_from_slice(buffer);
+            self.consume(buffer_len);
+
+            if line.len() > MAX_LINE_LENGTH {
+                return Err(RespError::TooBigInlineRequest);
+            }
+        }
+
+        if line.last() == Some(&b'\r') {
+            line.pop();
+        }
+        Ok(Some(line))
+    }
+
+    /// Read a line which must be present, i.e. the stream must not end before it
+    async fn read_required_line(&mut self) -> Result<Vec<u8>, RespError> {
+        self.read_line().await?.ok_or(RespError::UnexpectedEof)
+    }
+
+    /// Parse the length prefix of an array or a bulk string; `-1` denotes a null value
+    fn parse_length(
+        digits: &[u8],
+        max_length: usize,
+        error: RespError,
+    ) -> Result<Option<usize>, RespError> {
+        match str::from_utf8(digits)
+            .ok()
+            .and_then(|x| x.parse::<i64>().ok())
+        {
+            Some(-1) => Ok(None),
+            Some(length) => usize::try_from(length)
+                .ok()
+                .filter(|&length| length <= max_length)
+                .map(Some)
+                .ok_or(error),
+            None => Err(error),
+        }
+    }
+
+    /// Read the payload of a bulk string of the given length, along with its trailing `\r\n`
+    async fn read_bulk_payload(&mut self, length: usize) -> Result<Vec<u8>, RespError> {
+        let mut payload = vec![0; length + 2];
+        self.read_exact(&mut payload).await?;
+        if !payload.ends_with(b"\r\n") {
+            return Err(RespError::InvalidBulkLength);
+        }
+        payload.truncate(length);
+        Ok(payload)
+    }
+
+    /// Parse the value whose first line (including the type byte) has already been read
+    async fn read_value_after_line(&mut self, line: Vec<u8>) -> Result<RespValue, RespError> {
+        let Some((&type_byte, rest)) = line.split_first() else {
+            return Err(RespError::Malformed("empty line"));
+        };
+        let rest_as_string = || String::from_utf8_lossy(rest).into_owned();
+
+        match type_byte {
+            b'+' => Ok(RespValue::SimpleString(rest_as_string())),
+            b'-' => Ok(RespValue::Error(rest_as_string())),
+            b':' => str::from_utf8(rest)
+                .ok()
+                .and_then(|x| x.parse::<i64>().ok())
+                .map(RespValue::Integer)
+                .ok_or(RespError::Malformed("invalid integer")),
+            b'$' => {
+                match Self::parse_length(rest, MAX_BULK_LENGTH, RespError::InvalidBulkLength)? {
+                    Some(length) => {
+                        Ok(RespValue::BulkString(self.read_bulk_payload(length).await?))
+                    }
+                    None => Ok(RespValue::NullBulkString),
+                }
+            }
+            b'*' => {
+                match Self::parse_length(rest, MAX_ARRAY_LENGTH, RespError::InvalidMultibulkLength)?
+                {
+                    Some(length) => {
+                        let mut elements =
+                            Vec::with_capacity(length.min(MAX_PREALLOCATED_ELEMENTS));
+                        for _ in 0..length {
+                            // Boxed as the recursion for nested arrays would otherwise create an infinitely sized future
+                            elements.push(Box::pin(self.read_value()).await?);
+                        }
+                        Ok(RespValue::Array(elements))
+                    }
+                    None => Ok(RespValue::NullArray),
+                }
+            }
+            _ => Err(RespError::Malformed("unknown type byte")),
+        }
+    }
+
+    /// Read any single value, including nested arrays
+    pub async fn read_value(&mut self) -> Result<RespValue, RespError> {
+        let line = self.read_required_line().await?;
+        self.read_value_after_line(line).await
+    }
+
+    /// Read a bulk string; returns `None` for a null bulk string (`$-1\r\n`)
+    pub async fn read_bulk_string(&mut self) -> Result<Option<Vec<u8>>, RespError> {
+        let line = self.read_required_line().await?;
+        match line.first() {
+            Some(&b'$') => match self.read_value_after_line(line).await? {
+                RespValue::BulkString(val) => Ok(Some(val)),
+                _ => Ok(None),
+            },
+            first => Err(RespError::UnexpectedType {
+                expected: '$',
+                got: first.map_or(' ', |&x| char::from(x)),
+            }),
+        }
+    }
+
+    /// Read an RDB file transferred by a master during a full resynchronization
+    /// It is sent like a bulk string, except that it isn't followed by `\r\n`.
+    pub async fn read_rdb_transfer(&mut self) -> Result<Vec<u8>, RespError> {
+        let line = self.read_required_line().await?;
+        let Some((&b'$', digits)) = line.split_first() else {
+            return Err(RespError::UnexpectedType {
+                expected: '$',
+                got: line.first().map_or(' ', |&x| char::from(x)),
+            });
+        };
+        let length = Self::parse_length(digits, MAX_BULK_LENGTH, RespError::InvalidBulkLength)?
+            .ok_or(RespError::InvalidBulkLength)?;
+        let mut rdb = vec![0; length];
+        self.read_exact(&mut rdb).await?;
+        Ok(rdb)
+    }
+
+    /// Read the next command sent by a client, as its list of arguments
+    /// A command is either an array of bulk strings or an inline command, i.e. a line of space separated arguments.
+    /// Empty commands are skipped; returns `None` when the client closes the stream.
+    pub async fn read_command(&mut self) -> Result<Option<Vec<Vec<u8>>>, RespError> {
+        loop {
+            let Some(line) = self.read_line().await? else {
+                return Ok(None);
+            };
+
+            if line.first() != Some(&b'*') {
+                // Inline command
+                let args = split_inline_args(&line).ok_or(RespError::UnbalancedQuotes)?;
+                if args.is_empty() {
+                    continue;
+                }
+                return Ok(Some(args));
+            }
+
+            let Some(length) = Self::parse_length(
+                &line[1..],
+                MAX_ARRAY_LENGTH,
+                RespError::InvalidMultibulkLength,
+            )?
+            else {
+                continue; // Null array is treated as an empty command
+            };
+
+            let mut args = Vec::with_capacity(length.min(MAX_PREALLOCATED_ELEMENTS));
+            for _ in 0..length {
+                // Every argument must be a bulk string; nested arrays are not valid commands
+                match self.read_bulk_string().await? {
+                    Some(arg) => args.push(arg),
+                    None => return Err(RespError::InvalidBulkLength),
+                }
+            }
+            if !args.is_empty() {
+                return Ok(Some(args));
+            }
+        }
+    }
+}
@@ -0,0 +1,60 @@
+//! Random sampling of the elements of a set (SRANDMEMBER) or of the fields of a hash (HRANDFIELD)
+
+use rand::{seq::index, Rng as _};
+
+use crate::resp::{Protocol, RespValue};
+
+/// Randomly pick `count` distinct elements, or all of them if there aren't as many, or `-count` elements which may
+/// repeat if `count` is negative
+/// Every element is equally likely to be picked, and the picked elements are in no particular order.
+pub fn sample<T: Clone>(elements: impl IntoIterator<Item = T>, count: i64) -> Vec<T> {
+    let elements: Vec<T> = elements.into_iter().collect();
+    if elements.is_empty() {
+        return Vec::new();
+    }
+    let mut rng = rand::rng();
+    if count >= 0 {
+        let amount = usize::try_from(count)
+            .unwrap_or(usize::MAX)
+            .min(elements.len());
+        index::sample(&mut rng, elements.len(), amount)
+            .into_iter()
+            .map(|index| elements[index].clone())
+            .collect()
+    } else {
+        (0..count.unsigned_abs())
+            .map(|_| elements[rng.random_range(0..elements.len())].clone())
+            .collect()
+    }
+}
+
+/// Output of the SRANDMEMBER/HRANDFIELD commands
+pub enum SampleOutput {
+    /// The element when no count is given, if the key exists
+    Single(Option<Vec<u8>>),
+    /// The elements when a count is given
+    Many(Vec<Vec<u8>>),
+    /// The fields along with their values, by HRANDFIELD with `WITHVALUES`
+    WithValues(Vec<(Vec<u8>, Vec<u8>)>),
+}
+
+impl SampleOutput {
+    /// Convert to RESP; the fields and values are replied as pairs to RESP3 clients and as a flat array otherwise
+    pub fn into_resp(self, protocol: Protocol) -> RespValue {
+        match self {
+            Self::Single(element) => {
+                element.map_or(RespValue::NullBulkString, RespValue::BulkString)
+            }
+            Self::Many(elements) => RespValue::bulk_string_array(elements),
+            Self::WithValues(pairs) if protocol == Protocol::Resp3 => RespValue::Array(
+                pairs
+                    .into_iter()
+                    .map(|pair| RespValue::bulk_string_array(<[_; 2]>::from(pair)))
+                    .collect(),
+            ),
+            Self::WithValues(pairs) => {
+                RespValue::bulk_string_array(pairs.into_iter().flat_map(<[_; 2]>::from))
+            }
+        }
+    }
+}
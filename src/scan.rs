@@ -0,0 +1,275 @@
+//! Cursor-based iteration over the keyspace (SCAN) and over the elements of a value (HSCAN, SSCAN, ZSCAN)
+//! Redis visits the buckets of its hash tables in reverse binary order, incrementing the reversed bits of the
+//! cursor, so that the buckets visited before the table is resized map to buckets visited before in the resized
+//! table as well. Visiting the buckets in that order amounts to visiting the elements ordered by the reversed bits
+//! of their hashes, whatever the size of the table, so here the elements are ordered that way and the cursor is
+//! the position in that order to resume from. Every element which exists throughout an iteration is returned
+//! exactly once; elements added or removed during it may or may not be.
+
+use std::{
+    collections::BTreeSet,
+    hash::{DefaultHasher, Hasher as _},
+    iter::Peekable,
+    str,
+    sync::{Arc, Mutex},
+};
+
+use crate::{
+    command::WRONG_ARITY,
+    glob, parse_redis_int,
+    resp::RespValue,
+    store::{KeyValStore, RedisType, WRONGTYPE},
+};
+
+/// Number of elements visited by a call when COUNT isn't given
+const DEFAULT_COUNT: usize = 10;
+
+/// Position of an element in the iteration order
+fn position(element: &[u8]) -> u64 {
+    // The hasher has fixed keys, so that the positions don't change while the server runs
+    let mut hasher = DefaultHasher::new();
+    hasher.write(element);
+    hasher.finish().reverse_bits()
+}
+
+/// Take the next batch of at least `count` elements from the elements in iteration order, along with the cursor
+/// of the following batch, which is 0 once there is none
+/// Elements at the same position are always taken together, as the cursor can't tell them apart.
+fn take_batch<T>(
+    mut elements: Peekable<impl Iterator<Item = (u64, T)>>,
+    count: usize,
+) -> (u64, Vec<T>) {
+    let mut batch = Vec::new();
+    let mut last_position = None;
+    while let Some(&(next_position, _)) = elements.peek() {
+        if batch.len() >= count && last_position != Some(next_position) {
+            return (next_position, batch);
+        }
+        let (position, element) = elements.next().unwrap();
+        last_position = Some(position);
+        batch.push(element);
+    }
+    (0, batch)
+}
+
+/// Index of the keys in iteration order, so that SCAN visits only the keys of the batch instead of the whole keyspace
+#[derive(Default)]
+pub struct ScanIndex(BTreeSet<(u64, Vec<u8>)>);
+
+impl ScanIndex {
+    /// Add a new key
+    pub fn insert(&mut self, key: &[u8]) {
+        self.0.insert((position(key), key.to_vec()));
+    }
+
+    /// Remove a key
+    pub fn remove(&mut self, key: &[u8]) {
+        self.0.remove(&(position(key), key.to_vec()));
+    }
+
+    /// Remove all the keys
+    pub fn clear(&mut self) {
+        self.0.clear();
+    }
+
+    /// Next batch of keys from the cursor, along with the cursor of the following batch
+    pub fn scan(&self, cursor: u64, count: usize) -> (u64, Vec<&[u8]>) {
+        let keys = self
+            .0
+            .range((cursor, Vec::new())..)
+            .map(|&(position, ref key)| (position, key.as_slice()));
+        take_batch(keys.peekable(), count)
+    }
+}
+
+/// Next batch of the elements of a value from the cursor, along with the cursor of the following batch
+/// Values aren't indexed, so their elements are ordered on every call, which takes O(N); like Redis does for the
+/// small encodings, a value with few elements may well be returned in a single call.
+fn scan_elements<'a, T>(
+    elements: impl Iterator<Item = (&'a [u8], T)>,
+    cursor: u64,
+    count: usize,
+) -> (u64, Vec<(&'a [u8], T)>) {
+    let mut elements: Vec<_> = elements
+        .map(|(element, val)| (position(element), (element, val)))
+        .filter(|&(position, _)| position >= cursor)
+        .collect();
+    elements.sort_unstable_by_key(|&(position, (element, _))| (position, element));
+    take_batch(elements.into_iter().peekable(), count)
+}
+
+/// Parsed options of the SCAN commands
+struct ScanOptions {
+    /// `MATCH`: only reply the elements matching the glob-style pattern
+    pattern: Option<Vec<u8>>,
+    /// `COUNT`: number of elements to visit; the elements filtered out count as well
+    count: usize,
+    /// `TYPE`: only reply the keys holding this type of value, for SCAN
+    type_name: Option<String>,
+    /// `NOVALUES`: reply the fields without their values, for HSCAN
+    no_values: bool,
+}
+
+impl ScanOptions {
+    /// Parse the options given after the cursor; `allowed` are the options specific to the command
+    fn parse(options: &[Vec<u8>], allowed: &[&str]) -> Result<Self, &'static str> {
+        let mut scan_options = Self {
+            pattern: None,
+            count: DEFAULT_COUNT,
+            type_name: None,
+            no_values: false,
+        };
+        let mut options = options.iter();
+        while let Some(option) = options.next() {
+            let option = String::from_utf8_lossy(option).to_lowercase();
+            match option.as_str() {
+                "match" => {
+                    scan_options.pattern = Some(options.next().ok_or("ERR syntax error")?.clone());
+                }
+                "count" => {
+                    let count = parse_redis_int(options.next().ok_or("ERR syntax error")?)
+                        .ok_or("ERR value is not an integer or out of range")?;
+                    scan_options.count = usize::try_from(count)
+                        .ok()
+                        .filter(|&count| count > 0)
+                        .ok_or("ERR syntax error")?;
+                }
+                "type" if allowed.contains(&"type") => {
+                    let type_name = options.next().ok_or("ERR syntax error")?;
+                    scan_options.type_name =
+                        Some(String::from_utf8_lossy(type_name).to_lowercase());
+                }
+                "novalues" if allowed.contains(&"novalues") => scan_options.no_values = true,
+                _ => return Err("ERR syntax error"),
+            }
+        }
+        Ok(scan_options)
+    }
+
+    /// Whether the element matches the pattern, if any
+    fn matches(&self, element: &[u8]) -> bool {
+        self.pattern
+            .as_ref()
+            .is_none_or(|pattern| glob::matches(pattern, element))
+    }
+}
+
+/// Parse a cursor, which is an unsigned 64 bit integer
+fn parse_cursor(cursor: &[u8]) -> Result<u64, &'static str> {
+    str::from_utf8(cursor)
+        .ok()
+        .and_then(|cursor| cursor.parse().ok())
+        .ok_or("ERR invalid cursor")
+}
+
+/// Output of the SCAN commands
+pub struct ScanOutput {
+    /// Cursor of the next call, or 0 once the iteration is complete
+    cursor: u64,
+    /// Keys, or elements of the value; these are flattened pairs for HSCAN and ZSCAN
+    elements: Vec<Vec<u8>>,
+}
+
+impl ScanOutput {
+    /// Convert to RESP: the cursor as a bulk string followed by the array of elements
+    pub fn into_resp(self) -> RespValue {
+        RespValue::Array(vec![
+            RespValue::BulkString(self.cursor.to_string().into_bytes()),
+            RespValue::bulk_string_array(self.elements),
+        ])
+    }
+}
+
+/// SCAN: get the next batch of keys, optionally filtered by `MATCH` and `TYPE`
+pub fn scan(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<ScanOutput, &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(WRONG_ARITY);
+    }
+    let cursor = parse_cursor(&parsed_command[1])?;
+    let options = ScanOptions::parse(&parsed_command[2..], &["type"])?;
+
+    let store = redis_key_val_store.lock().unwrap();
+    let (cursor, elements) = store.scan(cursor, options.count, |key, data| {
+        options.matches(key)
+            && options
+                .type_name
+                .as_ref()
+                .is_none_or(|type_name| data.type_name() == type_name)
+    });
+    drop(store);
+    Ok(ScanOutput { cursor, elements })
+}
+
+/// HSCAN, SSCAN, ZSCAN: get the next batch of elements of the value at the key, which must hold the type of the
+/// command; a missing key has no elements
+pub fn scan_value(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<ScanOutput, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+    let command = String::from_utf8_lossy(&parsed_command[0]).to_lowercase();
+    let (type_name, allowed): (_, &[&str]) = match command.as_str() {
+        "hscan" => ("hash", &["novalues"]),
+        "sscan" => ("set", &[]),
+        "zscan" => ("zset", &[]),
+        _ => unreachable!("not a command scanning a value"),
+    };
+    let cursor = parse_cursor(&parsed_command[2])?;
+    let options = ScanOptions::parse(&parsed_command[3..], allowed)?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(data) = store.get(&parsed_command[1]) else {
+        return Ok(ScanOutput {
+            cursor: 0,
+            elements: Vec::new(),
+        });
+    };
+    let (cursor, elements) = match *data {
+        _ if data.type_name() != type_name => {
+            return Err(WRONGTYPE);
+        }
+        RedisType::Hash(ref hash) => {
+            let fields = hash.iter().map(|(field, val)| (field.as_slice(), val));
+            let (cursor, pairs) = scan_elements(fields, cursor, options.count);
+            let elements = pairs
+                .into_iter()
+                .filter(|&(field, _)| options.matches(field))
+                .flat_map(|(field, val)| {
+                    let val = (!options.no_values).then(|| val.clone());
+                    [Some(field.to_vec()), val].into_iter().flatten()
+                })
+                .collect();
+            (cursor, elements)
+        }
+        RedisType::Set(ref set) => {
+            let members = set.iter().map(|member| (member.as_slice(), ()));
+            let (cursor, members) = scan_elements(members, cursor, options.count);
+            let elements = members
+                .into_iter()
+                .filter(|&(member, ())| options.matches(member))
+                .map(|(member, ())| member.to_vec())
+                .collect();
+            (cursor, elements)
+        }
+        RedisType::SortedSet(ref sorted_set) => {
+            let members = sorted_set
+                .iter()
+                .map(|&(score, ref member)| (member.as_slice(), score));
+            let (cursor, pairs) = scan_elements(members, cursor, options.count);
+            let elements = pairs
+                .into_iter()
+                .filter(|&(member, _)| options.matches(member))
+                .flat_map(|(member, score)| [member.to_vec(), score.to_string().into_bytes()])
+                .collect();
+            (cursor, elements)
+        }
+        _ => unreachable!("type of the key is checked above"),
+    };
+    drop(store);
+    Ok(ScanOutput { cursor, elements })
+}
@@ -0,0 +1,399 @@
+//! Commands on the set data type, from adding and removing members to combining the sets with SINTER, SUNION and
+//! SDIFF, whose results can be stored in a destination key
+
+use std::{
+    collections::HashSet,
+    sync::{Arc, Mutex},
+};
+
+use crate::{
+    command::WRONG_ARITY,
+    notify::EventClass,
+    parse_redis_int,
+    resp::RespValue,
+    sample::{self, SampleOutput},
+    store::{KeyValStore, RedisType},
+};
+
+/// Unique members of a set
+type Set = HashSet<Vec<u8>>;
+
+/// SADD: add the members, returning the number of members which weren't in the set before
+pub fn sadd(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let set = store.get_or_insert_typed::<Set>(&parsed_command[1])?;
+    let added_members = parsed_command[2..]
+        .iter()
+        .filter(|member| set.insert((*member).clone()))
+        .count();
+    if added_members > 0 {
+        store.notify(EventClass::Set, "sadd", &parsed_command[1]);
+    }
+    drop(store);
+    Ok(added_members)
+}
+
+/// SREM: remove the members, returning the number of members which were in the set
+/// The key is removed along with the last member.
+pub fn srem(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(set) = store.get_typed_mut::<Set>(&parsed_command[1])? else {
+        return Ok(0);
+    };
+    let removed_members = parsed_command[2..]
+        .iter()
+        .filter(|member| set.remove(*member))
+        .count();
+    let is_set_empty = set.is_empty();
+    if removed_members > 0 {
+        store.notify(EventClass::Set, "srem", &parsed_command[1]);
+    }
+    if is_set_empty {
+        store.remove(&parsed_command[1]);
+        store.notify(EventClass::Generic, "del", &parsed_command[1]);
+    }
+    drop(store);
+    Ok(removed_members)
+}
+
+/// SMEMBERS: get all the members, in no particular order
+pub fn smembers(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Vec<Vec<u8>>, &'static str> {
+    if parsed_command.len() != 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let members = store
+        .get_typed::<Set>(&parsed_command[1])?
+        .map_or_else(Vec::new, |set| set.iter().cloned().collect());
+    drop(store);
+    Ok(members)
+}
+
+/// SISMEMBER: check whether the member is in the set
+pub fn sismember(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<bool, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let is_member = store
+        .get_typed::<Set>(&parsed_command[1])?
+        .is_some_and(|set| set.contains(&parsed_command[2]));
+    drop(store);
+    Ok(is_member)
+}
+
+/// SMISMEMBER: check whether every one of the members is in the set
+pub fn smismember(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Vec<bool>, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let set = store.get_typed::<Set>(&parsed_command[1])?;
+    let are_members = parsed_command[2..]
+        .iter()
+        .map(|member| set.is_some_and(|set| set.contains(member)))
+        .collect();
+    drop(store);
+    Ok(are_members)
+}
+
+/// SRANDMEMBER: get a random member, or random members with a count, like `sample::sample` picks them
+pub fn srandmember(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<SampleOutput, &'static str> {
+    let count = match parsed_command.len() {
+        2 => None,
+        3 => Some(
+            parse_redis_int(&parsed_command[2])
+                .ok_or("ERR value is not an integer or out of range")?,
+        ),
+        _ => return Err(WRONG_ARITY),
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let members = store
+        .get_typed::<Set>(&parsed_command[1])?
+        .map_or_else(Vec::new, |set| {
+            sample::sample(set, count.unwrap_or(1))
+                .into_iter()
+                .cloned()
+                .collect()
+        });
+    drop(store);
+    Ok(if count.is_some() {
+        SampleOutput::Many(members)
+    } else {
+        SampleOutput::Single(members.into_iter().next())
+    })
+}
+
+/// SPOP: remove a random member and get it, or remove random distinct members with a count and get them, like
+/// SRANDMEMBER picks them
+/// The key is removed along with the last member.
+pub fn spop(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<SampleOutput, &'static str> {
+    let count = match parsed_command.len() {
+        2 => None,
+        3 => Some(
+            parse_redis_int(&parsed_command[2])
+                .filter(|&count| count >= 0)
+                .ok_or("ERR value is out of range, must be positive")?,
+        ),
+        _ => return Err(WRONG_ARITY),
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(set) = store.get_typed_mut::<Set>(&parsed_command[1])? else {
+        return Ok(count.map_or(SampleOutput::Single(None), |_| {
+            SampleOutput::Many(Vec::new())
+        }));
+    };
+    let members: Vec<Vec<u8>> = sample::sample(&*set, count.unwrap_or(1))
+        .into_iter()
+        .cloned()
+        .collect();
+    for member in &members {
+        set.remove(member);
+    }
+    let is_set_empty = set.is_empty();
+    if !members.is_empty() {
+        store.notify(EventClass::Set, "spop", &parsed_command[1]);
+    }
+    if is_set_empty {
+        store.remove(&parsed_command[1]);
+        store.notify(EventClass::Generic, "del", &parsed_command[1]);
+    }
+    drop(store);
+    Ok(if count.is_some() {
+        SampleOutput::Many(members)
+    } else {
+        SampleOutput::Single(members.into_iter().next())
+    })
+}
+
+/// SREM of the members which SPOP removed, given its reply, to be propagated instead of SPOP so that the same
+/// members are removed when it is replayed, same as Redis; None if it removed nothing
+pub fn spop_as_srem(key: &[u8], reply: &RespValue) -> Option<Vec<Vec<u8>>> {
+    let members: Vec<Vec<u8>> = match *reply {
+        RespValue::BulkString(ref member) => vec![member.clone()],
+        RespValue::Array(ref members) => members
+            .iter()
+            .filter_map(|member| match *member {
+                RespValue::BulkString(ref member) => Some(member.clone()),
+                _ => None,
+            })
+            .collect(),
+        _ => Vec::new(),
+    };
+    if members.is_empty() {
+        return None;
+    }
+    let mut srem = vec![b"SREM".to_vec(), key.to_vec()];
+    srem.extend(members);
+    Some(srem)
+}
+
+/// SCARD: get the number of members
+pub fn scard(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() != 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let len = store
+        .get_typed::<Set>(&parsed_command[1])?
+        .map_or(0, HashSet::len);
+    drop(store);
+    Ok(len)
+}
+
+/// Operation of SINTER, SUNION and SDIFF along with their STORE variants, where missing keys are empty sets
+#[derive(Clone, Copy)]
+enum SetOperation {
+    /// Members of all the sets
+    Inter,
+    /// Members of any of the sets
+    Union,
+    /// Members of the first set which aren't in any of the others
+    Diff,
+}
+
+impl SetOperation {
+    /// Operation of the command, given in lowercase
+    fn of_command(command: &str) -> Self {
+        if command.starts_with("sinter") {
+            Self::Inter
+        } else if command.starts_with("sunion") {
+            Self::Union
+        } else {
+            Self::Diff
+        }
+    }
+
+    /// Event of the STORE variant
+    const fn store_event(self) -> &'static str {
+        match self {
+            Self::Inter => "sinterstore",
+            Self::Union => "sunionstore",
+            Self::Diff => "sdiffstore",
+        }
+    }
+
+    /// Combine the sets of the keys
+    fn apply(self, sets: &[Option<&Set>]) -> Set {
+        match self {
+            Self::Inter => intersection(sets).cloned().collect(),
+            Self::Union => sets
+                .iter()
+                .flatten()
+                .flat_map(|set| set.iter())
+                .cloned()
+                .collect(),
+            Self::Diff => {
+                let Some(&Some(first)) = sets.first() else {
+                    return Set::new();
+                };
+                first
+                    .iter()
+                    .filter(|member| !sets[1..].iter().flatten().any(|set| set.contains(*member)))
+                    .cloned()
+                    .collect()
+            }
+        }
+    }
+}
+
+/// Iterate over the members of all the sets, which are none if any of them is missing
+/// The smallest set is iterated over, checking for each of its members whether the others contain it.
+fn intersection<'a>(sets: &'a [Option<&'a Set>]) -> impl Iterator<Item = &'a Vec<u8>> {
+    let sets: Option<Vec<&Set>> = sets.iter().copied().collect();
+    let mut sets = sets.unwrap_or_default();
+    sets.sort_unstable_by_key(|set| set.len());
+    let smallest = sets.first().copied();
+    smallest
+        .into_iter()
+        .flat_map(HashSet::iter)
+        .filter(move |member| sets[1..].iter().all(|set| set.contains(*member)))
+}
+
+/// SINTER, SUNION, SDIFF: get the members of the combination of the sets
+pub fn combine(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Vec<Vec<u8>>, &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(WRONG_ARITY);
+    }
+    let operation =
+        SetOperation::of_command(&String::from_utf8_lossy(&parsed_command[0]).to_lowercase());
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let sets = store.get_many_typed::<Set>(&parsed_command[1..])?;
+    let members = operation.apply(&sets).into_iter().collect();
+    drop(store);
+    Ok(members)
+}
+
+/// SINTERSTORE, SUNIONSTORE, SDIFFSTORE: store the combination of the sets at the destination key, which is
+/// overwritten whatever its type, and return its number of members
+/// The destination key is removed instead if the combination is empty.
+pub fn combine_store(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+    let operation =
+        SetOperation::of_command(&String::from_utf8_lossy(&parsed_command[0]).to_lowercase());
+    let destination = &parsed_command[1];
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let sets = store.get_many_typed::<Set>(&parsed_command[2..])?;
+    let set = operation.apply(&sets);
+    let len = set.len();
+    if set.is_empty() {
+        if store.remove(destination).is_some() {
+            store.notify(EventClass::Generic, "del", destination);
+        }
+    } else {
+        store.insert(destination.clone(), RedisType::Set(set), None);
+        store.notify(EventClass::Set, operation.store_event(), destination);
+    }
+    drop(store);
+    Ok(len)
+}
+
+/// Parse the keys and the limit of SINTERCARD and ZINTERCARD, where a limit of 0 or none is no limit
+pub fn parse_intercard(parsed_command: &[Vec<u8>]) -> Result<(&[Vec<u8>], usize), &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+    let numkeys = parse_redis_int(&parsed_command[1])
+        .and_then(|numkeys| usize::try_from(numkeys).ok())
+        .filter(|&numkeys| numkeys > 0)
+        .ok_or("ERR numkeys should be greater than 0")?;
+    let keys = parsed_command[2..]
+        .get(..numkeys)
+        .ok_or("ERR Number of keys can't be greater than number of args")?;
+    let mut limit = 0;
+    let mut options = parsed_command[2 + numkeys..].iter();
+    while let Some(option) = options.next() {
+        if !option.eq_ignore_ascii_case(b"limit") {
+            return Err("ERR syntax error");
+        }
+        let value = options.next().ok_or("ERR syntax error")?;
+        limit = parse_redis_int(value)
+            .and_then(|limit| usize::try_from(limit).ok())
+            .ok_or("ERR LIMIT can't be negative")?;
+    }
+    Ok((keys, if limit == 0 { usize::MAX } else { limit }))
+}
+
+/// SINTERCARD: get the number of members of the intersection of the sets, counting up to `LIMIT` if given and
+/// not 0
+pub fn sintercard(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    let (keys, limit) = parse_intercard(parsed_command)?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let sets = store.get_many_typed::<Set>(keys)?;
+    // The iteration stops as soon as the limit is reached
+    let count = intersection(&sets).take(limit).count();
+    drop(store);
+    Ok(count)
+}
@@ -0,0 +1,50 @@
+//! Shutting down cleanly, by SHUTDOWN or on SIGINT/SIGTERM: the AOF is flushed to the disk and the keyspace saved to
+//! the RDB file before the process exits, so that no acknowledged write is lost
+//! The shutdowns are run by the accept loop, which SHUTDOWN sends its requests to.
+
+use std::{fs, io, process};
+
+use tokio::sync::oneshot;
+
+use crate::{rdb, transaction, Server};
+
+/// Request of SHUTDOWN: whether to save the keyspace, along with the channel to tell the client that the shutdown
+/// failed; nothing is sent if it succeeds, as the process exits
+pub type ShutdownRequest = (bool, oneshot::Sender<()>);
+
+/// Parse the arguments of SHUTDOWN into whether to save the keyspace
+/// Like Redis with its default save points, the keyspace is saved unless `NOSAVE` is given.
+pub fn parse_save(parsed_command: &[Vec<u8>]) -> Result<bool, &'static str> {
+    match *parsed_command {
+        [_] => Ok(true),
+        [_, ref mode] if mode.eq_ignore_ascii_case(b"save") => Ok(true),
+        [_, ref mode] if mode.eq_ignore_ascii_case(b"nosave") => Ok(false),
+        _ => Err("ERR syntax error"),
+    }
+}
+
+/// Bring the persistence up to date and exit the process; this only returns if that failed, with the error, in
+/// which case the server keeps running
+/// No command runs from then on, so that the files have all the writes which were replied to; the connections of
+/// the clients, including the blocked ones, are closed when the process exits.
+pub fn shutdown(server: &Server, save: bool) -> io::Error {
+    let _exclusive = transaction::ATOMICITY_LOCK.write().unwrap();
+    let aof = server.aof.lock().unwrap();
+    if let Some(ref aof) = *aof {
+        if let Err(err) = aof.sync() {
+            return err;
+        }
+    }
+    drop(aof);
+    if save {
+        let db_path = server.config.read().unwrap().db_path();
+        if let Err(err) = rdb::save_on_shutdown(&server.databases, &db_path) {
+            return err;
+        }
+    }
+    // Nothing listens on the Unix socket anymore, so it is removed like Redis does
+    if let Some(ref unixsocket) = server.config.read().unwrap().unixsocket {
+        let _ = fs::remove_file(unixsocket);
+    }
+    process::exit(0);
+}
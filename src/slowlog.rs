@@ -0,0 +1,175 @@
+//! SLOWLOG: the commands which took longer than `slowlog-log-slower-than` microseconds to run, newest first
+//! The log holds at most `slowlog-max-len` entries, the oldest ones being dropped as new ones come in. Only the
+//! time to run a command is measured, not the time a client spends blocked nor the time to read and reply.
+
+use std::{
+    collections::VecDeque,
+    sync::Mutex,
+    time::{Duration, SystemTime, UNIX_EPOCH},
+};
+
+use crate::{
+    command,
+    monitor::{self, REDACTED},
+    parse_redis_int,
+    resp::RespValue,
+};
+
+/// Maximum number of arguments kept for an entry, the last one of which tells how many more there were (same as
+/// Redis)
+const MAX_ARGS: usize = 32;
+/// Maximum number of bytes kept of an argument (same as Redis)
+const MAX_ARG_LEN: usize = 128;
+/// Number of entries SLOWLOG GET replies by default (same as Redis)
+const DEFAULT_GET_COUNT: usize = 10;
+
+/// A command which ran slowly
+struct SlowLogEntry {
+    /// Unique ID, growing from 0 and never reset
+    id: u64,
+    /// Unix time in seconds when the command was logged
+    timestamp: u64,
+    /// Time the command took to run, in microseconds
+    duration_us: u64,
+    /// Arguments of the command including its name, possibly cut short
+    args: Vec<Vec<u8>>,
+    /// Address of the client which ran the command, empty if it has none
+    client_addr: String,
+    /// Name of the client, as given by CLIENT SETNAME
+    client_name: Vec<u8>,
+}
+
+/// The slow log, newest entry first
+#[derive(Default)]
+pub struct SlowLog {
+    /// The entries, newest first
+    entries: VecDeque<SlowLogEntry>,
+    /// ID of the next entry
+    next_id: u64,
+}
+
+impl SlowLog {
+    /// Log the command which took the duration to run, keeping at most `max_len` entries
+    pub fn record(
+        &mut self,
+        parsed_command: &[Vec<u8>],
+        duration: Duration,
+        client: (String, Vec<u8>),
+        max_len: usize,
+    ) {
+        let (client_addr, client_name) = client;
+        let timestamp = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .unwrap_or_default()
+            .as_secs();
+        self.entries.push_front(SlowLogEntry {
+            id: self.next_id,
+            timestamp,
+            duration_us: u64::try_from(duration.as_micros()).unwrap_or(u64::MAX),
+            args: logged_args(parsed_command),
+            client_addr,
+            client_name,
+        });
+        self.next_id += 1;
+        self.entries.truncate(max_len);
+    }
+}
+
+/// Arguments of the command as logged: secrets are redacted, and long arguments and long lists of arguments are
+/// cut short, with a note of how much was left out
+fn logged_args(parsed_command: &[Vec<u8>]) -> Vec<Vec<u8>> {
+    let kept_count = if parsed_command.len() > MAX_ARGS {
+        MAX_ARGS - 1
+    } else {
+        parsed_command.len()
+    };
+    let mut args: Vec<Vec<u8>> = parsed_command[..kept_count]
+        .iter()
+        .enumerate()
+        .map(|(i, arg)| {
+            if monitor::is_secret(parsed_command, i) {
+                REDACTED.to_vec()
+            } else if arg.len() > MAX_ARG_LEN {
+                let mut kept = arg[..MAX_ARG_LEN].to_vec();
+                kept.extend_from_slice(
+                    format!("... ({} more bytes)", arg.len() - MAX_ARG_LEN).as_bytes(),
+                );
+                kept
+            } else {
+                arg.clone()
+            }
+        })
+        .collect();
+    if kept_count < parsed_command.len() {
+        args.push(
+            format!("... ({} more arguments)", parsed_command.len() - kept_count).into_bytes(),
+        );
+    }
+    args
+}
+
+/// Describe the entry as SLOWLOG GET does
+fn describe(entry: &SlowLogEntry) -> RespValue {
+    RespValue::Array(vec![
+        RespValue::Integer(i64::try_from(entry.id).unwrap()),
+        RespValue::Integer(i64::try_from(entry.timestamp).unwrap()),
+        RespValue::Integer(i64::try_from(entry.duration_us).unwrap_or(i64::MAX)),
+        RespValue::bulk_string_array(entry.args.iter().cloned()),
+        RespValue::BulkString(entry.client_addr.clone().into_bytes()),
+        RespValue::BulkString(entry.client_name.clone()),
+    ])
+}
+
+/// Lines of SLOWLOG HELP
+const HELP: &[&str] = &[
+    "GET [<count>]",
+    "    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+    "    Entries are made of:",
+    "    id, timestamp, time in microseconds, arguments array, client IP and port,",
+    "    client name",
+    "LEN",
+    "    Return the length of the slowlog.",
+    "RESET",
+    "    Reset the slowlog.",
+];
+
+/// Compute output of the SLOWLOG GET/LEN/RESET/HELP subcommands
+/// GET replies the given number of the newest entries, 10 by default, or all of them if -1.
+pub fn slowlog(slowlog: &Mutex<SlowLog>, parsed_command: &[Vec<u8>]) -> RespValue {
+    let subcommand = String::from_utf8_lossy(&parsed_command[1]).to_lowercase();
+    let args = &parsed_command[2..];
+    match subcommand.as_str() {
+        "get" if args.len() <= 1 => {
+            let count = match args.first().map(|count| parse_redis_int(count)) {
+                None => DEFAULT_GET_COUNT,
+                Some(Some(-1)) => usize::MAX,
+                Some(Some(count)) => match usize::try_from(count) {
+                    Ok(count) => count,
+                    Err(_) => {
+                        return RespValue::error("ERR count should be greater than or equal to -1")
+                    }
+                },
+                Some(None) => {
+                    return RespValue::error("ERR value is not an integer or out of range")
+                }
+            };
+            let slowlog = slowlog.lock().unwrap();
+            let entries = slowlog.entries.iter().take(count).map(describe).collect();
+            drop(slowlog);
+            RespValue::Array(entries)
+        }
+        "len" if args.is_empty() => {
+            RespValue::Integer(i64::try_from(slowlog.lock().unwrap().entries.len()).unwrap())
+        }
+        "reset" if args.is_empty() => {
+            slowlog.lock().unwrap().entries.clear();
+            RespValue::simple("OK")
+        }
+        "help" if args.is_empty() => RespValue::help("SLOWLOG", HELP),
+        "get" | "len" | "reset" | "help" => command::wrong_arity(&format!("slowlog|{subcommand}")),
+        _ => RespValue::Error(format!(
+            "ERR unknown subcommand '{}'. Try SLOWLOG HELP.",
+            String::from_utf8_lossy(&parsed_command[1])
+        )),
+    }
+}
@@ -0,0 +1,265 @@
+//! SORT and `SORT_RO`: sort the elements of a list, a set or a sorted set, by their values or by those of other keys
+//! The patterns of `BY` and `GET` name other keys by replacing their first `*` with an element; a pattern followed by
+//! `->field` takes the field of a hash rather than a string, and the `GET #` pattern takes the element itself.
+
+use std::{
+    cmp::Ordering,
+    collections::HashMap,
+    sync::{Arc, Mutex},
+};
+
+use crate::{
+    blocking::BlockedClients,
+    command::WRONG_ARITY,
+    databases,
+    notify::EventClass,
+    parse_redis_float, parse_redis_int,
+    resp::RespValue,
+    store::{KeyValStore, RedisType, WRONGTYPE},
+};
+
+/// Options of SORT
+#[derive(Default)]
+struct SortOptions<'a> {
+    /// `BY`: pattern of the keys whose values are sorted instead of the elements; without `*` nothing is sorted
+    by: Option<&'a [u8]>,
+    /// `LIMIT`: offset of the first element returned and number of elements returned, all of them if negative
+    limit: Option<(i64, i64)>,
+    /// `GET`: patterns of the values returned for every element, instead of the element itself
+    get: Vec<&'a [u8]>,
+    /// `DESC`: whether the elements are sorted from the greatest
+    is_desc: bool,
+    /// `ALPHA`: whether the elements are sorted as strings rather than as numbers
+    is_alpha: bool,
+    /// `STORE`: key at which the result is stored as a list, rather than returned
+    store: Option<&'a [u8]>,
+}
+
+impl<'a> SortOptions<'a> {
+    /// Parse the options following the key; `SORT_RO` doesn't take `STORE`
+    fn parse(args: &'a [Vec<u8>], is_read_only: bool) -> Result<Self, &'static str> {
+        let mut options = Self::default();
+        let mut args = args.iter();
+        while let Some(option) = args.next() {
+            match String::from_utf8_lossy(option).to_lowercase().as_str() {
+                "asc" => options.is_desc = false,
+                "desc" => options.is_desc = true,
+                "alpha" => options.is_alpha = true,
+                "limit" => {
+                    let (Some(offset), Some(count)) = (args.next(), args.next()) else {
+                        return Err("ERR syntax error");
+                    };
+                    let parse = |value| {
+                        parse_redis_int(value).ok_or("ERR value is not an integer or out of range")
+                    };
+                    options.limit = Some((parse(offset)?, parse(count)?));
+                }
+                "by" => options.by = Some(args.next().ok_or("ERR syntax error")?),
+                "get" => options.get.push(args.next().ok_or("ERR syntax error")?),
+                "store" if !is_read_only => {
+                    options.store = Some(args.next().ok_or("ERR syntax error")?);
+                }
+                _ => return Err("ERR syntax error"),
+            }
+        }
+        Ok(options)
+    }
+}
+
+/// Output of SORT
+pub enum SortOutput {
+    /// Values of the sorted elements, some of which may be missing with `GET`
+    Values(Vec<Option<Vec<u8>>>),
+    /// Number of values stored with `STORE`
+    Stored(usize),
+}
+
+impl SortOutput {
+    /// Convert the output into the reply of the command
+    pub fn into_resp(self) -> RespValue {
+        match self {
+            Self::Values(values) => RespValue::Array(
+                values
+                    .into_iter()
+                    .map(|value| value.map_or(RespValue::NullBulkString, RespValue::BulkString))
+                    .collect(),
+            ),
+            Self::Stored(len) => RespValue::Integer(i64::try_from(len).unwrap()),
+        }
+    }
+}
+
+/// Look up the value which the pattern names for the element, if there is one
+/// The key is the pattern with its first `*` replaced by the element, so a pattern without `*` names nothing.
+fn lookup(store: &mut KeyValStore, pattern: &[u8], element: &[u8]) -> Option<Vec<u8>> {
+    if pattern == b"#" {
+        return Some(element.to_vec());
+    }
+    let star = pattern.iter().position(|&c| c == b'*')?;
+    // Only an arrow after the `*` and followed by a field refers to a field
+    let arrow = pattern[star + 1..]
+        .windows(2)
+        .position(|window| window == b"->")
+        .map(|arrow| star + 1 + arrow)
+        .filter(|&arrow| arrow + 2 < pattern.len());
+    let key_pattern = arrow.map_or(pattern, |arrow| &pattern[..arrow]);
+    let key = [&key_pattern[..star], element, &key_pattern[star + 1..]].concat();
+    match arrow {
+        Some(arrow) => store
+            .get_typed::<HashMap<Vec<u8>, Vec<u8>>>(&key)
+            .ok()??
+            .get(&pattern[arrow + 2..])
+            .cloned(),
+        None => store.get_typed::<Vec<u8>>(&key).ok()?.cloned(),
+    }
+}
+
+/// Elements of the list, set or sorted set at the key, none if it doesn't exist, along with whether it is a set
+/// The elements of a sorted set are in the order of their scores, from the greatest if `is_desc`.
+fn elements(
+    store: &mut KeyValStore,
+    key: &[u8],
+    is_desc: bool,
+) -> Result<(Vec<Vec<u8>>, bool), &'static str> {
+    let Some(data) = store.get(key) else {
+        return Ok((Vec::new(), false));
+    };
+    let elements = match *data {
+        RedisType::List(ref list) => (list.iter().cloned().collect(), false),
+        RedisType::Set(ref set) => (set.iter().cloned().collect(), true),
+        RedisType::SortedSet(ref sorted_set) => {
+            let mut members: Vec<Vec<u8>> = sorted_set.iter().map(|pair| pair.1.clone()).collect();
+            if is_desc {
+                members.reverse();
+            }
+            (members, false)
+        }
+        _ => return Err(WRONGTYPE),
+    };
+    Ok(elements)
+}
+
+/// Sort the elements by the values which the pattern names for them, or by themselves if there is none
+/// Numbers are sorted unless `is_alpha`, where a missing value counts as 0. Like in Redis, elements which compare
+/// equal are sorted by themselves, so that the order is always the same.
+fn sort_elements(
+    store: &mut KeyValStore,
+    elements: Vec<Vec<u8>>,
+    by: Option<&[u8]>,
+    is_alpha: bool,
+    is_desc: bool,
+) -> Result<Vec<Vec<u8>>, &'static str> {
+    let weights: Vec<Option<Vec<u8>>> = elements
+        .iter()
+        .map(|element| {
+            by.map_or_else(
+                || Some(element.clone()),
+                |pattern| lookup(store, pattern, element),
+            )
+        })
+        .collect();
+    let scores = if is_alpha {
+        Vec::new()
+    } else {
+        weights
+            .iter()
+            .map(|weight| {
+                weight
+                    .as_ref()
+                    .map_or(Some(0.0), |weight| parse_redis_float(weight))
+            })
+            .collect::<Option<Vec<f64>>>()
+            .ok_or("ERR One or more scores can't be converted into double")?
+    };
+    let mut order: Vec<usize> = (0..elements.len()).collect();
+    order.sort_by(|&a, &b| {
+        let ordering = if is_alpha {
+            weights[a].cmp(&weights[b])
+        } else {
+            scores[a].partial_cmp(&scores[b]).unwrap_or(Ordering::Equal)
+        }
+        .then_with(|| elements[a].cmp(&elements[b]));
+        if is_desc {
+            ordering.reverse()
+        } else {
+            ordering
+        }
+    });
+    let mut elements: Vec<Option<Vec<u8>>> = elements.into_iter().map(Some).collect();
+    Ok(order
+        .into_iter()
+        .filter_map(|i| elements[i].take())
+        .collect())
+}
+
+/// SORT, `SORT_RO`: sort the elements of the list, set or sorted set, returning their values or storing them at the
+/// destination key as a list, which is replaced whatever its type
+/// `BY` a pattern without `*` keeps the elements in their order, except that a set stored with `STORE` is still
+/// sorted as strings, like in Redis, so that its order doesn't depend on how it is stored.
+pub fn sort(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    blocked_clients: &Mutex<BlockedClients>,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+) -> Result<SortOutput, &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(WRONG_ARITY);
+    }
+    let is_read_only = parsed_command[0].eq_ignore_ascii_case(b"sort_ro");
+    let options = SortOptions::parse(&parsed_command[2..], is_read_only)?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let (elements, is_set) = elements(&mut store, &parsed_command[1], options.is_desc)?;
+    let is_unsorted = options.by.is_some_and(|pattern| !pattern.contains(&b'*'));
+    let elements = if !is_unsorted {
+        sort_elements(
+            &mut store,
+            elements,
+            options.by,
+            options.is_alpha,
+            options.is_desc,
+        )?
+    } else if is_set && options.store.is_some() {
+        sort_elements(&mut store, elements, None, true, options.is_desc)?
+    } else {
+        elements
+    };
+    let (offset, count) = options.limit.unwrap_or((0, -1));
+    let count = usize::try_from(count).unwrap_or(usize::MAX);
+    let elements = elements
+        .into_iter()
+        .skip(usize::try_from(offset).unwrap_or(0))
+        .take(count);
+    let values: Vec<Option<Vec<u8>>> = if options.get.is_empty() {
+        elements.map(Some).collect()
+    } else {
+        elements
+            .flat_map(|element| {
+                options
+                    .get
+                    .iter()
+                    .map(|pattern| lookup(&mut store, pattern, &element))
+                    .collect::<Vec<_>>()
+            })
+            .collect()
+    };
+
+    let Some(destination) = options.store else {
+        drop(store);
+        return Ok(SortOutput::Values(values));
+    };
+    let len = values.len();
+    if values.is_empty() {
+        if store.remove(destination).is_some() {
+            store.notify(EventClass::Generic, "del", destination);
+        }
+    } else {
+        // Missing values are stored as empty strings
+        let list = values.into_iter().map(Option::unwrap_or_default).collect();
+        store.insert(destination.to_vec(), RedisType::List(list), None);
+        store.notify(EventClass::List, "sortstore", destination);
+        databases::serve_blocked_key(&mut store, db, destination, blocked_clients);
+    }
+    drop(store);
+    Ok(SortOutput::Stored(len))
+}
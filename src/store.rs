@@ -0,0 +1,860 @@
+//! Key-value store of the Redis server along with the expiry of its keys
+
+use std::{
+    collections::{HashMap, HashSet, VecDeque},
+    mem,
+    sync::{Arc, Mutex},
+    time::{Duration, Instant, SystemTime},
+};
+
+use rand::seq::IndexedRandom as _;
+use tokio::{sync::watch, time};
+
+use crate::{
+    config::MaxMemoryPolicy,
+    encoding::{Encoding, ListpackLimits},
+    eviction::{self, KeyAccess},
+    glob,
+    notify::{EventClass, KeyspaceEvent, NotifyFlags},
+    scan::ScanIndex,
+    stream::Stream,
+    zset::SortedSet,
+};
+
+/// Number of keys with a TTL sampled in every round of the active expiry
+const ACTIVE_EXPIRY_SAMPLE_SIZE: usize = 20;
+/// Interval between two cycles of the active expiry; Redis runs 10 cycles per second by default
+const ACTIVE_EXPIRY_INTERVAL: Duration = Duration::from_millis(100);
+/// Upper bound on the time for which a single cycle of the active expiry can hold the store
+const ACTIVE_EXPIRY_CYCLE_BUDGET: Duration = Duration::from_millis(25);
+
+/// Represent different types of possible values for a key.
+#[derive(Clone)]
+pub enum RedisType {
+    /// Array/list data type.
+    List(VecDeque<Vec<u8>>),
+    /// String data type.
+    Val(Vec<u8>),
+    /// Hash data type, i.e. a map from fields to values.
+    Hash(HashMap<Vec<u8>, Vec<u8>>),
+    /// Set data type, i.e. unordered unique members.
+    Set(HashSet<Vec<u8>>),
+    /// Sorted set data type, i.e. unique members ordered by their scores.
+    SortedSet(SortedSet),
+    /// Stream data type, i.e. an append-only log of entries.
+    Stream(Stream),
+}
+
+impl RedisType {
+    /// Name of the type, as replied by TYPE and matched by the `TYPE` option of SCAN
+    pub const fn type_name(&self) -> &'static str {
+        match *self {
+            Self::List(_) => "list",
+            Self::Val(_) => "string",
+            Self::Hash(_) => "hash",
+            Self::Set(_) => "set",
+            Self::SortedSet(_) => "zset",
+            Self::Stream(_) => "stream",
+        }
+    }
+
+    /// Number of elements of the value, which is 1 for a string
+    pub fn element_count(&self) -> usize {
+        match *self {
+            Self::List(ref list) => list.len(),
+            Self::Val(_) => 1,
+            Self::Hash(ref hash) => hash.len(),
+            Self::Set(ref set) => set.len(),
+            Self::SortedSet(ref sorted_set) => sorted_set.len(),
+            Self::Stream(ref stream) => stream.len(),
+        }
+    }
+}
+
+/// Error of the commands run against a key holding a different type of value than the one they act on
+pub const WRONGTYPE: &str = "WRONGTYPE Operation against a key holding the wrong kind of value";
+
+/// Data of one of the types of values, which the commands get from the store along with the type check
+pub trait TypedValue: Default {
+    /// The data, if the value is of this type
+    fn from_value(data: &RedisType) -> Option<&Self>;
+    /// The mutable data, if the value is of this type
+    fn from_value_mut(data: &mut RedisType) -> Option<&mut Self>;
+    /// Wrap into a value
+    fn into_value(self) -> RedisType;
+}
+
+impl TypedValue for Vec<u8> {
+    fn from_value(data: &RedisType) -> Option<&Self> {
+        match *data {
+            RedisType::Val(ref val) => Some(val),
+            _ => None,
+        }
+    }
+
+    fn from_value_mut(data: &mut RedisType) -> Option<&mut Self> {
+        match *data {
+            RedisType::Val(ref mut val) => Some(val),
+            _ => None,
+        }
+    }
+
+    fn into_value(self) -> RedisType {
+        RedisType::Val(self)
+    }
+}
+
+impl TypedValue for VecDeque<Vec<u8>> {
+    fn from_value(data: &RedisType) -> Option<&Self> {
+        match *data {
+            RedisType::List(ref list) => Some(list),
+            _ => None,
+        }
+    }
+
+    fn from_value_mut(data: &mut RedisType) -> Option<&mut Self> {
+        match *data {
+            RedisType::List(ref mut list) => Some(list),
+            _ => None,
+        }
+    }
+
+    fn into_value(self) -> RedisType {
+        RedisType::List(self)
+    }
+}
+
+impl TypedValue for HashMap<Vec<u8>, Vec<u8>> {
+    fn from_value(data: &RedisType) -> Option<&Self> {
+        match *data {
+            RedisType::Hash(ref hash) => Some(hash),
+            _ => None,
+        }
+    }
+
+    fn from_value_mut(data: &mut RedisType) -> Option<&mut Self> {
+        match *data {
+            RedisType::Hash(ref mut hash) => Some(hash),
+            _ => None,
+        }
+    }
+
+    fn into_value(self) -> RedisType {
+        RedisType::Hash(self)
+    }
+}
+
+impl TypedValue for HashSet<Vec<u8>> {
+    fn from_value(data: &RedisType) -> Option<&Self> {
+        match *data {
+            RedisType::Set(ref set) => Some(set),
+            _ => None,
+        }
+    }
+
+    fn from_value_mut(data: &mut RedisType) -> Option<&mut Self> {
+        match *data {
+            RedisType::Set(ref mut set) => Some(set),
+            _ => None,
+        }
+    }
+
+    fn into_value(self) -> RedisType {
+        RedisType::Set(self)
+    }
+}
+
+impl TypedValue for SortedSet {
+    fn from_value(data: &RedisType) -> Option<&Self> {
+        match *data {
+            RedisType::SortedSet(ref sorted_set) => Some(sorted_set),
+            _ => None,
+        }
+    }
+
+    fn from_value_mut(data: &mut RedisType) -> Option<&mut Self> {
+        match *data {
+            RedisType::SortedSet(ref mut sorted_set) => Some(sorted_set),
+            _ => None,
+        }
+    }
+
+    fn into_value(self) -> RedisType {
+        RedisType::SortedSet(self)
+    }
+}
+
+impl TypedValue for Stream {
+    fn from_value(data: &RedisType) -> Option<&Self> {
+        match *data {
+            RedisType::Stream(ref stream) => Some(stream),
+            _ => None,
+        }
+    }
+
+    fn from_value_mut(data: &mut RedisType) -> Option<&mut Self> {
+        match *data {
+            RedisType::Stream(ref mut stream) => Some(stream),
+            _ => None,
+        }
+    }
+
+    fn into_value(self) -> RedisType {
+        RedisType::Stream(self)
+    }
+}
+
+/// A key along with its value and its absolute expiry time, detached from the store
+pub type Entry = (Vec<u8>, RedisType, Option<SystemTime>);
+
+/// End of a list
+#[derive(Clone, Copy)]
+pub enum ListEnd {
+    /// Head of the list, i.e. the element at index 0
+    Left,
+    /// Tail of the list, i.e. the element at index -1
+    Right,
+}
+
+impl ListEnd {
+    /// Remove the element at this end of the list
+    pub fn pop<T>(self, list: &mut VecDeque<T>) -> Option<T> {
+        match self {
+            Self::Left => list.pop_front(),
+            Self::Right => list.pop_back(),
+        }
+    }
+
+    /// Add the element at this end of the list
+    pub fn push<T>(self, list: &mut VecDeque<T>, val: T) {
+        match self {
+            Self::Left => list.push_front(val),
+            Self::Right => list.push_back(val),
+        }
+    }
+
+    /// Name of the keyspace event of a pop from this end
+    pub const fn pop_event(self) -> &'static str {
+        match self {
+            Self::Left => "lpop",
+            Self::Right => "rpop",
+        }
+    }
+
+    /// Name of the keyspace event of a push to this end
+    pub const fn push_event(self) -> &'static str {
+        match self {
+            Self::Left => "lpush",
+            Self::Right => "rpush",
+        }
+    }
+}
+
+/// Represent all the data for a key
+struct RedisValue {
+    /// Actual data
+    data: RedisType,
+    /// Absolute time at which the key expires
+    expires_at: Option<SystemTime>, // it is optional as it may not be present for every key and thus will be infinite
+    /// Encoding which Redis would use for the data
+    encoding: Encoding,
+    /// Last access and access frequency of the key
+    access: KeyAccess,
+    /// Estimated memory used by the key, as of the last time it was estimated
+    size: usize,
+}
+
+impl RedisValue {
+    /// Wrap the data of a new key along with its encoding
+    fn new(data: RedisType, expires_at: Option<SystemTime>, limits: &ListpackLimits) -> Self {
+        let encoding = Encoding::of(&data, None, limits);
+        Self {
+            data,
+            expires_at,
+            encoding,
+            access: KeyAccess::new(),
+            size: 0,
+        }
+    }
+
+    /// Update the encoding from the current data, converting it if the data outgrew it
+    fn update_encoding(&mut self, limits: &ListpackLimits) {
+        self.encoding = Encoding::of(&self.data, Some(self.encoding), limits);
+    }
+
+    /// Check whether the key has outlived its TTL
+    fn is_expired(&self) -> bool {
+        self.expires_at
+            .is_some_and(|expires_at| SystemTime::now() >= expires_at)
+    }
+}
+
+/// Keys which can be sampled at random in O(1)
+#[derive(Default)]
+struct RandomKeys {
+    /// The keys, in no particular order
+    keys: Vec<Vec<u8>>,
+    /// Index of every key of `keys`, so that it can be removed from there in O(1)
+    positions: HashMap<Vec<u8>, usize>,
+}
+
+impl RandomKeys {
+    /// Add the key, unless it is already there
+    fn insert(&mut self, key: &[u8]) {
+        if !self.positions.contains_key(key) {
+            self.positions.insert(key.to_owned(), self.keys.len());
+            self.keys.push(key.to_owned());
+        }
+    }
+
+    /// Remove the key, if it is there
+    fn remove(&mut self, key: &[u8]) {
+        if let Some(position) = self.positions.remove(key) {
+            self.keys.swap_remove(position);
+            // Fix the index of the key which got swapped into the position
+            if let Some(swapped_key) = self.keys.get(position) {
+                self.positions.insert(swapped_key.clone(), position);
+            }
+        }
+    }
+
+    /// Remove all the keys
+    fn clear(&mut self) {
+        self.keys.clear();
+        self.positions.clear();
+    }
+
+    /// Number of keys
+    const fn len(&self) -> usize {
+        self.keys.len()
+    }
+
+    /// A key picked at random, unless there are none
+    fn random(&self, rng: &mut impl rand::Rng) -> Option<&Vec<u8>> {
+        (!self.keys.is_empty()).then(|| &self.keys[rng.random_range(0..self.keys.len())])
+    }
+
+    /// Distinct keys picked at random, as many as given or all of them if there are fewer
+    fn sample(&self, count: usize, rng: &mut impl rand::Rng) -> impl Iterator<Item = &Vec<u8>> {
+        self.keys.choose_multiple(rng, count)
+    }
+}
+
+/// The keyspace
+/// Expired keys are removed lazily when they are accessed ("PASSIVE EXPIRY")
+/// as well as periodically by sampling the keys having a TTL ("ACTIVE EXPIRY"), see `delete_expired_keys`
+#[derive(Default)]
+pub struct KeyValStore {
+    /// Actual key-val data
+    data: HashMap<Vec<u8>, RedisValue>,
+    /// Keys having a TTL, which the active expiry samples
+    volatile_keys: RandomKeys,
+    /// All the keys, which the eviction samples
+    all_keys: RandomKeys,
+    /// Sum of the estimated memory used by the keys, as of the last time each of them was estimated
+    used_memory: usize,
+    /// Keys which may have been written since their memory was last estimated
+    resized_keys: HashSet<Vec<u8>>,
+    /// All the keys in the iteration order of SCAN
+    scan_index: ScanIndex,
+    /// Keys watched by clients for WATCH; only these keys are versioned
+    watched_keys: HashMap<Vec<u8>, WatchedKey>,
+    /// Classes of the keyspace events which are recorded, as given by `notify-keyspace-events`
+    notify_flags: NotifyFlags,
+    /// Keyspace events recorded since they were last taken for publishing
+    events: Vec<KeyspaceEvent>,
+    /// Limits of the listpack encoding, as given by the `*-max-listpack-*` parameters
+    listpack_limits: ListpackLimits,
+}
+
+/// Version of a watched key, bumped by every modification of the key
+struct WatchedKey {
+    /// Number of modifications since the key was first watched
+    version: u64,
+    /// Number of clients watching the key; it is no longer versioned when this drops to 0
+    watchers: usize,
+}
+
+impl KeyValStore {
+    /// Remove the key if it has expired
+    fn remove_if_expired(&mut self, key: &[u8]) {
+        if self.data.get(key).is_some_and(RedisValue::is_expired) {
+            self.remove(key);
+            self.notify(EventClass::Expired, "expired", key);
+        }
+    }
+
+    /// Get the value of a key which hasn't expired
+    pub fn get(&mut self, key: &[u8]) -> Option<&RedisType> {
+        self.remove_if_expired(key);
+        let redis_val = self.data.get_mut(key)?;
+        redis_val.access.touch();
+        Some(&redis_val.data)
+    }
+
+    /// Get the value of a key which hasn't expired along with its last access, without counting as an access
+    pub fn peek(&mut self, key: &[u8]) -> Option<(&RedisType, KeyAccess)> {
+        self.remove_if_expired(key);
+        self.data
+            .get(key)
+            .map(|redis_val| (&redis_val.data, redis_val.access))
+    }
+
+    /// Get the mutable value of a key which hasn't expired
+    /// This counts as a modification of the key, so it must only be used by writes.
+    fn get_mut(&mut self, key: &[u8]) -> Option<&mut RedisType> {
+        self.remove_if_expired(key);
+        let redis_val = self.data.get_mut(key)?;
+        // The value was last written before this, so it is converted now if the last write made it outgrow its
+        // encoding
+        redis_val.update_encoding(&self.listpack_limits);
+        redis_val.access.touch();
+        if let Some(watched_key) = self.watched_keys.get_mut(key) {
+            watched_key.version += 1;
+        }
+        if !self.resized_keys.contains(key) {
+            self.resized_keys.insert(key.to_owned());
+        }
+        Some(&mut redis_val.data)
+    }
+
+    /// Get the mutable value of a key; if the key doesn't exist (or has expired) then create it without any TTL
+    fn get_or_insert_with(
+        &mut self,
+        key: &[u8],
+        default: impl FnOnce() -> RedisType,
+    ) -> &mut RedisType {
+        self.remove_if_expired(key);
+        self.touch(key);
+        if !self.data.contains_key(key) {
+            self.scan_index.insert(key);
+            self.all_keys.insert(key);
+        }
+        if !self.resized_keys.contains(key) {
+            self.resized_keys.insert(key.to_owned());
+        }
+        let limits = &self.listpack_limits;
+        let redis_val = self
+            .data
+            .entry(key.to_owned())
+            .and_modify(|redis_val| {
+                redis_val.update_encoding(limits);
+                redis_val.access.touch();
+            })
+            .or_insert_with(|| RedisValue::new(default(), None, limits));
+        &mut redis_val.data
+    }
+
+    /// Get the value of a key which hasn't expired, provided that it is of the type `T`
+    pub fn get_typed<T: TypedValue>(&mut self, key: &[u8]) -> Result<Option<&T>, &'static str> {
+        self.get(key)
+            .map(|data| T::from_value(data).ok_or(WRONGTYPE))
+            .transpose()
+    }
+
+    /// Get the values of several keys which haven't expired, provided that all of them are of the type `T`
+    pub fn get_many_typed<T: TypedValue>(
+        &mut self,
+        keys: &[Vec<u8>],
+    ) -> Result<Vec<Option<&T>>, &'static str> {
+        // Expired keys are removed first, as that needs the store to be mutable
+        for key in keys {
+            self.get_typed::<T>(key)?;
+        }
+        Ok(keys
+            .iter()
+            .map(|key| {
+                self.data
+                    .get(key)
+                    .and_then(|redis_val| T::from_value(&redis_val.data))
+            })
+            .collect())
+    }
+
+    /// Get the mutable value of a key which hasn't expired, provided that it is of the type `T`
+    /// Like `get_mut`, this counts as a modification of the key unless the type doesn't match.
+    pub fn get_typed_mut<T: TypedValue>(
+        &mut self,
+        key: &[u8],
+    ) -> Result<Option<&mut T>, &'static str> {
+        if self.get_typed::<T>(key)?.is_none() {
+            return Ok(None);
+        }
+        Ok(self.get_mut(key).and_then(T::from_value_mut))
+    }
+
+    /// Get the mutable value of a key, provided that it is of the type `T`; if the key doesn't exist (or has
+    /// expired) then create it empty without any TTL
+    /// A key of a different type is left untouched, so that a failing command doesn't modify it.
+    pub fn get_or_insert_typed<T: TypedValue>(
+        &mut self,
+        key: &[u8],
+    ) -> Result<&mut T, &'static str> {
+        self.get_typed::<T>(key)?;
+        let data = self.get_or_insert_with(key, || T::default().into_value());
+        Ok(T::from_value_mut(data).unwrap())
+    }
+
+    /// Get the absolute expiry time of the key, if any
+    /// This doesn't check whether the key has already expired
+    pub fn expires_at(&self, key: &[u8]) -> Option<SystemTime> {
+        self.data
+            .get(key)
+            .and_then(|redis_val| redis_val.expires_at)
+    }
+
+    /// Set or remove the TTL of an existing key, returning whether the key exists
+    pub fn set_expires_at(&mut self, key: &[u8], expires_at: Option<SystemTime>) -> bool {
+        self.remove_if_expired(key);
+        let Some(redis_val) = self.data.get_mut(key) else {
+            return false;
+        };
+        redis_val.expires_at = expires_at;
+        self.touch(key);
+        if expires_at.is_some() {
+            self.volatile_keys.insert(key);
+        } else {
+            self.volatile_keys.remove(key);
+        }
+        true
+    }
+
+    /// Insert the key, overwriting the value and the TTL if the key already exists
+    pub fn insert(&mut self, key: Vec<u8>, data: RedisType, expires_at: Option<SystemTime>) {
+        self.touch(&key);
+        if expires_at.is_some() {
+            self.volatile_keys.insert(&key);
+        } else {
+            self.volatile_keys.remove(&key);
+        }
+        if !self.data.contains_key(&key) {
+            self.scan_index.insert(&key);
+            self.all_keys.insert(&key);
+        }
+        self.resized_keys.remove(&key);
+        let mut redis_val = RedisValue::new(data, expires_at, &self.listpack_limits);
+        redis_val.size = eviction::estimated_size(&key, &redis_val.data);
+        self.used_memory += redis_val.size;
+        if let Some(old_val) = self.data.insert(key, redis_val) {
+            self.used_memory -= old_val.size;
+        }
+    }
+
+    /// Remove the key along with its TTL, returning its value
+    pub fn remove(&mut self, key: &[u8]) -> Option<RedisType> {
+        self.volatile_keys.remove(key);
+        let redis_val = self.data.remove(key)?;
+        self.scan_index.remove(key);
+        self.all_keys.remove(key);
+        self.resized_keys.remove(key);
+        self.used_memory -= redis_val.size;
+        self.touch(key);
+        Some(redis_val.data)
+    }
+
+    /// Remove all the keys along with their TTLs, returning their values so that the caller decides where they are
+    /// freed
+    pub fn clear(&mut self) -> Vec<RedisType> {
+        for (key, watched_key) in &mut self.watched_keys {
+            if self.data.contains_key(key) {
+                watched_key.version += 1;
+            }
+        }
+        self.scan_index.clear();
+        self.volatile_keys.clear();
+        self.all_keys.clear();
+        self.used_memory = 0;
+        self.resized_keys.clear();
+        self.data
+            .drain()
+            .map(|(_, redis_val)| redis_val.data)
+            .collect()
+    }
+
+    /// Swap all the keys along with their TTLs with those of another store, as SWAPDB does
+    /// The watchers, parameters and recorded events stay with their store; a watched key counts as modified if it
+    /// exists on either side.
+    pub fn swap_keys(&mut self, other: &mut Self) {
+        self.touch_watched_keys(&other.data);
+        other.touch_watched_keys(&self.data);
+        mem::swap(&mut self.data, &mut other.data);
+        mem::swap(&mut self.volatile_keys, &mut other.volatile_keys);
+        mem::swap(&mut self.all_keys, &mut other.all_keys);
+        mem::swap(&mut self.used_memory, &mut other.used_memory);
+        mem::swap(&mut self.resized_keys, &mut other.resized_keys);
+        mem::swap(&mut self.scan_index, &mut other.scan_index);
+    }
+
+    /// Copy of all the keys which haven't expired
+    pub fn snapshot(&self) -> Vec<Entry> {
+        self.data
+            .iter()
+            .filter(|&(_, redis_val)| !redis_val.is_expired())
+            .map(|(key, redis_val)| (key.clone(), redis_val.data.clone(), redis_val.expires_at))
+            .collect()
+    }
+
+    /// Keys which haven't expired and match the glob-style pattern, in no particular order
+    /// This goes through the whole keyspace, so like Redis's KEYS it is meant for debugging rather than production.
+    pub fn keys(&self, pattern: &[u8]) -> Vec<Vec<u8>> {
+        self.data
+            .iter()
+            .filter(|&(key, redis_val)| !redis_val.is_expired() && glob::matches(pattern, key))
+            .map(|(key, _)| key.clone())
+            .collect()
+    }
+
+    /// Next batch of keys from the SCAN cursor which haven't expired and pass the filter, along with the cursor of
+    /// the following batch
+    pub fn scan(
+        &self,
+        cursor: u64,
+        count: usize,
+        filter: impl Fn(&[u8], &RedisType) -> bool,
+    ) -> (u64, Vec<Vec<u8>>) {
+        let (cursor, keys) = self.scan_index.scan(cursor, count);
+        let keys = keys
+            .into_iter()
+            .filter(|&key| {
+                let redis_val = &self.data[key];
+                !redis_val.is_expired() && filter(key, &redis_val.data)
+            })
+            .map(<[u8]>::to_vec)
+            .collect();
+        (cursor, keys)
+    }
+
+    /// Number of keys in the store; this includes the expired keys which haven't been removed yet
+    pub fn len(&self) -> usize {
+        self.data.len()
+    }
+
+    /// Number of keys which haven't expired, as replied by DBSIZE
+    /// This takes linear time in the number of keys with a TTL, as the expired ones are counted out.
+    pub fn dbsize(&self) -> usize {
+        let expired_count = self
+            .volatile_keys
+            .keys
+            .iter()
+            .filter(|&key| self.data[key].is_expired())
+            .count();
+        self.data.len() - expired_count
+    }
+
+    /// A key picked at random among the ones which haven't expired, unless there are none
+    /// The expired keys which are picked are removed along the way; this doesn't count as an access of the key.
+    pub fn random_key(&mut self) -> Option<Vec<u8>> {
+        let mut rng = rand::rng();
+        loop {
+            let key = self.all_keys.random(&mut rng)?.clone();
+            if !self.data[&key].is_expired() {
+                return Some(key);
+            }
+            self.remove(&key);
+            self.notify(EventClass::Expired, "expired", &key);
+        }
+    }
+
+    /// Number of keys with a TTL; like `len`, this includes the expired keys which haven't been removed yet
+    pub const fn volatile_len(&self) -> usize {
+        self.volatile_keys.len()
+    }
+
+    /// Estimated memory used by the keys, estimating again the keys written since they last were
+    /// Only the sizes of the written keys change in the sum, and each of them is estimated in constant time, so this
+    /// costs no more than the writes themselves.
+    pub fn used_memory(&mut self) -> usize {
+        for key in mem::take(&mut self.resized_keys) {
+            if let Some(redis_val) = self.data.get_mut(&key) {
+                let size = eviction::estimated_size(&key, &redis_val.data);
+                self.used_memory = self.used_memory - redis_val.size + size;
+                redis_val.size = size;
+            }
+        }
+        self.used_memory
+    }
+
+    /// Randomly sample keys which the policy may evict, along with how good a candidate each of them is, the higher
+    /// the better
+    /// Expired keys are left out, as their removal frees memory anyway.
+    pub fn sample_for_eviction(
+        &self,
+        policy: MaxMemoryPolicy,
+        sample_size: usize,
+    ) -> Vec<(u128, Vec<u8>)> {
+        let keys = if policy.is_volatile() {
+            &self.volatile_keys
+        } else {
+            &self.all_keys
+        };
+        keys.sample(sample_size, &mut rand::rng())
+            .filter_map(|key| {
+                let redis_val = &self.data[key];
+                if redis_val.is_expired() {
+                    return None;
+                }
+                let score =
+                    eviction::eviction_score(policy, &redis_val.access, redis_val.expires_at)?;
+                Some((score, key.clone()))
+            })
+            .collect()
+    }
+
+    /// Start versioning the key for a client watching it, returning its current version
+    pub fn watch(&mut self, key: &[u8]) -> u64 {
+        let watched_key = self
+            .watched_keys
+            .entry(key.to_owned())
+            .or_insert(WatchedKey {
+                version: 0,
+                watchers: 0,
+            });
+        watched_key.watchers += 1;
+        watched_key.version
+    }
+
+    /// Stop versioning the key for a client which was watching it
+    pub fn unwatch(&mut self, key: &[u8]) {
+        if let Some(watched_key) = self.watched_keys.get_mut(key) {
+            watched_key.watchers -= 1;
+            if watched_key.watchers == 0 {
+                self.watched_keys.remove(key);
+            }
+        }
+    }
+
+    /// Get the current version of a watched key
+    /// An expired key is removed first, as its expiry counts as a modification.
+    pub fn version(&mut self, key: &[u8]) -> u64 {
+        self.remove_if_expired(key);
+        self.watched_keys
+            .get(key)
+            .map_or(0, |watched_key| watched_key.version)
+    }
+
+    /// Encoding of the value of a key which hasn't expired
+    pub fn encoding(&mut self, key: &[u8]) -> Option<Encoding> {
+        self.remove_if_expired(key);
+        let redis_val = self.data.get_mut(key)?;
+        redis_val.update_encoding(&self.listpack_limits);
+        Some(redis_val.encoding)
+    }
+
+    /// Set the limits of the listpack encoding, which apply from the next write of every value
+    pub const fn set_listpack_limits(&mut self, listpack_limits: ListpackLimits) {
+        self.listpack_limits = listpack_limits;
+    }
+
+    /// Set the classes of the keyspace events which are recorded
+    pub const fn set_notify_flags(&mut self, notify_flags: NotifyFlags) {
+        self.notify_flags = notify_flags;
+    }
+
+    /// Record a keyspace event for the key, if its class is enabled
+    pub fn notify(&mut self, class: EventClass, event: &'static str, key: &[u8]) {
+        if self.notify_flags.allows(class) {
+            self.events.push(KeyspaceEvent {
+                event,
+                key: key.to_owned(),
+            });
+        }
+    }
+
+    /// Take the keyspace events recorded since this was last called, along with the flags to publish them with
+    pub fn take_events(&mut self) -> (NotifyFlags, Vec<KeyspaceEvent>) {
+        (self.notify_flags, mem::take(&mut self.events))
+    }
+
+    /// Bump the version of the key, if it is watched, as it got modified
+    fn touch(&mut self, key: &[u8]) {
+        if let Some(watched_key) = self.watched_keys.get_mut(key) {
+            watched_key.version += 1;
+        }
+    }
+
+    /// Bump the version of every watched key which exists in this store or among the other keys
+    fn touch_watched_keys(&mut self, other_data: &HashMap<Vec<u8>, RedisValue>) {
+        for (key, watched_key) in &mut self.watched_keys {
+            if self.data.contains_key(key) || other_data.contains_key(key) {
+                watched_key.version += 1;
+            }
+        }
+    }
+
+    /// Randomly sample the keys having a TTL and remove the ones which have expired
+    /// Returns the number of keys sampled and the number of keys removed
+    fn remove_expired_sample(&mut self, sample_size: usize) -> (usize, usize) {
+        let mut rng = rand::rng();
+        let sample_size = sample_size.min(self.volatile_keys.len());
+        let mut expired_count = 0;
+
+        for _ in 0..sample_size {
+            // Sampling is done with replacement, which is fine for estimating the fraction of expired keys
+            let Some(key) = self.volatile_keys.random(&mut rng) else {
+                break;
+            };
+            if self.data.get(key).is_some_and(RedisValue::is_expired) {
+                let key = key.clone();
+                self.remove(&key);
+                self.notify(EventClass::Expired, "expired", &key);
+                expired_count += 1;
+            }
+        }
+        (sample_size, expired_count)
+    }
+}
+
+/// Copy of all the keys of every database which haven't expired, by database index
+pub fn snapshot_databases(databases: &[Arc<Mutex<KeyValStore>>]) -> Vec<Vec<Entry>> {
+    databases
+        .iter()
+        .map(|store| store.lock().unwrap().snapshot())
+        .collect()
+}
+
+/// Periodically remove the expired keys of every database ("ACTIVE EXPIRY"), until a shutdown is signalled
+/// Procedure (similar to Redis), for every database in turn-
+/// 1) Randomly sample 20 keys having a TTL.
+/// 2) Remove the sampled keys which have expired, handing their keyspace events over to `publish_events` along with
+///    the index of the database.
+/// 3) If more than 25% of the sampled keys had expired, then repeat from step 1, as many more keys are likely expired.
+///    This is bounded by a time budget shared by all the databases, so that the stores aren't held for too long.
+/// 4) Sleep for some time and repeat from step 1.
+///
+/// Cycles are skipped while `is_enabled` returns false, leaving the expired keys to be removed when accessed.
+pub async fn delete_expired_keys(
+    databases: Vec<Arc<Mutex<KeyValStore>>>,
+    publish_events: impl Fn(usize, NotifyFlags, Vec<KeyspaceEvent>) + Send,
+    is_enabled: impl Fn() -> bool + Send,
+    mut shutdown: watch::Receiver<()>,
+) {
+    let mut interval = time::interval(ACTIVE_EXPIRY_INTERVAL);
+    loop {
+        tokio::select! {
+            _ = interval.tick() => {}
+            _ = shutdown.changed() => return,
+        }
+        if !is_enabled() {
+            continue;
+        }
+
+        let cycle_start = Instant::now();
+        for (db, redis_key_val_store) in databases.iter().enumerate() {
+            loop {
+                // The lock is taken for every round, so that clients can make progress in between
+                let mut store = redis_key_val_store.lock().unwrap();
+                let (sampled, expired) = store.remove_expired_sample(ACTIVE_EXPIRY_SAMPLE_SIZE);
+                let (notify_flags, events) = store.take_events();
+                drop(store);
+                publish_events(db, notify_flags, events);
+
+                if sampled == 0
+                    || expired * 4 <= sampled
+                    || cycle_start.elapsed() > ACTIVE_EXPIRY_CYCLE_BUDGET
+                {
+                    break;
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,959 @@
+//! Stream data type, i.e. an append-only log of entries, and the commands on it
+//! Entries are identified by `<milliseconds>-<sequence>` IDs which only increase; consumer groups are in
+//! `consumer_group`.
+
+use std::{
+    collections::BTreeMap,
+    fmt,
+    ops::Bound,
+    str,
+    sync::{Arc, Mutex},
+    time::{Duration, SystemTime},
+};
+
+use crate::{
+    blocking::{BlockedClient, BlockedClients, Pop, Poppable},
+    command::WRONG_ARITY,
+    consumer_group::ConsumerGroup,
+    notify::EventClass,
+    parse_redis_int,
+    resp::RespValue,
+    store::KeyValStore,
+    unix_time_ms,
+};
+
+/// Error of an argument which isn't a valid ID
+const INVALID_ID: &str = "ERR Invalid stream ID specified as stream command argument";
+/// Error of a count which isn't an integer
+pub const INVALID_COUNT: &str = "ERR value is not an integer or out of range";
+/// Error of an ID given to XADD which doesn't come after the last entry
+const ID_TOO_SMALL: &str =
+    "ERR The ID specified in XADD is equal or smaller than the target stream top item";
+/// Error of a command on a stream which requires the key to exist
+const NO_KEY: &str = "ERR no such key";
+/// Number of entries per node of a stream, as limited by the default `stream-node-max-entries` of Redis; approximate
+/// trimming only removes whole nodes
+pub const STREAM_NODE_MAX_ENTRIES: usize = 100;
+
+/// ID of an entry: the Unix time in milliseconds at which it was added, followed by a sequence number telling apart
+/// the entries added within the same millisecond
+#[derive(Clone, Copy, Default, PartialEq, Eq, PartialOrd, Ord)]
+pub struct StreamId {
+    /// Milliseconds part
+    pub ms: u64,
+    /// Sequence part
+    pub seq: u64,
+}
+
+impl StreamId {
+    /// Smallest ID, which no entry can have
+    pub const MIN: Self = Self { ms: 0, seq: 0 };
+    /// Greatest ID
+    pub const MAX: Self = Self {
+        ms: u64::MAX,
+        seq: u64::MAX,
+    };
+
+    /// Parse an ID `ms-seq`, or `ms` alone in which case the sequence number is `missing_seq`
+    pub fn parse(arg: &[u8], missing_seq: u64) -> Result<Self, &'static str> {
+        let arg = str::from_utf8(arg).map_err(|_| INVALID_ID)?;
+        let parse_part = |part: &str| {
+            // Unlike the parsing of Rust, Redis doesn't allow a leading `+`
+            part.bytes()
+                .all(|byte| byte.is_ascii_digit())
+                .then(|| part.parse().ok())
+                .flatten()
+                .ok_or(INVALID_ID)
+        };
+        match arg.split_once('-') {
+            Some((ms, seq)) => Ok(Self {
+                ms: parse_part(ms)?,
+                seq: parse_part(seq)?,
+            }),
+            None => Ok(Self {
+                ms: parse_part(arg)?,
+                seq: missing_seq,
+            }),
+        }
+    }
+
+    /// The ID in its textual form
+    pub fn to_bytes(self) -> Vec<u8> {
+        self.to_string().into_bytes()
+    }
+}
+
+impl fmt::Display for StreamId {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}-{}", self.ms, self.seq)
+    }
+}
+
+/// Field/value pairs of an entry, in the order they were given
+pub type Fields = Vec<(Vec<u8>, Vec<u8>)>;
+
+/// Entries ordered by their IDs, which only ever grow
+#[derive(Clone, Default)]
+pub struct Stream {
+    /// Fields of every entry by its ID
+    entries: BTreeMap<StreamId, Fields>,
+    /// ID of the last entry ever added, which the IDs of the next entries must be greater than
+    last_id: StreamId,
+    /// Number of entries ever added
+    entries_added: u64,
+    /// Greatest ID of the entries removed by XDEL
+    max_deleted_id: StreamId,
+    /// Consumer groups by their names
+    groups: BTreeMap<Vec<u8>, ConsumerGroup>,
+}
+
+/// ID given to XADD for the new entry
+#[derive(Clone, Copy)]
+enum NewId {
+    /// `*`: generated from the current time
+    Auto,
+    /// `ms-*`: the milliseconds are given, and the sequence number is generated
+    AutoSeq(u64),
+    /// `ms-seq`, or `ms` alone for a sequence number of 0
+    Explicit(StreamId),
+}
+
+impl NewId {
+    /// Parse the ID argument of XADD
+    fn parse(arg: &[u8]) -> Result<Self, &'static str> {
+        if arg == b"*" {
+            return Ok(Self::Auto);
+        }
+        if let Some(ms) = arg.strip_suffix(b"-*") {
+            return StreamId::parse(ms, 0).and_then(|id| {
+                // `ms-seq-*` isn't valid
+                if ms.contains(&b'-') {
+                    Err(INVALID_ID)
+                } else {
+                    Ok(Self::AutoSeq(id.ms))
+                }
+            });
+        }
+        StreamId::parse(arg, 0).map(Self::Explicit)
+    }
+}
+
+/// Threshold up to which trimming removes the oldest entries
+#[derive(Clone, Copy)]
+enum TrimThreshold {
+    /// `MAXLEN`: maximum number of entries kept
+    MaxLen(usize),
+    /// `MINID`: smallest ID of the entries kept
+    MinId(StreamId),
+}
+
+/// Trimming given to XTRIM or XADD
+#[derive(Clone, Copy)]
+struct Trim {
+    /// Threshold up to which entries are removed
+    threshold: TrimThreshold,
+    /// `~`: whether only whole nodes of `STREAM_NODE_MAX_ENTRIES` entries are removed, so that more entries than the
+    /// threshold may be kept
+    is_approximate: bool,
+    /// `LIMIT`: maximum number of entries removed when approximate, 0 for no limit
+    limit: usize,
+}
+
+impl Trim {
+    /// Parse the trimming options at the start of the arguments, up to the first argument which isn't one, returning
+    /// the trimming if any along with the number of arguments taken
+    /// `MAXLEN` or `MINID` is followed by `=` or `~` if any and the threshold, and `LIMIT` by a count.
+    fn parse(args: &[Vec<u8>]) -> Result<(Option<Self>, usize), &'static str> {
+        let mut trim: Option<Self> = None;
+        let mut limit = None;
+        let mut taken = 0;
+        while let Some(option) = args.get(taken) {
+            let option = String::from_utf8_lossy(option).to_lowercase();
+            match option.as_str() {
+                "maxlen" | "minid" => {
+                    let is_max_len = option == "maxlen";
+                    if trim.is_some_and(|trim| {
+                        matches!(trim.threshold, TrimThreshold::MaxLen(_)) != is_max_len
+                    }) {
+                        return Err("ERR syntax error, MAXLEN and MINID options at the same time are not compatible");
+                    }
+                    let operator = args.get(taken + 1).map(Vec::as_slice);
+                    let is_approximate = operator == Some(b"~");
+                    taken += if matches!(operator, Some(b"~" | b"=")) {
+                        2
+                    } else {
+                        1
+                    };
+                    let threshold = args.get(taken).ok_or("ERR syntax error")?;
+                    let threshold = if is_max_len {
+                        let max_len = parse_redis_int(threshold).ok_or(INVALID_COUNT)?;
+                        TrimThreshold::MaxLen(
+                            usize::try_from(max_len)
+                                .map_err(|_| "ERR The MAXLEN argument must be >= 0.")?,
+                        )
+                    } else {
+                        TrimThreshold::MinId(StreamId::parse(threshold, 0)?)
+                    };
+                    trim = Some(Self {
+                        threshold,
+                        is_approximate,
+                        limit: 0,
+                    });
+                    taken += 1;
+                }
+                "limit" => {
+                    let count = args.get(taken + 1).ok_or("ERR syntax error")?;
+                    let count = parse_redis_int(count).ok_or(INVALID_COUNT)?;
+                    limit = Some(
+                        usize::try_from(count)
+                            .map_err(|_| "ERR The LIMIT argument must be >= 0.")?,
+                    );
+                    taken += 2;
+                }
+                _ => break,
+            }
+        }
+        let Some(mut trim) = trim else {
+            return match limit {
+                Some(_) => Err(
+                    "ERR syntax error, LIMIT cannot be used without specifying a trimming strategy",
+                ),
+                None => Ok((None, taken)),
+            };
+        };
+        trim.limit = match limit {
+            Some(_) if !trim.is_approximate => {
+                return Err("ERR syntax error, LIMIT cannot be used without the special ~ option");
+            }
+            Some(limit) => limit,
+            // Approximate trimming is limited by default, so that a single command doesn't remove too many entries
+            None if trim.is_approximate => 100 * STREAM_NODE_MAX_ENTRIES,
+            None => 0,
+        };
+        Ok((Some(trim), taken))
+    }
+}
+
+impl Stream {
+    /// Assemble a stream from its entries, the ID of its last entry ever added, the number of entries ever added,
+    /// the greatest ID of the entries deleted and its consumer groups
+    pub const fn from_parts(
+        entries: BTreeMap<StreamId, Fields>,
+        last_id: StreamId,
+        entries_added: u64,
+        max_deleted_id: StreamId,
+        groups: BTreeMap<Vec<u8>, ConsumerGroup>,
+    ) -> Self {
+        Self {
+            entries,
+            last_id,
+            entries_added,
+            max_deleted_id,
+            groups,
+        }
+    }
+
+    /// Number of entries
+    pub fn len(&self) -> usize {
+        self.entries.len()
+    }
+
+    /// All the entries in the order of their IDs
+    pub fn iter(&self) -> impl Iterator<Item = (&StreamId, &Fields)> {
+        self.entries.iter()
+    }
+
+    /// ID of the last entry ever added
+    pub const fn last_id(&self) -> StreamId {
+        self.last_id
+    }
+
+    /// Number of entries ever added
+    pub const fn entries_added(&self) -> u64 {
+        self.entries_added
+    }
+
+    /// Greatest ID of the entries deleted, 0-0 if none was
+    pub const fn max_deleted_id(&self) -> StreamId {
+        self.max_deleted_id
+    }
+
+    /// Consumer groups by their names
+    pub const fn groups(&self) -> &BTreeMap<Vec<u8>, ConsumerGroup> {
+        &self.groups
+    }
+
+    /// Consumer groups by their names, which may be changed
+    pub const fn groups_mut(&mut self) -> &mut BTreeMap<Vec<u8>, ConsumerGroup> {
+        &mut self.groups
+    }
+
+    /// Consumer group with the name along with the entries, so that entries can be delivered to its consumers
+    pub fn group_with_entries(
+        &mut self,
+        name: &[u8],
+    ) -> Option<(&mut ConsumerGroup, &BTreeMap<StreamId, Fields>)> {
+        let group = self.groups.get_mut(name)?;
+        Some((group, &self.entries))
+    }
+
+    /// ID of the new entry, which must come after the last entry ever added
+    /// `now_ms` is the current Unix time in milliseconds, from which `*` is generated.
+    fn next_id(&self, new_id: NewId, now_ms: u64) -> Result<StreamId, &'static str> {
+        let last_id = self.last_id;
+        let id = match new_id {
+            // The clock may go backwards, in which case the milliseconds of the last entry are reused
+            NewId::Auto if now_ms > last_id.ms => StreamId { ms: now_ms, seq: 0 },
+            NewId::Auto => last_id
+                .seq
+                .checked_add(1)
+                .map(|seq| StreamId {
+                    ms: last_id.ms,
+                    seq,
+                })
+                .or_else(|| last_id.ms.checked_add(1).map(|ms| StreamId { ms, seq: 0 }))
+                .ok_or(
+                    "ERR The stream has exhausted the last possible ID, unable to add more items",
+                )?,
+            NewId::AutoSeq(ms) if ms == last_id.ms => StreamId {
+                ms,
+                seq: last_id.seq.checked_add(1).ok_or(ID_TOO_SMALL)?,
+            },
+            NewId::AutoSeq(ms) => StreamId { ms, seq: 0 },
+            NewId::Explicit(id) => id,
+        };
+        if id == StreamId::MIN {
+            return Err("ERR The ID specified in XADD must be greater than 0-0");
+        }
+        if id <= last_id {
+            return Err(ID_TOO_SMALL);
+        }
+        Ok(id)
+    }
+
+    /// Append an entry with an ID greater than the last one
+    pub fn append(&mut self, id: StreamId, fields: Fields) {
+        self.entries.insert(id, fields);
+        self.last_id = id;
+        self.entries_added += 1;
+    }
+
+    /// Remove the entry with the ID, returning whether there was one
+    fn delete(&mut self, id: StreamId) -> bool {
+        let is_deleted = self.entries.remove(&id).is_some();
+        if is_deleted {
+            self.max_deleted_id = self.max_deleted_id.max(id);
+        }
+        is_deleted
+    }
+
+    /// Remove the oldest entries as the trimming tells, returning the number of entries removed
+    /// Unlike XDEL, trimming doesn't change the greatest deleted ID, same as Redis.
+    fn trim(&mut self, trim: Trim) -> usize {
+        let excess = match trim.threshold {
+            TrimThreshold::MaxLen(max_len) => self.len().saturating_sub(max_len),
+            TrimThreshold::MinId(min_id) => self.entries.range(..min_id).count(),
+        };
+        let removed = if trim.is_approximate {
+            // The nodes are counted from the oldest entry, and a node which doesn't fit within the limit stays
+            let limit = if trim.limit == 0 {
+                usize::MAX
+            } else {
+                trim.limit
+            };
+            let removed = excess.min(limit);
+            removed - removed % STREAM_NODE_MAX_ENTRIES
+        } else {
+            excess
+        };
+        for _ in 0..removed {
+            self.entries.pop_first();
+        }
+        removed
+    }
+
+    /// Entries with IDs greater than the given one, in the order of their IDs
+    fn after(&self, id: StreamId) -> impl Iterator<Item = (&StreamId, &Fields)> {
+        self.entries.range((Bound::Excluded(id), Bound::Unbounded))
+    }
+
+    /// Entries with IDs from `start` to `end` inclusive, in the order of their IDs
+    pub fn range(
+        &self,
+        start: StreamId,
+        end: StreamId,
+    ) -> impl Iterator<Item = (&StreamId, &Fields)> {
+        // An empty range rather than a panic when the bounds are crossed
+        (start <= end)
+            .then(|| self.entries.range(start..=end))
+            .into_iter()
+            .flatten()
+    }
+}
+
+/// A stream hands over the ID of its last entry to every client blocked on it by XREAD, without consuming anything;
+/// every new entry thus serves all of them
+impl Poppable for Stream {
+    fn is_empty(&self) -> bool {
+        self.entries.is_empty()
+    }
+
+    fn pop_for(&mut self, pop: Pop) -> Option<(Vec<u8>, Option<f64>)> {
+        match pop {
+            Pop::Stream => Some((self.last_id.to_bytes(), None)),
+            Pop::List(_) | Pop::SortedSet(_) => None,
+        }
+    }
+
+    fn put_back(&mut self, _pop: Pop, _last_id: Vec<u8>, _score: Option<f64>) {}
+}
+
+/// Current Unix time in milliseconds
+pub fn now_ms() -> u64 {
+    // The clock isn't before the epoch on any sane system
+    u64::try_from(unix_time_ms(SystemTime::now())).unwrap_or(0)
+}
+
+/// Convert an entry to RESP, as its ID followed by a flat array of its fields and values
+fn entry_resp(id: StreamId, fields: Fields) -> RespValue {
+    RespValue::Array(vec![
+        RespValue::BulkString(id.to_bytes()),
+        RespValue::bulk_string_array(fields.into_iter().flat_map(<[_; 2]>::from)),
+    ])
+}
+
+/// Convert entries to RESP, each as its ID followed by a flat array of its fields and values
+pub fn entries_resp(entries: Vec<(StreamId, Fields)>) -> RespValue {
+    RespValue::Array(
+        entries
+            .into_iter()
+            .map(|(id, fields)| entry_resp(id, fields))
+            .collect(),
+    )
+}
+
+/// Parse the trimming options of XADD, which come between the key and the ID, returning the trimming if any along
+/// with the position of the ID
+fn parse_xadd_options(parsed_command: &[Vec<u8>]) -> Result<(Option<Trim>, usize), &'static str> {
+    let (trim, taken) = Trim::parse(parsed_command.get(2..).unwrap_or_default())?;
+    Ok((trim, 2 + taken))
+}
+
+/// XADD: append an entry with the field/value pairs, creating the stream if the key doesn't exist, returning the ID
+/// of the entry
+/// With `MAXLEN` or `MINID`, the stream is then trimmed same as XTRIM. The clients blocked on the stream by XREAD
+/// are then served.
+pub fn xadd(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    blocked_clients: &Mutex<BlockedClients>,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+) -> Result<StreamId, &'static str> {
+    if parsed_command.len() < 5 {
+        return Err(WRONG_ARITY);
+    }
+    let (trim, id_position) = parse_xadd_options(parsed_command)?;
+    let fields = parsed_command.get(id_position + 1..).unwrap_or_default();
+    if fields.is_empty() || !fields.len().is_multiple_of(2) {
+        return Err(WRONG_ARITY);
+    }
+    let new_id = NewId::parse(&parsed_command[id_position])?;
+    let fields = fields
+        .chunks_exact(2)
+        .map(|pair| (pair[0].clone(), pair[1].clone()))
+        .collect();
+    let now_ms = now_ms();
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    // The ID is checked before creating the stream, so that a bad ID doesn't leave an empty stream behind
+    let id = match store.get_typed::<Stream>(&parsed_command[1])? {
+        Some(stream) => stream.next_id(new_id, now_ms)?,
+        None => Stream::default().next_id(new_id, now_ms)?,
+    };
+    let stream = store.get_or_insert_typed::<Stream>(&parsed_command[1])?;
+    stream.append(id, fields);
+    let trimmed = trim.map_or(0, |trim| stream.trim(trim));
+    store.notify(EventClass::Stream, "xadd", &parsed_command[1]);
+    if trimmed > 0 {
+        store.notify(EventClass::Stream, "xtrim", &parsed_command[1]);
+    }
+    if let Ok(Some(stream)) = store.get_typed_mut::<Stream>(&parsed_command[1]) {
+        blocked_clients
+            .lock()
+            .unwrap()
+            .serve(db, &parsed_command[1], stream);
+    }
+    drop(store);
+    Ok(id)
+}
+
+/// XADD as propagated, i.e. with the generated ID in place of `*` or `ms-*`, so that replaying it adds the same
+/// entry; None if it failed
+pub fn xadd_with_id(parsed_command: &[Vec<u8>], reply: &RespValue) -> Option<Vec<Vec<u8>>> {
+    let RespValue::BulkString(ref id) = *reply else {
+        return None;
+    };
+    let (_, id_position) = parse_xadd_options(parsed_command).ok()?;
+    let mut command = parsed_command.to_vec();
+    command[id_position].clone_from(id);
+    Some(command)
+}
+
+/// XDEL: remove the entries with the IDs, returning the number of entries removed
+/// The stream stays even if it has no entry left, and so does the ID of its last entry ever added.
+pub fn xdel(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+    let ids = parsed_command[2..]
+        .iter()
+        .map(|id| StreamId::parse(id, 0))
+        .collect::<Result<Vec<_>, _>>()?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(stream) = store.get_typed_mut::<Stream>(&parsed_command[1])? else {
+        return Ok(0);
+    };
+    let deleted = ids.into_iter().filter(|&id| stream.delete(id)).count();
+    if deleted > 0 {
+        store.notify(EventClass::Stream, "xdel", &parsed_command[1]);
+    }
+    drop(store);
+    Ok(deleted)
+}
+
+/// XTRIM: remove the oldest entries, beyond the `MAXLEN` newest ones or with IDs lower than `MINID`, returning the
+/// number of entries removed
+/// With `~`, only whole nodes of entries are removed, up to `LIMIT` entries, so that more entries may be kept.
+pub fn xtrim(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 4 {
+        return Err(WRONG_ARITY);
+    }
+    let (trim, taken) = Trim::parse(&parsed_command[2..])?;
+    let Some(trim) = trim.filter(|_| 2 + taken == parsed_command.len()) else {
+        return Err("ERR syntax error");
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(stream) = store.get_typed_mut::<Stream>(&parsed_command[1])? else {
+        return Ok(0);
+    };
+    let trimmed = stream.trim(trim);
+    if trimmed > 0 {
+        store.notify(EventClass::Stream, "xtrim", &parsed_command[1]);
+    }
+    drop(store);
+    Ok(trimmed)
+}
+
+/// XSETID: set the ID of the last entry ever added, along with the number of entries ever added with
+/// `ENTRIESADDED` and the greatest deleted ID with `MAXDELETEDID`
+/// The ID can't be lower than that of the last entry of the stream, which must exist.
+pub fn xsetid(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<(), &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+    let last_id = StreamId::parse(&parsed_command[2], 0)?;
+    let (mut entries_added, mut max_deleted_id) = (None, None);
+    let mut options = &parsed_command[3..];
+    loop {
+        match *options {
+            [] => break,
+            [ref option, ref count, ref rest @ ..]
+                if option.eq_ignore_ascii_case(b"entriesadded") =>
+            {
+                let count = parse_redis_int(count).ok_or(INVALID_COUNT)?;
+                entries_added =
+                    Some(u64::try_from(count).map_err(|_| "ERR entries_added must be positive")?);
+                options = rest;
+            }
+            [ref option, ref id, ref rest @ ..] if option.eq_ignore_ascii_case(b"maxdeletedid") => {
+                let id = StreamId::parse(id, 0)?;
+                if id > last_id {
+                    return Err("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id");
+                }
+                max_deleted_id = Some(id);
+                options = rest;
+            }
+            _ => return Err("ERR syntax error"),
+        }
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let stream = store
+        .get_typed_mut::<Stream>(&parsed_command[1])?
+        .ok_or(NO_KEY)?;
+    if stream
+        .entries
+        .last_key_value()
+        .is_some_and(|(&id, _)| id > last_id)
+    {
+        return Err("ERR The ID specified in XSETID is smaller than the target stream top item");
+    }
+    if entries_added
+        .is_some_and(|entries_added| entries_added < u64::try_from(stream.len()).unwrap())
+    {
+        return Err(
+            "ERR The entries_added specified in XSETID is smaller than the target stream length",
+        );
+    }
+    stream.last_id = last_id;
+    stream.entries_added = entries_added.unwrap_or(stream.entries_added);
+    stream.max_deleted_id = max_deleted_id.unwrap_or(stream.max_deleted_id);
+    store.notify(EventClass::Stream, "xsetid", &parsed_command[1]);
+    drop(store);
+    Ok(())
+}
+
+/// Lines of XINFO HELP
+const XINFO_HELP: &[&str] = &["STREAM <key>", "    Show information about the stream."];
+
+/// XINFO STREAM/HELP: get information about a stream, i.e. its length, the ID of its last entry ever added, the
+/// number of entries ever added, the greatest deleted ID, its number of consumer groups and its first and last
+/// entries
+pub fn xinfo(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<RespValue, String> {
+    let Some(subcommand) = parsed_command.get(1) else {
+        return Err(WRONG_ARITY.to_owned());
+    };
+    let subcommand = String::from_utf8_lossy(subcommand).to_lowercase();
+    match (subcommand.as_str(), parsed_command.len()) {
+        ("help", 2) => return Ok(RespValue::help("XINFO", XINFO_HELP)),
+        ("stream", 3) => {}
+        ("stream", len) if len > 3 => return Err("ERR syntax error".to_owned()),
+        ("stream" | "help", _) => {
+            return Err(format!(
+                "ERR wrong number of arguments for 'xinfo|{subcommand}' command"
+            ))
+        }
+        _ => {
+            return Err(format!(
+                "ERR unknown subcommand '{}'. Try XINFO HELP.",
+                String::from_utf8_lossy(&parsed_command[1])
+            ))
+        }
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let stream = store
+        .get_typed::<Stream>(&parsed_command[2])?
+        .ok_or(NO_KEY)?;
+    let id = |id: StreamId| RespValue::BulkString(id.to_bytes());
+    let integer = |n: u64| RespValue::Integer(i64::try_from(n).unwrap());
+    let entry = |entry: Option<(&StreamId, &Fields)>| {
+        entry.map_or(RespValue::NullBulkString, |(&id, fields)| {
+            entry_resp(id, fields.clone())
+        })
+    };
+    let info = [
+        ("length", integer(u64::try_from(stream.len()).unwrap())),
+        ("last-generated-id", id(stream.last_id)),
+        ("max-deleted-entry-id", id(stream.max_deleted_id)),
+        ("entries-added", integer(stream.entries_added)),
+        (
+            "recorded-first-entry-id",
+            id(stream
+                .entries
+                .keys()
+                .next()
+                .copied()
+                .unwrap_or(StreamId::MIN)),
+        ),
+        (
+            "groups",
+            integer(u64::try_from(stream.groups.len()).unwrap()),
+        ),
+        ("first-entry", entry(stream.entries.first_key_value())),
+        ("last-entry", entry(stream.entries.last_key_value())),
+    ];
+    drop(store);
+    Ok(RespValue::Map(
+        info.into_iter()
+            .map(|(name, value)| (RespValue::BulkString(name.as_bytes().to_vec()), value))
+            .collect(),
+    ))
+}
+
+/// Parse the start and the end of a range of IDs as given to XRANGE
+/// `-` and `+` stand for the smallest and greatest IDs, and an ID without a sequence number covers all the entries
+/// of its millisecond.
+pub fn parse_range(start: &[u8], end: &[u8]) -> Result<(StreamId, StreamId), &'static str> {
+    let start = match start {
+        b"-" => StreamId::MIN,
+        start => StreamId::parse(start, 0)?,
+    };
+    let end = match end {
+        b"+" => StreamId::MAX,
+        end => StreamId::parse(end, u64::MAX)?,
+    };
+    Ok((start, end))
+}
+
+/// XRANGE: get the entries with IDs from the start to the end, inclusive, up to `COUNT` of them
+/// `-` and `+` stand for the smallest and greatest IDs, and an ID without a sequence number covers all the entries
+/// of its millisecond.
+pub fn xrange(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Vec<(StreamId, Fields)>, &'static str> {
+    let count = match *parsed_command {
+        [_, _, _, _] => None,
+        [_, _, _, _, ref option, ref count] if option.eq_ignore_ascii_case(b"count") => {
+            let count = parse_redis_int(count).ok_or(INVALID_COUNT)?;
+            // A negative count returns nothing, same as Redis
+            Some(usize::try_from(count).unwrap_or(0))
+        }
+        [_, _, _, _, ..] => return Err("ERR syntax error"),
+        _ => return Err(WRONG_ARITY),
+    };
+    let (start, end) = parse_range(&parsed_command[2], &parsed_command[3])?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let entries = store
+        .get_typed::<Stream>(&parsed_command[1])?
+        .map_or_else(Vec::new, |stream| {
+            stream
+                .range(start, end)
+                .take(count.unwrap_or(usize::MAX))
+                .map(|(&id, fields)| (id, fields.clone()))
+                .collect()
+        });
+    drop(store);
+    Ok(entries)
+}
+
+/// XLEN: get the number of entries
+pub fn xlen(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() != 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let len = store
+        .get_typed::<Stream>(&parsed_command[1])?
+        .map_or(0, Stream::len);
+    drop(store);
+    Ok(len)
+}
+
+/// Entries read by XREAD, along with the key of every stream they were read from
+pub type StreamsEntries = Vec<(Vec<u8>, Vec<(StreamId, Fields)>)>;
+
+/// Convert the entries read by XREAD to RESP, as the key of every stream followed by its entries
+pub fn streams_entries_resp(streams_entries: StreamsEntries) -> RespValue {
+    RespValue::Array(
+        streams_entries
+            .into_iter()
+            .map(|(key, entries)| {
+                RespValue::Array(vec![RespValue::BulkString(key), entries_resp(entries)])
+            })
+            .collect(),
+    )
+}
+
+/// Streams which XREAD reads from, along with the ID after which entries are read in every one of them
+pub struct StreamReads {
+    /// Maximum number of entries read from every stream, if any
+    count: Option<usize>,
+    /// Key of every stream along with the ID of the last entry already seen, in the order they were given
+    ids: Vec<(Vec<u8>, StreamId)>,
+}
+
+impl StreamReads {
+    /// Entries after the IDs of the streams whose key the filter accepts, leaving out the streams without any
+    fn read(&self, store: &mut KeyValStore, is_read: impl Fn(&[u8]) -> bool) -> StreamsEntries {
+        self.ids
+            .iter()
+            .filter(|pair| is_read(&pair.0))
+            .filter_map(|&(ref key, id)| {
+                // The key may hold another type by the time a blocked client is served
+                let stream = store.get_typed::<Stream>(key).ok().flatten()?;
+                let entries: Vec<_> = stream
+                    .after(id)
+                    .take(self.count.unwrap_or(usize::MAX))
+                    .map(|(&entry_id, fields)| (entry_id, fields.clone()))
+                    .collect();
+                (!entries.is_empty()).then(|| (key.clone(), entries))
+            })
+            .collect()
+    }
+
+    /// Entries of the stream at the key which served the client blocked by XREAD
+    /// They may be gone already if the key was removed right after being served.
+    pub fn read_served(
+        &self,
+        redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+        key: &[u8],
+    ) -> StreamsEntries {
+        let mut store = redis_key_val_store.lock().unwrap();
+        let streams_entries = self.read(&mut store, |read_key| read_key == key);
+        drop(store);
+        streams_entries
+    }
+}
+
+/// Outcome of XREAD or XREADGROUP before waiting for any new entry, where the client blocked reads as given by `R`
+/// once served
+pub enum Xread<R> {
+    /// Some streams have entries after the IDs
+    Read(StreamsEntries),
+    /// No stream has entries after the IDs, so wait for a new entry until the timeout, which is infinite if absent
+    Blocked(BlockedClient, Option<Duration>, R),
+    /// No stream has entries after the IDs, and either BLOCK isn't given or the client isn't allowed to block
+    Empty,
+}
+
+/// Options and streams of XREAD and XREADGROUP
+pub struct ReadArgs<'a> {
+    /// Maximum number of entries read from every stream, if any
+    pub count: Option<usize>,
+    /// Whether `BLOCK` is given, so that the client waits for a new entry when there is none right away
+    pub is_blocking: bool,
+    /// Timeout of `BLOCK`, which is infinite if absent
+    pub timeout: Option<Duration>,
+    /// Names of the group and the consumer given by `GROUP`
+    pub group: Option<(&'a [u8], &'a [u8])>,
+    /// Whether `NOACK` is given, so that the entries delivered don't need to be acknowledged
+    pub noack: bool,
+    /// Keys of the streams
+    pub keys: &'a [Vec<u8>],
+    /// ID given for every stream, in the same order as the keys
+    pub ids: &'a [Vec<u8>],
+}
+
+impl<'a> ReadArgs<'a> {
+    /// Parse the arguments of XREAD, or those of XREADGROUP which also takes `GROUP` and `NOACK`
+    pub fn parse(parsed_command: &'a [Vec<u8>], is_group_read: bool) -> Result<Self, &'static str> {
+        let mut args = Self {
+            count: None,
+            is_blocking: false,
+            timeout: None,
+            group: None,
+            noack: false,
+            keys: &[],
+            ids: &[],
+        };
+        let mut options = &parsed_command[1..];
+        let streams = loop {
+            match *options {
+                [ref option, ref rest @ ..] if option.eq_ignore_ascii_case(b"streams") => {
+                    break rest
+                }
+                [ref option, ref rest @ ..]
+                    if is_group_read && option.eq_ignore_ascii_case(b"noack") =>
+                {
+                    args.noack = true;
+                    options = rest;
+                }
+                [ref option, ..] if !is_group_read && option.eq_ignore_ascii_case(b"group") => {
+                    return Err("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.");
+                }
+                [ref option, ref group, ref consumer, ref rest @ ..]
+                    if option.eq_ignore_ascii_case(b"group") =>
+                {
+                    args.group = Some((group, consumer));
+                    options = rest;
+                }
+                [ref option, ref count, ref rest @ ..] if option.eq_ignore_ascii_case(b"count") => {
+                    let count = parse_redis_int(count).ok_or(INVALID_COUNT)?;
+                    // A count which isn't positive doesn't limit anything, same as Redis
+                    args.count = usize::try_from(count).ok().filter(|&count| count > 0);
+                    options = rest;
+                }
+                [ref option, ref timeout, ref rest @ ..]
+                    if option.eq_ignore_ascii_case(b"block") =>
+                {
+                    let timeout_ms = parse_redis_int(timeout)
+                        .ok_or("ERR timeout is not an integer or out of range")?;
+                    if timeout_ms < 0 {
+                        return Err("ERR timeout is negative");
+                    }
+                    // 0 means to block forever
+                    args.is_blocking = true;
+                    args.timeout =
+                        (timeout_ms > 0).then(|| Duration::from_millis(timeout_ms.unsigned_abs()));
+                    options = rest;
+                }
+                _ => return Err("ERR syntax error"),
+            }
+        };
+        if is_group_read && args.group.is_none() {
+            return Err("ERR Missing GROUP option for XREADGROUP");
+        }
+        if streams.is_empty() || !streams.len().is_multiple_of(2) {
+            return Err(if is_group_read {
+                "ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified."
+            } else {
+                "ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."
+            });
+        }
+        (args.keys, args.ids) = streams.split_at(streams.len() / 2);
+        Ok(args)
+    }
+}
+
+/// XREAD: get the entries with IDs greater than the given ones, up to `COUNT` of them per stream
+/// `$` stands for the ID of the last entry of the stream, so that only entries added later are read. With `BLOCK`,
+/// the client waits until the timeout in milliseconds for a new entry when there is none right away.
+pub fn xread(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    blocked_clients: &Arc<Mutex<BlockedClients>>,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+    can_block: bool,
+) -> Result<Xread<StreamReads>, &'static str> {
+    let ReadArgs {
+        count,
+        is_blocking,
+        timeout,
+        keys,
+        ids,
+        ..
+    } = ReadArgs::parse(parsed_command, false)?;
+    let ids = ids
+        .iter()
+        .map(|id| match id.as_slice() {
+            b"$" => Ok(None),
+            b">" => Err("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option."),
+            id => StreamId::parse(id, 0).map(Some),
+        })
+        .collect::<Result<Vec<_>, _>>()?;
+
+    // The store stays locked while blocking, so that a new entry in between can't be missed
+    let mut store = redis_key_val_store.lock().unwrap();
+    let mut reads = StreamReads {
+        count,
+        ids: Vec::with_capacity(keys.len()),
+    };
+    for (key, id) in keys.iter().zip(ids) {
+        let last_id = store
+            .get_typed::<Stream>(key)?
+            .map_or(StreamId::MIN, Stream::last_id);
+        reads.ids.push((key.clone(), id.unwrap_or(last_id)));
+    }
+    let streams_entries = reads.read(&mut store, |_| true);
+    if !streams_entries.is_empty() {
+        return Ok(Xread::Read(streams_entries));
+    }
+
+    if !is_blocking || !can_block {
+        return Ok(Xread::Empty);
+    }
+    let blocked_client = BlockedClients::block(blocked_clients, db, keys, Pop::Stream);
+    drop(store);
+    Ok(Xread::Blocked(blocked_client, timeout, reads))
+}
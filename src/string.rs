@@ -0,0 +1,447 @@
+//! Commands on the string data type, which holds any bytes up to `MAX_STRING_LEN`
+//! Besides getting and setting whole strings, APPEND and SETRANGE edit them in place.
+
+use std::{
+    sync::{Arc, Mutex},
+    time::SystemTime,
+};
+
+use crate::{
+    command::WRONG_ARITY,
+    notify::EventClass,
+    parse_expiry_option, parse_redis_int,
+    store::{KeyValStore, RedisType},
+};
+
+/// Maximum length of a string, which is the default `proto-max-bulk-len` of Redis (512 MB)
+pub const MAX_STRING_LEN: usize = 512 * 1024 * 1024;
+
+/// How the GETEX command updates the TTL of the key
+enum GetExExpiry {
+    /// `EX`/`PX`/`EXAT`/`PXAT`: expire the key at the given time
+    At(SystemTime),
+    /// `PERSIST`: remove the TTL of the key
+    Persist,
+}
+
+/// SETEX/PSETEX: set the string along with its TTL, in seconds or milliseconds respectively
+pub fn setex(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<(), &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+    let (unit, invalid_time_error) = if parsed_command[0].eq_ignore_ascii_case(b"setex") {
+        ("ex", "ERR invalid expire time in 'setex' command")
+    } else {
+        ("px", "ERR invalid expire time in 'psetex' command")
+    };
+    let expires_at = parse_expiry_option(unit, Some(&parsed_command[2]), invalid_time_error)?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    store.insert(
+        parsed_command[1].clone(),
+        RedisType::Val(parsed_command[3].clone()),
+        Some(expires_at),
+    );
+    store.notify(EventClass::String, "set", &parsed_command[1]);
+    store.notify(EventClass::Generic, "expire", &parsed_command[1]);
+    drop(store);
+    Ok(())
+}
+
+/// SETNX: set the string unless the key already exists, returning whether it was set
+pub fn setnx(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<bool, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    if store.get(&parsed_command[1]).is_some() {
+        return Ok(false);
+    }
+    store.insert(
+        parsed_command[1].clone(),
+        RedisType::Val(parsed_command[2].clone()),
+        None,
+    );
+    store.notify(EventClass::String, "set", &parsed_command[1]);
+    drop(store);
+    Ok(true)
+}
+
+/// MSET/MSETNX: set the strings of every key and value pair, removing their TTL
+/// MSETNX sets none of them if any of the keys already exists; returns whether they were set.
+pub fn mset(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<bool, &'static str> {
+    if parsed_command.len() < 3 || parsed_command.len().is_multiple_of(2) {
+        return Err(WRONG_ARITY);
+    }
+    let pairs = parsed_command[1..].chunks_exact(2);
+
+    // The lock is held from the checks to the writes, so that no other client creates one of the keys in between
+    let mut store = redis_key_val_store.lock().unwrap();
+    if parsed_command[0].eq_ignore_ascii_case(b"msetnx")
+        && pairs.clone().any(|pair| store.get(&pair[0]).is_some())
+    {
+        return Ok(false);
+    }
+    for pair in pairs {
+        store.insert(pair[0].clone(), RedisType::Val(pair[1].clone()), None);
+        store.notify(EventClass::String, "set", &pair[0]);
+    }
+    drop(store);
+    Ok(true)
+}
+
+/// MGET: get the string of every key, which is None for a missing key or one of another type
+pub fn mget(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Vec<Option<Vec<u8>>>, &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let vals = parsed_command[1..]
+        .iter()
+        .map(|key| store.get_typed::<Vec<u8>>(key).ok().flatten().cloned())
+        .collect();
+    drop(store);
+    Ok(vals)
+}
+
+/// GETDEL: get the string and remove the key
+pub fn getdel(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Option<Vec<u8>>, &'static str> {
+    if parsed_command.len() != 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    // The lock is held from the read to the removal, so that no other client gets the same value
+    let mut store = redis_key_val_store.lock().unwrap();
+    if store.get_typed::<Vec<u8>>(&parsed_command[1])?.is_none() {
+        return Ok(None);
+    }
+    let Some(RedisType::Val(val)) = store.remove(&parsed_command[1]) else {
+        unreachable!("type of the key is checked above");
+    };
+    store.notify(EventClass::Generic, "del", &parsed_command[1]);
+    drop(store);
+    Ok(Some(val))
+}
+
+/// GETEX: get the string and optionally update its TTL; without any option the TTL is left as it is
+/// An expiry time in the past removes the key after getting the string.
+pub fn getex(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Option<Vec<u8>>, &'static str> {
+    if parsed_command.len() < 2 {
+        return Err(WRONG_ARITY);
+    }
+    let mut expiry = None;
+    let mut options = parsed_command[2..].iter();
+    while let Some(option) = options.next() {
+        if expiry.is_some() {
+            return Err("ERR syntax error");
+        }
+        expiry = Some(
+            match String::from_utf8_lossy(option).to_lowercase().as_str() {
+                "persist" => GetExExpiry::Persist,
+                unit @ ("ex" | "px" | "exat" | "pxat") => GetExExpiry::At(parse_expiry_option(
+                    unit,
+                    options.next(),
+                    "ERR invalid expire time in 'getex' command",
+                )?),
+                _ => return Err("ERR syntax error"),
+            },
+        );
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(val) = store.get_typed::<Vec<u8>>(&parsed_command[1])?.cloned() else {
+        return Ok(None);
+    };
+    let key = &parsed_command[1];
+    match expiry {
+        Some(GetExExpiry::At(expires_at)) if expires_at <= SystemTime::now() => {
+            store.remove(key);
+            store.notify(EventClass::Generic, "del", key);
+        }
+        Some(GetExExpiry::At(expires_at)) => {
+            store.set_expires_at(key, Some(expires_at));
+            store.notify(EventClass::Generic, "expire", key);
+        }
+        Some(GetExExpiry::Persist) if store.expires_at(key).is_some() => {
+            store.set_expires_at(key, None);
+            store.notify(EventClass::Generic, "persist", key);
+        }
+        Some(GetExExpiry::Persist) | None => {}
+    }
+    drop(store);
+    Ok(Some(val))
+}
+
+/// GETRANGE: get the substring between the inclusive offsets, which count from the end of the string when
+/// negative; out of range offsets are clamped, so a missing key or an empty range gives an empty string
+pub fn getrange(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Vec<u8>, &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+    let (Some(start), Some(end)) = (
+        parse_redis_int(&parsed_command[2]),
+        parse_redis_int(&parsed_command[3]),
+    ) else {
+        return Err("ERR value is not an integer or out of range");
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(val) = store.get_typed::<Vec<u8>>(&parsed_command[1])? else {
+        return Ok(Vec::new());
+    };
+    let len = i64::try_from(val.len()).unwrap();
+    // Like Redis, a range with both offsets from the end is empty if they are reversed, before clamping them
+    if start < 0 && end < 0 && start > end {
+        return Ok(Vec::new());
+    }
+    let start = if start < 0 {
+        (len + start).max(0)
+    } else {
+        start
+    };
+    let end = if end < 0 {
+        (len + end).max(0)
+    } else {
+        end.min(len - 1)
+    };
+    let substring = if start > end || len == 0 {
+        Vec::new()
+    } else {
+        val[usize::try_from(start).unwrap()..=usize::try_from(end).unwrap()].to_vec()
+    };
+    drop(store);
+    Ok(substring)
+}
+
+/// SETRANGE: overwrite the string from the offset, padding it with zero bytes if it is shorter than the offset,
+/// and return its new length
+/// A missing key is created as an empty string, unless the value is empty, in which case nothing is written.
+pub fn setrange(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+    let offset =
+        parse_redis_int(&parsed_command[2]).ok_or("ERR value is not an integer or out of range")?;
+    let offset = usize::try_from(offset).map_err(|_| "ERR offset is out of range")?;
+    let value = &parsed_command[3];
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let current_len = store
+        .get_typed::<Vec<u8>>(&parsed_command[1])?
+        .map_or(0, Vec::len);
+    if value.is_empty() {
+        return Ok(current_len);
+    }
+    if offset.saturating_add(value.len()) > MAX_STRING_LEN {
+        return Err("ERR string exceeds maximum allowed size (proto-max-bulk-len)");
+    }
+
+    let val = store.get_or_insert_typed::<Vec<u8>>(&parsed_command[1])?;
+    let end = offset + value.len();
+    if val.len() < end {
+        val.resize(end, 0);
+    }
+    val[offset..end].copy_from_slice(value);
+    let new_len = val.len();
+    store.notify(EventClass::String, "setrange", &parsed_command[1]);
+    drop(store);
+    Ok(new_len)
+}
+
+/// APPEND: append the value to the string, creating it if the key doesn't exist, and return its new length
+/// The string grows in place with an amortized reallocation, so that repeated appends take linear time overall.
+pub fn append(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(WRONG_ARITY);
+    }
+    let value = &parsed_command[2];
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let current_len = store
+        .get_typed::<Vec<u8>>(&parsed_command[1])?
+        .map_or(0, Vec::len);
+    if current_len.saturating_add(value.len()) > MAX_STRING_LEN {
+        return Err("ERR string exceeds maximum allowed size (proto-max-bulk-len)");
+    }
+
+    let val = store.get_or_insert_typed::<Vec<u8>>(&parsed_command[1])?;
+    val.extend_from_slice(value);
+    let new_len = val.len();
+    store.notify(EventClass::String, "append", &parsed_command[1]);
+    drop(store);
+    Ok(new_len)
+}
+
+/// STRLEN: get the length of the string in bytes, which is 0 for a missing key
+pub fn strlen(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() != 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let len = store
+        .get_typed::<Vec<u8>>(&parsed_command[1])?
+        .map_or(0, Vec::len);
+    drop(store);
+    Ok(len)
+}
+
+/// A common substring found by LCS with `IDX`, as the inclusive ranges of both strings which it spans
+pub struct LcsMatch {
+    /// Range of the first string
+    pub a: (usize, usize),
+    /// Range of the second string
+    pub b: (usize, usize),
+}
+
+impl LcsMatch {
+    /// Length of the substring
+    pub const fn len(&self) -> usize {
+        self.a.1 - self.a.0 + 1
+    }
+}
+
+/// Output of LCS
+pub enum Lcs {
+    /// The longest common subsequence itself
+    Subsequence(Vec<u8>),
+    /// `LEN`: the length of the longest common subsequence
+    Len(usize),
+    /// `IDX`: the substrings of the longest common subsequence, from the last one, along with its length; the
+    /// substrings shorter than `MINMATCHLEN` are left out, and `WITHMATCHLEN` adds their length to the reply
+    Matches(Vec<LcsMatch>, usize, bool),
+}
+
+/// LCS: find the longest common subsequence of the strings at both keys, a missing key being an empty string
+/// The subsequence is found from the table of the lengths of the longest common subsequences of every pair of
+/// prefixes, walking back from the end of both strings like Redis does, so that the result is the same.
+pub fn lcs(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Lcs, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+    let (mut is_len, mut is_idx, mut with_match_len, mut min_match_len) = (false, false, false, 0);
+    let mut options = parsed_command[3..].iter();
+    while let Some(option) = options.next() {
+        match String::from_utf8_lossy(option).to_lowercase().as_str() {
+            "len" => is_len = true,
+            "idx" => is_idx = true,
+            "withmatchlen" => with_match_len = true,
+            "minmatchlen" => {
+                let len = options
+                    .next()
+                    .and_then(|len| parse_redis_int(len))
+                    .ok_or("ERR value is not an integer or out of range")?;
+                // A negative length is no minimum at all
+                min_match_len = usize::try_from(len).unwrap_or(0);
+            }
+            _ => return Err("ERR syntax error"),
+        }
+    }
+    if is_len && is_idx {
+        return Err("ERR If you want both the length and indexes, please just use IDX.");
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let mut value = |key| {
+        store
+            .get_typed::<Vec<u8>>(key)
+            .map(|value| value.cloned().unwrap_or_default())
+            .map_err(|_| "ERR The specified keys must contain string values")
+    };
+    let a = value(&parsed_command[1])?;
+    let b = value(&parsed_command[2])?;
+    drop(store);
+    // The table takes 4 bytes for every pair of prefixes
+    let cells = (a.len() + 1).saturating_mul(b.len() + 1);
+    if cells.saturating_mul(4) > MAX_STRING_LEN {
+        return Err("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len");
+    }
+
+    let width = b.len() + 1;
+    let mut table = vec![0_u32; cells];
+    for i in 1..=a.len() {
+        for j in 1..=b.len() {
+            table[i * width + j] = if a[i - 1] == b[j - 1] {
+                table[(i - 1) * width + j - 1] + 1
+            } else {
+                table[(i - 1) * width + j].max(table[i * width + j - 1])
+            };
+        }
+    }
+    let len = table[a.len() * width + b.len()] as usize;
+    if is_len {
+        return Ok(Lcs::Len(len));
+    }
+
+    let mut subsequence = vec![0; len];
+    let mut matches = Vec::new();
+    // The match being extended backwards, which is emitted once it can't be extended anymore
+    let mut current: Option<LcsMatch> = None;
+    let (mut i, mut j) = (a.len(), b.len());
+    while i > 0 && j > 0 {
+        let is_common = a[i - 1] == b[j - 1];
+        if is_common {
+            // The length left to find is the position of the element in the subsequence
+            subsequence[table[i * width + j] as usize - 1] = a[i - 1];
+            let range = current.get_or_insert(LcsMatch {
+                a: (i - 1, i - 1),
+                b: (j - 1, j - 1),
+            });
+            range.a.0 = i - 1;
+            range.b.0 = j - 1;
+            i -= 1;
+            j -= 1;
+        } else if table[(i - 1) * width + j] > table[i * width + j - 1] {
+            i -= 1;
+        } else {
+            j -= 1;
+        }
+        if !is_common || i == 0 || j == 0 {
+            if let Some(range) = current.take() {
+                if range.len() >= min_match_len {
+                    matches.push(range);
+                }
+            }
+        }
+    }
+    Ok(if is_idx {
+        Lcs::Matches(matches, len, with_match_len)
+    } else {
+        Lcs::Subsequence(subsequence)
+    })
+}
@@ -0,0 +1,112 @@
+//! MULTI/EXEC transactions, optionally guarded by WATCH
+
+use std::sync::{Arc, Mutex, RwLock};
+
+use crate::{command::CommandSpec, resp::RespValue, store::KeyValStore};
+
+/// Commands are run while holding this for reading and transactions while holding it for writing,
+/// so that no command of another client runs in the middle of a transaction
+pub static ATOMICITY_LOCK: RwLock<()> = RwLock::new(());
+
+/// Command queued in a transaction, along with its arguments
+pub type QueuedCommand = (&'static CommandSpec, Vec<Vec<u8>>);
+
+/// Commands queued between MULTI and EXEC by a client
+#[derive(Default)]
+pub struct Transaction {
+    /// Commands to be run by EXEC, in order, along with their arguments
+    commands: Vec<QueuedCommand>,
+    /// A command was rejected while queueing, so EXEC must not run anything
+    aborted: bool,
+}
+
+impl Transaction {
+    /// Queue the command to be run by EXEC, given as validated by `command::validate`
+    /// A command which can never succeed (unknown or with a wrong number of arguments) is rejected and aborts the transaction.
+    pub fn queue(
+        &mut self,
+        command: Result<&'static CommandSpec, RespValue>,
+        parsed_command: Vec<Vec<u8>>,
+    ) -> RespValue {
+        match command {
+            Ok(command) => {
+                self.commands.push((command, parsed_command));
+                RespValue::simple("QUEUED")
+            }
+            Err(err) => {
+                self.aborted = true;
+                err
+            }
+        }
+    }
+
+    /// Number of commands queued so far
+    pub const fn queued_count(&self) -> usize {
+        self.commands.len()
+    }
+
+    /// Whether any queued command may write, so that EXEC may write too
+    pub fn has_writes(&self) -> bool {
+        self.commands.iter().any(|&(command, _)| command.is_write())
+    }
+
+    /// Make EXEC fail, as a command was rejected before it could be queued
+    pub const fn abort(&mut self) {
+        self.aborted = true;
+    }
+
+    /// Get the queued commands to be run by EXEC, or the error to reply with if the transaction was aborted
+    pub fn into_commands(self) -> Result<Vec<QueuedCommand>, &'static str> {
+        if self.aborted {
+            return Err("EXECABORT Transaction discarded because of previous errors.");
+        }
+        Ok(self.commands)
+    }
+}
+
+/// Keys watched by a client along with their versions at the time they were watched
+/// Every key is versioned in the store of the database it was watched in. The keys are unwatched when this is
+/// dropped.
+#[derive(Default)]
+pub struct WatchedKeys {
+    /// Watched keys along with their stores and versions
+    versions: Vec<(Arc<Mutex<KeyValStore>>, Vec<u8>, u64)>,
+}
+
+impl WatchedKeys {
+    /// Watch the keys of the store; watching a key again keeps its original version
+    pub fn watch(&mut self, redis_key_val_store: &Arc<Mutex<KeyValStore>>, keys: &[Vec<u8>]) {
+        let mut store = redis_key_val_store.lock().unwrap();
+        for key in keys {
+            let is_watched = self
+                .versions
+                .iter()
+                .any(|watched| Arc::ptr_eq(&watched.0, redis_key_val_store) && watched.1 == *key);
+            if !is_watched {
+                self.versions.push((
+                    Arc::clone(redis_key_val_store),
+                    key.clone(),
+                    store.watch(key),
+                ));
+            }
+        }
+        drop(store);
+    }
+
+    /// Check whether any of the keys got modified since it was watched
+    pub fn is_modified(&self) -> bool {
+        self.versions
+            .iter()
+            .any(|&(ref redis_key_val_store, ref key, version)| {
+                redis_key_val_store.lock().unwrap().version(key) != version
+            })
+    }
+}
+
+impl Drop for WatchedKeys {
+    fn drop(&mut self) {
+        for watched in &self.versions {
+            watched.0.lock().unwrap().unwatch(&watched.1);
+        }
+    }
+}
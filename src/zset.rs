@@ -0,0 +1,819 @@
+//! Sorted set data type, i.e. unique members ordered by their scores, and the commands on it
+//! Ranges are given by ranks, by scores or lexicographically, for members which all have the same score.
+
+use std::{
+    cmp::Ordering,
+    collections::HashMap,
+    sync::{Arc, Mutex},
+};
+
+use crate::{
+    blocking::{BlockedClients, Pop, Poppable},
+    command::WRONG_ARITY,
+    notify::EventClass,
+    parse_redis_float, parse_redis_int,
+    resp::{Protocol, RespValue},
+    set,
+    store::KeyValStore,
+};
+
+/// Set of unique members ordered by their scores; ties are broken by ordering the members lexicographically
+/// Members are kept in a sorted Vec along with a map of their scores, so that ranks and ranges are found by
+/// binary search while the score of a member is found in O(1). Insertion and removal are O(n).
+#[derive(Clone, Default)]
+pub struct SortedSet {
+    /// Score of every member
+    scores: HashMap<Vec<u8>, f64>,
+    /// (score, member) pairs in the sorted order
+    ordered: Vec<(f64, Vec<u8>)>,
+}
+
+impl SortedSet {
+    /// Order of the (score, member) pairs
+    fn compare(a: (f64, &[u8]), b: (f64, &[u8])) -> Ordering {
+        a.0.total_cmp(&b.0).then_with(|| a.1.cmp(b.1))
+    }
+
+    /// Find the index of the pair, or the index where it would be inserted
+    fn search(&self, score: f64, member: &[u8]) -> Result<usize, usize> {
+        self.ordered
+            .binary_search_by(|pair| Self::compare((pair.0, &pair.1), (score, member)))
+    }
+
+    /// Number of members
+    pub const fn len(&self) -> usize {
+        self.ordered.len()
+    }
+
+    /// Score of the member, if present
+    pub fn score(&self, member: &[u8]) -> Option<f64> {
+        self.scores.get(member).copied()
+    }
+
+    /// Insert the member or update its score, returning its old score
+    pub fn insert(&mut self, member: Vec<u8>, score: f64) -> Option<f64> {
+        let old_score = self.remove(&member);
+        let index = self.search(score, &member).unwrap_err();
+        self.ordered.insert(index, (score, member.clone()));
+        self.scores.insert(member, score);
+        old_score
+    }
+
+    /// Remove the member, returning its score
+    pub fn remove(&mut self, member: &[u8]) -> Option<f64> {
+        let score = self.scores.remove(member)?;
+        let index = self.search(score, member).unwrap();
+        self.ordered.remove(index);
+        Some(score)
+    }
+
+    /// 0-based rank of the member in ascending order of the scores
+    pub fn rank(&self, member: &[u8]) -> Option<usize> {
+        let score = self.score(member)?;
+        self.search(score, member).ok()
+    }
+
+    /// All the (score, member) pairs in ascending order
+    pub fn iter(&self) -> impl Iterator<Item = &(f64, Vec<u8>)> {
+        self.ordered.iter()
+    }
+
+    /// (score, member) pairs in the range of ranks, which must lie within the set
+    pub fn range(&self, start: usize, stop: usize) -> &[(f64, Vec<u8>)] {
+        &self.ordered[start..=stop]
+    }
+
+    /// (score, member) pairs which are neither below the minimum nor above the maximum, found by binary search
+    /// The bounds must agree with the order, i.e. all the pairs below the minimum come first and all the pairs above
+    /// the maximum come last.
+    fn range_between(
+        &self,
+        is_below_min: impl Fn(&(f64, Vec<u8>)) -> bool,
+        is_above_max: impl Fn(&(f64, Vec<u8>)) -> bool,
+    ) -> &[(f64, Vec<u8>)] {
+        let start = self.ordered.partition_point(is_below_min);
+        let stop = self.ordered.partition_point(|pair| !is_above_max(pair));
+        &self.ordered[start..stop.max(start)]
+    }
+}
+
+/// End of a sorted set, as popped by ZPOPMIN/ZPOPMAX
+#[derive(Clone, Copy)]
+pub enum SortedSetEnd {
+    /// Member with the lowest score
+    Min,
+    /// Member with the highest score
+    Max,
+}
+
+impl SortedSetEnd {
+    /// Remove the member at this end of the sorted set, returning it along with its score
+    fn pop(self, sorted_set: &mut SortedSet) -> Option<(Vec<u8>, f64)> {
+        let index = match self {
+            Self::Min => 0,
+            Self::Max => sorted_set.len().checked_sub(1)?,
+        };
+        let (score, member) = sorted_set.ordered.get(index)?.clone();
+        sorted_set.remove(&member);
+        Some((member, score))
+    }
+
+    /// Name of the keyspace event of a pop from this end
+    pub const fn pop_event(self) -> &'static str {
+        match self {
+            Self::Min => "zpopmin",
+            Self::Max => "zpopmax",
+        }
+    }
+}
+
+impl Poppable for SortedSet {
+    fn is_empty(&self) -> bool {
+        self.len() == 0
+    }
+
+    fn pop_for(&mut self, pop: Pop) -> Option<(Vec<u8>, Option<f64>)> {
+        match pop {
+            Pop::SortedSet(end) => end.pop(self).map(|(member, score)| (member, Some(score))),
+            Pop::List(_) | Pop::Stream => None,
+        }
+    }
+
+    fn put_back(&mut self, _pop: Pop, member: Vec<u8>, score: Option<f64>) {
+        if let Some(score) = score {
+            self.insert(member, score);
+        }
+    }
+}
+
+/// Hand over the members of the sorted set at the key of the database to the clients blocked on it by
+/// BZPOPMIN/BZPOPMAX, removing the key if that empties the sorted set
+pub fn serve_blocked_clients(
+    store: &mut KeyValStore,
+    blocked_clients: &Mutex<BlockedClients>,
+    db: usize,
+    key: &[u8],
+) {
+    let Ok(Some(sorted_set)) = store.get_typed_mut::<SortedSet>(key) else {
+        return;
+    };
+    let served_pops = blocked_clients.lock().unwrap().serve(db, key, sorted_set);
+    let is_sorted_set_empty = sorted_set.len() == 0;
+    for served_pop in served_pops {
+        served_pop.notify(store, key);
+    }
+    if is_sorted_set_empty {
+        store.remove(key);
+        store.notify(EventClass::Generic, "del", key);
+    }
+}
+
+/// Bound of a range of scores, e.g. `5`, `(5` when it is exclusive, or `-inf`
+#[derive(Clone, Copy)]
+struct ScoreBound {
+    /// Score at the bound
+    score: f64,
+    /// Whether the score at the bound is out of the range
+    is_exclusive: bool,
+}
+
+impl ScoreBound {
+    /// Parse the bound as given to ZRANGEBYSCORE
+    fn parse(bound: &[u8]) -> Result<Self, &'static str> {
+        let (score, is_exclusive) = bound
+            .strip_prefix(b"(")
+            .map_or((bound, false), |score| (score, true));
+        let score = parse_redis_float(score).ok_or("ERR min or max is not a float")?;
+        Ok(Self {
+            score,
+            is_exclusive,
+        })
+    }
+
+    /// Whether the score is below the range when this is its minimum
+    fn is_below(self, score: f64) -> bool {
+        if self.is_exclusive {
+            score <= self.score
+        } else {
+            score < self.score
+        }
+    }
+
+    /// Whether the score is above the range when this is its maximum
+    fn is_above(self, score: f64) -> bool {
+        if self.is_exclusive {
+            score >= self.score
+        } else {
+            score > self.score
+        }
+    }
+}
+
+/// Bound of a lexicographic range of members
+enum LexBound {
+    /// `-`: before every member
+    Min,
+    /// `+`: after every member
+    Max,
+    /// `[member`: the member is in the range
+    Inclusive(Vec<u8>),
+    /// `(member`: the member is out of the range
+    Exclusive(Vec<u8>),
+}
+
+impl LexBound {
+    /// Parse the bound as given to ZRANGEBYLEX
+    fn parse(bound: &[u8]) -> Result<Self, &'static str> {
+        match *bound {
+            [b'-'] => Ok(Self::Min),
+            [b'+'] => Ok(Self::Max),
+            [b'[', ref member @ ..] => Ok(Self::Inclusive(member.to_vec())),
+            [b'(', ref member @ ..] => Ok(Self::Exclusive(member.to_vec())),
+            _ => Err("ERR min or max not valid string range item"),
+        }
+    }
+
+    /// Whether the member is below the range when this is its minimum
+    fn is_below(&self, member: &[u8]) -> bool {
+        match *self {
+            Self::Min => false,
+            Self::Max => true,
+            Self::Inclusive(ref bound) => member < bound.as_slice(),
+            Self::Exclusive(ref bound) => member <= bound.as_slice(),
+        }
+    }
+
+    /// Whether the member is above the range when this is its maximum
+    fn is_above(&self, member: &[u8]) -> bool {
+        match *self {
+            Self::Min => true,
+            Self::Max => false,
+            Self::Inclusive(ref bound) => member > bound.as_slice(),
+            Self::Exclusive(ref bound) => member >= bound.as_slice(),
+        }
+    }
+}
+
+/// Parsed options of the ZADD command
+#[derive(Default)]
+#[expect(
+    clippy::struct_excessive_bools,
+    reason = "Every option of ZADD is an independent flag"
+)]
+struct ZaddOptions {
+    /// `NX`: only add new members, never update the existing ones
+    nx: bool,
+    /// `XX`: only update the existing members, never add new ones
+    xx: bool,
+    /// `GT`: only update the score of an existing member if the new score is greater
+    gt: bool,
+    /// `LT`: only update the score of an existing member if the new score is less
+    lt: bool,
+    /// `CH`: count the members whose score changed along with the added ones
+    ch: bool,
+}
+
+impl ZaddOptions {
+    /// Whether the score of an existing member may be updated from the old score to the new one
+    fn allows_update(&self, old_score: f64, score: f64) -> bool {
+        match score.partial_cmp(&old_score) {
+            _ if self.nx => false,
+            Some(Ordering::Greater) => !self.lt,
+            Some(Ordering::Less) => !self.gt,
+            // Same score, so there is nothing to update
+            _ => false,
+        }
+    }
+}
+
+/// ZADD: add the members with their scores or update the scores of the existing members
+/// Returns the number of members added (and changed, with `CH`). The members are handed over to the clients blocked
+/// on the sorted set, if any.
+pub fn zadd(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    blocked_clients: &Mutex<BlockedClients>,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 4 {
+        return Err(WRONG_ARITY);
+    }
+
+    // Options come before the score/member pairs
+    let mut options = ZaddOptions::default();
+    let mut pairs_start = 2;
+    for arg in &parsed_command[2..] {
+        match String::from_utf8_lossy(arg).to_lowercase().as_str() {
+            "nx" => options.nx = true,
+            "xx" => options.xx = true,
+            "gt" => options.gt = true,
+            "lt" => options.lt = true,
+            "ch" => options.ch = true,
+            _ => break,
+        }
+        pairs_start += 1;
+    }
+    if options.nx && options.xx {
+        return Err("ERR XX and NX options at the same time are not compatible");
+    }
+    if [options.nx, options.gt, options.lt]
+        .iter()
+        .filter(|&&option| option)
+        .count()
+        > 1
+    {
+        return Err("ERR GT, LT, and/or NX options at the same time are not compatible");
+    }
+
+    let pairs = &parsed_command[pairs_start..];
+    if pairs.is_empty() || !pairs.len().is_multiple_of(2) {
+        return Err("ERR syntax error");
+    }
+    // All the scores are validated before modifying the set, so that a bad score doesn't leave a partial update
+    let pairs = pairs
+        .chunks_exact(2)
+        .map(|pair| {
+            parse_redis_float(&pair[0])
+                .map(|score| (score, &pair[1]))
+                .ok_or("ERR value is not a valid float")
+        })
+        .collect::<Result<Vec<_>, _>>()?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let sorted_set = store.get_or_insert_typed::<SortedSet>(&parsed_command[1])?;
+
+    let mut added = 0;
+    let mut changed = 0;
+    for (score, member) in pairs {
+        match sorted_set.score(member) {
+            None if !options.xx => {
+                sorted_set.insert(member.clone(), score);
+                added += 1;
+            }
+            Some(old_score) if options.allows_update(old_score, score) => {
+                sorted_set.insert(member.clone(), score);
+                changed += 1;
+            }
+            _ => {}
+        }
+    }
+
+    // Nothing may have been added with `XX`, and an empty sorted set must not be left behind
+    if sorted_set.len() == 0 {
+        store.remove(&parsed_command[1]);
+    }
+    if added + changed > 0 {
+        store.notify(EventClass::SortedSet, "zadd", &parsed_command[1]);
+    }
+    serve_blocked_clients(&mut store, blocked_clients, db, &parsed_command[1]);
+    drop(store);
+    Ok(if options.ch { added + changed } else { added })
+}
+
+/// ZSCORE: get the score of the member
+pub fn zscore(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<Option<f64>, &'static str> {
+    if parsed_command.len() != 3 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let member_score = store
+        .get_typed::<SortedSet>(&parsed_command[1])?
+        .and_then(|sorted_set| sorted_set.score(&parsed_command[2]));
+    drop(store);
+    Ok(member_score)
+}
+
+/// ZINCRBY: add the increment to the score of the member, returning the new score
+/// A missing member is added with the increment as its score, and the sorted set is created if the key doesn't
+/// exist. The member is handed over to the clients blocked on the sorted set, if any.
+pub fn zincrby(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    blocked_clients: &Mutex<BlockedClients>,
+    db: usize,
+    parsed_command: &[Vec<u8>],
+) -> Result<f64, &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+    let increment =
+        parse_redis_float(&parsed_command[2]).ok_or("ERR value is not a valid float")?;
+
+    // The lock is held for the whole read-modify-write, so that concurrent updates are not lost
+    let mut store = redis_key_val_store.lock().unwrap();
+    // Checked before creating the sorted set, so that a missing key isn't created empty
+    let old_score = store
+        .get_typed::<SortedSet>(&parsed_command[1])?
+        .and_then(|sorted_set| sorted_set.score(&parsed_command[3]));
+    let new_score = old_score.unwrap_or(0.0) + increment;
+    if new_score.is_nan() {
+        return Err("ERR resulting score is not a number (NaN)");
+    }
+    store
+        .get_or_insert_typed::<SortedSet>(&parsed_command[1])?
+        .insert(parsed_command[3].clone(), new_score);
+    store.notify(EventClass::SortedSet, "zincr", &parsed_command[1]);
+    serve_blocked_clients(&mut store, blocked_clients, db, &parsed_command[1]);
+    drop(store);
+    Ok(new_score)
+}
+
+/// ZREM: remove the members, returning the number of members which existed
+/// The key is removed along with the last member.
+pub fn zrem(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() < 3 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(sorted_set) = store.get_typed_mut::<SortedSet>(&parsed_command[1])? else {
+        return Ok(0);
+    };
+    let removed = parsed_command[2..]
+        .iter()
+        .filter(|member| sorted_set.remove(member).is_some())
+        .count();
+    let is_sorted_set_empty = sorted_set.len() == 0;
+    if removed > 0 {
+        store.notify(EventClass::SortedSet, "zrem", &parsed_command[1]);
+    }
+    if is_sorted_set_empty {
+        store.remove(&parsed_command[1]);
+        store.notify(EventClass::Generic, "del", &parsed_command[1]);
+    }
+    drop(store);
+    Ok(removed)
+}
+
+/// ZCARD: get the number of members
+pub fn zcard(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() != 2 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let len = store
+        .get_typed::<SortedSet>(&parsed_command[1])?
+        .map_or(0, SortedSet::len);
+    drop(store);
+    Ok(len)
+}
+
+/// ZINTERCARD: get the number of members of all the sorted sets whatever their scores, counting up to `LIMIT` if
+/// given and not 0
+pub fn zintercard(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    let (keys, limit) = set::parse_intercard(parsed_command)?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let sorted_sets: Option<Vec<&SortedSet>> = store
+        .get_many_typed::<SortedSet>(keys)?
+        .into_iter()
+        .collect();
+    let mut sorted_sets = sorted_sets.unwrap_or_default();
+    // The smallest sorted set is iterated over, stopping as soon as the limit is reached
+    sorted_sets.sort_unstable_by_key(|sorted_set| sorted_set.len());
+    let count = sorted_sets.first().map_or(0, |smallest| {
+        smallest
+            .iter()
+            .filter(|pair| {
+                sorted_sets[1..]
+                    .iter()
+                    .all(|sorted_set| sorted_set.score(&pair.1).is_some())
+            })
+            .take(limit)
+            .count()
+    });
+    drop(store);
+    Ok(count)
+}
+
+/// ZCOUNT: get the number of members between the minimum and the maximum score, given like to ZRANGEBYSCORE
+pub fn zcount(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<usize, &'static str> {
+    if parsed_command.len() != 4 {
+        return Err(WRONG_ARITY);
+    }
+    let min = ScoreBound::parse(&parsed_command[2])?;
+    let max = ScoreBound::parse(&parsed_command[3])?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let count = store
+        .get_typed::<SortedSet>(&parsed_command[1])?
+        .map_or(0, |sorted_set| {
+            sorted_set
+                .range_between(|pair| min.is_below(pair.0), |pair| max.is_above(pair.0))
+                .len()
+        });
+    drop(store);
+    Ok(count)
+}
+
+/// ZPOPMIN/ZPOPMAX: remove up to `count` members from the end of the sorted set, 1 by default, returning them along
+/// with their scores
+/// The key is removed along with the last member.
+pub fn zpop(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+    end: SortedSetEnd,
+) -> Result<ZrangeOutput, &'static str> {
+    if !(2..=3).contains(&parsed_command.len()) {
+        return Err(WRONG_ARITY);
+    }
+    let count = parsed_command.get(2).map_or(Ok(1), |count| {
+        parse_redis_int(count)
+            .and_then(|count| usize::try_from(count).ok())
+            .ok_or("ERR value is out of range, must be positive")
+    })?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(sorted_set) = store.get_typed_mut::<SortedSet>(&parsed_command[1])? else {
+        return Ok(ZrangeOutput::with_scores(Vec::new()));
+    };
+    let members: Vec<_> = (0..count).map_while(|_| end.pop(sorted_set)).collect();
+    let is_sorted_set_empty = sorted_set.len() == 0;
+    if !members.is_empty() {
+        store.notify(EventClass::SortedSet, end.pop_event(), &parsed_command[1]);
+    }
+    if is_sorted_set_empty {
+        store.remove(&parsed_command[1]);
+        store.notify(EventClass::Generic, "del", &parsed_command[1]);
+    }
+    drop(store);
+    Ok(ZrangeOutput::with_scores(members))
+}
+
+/// Output of the ZRANK/ZREVRANK commands
+pub struct ZrankOutput {
+    /// Rank and score of the member, if it exists
+    rank: Option<(usize, f64)>,
+    /// Whether the score is to be replied along with the rank
+    with_score: bool,
+}
+
+impl ZrankOutput {
+    /// Convert to RESP
+    pub fn into_resp(self) -> RespValue {
+        match self.rank {
+            Some((rank, score)) if self.with_score => RespValue::Array(vec![
+                RespValue::Integer(i64::try_from(rank).unwrap()),
+                RespValue::Double(score),
+            ]),
+            Some((rank, _)) => RespValue::Integer(i64::try_from(rank).unwrap()),
+            None if self.with_score => RespValue::NullArray,
+            None => RespValue::NullBulkString,
+        }
+    }
+}
+
+/// ZRANK/ZREVRANK: get the rank of the member, along with its score with `WITHSCORE`
+pub fn zrank(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+    reverse: bool,
+) -> Result<ZrankOutput, &'static str> {
+    let with_score = match parsed_command.len() {
+        3 => false,
+        4 if parsed_command[3].eq_ignore_ascii_case(b"withscore") => true,
+        4 => return Err("ERR syntax error"),
+        _ => return Err(WRONG_ARITY),
+    };
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let rank = store
+        .get_typed::<SortedSet>(&parsed_command[1])?
+        .and_then(|sorted_set| {
+            let rank = sorted_set.rank(&parsed_command[2])?;
+            let rank = if reverse {
+                sorted_set.len() - 1 - rank
+            } else {
+                rank
+            };
+            Some((rank, sorted_set.score(&parsed_command[2])?))
+        });
+    drop(store);
+    Ok(ZrankOutput { rank, with_score })
+}
+
+/// Output of the ZRANGE command
+pub struct ZrangeOutput {
+    /// (member, score) pairs in the requested order
+    members: Vec<(Vec<u8>, f64)>,
+    /// Whether the scores are to be replied along with the members
+    with_scores: bool,
+}
+
+impl ZrangeOutput {
+    /// Output replying with the (member, score) pairs
+    const fn with_scores(members: Vec<(Vec<u8>, f64)>) -> Self {
+        Self {
+            members,
+            with_scores: true,
+        }
+    }
+
+    /// Convert to RESP; RESP3 clients get a [member, score] pair per member instead of a flat array
+    pub fn into_resp(self, protocol: Protocol) -> RespValue {
+        if !self.with_scores {
+            return RespValue::bulk_string_array(
+                self.members.into_iter().map(|(member, _)| member),
+            );
+        }
+
+        let members = self.members.into_iter();
+        RespValue::Array(match protocol {
+            Protocol::Resp2 => members
+                .flat_map(|(member, score)| {
+                    [RespValue::BulkString(member), RespValue::Double(score)]
+                })
+                .collect(),
+            Protocol::Resp3 => members
+                .map(|(member, score)| {
+                    RespValue::Array(vec![
+                        RespValue::BulkString(member),
+                        RespValue::Double(score),
+                    ])
+                })
+                .collect(),
+        })
+    }
+}
+
+/// ZRANGE: get the members in the range of ranks, optionally in the reverse order (`REV`)
+pub fn zrange(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<ZrangeOutput, &'static str> {
+    if parsed_command.len() < 4 {
+        return Err(WRONG_ARITY);
+    }
+
+    let mut reverse = false;
+    let mut with_scores = false;
+    for option in &parsed_command[4..] {
+        match String::from_utf8_lossy(option).to_lowercase().as_str() {
+            "rev" => reverse = true,
+            "withscores" => with_scores = true,
+            _ => return Err("ERR syntax error"),
+        }
+    }
+    let mut start =
+        parse_redis_int(&parsed_command[2]).ok_or("ERR value is not an integer or out of range")?;
+    let mut stop =
+        parse_redis_int(&parsed_command[3]).ok_or("ERR value is not an integer or out of range")?;
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let Some(sorted_set) = store.get_typed::<SortedSet>(&parsed_command[1])? else {
+        return Ok(ZrangeOutput {
+            members: Vec::new(),
+            with_scores,
+        });
+    };
+
+    // Same index semantics as LRANGE, applied on the order after reversing
+    let len = i64::try_from(sorted_set.len()).unwrap();
+    if start < 0 {
+        start += len;
+    }
+    if stop < 0 {
+        stop += len;
+    }
+    start = start.max(0);
+    stop = stop.min(len - 1);
+    if start > stop {
+        return Ok(ZrangeOutput {
+            members: Vec::new(),
+            with_scores,
+        });
+    }
+
+    // Both indices lie within the set now, so they always fit in usize
+    let (start, stop) = (
+        usize::try_from(start).unwrap(),
+        usize::try_from(stop).unwrap(),
+    );
+    let (start, stop) = if reverse {
+        (sorted_set.len() - 1 - stop, sorted_set.len() - 1 - start)
+    } else {
+        (start, stop)
+    };
+    let mut members: Vec<(Vec<u8>, f64)> = sorted_set
+        .range(start, stop)
+        .iter()
+        .map(|pair| (pair.1.clone(), pair.0))
+        .collect();
+    drop(store);
+
+    if reverse {
+        members.reverse();
+    }
+    Ok(ZrangeOutput {
+        members,
+        with_scores,
+    })
+}
+
+/// Minimum and maximum of the range of ZRANGEBYSCORE/ZRANGEBYLEX
+enum Bounds {
+    /// Range of scores
+    Score(ScoreBound, ScoreBound),
+    /// Lexicographic range of members
+    Lex(LexBound, LexBound),
+}
+
+/// ZRANGEBYSCORE/ZRANGEBYLEX: get the members between the minimum and the maximum score, or between the minimum and
+/// the maximum member when all the members have the same score, skipping `offset` of them and getting at most `count`
+/// of them with `LIMIT offset count`
+/// ZREVRANGEBYSCORE/ZREVRANGEBYLEX take the maximum before the minimum and get the members in the reverse order.
+pub fn zrange_by(
+    redis_key_val_store: &Arc<Mutex<KeyValStore>>,
+    parsed_command: &[Vec<u8>],
+) -> Result<ZrangeOutput, &'static str> {
+    if parsed_command.len() < 4 {
+        return Err(WRONG_ARITY);
+    }
+    let name = String::from_utf8_lossy(&parsed_command[0]).to_lowercase();
+    let (reverse, by_lex) = (name.starts_with("zrev"), name.ends_with("lex"));
+    let (min, max) = if reverse {
+        (&parsed_command[3], &parsed_command[2])
+    } else {
+        (&parsed_command[2], &parsed_command[3])
+    };
+    let bounds = if by_lex {
+        Bounds::Lex(LexBound::parse(min)?, LexBound::parse(max)?)
+    } else {
+        Bounds::Score(ScoreBound::parse(min)?, ScoreBound::parse(max)?)
+    };
+
+    let mut with_scores = false;
+    let mut limit = None;
+    let mut options = parsed_command[4..].iter();
+    while let Some(option) = options.next() {
+        match String::from_utf8_lossy(option).to_lowercase().as_str() {
+            "withscores" if !by_lex => with_scores = true,
+            "limit" => {
+                let (Some(offset), Some(count)) = (options.next(), options.next()) else {
+                    return Err("ERR syntax error");
+                };
+                let parse =
+                    |arg| parse_redis_int(arg).ok_or("ERR value is not an integer or out of range");
+                limit = Some((parse(offset)?, parse(count)?));
+            }
+            _ => return Err("ERR syntax error"),
+        }
+    }
+
+    let mut store = redis_key_val_store.lock().unwrap();
+    let pairs =
+        store
+            .get_typed::<SortedSet>(&parsed_command[1])?
+            .map(|sorted_set| match bounds {
+                Bounds::Score(min, max) => sorted_set
+                    .range_between(|pair| min.is_below(pair.0), |pair| max.is_above(pair.0)),
+                Bounds::Lex(min, max) => sorted_set
+                    .range_between(|pair| min.is_below(&pair.1), |pair| max.is_above(&pair.1)),
+            });
+
+    // A negative offset gives no member, and a negative count gives all of the members after the offset
+    let (offset, count) = limit.unwrap_or((0, -1));
+    let pairs = pairs.filter(|_| offset >= 0).unwrap_or_default();
+    let (offset, count) = (
+        usize::try_from(offset).unwrap_or_default(),
+        usize::try_from(count).unwrap_or(usize::MAX),
+    );
+    let to_member = |pair: &(f64, Vec<u8>)| (pair.1.clone(), pair.0);
+    let members = if reverse {
+        pairs
+            .iter()
+            .rev()
+            .skip(offset)
+            .take(count)
+            .map(to_member)
+            .collect()
+    } else {
+        pairs
+            .iter()
+            .skip(offset)
+            .take(count)
+            .map(to_member)
+            .collect()
+    };
+    drop(store);
+    Ok(ZrangeOutput {
+        members,
+        with_scores,
+    })
+}
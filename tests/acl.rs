@@ -0,0 +1,190 @@
+use redis::{Commands, Value};
+
+mod utils;
+
+fn acl<T: redis::FromRedisValue>(
+    con: &mut redis::Connection,
+    args: &[&str],
+) -> redis::RedisResult<T> {
+    redis::cmd("ACL").arg(args).query(con)
+}
+
+#[test]
+fn test_acl_users() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let whoami: String = acl(con, &["WHOAMI"]).unwrap();
+    assert_eq!(whoami, "default");
+    let list: Vec<String> = acl(con, &["LIST"]).unwrap();
+    assert_eq!(list, ["user default on nopass ~* +@all"]);
+
+    let _: () = acl(
+        con,
+        &[
+            "SETUSER",
+            "alice",
+            "on",
+            ">pw",
+            "~cached:*",
+            "-@all",
+            "+get",
+        ],
+    )
+    .unwrap();
+    let list: Vec<String> = acl(con, &["LIST"]).unwrap();
+    assert_eq!(
+        list[0],
+        "user alice on #30c952fab122c3f9759f02a6d95c3758b246b4fee239957b2d4fee46e26170c4 ~cached:* -@all +get"
+    );
+    let users: Vec<String> = acl(con, &["USERS"]).unwrap();
+    assert_eq!(users, ["alice", "default"]);
+    // Flags, passwords, commands and keys
+    let fields: Vec<Value> = acl(con, &["GETUSER", "alice"]).unwrap();
+    assert_eq!(fields.len(), 8);
+    assert_eq!(fields[0], Value::BulkString(b"flags".to_vec()));
+    assert_eq!(fields[7], Value::BulkString(b"~cached:*".to_vec()));
+    let user: Value = acl(con, &["GETUSER", "bob"]).unwrap();
+    assert_eq!(user, Value::Nil);
+
+    // Either all the rules are applied or none is
+    let err = acl::<()>(con, &["SETUSER", "alice", "off", "+nosuchcommand"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Error in ACL SETUSER modifier '+nosuchcommand': Unknown command or category name in ACL")
+    );
+    let err = acl::<()>(con, &["SETUSER", "alice", "#abc"]).unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+    let err = acl::<()>(con, &["NOSUCHSUBCOMMAND"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("unknown subcommand 'NOSUCHSUBCOMMAND'. Try ACL HELP.")
+    );
+
+    let mut alice_con = utils::get_connection(&test_server.port);
+    let _: String = redis::cmd("AUTH")
+        .arg(&["alice", "pw"])
+        .query(&mut alice_con)
+        .unwrap();
+    let err = acl::<String>(&mut alice_con, &["WHOAMI"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("User alice has no permissions to run the 'acl' command")
+    );
+    let _: () = con.set("cached:x", "1").unwrap();
+    let got: String = alice_con.get("cached:x").unwrap();
+    assert_eq!(got, "1");
+    let err = alice_con.get::<_, String>("other").unwrap_err();
+    assert_eq!(err.code(), Some("NOPERM"));
+    assert_eq!(err.detail(), Some("No permissions to access a key"));
+    let err = alice_con.set::<_, _, ()>("cached:x", "2").unwrap_err();
+    assert_eq!(err.code(), Some("NOPERM"));
+    assert_eq!(
+        err.detail(),
+        Some("User alice has no permissions to run the 'set' command")
+    );
+
+    // Deleting a user disconnects its clients, and the default user can't be deleted
+    let err = acl::<()>(con, &["DELUSER", "default"]).unwrap_err();
+    assert_eq!(err.detail(), Some("The 'default' user cannot be removed"));
+    let deleted: i64 = acl(con, &["DELUSER", "alice", "bob"]).unwrap();
+    assert_eq!(deleted, 1);
+    assert!(alice_con.get::<_, String>("cached:x").is_err());
+}
+
+#[test]
+fn test_acl_auth() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = acl(
+        con,
+        &["SETUSER", "bob", "off", ">pw", "allkeys", "allcommands"],
+    )
+    .unwrap();
+    let mut bob_con = utils::get_connection(&test_server.port);
+    let err = redis::cmd("AUTH")
+        .arg(&["bob", "pw"])
+        .query::<String>(&mut bob_con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGPASS"));
+
+    let _: () = acl(con, &["SETUSER", "bob", "on"]).unwrap();
+    let _: String = redis::cmd("AUTH")
+        .arg(&["bob", "pw"])
+        .query(&mut bob_con)
+        .unwrap();
+    let whoami: String = acl(&mut bob_con, &["WHOAMI"]).unwrap();
+    assert_eq!(whoami, "bob");
+    let clients: String = redis::cmd("CLIENT")
+        .arg("LIST")
+        .query(&mut bob_con)
+        .unwrap();
+    assert!(clients.contains(" user=bob "), "{clients}");
+
+    // RESET goes back to the default user
+    let _: String = redis::cmd("RESET").query(&mut bob_con).unwrap();
+    let whoami: String = acl(&mut bob_con, &["WHOAMI"]).unwrap();
+    assert_eq!(whoami, "default");
+}
+
+#[test]
+fn test_acl_movable_keys() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = acl(
+        con,
+        &["SETUSER", "carol", "on", ">pw", "~cached:*", "+@all"],
+    )
+    .unwrap();
+    let mut carol_con = utils::get_connection(&test_server.port);
+    let _: String = redis::cmd("AUTH")
+        .arg(&["carol", "pw"])
+        .query(&mut carol_con)
+        .unwrap();
+
+    // The keys are found after their number, after STREAMS or after the subcommand
+    let commands: [&[&str]; 9] = [
+        &["LMPOP", "2", "cached:a", "other", "LEFT"],
+        &["BLMPOP", "0.1", "1", "other", "LEFT"],
+        &["ZMPOP", "1", "other", "MIN"],
+        &["SINTERCARD", "2", "cached:a", "other"],
+        &["ZINTERCARD", "1", "other"],
+        &["XREAD", "STREAMS", "cached:a", "other", "0", "0"],
+        &["XREADGROUP", "GROUP", "g", "c", "STREAMS", "other", ">"],
+        &["OBJECT", "ENCODING", "other"],
+        &["MEMORY", "USAGE", "other"],
+    ];
+    for args in commands {
+        let err = redis::cmd(args[0])
+            .arg(&args[1..])
+            .query::<Value>(&mut carol_con)
+            .unwrap_err();
+        assert_eq!(err.code(), Some("NOPERM"), "{args:?}");
+        assert_eq!(err.detail(), Some("No permissions to access a key"));
+    }
+
+    let _: () = con.rpush("cached:a", "x").unwrap();
+    let popped: Value = redis::cmd("LMPOP")
+        .arg(&["2", "cached:b", "cached:a", "LEFT"])
+        .query(&mut carol_con)
+        .unwrap();
+    assert_eq!(
+        popped,
+        Value::Array(vec![
+            Value::BulkString(b"cached:a".to_vec()),
+            Value::Array(vec![Value::BulkString(b"x".to_vec())]),
+        ])
+    );
+    let count: i64 = redis::cmd("SINTERCARD")
+        .arg(&["2", "cached:a", "cached:b"])
+        .query(&mut carol_con)
+        .unwrap();
+    assert_eq!(count, 0);
+    let help: Vec<String> = redis::cmd("OBJECT")
+        .arg("HELP")
+        .query(&mut carol_con)
+        .unwrap();
+    assert!(!help.is_empty());
+}
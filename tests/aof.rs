@@ -0,0 +1,467 @@
+use redis::Commands;
+use std::{
+    collections::HashMap,
+    fs,
+    io::Write,
+    path::Path,
+    thread,
+    time::{Duration, Instant},
+};
+
+mod utils;
+
+// Start a server logging its write commands to the AOF in the given directory
+fn start_server_with_aof(dir: &Path) -> (utils::ChildGuard, String, redis::Connection) {
+    let port = utils::find_free_tcp_port().to_string();
+    let server = utils::start_server_with_args(&[
+        &port,
+        "--dir",
+        dir.to_str().unwrap(),
+        "--appendonly",
+        "yes",
+        "--appendfsync",
+        "always",
+    ]);
+    let connection = utils::get_connection(&port);
+    (server, port, connection)
+}
+
+fn read_aof(dir: &Path) -> String {
+    String::from_utf8(fs::read(dir.join("appendonly.aof")).unwrap()).unwrap()
+}
+
+#[test]
+fn test_aof_replay() {
+    let dir = utils::create_temp_dir("aof-replay");
+    let stream_id: String;
+
+    {
+        let (_server, _, mut con) = start_server_with_aof(&dir);
+        let _: () = con.set("string", "value").unwrap();
+        let _: () = redis::cmd("SET")
+            .arg(&["volatile", "value", "EX", "100"])
+            .query(&mut con)
+            .unwrap();
+        let _: () = redis::cmd("SETEX")
+            .arg(&["setex", "100", "value"])
+            .query(&mut con)
+            .unwrap();
+        let _: i64 = con.incr("counter", 5).unwrap();
+        let _: i64 = con.incr("counter", 1).unwrap();
+        let _: usize = con.rpush("list", &["a", "b", "c"]).unwrap();
+        let _: String = con.lpop("list", None).unwrap();
+        let _: usize = con.hset("hash", "field", "value").unwrap();
+        let _: usize = con.sadd("set", &["a", "b", "c"]).unwrap();
+        let _: Vec<String> = redis::cmd("SPOP")
+            .arg(&["set", "2"])
+            .query(&mut con)
+            .unwrap();
+        let _: usize = redis::cmd("ZADD")
+            .arg(&["zset", "1.5", "a", "-2", "b"])
+            .query(&mut con)
+            .unwrap();
+        stream_id = redis::cmd("XADD")
+            .arg(&["stream", "*", "field", "value"])
+            .query(&mut con)
+            .unwrap();
+        let _: i64 = con.expire("string", 1000).unwrap();
+        let _: i64 = con.persist("string").unwrap();
+        let _: () = redis::cmd("SET")
+            .arg(&["expired", "value", "PX", "100"])
+            .query(&mut con)
+            .unwrap();
+        // Reads and failed writes are not logged
+        let _: Option<String> = con.get("string").unwrap();
+        let _: Vec<String> = con.lrange("list", 0, -1).unwrap();
+        let _ = con.incr::<_, _, i64>("string", 1).unwrap_err();
+    }
+    let aof = read_aof(&dir);
+    assert!(aof.starts_with("*3\r\n$3\r\nSET\r\n$6\r\nstring\r\n$5\r\nvalue\r\n"));
+    assert!(!aof.contains("GET"), "{aof}");
+    assert!(!aof.contains("LRANGE"), "{aof}");
+    // Relative expiry times are logged as absolute ones
+    assert!(!aof.contains("EXPIRE\r\n"), "{aof}");
+    assert!(!aof.contains("SETEX"), "{aof}");
+    assert!(aof.contains("$9\r\nPEXPIREAT\r\n"), "{aof}");
+    assert!(aof.contains("$4\r\nPXAT\r\n"), "{aof}");
+    assert_eq!(aof.matches("INCRBY").count(), 2, "{aof}");
+    // The members picked by SPOP are logged as removed
+    assert!(!aof.contains("SPOP"), "{aof}");
+    assert!(aof.contains("*4\r\n$4\r\nSREM\r\n$3\r\nset\r\n"), "{aof}");
+    // The ID generated by XADD is logged in place of `*`
+    assert!(
+        aof.contains(&format!(
+            "$6\r\nstream\r\n${}\r\n{stream_id}\r\n",
+            stream_id.len()
+        )),
+        "{aof}"
+    );
+
+    thread::sleep(Duration::from_millis(200));
+    let (_server, _, mut con) = start_server_with_aof(&dir);
+    let dbsize: usize = redis::cmd("DBSIZE").query(&mut con).unwrap();
+    assert_eq!(dbsize, 9);
+    let get_result: String = con.get("string").unwrap();
+    assert_eq!(get_result, "value");
+    let ttl: i64 = con.ttl("string").unwrap();
+    assert_eq!(ttl, -1);
+    let ttl: i64 = con.ttl("volatile").unwrap();
+    assert!((98..=100).contains(&ttl), "{ttl}");
+    let ttl: i64 = con.ttl("setex").unwrap();
+    assert!((98..=100).contains(&ttl), "{ttl}");
+    let counter: i64 = con.get("counter").unwrap();
+    assert_eq!(counter, 6);
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["b", "c"]);
+    let hgetall_result: HashMap<String, String> = con.hgetall("hash").unwrap();
+    assert_eq!(
+        hgetall_result,
+        HashMap::from([("field".to_string(), "value".to_string())])
+    );
+    let zrange_result: Vec<(String, f64)> = redis::cmd("ZRANGE")
+        .arg(&["zset", "0", "-1", "WITHSCORES"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(
+        zrange_result,
+        [("b".to_string(), -2.0), ("a".to_string(), 1.5)]
+    );
+    let scard_result: usize = con.scard("set").unwrap();
+    assert_eq!(scard_result, 1);
+    let xrange_result: Vec<(String, Vec<String>)> = redis::cmd("XRANGE")
+        .arg(&["stream", "-", "+"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(
+        xrange_result,
+        [(stream_id, vec!["field".to_string(), "value".to_string()])]
+    );
+}
+
+#[test]
+fn test_aof_transactions_and_blocked_clients() {
+    let dir = utils::create_temp_dir("aof-transaction");
+
+    {
+        let (server, port, mut con) = start_server_with_aof(&dir);
+        let exec_result: (usize, i64) = redis::pipe()
+            .atomic()
+            .cmd("RPUSH")
+            .arg(&["queue", "a", "b"])
+            .cmd("INCR")
+            .arg("pushed")
+            .query(&mut con)
+            .unwrap();
+        assert_eq!(exec_result, (2, 1));
+
+        // An element handed over to a blocked client is logged as popped
+        let blocked = thread::spawn(move || {
+            let mut blocked_con = utils::get_connection(&port);
+            let blpop_result: (String, String) = redis::cmd("BLPOP")
+                .arg(&["empty", "0"])
+                .query(&mut blocked_con)
+                .unwrap();
+            blpop_result
+        });
+        thread::sleep(Duration::from_millis(200));
+        let _: usize = con.rpush("empty", &["x", "y"]).unwrap();
+        assert_eq!(
+            blocked.join().unwrap(),
+            ("empty".to_string(), "x".to_string())
+        );
+        drop(server);
+    }
+    let aof = read_aof(&dir);
+    assert!(aof.starts_with("*1\r\n$5\r\nMULTI\r\n"), "{aof}");
+    assert!(aof.contains("*1\r\n$4\r\nEXEC\r\n"), "{aof}");
+
+    // A crash in the middle of an append leaves an incomplete transaction, which is skipped
+    let mut file = fs::OpenOptions::new()
+        .append(true)
+        .open(dir.join("appendonly.aof"))
+        .unwrap();
+    file.write_all(
+        b"*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$6\r\npushed\r\n$1\r\n5\r\n*1\r\n$4\r\nEX",
+    )
+    .unwrap();
+    drop(file);
+
+    {
+        let (_server, _, mut con) = start_server_with_aof(&dir);
+        let lrange_result: Vec<String> = con.lrange("queue", 0, -1).unwrap();
+        assert_eq!(lrange_result, ["a", "b"]);
+        let lrange_result: Vec<String> = con.lrange("empty", 0, -1).unwrap();
+        assert_eq!(lrange_result, ["y"]);
+        let pushed: i64 = con.get("pushed").unwrap();
+        assert_eq!(pushed, 1);
+    }
+    // The AOF was rewritten without the incomplete part, so that new commands can be appended
+    assert!(!read_aof(&dir).contains("MULTI"));
+}
+
+#[test]
+fn test_bgrewriteaof() {
+    let dir = utils::create_temp_dir("aof-rewrite");
+
+    {
+        let (_server, _, mut con) = start_server_with_aof(&dir);
+        for _ in 0..100 {
+            let _: i64 = con.incr("counter", 1).unwrap();
+        }
+        let elements: Vec<String> = (0..100).map(|i| i.to_string()).collect();
+        let _: usize = con.rpush("list", &elements).unwrap();
+        let _: () = redis::cmd("SET")
+            .arg(&["volatile", "value", "EX", "100"])
+            .query(&mut con)
+            .unwrap();
+
+        let rewrite_result: String = redis::cmd("BGREWRITEAOF").query(&mut con).unwrap();
+        assert_eq!(
+            rewrite_result,
+            "Background append only file rewriting started"
+        );
+        // Writes during and after the rewrite are kept
+        let _: () = con.set("after", "rewrite").unwrap();
+        for _ in 0..50 {
+            if !read_aof(&dir).contains("INCR") {
+                break;
+            }
+            thread::sleep(Duration::from_millis(20));
+        }
+        let _: () = con.set("later", "write").unwrap();
+    }
+
+    // The counter is set once and the list is pushed in batches of 64 elements
+    let aof = read_aof(&dir);
+    assert!(!aof.contains("INCR"), "{aof}");
+    assert_eq!(aof.matches("RPUSH").count(), 2, "{aof}");
+    assert_eq!(fs::read_dir(&dir).unwrap().count(), 1);
+
+    let (_server, _, mut con) = start_server_with_aof(&dir);
+    let counter: i64 = con.get("counter").unwrap();
+    assert_eq!(counter, 100);
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result.len(), 100);
+    let ttl: i64 = con.ttl("volatile").unwrap();
+    assert!((98..=100).contains(&ttl), "{ttl}");
+    let get_result: String = con.get("after").unwrap();
+    assert_eq!(get_result, "rewrite");
+    let get_result: String = con.get("later").unwrap();
+    assert_eq!(get_result, "write");
+}
+
+#[test]
+fn test_aof_consumer_groups() {
+    let dir = utils::create_temp_dir("aof-groups");
+    type PendingEntries = Vec<(String, String, i64, i64)>;
+    let pending = |con: &mut redis::Connection| {
+        let pending: PendingEntries = redis::cmd("XPENDING")
+            .arg(&["stream", "group", "-", "+", "10"])
+            .query(con)
+            .unwrap();
+        pending
+            .into_iter()
+            .map(|(id, consumer, _, delivery_count)| (id, consumer, delivery_count))
+            .collect::<Vec<_>>()
+    };
+    let expected = [
+        ("1-0".to_string(), "bob".to_string(), 2),
+        ("2-0".to_string(), "alice".to_string(), 1),
+    ];
+
+    {
+        let (_server, _, mut con) = start_server_with_aof(&dir);
+        for id in ["1-0", "2-0"] {
+            let _: String = redis::cmd("XADD")
+                .arg(&["stream", id, "field", id])
+                .query(&mut con)
+                .unwrap();
+        }
+        let _: () = redis::cmd("XGROUP")
+            .arg(&["CREATE", "stream", "group", "0"])
+            .query(&mut con)
+            .unwrap();
+        let _: redis::Value = redis::cmd("XREADGROUP")
+            .arg(&["GROUP", "group", "alice", "STREAMS", "stream", ">"])
+            .query(&mut con)
+            .unwrap();
+        thread::sleep(Duration::from_millis(100));
+        // Replayed right away, the entry wouldn't be idle for long enough
+        let _: Vec<String> = redis::cmd("XCLAIM")
+            .arg(&["stream", "group", "bob", "100", "1-0", "JUSTID"])
+            .query(&mut con)
+            .unwrap();
+        let _: redis::Value = redis::cmd("XCLAIM")
+            .arg(&["stream", "group", "bob", "0", "1-0"])
+            .query(&mut con)
+            .unwrap();
+        assert_eq!(pending(&mut con), expected);
+    }
+
+    {
+        let (_server, _, mut con) = start_server_with_aof(&dir);
+        assert_eq!(pending(&mut con), expected);
+        let _: String = redis::cmd("BGREWRITEAOF").query(&mut con).unwrap();
+        for _ in 0..50 {
+            if !read_aof(&dir).contains("XREADGROUP") {
+                break;
+            }
+            thread::sleep(Duration::from_millis(20));
+        }
+    }
+    assert!(!read_aof(&dir).contains("XREADGROUP"));
+
+    let (_server, _, mut con) = start_server_with_aof(&dir);
+    assert_eq!(pending(&mut con), expected);
+}
+
+#[test]
+fn test_bgrewriteaof_stream_deletions() {
+    let dir = utils::create_temp_dir("aof-rewrite-streams");
+    let xinfo = |con: &mut redis::Connection, key: &str| -> HashMap<String, redis::Value> {
+        redis::cmd("XINFO")
+            .arg(&["STREAM", key])
+            .query(con)
+            .unwrap()
+    };
+
+    {
+        let (_server, _, mut con) = start_server_with_aof(&dir);
+        for (key, id) in [
+            ("stream", "1-0"),
+            ("stream", "2-0"),
+            ("stream", "3-0"),
+            ("empty", "1-0"),
+        ] {
+            let _: String = redis::cmd("XADD")
+                .arg(&[key, id, "field", id])
+                .query(&mut con)
+                .unwrap();
+        }
+        let _: usize = redis::cmd("XDEL")
+            .arg(&["stream", "3-0"])
+            .query(&mut con)
+            .unwrap();
+        let _: usize = redis::cmd("XDEL")
+            .arg(&["empty", "1-0"])
+            .query(&mut con)
+            .unwrap();
+        let _: String = redis::cmd("BGREWRITEAOF").query(&mut con).unwrap();
+        // Unlike XDEL itself, MAXDELETEDID of XSETID stays
+        for _ in 0..50 {
+            if !read_aof(&dir).contains("\r\nXDEL\r\n") {
+                break;
+            }
+            thread::sleep(Duration::from_millis(20));
+        }
+    }
+    assert!(!read_aof(&dir).contains("\r\nXDEL\r\n"));
+
+    // The IDs survive the deletions, and so does the stream left without entries
+    let (_server, _, mut con) = start_server_with_aof(&dir);
+    let info = xinfo(&mut con, "stream");
+    let field = |name: &str| -> String { redis::from_redis_value(&info[name]).unwrap() };
+    assert_eq!(field("length"), "2");
+    assert_eq!(field("last-generated-id"), "3-0");
+    assert_eq!(field("max-deleted-entry-id"), "3-0");
+    assert_eq!(field("entries-added"), "3");
+    let info = xinfo(&mut con, "empty");
+    let field = |name: &str| -> String { redis::from_redis_value(&info[name]).unwrap() };
+    assert_eq!(field("length"), "0");
+    assert_eq!(field("last-generated-id"), "1-0");
+}
+
+#[test]
+fn test_aof_created_from_rdb_file() {
+    let dir = utils::create_temp_dir("aof-from-rdb");
+
+    // Without AOF, the keys are only saved to the RDB file
+    {
+        let port = utils::find_free_tcp_port().to_string();
+        let _server = utils::start_server_with_args(&[&port, "--dir", dir.to_str().unwrap()]);
+        let mut con = utils::get_connection(&port);
+        let _: () = con.set("foo", "bar").unwrap();
+        let _: () = redis::cmd("SAVE").query(&mut con).unwrap();
+    }
+    assert!(!dir.join("appendonly.aof").exists());
+
+    // Enabling the AOF loads the RDB file and creates the AOF from it
+    {
+        let (_server, _, mut con) = start_server_with_aof(&dir);
+        let get_result: String = con.get("foo").unwrap();
+        assert_eq!(get_result, "bar");
+    }
+    assert!(read_aof(&dir).contains("foo"));
+    fs::remove_file(dir.join("dump.rdb")).unwrap();
+    let (_server, _, mut con) = start_server_with_aof(&dir);
+    let get_result: String = con.get("foo").unwrap();
+    assert_eq!(get_result, "bar");
+}
+
+#[test]
+fn test_waitaof() {
+    let dir = utils::create_temp_dir("aof-waitaof");
+
+    // With `appendfsync everysec` a write is flushed to the AOF within a second
+    {
+        let port = utils::find_free_tcp_port().to_string();
+        let _server = utils::start_server_with_args(&[
+            &port,
+            "--dir",
+            dir.to_str().unwrap(),
+            "--appendonly",
+            "yes",
+        ]);
+        let mut con = utils::get_connection(&port);
+        let waitaof_result: (i64, i64) = redis::cmd("WAITAOF")
+            .arg(&[1, 0, 0])
+            .query(&mut con)
+            .unwrap();
+        assert_eq!(waitaof_result, (1, 0));
+        let _: () = con.set("foo", "bar").unwrap();
+        let start = Instant::now();
+        let waitaof_result: (i64, i64) = redis::cmd("WAITAOF")
+            .arg(&[1, 0, 0])
+            .query(&mut con)
+            .unwrap();
+        assert_eq!(waitaof_result, (1, 0));
+        assert!(start.elapsed() < Duration::from_secs(2));
+        // No replica flushes it, so the timeout elapses
+        let waitaof_result: (i64, i64) = redis::cmd("WAITAOF")
+            .arg(&[1, 1, 100])
+            .query(&mut con)
+            .unwrap();
+        assert_eq!(waitaof_result, (1, 0));
+    }
+
+    // With `appendfsync always` it is flushed before being replied to
+    {
+        let (_server, _, mut con) = start_server_with_aof(&dir);
+        let _: () = con.set("foo", "baz").unwrap();
+        let waitaof_result: (i64, i64) = redis::cmd("WAITAOF")
+            .arg(&[0, 0, 0])
+            .query(&mut con)
+            .unwrap();
+        assert_eq!(waitaof_result, (1, 0));
+    }
+
+    // Without AOF nothing is flushed locally
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("foo", "bar").unwrap();
+    let waitaof_result: (i64, i64) = redis::cmd("WAITAOF").arg(&[0, 0, 0]).query(con).unwrap();
+    assert_eq!(waitaof_result, (0, 0));
+    let err = redis::cmd("WAITAOF")
+        .arg(&[1, 0, 0])
+        .query::<(i64, i64)>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+    );
+    let err = redis::cmd("WAITAOF")
+        .arg(&[0, 0, -1])
+        .query::<(i64, i64)>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("timeout is negative"));
+}
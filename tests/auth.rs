@@ -0,0 +1,83 @@
+use redis::{Commands, Value};
+
+mod utils;
+
+fn auth(con: &mut redis::Connection, args: &[&str]) -> redis::RedisResult<String> {
+    redis::cmd("AUTH").arg(args).query(con)
+}
+
+#[test]
+fn test_requirepass() {
+    let port = utils::find_free_tcp_port().to_string();
+    let _server = utils::start_server_with_args(&[&port, "--requirepass", "s3cret"]);
+    let mut con = utils::get_connection(&port);
+
+    for command in ["GET", "PING"] {
+        let err = redis::cmd(command)
+            .arg("foo")
+            .query::<Value>(&mut con)
+            .unwrap_err();
+        assert_eq!(err.code(), Some("NOAUTH"));
+        assert_eq!(err.detail(), Some("Authentication required."));
+    }
+    let err = redis::cmd("NOSUCHCOMMAND")
+        .query::<Value>(&mut con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+
+    let err = auth(&mut con, &["wrong"]).unwrap_err();
+    assert_eq!(err.code(), Some("WRONGPASS"));
+    assert_eq!(
+        err.detail(),
+        Some("invalid username-password pair or user is disabled.")
+    );
+    assert_eq!(auth(&mut con, &["s3cret"]).unwrap(), "OK");
+    let _: () = con.set("foo", "bar").unwrap();
+
+    // The user may be given, and it can only be the default one
+    let mut other_con = utils::get_connection(&port);
+    let err = auth(&mut other_con, &["admin", "s3cret"]).unwrap_err();
+    assert_eq!(err.code(), Some("WRONGPASS"));
+    assert_eq!(auth(&mut other_con, &["default", "s3cret"]).unwrap(), "OK");
+    let got: String = other_con.get("foo").unwrap();
+    assert_eq!(got, "bar");
+    let err = auth(&mut other_con, &["default", "s3cret", "extra"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+
+    // RESET goes back to an unauthenticated connection
+    let _: String = redis::cmd("RESET").query(&mut other_con).unwrap();
+    let err = other_con.get::<_, String>("foo").unwrap_err();
+    assert_eq!(err.code(), Some("NOAUTH"));
+}
+
+#[test]
+fn test_requirepass_changed() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Without a password, only the default user with any password is accepted
+    let err = auth(con, &["s3cret"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+    );
+    assert_eq!(auth(con, &["default", "anything"]).unwrap(), "OK");
+
+    // The connections which are already open stay authenticated
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "requirepass", "s3cret"])
+        .query(con)
+        .unwrap();
+    let _: () = con.set("foo", "bar").unwrap();
+    let mut other_con = utils::get_connection(&test_server.port);
+    let err = other_con.get::<_, String>("foo").unwrap_err();
+    assert_eq!(err.code(), Some("NOAUTH"));
+
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "requirepass", ""])
+        .query(con)
+        .unwrap();
+    let mut other_con = utils::get_connection(&test_server.port);
+    let got: String = other_con.get("foo").unwrap();
+    assert_eq!(got, "bar");
+}
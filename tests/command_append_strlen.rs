@@ -0,0 +1,74 @@
+use redis::Commands;
+
+mod utils;
+
+#[test]
+fn test_append() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // A missing key is created
+    let len: usize = con.append("key", "Hello").unwrap();
+    assert_eq!(len, 5);
+    let len: usize = con.append("key", " World").unwrap();
+    assert_eq!(len, 11);
+    let val: String = con.get("key").unwrap();
+    assert_eq!(val, "Hello World");
+
+    // The TTL is retained
+    let _: () = redis::cmd("SET")
+        .arg(&["ttl", "a", "EX", "100"])
+        .query(con)
+        .unwrap();
+    let _: usize = con.append("ttl", "b").unwrap();
+    let ttl: i64 = con.ttl("ttl").unwrap();
+    assert!(ttl > 0);
+
+    let _: usize = con.rpush("list", "a").unwrap();
+    let err = con.append::<_, _, usize>("list", "x").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_strlen() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("key", "Hello").unwrap();
+    let len: usize = con.strlen("key").unwrap();
+    assert_eq!(len, 5);
+    let _: () = con.set("number", 12345).unwrap();
+    let len: usize = con.strlen("number").unwrap();
+    assert_eq!(len, 5);
+    let len: usize = con.strlen("missing").unwrap();
+    assert_eq!(len, 0);
+
+    let _: usize = con.rpush("list", "a").unwrap();
+    let err = con.strlen::<_, usize>("list").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_append_multibyte() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Lengths are in bytes, not characters
+    let mut expected = String::new();
+    for chunk in ["héllo", " ", "wörld", " ", "日本語", "🦀", "\0\u{7f}"] {
+        expected.push_str(chunk);
+        let len: usize = con.append("key", chunk).unwrap();
+        assert_eq!(len, expected.len());
+        let strlen: usize = con.strlen("key").unwrap();
+        assert_eq!(strlen, len);
+    }
+    let val: String = con.get("key").unwrap();
+    assert_eq!(val, expected);
+
+    // Many appends, each growing the string
+    for _ in 0..1000 {
+        let _: usize = con.append("long", "ab").unwrap();
+    }
+    let len: usize = con.strlen("long").unwrap();
+    assert_eq!(len, 2000);
+}
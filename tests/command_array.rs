@@ -91,3 +91,125 @@ fn test_lpop() {
     let list_size: usize = con.llen("list").unwrap();
     assert_eq!(list_size, 1);
 }
+
+#[test]
+fn test_pop_count() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.rpush("list", &["a", "b", "c", "d", "e"]).unwrap();
+
+    // Without a count a single element is popped, with a count an array of them
+    let rpop_result: String = con.rpop("list", None).unwrap();
+    assert_eq!(rpop_result, "e");
+    let rpop_result: Vec<String> = redis::cmd("RPOP").arg(&["list", "1"]).query(con).unwrap();
+    assert_eq!(rpop_result, ["d"]);
+    let rpop_result: Vec<String> = redis::cmd("RPOP").arg(&["list", "0"]).query(con).unwrap();
+    assert!(rpop_result.is_empty());
+    for command in ["LPOP", "RPOP"] {
+        let err = redis::cmd(command)
+            .arg(&["list", "-1"])
+            .query::<Vec<String>>(con)
+            .unwrap_err();
+        assert_eq!(
+            err.detail(),
+            Some("value is out of range, must be positive")
+        );
+    }
+    let err = redis::cmd("RPOP")
+        .arg(&["list", "1", "2"])
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'rpop' command")
+    );
+
+    // The key is removed along with the last element
+    let rpop_result: Vec<String> = con.rpop("list", NonZero::new(10)).unwrap();
+    assert_eq!(rpop_result, ["c", "b", "a"]);
+    let exists: bool = con.exists("list").unwrap();
+    assert!(!exists);
+    let rpop_result: Option<String> = con.rpop("list", None).unwrap();
+    assert_eq!(rpop_result, None);
+    let lpop_result: redis::Value = redis::cmd("LPOP").arg(&["list", "2"]).query(con).unwrap();
+    assert_eq!(lpop_result, redis::Value::Nil);
+
+    let _: usize = con.rpush("list", "a").unwrap();
+    let lpop_result: String = con.lpop("list", None).unwrap();
+    assert_eq!(lpop_result, "a");
+    let exists: bool = con.exists("list").unwrap();
+    assert!(!exists);
+}
+
+#[test]
+fn test_lrange_edge_cases() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.rpush("list", &["a", "b", "c", "d", "e"]).unwrap();
+
+    // (start, stop, expected)
+    let cases: &[(isize, isize, &[&str])] = &[
+        (0, -1, &["a", "b", "c", "d", "e"]),
+        (-3, -2, &["c", "d"]),
+        (-1, -1, &["e"]),
+        // Negative start beyond the head is clamped to the head
+        (-100, 1, &["a", "b"]),
+        (-100, -100, &[]),
+        (-100, 100, &["a", "b", "c", "d", "e"]),
+        // Stop beyond the tail is clamped to the tail
+        (3, 100, &["d", "e"]),
+        // Start beyond the tail gives an empty range
+        (5, 10, &[]),
+        (100, -1, &[]),
+        // Start after stop gives an empty range
+        (3, 1, &[]),
+        (-1, -2, &[]),
+        (2, -4, &[]),
+    ];
+    for &(start, stop, expected) in cases {
+        let lrange_result: Vec<String> = con.lrange("list", start, stop).unwrap();
+        assert_eq!(lrange_result, expected, "LRANGE list {start} {stop}");
+    }
+}
+
+#[test]
+fn test_list_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("string", "value").unwrap();
+    for command in ["LPUSH", "RPUSH"] {
+        let err = redis::cmd(command)
+            .arg("string")
+            .arg("x")
+            .query::<usize>(con)
+            .unwrap_err();
+        assert_eq!(err.code(), Some("WRONGTYPE"));
+    }
+    let err = con.lrange::<_, Vec<String>>("string", 0, -1).unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = con.llen::<_, usize>("string").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    // The string is left untouched
+    let get_result: String = con.get("string").unwrap();
+    assert_eq!(get_result, "value");
+
+    let err = redis::cmd("LRANGE")
+        .arg("list")
+        .arg("a")
+        .arg(1)
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+
+    // A push without any value doesn't create the key
+    let err = redis::cmd("RPUSH")
+        .arg("list")
+        .query::<usize>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+    let exists: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(exists, 1);
+}
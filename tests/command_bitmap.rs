@@ -0,0 +1,202 @@
+use redis::Commands;
+
+mod utils;
+
+fn setbit(con: &mut redis::Connection, key: &str, offset: usize, bit: u8) -> u8 {
+    redis::cmd("SETBIT")
+        .arg(key)
+        .arg(offset)
+        .arg(bit)
+        .query(con)
+        .unwrap()
+}
+
+fn getbit(con: &mut redis::Connection, key: &str, offset: usize) -> u8 {
+    redis::cmd("GETBIT")
+        .arg(key)
+        .arg(offset)
+        .query(con)
+        .unwrap()
+}
+
+fn bitcount(con: &mut redis::Connection, args: &[&str]) -> redis::RedisResult<i64> {
+    redis::cmd("BITCOUNT").arg(args).query(con)
+}
+
+fn bitpos(con: &mut redis::Connection, args: &[&str]) -> redis::RedisResult<i64> {
+    redis::cmd("BITPOS").arg(args).query(con)
+}
+
+#[test]
+fn test_setbit_getbit() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // The string grows with zero bytes up to the byte of the bit
+    assert_eq!(setbit(con, "bits", 7, 1), 0);
+    let get_result: Vec<u8> = con.get("bits").unwrap();
+    assert_eq!(get_result, [0x01]);
+    assert_eq!(setbit(con, "bits", 8, 1), 0);
+    assert_eq!(setbit(con, "bits", 23, 1), 0);
+    let get_result: Vec<u8> = con.get("bits").unwrap();
+    assert_eq!(get_result, [0x01, 0x80, 0x01]);
+    // The old bit is returned
+    assert_eq!(setbit(con, "bits", 8, 0), 1);
+    assert_eq!(setbit(con, "bits", 8, 0), 0);
+    let get_result: Vec<u8> = con.get("bits").unwrap();
+    assert_eq!(get_result, [0x01, 0x00, 0x01]);
+
+    assert_eq!(getbit(con, "bits", 7), 1);
+    assert_eq!(getbit(con, "bits", 6), 0);
+    assert_eq!(getbit(con, "bits", 23), 1);
+    assert_eq!(getbit(con, "bits", 1000), 0);
+    assert_eq!(getbit(con, "missing", 0), 0);
+
+    // Clearing a bit of a missing key still creates it
+    assert_eq!(setbit(con, "zeros", 9, 0), 0);
+    let get_result: Vec<u8> = con.get("zeros").unwrap();
+    assert_eq!(get_result, [0, 0]);
+    // The bits of any string can be set
+    let _: () = con.set("string", "a").unwrap();
+    assert_eq!(setbit(con, "string", 6, 1), 0);
+    let get_result: String = con.get("string").unwrap();
+    assert_eq!(get_result, "c");
+
+    for (offset, bit, expected) in [
+        ("-1", "1", "bit offset is not an integer or out of range"),
+        (
+            "4294967296",
+            "1",
+            "bit offset is not an integer or out of range",
+        ),
+        ("abc", "1", "bit offset is not an integer or out of range"),
+        ("0", "2", "bit is not an integer or out of range"),
+    ] {
+        let err = redis::cmd("SETBIT")
+            .arg(&["bits", offset, bit])
+            .query::<u8>(con)
+            .unwrap_err();
+        assert_eq!(err.detail(), Some(expected), "{offset} {bit}");
+    }
+    let _: usize = con.rpush("list", "a").unwrap();
+    let err = redis::cmd("GETBIT")
+        .arg(&["list", "0"])
+        .query::<u8>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_bitcount() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("key", "foobar").unwrap();
+    assert_eq!(bitcount(con, &["key"]).unwrap(), 26);
+    assert_eq!(bitcount(con, &["key", "0", "0"]).unwrap(), 4);
+    assert_eq!(bitcount(con, &["key", "1", "1"]).unwrap(), 6);
+    assert_eq!(bitcount(con, &["key", "1", "-2"]).unwrap(), 18);
+    assert_eq!(bitcount(con, &["key", "-100", "100"]).unwrap(), 26);
+    assert_eq!(bitcount(con, &["key", "3", "1"]).unwrap(), 0);
+    // A range of bits may start and end in the middle of bytes
+    assert_eq!(bitcount(con, &["key", "5", "30", "BIT"]).unwrap(), 17);
+    assert_eq!(bitcount(con, &["key", "1", "1", "bit"]).unwrap(), 1);
+    assert_eq!(bitcount(con, &["key", "-3", "-1", "BIT"]).unwrap(), 1);
+    assert_eq!(bitcount(con, &["key", "0", "0", "BYTE"]).unwrap(), 4);
+    assert_eq!(bitcount(con, &["missing"]).unwrap(), 0);
+    assert_eq!(bitcount(con, &["missing", "0", "-1"]).unwrap(), 0);
+
+    let err = bitcount(con, &["key", "0"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let err = bitcount(con, &["key", "0", "1", "WORD"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let err = bitcount(con, &["key", "a", "1"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("value is not an integer or out of range")
+    );
+}
+
+#[test]
+fn test_bitpos() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("key", b"\xff\xf0\x00".as_slice()).unwrap();
+    assert_eq!(bitpos(con, &["key", "0"]).unwrap(), 12);
+    assert_eq!(bitpos(con, &["key", "1"]).unwrap(), 0);
+    assert_eq!(bitpos(con, &["key", "1", "2"]).unwrap(), -1);
+    assert_eq!(bitpos(con, &["key", "1", "1", "-1"]).unwrap(), 8);
+    assert_eq!(bitpos(con, &["key", "1", "10", "20", "BIT"]).unwrap(), 10);
+    assert_eq!(bitpos(con, &["key", "0", "2", "15", "BIT"]).unwrap(), 12);
+    assert_eq!(bitpos(con, &["key", "1", "12", "-1", "BIT"]).unwrap(), -1);
+
+    // Without an end, the string is as if padded with zero bytes when looking for a clear bit
+    let _: () = con.set("ones", b"\xff\xff".as_slice()).unwrap();
+    assert_eq!(bitpos(con, &["ones", "0"]).unwrap(), 16);
+    assert_eq!(bitpos(con, &["ones", "0", "1"]).unwrap(), 16);
+    assert_eq!(bitpos(con, &["ones", "0", "0", "-1"]).unwrap(), -1);
+    assert_eq!(bitpos(con, &["missing", "0"]).unwrap(), 0);
+    assert_eq!(bitpos(con, &["missing", "1"]).unwrap(), -1);
+
+    let err = bitpos(con, &["key", "2"]).unwrap_err();
+    assert_eq!(err.detail(), Some("The bit argument must be 1 or 0."));
+    let err = bitpos(con, &["key", "1", "0", "1", "BIT", "extra"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
+
+fn bitop(con: &mut redis::Connection, args: &[&str]) -> redis::RedisResult<usize> {
+    redis::cmd("BITOP").arg(args).query(con)
+}
+
+#[test]
+fn test_bitop() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("a", b"\xf0\x0f\xff".as_slice()).unwrap();
+    let _: () = con.set("b", b"\x3c".as_slice()).unwrap();
+
+    // The shorter string is padded with zero bytes
+    assert_eq!(bitop(con, &["XOR", "dest", "a", "b"]).unwrap(), 3);
+    let get_result: Vec<u8> = con.get("dest").unwrap();
+    assert_eq!(get_result, [0xcc, 0x0f, 0xff]);
+    assert_eq!(bitop(con, &["and", "dest", "a", "b"]).unwrap(), 3);
+    let get_result: Vec<u8> = con.get("dest").unwrap();
+    assert_eq!(get_result, [0x30, 0x00, 0x00]);
+    assert_eq!(bitop(con, &["OR", "dest", "b", "a", "missing"]).unwrap(), 3);
+    let get_result: Vec<u8> = con.get("dest").unwrap();
+    assert_eq!(get_result, [0xfc, 0x0f, 0xff]);
+    assert_eq!(bitop(con, &["NOT", "dest", "a"]).unwrap(), 3);
+    let get_result: Vec<u8> = con.get("dest").unwrap();
+    assert_eq!(get_result, [0x0f, 0xf0, 0x00]);
+    // The operation on a single string is the string itself
+    assert_eq!(bitop(con, &["XOR", "dest", "b"]).unwrap(), 1);
+    let get_result: Vec<u8> = con.get("dest").unwrap();
+    assert_eq!(get_result, [0x3c]);
+
+    // The destination may be a source, and its TTL is removed
+    let _: bool = con.expire("dest", 100).unwrap();
+    assert_eq!(bitop(con, &["AND", "dest", "dest", "a"]).unwrap(), 3);
+    let get_result: Vec<u8> = con.get("dest").unwrap();
+    assert_eq!(get_result, [0x30, 0x00, 0x00]);
+    let ttl: i64 = con.ttl("dest").unwrap();
+    assert_eq!(ttl, -1);
+
+    // An empty result removes the destination
+    assert_eq!(bitop(con, &["OR", "dest", "missing", "other"]).unwrap(), 0);
+    let exists_result: bool = con.exists("dest").unwrap();
+    assert!(!exists_result);
+
+    let err = bitop(con, &["NOT", "dest", "a", "b"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("BITOP NOT must be called with a single source key.")
+    );
+    let err = bitop(con, &["NAND", "dest", "a", "b"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let _: usize = con.rpush("list", "a").unwrap();
+    let err = bitop(con, &["OR", "dest", "a", "list"]).unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let exists_result: bool = con.exists("dest").unwrap();
+    assert!(!exists_result);
+}
@@ -0,0 +1,169 @@
+use redis::Commands;
+use std::{
+    io::Write,
+    net::TcpStream,
+    thread,
+    time::{Duration, Instant},
+};
+
+mod utils;
+
+// Run BLPOP/BRPOP on a new connection in a separate thread
+fn spawn_blocking_pop(
+    port: &str,
+    command: &'static str,
+    keys: &'static [&'static str],
+    timeout: f64,
+) -> thread::JoinHandle<Option<(String, String)>> {
+    let mut con = utils::get_connection(port);
+    thread::spawn(move || {
+        redis::cmd(command)
+            .arg(keys)
+            .arg(timeout)
+            .query(&mut con)
+            .unwrap()
+    })
+}
+
+#[test]
+fn test_blocking_pop_available() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.rpush("list", &["a", "b", "c"]).unwrap();
+
+    let blpop_result: Option<(String, String)> =
+        redis::cmd("BLPOP").arg("list").arg(0).query(con).unwrap();
+    assert_eq!(blpop_result, Some(("list".to_string(), "a".to_string())));
+    let brpop_result: Option<(String, String)> =
+        redis::cmd("BRPOP").arg("list").arg(0).query(con).unwrap();
+    assert_eq!(brpop_result, Some(("list".to_string(), "c".to_string())));
+
+    // The first non-empty list is popped from
+    let blpop_result: Option<(String, String)> = redis::cmd("BLPOP")
+        .arg(&["empty", "list"])
+        .arg(0)
+        .query(con)
+        .unwrap();
+    assert_eq!(blpop_result, Some(("list".to_string(), "b".to_string())));
+    // The key is removed along with its last element
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 0);
+}
+
+#[test]
+fn test_blocking_pop_timeout() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let start = Instant::now();
+    let blpop_result: Option<(String, String)> =
+        redis::cmd("BLPOP").arg("list").arg(0.2).query(con).unwrap();
+    assert_eq!(blpop_result, None);
+    assert!(start.elapsed() >= Duration::from_millis(200));
+
+    // The connection is usable after the timeout
+    let ping_result: String = con.ping().unwrap();
+    assert_eq!(ping_result, "PONG");
+}
+
+#[test]
+fn test_blocking_pop_woken_by_push() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let blocked = spawn_blocking_pop(&test_server.port, "BRPOP", &["list1", "list2"], 0.0);
+    thread::sleep(Duration::from_millis(200));
+
+    // Reply of the push is the length before the element is handed over
+    let rpush_result: usize = con.rpush("list2", "x").unwrap();
+    assert_eq!(rpush_result, 1);
+    assert_eq!(
+        blocked.join().unwrap(),
+        Some(("list2".to_string(), "x".to_string()))
+    );
+    let list_size: usize = con.llen("list2").unwrap();
+    assert_eq!(list_size, 0);
+}
+
+#[test]
+fn test_blocking_pop_fifo() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let first = spawn_blocking_pop(&test_server.port, "BLPOP", &["queue"], 5.0);
+    thread::sleep(Duration::from_millis(100));
+    let second = spawn_blocking_pop(&test_server.port, "BLPOP", &["queue"], 5.0);
+    thread::sleep(Duration::from_millis(100));
+    let third = spawn_blocking_pop(&test_server.port, "BLPOP", &["queue"], 0.5);
+    thread::sleep(Duration::from_millis(100));
+
+    // One element per blocked client, in the order they blocked
+    let rpush_result: usize = con.rpush("queue", &["a", "b"]).unwrap();
+    assert_eq!(rpush_result, 2);
+    assert_eq!(
+        first.join().unwrap(),
+        Some(("queue".to_string(), "a".to_string()))
+    );
+    assert_eq!(
+        second.join().unwrap(),
+        Some(("queue".to_string(), "b".to_string()))
+    );
+    assert_eq!(third.join().unwrap(), None);
+}
+
+#[test]
+fn test_blocking_pop_disconnected_client() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Block a client forever and then disconnect it
+    let mut stream = TcpStream::connect(format!("127.0.0.1:{}", test_server.port)).unwrap();
+    stream.write_all(b"BLPOP queue 0\r\n").unwrap();
+    thread::sleep(Duration::from_millis(100));
+    drop(stream);
+    thread::sleep(Duration::from_millis(100));
+
+    let blocked = spawn_blocking_pop(&test_server.port, "BLPOP", &["queue"], 5.0);
+    thread::sleep(Duration::from_millis(100));
+
+    // The element goes to the connected client instead of being lost to the disconnected one
+    let _: usize = con.rpush("queue", "a").unwrap();
+    assert_eq!(
+        blocked.join().unwrap(),
+        Some(("queue".to_string(), "a".to_string()))
+    );
+
+    // No one is blocked anymore, so the element stays in the list
+    let _: usize = con.rpush("queue", "b").unwrap();
+    let lrange_result: Vec<String> = con.lrange("queue", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["b"]);
+}
+
+#[test]
+fn test_blocking_pop_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("string", "value").unwrap();
+    let err = redis::cmd("BLPOP")
+        .arg("string")
+        .arg(0)
+        .query::<Option<(String, String)>>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+
+    for (timeout, message) in [
+        ("-1", "timeout is negative"),
+        ("abc", "timeout is not a float or out of range"),
+        ("inf", "timeout is not a float or out of range"),
+    ] {
+        let err = redis::cmd("BRPOP")
+            .arg("list")
+            .arg(timeout)
+            .query::<Option<(String, String)>>(con)
+            .unwrap_err();
+        assert_eq!(err.code(), Some("ERR"));
+        assert_eq!(err.detail(), Some(message));
+    }
+}
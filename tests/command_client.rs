@@ -0,0 +1,265 @@
+use redis::{Commands, Value};
+use std::{
+    thread,
+    time::{Duration, Instant},
+};
+
+mod utils;
+
+fn client<T: redis::FromRedisValue>(con: &mut redis::Connection, args: &[&str]) -> T {
+    redis::cmd("CLIENT").arg(args).query(con).unwrap()
+}
+
+// Line of CLIENT LIST describing the connection with the ID
+fn list_line(con: &mut redis::Connection, id: i64) -> String {
+    let list: String = client(con, &["LIST"]);
+    list.lines()
+        .find(|line| line.starts_with(&format!("id={id} ")))
+        .unwrap_or_else(|| panic!("no connection {id} in {list:?}"))
+        .to_owned()
+}
+
+#[test]
+fn test_client_id_and_name() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let mut other_con = utils::get_connection(&test_server.port);
+    let con = &mut test_server.connection;
+
+    let id: i64 = client(con, &["ID"]);
+    let other_id: i64 = client(&mut other_con, &["id"]);
+    assert!(other_id > id);
+
+    let name: Option<String> = client(con, &["GETNAME"]);
+    assert_eq!(name, None);
+    let _: () = client(con, &["SETNAME", "worker-1"]);
+    let name: Option<String> = client(con, &["GETNAME"]);
+    assert_eq!(name.as_deref(), Some("worker-1"));
+    // Names are per connection
+    let name: Option<String> = client(&mut other_con, &["GETNAME"]);
+    assert_eq!(name, None);
+
+    for name in ["worker 1", "worker\n1"] {
+        let err = redis::cmd("CLIENT")
+            .arg(&["SETNAME", name])
+            .query::<Value>(con)
+            .unwrap_err();
+        assert_eq!(
+            err.detail(),
+            Some("Client names cannot contain spaces, newlines or special characters.")
+        );
+    }
+    // An empty name removes the name
+    let _: () = client(con, &["SETNAME", ""]);
+    let name: Option<String> = client(con, &["GETNAME"]);
+    assert_eq!(name, None);
+}
+
+#[test]
+fn test_client_list() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let mut other_con = utils::get_connection(&test_server.port);
+    let con = &mut test_server.connection;
+    let id: i64 = client(con, &["ID"]);
+    let other_id: i64 = client(&mut other_con, &["ID"]);
+
+    let _: () = client(con, &["SETNAME", "lister"]);
+    let _: () = redis::cmd("SELECT").arg(2).query(con).unwrap();
+    let line = list_line(con, id);
+    let fields: Vec<&str> = line.split(' ').collect();
+    assert!(fields[1].starts_with("addr=127.0.0.1:"), "{line}");
+    assert_eq!(fields[2], format!("laddr=127.0.0.1:{}", test_server.port));
+    assert_eq!(fields[3], "name=lister");
+    assert!(fields.contains(&"flags=N"), "{line}");
+    assert!(fields.contains(&"db=2"), "{line}");
+    assert!(fields.contains(&"multi=-1"), "{line}");
+    assert!(fields.contains(&"cmd=client|list"), "{line}");
+    assert!(fields.contains(&"resp=2"), "{line}");
+
+    // The state of the other connections is listed as of their last command
+    let _: () = redis::cmd("MULTI").query(&mut other_con).unwrap();
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "bar"])
+        .query(&mut other_con)
+        .unwrap();
+    let line = list_line(con, other_id);
+    assert!(line.contains(" name= "), "{line}");
+    assert!(line.contains(" flags=x "), "{line}");
+    assert!(line.contains(" multi=1 "), "{line}");
+    assert!(line.contains(" cmd=set "), "{line}");
+    let _: () = redis::cmd("DISCARD").query(&mut other_con).unwrap();
+
+    let _: () = client(&mut other_con, &["NO-EVICT", "on"]);
+    assert!(list_line(con, other_id).contains(" flags=e "));
+    let _: () = client(&mut other_con, &["NO-EVICT", "OFF"]);
+    assert!(list_line(con, other_id).contains(" flags=N "));
+    let err = redis::cmd("CLIENT")
+        .arg(&["NO-EVICT", "maybe"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+
+    // Closed connections are removed from the list
+    drop(other_con);
+    thread::sleep(Duration::from_millis(100));
+    let list: String = client(con, &["LIST"]);
+    assert_eq!(list.lines().count(), 1, "{list}");
+}
+
+#[test]
+fn test_client_kill() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let port = test_server.port.clone();
+    let con = &mut test_server.connection;
+    let id: i64 = client(con, &["ID"]);
+
+    let mut other_con = utils::get_connection(&port);
+    let other_id: i64 = client(&mut other_con, &["ID"]);
+    let killed: i64 = client(con, &["KILL", "ID", &other_id.to_string()]);
+    assert_eq!(killed, 1);
+    let result = redis::cmd("PING").query::<String>(&mut other_con);
+    assert!(result.is_err(), "{result:?}");
+    // Nothing is left to kill
+    let killed: i64 = client(con, &["KILL", "ID", &other_id.to_string()]);
+    assert_eq!(killed, 0);
+    // The own connection is spared unless SKIPME is no
+    let killed: i64 = client(con, &["KILL", "ID", &id.to_string()]);
+    assert_eq!(killed, 0);
+
+    // A blocked client is unblocked without taking anything
+    let mut blocked_con = utils::get_connection(&port);
+    let blocked_id: i64 = client(&mut blocked_con, &["ID"]);
+    let blocked = thread::spawn(move || {
+        redis::cmd("BLPOP")
+            .arg(&["list", "0"])
+            .query::<Value>(&mut blocked_con)
+    });
+    thread::sleep(Duration::from_millis(200));
+    let killed: i64 = client(
+        con,
+        &["KILL", "ID", &blocked_id.to_string(), "SKIPME", "yes"],
+    );
+    assert_eq!(killed, 1);
+    assert!(blocked.join().unwrap().is_err());
+    let _: usize = con.rpush("list", "a").unwrap();
+    let len: usize = con.llen("list").unwrap();
+    assert_eq!(len, 1);
+
+    // The old form takes the address of the client
+    let mut other_con = utils::get_connection(&port);
+    let other_id: i64 = client(&mut other_con, &["ID"]);
+    let line = list_line(con, other_id);
+    let addr = line
+        .split(' ')
+        .nth(1)
+        .unwrap()
+        .strip_prefix("addr=")
+        .unwrap();
+    let _: () = client(con, &["KILL", addr]);
+    assert!(redis::cmd("PING").query::<String>(&mut other_con).is_err());
+    let err = redis::cmd("CLIENT")
+        .arg(&["KILL", addr])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("No such client"));
+
+    let err = redis::cmd("CLIENT")
+        .arg(&["KILL", "ID", "0"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("client-id should be greater than 0"));
+    let err = redis::cmd("CLIENT")
+        .arg(&["KILL", "ID", "1", "TYPE"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+
+    // A client can kill itself
+    let killed: i64 = client(con, &["KILL", "ID", &id.to_string(), "SKIPME", "no"]);
+    assert_eq!(killed, 1);
+    assert!(redis::cmd("PING").query::<String>(con).is_err());
+}
+
+#[test]
+fn test_client_pause() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let port = test_server.port.clone();
+    let con = &mut test_server.connection;
+
+    // During a WRITE pause, writes wait for the pause to end while reads don't
+    let _: () = client(con, &["PAUSE", "500", "WRITE"]);
+    let start = Instant::now();
+    let mut other_con = utils::get_connection(&port);
+    let value: Option<String> = other_con.get("key").unwrap();
+    assert_eq!(value, None);
+    assert!(start.elapsed() < Duration::from_millis(300));
+    let _: () = other_con.set("key", "value").unwrap();
+    assert!(start.elapsed() >= Duration::from_millis(450));
+    let value: String = con.get("key").unwrap();
+    assert_eq!(value, "value");
+
+    // UNPAUSE lets the delayed writes run right away
+    let _: () = client(con, &["PAUSE", "10000", "WRITE"]);
+    let start = Instant::now();
+    let writer = thread::spawn(move || {
+        let _: () = other_con.set("key", "other").unwrap();
+        start.elapsed()
+    });
+    thread::sleep(Duration::from_millis(300));
+    let value: String = con.get("key").unwrap();
+    assert_eq!(value, "value");
+    let _: () = client(con, &["UNPAUSE"]);
+    let elapsed = writer.join().unwrap();
+    assert!((Duration::from_millis(300)..Duration::from_secs(2)).contains(&elapsed));
+    let value: String = con.get("key").unwrap();
+    assert_eq!(value, "other");
+
+    // Without a mode every command waits, but the pause can still be lifted
+    let _: () = client(con, &["PAUSE", "400"]);
+    let start = Instant::now();
+    let _: String = redis::cmd("PING").query(con).unwrap();
+    assert!(start.elapsed() >= Duration::from_millis(350));
+
+    let err = redis::cmd("CLIENT")
+        .arg(&["PAUSE", "soon"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("timeout is not an integer or out of range")
+    );
+    let err = redis::cmd("CLIENT")
+        .arg(&["PAUSE", "-1"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("timeout is negative"));
+    let err = redis::cmd("CLIENT")
+        .arg(&["PAUSE", "100", "READ"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
+
+#[test]
+fn test_client_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let err = redis::cmd("CLIENT")
+        .arg("NOSUCHSUBCOMMAND")
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("unknown subcommand 'NOSUCHSUBCOMMAND'. Try CLIENT HELP.")
+    );
+    let err = redis::cmd("CLIENT")
+        .arg("SETNAME")
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'client|setname' command")
+    );
+    // The client libraries may report themselves
+    let _: () = client(con, &["SETINFO", "LIB-NAME", "redis-rs"]);
+}
@@ -0,0 +1,258 @@
+use redis::Value;
+
+mod utils;
+
+fn bulk(s: &str) -> Value {
+    Value::BulkString(s.as_bytes().to_vec())
+}
+
+#[test]
+fn test_command_info() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let info: Vec<Value> = redis::cmd("COMMAND")
+        .arg(&["INFO", "get", "BLPOP", "nosuchcommand"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        info,
+        [
+            Value::Array(vec![
+                bulk("get"),
+                Value::Int(2),
+                Value::Array(vec![
+                    Value::SimpleString("readonly".to_owned()),
+                    Value::SimpleString("fast".to_owned()),
+                ]),
+                Value::Int(1),
+                Value::Int(1),
+                Value::Int(1),
+                Value::Array(Vec::new()),
+                Value::Array(Vec::new()),
+                Value::Array(Vec::new()),
+                Value::Array(Vec::new()),
+            ]),
+            Value::Array(vec![
+                bulk("blpop"),
+                Value::Int(-3),
+                Value::Array(vec![
+                    Value::SimpleString("write".to_owned()),
+                    Value::SimpleString("blocking".to_owned()),
+                ]),
+                Value::Int(1),
+                Value::Int(-2),
+                Value::Int(1),
+                Value::Array(Vec::new()),
+                Value::Array(Vec::new()),
+                Value::Array(Vec::new()),
+                Value::Array(Vec::new()),
+            ]),
+            Value::Nil,
+        ]
+    );
+
+    // Every command is described, the same way as by COMMAND INFO
+    let count: usize = redis::cmd("COMMAND").arg("COUNT").query(con).unwrap();
+    let all: Vec<Value> = redis::cmd("COMMAND").query(con).unwrap();
+    assert_eq!(all.len(), count);
+    let all_info: Vec<Value> = redis::cmd("COMMAND").arg("INFO").query(con).unwrap();
+    assert_eq!(all_info, all);
+    assert!(all.contains(&info[0]));
+    assert!(all
+        .iter()
+        .any(|command| matches!(*command, Value::Array(ref info) if info[0] == bulk("command"))));
+}
+
+#[test]
+fn test_command_flags() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let flags = |con: &mut redis::Connection, name: &str| -> Vec<String> {
+        let info: Vec<Value> = redis::cmd("COMMAND")
+            .arg(&["INFO", name])
+            .query(con)
+            .unwrap();
+        let Value::Array(ref info) = info[0] else {
+            panic!("no command {name}: {info:?}");
+        };
+        redis::from_redis_value(&info[2]).unwrap()
+    };
+    assert_eq!(flags(con, "get"), ["readonly", "fast"]);
+    assert_eq!(flags(con, "set"), ["write", "denyoom"]);
+    assert_eq!(
+        flags(con, "publish"),
+        ["pubsub", "loading", "stale", "fast"]
+    );
+    assert_eq!(flags(con, "info"), ["loading", "stale"]);
+    assert_eq!(flags(con, "failover"), ["admin", "stale"]);
+}
+
+#[test]
+fn test_command_docs() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let docs: Value = redis::cmd("COMMAND")
+        .arg(&["DOCS", "zadd", "nosuchcommand", "LPOP"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        docs,
+        Value::Array(vec![
+            bulk("zadd"),
+            Value::Array(vec![bulk("group"), bulk("sorted-set")]),
+            bulk("lpop"),
+            Value::Array(vec![bulk("group"), bulk("list")]),
+        ])
+    );
+    let count: usize = redis::cmd("COMMAND").arg("COUNT").query(con).unwrap();
+    let Value::Array(all_docs) = redis::cmd("COMMAND").arg("DOCS").query(con).unwrap() else {
+        panic!("DOCS isn't an array");
+    };
+    assert_eq!(all_docs.len(), 2 * count);
+}
+
+#[test]
+fn test_command_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let err = redis::cmd("COMMAND")
+        .arg("NOSUCHSUBCOMMAND")
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("unknown subcommand 'NOSUCHSUBCOMMAND'. Try COMMAND HELP.")
+    );
+    let err = redis::cmd("COMMAND")
+        .arg(&["COUNT", "get"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'command|count' command")
+    );
+}
+
+#[test]
+fn test_command_getkeys() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let keys: Vec<String> = redis::cmd("COMMAND")
+        .arg(&["GETKEYS", "SET", "foo", "bar", "EX", "10"])
+        .query(con)
+        .unwrap();
+    assert_eq!(keys, ["foo"]);
+    let keys: Vec<String> = redis::cmd("COMMAND")
+        .arg(&["GETKEYS", "get", "foo"])
+        .query(con)
+        .unwrap();
+    assert_eq!(keys, ["foo"]);
+    // Every other argument of MSET is a key
+    let keys: Vec<String> = redis::cmd("COMMAND")
+        .arg(&["GETKEYS", "MSET", "a", "1", "b", "2", "c", "3"])
+        .query(con)
+        .unwrap();
+    assert_eq!(keys, ["a", "b", "c"]);
+    let keys: Vec<String> = redis::cmd("COMMAND")
+        .arg(&["GETKEYS", "ZADD", "zset", "1", "one", "2", "two"])
+        .query(con)
+        .unwrap();
+    assert_eq!(keys, ["zset"]);
+    // The keys given by their number, or after STREAMS
+    let movable: [(&[&str], &[&str]); 7] = [
+        (&["LMPOP", "2", "a", "b", "LEFT", "COUNT", "2"], &["a", "b"]),
+        (&["BLMPOP", "0", "1", "a", "RIGHT"], &["a"]),
+        (&["ZMPOP", "2", "a", "b", "MIN"], &["a", "b"]),
+        (
+            &["SINTERCARD", "3", "a", "b", "c", "LIMIT", "1"],
+            &["a", "b", "c"],
+        ),
+        (&["ZINTERCARD", "1", "a"], &["a"]),
+        (
+            &["XREAD", "COUNT", "1", "STREAMS", "a", "b", "0", "0"],
+            &["a", "b"],
+        ),
+        (
+            &["XREADGROUP", "GROUP", "g", "streams", "STREAMS", "a", ">"],
+            &["a"],
+        ),
+    ];
+    for (args, expected) in movable {
+        let keys: Vec<String> = redis::cmd("COMMAND")
+            .arg("GETKEYS")
+            .arg(args)
+            .query(con)
+            .unwrap();
+        assert_eq!(keys, expected, "{args:?}");
+    }
+
+    let err = redis::cmd("COMMAND")
+        .arg(&["GETKEYS", "LMPOP", "x", "a", "LEFT"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Invalid arguments specified for command")
+    );
+    let err = redis::cmd("COMMAND")
+        .arg(&["GETKEYS", "PING"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Invalid arguments specified for command")
+    );
+    let err = redis::cmd("COMMAND")
+        .arg(&["GETKEYS", "GET"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Invalid number of arguments specified for command")
+    );
+    let err = redis::cmd("COMMAND")
+        .arg(&["GETKEYS", "NOSUCHCOMMAND", "foo"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("Invalid command specified"));
+    let err = redis::cmd("COMMAND")
+        .arg("GETKEYS")
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'command|getkeys' command")
+    );
+}
+
+#[test]
+fn test_container_help() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for (command, subcommand) in [
+        ("CLIENT", "KILL <ip:port>"),
+        ("CONFIG", "GET <pattern>"),
+        ("COMMAND", "GETKEYS <full-command>"),
+        ("ACL", "WHOAMI"),
+        ("SLOWLOG", "RESET"),
+        ("XGROUP", "DESTROY <key> <groupname>"),
+        ("DEBUG", "SLEEP <seconds>"),
+    ] {
+        let lines: Vec<String> = redis::cmd(command).arg("help").query(con).unwrap();
+        assert_eq!(
+            lines[0],
+            format!("{command} <subcommand> [<arg> [value] [opt] ...]. Subcommands are:")
+        );
+        assert!(lines.iter().any(|line| line == subcommand), "{command}");
+        assert_eq!(
+            lines.last().map(String::as_str),
+            Some("    Print this help.")
+        );
+    }
+}
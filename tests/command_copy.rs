@@ -0,0 +1,151 @@
+use redis::Commands;
+use std::{
+    collections::{HashMap, HashSet},
+    thread,
+    time::Duration,
+};
+
+mod utils;
+
+// Send COPY with the given arguments
+fn copy(con: &mut redis::Connection, args: &[&str]) -> redis::RedisResult<i64> {
+    redis::cmd("COPY").arg(args).query(con)
+}
+
+#[test]
+fn test_copy() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = redis::cmd("SET")
+        .arg(&["source", "value", "EX", "100"])
+        .query(con)
+        .unwrap();
+
+    assert_eq!(copy(con, &["source", "destination"]).unwrap(), 1);
+    let val: String = con.get("destination").unwrap();
+    assert_eq!(val, "value");
+    let ttl: i64 = con.ttl("destination").unwrap();
+    assert!((99..=100).contains(&ttl), "{ttl}");
+    let val: String = con.get("source").unwrap();
+    assert_eq!(val, "value");
+
+    assert_eq!(copy(con, &["missing", "destination"]).unwrap(), 0);
+    let val: String = con.get("destination").unwrap();
+    assert_eq!(val, "value");
+}
+
+#[test]
+fn test_copy_replace() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("source", "new").unwrap();
+    let _: usize = con.rpush("destination", "old").unwrap();
+    let _: i64 = con.expire("destination", 100).unwrap();
+
+    // An existing destination is only overwritten with REPLACE
+    assert_eq!(copy(con, &["source", "destination"]).unwrap(), 0);
+    let list: Vec<String> = con.lrange("destination", 0, -1).unwrap();
+    assert_eq!(list, ["old"]);
+    assert_eq!(copy(con, &["source", "destination", "REPLACE"]).unwrap(), 1);
+    let val: String = con.get("destination").unwrap();
+    assert_eq!(val, "new");
+    // The TTL of the source replaces the one of the destination
+    let ttl: i64 = con.ttl("destination").unwrap();
+    assert_eq!(ttl, -1);
+
+    assert_eq!(
+        copy(con, &["source", "other", "DB", "0", "REPLACE"]).unwrap(),
+        1
+    );
+    let err = copy(con, &["source", "other", "DB", "16"]).unwrap_err();
+    assert_eq!(err.detail(), Some("DB index is out of range"));
+    let err = copy(con, &["source", "source"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("source and destination objects are the same")
+    );
+    let err = copy(con, &["source", "other", "FORCE"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
+
+#[test]
+fn test_copy_to_other_db() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("source", "value").unwrap();
+
+    // A key may be copied onto itself in another database
+    assert_eq!(copy(con, &["source", "source", "DB", "3"]).unwrap(), 1);
+    assert_eq!(copy(con, &["source", "source", "DB", "3"]).unwrap(), 0);
+    let _: () = redis::cmd("SELECT").arg(3).query(con).unwrap();
+    let val: String = con.get("source").unwrap();
+    assert_eq!(val, "value");
+    let _: () = redis::cmd("SELECT").arg(0).query(con).unwrap();
+    let val: String = con.get("source").unwrap();
+    assert_eq!(val, "value");
+}
+
+#[test]
+fn test_copy_is_independent() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: usize = con.rpush("list", &["a", "b"]).unwrap();
+    let _: usize = con.hset("hash", "field", "value").unwrap();
+    let _: usize = con.sadd("set", &["a", "b"]).unwrap();
+    let _: usize = con.zadd("zset", "a", 1).unwrap();
+    for key in ["list", "hash", "set", "zset"] {
+        assert_eq!(copy(con, &[key, &format!("{key}-copy")]).unwrap(), 1);
+    }
+
+    // Modifying the copies leaves the originals as they were
+    let _: usize = con.rpush("list-copy", "c").unwrap();
+    let _: String = con.lpop("list-copy", None).unwrap();
+    let _: usize = con.hset("hash-copy", "field", "changed").unwrap();
+    let _: usize = con.srem("set-copy", "a").unwrap();
+    let _: usize = con.zadd("zset-copy", "a", 5).unwrap();
+
+    let list: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(list, ["a", "b"]);
+    let list: Vec<String> = con.lrange("list-copy", 0, -1).unwrap();
+    assert_eq!(list, ["b", "c"]);
+    let hash: HashMap<String, String> = con.hgetall("hash").unwrap();
+    assert_eq!(hash["field"], "value");
+    let hash: HashMap<String, String> = con.hgetall("hash-copy").unwrap();
+    assert_eq!(hash["field"], "changed");
+    let set: HashSet<String> = con.smembers("set").unwrap();
+    assert_eq!(set, HashSet::from(["a".to_string(), "b".to_string()]));
+    let set: HashSet<String> = con.smembers("set-copy").unwrap();
+    assert_eq!(set, HashSet::from(["b".to_string()]));
+    let score: f64 = con.zscore("zset", "a").unwrap();
+    assert!((score - 1.0).abs() < f64::EPSILON);
+    let score: f64 = con.zscore("zset-copy", "a").unwrap();
+    assert!((score - 5.0).abs() < f64::EPSILON);
+}
+
+#[test]
+fn test_copy_serves_blocked_clients() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let mut blocked_con = utils::get_connection(&test_server.port);
+    let blocked = thread::spawn(move || {
+        let popped: Option<(String, String)> = redis::cmd("BLPOP")
+            .arg(&["target", "0"])
+            .query(&mut blocked_con)
+            .unwrap();
+        popped
+    });
+    thread::sleep(Duration::from_millis(100));
+
+    let _: usize = con.rpush("source", &["a", "b"]).unwrap();
+    assert_eq!(copy(con, &["source", "target"]).unwrap(), 1);
+    assert_eq!(
+        blocked.join().unwrap(),
+        Some(("target".to_string(), "a".to_string()))
+    );
+    // Only the copy was popped from
+    let target: Vec<String> = con.lrange("target", 0, -1).unwrap();
+    assert_eq!(target, ["b"]);
+    let source: Vec<String> = con.lrange("source", 0, -1).unwrap();
+    assert_eq!(source, ["a", "b"]);
+}
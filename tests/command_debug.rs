@@ -0,0 +1,134 @@
+use redis::{Commands, Value};
+use std::{
+    thread,
+    time::{Duration, Instant},
+};
+
+mod utils;
+
+fn debug<T: redis::FromRedisValue>(con: &mut redis::Connection, args: &[&str]) -> T {
+    redis::cmd("DEBUG").arg(args).query(con).unwrap()
+}
+
+// Keyspace section of INFO, which also counts the expired keys which haven't been removed yet
+fn info_keyspace(con: &mut redis::Connection) -> String {
+    redis::cmd("INFO").arg("keyspace").query(con).unwrap()
+}
+
+#[test]
+fn test_debug_sleep() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let port = test_server.port.clone();
+    let con = &mut test_server.connection;
+
+    let sleeper = thread::spawn(move || {
+        let mut con = utils::get_connection(&port);
+        let start = Instant::now();
+        let _: () = debug(&mut con, &["SLEEP", "0.5"]);
+        start.elapsed()
+    });
+    thread::sleep(Duration::from_millis(100));
+    // The other clients are still served meanwhile
+    let start = Instant::now();
+    let _: () = redis::cmd("PING").query(con).unwrap();
+    assert!(start.elapsed() < Duration::from_millis(300));
+    assert!(sleeper.join().unwrap() >= Duration::from_millis(500));
+
+    let start = Instant::now();
+    let _: () = debug(con, &["sleep", "0"]);
+    assert!(start.elapsed() < Duration::from_millis(300));
+
+    // Inside a transaction, the whole transaction takes that long
+    let start = Instant::now();
+    let (slept, got): (String, Option<String>) = redis::pipe()
+        .atomic()
+        .cmd("DEBUG")
+        .arg(&["SLEEP", "0.2"])
+        .cmd("GET")
+        .arg("foo")
+        .query(con)
+        .unwrap();
+    assert_eq!((slept.as_str(), got), ("OK", None));
+    assert!(start.elapsed() >= Duration::from_millis(200));
+
+    let err = redis::cmd("DEBUG")
+        .arg(&["SLEEP", "soon"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("value is not a valid float"));
+}
+
+#[test]
+fn test_debug_object() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("foo", "bar").unwrap();
+    let description: String = debug(con, &["OBJECT", "foo"]);
+    assert_eq!(
+        description,
+        "Value at:0x0 refcount:1 encoding:embstr serializedlength:4 lru:0 lru_seconds_idle:0"
+    );
+    let _: () = con.set("counter", 12345).unwrap();
+    let description: String = debug(con, &["OBJECT", "counter"]);
+    assert!(description.contains(" encoding:int serializedlength:6 "));
+    let _: usize = con.rpush("list", &["a", "bc"]).unwrap();
+    let description: String = debug(con, &["OBJECT", "list"]);
+    assert!(description.contains(" encoding:listpack serializedlength:6 "));
+
+    let err = redis::cmd("DEBUG")
+        .arg(&["OBJECT", "nosuchkey"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("no such key"));
+}
+
+#[test]
+fn test_debug_set_active_expire() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = debug(con, &["SET-ACTIVE-EXPIRE", "0"]);
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "bar", "PX", "100"])
+        .query(con)
+        .unwrap();
+    thread::sleep(Duration::from_millis(500));
+    // The expired key is only removed once accessed, though DBSIZE leaves it out anyway
+    assert_eq!(utils::dbsize(con), 0);
+    assert!(info_keyspace(con).contains("db0:keys=1,"));
+    let got: Option<String> = con.get("foo").unwrap();
+    assert_eq!(got, None);
+    assert!(!info_keyspace(con).contains("db0:"));
+
+    let _: () = debug(con, &["SET-ACTIVE-EXPIRE", "1"]);
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "bar", "PX", "100"])
+        .query(con)
+        .unwrap();
+    thread::sleep(Duration::from_millis(500));
+    assert!(!info_keyspace(con).contains("db0:"));
+}
+
+#[test]
+fn test_debug_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let err = redis::cmd("DEBUG")
+        .arg("NOSUCHSUBCOMMAND")
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("unknown subcommand 'NOSUCHSUBCOMMAND'. Try DEBUG HELP.")
+    );
+    let err = redis::cmd("DEBUG")
+        .arg("SLEEP")
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'debug|sleep' command")
+    );
+}
@@ -0,0 +1,128 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+#[test]
+fn test_del() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("string", "value").unwrap();
+    let _: usize = con.rpush("list", &["a", "b"]).unwrap();
+    let _: usize = con.hset("hash", "field", "value").unwrap();
+
+    // Missing keys and keys given again don't count
+    let removed: usize = con.del(&["string", "missing", "list", "string"]).unwrap();
+    assert_eq!(removed, 2);
+    let removed: usize = con.del("missing").unwrap();
+    assert_eq!(removed, 0);
+    let val: Option<String> = con.get("string").unwrap();
+    assert_eq!(val, None);
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 1);
+
+    let err = redis::cmd("DEL").query::<usize>(con).unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+}
+
+#[test]
+fn test_del_clears_ttl() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = redis::cmd("SET")
+        .arg(&["key", "value", "EX", "100"])
+        .query(con)
+        .unwrap();
+    let removed: usize = con.del("key").unwrap();
+    assert_eq!(removed, 1);
+    // A new key with the same name doesn't inherit the TTL
+    let _: usize = con.rpush("key", "a").unwrap();
+    let ttl: i64 = con.ttl("key").unwrap();
+    assert_eq!(ttl, -1);
+
+    // An expired key doesn't count as removed
+    let _: () = redis::cmd("SET")
+        .arg(&["short", "lived", "PX", "50"])
+        .query(con)
+        .unwrap();
+    thread::sleep(Duration::from_millis(100));
+    let removed: usize = con.del("short").unwrap();
+    assert_eq!(removed, 0);
+}
+
+#[test]
+fn test_unlink() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("string", "value").unwrap();
+    // Large enough to be freed in the background
+    let members: Vec<String> = (0..1000).map(|i| i.to_string()).collect();
+    let _: usize = con.sadd("set", &members).unwrap();
+
+    let removed: usize = con.unlink(&["string", "set", "missing"]).unwrap();
+    assert_eq!(removed, 2);
+    let key_type: String = redis::cmd("TYPE").arg("set").query(con).unwrap();
+    assert_eq!(key_type, "none");
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 0);
+    let added: usize = con.sadd("set", "1").unwrap();
+    assert_eq!(added, 1);
+}
+
+#[test]
+fn test_exists() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("string", "value").unwrap();
+    let _: usize = con.rpush("list", "a").unwrap();
+
+    let count: usize = con.exists("string").unwrap();
+    assert_eq!(count, 1);
+    let count: usize = con.exists("missing").unwrap();
+    assert_eq!(count, 0);
+    // Keys given several times are counted as many times
+    let count: usize = con
+        .exists(&["string", "list", "missing", "string"])
+        .unwrap();
+    assert_eq!(count, 3);
+}
+
+#[test]
+fn test_touch() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("string", "value").unwrap();
+    let _: usize = con.rpush("list", "a").unwrap();
+    let _: () = redis::cmd("SET")
+        .arg(&["short", "lived", "PX", "50"])
+        .query(con)
+        .unwrap();
+
+    thread::sleep(Duration::from_millis(1100));
+    let idletime: i64 = redis::cmd("OBJECT")
+        .arg(&["IDLETIME", "string"])
+        .query(con)
+        .unwrap();
+    assert_eq!(idletime, 1);
+
+    // Missing and expired keys don't count, while a key given several times is counted as many times
+    let count: usize = redis::cmd("TOUCH")
+        .arg(&["string", "list", "missing", "short", "string"])
+        .query(con)
+        .unwrap();
+    assert_eq!(count, 3);
+    for key in ["string", "list"] {
+        let idletime: i64 = redis::cmd("OBJECT")
+            .arg(&["IDLETIME", key])
+            .query(con)
+            .unwrap();
+        assert_eq!(idletime, 0, "{key}");
+    }
+
+    let err = redis::cmd("TOUCH").query::<usize>(con).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'touch' command")
+    );
+}
@@ -0,0 +1,135 @@
+use redis::{Commands, Value};
+use std::{
+    collections::HashMap,
+    time::{SystemTime, UNIX_EPOCH},
+};
+
+mod utils;
+
+fn dump(con: &mut redis::Connection, key: &str) -> Vec<u8> {
+    redis::cmd("DUMP").arg(key).query(con).unwrap()
+}
+
+fn restore(
+    con: &mut redis::Connection,
+    key: &str,
+    ttl: i64,
+    payload: &[u8],
+    options: &[&str],
+) -> redis::RedisResult<()> {
+    redis::cmd("RESTORE")
+        .arg(key)
+        .arg(ttl)
+        .arg(payload)
+        .arg(options)
+        .query(con)
+}
+
+#[test]
+fn test_dump_restore_round_trip() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = redis::cmd("HSET")
+        .arg(&["hash", "a", "1", "b", "2", "c", "3"])
+        .query(con)
+        .unwrap();
+    let hash: HashMap<String, String> = con.hgetall("hash").unwrap();
+    let payload = dump(con, "hash");
+    let _: usize = con.del("hash").unwrap();
+    restore(con, "hash", 0, &payload, &[]).unwrap();
+    let restored: HashMap<String, String> = con.hgetall("hash").unwrap();
+    assert_eq!(restored, hash);
+    assert_eq!(con.ttl::<_, i64>("hash").unwrap(), -1);
+
+    // Every type survives the round trip
+    let _: usize = con.rpush("list", &["a", "b", "c"]).unwrap();
+    let _: usize = con
+        .zadd_multiple("zset", &[(1.5, "one"), (2.0, "two")])
+        .unwrap();
+    let _: () = con.set("string", "value").unwrap();
+    for key in ["list", "zset", "string"] {
+        let payload = dump(con, key);
+        restore(con, &format!("{key}:copy"), 0, &payload, &[]).unwrap();
+        assert_eq!(dump(con, &format!("{key}:copy")), payload);
+    }
+    let list: Vec<String> = con.lrange("list:copy", 0, -1).unwrap();
+    assert_eq!(list, ["a", "b", "c"]);
+    let zset: Vec<(String, f64)> = con.zrange_withscores("zset:copy", 0, -1).unwrap();
+    assert_eq!(zset, [("one".to_owned(), 1.5), ("two".to_owned(), 2.0)]);
+
+    let reply: Value = redis::cmd("DUMP").arg("missing").query(con).unwrap();
+    assert_eq!(reply, Value::Nil);
+}
+
+#[test]
+fn test_restore_redis_payload() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // What Redis replies to DUMP of the value 10, as an RDB version 9 payload
+    restore(con, "key", 0, b"\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n", &[]).unwrap();
+    let val: String = con.get("key").unwrap();
+    assert_eq!(val, "10");
+}
+
+#[test]
+fn test_restore_ttl() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("key", "value").unwrap();
+    let payload = dump(con, "key");
+    restore(con, "ttl", 10000, &payload, &[]).unwrap();
+    let pttl: i64 = con.pttl("ttl").unwrap();
+    assert!(pttl > 0 && pttl <= 10000, "{pttl}");
+
+    let timestamp = 4_102_444_800_000_i64; // 2100-01-01
+    restore(con, "absttl", timestamp, &payload, &["ABSTTL"]).unwrap();
+    let now_ms = i64::try_from(
+        SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .unwrap()
+            .as_millis(),
+    )
+    .unwrap();
+    let pttl: i64 = con.pttl("absttl").unwrap();
+    assert!((timestamp - now_ms - pttl).abs() < 1000, "{pttl}");
+
+    // A TTL in the past removes the key rather than creating it
+    restore(con, "key", 1, &payload, &["REPLACE", "ABSTTL"]).unwrap();
+    assert!(!con.exists::<_, bool>("key").unwrap());
+
+    let err = restore(con, "key", -1, &payload, &[]).unwrap_err();
+    assert_eq!(err.detail(), Some("Invalid TTL value, must be >= 0"));
+}
+
+#[test]
+fn test_restore_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("key", "value").unwrap();
+    let payload = dump(con, "key");
+
+    // An existing key is only overwritten with REPLACE
+    let err = restore(con, "key", 0, &payload, &[]).unwrap_err();
+    assert_eq!(err.code(), Some("BUSYKEY"));
+    assert_eq!(err.detail(), Some("Target key name already exists."));
+    let _: () = con.set("key", "other").unwrap();
+    restore(con, "key", 0, &payload, &["REPLACE"]).unwrap();
+    let val: String = con.get("key").unwrap();
+    assert_eq!(val, "value");
+
+    // The checksum catches any corruption
+    let mut corrupted = payload.clone();
+    corrupted[2] ^= 1;
+    let err = restore(con, "new", 0, &corrupted, &[]).unwrap_err();
+    assert_eq!(err.detail(), Some("Bad data format"));
+    let err = restore(con, "new", 0, &payload[..payload.len() - 1], &[]).unwrap_err();
+    assert_eq!(err.detail(), Some("Bad data format"));
+    assert!(!con.exists::<_, bool>("new").unwrap());
+
+    let err = restore(con, "new", 0, &payload, &["FOO"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
@@ -0,0 +1,233 @@
+use redis::Commands;
+use std::{
+    thread,
+    time::{Duration, SystemTime, UNIX_EPOCH},
+};
+
+mod utils;
+
+fn expire(con: &mut redis::Connection, command: &str, args: &[&str]) -> redis::RedisResult<i64> {
+    redis::cmd(command).arg(args).query(con)
+}
+
+#[test]
+fn test_expire_variants() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Missing keys are not given an expiry
+    let expire_result: i64 = con.expire("foo", 10).unwrap();
+    assert_eq!(expire_result, 0);
+
+    let _: () = con.set("foo", "bar").unwrap();
+    let expire_result: i64 = con.expire("foo", 10).unwrap();
+    assert_eq!(expire_result, 1);
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert_eq!(ttl, 10);
+
+    let pexpire_result: i64 = con.pexpire("foo", 5000).unwrap();
+    assert_eq!(pexpire_result, 1);
+    let pttl: i64 = con.pttl("foo").unwrap();
+    assert!((4900..=5000).contains(&pttl), "{pttl}");
+
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap()
+        .as_secs();
+    let expireat_result: i64 = con
+        .expire_at("foo", i64::try_from(now).unwrap() + 100)
+        .unwrap();
+    assert_eq!(expireat_result, 1);
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert!((99..=100).contains(&ttl), "{ttl}");
+
+    let pexpireat = (now * 1000 + 20_000).to_string();
+    let pexpireat_result = expire(con, "PEXPIREAT", &["foo", &pexpireat]).unwrap();
+    assert_eq!(pexpireat_result, 1);
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert!((19..=20).contains(&ttl), "{ttl}");
+
+    // PERSIST removes the TTL only once
+    let persist_result: i64 = con.persist("foo").unwrap();
+    assert_eq!(persist_result, 1);
+    let persist_result: i64 = con.persist("foo").unwrap();
+    assert_eq!(persist_result, 0);
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert_eq!(ttl, -1);
+    let persist_result: i64 = con.persist("missing").unwrap();
+    assert_eq!(persist_result, 0);
+}
+
+#[test]
+fn test_expiretime() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let expire_time = expire(con, "EXPIRETIME", &["foo"]).unwrap();
+    assert_eq!(expire_time, -2);
+    let expire_time = expire(con, "PEXPIRETIME", &["foo"]).unwrap();
+    assert_eq!(expire_time, -2);
+    let _: () = con.set("foo", "bar").unwrap();
+    let expire_time = expire(con, "EXPIRETIME", &["foo"]).unwrap();
+    assert_eq!(expire_time, -1);
+    let expire_time = expire(con, "PEXPIRETIME", &["foo"]).unwrap();
+    assert_eq!(expire_time, -1);
+
+    // The absolute time set comes back as is
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap()
+        .as_secs();
+    let expire_at = i64::try_from(now).unwrap() + 100;
+    let _: i64 = con.expire_at("foo", expire_at).unwrap();
+    let expire_time = expire(con, "EXPIRETIME", &["foo"]).unwrap();
+    assert_eq!(expire_time, expire_at);
+    let expire_time = expire(con, "PEXPIRETIME", &["foo"]).unwrap();
+    assert_eq!(expire_time, expire_at * 1000);
+
+    let pexpire_at = expire_at * 1000 + 1234;
+    let _ = expire(con, "PEXPIREAT", &["foo", &pexpire_at.to_string()]).unwrap();
+    let expire_time = expire(con, "PEXPIRETIME", &["foo"]).unwrap();
+    assert_eq!(expire_time, pexpire_at);
+    let expire_time = expire(con, "EXPIRETIME", &["foo"]).unwrap();
+    assert_eq!(expire_time, expire_at + 1);
+
+    // A relative TTL is turned into the absolute time
+    let _: i64 = con.expire("foo", 50).unwrap();
+    let expire_time = expire(con, "EXPIRETIME", &["foo"]).unwrap();
+    let now = i64::try_from(now).unwrap();
+    assert!(
+        (now + 49..=now + 51).contains(&expire_time),
+        "{expire_time}"
+    );
+
+    let _: i64 = con.persist("foo").unwrap();
+    let expire_time = expire(con, "EXPIRETIME", &["foo"]).unwrap();
+    assert_eq!(expire_time, -1);
+}
+
+#[test]
+fn test_expire_key_expires() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("foo", "bar").unwrap();
+    let _: i64 = con.pexpire("foo", 100).unwrap();
+    thread::sleep(Duration::from_millis(200));
+    let get_result: Option<String> = con.get("foo").unwrap();
+    assert_eq!(get_result, None);
+
+    // A time in the past deletes the key right away
+    let _: () = con.set("foo", "bar").unwrap();
+    let expire_result: i64 = con.expire("foo", -1).unwrap();
+    assert_eq!(expire_result, 1);
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 0);
+    let _: () = con.set("foo", "bar").unwrap();
+    let expireat_result: i64 = con.expire_at("foo", 1).unwrap();
+    assert_eq!(expireat_result, 1);
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 0);
+
+    // Any type of key can expire
+    let _: usize = con.rpush("list", "a").unwrap();
+    let expire_result: i64 = con.pexpire("list", 100).unwrap();
+    assert_eq!(expire_result, 1);
+    thread::sleep(Duration::from_millis(200));
+    let list_size: usize = con.llen("list").unwrap();
+    assert_eq!(list_size, 0);
+}
+
+#[test]
+fn test_expire_options() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("foo", "bar").unwrap();
+
+    // A key without a TTL is treated as having an infinite TTL
+    let cases: &[(&[&str], i64, i64)] = &[
+        (&["foo", "100", "XX"], 0, -1),
+        (&["foo", "100", "GT"], 0, -1),
+        (&["foo", "100", "LT"], 1, 100),
+        (&["foo", "200", "NX"], 0, 100),
+        (&["foo", "50", "GT"], 0, 100),
+        (&["foo", "200", "gt"], 1, 200),
+        (&["foo", "300", "LT"], 0, 200),
+        (&["foo", "150", "XX", "LT"], 1, 150),
+        (&["foo", "400", "XX"], 1, 400),
+    ];
+    for &(args, result, ttl) in cases {
+        assert_eq!(expire(con, "EXPIRE", args).unwrap(), result, "{args:?}");
+        assert_eq!(con.ttl::<_, i64>("foo").unwrap(), ttl, "{args:?}");
+    }
+
+    let _: i64 = con.persist("foo").unwrap();
+    let expire_result = expire(con, "EXPIRE", &["foo", "100", "NX"]).unwrap();
+    assert_eq!(expire_result, 1);
+}
+
+#[test]
+fn test_expire_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("foo", "bar").unwrap();
+
+    let cases: &[(&str, &[&str], &str)] = &[
+        (
+            "EXPIRE",
+            &["foo", "abc"],
+            "value is not an integer or out of range",
+        ),
+        (
+            "EXPIRE",
+            &["foo", "1.5"],
+            "value is not an integer or out of range",
+        ),
+        (
+            "EXPIRE",
+            &["foo", "9223372036854775807"],
+            "invalid expire time in 'expire' command",
+        ),
+        (
+            "PEXPIRE",
+            &["foo", "9223372036854775807"],
+            "invalid expire time in 'pexpire' command",
+        ),
+        (
+            "EXPIREAT",
+            &["foo", "-9223372036854775807"],
+            "invalid expire time in 'expireat' command",
+        ),
+        (
+            "EXPIRE",
+            &["foo", "10", "NX", "XX"],
+            "NX and XX, GT or LT options at the same time are not compatible",
+        ),
+        (
+            "EXPIRE",
+            &["foo", "10", "GT", "LT"],
+            "GT and LT options at the same time are not compatible",
+        ),
+        ("EXPIRE", &["foo", "10", "abc"], "syntax error"),
+        (
+            "EXPIRE",
+            &["foo"],
+            "wrong number of arguments for 'expire' command",
+        ),
+        (
+            "PERSIST",
+            &[],
+            "wrong number of arguments for 'persist' command",
+        ),
+    ];
+    for &(command, args, message) in cases {
+        let err = expire(con, command, args).unwrap_err();
+        assert_eq!(err.code(), Some("ERR"), "{command} {args:?}");
+        assert_eq!(err.detail(), Some(message), "{command} {args:?}");
+    }
+    // The key is left untouched
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert_eq!(ttl, -1);
+}
@@ -0,0 +1,317 @@
+use redis::Commands;
+
+mod utils;
+
+type Position = Option<(f64, f64)>;
+
+// Add Palermo and Catania to the key, as in the examples of the Redis documentation
+fn add_sicily(con: &mut redis::Connection, key: &str) {
+    let added: usize = redis::cmd("GEOADD")
+        .arg(key)
+        .arg(&[
+            "13.361389",
+            "38.115556",
+            "Palermo",
+            "15.087269",
+            "37.502669",
+            "Catania",
+        ])
+        .query(con)
+        .unwrap();
+    assert_eq!(added, 2);
+}
+
+#[test]
+fn test_geoadd_geopos() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    add_sicily(con, "Sicily");
+
+    // The scores are the same geohashes as Redis computes
+    let score: f64 = con.zscore("Sicily", "Palermo").unwrap();
+    assert_eq!(score, 3_479_099_956_230_698.0);
+    let score: f64 = con.zscore("Sicily", "Catania").unwrap();
+    assert_eq!(score, 3_479_447_370_796_909.0);
+
+    // The positions come back within the precision of the geohash
+    let positions: Vec<Position> = redis::cmd("GEOPOS")
+        .arg(&["Sicily", "Palermo", "missing", "Catania"])
+        .query(con)
+        .unwrap();
+    assert_eq!(positions.len(), 3);
+    assert_eq!(positions[1], None);
+    for (position, expected) in [
+        (positions[0], (13.361389, 38.115556)),
+        (positions[2], (15.087269, 37.502669)),
+    ] {
+        let (longitude, latitude) = position.unwrap();
+        assert!((longitude - expected.0).abs() < 1e-5, "{longitude}");
+        assert!((latitude - expected.1).abs() < 1e-5, "{latitude}");
+    }
+    let positions: Vec<Position> = redis::cmd("GEOPOS")
+        .arg(&["missing", "Palermo"])
+        .query(con)
+        .unwrap();
+    assert_eq!(positions, [None]);
+
+    // Moving a member doesn't add it again, unless CH counts it
+    let added: usize = redis::cmd("GEOADD")
+        .arg(&["Sicily", "13.5", "38", "Palermo"])
+        .query(con)
+        .unwrap();
+    assert_eq!(added, 0);
+    let changed: usize = redis::cmd("GEOADD")
+        .arg(&["Sicily", "CH", "13.6", "38", "Palermo"])
+        .query(con)
+        .unwrap();
+    assert_eq!(changed, 1);
+    let added: usize = redis::cmd("GEOADD")
+        .arg(&["Sicily", "XX", "13", "37", "Messina"])
+        .query(con)
+        .unwrap();
+    assert_eq!(added, 0);
+    let len: usize = con.zcard("Sicily").unwrap();
+    assert_eq!(len, 2);
+
+    let err = redis::cmd("GEOADD")
+        .arg(&["Sicily", "200", "100", "Nowhere"])
+        .query::<usize>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("invalid longitude,latitude pair 200.000000,100.000000")
+    );
+    let err = redis::cmd("GEOADD")
+        .arg(&["Sicily", "0", "85.1", "Pole"])
+        .query::<usize>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("invalid longitude,latitude pair 0.000000,85.100000")
+    );
+    let err = redis::cmd("GEOADD")
+        .arg(&["Sicily", "east", "38", "Palermo"])
+        .query::<usize>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("value is not a valid float"));
+    let err = redis::cmd("GEOADD")
+        .arg(&["Sicily", "13", "38", "Palermo", "14"])
+        .query::<usize>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let _: () = con.set("string", "value").unwrap();
+    let err = redis::cmd("GEOADD")
+        .arg(&["string", "13", "38", "Palermo"])
+        .query::<usize>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_geodist() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    add_sicily(con, "Sicily");
+
+    let cases: &[(&[&str], Option<&str>)] = &[
+        (&["Palermo", "Catania"], Some("166274.1516")),
+        (&["Palermo", "Catania", "km"], Some("166.2742")),
+        (&["Palermo", "Catania", "MI"], Some("103.3182")),
+        (&["Palermo", "Catania", "ft"], Some("545518.8700")),
+        (&["Palermo", "Palermo"], Some("0.0000")),
+        (&["Palermo", "missing"], None),
+    ];
+    for &(args, expected) in cases {
+        let distance: Option<String> = redis::cmd("GEODIST")
+            .arg("Sicily")
+            .arg(args)
+            .query(con)
+            .unwrap();
+        assert_eq!(distance.as_deref(), expected, "{args:?}");
+    }
+    let distance: Option<String> = redis::cmd("GEODIST")
+        .arg(&["missing", "Palermo", "Catania"])
+        .query(con)
+        .unwrap();
+    assert_eq!(distance, None);
+
+    let err = redis::cmd("GEODIST")
+        .arg(&["Sicily", "Palermo", "Catania", "yd"])
+        .query::<Option<String>>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("unsupported unit provided. please use M, KM, FT, MI")
+    );
+}
+
+#[test]
+fn test_geosearch() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    add_sicily(con, "Sicily");
+    let _: usize = redis::cmd("GEOADD")
+        .arg(&[
+            "Sicily",
+            "12.758489",
+            "38.788135",
+            "edge1",
+            "17.241510",
+            "38.788135",
+            "edge2",
+        ])
+        .query(con)
+        .unwrap();
+
+    let members: Vec<String> = redis::cmd("GEOSEARCH")
+        .arg(&[
+            "Sicily",
+            "FROMLONLAT",
+            "15",
+            "37",
+            "BYRADIUS",
+            "200",
+            "km",
+            "ASC",
+        ])
+        .query(con)
+        .unwrap();
+    assert_eq!(members, ["Catania", "Palermo"]);
+    let members: Vec<(String, String)> = redis::cmd("GEOSEARCH")
+        .arg(&[
+            "Sicily",
+            "FROMLONLAT",
+            "15",
+            "37",
+            "BYRADIUS",
+            "200",
+            "km",
+            "DESC",
+            "WITHDIST",
+        ])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        members,
+        [
+            ("Palermo".to_string(), "190.4424".to_string()),
+            ("Catania".to_string(), "56.4413".to_string())
+        ]
+    );
+
+    // The box is wider than the circle of the same size
+    let members: Vec<String> = redis::cmd("GEOSEARCH")
+        .arg(&[
+            "Sicily",
+            "FROMLONLAT",
+            "15",
+            "37",
+            "BYBOX",
+            "400",
+            "400",
+            "km",
+            "ASC",
+        ])
+        .query(con)
+        .unwrap();
+    assert_eq!(members, ["Catania", "Palermo", "edge2", "edge1"]);
+    let members: Vec<String> = redis::cmd("GEOSEARCH")
+        .arg(&[
+            "Sicily",
+            "FROMLONLAT",
+            "15",
+            "37",
+            "BYRADIUS",
+            "200",
+            "km",
+            "COUNT",
+            "1",
+        ])
+        .query(con)
+        .unwrap();
+    assert_eq!(members, ["Catania"]);
+    let members: Vec<String> = redis::cmd("GEOSEARCH")
+        .arg(&["Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "100", "km"])
+        .query(con)
+        .unwrap();
+    assert_eq!(members, ["Palermo", "edge1"]);
+
+    let results: Vec<(String, String, i64, (f64, f64))> = redis::cmd("GEOSEARCH")
+        .arg(&["Sicily", "FROMMEMBER", "Catania", "BYRADIUS", "1", "m"])
+        .arg(&["WITHCOORD", "WITHHASH", "WITHDIST"])
+        .query(con)
+        .unwrap();
+    assert_eq!(results.len(), 1);
+    let (member, distance, hash, (longitude, latitude)) = results[0].clone();
+    assert_eq!(
+        (member.as_str(), distance.as_str(), hash),
+        ("Catania", "0.0000", 3_479_447_370_796_909)
+    );
+    assert!((longitude - 15.087269).abs() < 1e-5 && (latitude - 37.502669).abs() < 1e-5);
+
+    let members: Vec<String> = redis::cmd("GEOSEARCH")
+        .arg(&["missing", "FROMMEMBER", "Palermo", "BYRADIUS", "100", "km"])
+        .query(con)
+        .unwrap();
+    assert!(members.is_empty());
+
+    let cases: &[(&[&str], &str)] = &[
+        (
+            &["BYRADIUS", "1", "km", "WITHDIST", "ASC"],
+            "exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH",
+        ),
+        (
+            &[
+                "FROMMEMBER",
+                "Palermo",
+                "FROMLONLAT",
+                "15",
+                "37",
+                "BYRADIUS",
+                "1",
+                "km",
+            ],
+            "exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH",
+        ),
+        (
+            &["FROMMEMBER", "Palermo", "WITHDIST", "WITHHASH", "ASC"],
+            "exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH",
+        ),
+        (
+            &["FROMMEMBER", "missing", "BYRADIUS", "1", "km"],
+            "could not decode requested zset member",
+        ),
+        (
+            &["FROMLONLAT", "15", "90", "BYRADIUS", "1", "km"],
+            "invalid longitude,latitude pair 15.000000,90.000000",
+        ),
+        (
+            &["FROMMEMBER", "Palermo", "BYRADIUS", "-1", "km"],
+            "radius cannot be negative",
+        ),
+        (
+            &["FROMMEMBER", "Palermo", "BYBOX", "1", "-1", "km"],
+            "height or width cannot be negative",
+        ),
+        (
+            &["FROMMEMBER", "Palermo", "BYRADIUS", "1", "km", "COUNT", "0"],
+            "COUNT must be > 0",
+        ),
+        (
+            &["FROMMEMBER", "Palermo", "BYRADIUS", "1", "km", "ANY"],
+            "the ANY argument requires COUNT argument",
+        ),
+        (
+            &["FROMMEMBER", "Palermo", "BYRADIUS", "1", "km", "NEAR"],
+            "syntax error",
+        ),
+    ];
+    for &(args, detail) in cases {
+        let err = redis::cmd("GEOSEARCH")
+            .arg("Sicily")
+            .arg(args)
+            .query::<Vec<String>>(con)
+            .unwrap_err();
+        assert_eq!(err.detail(), Some(detail), "{args:?}");
+    }
+}
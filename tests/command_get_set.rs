@@ -1,7 +1,7 @@
 use redis::Commands;
 mod utils;
 use std::thread;
-use std::time::Duration;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
 
 #[test]
 fn test_set_get_ttl() {
@@ -28,3 +28,212 @@ fn test_set_get_ttl() {
 
     // 4. Clean up happens using the `Drop` trait of `ChildGuard`
 }
+
+#[test]
+fn test_set_options() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // NX only sets a missing key
+    let set_result: Option<String> = redis::cmd("SET")
+        .arg(&["nx_key", "foo", "NX"])
+        .query(con)
+        .unwrap();
+    assert_eq!(set_result, Some("OK".into()));
+    let set_result: Option<String> = redis::cmd("SET")
+        .arg(&["nx_key", "bar", "NX"])
+        .query(con)
+        .unwrap();
+    assert_eq!(set_result, None);
+    let val: Option<String> = con.get("nx_key").unwrap();
+    assert_eq!(val, Some("foo".into()));
+
+    // XX only sets an existing key
+    let set_result: Option<String> = redis::cmd("SET")
+        .arg(&["xx_key", "foo", "XX"])
+        .query(con)
+        .unwrap();
+    assert_eq!(set_result, None);
+    let val: Option<String> = con.get("xx_key").unwrap();
+    assert_eq!(val, None);
+    let set_result: Option<String> = redis::cmd("SET")
+        .arg(&["nx_key", "baz", "XX"])
+        .query(con)
+        .unwrap();
+    assert_eq!(set_result, Some("OK".into()));
+
+    // GET returns the old value
+    let old_value: Option<String> = redis::cmd("SET")
+        .arg(&["nx_key", "qux", "GET"])
+        .query(con)
+        .unwrap();
+    assert_eq!(old_value, Some("baz".into()));
+    let old_value: Option<String> = redis::cmd("SET")
+        .arg(&["get_key", "foo", "GET"])
+        .query(con)
+        .unwrap();
+    assert_eq!(old_value, None);
+
+    // GET on a non-string key is an error and leaves the key untouched
+    let _: usize = con.rpush("list", "foo").unwrap();
+    let set_result: Result<Option<String>, _> =
+        redis::cmd("SET").arg(&["list", "foo", "GET"]).query(con);
+    assert_eq!(set_result.unwrap_err().code(), Some("WRONGTYPE"));
+    let list_size: usize = con.llen("list").unwrap();
+    assert_eq!(list_size, 1);
+}
+
+#[test]
+fn test_set_expiry_options() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // KEEPTTL retains the TTL while a plain SET removes it
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "bar", "PX", "500"])
+        .query(con)
+        .unwrap();
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "baz", "KEEPTTL"])
+        .query(con)
+        .unwrap();
+    let _: () = redis::cmd("SET")
+        .arg(&["persistent", "bar", "EX", "1"])
+        .query(con)
+        .unwrap();
+    let _: () = con.set("persistent", "baz").unwrap();
+    thread::sleep(Duration::from_millis(1000));
+    let val: Option<String> = con.get("foo").unwrap();
+    assert_eq!(val, None);
+    let val: Option<String> = con.get("persistent").unwrap();
+    assert_eq!(val, Some("baz".into()));
+
+    // Absolute expiry in the past expires the key right away
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "bar", "PXAT", "1000"])
+        .query(con)
+        .unwrap();
+    let val: Option<String> = con.get("foo").unwrap();
+    assert_eq!(val, None);
+
+    // Absolute expiry in the future
+    let expires_at = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap()
+        .as_secs()
+        + 100;
+    let _: () = redis::cmd("SET")
+        .arg("foo")
+        .arg("bar")
+        .arg("EXAT")
+        .arg(expires_at)
+        .query(con)
+        .unwrap();
+    let val: Option<String> = con.get("foo").unwrap();
+    assert_eq!(val, Some("bar".into()));
+}
+
+#[test]
+fn test_set_invalid_options() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for args in [
+        ["foo", "bar", "NX", "XX"].as_slice(),
+        &["foo", "bar", "EX", "10", "PX", "100"],
+        &["foo", "bar", "EX", "10", "KEEPTTL"],
+        &["foo", "bar", "EX"],
+        &["foo", "bar", "FOO"],
+    ] {
+        let set_result: Result<(), _> = redis::cmd("SET").arg(args).query(con);
+        assert_eq!(set_result.unwrap_err().detail(), Some("syntax error"));
+    }
+
+    let set_result: Result<(), _> = redis::cmd("SET")
+        .arg(&["foo", "bar", "EX", "ten"])
+        .query(con);
+    assert_eq!(
+        set_result.unwrap_err().detail(),
+        Some("value is not an integer or out of range")
+    );
+    // Zero, and times whose expiry in milliseconds overflows an i64
+    for args in [
+        ["foo", "bar", "PX", "0"].as_slice(),
+        &["foo", "bar", "PX", "9223372036854775807"],
+        &["foo", "bar", "EX", "9223372036854775"],
+        &["foo", "bar", "EXAT", "9223372036854776"],
+    ] {
+        let set_result: Result<(), _> = redis::cmd("SET").arg(args).query(con);
+        assert_eq!(
+            set_result.unwrap_err().detail(),
+            Some("invalid expire time in 'set' command")
+        );
+    }
+
+    // The key was never written
+    let val: Option<String> = con.get("foo").unwrap();
+    assert_eq!(val, None);
+}
+
+#[test]
+fn test_active_expiry() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for i in 0..100 {
+        let _: () = redis::cmd("SET")
+            .arg(format!("volatile_{i}"))
+            .arg("val")
+            .arg(&["PX", "100"])
+            .query(con)
+            .unwrap();
+    }
+    let _: () = con.set("persistent", "val").unwrap();
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 101);
+
+    // The expired keys are removed in the background without ever being accessed
+    thread::sleep(Duration::from_millis(1000));
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 1);
+}
+
+#[test]
+fn test_ttl_pttl() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Non-existent key
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert_eq!(ttl, -2);
+    let pttl: i64 = con.pttl("foo").unwrap();
+    assert_eq!(pttl, -2);
+
+    // Key without any expiry
+    let _: () = con.set("foo", "bar").unwrap();
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert_eq!(ttl, -1);
+    let pttl: i64 = con.pttl("foo").unwrap();
+    assert_eq!(pttl, -1);
+
+    // Key with an expiry
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "bar", "EX", "100"])
+        .query(con)
+        .unwrap();
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert_eq!(ttl, 100);
+    let pttl: i64 = con.pttl("foo").unwrap();
+    assert!(pttl > 99_000 && pttl <= 100_000);
+
+    // Expired key which hasn't been removed by the active expiry yet
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "bar", "PX", "1"])
+        .query(con)
+        .unwrap();
+    thread::sleep(Duration::from_millis(5));
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert_eq!(ttl, -2);
+    let val: Option<String> = con.get("foo").unwrap();
+    assert_eq!(val, None);
+}
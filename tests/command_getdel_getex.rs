@@ -0,0 +1,179 @@
+use redis::Commands;
+use std::thread;
+
+mod utils;
+
+#[test]
+fn test_getdel() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("key", "value").unwrap();
+    let val: Option<String> = con.get_del("key").unwrap();
+    assert_eq!(val.as_deref(), Some("value"));
+    let val: Option<String> = con.get("key").unwrap();
+    assert_eq!(val, None);
+    let val: Option<String> = con.get_del("key").unwrap();
+    assert_eq!(val, None);
+
+    // A key of another type isn't removed
+    let _: usize = con.rpush("list", "a").unwrap();
+    let err = con.get_del::<_, Option<String>>("list").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let len: usize = con.llen("list").unwrap();
+    assert_eq!(len, 1);
+}
+
+#[test]
+fn test_getdel_is_atomic() {
+    let test_server = utils::start_server_and_get_connection();
+    let mut con = utils::get_connection(&test_server.port);
+    let _: () = con.set("key", "value").unwrap();
+
+    // Only one of the clients racing to consume the value gets it
+    let clients: Vec<_> = (0..8)
+        .map(|_| {
+            let port = test_server.port.clone();
+            thread::spawn(move || {
+                let mut con = utils::get_connection(&port);
+                con.get_del::<_, Option<String>>("key").unwrap()
+            })
+        })
+        .collect();
+    let values: Vec<String> = clients
+        .into_iter()
+        .filter_map(|client| client.join().unwrap())
+        .collect();
+    assert_eq!(values, ["value"]);
+}
+
+#[test]
+fn test_getex() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Without any option the TTL is left as it is
+    let _: () = redis::cmd("SET")
+        .arg(&["key", "value", "EX", "100"])
+        .query(con)
+        .unwrap();
+    let val: Option<String> = redis::cmd("GETEX").arg("key").query(con).unwrap();
+    assert_eq!(val.as_deref(), Some("value"));
+    let ttl: i64 = con.ttl("key").unwrap();
+    assert!((99..=100).contains(&ttl));
+
+    let val: Option<String> = redis::cmd("GETEX")
+        .arg(&["key", "EX", "1000"])
+        .query(con)
+        .unwrap();
+    assert_eq!(val.as_deref(), Some("value"));
+    let ttl: i64 = con.ttl("key").unwrap();
+    assert!((999..=1000).contains(&ttl));
+    let _: Option<String> = redis::cmd("GETEX")
+        .arg(&["key", "PX", "50000"])
+        .query(con)
+        .unwrap();
+    let ttl: i64 = con.pttl("key").unwrap();
+    assert!((49_000..=50_000).contains(&ttl));
+
+    let _: Option<String> = redis::cmd("GETEX")
+        .arg(&["key", "PERSIST"])
+        .query(con)
+        .unwrap();
+    let ttl: i64 = con.ttl("key").unwrap();
+    assert_eq!(ttl, -1);
+
+    // An expiry time in the past removes the key after getting it
+    let val: Option<String> = redis::cmd("GETEX")
+        .arg(&["key", "EXAT", "1"])
+        .query(con)
+        .unwrap();
+    assert_eq!(val.as_deref(), Some("value"));
+    let val: Option<String> = con.get("key").unwrap();
+    assert_eq!(val, None);
+
+    let val: Option<String> = redis::cmd("GETEX")
+        .arg(&["missing", "EX", "10"])
+        .query(con)
+        .unwrap();
+    assert_eq!(val, None);
+}
+
+#[test]
+fn test_getex_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("key", "value").unwrap();
+
+    for args in [
+        &["key", "EX"][..],
+        &["key", "EX", "10", "PERSIST"],
+        &["key", "KEEPTTL"],
+    ] {
+        let err = redis::cmd("GETEX")
+            .arg(args)
+            .query::<Option<String>>(con)
+            .unwrap_err();
+        assert_eq!(err.detail(), Some("syntax error"), "{args:?}");
+    }
+    let err = redis::cmd("GETEX")
+        .arg(&["key", "EX", "0"])
+        .query::<Option<String>>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("invalid expire time in 'getex' command"));
+    let ttl: i64 = con.ttl("key").unwrap();
+    assert_eq!(ttl, -1);
+
+    let _: usize = con.rpush("list", "a").unwrap();
+    let err = redis::cmd("GETEX")
+        .arg(&["list", "EX", "10"])
+        .query::<Option<String>>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let ttl: i64 = con.ttl("list").unwrap();
+    assert_eq!(ttl, -1);
+}
+
+#[test]
+fn test_getex_logged_with_absolute_time() {
+    let dir = utils::create_temp_dir("getex-aof");
+    let port = utils::find_free_tcp_port().to_string();
+    let args = [
+        port.as_str(),
+        "--dir",
+        dir.to_str().unwrap(),
+        "--appendonly",
+        "yes",
+    ];
+    {
+        let _server = utils::start_server_with_args(&args);
+        let mut con = utils::get_connection(&port);
+        let _: () = con.set("volatile", "value").unwrap();
+        let _: Option<String> = redis::cmd("GETEX")
+            .arg(&["volatile", "EX", "100"])
+            .query(&mut con)
+            .unwrap();
+        let _: () = redis::cmd("SET")
+            .arg(&["persistent", "value", "EX", "100"])
+            .query(&mut con)
+            .unwrap();
+        let _: Option<String> = redis::cmd("GETEX")
+            .arg(&["persistent", "PERSIST"])
+            .query(&mut con)
+            .unwrap();
+        let _: () = con.set("removed", "value").unwrap();
+        let _: Option<String> = con.get_del("removed").unwrap();
+    }
+    let aof = String::from_utf8(std::fs::read(dir.join("appendonly.aof")).unwrap()).unwrap();
+    assert!(!aof.contains("GETEX"), "{aof}");
+    assert!(aof.contains("$9\r\nPEXPIREAT\r\n"), "{aof}");
+
+    let _server = utils::start_server_with_args(&args);
+    let mut con = utils::get_connection(&port);
+    let ttl: i64 = con.ttl("volatile").unwrap();
+    assert!((98..=100).contains(&ttl), "{ttl}");
+    let ttl: i64 = con.ttl("persistent").unwrap();
+    assert_eq!(ttl, -1);
+    let val: Option<String> = con.get("removed").unwrap();
+    assert_eq!(val, None);
+}
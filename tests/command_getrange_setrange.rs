@@ -0,0 +1,99 @@
+use redis::Commands;
+
+mod utils;
+
+#[test]
+fn test_getrange() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("key", "Hello World").unwrap();
+
+    for (start, end, expected) in [
+        (0, 4, "Hello"),
+        (-5, -1, "World"),
+        (0, -1, "Hello World"),
+        (-100, 4, "Hello"),
+        (6, 100, "World"),
+        (5, 3, ""),
+        (-1, -5, ""),
+        (20, 30, ""),
+        (-100, -50, "H"),
+    ] {
+        let substring: String = con.getrange("key", start, end).unwrap();
+        assert_eq!(substring, expected, "{start} {end}");
+    }
+    let substring: String = con.getrange("missing", 0, -1).unwrap();
+    assert_eq!(substring, "");
+    // Integers are strings as well
+    let _: () = con.set("number", 1234).unwrap();
+    let substring: String = con.getrange("number", 1, 2).unwrap();
+    assert_eq!(substring, "23");
+
+    let err = redis::cmd("GETRANGE")
+        .arg(&["key", "a", "1"])
+        .query::<String>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("value is not an integer or out of range")
+    );
+    let _: usize = con.rpush("list", "a").unwrap();
+    let err = con.getrange::<_, String>("list", 0, 1).unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_setrange() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("key", "Hello World").unwrap();
+
+    let len: usize = con.setrange("key", 6, "Redis").unwrap();
+    assert_eq!(len, 11);
+    let val: String = con.get("key").unwrap();
+    assert_eq!(val, "Hello Redis");
+    let len: usize = con.setrange("key", 6, "Redis server").unwrap();
+    assert_eq!(len, 18);
+    let val: String = con.get("key").unwrap();
+    assert_eq!(val, "Hello Redis server");
+    // An empty value doesn't modify the string
+    let len: usize = con.setrange("key", 100, "").unwrap();
+    assert_eq!(len, 18);
+
+    let err = con.setrange::<_, _, usize>("key", -1, "x").unwrap_err();
+    assert_eq!(err.detail(), Some("offset is out of range"));
+    let err = con
+        .setrange::<_, _, usize>("key", 536_870_911, "xx")
+        .unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+    let _: usize = con.rpush("list", "a").unwrap();
+    let err = con.setrange::<_, _, usize>("list", 0, "x").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_setrange_zero_padding() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // A missing key is created padded with zero bytes up to the offset
+    let len: usize = con.setrange("missing", 5, "abc").unwrap();
+    assert_eq!(len, 8);
+    let val: Vec<u8> = con.get("missing").unwrap();
+    assert_eq!(val, b"\0\0\0\0\0abc");
+
+    // An existing string shorter than the offset is padded as well
+    let _: () = con.set("short", "ab").unwrap();
+    let len: usize = con.setrange("short", 4, "cd").unwrap();
+    assert_eq!(len, 6);
+    let val: Vec<u8> = con.get("short").unwrap();
+    assert_eq!(val, b"ab\0\0cd");
+    let substring: Vec<u8> = con.getrange("short", 1, 3).unwrap();
+    assert_eq!(substring, b"b\0\0");
+
+    // An empty value doesn't create the key
+    let len: usize = con.setrange("empty", 10, "").unwrap();
+    assert_eq!(len, 0);
+    let val: Option<String> = con.get("empty").unwrap();
+    assert_eq!(val, None);
+}
@@ -0,0 +1,320 @@
+use redis::Commands;
+use std::collections::HashMap;
+
+mod utils;
+
+use utils::send_raw;
+
+#[test]
+fn test_hset_hget() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Reply is the number of new fields
+    let hset_result: usize = con.hset("hash", "a", "1").unwrap();
+    assert_eq!(hset_result, 1);
+    let hset_result: usize = redis::cmd("HSET")
+        .arg("hash")
+        .arg(&["a", "10", "b", "2", "c", "3"])
+        .query(con)
+        .unwrap();
+    assert_eq!(hset_result, 2);
+
+    let hget_result: String = con.hget("hash", "a").unwrap();
+    assert_eq!(hget_result, "10");
+    let hget_result: Option<String> = con.hget("hash", "missing").unwrap();
+    assert_eq!(hget_result, None);
+    let hget_result: Option<String> = con.hget("missing", "a").unwrap();
+    assert_eq!(hget_result, None);
+
+    let hlen_result: usize = con.hlen("hash").unwrap();
+    assert_eq!(hlen_result, 3);
+    let hlen_result: usize = con.hlen("missing").unwrap();
+    assert_eq!(hlen_result, 0);
+
+    let hexists_result: bool = con.hexists("hash", "b").unwrap();
+    assert!(hexists_result);
+    let hexists_result: bool = con.hexists("hash", "missing").unwrap();
+    assert!(!hexists_result);
+
+    let hgetall_result: HashMap<String, String> = con.hgetall("hash").unwrap();
+    assert_eq!(
+        hgetall_result,
+        HashMap::from([
+            ("a".to_string(), "10".to_string()),
+            ("b".to_string(), "2".to_string()),
+            ("c".to_string(), "3".to_string())
+        ])
+    );
+    let hgetall_result: HashMap<String, String> = con.hgetall("missing").unwrap();
+    assert!(hgetall_result.is_empty());
+}
+
+#[test]
+fn test_hsetnx_hstrlen() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // The hash is created on the first call
+    let is_set: bool = con.hset_nx("hash", "a", "value").unwrap();
+    assert!(is_set);
+    let hgetall_result: HashMap<String, String> = con.hgetall("hash").unwrap();
+    assert_eq!(
+        hgetall_result,
+        HashMap::from([("a".to_string(), "value".to_string())])
+    );
+    // An existing field is left unchanged
+    let is_set: bool = con.hset_nx("hash", "a", "other").unwrap();
+    assert!(!is_set);
+    let hget_result: String = con.hget("hash", "a").unwrap();
+    assert_eq!(hget_result, "value");
+    let is_set: bool = con.hset_nx("hash", "b", "").unwrap();
+    assert!(is_set);
+
+    let len: usize = redis::cmd("HSTRLEN")
+        .arg(&["hash", "a"])
+        .query(con)
+        .unwrap();
+    assert_eq!(len, 5);
+    let len: usize = redis::cmd("HSTRLEN")
+        .arg(&["hash", "b"])
+        .query(con)
+        .unwrap();
+    assert_eq!(len, 0);
+    let len: usize = redis::cmd("HSTRLEN")
+        .arg(&["hash", "missing"])
+        .query(con)
+        .unwrap();
+    assert_eq!(len, 0);
+    let len: usize = redis::cmd("HSTRLEN")
+        .arg(&["missing", "a"])
+        .query(con)
+        .unwrap();
+    assert_eq!(len, 0);
+
+    let _: () = con.set("string", "value").unwrap();
+    let err = con
+        .hset_nx::<_, _, _, bool>("string", "a", "1")
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = redis::cmd("HSTRLEN")
+        .arg(&["string", "a"])
+        .query::<usize>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_hdel() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = redis::cmd("HSET")
+        .arg("hash")
+        .arg(&["a", "1", "b", "2", "c", "3"])
+        .query(con)
+        .unwrap();
+
+    // Reply is the number of fields which existed
+    let hdel_result: usize = con.hdel("hash", &["a", "b", "missing"]).unwrap();
+    assert_eq!(hdel_result, 2);
+    let hdel_result: usize = con.hdel("missing", "a").unwrap();
+    assert_eq!(hdel_result, 0);
+
+    // The key is removed along with its last field
+    let hdel_result: usize = con.hdel("hash", "c").unwrap();
+    assert_eq!(hdel_result, 1);
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 0);
+}
+
+#[test]
+fn test_hash_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Every field must have a value
+    let err = redis::cmd("HSET")
+        .arg("hash")
+        .arg(&["a", "1", "b"])
+        .query::<usize>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 0);
+
+    let _: () = con.set("string", "value").unwrap();
+    let results = [
+        con.hset::<_, _, _, usize>("string", "a", "1").map(|_| ()),
+        con.hget::<_, _, Option<String>>("string", "a").map(|_| ()),
+        con.hdel::<_, _, usize>("string", "a").map(|_| ()),
+        con.hgetall::<_, HashMap<String, String>>("string")
+            .map(|_| ()),
+        con.hlen::<_, usize>("string").map(|_| ()),
+        con.hexists::<_, _, bool>("string", "a").map(|_| ()),
+    ];
+    for result in results {
+        assert_eq!(result.unwrap_err().code(), Some("WRONGTYPE"));
+    }
+    // The string is left untouched
+    let get_result: String = con.get("string").unwrap();
+    assert_eq!(get_result, "value");
+}
+
+#[test]
+fn test_hgetall_resp3() {
+    let test_server = utils::start_server_and_get_connection();
+
+    let reply = send_raw(
+        &test_server.port,
+        &[b"HSET hash field value\r\nHGETALL hash\r\nHELLO 3\r\nHGETALL hash\r\n"],
+    );
+    let reply = String::from_utf8(reply).unwrap();
+    // Flat array of fields and values in RESP2 and a map in RESP3
+    assert!(
+        reply.starts_with(":1\r\n*2\r\n$5\r\nfield\r\n$5\r\nvalue\r\n%7\r\n"),
+        "{reply}"
+    );
+    assert!(
+        reply.ends_with("%1\r\n$5\r\nfield\r\n$5\r\nvalue\r\n"),
+        "{reply}"
+    );
+}
+
+#[test]
+fn test_hincrby() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // The hash and the field are created by the first increment
+    let hincrby_result: i64 = redis::cmd("HINCRBY")
+        .arg(&["hash", "counter", "5"])
+        .query(con)
+        .unwrap();
+    assert_eq!(hincrby_result, 5);
+    let hincrby_result: i64 = redis::cmd("HINCRBY")
+        .arg(&["hash", "counter", "-7"])
+        .query(con)
+        .unwrap();
+    assert_eq!(hincrby_result, -2);
+    let hget_result: String = con.hget("hash", "counter").unwrap();
+    assert_eq!(hget_result, "-2");
+
+    let _: usize = con.hset("hash", "text", "abc").unwrap();
+    let _: usize = con.hset("hash", "max", i64::MAX).unwrap();
+    let cases = [
+        (["hash", "text", "1"], "hash value is not an integer"),
+        (
+            ["hash", "counter", "1.5"],
+            "value is not an integer or out of range",
+        ),
+        (
+            ["hash", "max", "1"],
+            "increment or decrement would overflow",
+        ),
+    ];
+    for (args, expected) in cases {
+        let err = redis::cmd("HINCRBY")
+            .arg(&args)
+            .query::<i64>(con)
+            .unwrap_err();
+        assert_eq!(err.detail(), Some(expected), "{args:?}");
+    }
+}
+
+#[test]
+fn test_hincrbyfloat() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // The hash and the field are created by the first increment, and the result has no trailing zeros
+    let hincrbyfloat_result: String = redis::cmd("HINCRBYFLOAT")
+        .arg(&["hash", "price", "10.50"])
+        .query(con)
+        .unwrap();
+    assert_eq!(hincrbyfloat_result, "10.5");
+    let exists: bool = con.exists("hash").unwrap();
+    assert!(exists);
+    for (delta, expected) in [("0.1", "10.6"), ("-5.6", "5"), ("5e3", "5005")] {
+        let hincrbyfloat_result: String = redis::cmd("HINCRBYFLOAT")
+            .arg(&["hash", "price", delta])
+            .query(con)
+            .unwrap();
+        assert_eq!(hincrbyfloat_result, expected);
+    }
+    let _: usize = con.hset("hash", "small", "0.1").unwrap();
+    let hincrbyfloat_result: String = redis::cmd("HINCRBYFLOAT")
+        .arg(&["hash", "small", "0.2"])
+        .query(con)
+        .unwrap();
+    assert_eq!(hincrbyfloat_result, "0.30000000000000004");
+
+    let _: usize = con.hset("hash", "text", "abc").unwrap();
+    let cases = [
+        (["hash", "text", "1"], "hash value is not a float"),
+        (["hash", "price", "abc"], "value is not a valid float"),
+        (
+            ["other", "price", "inf"],
+            "increment would produce NaN or Infinity",
+        ),
+    ];
+    for (args, expected) in cases {
+        let err = redis::cmd("HINCRBYFLOAT")
+            .arg(&args)
+            .query::<String>(con)
+            .unwrap_err();
+        assert_eq!(err.detail(), Some(expected), "{args:?}");
+    }
+    let exists: bool = con.exists("other").unwrap();
+    assert!(!exists);
+}
+
+#[test]
+fn test_hrandfield() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = redis::cmd("HSET")
+        .arg(&["hash", "a", "1", "b", "2", "c", "3"])
+        .query(con)
+        .unwrap();
+    let hash = HashMap::from([
+        ("a".to_string(), "1".to_string()),
+        ("b".to_string(), "2".to_string()),
+        ("c".to_string(), "3".to_string()),
+    ]);
+
+    let hrandfield_result: String = redis::cmd("HRANDFIELD").arg("hash").query(con).unwrap();
+    assert!(hash.contains_key(&hrandfield_result));
+    let hrandfield_result: Option<String> =
+        redis::cmd("HRANDFIELD").arg("missing").query(con).unwrap();
+    assert_eq!(hrandfield_result, None);
+
+    // A positive count gives distinct fields, at most all of them
+    let hrandfield_result: Vec<String> = redis::cmd("HRANDFIELD")
+        .arg(&["hash", "5"])
+        .query(con)
+        .unwrap();
+    assert_eq!(hrandfield_result.len(), 3);
+    let hrandfield_result: HashMap<String, String> = redis::cmd("HRANDFIELD")
+        .arg(&["hash", "3", "WITHVALUES"])
+        .query(con)
+        .unwrap();
+    assert_eq!(hrandfield_result, hash);
+
+    // A negative count may repeat fields, so it can give more of them than the hash has
+    let hrandfield_result: Vec<String> = redis::cmd("HRANDFIELD")
+        .arg(&["hash", "-7", "WITHVALUES"])
+        .query(con)
+        .unwrap();
+    assert_eq!(hrandfield_result.len(), 14);
+    for pair in hrandfield_result.chunks_exact(2) {
+        assert_eq!(hash[&pair[0]], pair[1]);
+    }
+
+    let err = redis::cmd("HRANDFIELD")
+        .arg(&["hash", "1", "WITHSCORES"])
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
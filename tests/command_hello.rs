@@ -0,0 +1,137 @@
+mod utils;
+
+use utils::send_raw;
+
+#[test]
+fn test_hello() {
+    let test_server = utils::start_server_and_get_connection();
+
+    // RESP2 reply of HELLO is a flat array of keys and values
+    let reply = String::from_utf8(send_raw(&test_server.port, &[b"HELLO\r\n"])).unwrap();
+    assert!(
+        reply.starts_with("*14\r\n$6\r\nserver\r\n$5\r\nredis\r\n"),
+        "{reply}"
+    );
+    assert!(reply.contains("$5\r\nproto\r\n:2\r\n"), "{reply}");
+
+    let reply = String::from_utf8(send_raw(&test_server.port, &[b"HELLO 2\r\n"])).unwrap();
+    assert!(reply.starts_with("*14\r\n"), "{reply}");
+    assert!(reply.contains("$5\r\nproto\r\n:2\r\n"), "{reply}");
+
+    // RESP3 reply of HELLO is a map, and is already encoded in RESP3
+    let reply = String::from_utf8(send_raw(&test_server.port, &[b"HELLO 3\r\n"])).unwrap();
+    assert!(
+        reply.starts_with("%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n"),
+        "{reply}"
+    );
+    assert!(reply.contains("$5\r\nproto\r\n:3\r\n"), "{reply}");
+    assert!(reply.ends_with("$7\r\nmodules\r\n*0\r\n"), "{reply}");
+}
+
+#[test]
+fn test_hello_protocol_is_per_connection() {
+    let test_server = utils::start_server_and_get_connection();
+
+    // Null replies are encoded as `_` only after switching to RESP3
+    let reply = send_raw(
+        &test_server.port,
+        &[b"GET missing\r\nHELLO 3\r\nGET missing\r\nHELLO 2\r\nGET missing\r\n"],
+    );
+    let reply = String::from_utf8(reply).unwrap();
+    assert!(reply.starts_with("$-1\r\n%7\r\n"), "{reply}");
+    assert!(reply.contains("*0\r\n_\r\n*14\r\n"), "{reply}");
+    assert!(reply.ends_with("*0\r\n$-1\r\n"), "{reply}");
+
+    // A new connection starts with RESP2 regardless of the other connections
+    let reply = send_raw(&test_server.port, &[b"GET missing\r\n"]);
+    assert_eq!(reply, b"$-1\r\n");
+}
+
+#[test]
+fn test_hello_errors() {
+    let test_server = utils::start_server_and_get_connection();
+
+    let cases: &[(&[u8], &[u8])] = &[
+        (b"HELLO 4\r\n", b"-NOPROTO unsupported protocol version\r\n"),
+        (b"HELLO 1\r\n", b"-NOPROTO unsupported protocol version\r\n"),
+        (
+            b"HELLO three\r\n",
+            b"-ERR Protocol version is not an integer or out of range\r\n",
+        ),
+        // A failed HELLO doesn't switch the protocol, even when only its options are wrong
+        (
+            b"HELLO 4\r\nGET missing\r\n",
+            b"-NOPROTO unsupported protocol version\r\n$-1\r\n",
+        ),
+        (
+            b"HELLO 3 SETNAME\r\nGET missing\r\n",
+            b"-ERR syntax error\r\n$-1\r\n",
+        ),
+        (
+            b"HELLO 3 AUTH default\r\nGET missing\r\n",
+            b"-ERR syntax error\r\n$-1\r\n",
+        ),
+        (
+            b"*4\r\n$5\r\nHELLO\r\n$1\r\n3\r\n$7\r\nSETNAME\r\n$3\r\na b\r\nGET missing\r\n",
+            b"-ERR Client names cannot contain spaces, newlines or special characters.\r\n$-1\r\n",
+        ),
+        (
+            b"HELLO 3 NOSUCHOPTION\r\nGET missing\r\n",
+            b"-ERR syntax error\r\n$-1\r\n",
+        ),
+    ];
+    for &(input, expected) in cases {
+        let reply = send_raw(&test_server.port, &[input]);
+        assert_eq!(reply, expected, "{:?}", String::from_utf8_lossy(&reply));
+    }
+}
+
+#[test]
+fn test_hello_auth_and_setname() {
+    let port = utils::find_free_tcp_port().to_string();
+    let _server = utils::start_server_with_args(&[&port, "--requirepass", "s3cret"]);
+
+    // Without AUTH, HELLO needs the client to be authenticated already
+    let reply = send_raw(&port, &[b"HELLO 3\r\nGET missing\r\n"]);
+    let reply = String::from_utf8(reply).unwrap();
+    assert!(reply.starts_with("-NOAUTH HELLO must be called"), "{reply}");
+    assert!(
+        reply.ends_with("-NOAUTH Authentication required.\r\n"),
+        "{reply}"
+    );
+
+    // A wrong password neither switches the protocol nor names the client
+    let reply = send_raw(
+        &port,
+        &[b"HELLO 3 AUTH default wrong SETNAME app\r\nAUTH s3cret\r\nGET missing\r\nCLIENT GETNAME\r\n"],
+    );
+    assert_eq!(
+        String::from_utf8(reply).unwrap(),
+        "-WRONGPASS invalid username-password pair or user is disabled.\r\n+OK\r\n$-1\r\n$-1\r\n"
+    );
+
+    let reply = send_raw(
+        &port,
+        &[b"HELLO 3 AUTH default s3cret SETNAME app\r\nGET missing\r\nCLIENT GETNAME\r\n"],
+    );
+    let reply = String::from_utf8(reply).unwrap();
+    assert!(reply.starts_with("%7\r\n"), "{reply}");
+    assert!(reply.ends_with("_\r\n$3\r\napp\r\n"), "{reply}");
+}
+
+#[test]
+fn test_hello_role() {
+    let master_port = utils::find_free_tcp_port().to_string();
+    let _master = utils::start_server(&master_port);
+    let replica_port = utils::find_free_tcp_port().to_string();
+    let _replica = utils::start_server_with_args(&[
+        &replica_port,
+        "--replicaof",
+        &format!("127.0.0.1 {master_port}"),
+    ]);
+
+    let reply = String::from_utf8(send_raw(&master_port, &[b"HELLO 3\r\n"])).unwrap();
+    assert!(reply.contains("$4\r\nrole\r\n$6\r\nmaster\r\n"), "{reply}");
+    let reply = String::from_utf8(send_raw(&replica_port, &[b"HELLO 3\r\n"])).unwrap();
+    assert!(reply.contains("$4\r\nrole\r\n$7\r\nreplica\r\n"), "{reply}");
+}
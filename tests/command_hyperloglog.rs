@@ -0,0 +1,135 @@
+use redis::Commands;
+
+mod utils;
+
+#[test]
+fn test_pfadd_pfcount() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Creating the key counts as a change, even without elements
+    let changed: bool = redis::cmd("PFADD").arg("empty").query(con).unwrap();
+    assert!(changed);
+    let changed: bool = redis::cmd("PFADD").arg("empty").query(con).unwrap();
+    assert!(!changed);
+    let count: usize = redis::cmd("PFCOUNT").arg("empty").query(con).unwrap();
+    assert_eq!(count, 0);
+    let key_type: String = redis::cmd("TYPE").arg("empty").query(con).unwrap();
+    assert_eq!(key_type, "string");
+
+    // Few elements are counted exactly, and adding them again changes nothing
+    let changed: bool = con.pfadd("hll", &["a", "b", "c", "d", "e"]).unwrap();
+    assert!(changed);
+    let changed: bool = con.pfadd("hll", &["a", "c", "e"]).unwrap();
+    assert!(!changed);
+    let count: usize = con.pfcount("hll").unwrap();
+    assert_eq!(count, 5);
+    let changed: bool = con.pfadd("hll", &["f", "g", "h", "i", "j"]).unwrap();
+    assert!(changed);
+    let count: usize = con.pfcount("hll").unwrap();
+    assert_eq!(count, 10);
+    let count: usize = con.pfcount("missing").unwrap();
+    assert_eq!(count, 0);
+
+    // The string is the sparse encoding of Redis
+    let value: Vec<u8> = con.get("hll").unwrap();
+    assert!(value.starts_with(b"HYLL\x01"));
+    assert!(value.len() < 100);
+}
+
+#[test]
+fn test_pfcount_error_bound() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // The standard error is 0.81%, so the estimate stays well within 5% as the string turns dense
+    let mut added = 0;
+    for batch in 0..10 {
+        let elements: Vec<String> = (0..1000)
+            .map(|i| format!("element:{}", batch * 1000 + i))
+            .collect();
+        let _: bool = con.pfadd("hll", &elements).unwrap();
+        added += elements.len();
+        let count: usize = con.pfcount("hll").unwrap();
+        assert!(count.abs_diff(added) * 20 < added, "{count} for {added}");
+    }
+    let value: Vec<u8> = con.get("hll").unwrap();
+    assert!(value.starts_with(b"HYLL\x00"));
+    assert_eq!(value.len(), 16 + 12288);
+
+    // Adding the same elements again doesn't change the estimate
+    let before: usize = con.pfcount("hll").unwrap();
+    let elements: Vec<String> = (0..5000).map(|i| format!("element:{i}")).collect();
+    let _: bool = con.pfadd("hll", &elements).unwrap();
+    let after: usize = con.pfcount("hll").unwrap();
+    assert_eq!(before, after);
+}
+
+#[test]
+fn test_pfcount_pfmerge_union() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let first: Vec<String> = (0..3000).map(|i| format!("element:{i}")).collect();
+    let second: Vec<String> = (2000..5000).map(|i| format!("element:{i}")).collect();
+    let _: bool = con.pfadd("hll1", &first).unwrap();
+    let _: bool = con.pfadd("hll2", &second).unwrap();
+
+    // The union counts the 1000 shared elements once
+    let union_count: usize = con.pfcount(&["hll1", "hll2", "missing"]).unwrap();
+    assert!(union_count.abs_diff(5000) < 250, "{union_count}");
+
+    let _: () = redis::cmd("PFMERGE")
+        .arg(&["merged", "hll1", "hll2"])
+        .query(con)
+        .unwrap();
+    let count: usize = con.pfcount("merged").unwrap();
+    assert_eq!(count, union_count);
+
+    // The destination is part of the union
+    let _: bool = con.pfadd("destination", &["x", "y"]).unwrap();
+    let _: () = redis::cmd("PFMERGE")
+        .arg(&["destination", "missing"])
+        .query(con)
+        .unwrap();
+    let count: usize = con.pfcount("destination").unwrap();
+    assert_eq!(count, 2);
+    let _: () = redis::cmd("PFMERGE")
+        .arg(&["destination", "merged"])
+        .query(con)
+        .unwrap();
+    let count: usize = con.pfcount("destination").unwrap();
+    assert!(count.abs_diff(5002) < 250, "{count}");
+}
+
+#[test]
+fn test_hyperloglog_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("string", "value").unwrap();
+    let _: () = con
+        .set(
+            "corrupted",
+            b"HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f",
+        )
+        .unwrap();
+    let _: usize = con.rpush("list", "a").unwrap();
+
+    for key in ["string", "list"] {
+        let err = con.pfadd::<_, _, bool>(key, "a").unwrap_err();
+        assert_eq!(err.code(), Some("WRONGTYPE"), "{key}");
+        let err = con.pfcount::<_, usize>(key).unwrap_err();
+        assert_eq!(err.code(), Some("WRONGTYPE"), "{key}");
+    }
+    let err = con.pfcount::<_, usize>("string").unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Key is not a valid HyperLogLog string value.")
+    );
+    let err = redis::cmd("PFMERGE")
+        .arg(&["destination", "string"])
+        .query::<()>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = con.pfcount::<_, usize>("corrupted").unwrap_err();
+    assert_eq!(err.code(), Some("INVALIDOBJ"));
+}
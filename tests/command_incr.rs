@@ -0,0 +1,187 @@
+use redis::Commands;
+use std::thread;
+
+mod utils;
+
+#[test]
+fn test_incr_decr() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Missing key is initialized to 0
+    let value: i64 = redis::cmd("INCR").arg("counter").query(con).unwrap();
+    assert_eq!(value, 1);
+    let value: i64 = redis::cmd("INCR").arg("counter").query(con).unwrap();
+    assert_eq!(value, 2);
+    let value: i64 = con.incr("counter", 10).unwrap();
+    assert_eq!(value, 12);
+    let value: i64 = redis::cmd("DECR").arg("counter").query(con).unwrap();
+    assert_eq!(value, 11);
+    let value: i64 = con.decr("counter", 20).unwrap();
+    assert_eq!(value, -9);
+    let value: i64 = con.decr("new_counter", 5).unwrap();
+    assert_eq!(value, -5);
+
+    // The value is stored as a string
+    let value: String = con.get("counter").unwrap();
+    assert_eq!(value, "-9");
+
+    // The TTL is retained
+    let _: () = redis::cmd("SET")
+        .arg(&["volatile", "1", "EX", "100"])
+        .query(con)
+        .unwrap();
+    let value: i64 = con.incr("volatile", 1).unwrap();
+    assert_eq!(value, 2);
+    let ttl: i64 = con.ttl("volatile").unwrap();
+    assert_eq!(ttl, 100);
+}
+
+#[test]
+fn test_incr_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Not an integer
+    let _: () = con.set("foo", "bar").unwrap();
+    let result: Result<i64, _> = con.incr("foo", 1);
+    assert_eq!(
+        result.unwrap_err().detail(),
+        Some("value is not an integer or out of range")
+    );
+    let _: () = con.set("foo", "+1").unwrap();
+    let result: Result<i64, _> = redis::cmd("INCR").arg("foo").query(con);
+    assert!(result.is_err());
+    let result: Result<i64, _> = redis::cmd("INCRBY").arg(&["bar", "1.5"]).query(con);
+    assert!(result.is_err());
+
+    // Overflow and underflow
+    let _: () = con.set("foo", i64::MAX).unwrap();
+    let result: Result<i64, _> = con.incr("foo", 1);
+    assert_eq!(
+        result.unwrap_err().detail(),
+        Some("value is not an integer or out of range")
+    );
+    let _: () = con.set("foo", i64::MIN).unwrap();
+    let result: Result<i64, _> = redis::cmd("DECR").arg("foo").query(con);
+    assert!(result.is_err());
+    let result: Result<i64, _> = con.decr("bar", i64::MIN);
+    assert!(result.is_err());
+    // The value is left untouched
+    let value: i64 = con.get("foo").unwrap();
+    assert_eq!(value, i64::MIN);
+
+    // Wrong type
+    let _: usize = con.rpush("list", "foo").unwrap();
+    let result: Result<i64, _> = con.incr("list", 1);
+    assert_eq!(result.unwrap_err().code(), Some("WRONGTYPE"));
+}
+
+// Reply to INCRBYFLOAT of the key by the increment
+fn incr_by_float(con: &mut redis::Connection, key: &str, increment: &str) -> String {
+    redis::cmd("INCRBYFLOAT")
+        .arg(&[key, increment])
+        .query(con)
+        .unwrap()
+}
+
+#[test]
+fn test_incrbyfloat() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // A missing key is created at the increment, and the results have neither trailing zeros nor an exponent
+    assert_eq!(incr_by_float(con, "price", "10.50"), "10.5");
+    for (increment, expected) in [
+        ("0.1", "10.6"),
+        ("-5.6", "5"),
+        ("3.0e3", "3005"),
+        ("1e10", "10000003005"),
+    ] {
+        assert_eq!(incr_by_float(con, "price", increment), expected);
+    }
+    let value: String = con.get("price").unwrap();
+    assert_eq!(value, "10000003005");
+    let _: () = con.set("small", "0.1").unwrap();
+    // The result is the shortest form of the double, like the Display of Rust
+    assert_eq!(incr_by_float(con, "small", "0.2"), "0.30000000000000004");
+    let _: () = con.set("integer", 5).unwrap();
+    assert_eq!(incr_by_float(con, "integer", "0.25"), "5.25");
+
+    // The results are formatted the same as those of HINCRBYFLOAT
+    for increment in ["1.10", "2.0e-3", "-7e2"] {
+        let hincrbyfloat_result: String = redis::cmd("HINCRBYFLOAT")
+            .arg(&["hash", "field", increment])
+            .query(con)
+            .unwrap();
+        assert_eq!(incr_by_float(con, "same", increment), hincrbyfloat_result);
+    }
+
+    // The TTL is retained
+    let _: () = redis::cmd("SET")
+        .arg(&["volatile", "1.5", "EX", "100"])
+        .query(con)
+        .unwrap();
+    assert_eq!(incr_by_float(con, "volatile", "1"), "2.5");
+    let ttl: i64 = con.ttl("volatile").unwrap();
+    assert_eq!(ttl, 100);
+
+    let _: () = con.set("text", "abc").unwrap();
+    let _: () = con.set("huge", "1.7e308").unwrap();
+    let _: usize = con.rpush("list", "a").unwrap();
+    let cases = [
+        (["text", "1"], "ERR value is not a valid float"),
+        (["price", "abc"], "ERR value is not a valid float"),
+        (
+            ["other", "inf"],
+            "ERR increment would produce NaN or Infinity",
+        ),
+        (
+            ["huge", "1.7e308"],
+            "ERR increment would produce NaN or Infinity",
+        ),
+        (
+            ["list", "1"],
+            "WRONGTYPE Operation against a key holding the wrong kind of value",
+        ),
+    ];
+    for (args, expected) in cases {
+        let err = redis::cmd("INCRBYFLOAT")
+            .arg(&args)
+            .query::<String>(con)
+            .unwrap_err();
+        let err = format!("{} {}", err.code().unwrap(), err.detail().unwrap());
+        assert_eq!(err, expected, "{args:?}");
+    }
+    // Nothing is stored on failure
+    let exists: bool = con.exists("other").unwrap();
+    assert!(!exists);
+    let value: String = con.get("price").unwrap();
+    assert_eq!(value, "10000003005");
+}
+
+#[test]
+fn test_concurrent_incr() {
+    let test_server = utils::start_server_and_get_connection();
+    let port = test_server.port.clone();
+
+    // No increments are lost when multiple clients update the same key
+    let handles: Vec<_> = (0..8)
+        .map(|_| {
+            let port = port.clone();
+            thread::spawn(move || {
+                let mut con = utils::get_connection(&port);
+                for _ in 0..250 {
+                    let _: i64 = con.incr("counter", 1).unwrap();
+                }
+            })
+        })
+        .collect();
+    for handle in handles {
+        handle.join().unwrap();
+    }
+
+    let mut con = utils::get_connection(&port);
+    let value: i64 = con.get("counter").unwrap();
+    assert_eq!(value, 2000);
+}
@@ -0,0 +1,144 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+fn info(con: &mut redis::Connection, sections: &[&str]) -> String {
+    redis::cmd("INFO").arg(sections).query(con).unwrap()
+}
+
+// Value of the field in the report, if it is there
+fn field<'a>(report: &'a str, name: &str) -> Option<&'a str> {
+    report
+        .split("\r\n")
+        .find_map(|line| line.strip_prefix(name)?.strip_prefix(':'))
+}
+
+#[test]
+fn test_info() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let port = test_server.port.clone();
+    let con = &mut test_server.connection;
+
+    let report = info(con, &[]);
+    let headers: Vec<&str> = report
+        .split("\r\n")
+        .filter(|line| line.starts_with('#'))
+        .collect();
+    assert_eq!(
+        headers,
+        ["# Server", "# Clients", "# Replication", "# Keyspace"]
+    );
+    assert!(report.contains("\r\n\r\n# Clients\r\n"));
+    assert_eq!(field(&report, "redis_version"), Some("7.4.0"));
+    assert_eq!(field(&report, "run_id").unwrap().len(), 40);
+    assert_eq!(field(&report, "tcp_port"), Some(port.as_str()));
+    assert!(field(&report, "uptime_in_seconds").is_some());
+    assert_eq!(field(&report, "role"), Some("master"));
+    assert_eq!(field(&report, "connected_slaves"), Some("0"));
+    assert_eq!(field(&report, "master_replid").unwrap().len(), 40);
+    assert_eq!(field(&report, "master_repl_offset"), Some("0"));
+    assert!(report.ends_with("# Keyspace\r\n"));
+    // The same sections are reported whichever way all of them are requested
+    for sections in [
+        &["default"][..],
+        &["all"],
+        &["EVERYTHING"],
+        &["server", "all"],
+    ] {
+        let all_report = info(con, sections);
+        assert_eq!(all_report.matches('#').count(), 4);
+    }
+
+    // Only the requested sections are reported, in the usual order
+    let report = info(con, &["server"]);
+    assert!(report.starts_with("# Server\r\n"));
+    assert_eq!(report.matches('#').count(), 1);
+    let report = info(con, &["Keyspace", "clients"]);
+    assert_eq!(
+        report,
+        "# Clients\r\nconnected_clients:1\r\n\r\n# Keyspace\r\n"
+    );
+    assert_eq!(info(con, &["nosuchsection"]), "");
+
+    let mut other_con = utils::get_connection(&port);
+    let report = info(&mut other_con, &["clients"]);
+    assert_eq!(field(&report, "connected_clients"), Some("2"));
+
+    let _: () = con.set("foo", "bar").unwrap();
+    let _: () = redis::cmd("SET")
+        .arg(&["baz", "qux", "EX", "100"])
+        .query(con)
+        .unwrap();
+    let _: () = redis::cmd("SELECT").arg(3).query(con).unwrap();
+    let _: () = con.set("foo", "bar").unwrap();
+    let report = info(con, &["keyspace"]);
+    assert_eq!(
+        report,
+        "# Keyspace\r\ndb0:keys=2,expires=1,avg_ttl=0\r\ndb3:keys=1,expires=0,avg_ttl=0\r\n"
+    );
+}
+
+#[test]
+fn test_info_replication() {
+    let master_port = utils::find_free_tcp_port().to_string();
+    let _master = utils::start_server(&master_port);
+    let mut master_con = utils::get_connection(&master_port);
+    let replica_port = utils::find_free_tcp_port().to_string();
+    let _replica = utils::start_server_with_args(&[
+        &replica_port,
+        "--replicaof",
+        &format!("127.0.0.1 {master_port}"),
+    ]);
+    let mut replica_con = utils::get_connection(&replica_port);
+
+    let mut report = info(&mut master_con, &["replication"]);
+    for _ in 0..50 {
+        if field(&report, "connected_slaves") == Some("1") {
+            break;
+        }
+        thread::sleep(Duration::from_millis(100));
+        report = info(&mut master_con, &["replication"]);
+    }
+    assert_eq!(field(&report, "role"), Some("master"));
+    assert_eq!(field(&report, "connected_slaves"), Some("1"));
+    let link = field(&report, "slave0").unwrap();
+    assert!(link.starts_with(&format!(
+        "ip=127.0.0.1,port={replica_port},state=online,offset="
+    )));
+    assert!(field(&report, "slave1").is_none());
+    // The link of the replica isn't a client connection
+    let report = info(&mut master_con, &["clients"]);
+    assert_eq!(field(&report, "connected_clients"), Some("1"));
+
+    let report = info(&mut replica_con, &["replication"]);
+    assert_eq!(field(&report, "role"), Some("slave"));
+    assert_eq!(field(&report, "master_host"), Some("127.0.0.1"));
+    assert_eq!(field(&report, "master_port"), Some(master_port.as_str()));
+    assert_eq!(field(&report, "connected_slaves"), Some("0"));
+
+    // The replica reports the replication ID of its master, and the offset it processed once it caught up
+    let _: () = master_con.set("foo", "bar").unwrap();
+    let master_report = info(&mut master_con, &["replication"]);
+    let mut report = info(&mut replica_con, &["replication"]);
+    for _ in 0..50 {
+        if field(&report, "master_repl_offset") == field(&master_report, "master_repl_offset") {
+            break;
+        }
+        thread::sleep(Duration::from_millis(100));
+        report = info(&mut replica_con, &["replication"]);
+    }
+    assert_eq!(field(&report, "master_link_status"), Some("up"));
+    assert_eq!(
+        field(&report, "master_replid"),
+        field(&master_report, "master_replid")
+    );
+    assert_eq!(
+        field(&report, "master_repl_offset"),
+        field(&master_report, "master_repl_offset")
+    );
+    assert_eq!(
+        field(&report, "slave_repl_offset"),
+        field(&master_report, "master_repl_offset")
+    );
+}
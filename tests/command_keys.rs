@@ -0,0 +1,67 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+// Keys matching the pattern, sorted as KEYS replies them in no particular order
+fn sorted_keys(con: &mut redis::Connection, pattern: &str) -> Vec<String> {
+    let mut keys: Vec<String> = con.keys(pattern).unwrap();
+    keys.sort();
+    keys
+}
+
+#[test]
+fn test_keys() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    assert!(sorted_keys(con, "*").is_empty());
+
+    for key in [
+        "user:1",
+        "user:2",
+        "user:10",
+        "session:1",
+        "hat",
+        "hit",
+        "hot",
+    ] {
+        let _: () = con.set(key, "value").unwrap();
+    }
+    let _: usize = con.rpush("users", "1").unwrap();
+
+    assert_eq!(
+        sorted_keys(con, "*"),
+        [
+            "hat",
+            "hit",
+            "hot",
+            "session:1",
+            "user:1",
+            "user:10",
+            "user:2",
+            "users"
+        ]
+    );
+    assert_eq!(sorted_keys(con, "user:*"), ["user:1", "user:10", "user:2"]);
+    assert_eq!(sorted_keys(con, "user:?"), ["user:1", "user:2"]);
+    assert_eq!(sorted_keys(con, "h[ao]t"), ["hat", "hot"]);
+    assert_eq!(sorted_keys(con, "h[^a]t"), ["hit", "hot"]);
+    assert_eq!(sorted_keys(con, "h[a-i]t"), ["hat", "hit"]);
+    assert_eq!(sorted_keys(con, "hit"), ["hit"]);
+    assert!(sorted_keys(con, "nothing*").is_empty());
+}
+
+#[test]
+fn test_keys_skips_expired_keys() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("kept", "value").unwrap();
+    let _: () = redis::cmd("SET")
+        .arg(&["gone", "value", "PX", "50"])
+        .query(con)
+        .unwrap();
+    thread::sleep(Duration::from_millis(100));
+    assert_eq!(sorted_keys(con, "*"), ["kept"]);
+}
@@ -0,0 +1,92 @@
+use redis::Commands;
+
+mod utils;
+
+fn lcs<T: redis::FromRedisValue>(con: &mut redis::Connection, args: &[&str]) -> T {
+    redis::cmd("LCS").arg(args).query(con).unwrap()
+}
+
+#[test]
+fn test_lcs() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("key1", "ohmytext").unwrap();
+    let _: () = con.set("key2", "mynewtext").unwrap();
+
+    let subsequence: String = lcs(con, &["key1", "key2"]);
+    assert_eq!(subsequence, "mytext");
+    let len: i64 = lcs(con, &["key1", "key2", "LEN"]);
+    assert_eq!(len, 6);
+
+    // A missing key is an empty string
+    let subsequence: String = lcs(con, &["key1", "missing"]);
+    assert_eq!(subsequence, "");
+    let len: i64 = lcs(con, &["missing", "key2", "LEN"]);
+    assert_eq!(len, 0);
+
+    let _: usize = con.rpush("list", "a").unwrap();
+    let err = redis::cmd("LCS")
+        .arg(&["key1", "list"])
+        .query::<String>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("The specified keys must contain string values")
+    );
+    let err = redis::cmd("LCS")
+        .arg(&["key1", "key2", "LEN", "IDX"])
+        .query::<String>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("If you want both the length and indexes, please just use IDX.")
+    );
+    let err = redis::cmd("LCS")
+        .arg(&["key1", "key2", "MINMATCHLEN", "x"])
+        .query::<String>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("value is not an integer or out of range")
+    );
+}
+
+#[test]
+fn test_lcs_idx() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("key1", "ohmytext").unwrap();
+    let _: () = con.set("key2", "mynewtext").unwrap();
+
+    // The matches are listed from the end of the strings, as a map flattened in RESP2
+    let reply: (String, Vec<Vec<(usize, usize)>>, String, usize) =
+        lcs(con, &["key1", "key2", "IDX"]);
+    assert_eq!(
+        reply,
+        (
+            "matches".to_owned(),
+            vec![vec![(4, 7), (5, 8)], vec![(2, 3), (0, 1)]],
+            "len".to_owned(),
+            6
+        )
+    );
+
+    let reply: (
+        String,
+        Vec<((usize, usize), (usize, usize), usize)>,
+        String,
+        usize,
+    ) = lcs(
+        con,
+        &["key1", "key2", "IDX", "MINMATCHLEN", "4", "WITHMATCHLEN"],
+    );
+    assert_eq!(
+        reply,
+        (
+            "matches".to_owned(),
+            vec![((4, 7), (5, 8), 4)],
+            "len".to_owned(),
+            6
+        )
+    );
+}
@@ -0,0 +1,112 @@
+use redis::Commands;
+
+mod utils;
+
+fn linsert(con: &mut redis::Connection, key: &str, position: &str, pivot: &str, val: &str) -> i64 {
+    redis::cmd("LINSERT")
+        .arg(&[key, position, pivot, val])
+        .query(con)
+        .unwrap()
+}
+
+#[test]
+fn test_lset() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.rpush("list", &["a", "b", "c"]).unwrap();
+    let _: () = con.lset("list", 0, "x").unwrap();
+    let _: () = con.lset("list", -1, "z").unwrap();
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["x", "b", "z"]);
+
+    for index in [3, -4] {
+        let err = con.lset::<_, _, ()>("list", index, "y").unwrap_err();
+        assert_eq!(err.detail(), Some("index out of range"));
+    }
+    let err = con.lset::<_, _, ()>("missing", 0, "y").unwrap_err();
+    assert_eq!(err.detail(), Some("no such key"));
+}
+
+#[test]
+fn test_linsert() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.rpush("list", &["a", "c", "a"]).unwrap();
+    let linsert_result = linsert(con, "list", "BEFORE", "a", "x");
+    assert_eq!(linsert_result, 4);
+    let linsert_result = linsert(con, "list", "AFTER", "c", "y");
+    assert_eq!(linsert_result, 5);
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["x", "a", "c", "y", "a"]);
+
+    let linsert_result = linsert(con, "list", "AFTER", "nosuchpivot", "y");
+    assert_eq!(linsert_result, -1);
+    let linsert_result = linsert(con, "missing", "AFTER", "a", "y");
+    assert_eq!(linsert_result, 0);
+    let err = redis::cmd("LINSERT")
+        .arg(&["list", "AROUND", "a", "y"])
+        .query::<i64>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
+
+#[test]
+fn test_lrem() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con
+        .rpush("list", &["a", "b", "a", "c", "a", "b", "a"])
+        .unwrap();
+    // A positive count removes from the head
+    let lrem_result: usize = con.lrem("list", 2, "a").unwrap();
+    assert_eq!(lrem_result, 2);
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["b", "c", "a", "b", "a"]);
+
+    // A negative count removes from the tail
+    let _: usize = con.rpush("list", &["c", "b"]).unwrap();
+    let lrem_result: usize = con.lrem("list", -2, "b").unwrap();
+    assert_eq!(lrem_result, 2);
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["b", "c", "a", "a", "c"]);
+    let lrem_result: usize = con.lrem("list", -10, "a").unwrap();
+    assert_eq!(lrem_result, 2);
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["b", "c", "c"]);
+
+    // 0 removes all the occurrences, and the key along with the last element
+    let lrem_result: usize = con.lrem("list", 0, "c").unwrap();
+    assert_eq!(lrem_result, 2);
+    let lrem_result: usize = con.lrem("list", 0, "b").unwrap();
+    assert_eq!(lrem_result, 1);
+    let exists: bool = con.exists("list").unwrap();
+    assert!(!exists);
+    let lrem_result: usize = con.lrem("list", 0, "b").unwrap();
+    assert_eq!(lrem_result, 0);
+}
+
+#[test]
+fn test_ltrim() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.rpush("list", &["a", "b", "c", "d", "e"]).unwrap();
+    let _: () = con.ltrim("list", 1, -2).unwrap();
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["b", "c", "d"]);
+    let _: () = con.ltrim("list", -100, 100).unwrap();
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["b", "c", "d"]);
+
+    // Trimming everything removes the key
+    let _: () = con.ltrim("list", 2, 1).unwrap();
+    let exists: bool = con.exists("list").unwrap();
+    assert!(!exists);
+
+    let _: () = con.set("string", "value").unwrap();
+    let err = con.ltrim::<_, ()>("string", 0, 1).unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
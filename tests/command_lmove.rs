@@ -0,0 +1,127 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+fn lmove(
+    con: &mut redis::Connection,
+    source: &str,
+    destination: &str,
+    from: &str,
+    to: &str,
+) -> redis::RedisResult<Option<String>> {
+    redis::cmd("LMOVE")
+        .arg(&[source, destination, from, to])
+        .query(con)
+}
+
+#[test]
+fn test_lmove() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.rpush("source", &["a", "b", "c"]).unwrap();
+
+    assert_eq!(
+        lmove(con, "source", "destination", "LEFT", "RIGHT").unwrap(),
+        Some("a".to_string())
+    );
+    assert_eq!(
+        lmove(con, "source", "destination", "right", "left").unwrap(),
+        Some("c".to_string())
+    );
+    let rpoplpush_result: Option<String> = redis::cmd("RPOPLPUSH")
+        .arg(&["source", "destination"])
+        .query(con)
+        .unwrap();
+    assert_eq!(rpoplpush_result, Some("b".to_string()));
+    let lrange_result: Vec<String> = con.lrange("destination", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["b", "c", "a"]);
+
+    // The key is removed along with the last element, and an empty source moves nothing
+    let exists: bool = con.exists("source").unwrap();
+    assert!(!exists);
+    assert_eq!(
+        lmove(con, "source", "destination", "LEFT", "LEFT").unwrap(),
+        None
+    );
+    let list_size: usize = con.llen("destination").unwrap();
+    assert_eq!(list_size, 3);
+
+    // The source and the destination may be the same list, which rotates it
+    assert_eq!(
+        lmove(con, "destination", "destination", "LEFT", "RIGHT").unwrap(),
+        Some("b".to_string())
+    );
+    let lrange_result: Vec<String> = con.lrange("destination", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["c", "a", "b"]);
+    let _: usize = con.rpush("single", "x").unwrap();
+    assert_eq!(
+        lmove(con, "single", "single", "RIGHT", "LEFT").unwrap(),
+        Some("x".to_string())
+    );
+    let lrange_result: Vec<String> = con.lrange("single", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["x"]);
+}
+
+#[test]
+fn test_lmove_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.rpush("list", "a").unwrap();
+    let _: () = con.set("string", "value").unwrap();
+
+    // Nothing is popped when the destination isn't a list
+    let err = lmove(con, "list", "string", "LEFT", "LEFT").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = lmove(con, "string", "list", "LEFT", "LEFT").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let list_size: usize = con.llen("list").unwrap();
+    assert_eq!(list_size, 1);
+
+    let err = lmove(con, "list", "other", "UP", "LEFT").unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let err = redis::cmd("BLMOVE")
+        .arg(&["list", "other", "LEFT", "LEFT", "-1"])
+        .query::<Option<String>>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("timeout is negative"));
+}
+
+#[test]
+fn test_blmove() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // An available element is moved right away
+    let _: usize = con.rpush("source", "a").unwrap();
+    let blmove_result: Option<String> = redis::cmd("BLMOVE")
+        .arg(&["source", "destination", "LEFT", "RIGHT", "0"])
+        .query(con)
+        .unwrap();
+    assert_eq!(blmove_result, Some("a".to_string()));
+
+    // The timeout elapses while the source is empty
+    let blmove_result: Option<String> = redis::cmd("BLMOVE")
+        .arg(&["source", "destination", "LEFT", "RIGHT", "0.1"])
+        .query(con)
+        .unwrap();
+    assert_eq!(blmove_result, None);
+
+    // The client blocks until the source receives an element
+    let mut blocked_con = utils::get_connection(&test_server.port);
+    let blocked = thread::spawn(move || {
+        redis::cmd("BLMOVE")
+            .arg(&["source", "destination", "RIGHT", "LEFT", "0"])
+            .query::<Option<String>>(&mut blocked_con)
+            .unwrap()
+    });
+    thread::sleep(Duration::from_millis(200));
+    let _: usize = con.rpush("source", &["b", "c"]).unwrap();
+    assert_eq!(blocked.join().unwrap(), Some("c".to_string()));
+    let lrange_result: Vec<String> = con.lrange("source", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["b"]);
+    let lrange_result: Vec<String> = con.lrange("destination", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["c", "a"]);
+}
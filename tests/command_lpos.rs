@@ -0,0 +1,114 @@
+use redis::{Commands, Value};
+
+mod utils;
+
+fn lpos<T: redis::FromRedisValue>(con: &mut redis::Connection, args: &[&str]) -> T {
+    redis::cmd("LPOS").arg("list").arg(args).query(con).unwrap()
+}
+
+#[test]
+fn test_lpos() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con
+        .rpush("list", &["a", "b", "c", "1", "2", "3", "c", "c"])
+        .unwrap();
+    assert_eq!(lpos::<i64>(con, &["c"]), 2);
+    assert_eq!(lpos::<Value>(con, &["x"]), Value::Nil);
+    assert_eq!(lpos::<i64>(con, &["c", "RANK", "2"]), 6);
+    assert_eq!(lpos::<Value>(con, &["c", "RANK", "4"]), Value::Nil);
+    // MAXLEN only compares the first elements
+    assert_eq!(lpos::<Value>(con, &["c", "MAXLEN", "2"]), Value::Nil);
+    assert_eq!(lpos::<i64>(con, &["c", "MAXLEN", "3"]), 2);
+
+    // A missing key has no matches
+    let reply: Value = redis::cmd("LPOS")
+        .arg(&["missing", "a"])
+        .query(con)
+        .unwrap();
+    assert_eq!(reply, Value::Nil);
+    let reply: Vec<i64> = redis::cmd("LPOS")
+        .arg(&["missing", "a", "COUNT", "0"])
+        .query(con)
+        .unwrap();
+    assert!(reply.is_empty());
+}
+
+#[test]
+fn test_lpos_negative_rank() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con
+        .rpush("list", &["a", "b", "c", "1", "2", "3", "c", "c"])
+        .unwrap();
+    // Matches are counted from the tail
+    assert_eq!(lpos::<i64>(con, &["c", "RANK", "-1"]), 7);
+    assert_eq!(lpos::<i64>(con, &["c", "RANK", "-3"]), 2);
+    assert_eq!(
+        lpos::<Vec<i64>>(con, &["c", "RANK", "-1", "COUNT", "2"]),
+        [7, 6]
+    );
+    assert_eq!(
+        lpos::<Vec<i64>>(con, &["c", "RANK", "-2", "COUNT", "0"]),
+        [6, 2]
+    );
+    // MAXLEN counts from the tail too
+    assert_eq!(
+        lpos::<Vec<i64>>(con, &["c", "RANK", "-1", "COUNT", "0", "MAXLEN", "2"]),
+        [7, 6]
+    );
+}
+
+#[test]
+fn test_lpos_count() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con
+        .rpush("list", &["a", "b", "c", "1", "2", "3", "c", "c"])
+        .unwrap();
+    // COUNT 0 returns all the matches
+    assert_eq!(lpos::<Vec<i64>>(con, &["c", "COUNT", "0"]), [2, 6, 7]);
+    assert_eq!(lpos::<Vec<i64>>(con, &["c", "COUNT", "2"]), [2, 6]);
+    assert_eq!(lpos::<Vec<i64>>(con, &["c", "COUNT", "1"]), [2]);
+    assert_eq!(
+        lpos::<Vec<i64>>(con, &["c", "COUNT", "0", "RANK", "2"]),
+        [6, 7]
+    );
+    assert!(lpos::<Vec<i64>>(con, &["x", "COUNT", "0"]).is_empty());
+}
+
+#[test]
+fn test_lpos_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.rpush("list", &["a"]).unwrap();
+    for (args, expected) in [
+        (&["a", "RANK", "0"][..], "RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"),
+        (&["a", "COUNT", "-1"], "COUNT can't be negative"),
+        (&["a", "MAXLEN", "-1"], "MAXLEN can't be negative"),
+        (&["a", "RANK", "x"], "value is not an integer or out of range"),
+        (&["a", "RANK"], "syntax error"),
+        (&["a", "FOO", "1"], "syntax error"),
+    ] {
+        let err = redis::cmd("LPOS")
+            .arg("list")
+            .arg(args)
+            .query::<Value>(con)
+            .unwrap_err();
+        assert_eq!(err.detail(), Some(expected));
+    }
+
+    let _: () = con.set("string", "a").unwrap();
+    let err = redis::cmd("LPOS")
+        .arg(&["string", "a"])
+        .query::<Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Operation against a key holding the wrong kind of value")
+    );
+}
@@ -0,0 +1,92 @@
+use redis::Commands;
+use std::collections::HashMap;
+
+mod utils;
+
+// Reply to MEMORY USAGE for the key, with the given options
+fn usage(con: &mut redis::Connection, key: &str, options: &[&str]) -> Option<usize> {
+    redis::cmd("MEMORY")
+        .arg(&["USAGE", key])
+        .arg(options)
+        .query(con)
+        .unwrap()
+}
+
+#[test]
+fn test_memory_usage() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // The usage grows as the list gains elements
+    let _: usize = con.rpush("list", "first").unwrap();
+    let mut last_usage = usage(con, "list", &[]).unwrap();
+    for len in 2..=10 {
+        let _: usize = con.rpush("list", format!("element {len}")).unwrap();
+        let list_usage = usage(con, "list", &["SAMPLES", "0"]).unwrap();
+        assert!(list_usage > last_usage, "{list_usage} <= {last_usage}");
+        last_usage = list_usage;
+    }
+
+    // Sampling the elements estimates the others from them
+    let _: usize = con.rpush("same", &["x"; 100]).unwrap();
+    assert_eq!(
+        usage(con, "same", &[]),
+        usage(con, "same", &["SAMPLES", "0"])
+    );
+    let _: () = con.set("string", "value").unwrap();
+    let _: () = con.set("longer", "value".repeat(10)).unwrap();
+    assert!(usage(con, "longer", &[]).unwrap() > usage(con, "string", &[]).unwrap());
+    assert_eq!(usage(con, "missing", &[]), None);
+
+    let err = redis::cmd("MEMORY")
+        .arg(&["USAGE", "list", "SAMPLES"])
+        .query::<Option<usize>>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let err = redis::cmd("MEMORY")
+        .arg(&["USAGE", "list", "SAMPLES", "many"])
+        .query::<Option<usize>>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("value is not an integer or out of range")
+    );
+    let err = redis::cmd("MEMORY")
+        .arg("USAGE")
+        .query::<Option<usize>>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'memory|usage' command")
+    );
+}
+
+#[test]
+fn test_memory_stats_and_doctor() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("first", "value").unwrap();
+    let _: usize = con.rpush("second", &["a", "b", "c"]).unwrap();
+
+    let stats: HashMap<String, redis::Value> =
+        redis::cmd("MEMORY").arg("STATS").query(con).unwrap();
+    let stat = |name: &str| -> usize { redis::from_redis_value(&stats[name]).unwrap() };
+    assert_eq!(stat("keys.count"), 2);
+    let total = usage(con, "first", &[]).unwrap() + usage(con, "second", &[]).unwrap();
+    assert_eq!(stat("total.allocated"), total);
+    assert_eq!(stat("keys.bytes-per-key"), total / 2);
+    assert_eq!(stat("dataset.bytes") + stat("overhead.total"), total);
+
+    // There is too little data to find any issue
+    let report: String = redis::cmd("MEMORY").arg("DOCTOR").query(con).unwrap();
+    assert!(report.contains("using very little memory"), "{report}");
+
+    let err = redis::cmd("MEMORY")
+        .arg("PURGE")
+        .query::<String>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("unknown subcommand 'PURGE'. Try MEMORY HELP.")
+    );
+}
@@ -0,0 +1,229 @@
+use redis::Commands;
+use std::{
+    thread,
+    time::{Duration, Instant},
+};
+
+mod utils;
+
+type ListPop = Option<(String, Vec<String>)>;
+type SortedSetPop = Option<(String, Vec<(String, f64)>)>;
+
+#[test]
+fn test_lmpop() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: usize = con.rpush("list1", &["a", "b", "c"]).unwrap();
+    let _: usize = con.rpush("list2", &["d", "e"]).unwrap();
+
+    // The first non-empty list in the order of the keys is popped from
+    let popped: ListPop = redis::cmd("LMPOP")
+        .arg(&["3", "missing", "list2", "list1", "LEFT"])
+        .query(con)
+        .unwrap();
+    assert_eq!(popped, Some(("list2".to_string(), vec!["d".to_string()])));
+    let popped: ListPop = redis::cmd("LMPOP")
+        .arg(&["2", "list1", "list2", "RIGHT", "COUNT", "2"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        popped,
+        Some(("list1".to_string(), vec!["c".to_string(), "b".to_string()]))
+    );
+
+    // A count larger than the list pops all of it, and the key is removed along with its last element
+    let popped: ListPop = redis::cmd("LMPOP")
+        .arg(&["1", "list2", "left", "count", "10"])
+        .query(con)
+        .unwrap();
+    assert_eq!(popped, Some(("list2".to_string(), vec!["e".to_string()])));
+    let exists: bool = con.exists("list2").unwrap();
+    assert!(!exists);
+    let popped: ListPop = redis::cmd("LMPOP")
+        .arg(&["2", "missing", "list2", "LEFT"])
+        .query(con)
+        .unwrap();
+    assert_eq!(popped, None);
+
+    let _: () = con.set("string", "value").unwrap();
+    let cases: &[(&[&str], &str)] = &[
+        (&["0", "list1", "LEFT"], "numkeys should be greater than 0"),
+        (&["x", "list1", "LEFT"], "numkeys should be greater than 0"),
+        (&["3", "list1", "LEFT"], "syntax error"),
+        (&["1", "list1", "UP"], "syntax error"),
+        (&["1", "list1", "LEFT", "COUNT"], "syntax error"),
+        (
+            &["1", "list1", "LEFT", "COUNT", "0"],
+            "count should be greater than 0",
+        ),
+        (
+            &["1", "list1", "LEFT", "COUNT", "1", "COUNT", "1"],
+            "syntax error",
+        ),
+    ];
+    for &(args, detail) in cases {
+        let err = redis::cmd("LMPOP")
+            .arg(args)
+            .query::<ListPop>(con)
+            .unwrap_err();
+        assert_eq!(err.detail(), Some(detail), "{args:?}");
+    }
+    let err = redis::cmd("LMPOP")
+        .arg(&["2", "string", "list1", "LEFT"])
+        .query::<ListPop>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = redis::cmd("LMPOP")
+        .arg(&["1", "list1"])
+        .query::<ListPop>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'lmpop' command")
+    );
+}
+
+#[test]
+fn test_zmpop() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: usize = con
+        .zadd_multiple("zset1", &[(1, "one"), (2, "two"), (3, "three")])
+        .unwrap();
+    let _: usize = con.zadd("zset2", "ten", 10).unwrap();
+
+    let popped: SortedSetPop = redis::cmd("ZMPOP")
+        .arg(&["2", "missing", "zset1", "MIN"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        popped,
+        Some(("zset1".to_string(), vec![("one".to_string(), 1.0)]))
+    );
+    let popped: SortedSetPop = redis::cmd("ZMPOP")
+        .arg(&["2", "zset1", "zset2", "MAX", "COUNT", "5"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        popped,
+        Some((
+            "zset1".to_string(),
+            vec![("three".to_string(), 3.0), ("two".to_string(), 2.0)]
+        ))
+    );
+    let popped: SortedSetPop = redis::cmd("ZMPOP")
+        .arg(&["2", "zset1", "zset2", "MAX", "COUNT", "5"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        popped,
+        Some(("zset2".to_string(), vec![("ten".to_string(), 10.0)]))
+    );
+    let popped: SortedSetPop = redis::cmd("ZMPOP")
+        .arg(&["2", "zset1", "zset2", "MIN"])
+        .query(con)
+        .unwrap();
+    assert_eq!(popped, None);
+
+    let err = redis::cmd("ZMPOP")
+        .arg(&["1", "zset1", "LEFT"])
+        .query::<SortedSetPop>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
+
+#[test]
+fn test_blmpop_bzmpop_available() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: usize = con.rpush("list", &["a", "b", "c"]).unwrap();
+    let _: usize = con
+        .zadd_multiple("zset", &[(1, "one"), (2, "two")])
+        .unwrap();
+
+    let popped: ListPop = redis::cmd("BLMPOP")
+        .arg(&["0", "2", "empty", "list", "RIGHT", "COUNT", "2"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        popped,
+        Some(("list".to_string(), vec!["c".to_string(), "b".to_string()]))
+    );
+    let popped: SortedSetPop = redis::cmd("BZMPOP")
+        .arg(&["0", "1", "zset", "MIN"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        popped,
+        Some(("zset".to_string(), vec![("one".to_string(), 1.0)]))
+    );
+
+    let start = Instant::now();
+    let popped: ListPop = redis::cmd("BLMPOP")
+        .arg(&["0.2", "1", "empty", "LEFT"])
+        .query(con)
+        .unwrap();
+    assert_eq!(popped, None);
+    assert!(start.elapsed() >= Duration::from_millis(200));
+
+    let err = redis::cmd("BZMPOP")
+        .arg(&["-1", "1", "zset", "MIN"])
+        .query::<SortedSetPop>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("timeout is negative"));
+
+    // Inside a transaction the commands don't block
+    let replies: (ListPop, SortedSetPop) = redis::pipe()
+        .atomic()
+        .cmd("BLMPOP")
+        .arg(&["0", "1", "empty", "LEFT"])
+        .cmd("BZMPOP")
+        .arg(&["0", "1", "empty", "MAX"])
+        .query(con)
+        .unwrap();
+    assert_eq!(replies, (None, None));
+}
+
+#[test]
+fn test_blmpop_bzmpop_woken_by_push() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let mut blocked_con = utils::get_connection(&test_server.port);
+    let blocked = thread::spawn(move || {
+        redis::cmd("BLMPOP")
+            .arg(&["0", "2", "list1", "list2", "LEFT", "COUNT", "2"])
+            .query::<ListPop>(&mut blocked_con)
+            .unwrap()
+    });
+    thread::sleep(Duration::from_millis(200));
+    // The client is served up to the count from the list which got pushed to
+    let _: usize = con.rpush("list2", &["a", "b", "c"]).unwrap();
+    assert_eq!(
+        blocked.join().unwrap(),
+        Some(("list2".to_string(), vec!["a".to_string(), "b".to_string()]))
+    );
+    let list: Vec<String> = con.lrange("list2", 0, -1).unwrap();
+    assert_eq!(list, ["c"]);
+
+    let mut blocked_con = utils::get_connection(&test_server.port);
+    let blocked = thread::spawn(move || {
+        redis::cmd("BZMPOP")
+            .arg(&["0", "1", "zset", "MAX", "COUNT", "5"])
+            .query::<SortedSetPop>(&mut blocked_con)
+            .unwrap()
+    });
+    thread::sleep(Duration::from_millis(200));
+    let _: usize = con
+        .zadd_multiple("zset", &[(1, "one"), (2, "two")])
+        .unwrap();
+    assert_eq!(
+        blocked.join().unwrap(),
+        Some((
+            "zset".to_string(),
+            vec![("two".to_string(), 2.0), ("one".to_string(), 1.0)]
+        ))
+    );
+    let exists: bool = con.exists("zset").unwrap();
+    assert!(!exists);
+}
@@ -0,0 +1,157 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+#[test]
+fn test_setex_psetex() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = redis::cmd("SETEX")
+        .arg(&["foo", "100", "bar"])
+        .query(con)
+        .unwrap();
+    let get_result: String = con.get("foo").unwrap();
+    assert_eq!(get_result, "bar");
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert_eq!(ttl, 100);
+    let _: () = redis::cmd("PSETEX")
+        .arg(&["short", "100", "lived"])
+        .query(con)
+        .unwrap();
+    let pttl: i64 = con.pttl("short").unwrap();
+    assert!((90..=100).contains(&pttl), "{pttl}");
+    thread::sleep(Duration::from_millis(150));
+    let get_result: Option<String> = con.get("short").unwrap();
+    assert_eq!(get_result, None);
+
+    // The TTL must be positive, and the key is left as it is otherwise
+    for (name, ttl) in [("SETEX", "0"), ("SETEX", "-5"), ("PSETEX", "0")] {
+        let err = redis::cmd(name)
+            .arg(&["foo", ttl, "new"])
+            .query::<()>(con)
+            .unwrap_err();
+        assert_eq!(
+            err.detail(),
+            Some(format!("invalid expire time in '{}' command", name.to_lowercase()).as_str())
+        );
+    }
+    let err = redis::cmd("SETEX")
+        .arg(&["foo", "ten", "new"])
+        .query::<()>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("value is not an integer or out of range")
+    );
+    let get_result: String = con.get("foo").unwrap();
+    assert_eq!(get_result, "bar");
+}
+
+#[test]
+fn test_setnx() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let setnx_result: bool = redis::cmd("SETNX").arg(&["foo", "bar"]).query(con).unwrap();
+    assert!(setnx_result);
+    let setnx_result: bool = redis::cmd("SETNX").arg(&["foo", "baz"]).query(con).unwrap();
+    assert!(!setnx_result);
+    let get_result: String = con.get("foo").unwrap();
+    assert_eq!(get_result, "bar");
+    // Any type counts as existing
+    let _: usize = con.rpush("list", "a").unwrap();
+    let setnx_result: bool = redis::cmd("SETNX")
+        .arg(&["list", "baz"])
+        .query(con)
+        .unwrap();
+    assert!(!setnx_result);
+}
+
+#[test]
+fn test_mset_mget() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = redis::cmd("SET")
+        .arg(&["a", "old", "EX", "100"])
+        .query(con)
+        .unwrap();
+    let _: () = redis::cmd("MSET")
+        .arg(&["a", "1", "b", "2", "c", "3"])
+        .query(con)
+        .unwrap();
+    let _: usize = con.rpush("list", "a").unwrap();
+    let mget_result: Vec<Option<String>> = redis::cmd("MGET")
+        .arg(&["a", "missing", "b", "list", "c"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        mget_result,
+        [
+            Some("1".to_string()),
+            None,
+            Some("2".to_string()),
+            None,
+            Some("3".to_string())
+        ]
+    );
+    // MSET removes the TTL, same as SET
+    let ttl: i64 = con.ttl("a").unwrap();
+    assert_eq!(ttl, -1);
+    // The last value of a key given twice wins
+    let _: () = redis::cmd("MSET")
+        .arg(&["d", "1", "d", "2"])
+        .query(con)
+        .unwrap();
+    let get_result: String = con.get("d").unwrap();
+    assert_eq!(get_result, "2");
+
+    let err = redis::cmd("MSET")
+        .arg(&["a", "1", "b"])
+        .query::<()>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'mset' command")
+    );
+}
+
+#[test]
+fn test_msetnx() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let msetnx_result: bool = redis::cmd("MSETNX")
+        .arg(&["a", "1", "b", "2"])
+        .query(con)
+        .unwrap();
+    assert!(msetnx_result);
+
+    // A single existing key leaves the keyspace untouched
+    let msetnx_result: bool = redis::cmd("MSETNX")
+        .arg(&["c", "3", "a", "new", "d", "4"])
+        .query(con)
+        .unwrap();
+    assert!(!msetnx_result);
+    let mget_result: Vec<Option<String>> = redis::cmd("MGET")
+        .arg(&["a", "b", "c", "d"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        mget_result,
+        [Some("1".to_string()), Some("2".to_string()), None, None]
+    );
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 2);
+
+    let err = redis::cmd("MSETNX")
+        .arg(&["c", "3", "d"])
+        .query::<()>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'msetnx' command")
+    );
+}
@@ -0,0 +1,282 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+// Reply to OBJECT ENCODING for the key
+fn encoding(con: &mut redis::Connection, key: &str) -> Option<String> {
+    redis::cmd("OBJECT")
+        .arg(&["ENCODING", key])
+        .query(con)
+        .unwrap()
+}
+
+#[test]
+fn test_string_encodings() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for (val, expected) in [
+        ("12345", "int"),
+        ("-9223372036854775808", "int"),
+        ("0123", "embstr"),
+        ("hello", "embstr"),
+        (&"x".repeat(44), "embstr"),
+        (&"x".repeat(45), "raw"),
+    ] {
+        let _: () = con.set("key", val).unwrap();
+        assert_eq!(encoding(con, "key").as_deref(), Some(expected), "{val}");
+    }
+    let _: () = con.set("counter", "abc").unwrap();
+    let _: () = con.set("counter", "1").unwrap();
+    let _: i64 = con.incr("counter", 10).unwrap();
+    assert_eq!(encoding(con, "counter").as_deref(), Some("int"));
+    assert_eq!(encoding(con, "missing"), None);
+}
+
+#[test]
+fn test_hash_and_zset_encodings() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.hset("hash", "field", "value").unwrap();
+    assert_eq!(encoding(con, "hash").as_deref(), Some("listpack"));
+    let fields: Vec<(String, u32)> = (0..128).map(|i| (format!("field:{i}"), i)).collect();
+    let _: usize = redis::cmd("HSET")
+        .arg("hash")
+        .arg(&fields)
+        .query(con)
+        .unwrap();
+    assert_eq!(encoding(con, "hash").as_deref(), Some("hashtable"));
+    // A hash is never converted back
+    let removed: Vec<String> = fields.into_iter().map(|(field, _)| field).collect();
+    let _: usize = con.hdel("hash", &removed).unwrap();
+    assert_eq!(encoding(con, "hash").as_deref(), Some("hashtable"));
+
+    let _: usize = con.hset("long", "field", "x".repeat(65)).unwrap();
+    assert_eq!(encoding(con, "long").as_deref(), Some("hashtable"));
+
+    let _: usize = con.zadd("zset", "member", 1).unwrap();
+    assert_eq!(encoding(con, "zset").as_deref(), Some("listpack"));
+    for i in 0..128 {
+        let _: usize = con.zadd("zset", format!("member:{i}"), i).unwrap();
+    }
+    assert_eq!(encoding(con, "zset").as_deref(), Some("skiplist"));
+    let _: usize = con.zadd("long-zset", "x".repeat(65), 1).unwrap();
+    assert_eq!(encoding(con, "long-zset").as_deref(), Some("skiplist"));
+
+    // The limits are configurable
+    let _: () = redis::cmd("CONFIG")
+        .arg(&[
+            "SET",
+            "hash-max-listpack-entries",
+            "2",
+            "zset-max-listpack-value",
+            "3",
+        ])
+        .query(con)
+        .unwrap();
+    let _: usize = con.hset("small", "a", "1").unwrap();
+    let _: usize = con.hset("small", "b", "2").unwrap();
+    assert_eq!(encoding(con, "small").as_deref(), Some("listpack"));
+    let _: usize = con.hset("small", "c", "3").unwrap();
+    assert_eq!(encoding(con, "small").as_deref(), Some("hashtable"));
+    let _: usize = con.zadd("short", "abcd", 1).unwrap();
+    assert_eq!(encoding(con, "short").as_deref(), Some("skiplist"));
+}
+
+#[test]
+fn test_list_encodings() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // By default a listpack holds up to 8 KB
+    let _: usize = con.rpush("list", &["a", "b"]).unwrap();
+    assert_eq!(encoding(con, "list").as_deref(), Some("listpack"));
+    let _: usize = con.rpush("list", "x".repeat(9000)).unwrap();
+    assert_eq!(encoding(con, "list").as_deref(), Some("quicklist"));
+
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "list-max-listpack-size", "4"])
+        .query(con)
+        .unwrap();
+    let _: usize = con.rpush("counted", &["1", "2", "3", "4"]).unwrap();
+    assert_eq!(encoding(con, "counted").as_deref(), Some("listpack"));
+    let _: usize = con.rpush("counted", "5").unwrap();
+    assert_eq!(encoding(con, "counted").as_deref(), Some("quicklist"));
+    // A list stays a quicklist however much it shrinks
+    let _: Vec<String> = con.lpop("counted", std::num::NonZeroUsize::new(4)).unwrap();
+    assert_eq!(encoding(con, "counted").as_deref(), Some("quicklist"));
+    let _: usize = con.rpush("counted", "1").unwrap();
+    assert_eq!(encoding(con, "counted").as_deref(), Some("quicklist"));
+}
+
+#[test]
+fn test_list_encodings_at_the_limits() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "list-max-listpack-size", "128"])
+        .query(con)
+        .unwrap();
+    let elements: Vec<String> = (0..128).map(|i| i.to_string()).collect();
+    let _: usize = con.rpush("list", &elements).unwrap();
+    assert_eq!(encoding(con, "list").as_deref(), Some("listpack"));
+    let _: usize = con.rpush("list", "128").unwrap();
+    assert_eq!(encoding(con, "list").as_deref(), Some("quicklist"));
+
+    // An element longer than the packed threshold converts the list
+    let _: () = redis::cmd("DEBUG")
+        .arg(&["QUICKLIST-PACKED-THRESHOLD", "64b"])
+        .query(con)
+        .unwrap();
+    let _: usize = con.rpush("long", "x".repeat(64)).unwrap();
+    assert_eq!(encoding(con, "long").as_deref(), Some("listpack"));
+    let _: usize = con.rpush("long", "x".repeat(65)).unwrap();
+    assert_eq!(encoding(con, "long").as_deref(), Some("quicklist"));
+    let _: usize = con.lpush("created", "x".repeat(65)).unwrap();
+    assert_eq!(encoding(con, "created").as_deref(), Some("quicklist"));
+
+    for threshold in ["0", "4gb", "foo"] {
+        let err = redis::cmd("DEBUG")
+            .arg(&["QUICKLIST-PACKED-THRESHOLD", threshold])
+            .query::<()>(con)
+            .unwrap_err();
+        assert_eq!(
+            err.detail(),
+            Some("argument must be a memory value bigger than 1 and smaller than 4gb")
+        );
+    }
+}
+
+#[test]
+fn test_object_subcommands() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("foo", "bar").unwrap();
+
+    let refcount: i64 = redis::cmd("OBJECT")
+        .arg(&["REFCOUNT", "foo"])
+        .query(con)
+        .unwrap();
+    assert_eq!(refcount, 1);
+    let idletime: i64 = redis::cmd("OBJECT")
+        .arg(&["IDLETIME", "foo"])
+        .query(con)
+        .unwrap();
+    assert_eq!(idletime, 0);
+    let refcount: Option<i64> = redis::cmd("OBJECT")
+        .arg(&["REFCOUNT", "missing"])
+        .query(con)
+        .unwrap();
+    assert_eq!(refcount, None);
+
+    let err = redis::cmd("OBJECT")
+        .arg(&["SIZE", "foo"])
+        .query::<redis::Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("unknown subcommand 'SIZE'. Try OBJECT HELP.")
+    );
+    let err = redis::cmd("OBJECT")
+        .arg(&["ENCODING"])
+        .query::<redis::Value>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+}
+
+#[test]
+fn test_object_help() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let lines: Vec<String> = redis::cmd("OBJECT").arg("HELP").query(con).unwrap();
+    assert_eq!(
+        lines.first().map(String::as_str),
+        Some("OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:")
+    );
+    assert!(lines.iter().any(|line| line == "ENCODING <key>"));
+    assert_eq!(lines[lines.len() - 2..], ["HELP", "    Print this help."]);
+
+    let err = redis::cmd("OBJECT")
+        .arg(&["HELP", "foo"])
+        .query::<redis::Value>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'object|help' command")
+    );
+}
+
+#[test]
+fn test_object_idletime_and_freq() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("foo", "bar").unwrap();
+    let _: () = con.set("bar", "foo").unwrap();
+
+    // OBJECT itself isn't an access of the key
+    thread::sleep(Duration::from_millis(1100));
+    let _: String = con.get("bar").unwrap();
+    for _ in 0..2 {
+        let idletime: i64 = redis::cmd("OBJECT")
+            .arg(&["IDLETIME", "foo"])
+            .query(con)
+            .unwrap();
+        assert_eq!(idletime, 1);
+    }
+    let idletime: i64 = redis::cmd("OBJECT")
+        .arg(&["IDLETIME", "bar"])
+        .query(con)
+        .unwrap();
+    assert_eq!(idletime, 0);
+    let err = redis::cmd("OBJECT")
+        .arg(&["FREQ", "foo"])
+        .query::<redis::Value>(con)
+        .unwrap_err();
+    assert!(err
+        .detail()
+        .unwrap()
+        .starts_with("An LFU maxmemory policy is not selected"));
+
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "maxmemory-policy", "allkeys-lfu"])
+        .query(con)
+        .unwrap();
+    let _: () = con.set("counted", "val").unwrap();
+    let freq: i64 = redis::cmd("OBJECT")
+        .arg(&["FREQ", "counted"])
+        .query(con)
+        .unwrap();
+    assert_eq!(freq, 5);
+    let _: String = con.get("counted").unwrap();
+    let freq: i64 = redis::cmd("OBJECT")
+        .arg(&["FREQ", "counted"])
+        .query(con)
+        .unwrap();
+    assert_eq!(freq, 6);
+    // The frequency grows ever more slowly
+    for _ in 0..100 {
+        let _: String = con.get("counted").unwrap();
+    }
+    let freq: i64 = redis::cmd("OBJECT")
+        .arg(&["FREQ", "counted"])
+        .query(con)
+        .unwrap();
+    assert!((6..20).contains(&freq), "{freq}");
+    let freq: Option<i64> = redis::cmd("OBJECT")
+        .arg(&["FREQ", "missing"])
+        .query(con)
+        .unwrap();
+    assert_eq!(freq, None);
+    let err = redis::cmd("OBJECT")
+        .arg(&["IDLETIME", "foo"])
+        .query::<redis::Value>(con)
+        .unwrap_err();
+    assert!(err
+        .detail()
+        .unwrap()
+        .starts_with("An LFU maxmemory policy is selected"));
+}
@@ -0,0 +1,81 @@
+use redis::Commands;
+use std::{collections::HashSet, num::NonZero, thread, time::Duration};
+
+mod utils;
+
+fn randomkey(con: &mut redis::Connection) -> Option<String> {
+    redis::cmd("RANDOMKEY").query(con).unwrap()
+}
+
+#[test]
+fn test_dbsize() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    assert_eq!(utils::dbsize(con), 0);
+
+    let _: () = con.set("string", "value").unwrap();
+    let _: usize = con.rpush("list", &["a", "b"]).unwrap();
+    let _: () = redis::cmd("SET")
+        .arg(&["volatile", "value", "PX", "100"])
+        .query(con)
+        .unwrap();
+    assert_eq!(utils::dbsize(con), 3);
+    let _: usize = con.del("string").unwrap();
+    assert_eq!(utils::dbsize(con), 2);
+    // Popping the last element removes the list
+    let _: Vec<String> = con.lpop("list", NonZero::new(2)).unwrap();
+    assert_eq!(utils::dbsize(con), 1);
+
+    // An expired key doesn't count even before it is removed
+    let _: () = redis::cmd("DEBUG")
+        .arg(&["SET-ACTIVE-EXPIRE", "0"])
+        .query(con)
+        .unwrap();
+    thread::sleep(Duration::from_millis(200));
+    assert_eq!(utils::dbsize(con), 0);
+
+    // Only the keys of the selected database count
+    let _: () = con.set("other", "value").unwrap();
+    let _: () = redis::cmd("SELECT").arg(1).query(con).unwrap();
+    assert_eq!(utils::dbsize(con), 0);
+}
+
+#[test]
+fn test_randomkey() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    assert_eq!(randomkey(con), None);
+
+    for key in ["a", "b", "c"] {
+        let _: () = con.set(key, "value").unwrap();
+    }
+    let mut seen = HashSet::new();
+    for _ in 0..100 {
+        seen.insert(randomkey(con).unwrap());
+    }
+    assert_eq!(seen, HashSet::from(["a", "b", "c"].map(str::to_owned)));
+
+    // Expired keys are never picked, and are removed once picked
+    let _: () = redis::cmd("DEBUG")
+        .arg(&["SET-ACTIVE-EXPIRE", "0"])
+        .query(con)
+        .unwrap();
+    for key in ["x", "y"] {
+        let _: () = redis::cmd("SET")
+            .arg(&[key, "value", "PX", "50"])
+            .query(con)
+            .unwrap();
+    }
+    thread::sleep(Duration::from_millis(100));
+    for _ in 0..100 {
+        let key = randomkey(con).unwrap();
+        assert!(["a", "b", "c"].contains(&key.as_str()), "{key}");
+    }
+    let info: String = redis::cmd("INFO").arg("keyspace").query(con).unwrap();
+    assert!(info.contains("db0:keys=3,expires=0"), "{info}");
+
+    let _: usize = con.del(&["a", "b", "c"]).unwrap();
+    assert_eq!(randomkey(con), None);
+    let _: () = redis::cmd("SELECT").arg(1).query(con).unwrap();
+    assert_eq!(randomkey(con), None);
+}
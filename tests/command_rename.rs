@@ -0,0 +1,115 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+fn rename(con: &mut redis::Connection, key: &str, new_key: &str) -> redis::RedisResult<()> {
+    redis::cmd("RENAME").arg(&[key, new_key]).query(con)
+}
+
+fn renamenx(con: &mut redis::Connection, key: &str, new_key: &str) -> bool {
+    redis::cmd("RENAMENX")
+        .arg(&[key, new_key])
+        .query(con)
+        .unwrap()
+}
+
+#[test]
+fn test_rename() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "bar", "EX", "100"])
+        .query(con)
+        .unwrap();
+    rename(con, "foo", "baz").unwrap();
+    let exists_result: bool = con.exists("foo").unwrap();
+    assert!(!exists_result);
+    let get_result: String = con.get("baz").unwrap();
+    assert_eq!(get_result, "bar");
+    // The TTL moves along with the value
+    let ttl: i64 = con.ttl("baz").unwrap();
+    assert!((99..=100).contains(&ttl), "{ttl}");
+
+    // An existing new key is overwritten, along with its TTL and type
+    let _: usize = con.rpush("list", &["a", "b"]).unwrap();
+    rename(con, "list", "baz").unwrap();
+    let ttl: i64 = con.ttl("baz").unwrap();
+    assert_eq!(ttl, -1);
+    let lrange_result: Vec<String> = con.lrange("baz", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["a", "b"]);
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 1);
+
+    // Renaming a key to itself leaves it as it is
+    rename(con, "baz", "baz").unwrap();
+    let lrange_result: Vec<String> = con.lrange("baz", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["a", "b"]);
+
+    let err = rename(con, "missing", "other").unwrap_err();
+    assert_eq!(err.detail(), Some("no such key"));
+    let exists_result: bool = con.exists("other").unwrap();
+    assert!(!exists_result);
+}
+
+#[test]
+fn test_renamenx() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "bar", "EX", "100"])
+        .query(con)
+        .unwrap();
+    let _: usize = con.hset("hash", "field", "value").unwrap();
+
+    // An existing new key is left as it is, and so is the key
+    assert!(!renamenx(con, "foo", "hash"));
+    let get_result: String = con.get("foo").unwrap();
+    assert_eq!(get_result, "bar");
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert!((99..=100).contains(&ttl), "{ttl}");
+    let hget_result: String = con.hget("hash", "field").unwrap();
+    assert_eq!(hget_result, "value");
+    assert!(!renamenx(con, "foo", "foo"));
+
+    assert!(renamenx(con, "foo", "new"));
+    let get_result: String = con.get("new").unwrap();
+    assert_eq!(get_result, "bar");
+    let ttl: i64 = con.ttl("new").unwrap();
+    assert!((99..=100).contains(&ttl), "{ttl}");
+    let exists_result: bool = con.exists("foo").unwrap();
+    assert!(!exists_result);
+
+    let err = redis::cmd("RENAMENX")
+        .arg(&["missing", "other"])
+        .query::<bool>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("no such key"));
+}
+
+#[test]
+fn test_rename_serves_blocked_clients() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let mut blocked_con = utils::get_connection(&test_server.port);
+    let blocked = thread::spawn(move || {
+        let popped: Option<(String, String)> = redis::cmd("BLPOP")
+            .arg(&["target", "0"])
+            .query(&mut blocked_con)
+            .unwrap();
+        popped
+    });
+    thread::sleep(Duration::from_millis(100));
+
+    let _: usize = con.rpush("source", &["a", "b"]).unwrap();
+    rename(con, "source", "target").unwrap();
+    assert_eq!(
+        blocked.join().unwrap(),
+        Some(("target".to_string(), "a".to_string()))
+    );
+    let lrange_result: Vec<String> = con.lrange("target", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["b"]);
+}
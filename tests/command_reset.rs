@@ -0,0 +1,83 @@
+use redis::{Commands, Value};
+
+mod utils;
+
+fn reset(con: &mut redis::Connection) {
+    let reply: String = redis::cmd("RESET").query(con).unwrap();
+    assert_eq!(reply, "RESET");
+}
+
+#[test]
+fn test_reset_connection_state() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = redis::cmd("SELECT").arg(2).query(con).unwrap();
+    let _: () = con.set("foo", "in db 2").unwrap();
+    let _: () = redis::cmd("CLIENT")
+        .arg(&["SETNAME", "pooled"])
+        .query(con)
+        .unwrap();
+    let _: () = redis::cmd("CLIENT")
+        .arg(&["NO-EVICT", "on"])
+        .query(con)
+        .unwrap();
+    let _: Value = redis::cmd("HELLO").arg(3).query(con).unwrap();
+    reset(con);
+
+    let got: Option<String> = con.get("foo").unwrap();
+    assert_eq!(got, None);
+    let name: Option<String> = redis::cmd("CLIENT").arg("GETNAME").query(con).unwrap();
+    assert_eq!(name, None);
+    let list: String = redis::cmd("CLIENT").arg("LIST").query(con).unwrap();
+    assert!(list.contains(" flags=N db=0 "), "{list}");
+    assert!(list.ends_with(" resp=2\n"), "{list}");
+}
+
+#[test]
+fn test_reset_transaction() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let mut other_con = utils::get_connection(&test_server.port);
+    let con = &mut test_server.connection;
+
+    // The open transaction is discarded
+    let _: () = redis::cmd("MULTI").query(con).unwrap();
+    let _: () = redis::cmd("SET").arg(&["foo", "bar"]).query(con).unwrap();
+    reset(con);
+    let err = redis::cmd("EXEC").query::<Value>(con).unwrap_err();
+    assert_eq!(err.detail(), Some("EXEC without MULTI"));
+    let got: Option<String> = con.get("foo").unwrap();
+    assert_eq!(got, None);
+
+    // The watched keys are forgotten
+    let _: () = redis::cmd("WATCH").arg("foo").query(con).unwrap();
+    reset(con);
+    let _: () = other_con.set("foo", "baz").unwrap();
+    let replies: Option<(String,)> = redis::pipe()
+        .atomic()
+        .cmd("SET")
+        .arg(&["foo", "bar"])
+        .query(con)
+        .unwrap();
+    assert_eq!(replies, Some(("OK".to_owned(),)));
+}
+
+#[test]
+fn test_reset_subscriber() {
+    let test_server = utils::start_server_and_get_connection();
+    let reply = utils::send_raw(
+        &test_server.port,
+        &[b"SUBSCRIBE a\r\nPSUBSCRIBE b*\r\nRESET\r\nGET foo\r\nPUBLISH a hello\r\n"],
+    );
+    assert_eq!(
+        String::from_utf8(reply).unwrap(),
+        concat!(
+            "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n",
+            "*3\r\n$10\r\npsubscribe\r\n$2\r\nb*\r\n:2\r\n",
+            "+RESET\r\n",
+            "$-1\r\n",
+            // Nobody is subscribed anymore
+            ":0\r\n",
+        )
+    );
+}
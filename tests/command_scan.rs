@@ -0,0 +1,201 @@
+use redis::Commands;
+use std::{
+    collections::{HashMap, HashSet},
+    thread,
+    time::Duration,
+};
+
+mod utils;
+
+// Send a SCAN command, returning the next cursor and the batch of elements
+fn scan(
+    con: &mut redis::Connection,
+    command: &str,
+    args: &[&str],
+) -> redis::RedisResult<(String, Vec<String>)> {
+    redis::cmd(command).arg(args).query(con)
+}
+
+// Iterate until the cursor is 0 again, calling `between_calls` after every call
+fn scan_all(
+    con: &mut redis::Connection,
+    command: &str,
+    key: Option<&str>,
+    options: &[&str],
+    mut between_calls: impl FnMut(&mut redis::Connection),
+) -> Vec<String> {
+    let mut elements = Vec::new();
+    let mut cursor = "0".to_string();
+    loop {
+        let mut args: Vec<&str> = key.into_iter().collect();
+        args.push(&cursor);
+        args.extend_from_slice(options);
+        let (next_cursor, batch) = scan(con, command, &args).unwrap();
+        elements.extend(batch);
+        between_calls(con);
+        if next_cursor == "0" {
+            return elements;
+        }
+        cursor = next_cursor;
+    }
+}
+
+#[test]
+fn test_scan() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let (cursor, keys) = scan(con, "SCAN", &["0"]).unwrap();
+    assert_eq!(cursor, "0");
+    assert!(keys.is_empty());
+
+    for i in 0..500 {
+        let _: () = con.set(format!("key:{i}"), i).unwrap();
+    }
+    // Every key is returned exactly once
+    let keys = scan_all(con, "SCAN", None, &["COUNT", "7"], |_| {});
+    assert_eq!(keys.len(), 500);
+    let keys: HashSet<String> = keys.into_iter().collect();
+    assert_eq!(keys, (0..500).map(|i| format!("key:{i}")).collect());
+
+    // The keys which exist throughout the iteration are all returned, even as more keys are added
+    let mut added = 0;
+    let keys: HashSet<String> = scan_all(con, "SCAN", None, &[], |con| {
+        for _ in 0..20 {
+            let _: () = con.set(format!("added:{added}"), added).unwrap();
+            added += 1;
+        }
+    })
+    .into_iter()
+    .collect();
+    assert!((0..500).all(|i| keys.contains(&format!("key:{i}"))));
+}
+
+#[test]
+fn test_scan_options() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for i in 0..20 {
+        let _: () = con.set(format!("user:{i}"), i).unwrap();
+        let _: usize = con.rpush(format!("queue:{i}"), i).unwrap();
+    }
+    let _: usize = con.rpush("user:list", "x").unwrap();
+    let _: () = redis::cmd("SET")
+        .arg(&["user:gone", "x", "PX", "1"])
+        .query(con)
+        .unwrap();
+    thread::sleep(Duration::from_millis(10));
+
+    let mut keys = scan_all(
+        con,
+        "SCAN",
+        None,
+        &["MATCH", "user:1*", "COUNT", "3"],
+        |_| {},
+    );
+    keys.sort();
+    assert_eq!(
+        keys,
+        [
+            "user:1", "user:10", "user:11", "user:12", "user:13", "user:14", "user:15", "user:16",
+            "user:17", "user:18", "user:19"
+        ]
+    );
+    let keys = scan_all(
+        con,
+        "SCAN",
+        None,
+        &["MATCH", "user:*", "TYPE", "list"],
+        |_| {},
+    );
+    assert_eq!(keys, ["user:list"]);
+    let keys = scan_all(con, "SCAN", None, &["TYPE", "LIST"], |_| {});
+    assert_eq!(keys.len(), 21);
+    let keys = scan_all(con, "SCAN", None, &["TYPE", "hash"], |_| {});
+    assert!(keys.is_empty());
+
+    let err = scan(con, "SCAN", &["abc"]).unwrap_err();
+    assert_eq!(err.detail(), Some("invalid cursor"));
+    let err = scan(con, "SCAN", &["0", "COUNT", "0"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let err = scan(con, "SCAN", &["0", "COUNT", "many"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("value is not an integer or out of range")
+    );
+    let err = scan(con, "SCAN", &["0", "MATCH"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let err = scan(con, "SCAN", &["0", "NOVALUES"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
+
+#[test]
+fn test_hscan() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let fields: Vec<(String, String)> = (0..100)
+        .map(|i| (format!("field:{i}"), format!("value:{i}")))
+        .collect();
+    let _: usize = redis::cmd("HSET")
+        .arg("hash")
+        .arg(&fields)
+        .query(con)
+        .unwrap();
+
+    let elements = scan_all(con, "HSCAN", Some("hash"), &["COUNT", "9"], |_| {});
+    let pairs: HashMap<String, String> = elements
+        .chunks_exact(2)
+        .map(|pair| (pair[0].clone(), pair[1].clone()))
+        .collect();
+    assert_eq!(elements.len(), 200);
+    assert_eq!(pairs, fields.into_iter().collect());
+
+    let mut fields = scan_all(
+        con,
+        "HSCAN",
+        Some("hash"),
+        &["MATCH", "field:9?", "NOVALUES"],
+        |_| {},
+    );
+    fields.sort();
+    assert_eq!(
+        fields,
+        (90..100).map(|i| format!("field:{i}")).collect::<Vec<_>>()
+    );
+
+    let (cursor, elements) = scan(con, "HSCAN", &["missing", "0"]).unwrap();
+    assert_eq!(cursor, "0");
+    assert!(elements.is_empty());
+    let err = scan(con, "HSCAN", &["hash", "0", "TYPE", "hash"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
+
+#[test]
+fn test_zscan_and_sscan() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for i in 0..30 {
+        let _: usize = con
+            .zadd("zset", format!("member:{i}"), f64::from(i) + 0.5)
+            .unwrap();
+    }
+    let elements = scan_all(con, "ZSCAN", Some("zset"), &["COUNT", "4"], |_| {});
+    let scores: HashMap<String, String> = elements
+        .chunks_exact(2)
+        .map(|pair| (pair[0].clone(), pair[1].clone()))
+        .collect();
+    assert_eq!(scores.len(), 30);
+    assert_eq!(scores["member:3"], "3.5");
+
+    let _: () = con.set("string", "value").unwrap();
+    let err = scan(con, "ZSCAN", &["string", "0"]).unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = scan(con, "SSCAN", &["zset", "0"]).unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let (cursor, elements) = scan(con, "SSCAN", &["missing", "0"]).unwrap();
+    assert_eq!(cursor, "0");
+    assert!(elements.is_empty());
+}
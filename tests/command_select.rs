@@ -0,0 +1,363 @@
+use redis::Commands;
+use std::{fs, thread, time::Duration};
+
+mod utils;
+
+// Switch the database of the connection
+fn select(con: &mut redis::Connection, db: i64) {
+    let _: () = redis::cmd("SELECT").arg(db).query(con).unwrap();
+}
+
+#[test]
+fn test_select() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("foo", "zero").unwrap();
+
+    select(con, 1);
+    let val: Option<String> = con.get("foo").unwrap();
+    assert_eq!(val, None);
+    let _: () = con.set("foo", "one").unwrap();
+    let _: () = con.set("bar", "one").unwrap();
+    assert_eq!(utils::dbsize(con), 2);
+    let keys: Vec<String> = con.keys("*").unwrap();
+    assert_eq!(keys.len(), 2);
+    select(con, 0);
+    let val: String = con.get("foo").unwrap();
+    assert_eq!(val, "zero");
+    assert_eq!(utils::dbsize(con), 1);
+
+    // The database is selected per connection
+    let mut other_con = utils::get_connection(&test_server.port);
+    select(con, 1);
+    let val: String = other_con.get("foo").unwrap();
+    assert_eq!(val, "zero");
+
+    // The last of the 16 databases is 15
+    select(con, 15);
+    assert_eq!(utils::dbsize(con), 0);
+    let err = redis::cmd("SELECT").arg(16).query::<()>(con).unwrap_err();
+    assert_eq!(err.detail(), Some("DB index is out of range"));
+    let err = redis::cmd("SELECT").arg(-1).query::<()>(con).unwrap_err();
+    assert_eq!(err.detail(), Some("DB index is out of range"));
+    let err = redis::cmd("SELECT")
+        .arg("one")
+        .query::<()>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("value is not an integer or out of range")
+    );
+    // A failed SELECT leaves the database as it was
+    assert_eq!(utils::dbsize(con), 0);
+}
+
+#[test]
+fn test_select_in_transaction() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let exec_result: (String, String, String) = redis::pipe()
+        .atomic()
+        .cmd("SELECT")
+        .arg(2)
+        .cmd("SET")
+        .arg(&["foo", "bar"])
+        .cmd("SELECT")
+        .arg(3)
+        .query(con)
+        .unwrap();
+    assert_eq!(exec_result.1, "OK");
+    // The SELECT inside the transaction sticks
+    assert_eq!(utils::dbsize(con), 0);
+    select(con, 2);
+    let val: String = con.get("foo").unwrap();
+    assert_eq!(val, "bar");
+}
+
+#[test]
+fn test_move() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "bar", "EX", "100"])
+        .query(con)
+        .unwrap();
+
+    let moved: i64 = redis::cmd("MOVE").arg(&["foo", "1"]).query(con).unwrap();
+    assert_eq!(moved, 1);
+    assert_eq!(utils::dbsize(con), 0);
+    select(con, 1);
+    let val: String = con.get("foo").unwrap();
+    assert_eq!(val, "bar");
+    // The TTL moves along with the key
+    let ttl: i64 = con.ttl("foo").unwrap();
+    assert!((99..=100).contains(&ttl), "{ttl}");
+
+    // An existing key of the destination isn't overwritten
+    select(con, 0);
+    let _: () = con.set("foo", "other").unwrap();
+    let moved: i64 = redis::cmd("MOVE").arg(&["foo", "1"]).query(con).unwrap();
+    assert_eq!(moved, 0);
+    let val: String = con.get("foo").unwrap();
+    assert_eq!(val, "other");
+    let moved: i64 = redis::cmd("MOVE")
+        .arg(&["missing", "1"])
+        .query(con)
+        .unwrap();
+    assert_eq!(moved, 0);
+
+    let err = redis::cmd("MOVE")
+        .arg(&["foo", "0"])
+        .query::<()>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("source and destination objects are the same")
+    );
+    let err = redis::cmd("MOVE")
+        .arg(&["foo", "16"])
+        .query::<()>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("DB index is out of range"));
+}
+
+#[test]
+fn test_swapdb() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let mut other_con = utils::get_connection(&test_server.port);
+    let _: () = con.set("foo", "zero").unwrap();
+    select(&mut other_con, 1);
+    let _: usize = other_con.rpush("list", "a").unwrap();
+
+    let swap_result: String = redis::cmd("SWAPDB").arg(&["0", "1"]).query(con).unwrap();
+    assert_eq!(swap_result, "OK");
+    // Both connections see the keys of the other database right away
+    let list: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(list, ["a"]);
+    let val: String = other_con.get("foo").unwrap();
+    assert_eq!(val, "zero");
+
+    let err = redis::cmd("SWAPDB")
+        .arg(&["zero", "1"])
+        .query::<()>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("invalid first DB index"));
+    let err = redis::cmd("SWAPDB")
+        .arg(&["0", "one"])
+        .query::<()>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("invalid second DB index"));
+    let err = redis::cmd("SWAPDB")
+        .arg(&["0", "16"])
+        .query::<()>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("DB index is out of range"));
+}
+
+#[test]
+fn test_swapdb_serves_blocked_clients_and_touches_watched_keys() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let mut watching_con = utils::get_connection(&test_server.port);
+    let _: () = redis::cmd("WATCH")
+        .arg("list")
+        .query(&mut watching_con)
+        .unwrap();
+
+    let mut blocked_con = utils::get_connection(&test_server.port);
+    let blocked = thread::spawn(move || {
+        let popped: Option<(String, String)> = redis::cmd("BLPOP")
+            .arg(&["list", "0"])
+            .query(&mut blocked_con)
+            .unwrap();
+        popped
+    });
+    thread::sleep(Duration::from_millis(100));
+
+    select(con, 1);
+    let _: usize = con.rpush("list", &["a", "b"]).unwrap();
+    let _: () = redis::cmd("SWAPDB").arg(&["0", "1"]).query(con).unwrap();
+    assert_eq!(
+        blocked.join().unwrap(),
+        Some(("list".to_string(), "a".to_string()))
+    );
+    select(con, 0);
+    let list: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(list, ["b"]);
+
+    // The watched key now holds the list of the other database
+    let exec_result: Option<(String,)> = redis::pipe()
+        .atomic()
+        .cmd("SET")
+        .arg(&["foo", "bar"])
+        .query(&mut watching_con)
+        .unwrap();
+    assert_eq!(exec_result, None);
+}
+
+#[test]
+fn test_flushdb_flushall() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    for db in 0..3 {
+        select(con, db);
+        let _: () = con.set("foo", "bar").unwrap();
+        let _: usize = con.rpush("list", &["a", "b"]).unwrap();
+    }
+
+    let flush_result: String = redis::cmd("FLUSHDB").query(con).unwrap();
+    assert_eq!(flush_result, "OK");
+    assert_eq!(utils::dbsize(con), 0);
+    select(con, 1);
+    assert_eq!(utils::dbsize(con), 2);
+    let _: () = redis::cmd("FLUSHDB").arg("ASYNC").query(con).unwrap();
+    assert_eq!(utils::dbsize(con), 0);
+
+    select(con, 0);
+    let _: () = con.set("foo", "bar").unwrap();
+    let flush_result: String = redis::cmd("FLUSHALL").arg("SYNC").query(con).unwrap();
+    assert_eq!(flush_result, "OK");
+    for db in 0..3 {
+        select(con, db);
+        assert_eq!(utils::dbsize(con), 0);
+    }
+
+    let err = redis::cmd("FLUSHALL")
+        .arg("LATER")
+        .query::<()>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
+
+#[test]
+fn test_flush_clears_ttls() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for flush in [
+        &["FLUSHDB"][..],
+        &["FLUSHDB", "ASYNC"],
+        &["FLUSHALL"],
+        &["FLUSHALL", "ASYNC"],
+    ] {
+        let _: () = con.set("foo", "bar").unwrap();
+        let _: bool = con.pexpire("foo", 200).unwrap();
+        let flush_result: String = redis::cmd(flush[0]).arg(&flush[1..]).query(con).unwrap();
+        assert_eq!(flush_result, "OK");
+
+        // The key created again has no TTL, so the TTL of the flushed key doesn't expire it
+        let _: () = con.set("foo", "baz").unwrap();
+        let ttl: i64 = con.pttl("foo").unwrap();
+        assert_eq!(ttl, -1, "{flush:?}");
+        thread::sleep(Duration::from_millis(300));
+        let val: Option<String> = con.get("foo").unwrap();
+        assert_eq!(val.as_deref(), Some("baz"), "{flush:?}");
+        let _: usize = con.del("foo").unwrap();
+    }
+}
+
+#[test]
+fn test_keyspace_events_of_other_db() {
+    let port = utils::find_free_tcp_port().to_string();
+    let _server = utils::start_server_with_args(&[&port, "--notify-keyspace-events", "KEA"]);
+    let mut con = utils::get_connection(&port);
+    let mut subscriber_con = utils::get_connection(&port);
+    let mut subscriber = subscriber_con.as_pubsub();
+    subscriber.psubscribe("__key*@2__:*").unwrap();
+
+    let _: () = con.set("foo", "zero").unwrap();
+    select(&mut con, 2);
+    let _: () = con.set("foo", "two").unwrap();
+    let moved: i64 = redis::cmd("MOVE")
+        .arg(&["foo", "3"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(moved, 1);
+
+    let messages: Vec<(String, String)> = (0..4)
+        .map(|_| {
+            let message = subscriber.get_message().unwrap();
+            (
+                message.get_channel_name().to_string(),
+                message.get_payload().unwrap(),
+            )
+        })
+        .collect();
+    assert_eq!(
+        messages,
+        [
+            ("__keyspace@2__:foo".to_string(), "set".to_string()),
+            ("__keyevent@2__:set".to_string(), "foo".to_string()),
+            ("__keyspace@2__:foo".to_string(), "move_from".to_string()),
+            ("__keyevent@2__:move_from".to_string(), "foo".to_string()),
+        ]
+    );
+}
+
+#[test]
+fn test_databases_are_saved() {
+    let dir = utils::create_temp_dir("select-save");
+
+    {
+        let (_server, mut con) = utils::start_server_in_dir(&dir, &["--dbfilename", "test.rdb"]);
+        let _: () = con.set("foo", "zero").unwrap();
+        select(&mut con, 5);
+        let _: () = con.set("foo", "five").unwrap();
+        let _: () = redis::cmd("SAVE").query(&mut con).unwrap();
+    }
+
+    let (_server, mut con) = utils::start_server_in_dir(&dir, &["--dbfilename", "test.rdb"]);
+    let val: String = con.get("foo").unwrap();
+    assert_eq!(val, "zero");
+    select(&mut con, 5);
+    let val: String = con.get("foo").unwrap();
+    assert_eq!(val, "five");
+    drop(con);
+
+    // The keys of a database beyond the configured ones can't be loaded
+    let port = utils::find_free_tcp_port().to_string();
+    let mut server = utils::start_server_with_args(&[
+        &port,
+        "--dir",
+        dir.to_str().unwrap(),
+        "--dbfilename",
+        "test.rdb",
+        "--databases",
+        "4",
+    ]);
+    assert!(server.exit_status().is_some_and(|status| !status.success()));
+}
+
+#[test]
+fn test_databases_are_logged_to_the_aof() {
+    let dir = utils::create_temp_dir("select-aof");
+
+    {
+        let (_server, mut con) = utils::start_server_in_dir(&dir, &["--appendonly", "yes"]);
+        let _: () = con.set("foo", "zero").unwrap();
+        select(&mut con, 2);
+        let _: () = con.set("foo", "two").unwrap();
+        let _: usize = con.rpush("list", "a").unwrap();
+        let moved: i64 = redis::cmd("MOVE")
+            .arg(&["list", "0"])
+            .query(&mut con)
+            .unwrap();
+        assert_eq!(moved, 1);
+    }
+
+    // SELECT is logged only when the database changes
+    let aof = String::from_utf8(fs::read(dir.join("appendonly.aof")).unwrap()).unwrap();
+    assert_eq!(aof.matches("SELECT").count(), 1, "{aof}");
+
+    let (_server, mut con) = utils::start_server_in_dir(&dir, &["--appendonly", "yes"]);
+    let list: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(list, ["a"]);
+    let val: String = con.get("foo").unwrap();
+    assert_eq!(val, "zero");
+    select(&mut con, 2);
+    let val: String = con.get("foo").unwrap();
+    assert_eq!(val, "two");
+    assert_eq!(utils::dbsize(&mut con), 1);
+}
@@ -0,0 +1,323 @@
+use redis::Commands;
+use std::collections::HashSet;
+
+mod utils;
+
+#[test]
+fn test_sadd_and_smembers() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let added: usize = con.sadd("set", &["a", "b", "c"]).unwrap();
+    assert_eq!(added, 3);
+    // Members already in the set aren't counted, including duplicates within the same command
+    let added: usize = con.sadd("set", &["a", "d", "d"]).unwrap();
+    assert_eq!(added, 1);
+    let added: usize = con.sadd("set", "b").unwrap();
+    assert_eq!(added, 0);
+
+    let members: HashSet<String> = con.smembers("set").unwrap();
+    let expected: HashSet<String> = ["a", "b", "c", "d"].map(String::from).into();
+    assert_eq!(members, expected);
+    let len: usize = con.scard("set").unwrap();
+    assert_eq!(len, 4);
+
+    let members: Vec<String> = con.smembers("missing").unwrap();
+    assert!(members.is_empty());
+    let len: usize = con.scard("missing").unwrap();
+    assert_eq!(len, 0);
+    let key_type: String = redis::cmd("TYPE").arg("set").query(con).unwrap();
+    assert_eq!(key_type, "set");
+
+    let (cursor, members): (String, HashSet<String>) = redis::cmd("SSCAN")
+        .arg(&["set", "0", "COUNT", "100"])
+        .query(con)
+        .unwrap();
+    assert_eq!(cursor, "0");
+    assert_eq!(members, expected);
+}
+
+#[test]
+fn test_sismember_and_smismember() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: usize = con.sadd("set", &["a", "b"]).unwrap();
+
+    let is_member: bool = con.sismember("set", "a").unwrap();
+    assert!(is_member);
+    let is_member: bool = con.sismember("set", "z").unwrap();
+    assert!(!is_member);
+    let is_member: bool = con.sismember("missing", "a").unwrap();
+    assert!(!is_member);
+
+    let are_members: Vec<bool> = redis::cmd("SMISMEMBER")
+        .arg(&["set", "a", "z", "b"])
+        .query(con)
+        .unwrap();
+    assert_eq!(are_members, [true, false, true]);
+    let are_members: Vec<bool> = redis::cmd("SMISMEMBER")
+        .arg(&["missing", "a", "b"])
+        .query(con)
+        .unwrap();
+    assert_eq!(are_members, [false, false]);
+}
+
+#[test]
+fn test_srem() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: usize = con.sadd("set", &["a", "b", "c"]).unwrap();
+
+    let removed: usize = con.srem("set", &["a", "z"]).unwrap();
+    assert_eq!(removed, 1);
+    let removed: usize = con.srem("missing", "a").unwrap();
+    assert_eq!(removed, 0);
+
+    // The key is removed along with the last member
+    let removed: usize = con.srem("set", &["b", "c"]).unwrap();
+    assert_eq!(removed, 2);
+    let key_type: String = redis::cmd("TYPE").arg("set").query(con).unwrap();
+    assert_eq!(key_type, "none");
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 0);
+}
+
+#[test]
+fn test_set_wrongtype() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("string", "value").unwrap();
+    let _: usize = con.sadd("set", "a").unwrap();
+
+    let err = con.sadd::<_, _, usize>("string", "a").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = con.srem::<_, _, usize>("string", "a").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = con.smembers::<_, Vec<String>>("string").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = con.sismember::<_, _, bool>("string", "a").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = redis::cmd("SMISMEMBER")
+        .arg(&["string", "a"])
+        .query::<Vec<bool>>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = con.scard::<_, usize>("string").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = con.get::<_, String>("set").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let val: String = con.get("string").unwrap();
+    assert_eq!(val, "value");
+}
+
+#[test]
+fn test_set_encodings() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let encoding = |con: &mut redis::Connection, key: &str| -> String {
+        redis::cmd("OBJECT")
+            .arg(&["ENCODING", key])
+            .query(con)
+            .unwrap()
+    };
+
+    let _: usize = con.sadd("set", &["1", "2", "-3"]).unwrap();
+    assert_eq!(encoding(con, "set"), "intset");
+    // A member which isn't an integer makes a small set a listpack
+    let _: usize = con.sadd("set", "a").unwrap();
+    assert_eq!(encoding(con, "set"), "listpack");
+    let _: usize = con.srem("set", "a").unwrap();
+    assert_eq!(encoding(con, "set"), "listpack");
+    let members: Vec<String> = (0..128).map(|i| format!("member:{i}")).collect();
+    let _: usize = con.sadd("set", &members).unwrap();
+    assert_eq!(encoding(con, "set"), "hashtable");
+    // A set is never converted back
+    let _: usize = con.srem("set", &members).unwrap();
+    assert_eq!(encoding(con, "set"), "hashtable");
+
+    // A set of integers which outgrows an intset becomes a hash table right away
+    let integers: Vec<usize> = (0..512).collect();
+    let _: usize = con.sadd("integers", &integers).unwrap();
+    assert_eq!(encoding(con, "integers"), "intset");
+    let _: usize = con.sadd("integers", 512).unwrap();
+    assert_eq!(encoding(con, "integers"), "hashtable");
+
+    // A set is a listpack up to 128 members of up to 64 bytes
+    let _: usize = con.sadd("small", &members[..127]).unwrap();
+    assert_eq!(encoding(con, "small"), "listpack");
+    let _: usize = con.sadd("small", "member:127").unwrap();
+    assert_eq!(encoding(con, "small"), "listpack");
+    let _: usize = con.sadd("small", "member:128").unwrap();
+    assert_eq!(encoding(con, "small"), "hashtable");
+    let _: usize = con.sadd("long", "x".repeat(64)).unwrap();
+    assert_eq!(encoding(con, "long"), "listpack");
+    let _: usize = con.sadd("long", "x".repeat(65)).unwrap();
+    assert_eq!(encoding(con, "long"), "hashtable");
+    // An intset with too many members for a listpack can't become one
+    let _: usize = con.sadd("many", &integers[..128]).unwrap();
+    let _: usize = con.sadd("many", "a").unwrap();
+    assert_eq!(encoding(con, "many"), "hashtable");
+}
+
+#[test]
+fn test_set_encoding_limits() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let encoding = |con: &mut redis::Connection, key: &str| -> String {
+        redis::cmd("OBJECT")
+            .arg(&["ENCODING", key])
+            .query(con)
+            .unwrap()
+    };
+    let _: () = redis::cmd("CONFIG")
+        .arg(&[
+            "SET",
+            "set-max-intset-entries",
+            "2",
+            "set-max-listpack-entries",
+            "3",
+            "set-max-listpack-value",
+            "4",
+        ])
+        .query(con)
+        .unwrap();
+    let limits: Vec<String> = redis::cmd("CONFIG")
+        .arg(&["GET", "set-max-*"])
+        .query(con)
+        .unwrap();
+    assert_eq!(limits.len(), 6);
+
+    let _: usize = con.sadd("integers", &[1, 2]).unwrap();
+    assert_eq!(encoding(con, "integers"), "intset");
+    let _: usize = con.sadd("integers", 3).unwrap();
+    assert_eq!(encoding(con, "integers"), "hashtable");
+
+    let _: usize = con.sadd("mixed", &[1, 2]).unwrap();
+    let _: usize = con.sadd("mixed", "abcd").unwrap();
+    assert_eq!(encoding(con, "mixed"), "listpack");
+    let _: usize = con.sadd("mixed", "e").unwrap();
+    assert_eq!(encoding(con, "mixed"), "hashtable");
+
+    // A set of integers stored at once which is too large for an intset may still fit a listpack
+    let _: usize = con.sadd("other", 3).unwrap();
+    let _: usize = con.sunionstore("created", &["integers", "other"]).unwrap();
+    assert_eq!(encoding(con, "created"), "listpack");
+    let _: usize = con.sadd("too-long", "abcde").unwrap();
+    assert_eq!(encoding(con, "too-long"), "hashtable");
+}
+
+#[test]
+fn test_set_persistence() {
+    let dir = utils::create_temp_dir("set-persistence");
+    let port = utils::find_free_tcp_port().to_string();
+    let args = [port.as_str(), "--dir", dir.to_str().unwrap()];
+    let expected: HashSet<String> = ["a", "b", "1"].map(String::from).into();
+    {
+        let _server = utils::start_server_with_args(&args);
+        let mut con = utils::get_connection(&port);
+        let _: usize = con.sadd("set", &["a", "b", "1"]).unwrap();
+        let _: () = redis::cmd("SAVE").query(&mut con).unwrap();
+    }
+
+    let _server = utils::start_server_with_args(&args);
+    let mut con = utils::get_connection(&port);
+    let members: HashSet<String> = con.smembers("set").unwrap();
+    assert_eq!(members, expected);
+}
+
+#[test]
+fn test_srandmember() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.sadd("set", &["a", "b", "c"]).unwrap();
+    let members = HashSet::from(["a".to_string(), "b".to_string(), "c".to_string()]);
+
+    let srandmember_result: String = redis::cmd("SRANDMEMBER").arg("set").query(con).unwrap();
+    assert!(members.contains(&srandmember_result));
+    let srandmember_result: Option<String> =
+        redis::cmd("SRANDMEMBER").arg("missing").query(con).unwrap();
+    assert_eq!(srandmember_result, None);
+
+    // A positive count gives distinct members, at most all of them
+    let srandmember_result: Vec<String> = redis::cmd("SRANDMEMBER")
+        .arg("set")
+        .arg(2)
+        .query(con)
+        .unwrap();
+    let distinct: HashSet<String> = srandmember_result.iter().cloned().collect();
+    assert_eq!(distinct.len(), 2);
+    assert!(distinct.is_subset(&members));
+    let srandmember_result: HashSet<String> = redis::cmd("SRANDMEMBER")
+        .arg("set")
+        .arg(10)
+        .query(con)
+        .unwrap();
+    assert_eq!(srandmember_result, members);
+
+    // A negative count may repeat members, so it can give more of them than the set has
+    let srandmember_result: Vec<String> = redis::cmd("SRANDMEMBER")
+        .arg(&["set", "-10"])
+        .query(con)
+        .unwrap();
+    assert_eq!(srandmember_result.len(), 10);
+    assert!(srandmember_result
+        .iter()
+        .all(|member| members.contains(member)));
+
+    let srandmember_result: Vec<String> = redis::cmd("SRANDMEMBER")
+        .arg("set")
+        .arg(0)
+        .query(con)
+        .unwrap();
+    assert!(srandmember_result.is_empty());
+    let srandmember_result: Vec<String> = redis::cmd("SRANDMEMBER")
+        .arg("missing")
+        .arg(2)
+        .query(con)
+        .unwrap();
+    assert!(srandmember_result.is_empty());
+}
+
+#[test]
+fn test_spop() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.sadd("set", &["a", "b", "c", "d", "e"]).unwrap();
+    let mut members = HashSet::from(["a", "b", "c", "d", "e"].map(String::from));
+
+    let spop_result: String = redis::cmd("SPOP").arg("set").query(con).unwrap();
+    assert!(members.remove(&spop_result));
+    let scard_result: usize = con.scard("set").unwrap();
+    assert_eq!(scard_result, 4);
+
+    // With a count, distinct members are removed
+    let spop_result: Vec<String> = redis::cmd("SPOP").arg(&["set", "2"]).query(con).unwrap();
+    assert_eq!(spop_result.len(), 2);
+    for member in &spop_result {
+        assert!(members.remove(member), "{member}");
+    }
+    let smembers_result: HashSet<String> = con.smembers("set").unwrap();
+    assert_eq!(smembers_result, members);
+    let spop_result: Vec<String> = redis::cmd("SPOP").arg(&["set", "0"]).query(con).unwrap();
+    assert!(spop_result.is_empty());
+    let err = redis::cmd("SPOP")
+        .arg(&["set", "-1"])
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("value is out of range, must be positive")
+    );
+
+    // Popping more members than the set has empties it, which removes the key
+    let spop_result: HashSet<String> = redis::cmd("SPOP").arg(&["set", "10"]).query(con).unwrap();
+    assert_eq!(spop_result, members);
+    let exists: bool = con.exists("set").unwrap();
+    assert!(!exists);
+    let spop_result: Option<String> = redis::cmd("SPOP").arg("set").query(con).unwrap();
+    assert_eq!(spop_result, None);
+    let spop_result: Vec<String> = redis::cmd("SPOP").arg(&["set", "2"]).query(con).unwrap();
+    assert!(spop_result.is_empty());
+}
@@ -0,0 +1,148 @@
+use redis::Commands;
+use std::collections::HashSet;
+
+mod utils;
+
+fn set_of(members: &[&str]) -> HashSet<String> {
+    members.iter().map(|&member| member.to_string()).collect()
+}
+
+// Create the sets {a, b, c, d}, {c, d, e} and {a, c, f}
+fn add_sets(con: &mut redis::Connection) {
+    let _: usize = con.sadd("set1", &["a", "b", "c", "d"]).unwrap();
+    let _: usize = con.sadd("set2", &["c", "d", "e"]).unwrap();
+    let _: usize = con.sadd("set3", &["a", "c", "f"]).unwrap();
+}
+
+#[test]
+fn test_sinter_sunion_sdiff() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    add_sets(con);
+
+    let members: HashSet<String> = con.sinter(&["set1", "set2"]).unwrap();
+    assert_eq!(members, set_of(&["c", "d"]));
+    let members: HashSet<String> = con.sinter(&["set1", "set2", "set3"]).unwrap();
+    assert_eq!(members, set_of(&["c"]));
+    let members: HashSet<String> = con.sunion(&["set1", "set2", "set3"]).unwrap();
+    assert_eq!(members, set_of(&["a", "b", "c", "d", "e", "f"]));
+    let members: HashSet<String> = con.sdiff(&["set1", "set2", "set3"]).unwrap();
+    assert_eq!(members, set_of(&["b"]));
+    let members: HashSet<String> = con.sdiff("set2").unwrap();
+    assert_eq!(members, set_of(&["c", "d", "e"]));
+}
+
+#[test]
+fn test_missing_keys_are_empty_sets() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    add_sets(con);
+
+    let members: HashSet<String> = con.sinter(&["set1", "missing", "set2"]).unwrap();
+    assert!(members.is_empty());
+    let members: HashSet<String> = con.sunion(&["missing", "set2", "other"]).unwrap();
+    assert_eq!(members, set_of(&["c", "d", "e"]));
+    let members: HashSet<String> = con.sdiff(&["set2", "missing"]).unwrap();
+    assert_eq!(members, set_of(&["c", "d", "e"]));
+    let members: HashSet<String> = con.sdiff(&["missing", "set2"]).unwrap();
+    assert!(members.is_empty());
+
+    // Every key must be a set, even when the result is known to be empty
+    let _: () = con.set("string", "value").unwrap();
+    let err = con
+        .sinter::<_, HashSet<String>>(&["missing", "string"])
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = con
+        .sunion::<_, HashSet<String>>(&["set1", "string"])
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_store_variants() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    add_sets(con);
+
+    let len: usize = con.sinterstore("dest", &["set1", "set2"]).unwrap();
+    assert_eq!(len, 2);
+    let members: HashSet<String> = con.smembers("dest").unwrap();
+    assert_eq!(members, set_of(&["c", "d"]));
+    let len: usize = con
+        .sunionstore("dest", &["set2", "missing", "set3"])
+        .unwrap();
+    assert_eq!(len, 5);
+    let members: HashSet<String> = con.smembers("dest").unwrap();
+    assert_eq!(members, set_of(&["a", "c", "d", "e", "f"]));
+
+    // The destination may be one of the sources
+    let len: usize = con.sdiffstore("set1", &["set1", "set3"]).unwrap();
+    assert_eq!(len, 2);
+    let members: HashSet<String> = con.smembers("set1").unwrap();
+    assert_eq!(members, set_of(&["b", "d"]));
+
+    // The destination is overwritten whatever its type, along with its TTL
+    let _: () = redis::cmd("SET")
+        .arg(&["string", "value", "EX", "100"])
+        .query(con)
+        .unwrap();
+    let len: usize = con.sinterstore("string", &["set2", "set3"]).unwrap();
+    assert_eq!(len, 1);
+    let members: HashSet<String> = con.smembers("string").unwrap();
+    assert_eq!(members, set_of(&["c"]));
+    let ttl: i64 = con.ttl("string").unwrap();
+    assert_eq!(ttl, -1);
+
+    // An empty result removes the destination
+    let len: usize = con.sinterstore("dest", &["set2", "missing"]).unwrap();
+    assert_eq!(len, 0);
+    let key_type: String = redis::cmd("TYPE").arg("dest").query(con).unwrap();
+    assert_eq!(key_type, "none");
+    let len: usize = con.sdiffstore("empty", &["missing", "set2"]).unwrap();
+    assert_eq!(len, 0);
+    let key_type: String = redis::cmd("TYPE").arg("empty").query(con).unwrap();
+    assert_eq!(key_type, "none");
+}
+
+#[test]
+fn test_sintercard() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    add_sets(con);
+    let sintercard = |con: &mut redis::Connection, args: &[&str]| -> redis::RedisResult<usize> {
+        redis::cmd("SINTERCARD").arg(args).query(con)
+    };
+
+    assert_eq!(sintercard(con, &["2", "set1", "set2"]).unwrap(), 2);
+    assert_eq!(sintercard(con, &["3", "set1", "set2", "set3"]).unwrap(), 1);
+    assert_eq!(sintercard(con, &["1", "set1"]).unwrap(), 4);
+    assert_eq!(sintercard(con, &["2", "set1", "missing"]).unwrap(), 0);
+    // The count stops at the limit, which is unlimited when 0
+    assert_eq!(
+        sintercard(con, &["2", "set1", "set2", "LIMIT", "1"]).unwrap(),
+        1
+    );
+    assert_eq!(sintercard(con, &["1", "set1", "limit", "10"]).unwrap(), 4);
+    assert_eq!(sintercard(con, &["1", "set1", "LIMIT", "0"]).unwrap(), 4);
+    let members: Vec<String> = (0..1000).map(|i| i.to_string()).collect();
+    let _: usize = con.sadd("large1", &members).unwrap();
+    let _: usize = con.sadd("large2", &members[500..]).unwrap();
+    assert_eq!(sintercard(con, &["2", "large1", "large2"]).unwrap(), 500);
+    assert_eq!(
+        sintercard(con, &["2", "large1", "large2", "LIMIT", "7"]).unwrap(),
+        7
+    );
+
+    let err = sintercard(con, &["0", "set1"]).unwrap_err();
+    assert_eq!(err.detail(), Some("numkeys should be greater than 0"));
+    let err = sintercard(con, &["3", "set1", "set2"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Number of keys can't be greater than number of args")
+    );
+    let err = sintercard(con, &["1", "set1", "LIMIT", "-1"]).unwrap_err();
+    assert_eq!(err.detail(), Some("LIMIT can't be negative"));
+    let err = sintercard(con, &["1", "set1", "set2"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
@@ -0,0 +1,190 @@
+use redis::Commands;
+
+mod utils;
+
+fn sort<T: redis::FromRedisValue>(con: &mut redis::Connection, args: &[&str]) -> T {
+    redis::cmd("SORT").arg(args).query(con).unwrap()
+}
+
+#[test]
+fn test_sort() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: usize = con.rpush("list", &["3", "10", "1", "2.5", "-4"]).unwrap();
+
+    // Numbers are sorted by value, not as strings
+    let sorted: Vec<String> = sort(con, &["list"]);
+    assert_eq!(sorted, ["-4", "1", "2.5", "3", "10"]);
+    let sorted: Vec<String> = sort(con, &["list", "DESC"]);
+    assert_eq!(sorted, ["10", "3", "2.5", "1", "-4"]);
+    let sorted: Vec<String> = sort(con, &["list", "ALPHA"]);
+    assert_eq!(sorted, ["-4", "1", "10", "2.5", "3"]);
+    let sorted: Vec<String> = sort(con, &["list", "LIMIT", "1", "2"]);
+    assert_eq!(sorted, ["1", "2.5"]);
+    let sorted: Vec<String> = sort(con, &["list", "LIMIT", "-1", "2", "DESC"]);
+    assert_eq!(sorted, ["10", "3"]);
+    let sorted: Vec<String> = sort(con, &["list", "LIMIT", "3", "-1"]);
+    assert_eq!(sorted, ["3", "10"]);
+    let sorted: Vec<String> = sort(con, &["list", "LIMIT", "10", "2"]);
+    assert!(sorted.is_empty());
+
+    // Sets and sorted sets are sorted by their members, not by their scores
+    let _: usize = con.sadd("set", &["b", "c", "a"]).unwrap();
+    let sorted: Vec<String> = sort(con, &["set", "ALPHA"]);
+    assert_eq!(sorted, ["a", "b", "c"]);
+    let _: usize = con
+        .zadd_multiple("zset", &[(1, "30"), (2, "10"), (3, "20")])
+        .unwrap();
+    let sorted: Vec<String> = sort(con, &["zset"]);
+    assert_eq!(sorted, ["10", "20", "30"]);
+    let sorted: Vec<String> = sort(con, &["missing"]);
+    assert!(sorted.is_empty());
+
+    // SORT_RO is the same, without STORE
+    let sorted: Vec<String> = redis::cmd("SORT_RO").arg("list").query(con).unwrap();
+    assert_eq!(sorted, ["-4", "1", "2.5", "3", "10"]);
+    let err = redis::cmd("SORT_RO")
+        .arg(&["list", "STORE", "destination"])
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+
+    let err = redis::cmd("SORT")
+        .arg("set")
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("One or more scores can't be converted into double")
+    );
+    let err = redis::cmd("SORT")
+        .arg(&["list", "LIMIT", "1"])
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let err = redis::cmd("SORT")
+        .arg(&["list", "LIMIT", "first", "2"])
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("value is not an integer or out of range")
+    );
+    let _: () = con.set("string", "value").unwrap();
+    let err = redis::cmd("SORT")
+        .arg("string")
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_sort_by_and_get() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: usize = con.rpush("users", &["1", "2", "3", "4"]).unwrap();
+    for (id, weight, name) in [
+        ("1", "30", "alice"),
+        ("2", "10", "bob"),
+        ("3", "20", "carol"),
+    ] {
+        let _: () = con.set(format!("weight_{id}"), weight).unwrap();
+        let _: () = con.hset(format!("user:{id}"), "name", name).unwrap();
+        let _: () = con.hset(format!("user:{id}"), "age", weight).unwrap();
+    }
+
+    // A missing weight counts as 0
+    let sorted: Vec<String> = sort(con, &["users", "BY", "weight_*"]);
+    assert_eq!(sorted, ["4", "2", "3", "1"]);
+    let sorted: Vec<String> = sort(con, &["users", "BY", "weight_*", "DESC"]);
+    assert_eq!(sorted, ["1", "3", "2", "4"]);
+    let sorted: Vec<String> = sort(con, &["users", "BY", "user:*->age", "LIMIT", "1", "2"]);
+    assert_eq!(sorted, ["2", "3"]);
+    let sorted: Vec<String> = sort(con, &["users", "BY", "user:*->name", "ALPHA", "DESC"]);
+    assert_eq!(sorted, ["3", "2", "1", "4"]);
+    // Without `*` the elements aren't sorted at all
+    let sorted: Vec<String> = sort(con, &["users", "BY", "nosort", "DESC"]);
+    assert_eq!(sorted, ["1", "2", "3", "4"]);
+
+    // The values of every GET are returned for every element, missing ones as nil
+    let values: Vec<Option<String>> = sort(
+        con,
+        &[
+            "users",
+            "BY",
+            "weight_*",
+            "GET",
+            "#",
+            "GET",
+            "user:*->name",
+            "GET",
+            "weight_*",
+        ],
+    );
+    assert_eq!(
+        values,
+        [
+            Some("4"),
+            None,
+            None,
+            Some("2"),
+            Some("bob"),
+            Some("10"),
+            Some("3"),
+            Some("carol"),
+            Some("20"),
+            Some("1"),
+            Some("alice"),
+            Some("30"),
+        ]
+        .map(|value| value.map(str::to_owned))
+    );
+    // A key of the wrong type and a missing field are missing values too
+    let values: Vec<Option<String>> = sort(
+        con,
+        &[
+            "users",
+            "LIMIT",
+            "0",
+            "1",
+            "GET",
+            "user:*",
+            "GET",
+            "user:*->email",
+        ],
+    );
+    assert_eq!(values, [None, None]);
+}
+
+#[test]
+fn test_sort_store() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: usize = con.rpush("list", &["3", "1", "2"]).unwrap();
+    let _: () = con.set("name_1", "one").unwrap();
+    let _: () = con.set("name_3", "three").unwrap();
+
+    // The destination is replaced whatever its type, with missing values stored as empty strings
+    let _: () = con.set("destination", "value").unwrap();
+    let stored: usize = sort(con, &["list", "GET", "name_*", "STORE", "destination"]);
+    assert_eq!(stored, 3);
+    let list: Vec<String> = con.lrange("destination", 0, -1).unwrap();
+    assert_eq!(list, ["one", "", "three"]);
+    let stored: usize = sort(con, &["list", "DESC", "STORE", "list"]);
+    assert_eq!(stored, 3);
+    let list: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(list, ["3", "2", "1"]);
+
+    // A set stored without sorting is still sorted as strings
+    let _: usize = con.sadd("set", &["b", "c", "a"]).unwrap();
+    let stored: usize = sort(con, &["set", "BY", "nosort", "STORE", "destination"]);
+    assert_eq!(stored, 3);
+    let list: Vec<String> = con.lrange("destination", 0, -1).unwrap();
+    assert_eq!(list, ["a", "b", "c"]);
+
+    // An empty result removes the destination
+    let stored: usize = sort(con, &["missing", "STORE", "destination"]);
+    assert_eq!(stored, 0);
+    let exists: bool = con.exists("destination").unwrap();
+    assert!(!exists);
+}
@@ -0,0 +1,400 @@
+use redis::Commands;
+use std::{
+    collections::HashMap,
+    thread,
+    time::{Duration, Instant},
+};
+
+mod utils;
+
+type Entries = Vec<(String, Vec<String>)>;
+type StreamsEntries = Vec<(String, Entries)>;
+
+fn xadd(con: &mut redis::Connection, args: &[&str]) -> redis::RedisResult<String> {
+    redis::cmd("XADD").arg(args).query(con)
+}
+
+fn xrange(con: &mut redis::Connection, args: &[&str]) -> Entries {
+    redis::cmd("XRANGE").arg(args).query(con).unwrap()
+}
+
+fn xread(con: &mut redis::Connection, args: &[&str]) -> Option<StreamsEntries> {
+    redis::cmd("XREAD").arg(args).query(con).unwrap()
+}
+
+// Run XREAD on a new connection in a separate thread
+fn spawn_xread(
+    port: &str,
+    args: &'static [&'static str],
+) -> thread::JoinHandle<Option<StreamsEntries>> {
+    let mut con = utils::get_connection(port);
+    thread::spawn(move || xread(&mut con, args))
+}
+
+fn xlen(con: &mut redis::Connection, key: &str) -> usize {
+    redis::cmd("XLEN").arg(key).query(con).unwrap()
+}
+
+fn ids(entries: Entries) -> Vec<String> {
+    entries.into_iter().map(|pair| pair.0).collect()
+}
+
+fn entry(id: &str, fields: &[&str]) -> (String, Vec<String>) {
+    (
+        id.to_string(),
+        fields.iter().map(|&field| field.to_string()).collect(),
+    )
+}
+
+#[test]
+fn test_xadd_ids() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    assert_eq!(xadd(con, &["stream", "1-1", "a", "1"]).unwrap(), "1-1");
+    // The sequence number is generated from the last entry of the same millisecond, or 0 for a new millisecond
+    assert_eq!(xadd(con, &["stream", "1-*", "a", "2"]).unwrap(), "1-2");
+    assert_eq!(xadd(con, &["stream", "5-*", "a", "3"]).unwrap(), "5-0");
+    // A missing sequence number is 0
+    assert_eq!(xadd(con, &["stream", "6", "a", "4"]).unwrap(), "6-0");
+
+    // An ID generated from the current time comes after the last one
+    let id = xadd(con, &["stream", "*", "a", "5"]).unwrap();
+    let (ms, seq) = id.split_once('-').unwrap();
+    assert!(ms.parse::<u64>().unwrap() > 6);
+    assert_eq!(seq, "0");
+    let xlen: usize = redis::cmd("XLEN").arg("stream").query(con).unwrap();
+    assert_eq!(xlen, 5);
+
+    let err = xadd(con, &["stream", "6-0", "a", "6"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("The ID specified in XADD is equal or smaller than the target stream top item")
+    );
+    let err = xadd(con, &["new", "0-0", "a", "1"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("The ID specified in XADD must be greater than 0-0")
+    );
+    // A failed XADD doesn't create the stream
+    let exists: bool = con.exists("new").unwrap();
+    assert!(!exists);
+    for id in ["abc", "1-2-3", "+1", "1-*-*"] {
+        let err = xadd(con, &["stream", id, "a", "1"]).unwrap_err();
+        assert_eq!(
+            err.detail(),
+            Some("Invalid stream ID specified as stream command argument"),
+            "{id}"
+        );
+    }
+    let err = xadd(con, &["stream", "*", "a"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'xadd' command")
+    );
+
+    let _: () = con.set("string", "value").unwrap();
+    let err = xadd(con, &["string", "*", "a", "1"]).unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let type_result: String = redis::cmd("TYPE").arg("stream").query(con).unwrap();
+    assert_eq!(type_result, "stream");
+}
+
+#[test]
+fn test_xrange() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for (id, val) in [("1-0", "a"), ("1-5", "b"), ("2-0", "c"), ("3-1", "d")] {
+        xadd(con, &["stream", id, "field", val, "other", val]).unwrap();
+    }
+
+    let all = xrange(con, &["stream", "-", "+"]);
+    assert_eq!(all.len(), 4);
+    assert_eq!(all[1], entry("1-5", &["field", "b", "other", "b"]));
+
+    // An ID without a sequence number covers the whole millisecond as the end
+    assert_eq!(ids(xrange(con, &["stream", "1", "1"])), ["1-0", "1-5"]);
+    assert_eq!(ids(xrange(con, &["stream", "1-1", "3-0"])), ["1-5", "2-0"]);
+    assert_eq!(
+        ids(xrange(con, &["stream", "-", "+", "COUNT", "3"])),
+        ["1-0", "1-5", "2-0"]
+    );
+    assert!(xrange(con, &["stream", "3", "1"]).is_empty());
+    assert!(xrange(con, &["missing", "-", "+"]).is_empty());
+
+    let err = redis::cmd("XRANGE")
+        .arg(&["stream", "-", "+", "LIMIT", "1"])
+        .query::<Entries>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let err = redis::cmd("XRANGE")
+        .arg(&["stream", "x", "+"])
+        .query::<Entries>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Invalid stream ID specified as stream command argument")
+    );
+
+    let xlen: usize = redis::cmd("XLEN").arg("missing").query(con).unwrap();
+    assert_eq!(xlen, 0);
+}
+
+#[test]
+fn test_xread() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for (key, id) in [("s1", "1-0"), ("s1", "2-0"), ("s1", "3-0"), ("s2", "5-0")] {
+        xadd(con, &[key, id, "field", id]).unwrap();
+    }
+
+    // The entries after the IDs, leaving out the streams without any
+    assert_eq!(
+        xread(con, &["STREAMS", "s1", "s2", "missing", "1", "5-0", "0"]),
+        Some(vec![(
+            "s1".to_string(),
+            vec![
+                entry("2-0", &["field", "2-0"]),
+                entry("3-0", &["field", "3-0"])
+            ]
+        )])
+    );
+    assert_eq!(
+        xread(con, &["COUNT", "1", "STREAMS", "s1", "s2", "0", "0"]),
+        Some(vec![
+            ("s1".to_string(), vec![entry("1-0", &["field", "1-0"])]),
+            ("s2".to_string(), vec![entry("5-0", &["field", "5-0"])]),
+        ])
+    );
+    // `$` only reads entries added later, so without BLOCK there is nothing
+    assert_eq!(xread(con, &["STREAMS", "s1", "$"]), None);
+    assert_eq!(xread(con, &["STREAMS", "s1", "3"]), None);
+
+    let err = redis::cmd("XREAD")
+        .arg(&["STREAMS", "s1", "s2", "0"])
+        .query::<Option<StreamsEntries>>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+    );
+    let err = redis::cmd("XREAD")
+        .arg(&["COUNT", "1", "s1", "0"])
+        .query::<Option<StreamsEntries>>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let err = redis::cmd("XREAD")
+        .arg(&["BLOCK", "-1", "STREAMS", "s1", "0"])
+        .query::<Option<StreamsEntries>>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("timeout is negative"));
+    let _: () = con.set("string", "value").unwrap();
+    let err = redis::cmd("XREAD")
+        .arg(&["STREAMS", "string", "0"])
+        .query::<Option<StreamsEntries>>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_xread_block() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    xadd(con, &["stream", "1-0", "field", "old"]).unwrap();
+    // Entries available right away are read without blocking
+    assert_eq!(
+        xread(con, &["BLOCK", "0", "STREAMS", "stream", "0"]),
+        Some(vec![(
+            "stream".to_string(),
+            vec![entry("1-0", &["field", "old"])]
+        )])
+    );
+    // The timeout elapses without any new entry
+    let start = Instant::now();
+    assert_eq!(
+        xread(con, &["BLOCK", "100", "STREAMS", "stream", "$"]),
+        None
+    );
+    assert!(start.elapsed() >= Duration::from_millis(100));
+
+    // Every client blocked on the stream reads the new entry, not only the first one
+    let first = spawn_xread(&test_server.port, &["BLOCK", "0", "STREAMS", "stream", "$"]);
+    let second = spawn_xread(
+        &test_server.port,
+        &["BLOCK", "0", "STREAMS", "other", "stream", "$", "$"],
+    );
+    thread::sleep(Duration::from_millis(100));
+    // A list doesn't serve clients blocked on streams
+    let _: usize = con.rpush("other", "x").unwrap();
+    xadd(con, &["stream", "2-0", "field", "new"]).unwrap();
+
+    let expected = Some(vec![(
+        "stream".to_string(),
+        vec![entry("2-0", &["field", "new"])],
+    )]);
+    assert_eq!(first.join().unwrap(), expected);
+    assert_eq!(second.join().unwrap(), expected);
+
+    // Inside a transaction XREAD doesn't block
+    let exec_result: (Option<StreamsEntries>,) = redis::pipe()
+        .atomic()
+        .cmd("XREAD")
+        .arg(&["BLOCK", "0", "STREAMS", "stream", "$"])
+        .query(con)
+        .unwrap();
+    assert_eq!(exec_result, (None,));
+}
+
+#[test]
+fn test_xtrim() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let xtrim = |con: &mut redis::Connection, args: &[&str]| -> redis::RedisResult<usize> {
+        redis::cmd("XTRIM").arg(args).query(con)
+    };
+    for id in ["1-0", "2-0", "3-0", "4-0", "5-0"] {
+        xadd(con, &["stream", id, "field", id]).unwrap();
+    }
+
+    // The oldest entries are dropped
+    assert_eq!(xtrim(con, &["stream", "MAXLEN", "3"]).unwrap(), 2);
+    assert_eq!(
+        ids(xrange(con, &["stream", "-", "+"])),
+        ["3-0", "4-0", "5-0"]
+    );
+    assert_eq!(xlen(con, "stream"), 3);
+    assert_eq!(xtrim(con, &["stream", "MAXLEN", "=", "5"]).unwrap(), 0);
+    assert_eq!(xtrim(con, &["stream", "MINID", "4"]).unwrap(), 1);
+    assert_eq!(ids(xrange(con, &["stream", "-", "+"])), ["4-0", "5-0"]);
+    assert_eq!(xtrim(con, &["missing", "MAXLEN", "0"]).unwrap(), 0);
+    // The ID of the last entry ever added stays
+    assert!(xadd(con, &["stream", "5-0", "field", "again"]).is_err());
+
+    // Approximate trimming only removes whole nodes of 100 entries, up to the limit
+    for ms in 1..=250 {
+        xadd(con, &["big", &format!("{ms}-0"), "field", "value"]).unwrap();
+    }
+    assert_eq!(xtrim(con, &["big", "MAXLEN", "~", "120"]).unwrap(), 100);
+    assert_eq!(xlen(con, "big"), 150);
+    assert_eq!(
+        xtrim(con, &["big", "MAXLEN", "~", "0", "LIMIT", "50"]).unwrap(),
+        0
+    );
+    assert_eq!(xtrim(con, &["big", "MINID", "~", "300"]).unwrap(), 100);
+    assert_eq!(xlen(con, "big"), 50);
+
+    let err = xtrim(con, &["stream", "MAXLEN", "1", "LIMIT", "10"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("syntax error, LIMIT cannot be used without the special ~ option")
+    );
+    let err = xtrim(con, &["stream", "MAXLEN", "-1"]).unwrap_err();
+    assert_eq!(err.detail(), Some("The MAXLEN argument must be >= 0."));
+    let err = xtrim(con, &["stream", "MAXLEN", "1", "MINID", "1"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("syntax error, MAXLEN and MINID options at the same time are not compatible")
+    );
+    let err = xtrim(con, &["stream", "COUNT", "1"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
+
+#[test]
+fn test_xadd_trimming() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // The stream is capped as every entry is added
+    for id in ["1-0", "2-0", "3-0", "4-0", "5-0"] {
+        let added = xadd(con, &["stream", "MAXLEN", "3", id, "field", id]).unwrap();
+        assert_eq!(added, id);
+    }
+    assert_eq!(xlen(con, "stream"), 3);
+    assert_eq!(
+        ids(xrange(con, &["stream", "-", "+"])),
+        ["3-0", "4-0", "5-0"]
+    );
+    xadd(con, &["stream", "MINID", "=", "5", "6-0", "field", "6-0"]).unwrap();
+    assert_eq!(ids(xrange(con, &["stream", "-", "+"])), ["5-0", "6-0"]);
+    xadd(con, &["stream", "MAXLEN", "~", "1", "*", "field", "new"]).unwrap();
+    assert_eq!(xlen(con, "stream"), 3);
+
+    let err = xadd(con, &["stream", "MAXLEN", "1", "*", "field"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'xadd' command")
+    );
+    let err = xadd(con, &["stream", "MAXLEN", "x", "*", "field", "value"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("value is not an integer or out of range")
+    );
+}
+
+#[test]
+fn test_xdel_and_xinfo() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let xdel = |con: &mut redis::Connection, ids: &[&str]| -> usize {
+        redis::cmd("XDEL")
+            .arg("stream")
+            .arg(ids)
+            .query(con)
+            .unwrap()
+    };
+    let xinfo = |con: &mut redis::Connection| -> HashMap<String, redis::Value> {
+        redis::cmd("XINFO")
+            .arg(&["STREAM", "stream"])
+            .query(con)
+            .unwrap()
+    };
+    for id in ["1-0", "2-0", "3-0"] {
+        xadd(con, &["stream", id, "field", id]).unwrap();
+    }
+    let _: () = redis::cmd("XGROUP")
+        .arg(&["CREATE", "stream", "group", "0"])
+        .query(con)
+        .unwrap();
+
+    assert_eq!(xdel(con, &["2-0", "9-0"]), 1);
+    assert_eq!(xdel(con, &["2-0"]), 0);
+    assert_eq!(xlen(con, "stream"), 2);
+    let info = xinfo(con);
+    let field = |name: &str| -> String { redis::from_redis_value(&info[name]).unwrap() };
+    assert_eq!(field("length"), "2");
+    assert_eq!(field("last-generated-id"), "3-0");
+    assert_eq!(field("max-deleted-entry-id"), "2-0");
+    assert_eq!(field("entries-added"), "3");
+    assert_eq!(field("recorded-first-entry-id"), "1-0");
+    assert_eq!(field("groups"), "1");
+    let first_entry: (String, Vec<String>) = redis::from_redis_value(&info["first-entry"]).unwrap();
+    assert_eq!(first_entry, entry("1-0", &["field", "1-0"]));
+    let last_entry: (String, Vec<String>) = redis::from_redis_value(&info["last-entry"]).unwrap();
+    assert_eq!(last_entry, entry("3-0", &["field", "3-0"]));
+
+    // The stream stays without any entry
+    assert_eq!(xdel(con, &["1-0", "3-0"]), 2);
+    let exists: bool = con.exists("stream").unwrap();
+    assert!(exists);
+    let info = xinfo(con);
+    assert_eq!(info["first-entry"], redis::Value::Nil);
+    let last_generated_id: String = redis::from_redis_value(&info["last-generated-id"]).unwrap();
+    assert_eq!(last_generated_id, "3-0");
+
+    let err = redis::cmd("XINFO")
+        .arg(&["STREAM", "missing"])
+        .query::<redis::Value>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("no such key"));
+    let err = redis::cmd("XDEL")
+        .arg(&["stream", "x"])
+        .query::<usize>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Invalid stream ID specified as stream command argument")
+    );
+}
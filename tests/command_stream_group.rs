@@ -0,0 +1,441 @@
+use std::{thread, time::Duration};
+
+mod utils;
+
+type Entries = Vec<(String, Vec<String>)>;
+type StreamsEntries = Vec<(String, Entries)>;
+type PendingSummary = (
+    i64,
+    Option<String>,
+    Option<String>,
+    Option<Vec<(String, String)>>,
+);
+type PendingEntries = Vec<(String, String, i64, i64)>;
+
+fn xadd(con: &mut redis::Connection, args: &[&str]) {
+    let _: String = redis::cmd("XADD").arg(args).query(con).unwrap();
+}
+
+fn xgroup(con: &mut redis::Connection, args: &[&str]) -> redis::RedisResult<redis::Value> {
+    redis::cmd("XGROUP").arg(args).query(con)
+}
+
+fn xreadgroup(con: &mut redis::Connection, args: &[&str]) -> Option<StreamsEntries> {
+    redis::cmd("XREADGROUP").arg(args).query(con).unwrap()
+}
+
+fn xpending(con: &mut redis::Connection, args: &[&str]) -> PendingEntries {
+    redis::cmd("XPENDING").arg(args).query(con).unwrap()
+}
+
+fn entry(id: &str, fields: &[&str]) -> (String, Vec<String>) {
+    (
+        id.to_string(),
+        fields.iter().map(|&field| field.to_string()).collect(),
+    )
+}
+
+// Pending entries as their IDs, consumers and numbers of deliveries, leaving out the idle times
+fn pending_ids(con: &mut redis::Connection, args: &[&str]) -> Vec<(String, String, i64)> {
+    xpending(con, args)
+        .into_iter()
+        .map(|(id, consumer, _, delivery_count)| (id, consumer, delivery_count))
+        .collect()
+}
+
+#[test]
+fn test_xgroup() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let err = xgroup(con, &["CREATE", "stream", "group", "$"]).unwrap_err();
+    assert!(err
+        .detail()
+        .unwrap()
+        .starts_with("The XGROUP subcommand requires the key to exist."));
+    assert_eq!(
+        xgroup(con, &["CREATE", "stream", "group", "$", "MKSTREAM"]).unwrap(),
+        redis::Value::Okay
+    );
+    let xlen: usize = redis::cmd("XLEN").arg("stream").query(con).unwrap();
+    assert_eq!(xlen, 0);
+    let err = xgroup(con, &["CREATE", "stream", "group", "0"]).unwrap_err();
+    assert_eq!(err.code(), Some("BUSYGROUP"));
+
+    assert_eq!(
+        xgroup(con, &["CREATECONSUMER", "stream", "group", "alice"]).unwrap(),
+        redis::Value::Int(1)
+    );
+    assert_eq!(
+        xgroup(con, &["CREATECONSUMER", "stream", "group", "alice"]).unwrap(),
+        redis::Value::Int(0)
+    );
+    let err = xgroup(con, &["CREATECONSUMER", "stream", "missing", "alice"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("No such consumer group 'missing' for key name 'stream'")
+    );
+    assert_eq!(err.code(), Some("NOGROUP"));
+
+    // Deleting a consumer drops its pending entries
+    xadd(con, &["stream", "1-0", "field", "1"]);
+    xadd(con, &["stream", "2-0", "field", "2"]);
+    xreadgroup(con, &["GROUP", "group", "alice", "STREAMS", "stream", ">"]);
+    assert_eq!(
+        xgroup(con, &["DELCONSUMER", "stream", "group", "alice"]).unwrap(),
+        redis::Value::Int(2)
+    );
+    assert_eq!(
+        pending_ids(con, &["stream", "group", "-", "+", "10"]),
+        vec![]
+    );
+
+    // SETID rewinds the group, so that the entries are delivered again
+    assert_eq!(
+        xgroup(con, &["SETID", "stream", "group", "1-0"]).unwrap(),
+        redis::Value::Okay
+    );
+    assert_eq!(
+        xreadgroup(con, &["GROUP", "group", "bob", "STREAMS", "stream", ">"]),
+        Some(vec![(
+            "stream".to_string(),
+            vec![entry("2-0", &["field", "2"])]
+        )])
+    );
+
+    assert_eq!(
+        xgroup(con, &["DESTROY", "stream", "group"]).unwrap(),
+        redis::Value::Int(1)
+    );
+    assert_eq!(
+        xgroup(con, &["DESTROY", "stream", "group"]).unwrap(),
+        redis::Value::Int(0)
+    );
+
+    let err = xgroup(con, &["CREATE", "stream", "group"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'xgroup|create' command")
+    );
+    let err = xgroup(con, &["NOPE", "stream"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("unknown subcommand 'NOPE'. Try XGROUP HELP.")
+    );
+}
+
+#[test]
+fn test_xreadgroup() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for id in ["1-0", "2-0", "3-0"] {
+        xadd(con, &["stream", id, "field", id]);
+    }
+    xgroup(con, &["CREATE", "stream", "group", "0"]).unwrap();
+
+    // Every new entry goes to only one consumer
+    assert_eq!(
+        xreadgroup(
+            con,
+            &["GROUP", "group", "alice", "COUNT", "2", "STREAMS", "stream", ">"]
+        ),
+        Some(vec![(
+            "stream".to_string(),
+            vec![
+                entry("1-0", &["field", "1-0"]),
+                entry("2-0", &["field", "2-0"])
+            ]
+        )])
+    );
+    assert_eq!(
+        xreadgroup(con, &["GROUP", "group", "bob", "STREAMS", "stream", ">"]),
+        Some(vec![(
+            "stream".to_string(),
+            vec![entry("3-0", &["field", "3-0"])]
+        )])
+    );
+    assert_eq!(
+        xreadgroup(con, &["GROUP", "group", "bob", "STREAMS", "stream", ">"]),
+        None
+    );
+
+    // The history of a consumer is its pending entries, which are delivered again
+    assert_eq!(
+        xreadgroup(
+            con,
+            &["GROUP", "group", "alice", "STREAMS", "stream", "1-0"]
+        ),
+        Some(vec![(
+            "stream".to_string(),
+            vec![entry("2-0", &["field", "2-0"])]
+        )])
+    );
+    assert_eq!(
+        pending_ids(con, &["stream", "group", "-", "+", "10"]),
+        vec![
+            ("1-0".to_string(), "alice".to_string(), 1),
+            ("2-0".to_string(), "alice".to_string(), 2),
+            ("3-0".to_string(), "bob".to_string(), 1),
+        ]
+    );
+    // An empty history is still replied for the stream
+    assert_eq!(
+        xreadgroup(con, &["GROUP", "group", "carol", "STREAMS", "stream", "0"]),
+        Some(vec![("stream".to_string(), vec![])])
+    );
+
+    // NOACK entries never become pending
+    xadd(con, &["stream", "4-0", "field", "4-0"]);
+    xreadgroup(
+        con,
+        &["GROUP", "group", "carol", "NOACK", "STREAMS", "stream", ">"],
+    );
+    let summary: PendingSummary = redis::cmd("XPENDING")
+        .arg(&["stream", "group"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        summary,
+        (
+            3,
+            Some("1-0".to_string()),
+            Some("3-0".to_string()),
+            Some(vec![
+                ("alice".to_string(), "2".to_string()),
+                ("bob".to_string(), "1".to_string()),
+            ])
+        )
+    );
+
+    let err = redis::cmd("XREADGROUP")
+        .arg(&["GROUP", "missing", "alice", "STREAMS", "stream", ">"])
+        .query::<Option<StreamsEntries>>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("No such key 'stream' or consumer group 'missing' in XREADGROUP with GROUP option")
+    );
+    assert_eq!(err.code(), Some("NOGROUP"));
+    let err = redis::cmd("XREADGROUP")
+        .arg(&["GROUP", "group", "alice", "STREAMS", "stream", "$"])
+        .query::<Option<StreamsEntries>>(con)
+        .unwrap_err();
+    assert!(err
+        .detail()
+        .unwrap()
+        .starts_with("The $ ID is meaningless in the context of XREADGROUP"));
+    let err = redis::cmd("XREADGROUP")
+        .arg(&["COUNT", "1", "NOACK", "STREAMS", "stream", ">"])
+        .query::<Option<StreamsEntries>>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("Missing GROUP option for XREADGROUP"));
+    let err = redis::cmd("XREAD")
+        .arg(&["STREAMS", "stream", ">"])
+        .query::<Option<StreamsEntries>>(con)
+        .unwrap_err();
+    assert!(err
+        .detail()
+        .unwrap()
+        .starts_with("The > ID can be specified only when calling XREADGROUP"));
+}
+
+#[test]
+fn test_xreadgroup_block() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    xgroup(con, &["CREATE", "stream", "group", "$", "MKSTREAM"]).unwrap();
+    assert_eq!(
+        xreadgroup(
+            con,
+            &["GROUP", "group", "alice", "BLOCK", "100", "STREAMS", "stream", ">"]
+        ),
+        None
+    );
+
+    // Of the two consumers blocked on the stream, only one gets the new entry
+    let spawn_xreadgroup = |consumer: &'static str| {
+        let mut con = utils::get_connection(&test_server.port);
+        thread::spawn(move || {
+            xreadgroup(
+                &mut con,
+                &[
+                    "GROUP", "group", consumer, "BLOCK", "300", "STREAMS", "stream", ">",
+                ],
+            )
+        })
+    };
+    let alice = spawn_xreadgroup("alice");
+    let bob = spawn_xreadgroup("bob");
+    thread::sleep(Duration::from_millis(100));
+    xadd(con, &["stream", "1-0", "field", "value"]);
+
+    let mut results = [alice.join().unwrap(), bob.join().unwrap()];
+    results.sort();
+    assert_eq!(
+        results,
+        [
+            None,
+            Some(vec![(
+                "stream".to_string(),
+                vec![entry("1-0", &["field", "value"])]
+            )])
+        ]
+    );
+    let pending = xpending(con, &["stream", "group", "-", "+", "10"]);
+    assert_eq!(pending.len(), 1);
+    assert_eq!(pending[0].0, "1-0");
+}
+
+#[test]
+fn test_xack_xpending() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for id in ["1-0", "2-0", "3-0"] {
+        xadd(con, &["stream", id, "field", id]);
+    }
+    xgroup(con, &["CREATE", "stream", "group", "0"]).unwrap();
+    let summary: PendingSummary = redis::cmd("XPENDING")
+        .arg(&["stream", "group"])
+        .query(con)
+        .unwrap();
+    assert_eq!(summary, (0, None, None, None));
+
+    xreadgroup(
+        con,
+        &[
+            "GROUP", "group", "alice", "COUNT", "2", "STREAMS", "stream", ">",
+        ],
+    );
+    xreadgroup(con, &["GROUP", "group", "bob", "STREAMS", "stream", ">"]);
+    assert_eq!(
+        pending_ids(con, &["stream", "group", "-", "+", "10", "bob"]),
+        vec![("3-0".to_string(), "bob".to_string(), 1)]
+    );
+    assert_eq!(
+        pending_ids(con, &["stream", "group", "2", "+", "1"]),
+        vec![("2-0".to_string(), "alice".to_string(), 1)]
+    );
+    // Nothing was delivered an hour ago
+    assert_eq!(
+        pending_ids(con, &["stream", "group", "IDLE", "3600000", "-", "+", "10"]),
+        vec![]
+    );
+
+    let acked: usize = redis::cmd("XACK")
+        .arg(&["stream", "group", "1-0", "3-0", "9-0"])
+        .query(con)
+        .unwrap();
+    assert_eq!(acked, 2);
+    assert_eq!(
+        pending_ids(con, &["stream", "group", "-", "+", "10"]),
+        vec![("2-0".to_string(), "alice".to_string(), 1)]
+    );
+    let acked: usize = redis::cmd("XACK")
+        .arg(&["stream", "missing", "2-0"])
+        .query(con)
+        .unwrap();
+    assert_eq!(acked, 0);
+
+    let err = redis::cmd("XPENDING")
+        .arg(&["stream", "missing"])
+        .query::<PendingSummary>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("No such key 'stream' or consumer group 'missing'")
+    );
+    assert_eq!(err.code(), Some("NOGROUP"));
+}
+
+#[test]
+fn test_xclaim() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for id in ["1-0", "2-0", "3-0"] {
+        xadd(con, &["stream", id, "field", id]);
+    }
+    xgroup(con, &["CREATE", "stream", "group", "0"]).unwrap();
+    xreadgroup(
+        con,
+        &[
+            "GROUP", "group", "alice", "COUNT", "2", "STREAMS", "stream", ">",
+        ],
+    );
+
+    // The entries weren't idle for long enough
+    let claimed: Entries = redis::cmd("XCLAIM")
+        .arg(&["stream", "group", "bob", "3600000", "1-0", "2-0"])
+        .query(con)
+        .unwrap();
+    assert_eq!(claimed, vec![]);
+
+    let claimed: Entries = redis::cmd("XCLAIM")
+        .arg(&["stream", "group", "bob", "0", "1-0"])
+        .query(con)
+        .unwrap();
+    assert_eq!(claimed, vec![entry("1-0", &["field", "1-0"])]);
+    // JUSTID doesn't count as a delivery, and FORCE claims an entry which isn't pending
+    let claimed: Vec<String> = redis::cmd("XCLAIM")
+        .arg(&[
+            "stream", "group", "bob", "0", "2-0", "3-0", "JUSTID", "FORCE",
+        ])
+        .query(con)
+        .unwrap();
+    assert_eq!(claimed, vec!["2-0", "3-0"]);
+    let claimed: Vec<String> = redis::cmd("XCLAIM")
+        .arg(&[
+            "stream",
+            "group",
+            "carol",
+            "0",
+            "3-0",
+            "RETRYCOUNT",
+            "7",
+            "JUSTID",
+        ])
+        .query(con)
+        .unwrap();
+    assert_eq!(claimed, vec!["3-0"]);
+    assert_eq!(
+        pending_ids(con, &["stream", "group", "-", "+", "10"]),
+        vec![
+            ("1-0".to_string(), "bob".to_string(), 2),
+            ("2-0".to_string(), "bob".to_string(), 1),
+            ("3-0".to_string(), "carol".to_string(), 7),
+        ]
+    );
+    // The history of alice is now empty
+    assert_eq!(
+        xreadgroup(con, &["GROUP", "group", "alice", "STREAMS", "stream", "0"]),
+        Some(vec![("stream".to_string(), vec![])])
+    );
+
+    // IDLE sets the time of the delivery
+    let _: Vec<String> = redis::cmd("XCLAIM")
+        .arg(&[
+            "stream", "group", "bob", "0", "3-0", "IDLE", "3600000", "JUSTID",
+        ])
+        .query(con)
+        .unwrap();
+    let pending = xpending(con, &["stream", "group", "IDLE", "3600000", "-", "+", "10"]);
+    assert_eq!(pending.len(), 1);
+    assert_eq!(pending[0].0, "3-0");
+
+    let err = redis::cmd("XCLAIM")
+        .arg(&["stream", "group", "bob", "x", "1-0"])
+        .query::<Entries>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Invalid min-idle-time argument for XCLAIM")
+    );
+    let err = redis::cmd("XCLAIM")
+        .arg(&["stream", "group", "bob", "0", "1-0", "NOPE"])
+        .query::<Entries>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("Unrecognized XCLAIM option 'NOPE'"));
+}
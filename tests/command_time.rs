@@ -0,0 +1,26 @@
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+mod utils;
+
+#[test]
+fn test_time() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let (seconds, micros): (String, String) = redis::cmd("TIME").query(con).unwrap();
+    let (seconds, micros): (u64, u64) = (seconds.parse().unwrap(), micros.parse().unwrap());
+    // The microseconds are within the second, not since the epoch
+    assert!(micros < 1_000_000, "{micros}");
+    let server_time = Duration::from_secs(seconds) + Duration::from_micros(micros);
+    let now = SystemTime::now().duration_since(UNIX_EPOCH).unwrap();
+    assert!(
+        now.abs_diff(server_time) < Duration::from_secs(2),
+        "{server_time:?}"
+    );
+
+    let err = redis::cmd("TIME").arg("now").query::<()>(con).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'time' command")
+    );
+}
@@ -0,0 +1,186 @@
+use redis::Commands;
+
+mod utils;
+
+use utils::send_raw;
+
+#[test]
+fn test_multi_exec() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let multi_result: String = redis::cmd("MULTI").query(con).unwrap();
+    assert_eq!(multi_result, "OK");
+    // Commands are queued instead of being run
+    let set_result: String = redis::cmd("SET").arg(&["foo", "1"]).query(con).unwrap();
+    assert_eq!(set_result, "QUEUED");
+    let incr_result: String = redis::cmd("INCR").arg("foo").query(con).unwrap();
+    assert_eq!(incr_result, "QUEUED");
+    let get_result: String = redis::cmd("GET").arg("foo").query(con).unwrap();
+    assert_eq!(get_result, "QUEUED");
+
+    let exec_result: (String, i64, String) = redis::cmd("EXEC").query(con).unwrap();
+    assert_eq!(exec_result, ("OK".to_string(), 2, "2".to_string()));
+
+    // An empty transaction replies with an empty array
+    let exec_result: Vec<String> = redis::pipe().atomic().query(con).unwrap();
+    assert!(exec_result.is_empty());
+    let exec_result: (usize, Vec<String>) = redis::pipe()
+        .atomic()
+        .cmd("RPUSH")
+        .arg(&["list", "a", "b"])
+        .cmd("LRANGE")
+        .arg(&["list", "0", "-1"])
+        .query(con)
+        .unwrap();
+    assert_eq!(exec_result, (2, vec!["a".to_string(), "b".to_string()]));
+}
+
+#[test]
+fn test_discard() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = redis::cmd("MULTI").query(con).unwrap();
+    let _: () = redis::cmd("SET").arg(&["foo", "1"]).query(con).unwrap();
+    let discard_result: String = redis::cmd("DISCARD").query(con).unwrap();
+    assert_eq!(discard_result, "OK");
+
+    // Nothing was run and the connection is no longer in a transaction
+    let get_result: Option<String> = con.get("foo").unwrap();
+    assert_eq!(get_result, None);
+    let err = redis::cmd("EXEC").query::<()>(con).unwrap_err();
+    assert_eq!(err.detail(), Some("EXEC without MULTI"));
+    let err = redis::cmd("DISCARD").query::<()>(con).unwrap_err();
+    assert_eq!(err.detail(), Some("DISCARD without MULTI"));
+}
+
+#[test]
+fn test_exec_abort_on_queue_error() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: () = redis::cmd("MULTI").query(con).unwrap();
+    let _: () = redis::cmd("SET").arg(&["foo", "1"]).query(con).unwrap();
+    // Unknown commands and wrong numbers of arguments are rejected right away
+    let err = redis::cmd("FOO").query::<()>(con).unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+    let err = redis::cmd("GET").query::<()>(con).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'get' command")
+    );
+    // MULTI can't be nested but doesn't abort the transaction on its own
+    let err = redis::cmd("MULTI").query::<()>(con).unwrap_err();
+    assert_eq!(err.detail(), Some("MULTI calls can not be nested"));
+
+    let err = redis::cmd("EXEC").query::<()>(con).unwrap_err();
+    assert_eq!(err.code(), Some("EXECABORT"));
+    // None of the queued commands were run
+    let get_result: Option<String> = con.get("foo").unwrap();
+    assert_eq!(get_result, None);
+}
+
+#[test]
+fn test_exec_runtime_errors_inline() {
+    let test_server = utils::start_server_and_get_connection();
+
+    let reply = send_raw(
+        &test_server.port,
+        &[b"SET foo bar\r\nMULTI\r\nINCR foo\r\nSET foo 5\r\nINCR foo\r\nBLPOP list 0\r\nEXEC\r\n"],
+    );
+    let reply = String::from_utf8(reply).unwrap();
+    // A failing command doesn't stop the rest, and blocking commands don't block
+    assert_eq!(
+        reply,
+        "+OK\r\n+OK\r\n+QUEUED\r\n+QUEUED\r\n+QUEUED\r\n+QUEUED\r\n\
+         *4\r\n-ERR value is not an integer or out of range\r\n+OK\r\n:6\r\n*-1\r\n"
+    );
+}
+
+// Increment the counter with the compare-and-swap pattern, letting `interfere` run between the read and EXEC
+fn cas_incr(con: &mut redis::Connection, interfere: impl FnOnce()) -> Option<(String,)> {
+    let _: () = redis::cmd("WATCH").arg("counter").query(con).unwrap();
+    let counter: i64 = con.get("counter").unwrap();
+    interfere();
+    redis::pipe()
+        .atomic()
+        .cmd("SET")
+        .arg("counter")
+        .arg(counter + 1)
+        .query(con)
+        .unwrap()
+}
+
+#[test]
+fn test_watch_compare_and_swap() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let mut other_con = utils::get_connection(&test_server.port);
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("counter", 10).unwrap();
+
+    // A concurrent write to the watched key aborts the transaction
+    let exec_result = cas_incr(con, || {
+        let _: () = other_con.set("counter", 20).unwrap();
+    });
+    assert_eq!(exec_result, None);
+    let counter: i64 = con.get("counter").unwrap();
+    assert_eq!(counter, 20);
+
+    // EXEC unwatched the key, so a retry without interference succeeds
+    let exec_result = cas_incr(con, || {});
+    assert_eq!(exec_result, Some(("OK".to_string(),)));
+    let counter: i64 = con.get("counter").unwrap();
+    assert_eq!(counter, 21);
+
+    // Reads by other clients don't count as modifications
+    let exec_result = cas_incr(con, || {
+        let _: i64 = other_con.get("counter").unwrap();
+        let _: i64 = other_con.ttl("counter").unwrap();
+    });
+    assert_eq!(exec_result, Some(("OK".to_string(),)));
+    let counter: i64 = con.get("counter").unwrap();
+    assert_eq!(counter, 22);
+}
+
+#[test]
+fn test_watch_variants() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let mut other_con = utils::get_connection(&test_server.port);
+    let con = &mut test_server.connection;
+
+    // UNWATCH forgets the keys, so later writes don't abort the transaction
+    let _: () = redis::cmd("WATCH").arg(&["a", "b"]).query(con).unwrap();
+    let unwatch_result: String = redis::cmd("UNWATCH").query(con).unwrap();
+    assert_eq!(unwatch_result, "OK");
+    let _: () = other_con.set("a", "1").unwrap();
+    let exec_result: Option<(i64,)> = redis::pipe()
+        .atomic()
+        .cmd("INCR")
+        .arg("a")
+        .query(con)
+        .unwrap();
+    assert_eq!(exec_result, Some((2,)));
+
+    // Creating a watched key which was missing counts as a modification, as do writes of other types
+    for interfering_command in [&["HSET", "missing", "f", "v"][..], &["RPUSH", "list", "x"]] {
+        let _: () = redis::cmd("WATCH")
+            .arg(&["missing", "list"])
+            .query(con)
+            .unwrap();
+        let _: () = redis::cmd(interfering_command[0])
+            .arg(&interfering_command[1..])
+            .query(&mut other_con)
+            .unwrap();
+        let exec_result: Option<(String,)> = redis::pipe().atomic().cmd("PING").query(con).unwrap();
+        assert_eq!(exec_result, None, "{interfering_command:?}");
+    }
+
+    // WATCH isn't allowed inside a transaction, which can still be run
+    let _: () = redis::cmd("MULTI").query(con).unwrap();
+    let err = redis::cmd("WATCH").arg("a").query::<()>(con).unwrap_err();
+    assert_eq!(err.detail(), Some("WATCH inside MULTI is not allowed"));
+    let exec_result: Vec<String> = redis::cmd("EXEC").query(con).unwrap();
+    assert!(exec_result.is_empty());
+}
@@ -0,0 +1,134 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+// Populate one key of every type
+fn set_up_keys(con: &mut redis::Connection) {
+    let _: () = con.set("string", "value").unwrap();
+    let _: usize = con.rpush("list", "a").unwrap();
+    let _: usize = con.hset("hash", "field", "value").unwrap();
+    let _: usize = con.zadd("zset", "member", 1).unwrap();
+}
+
+#[test]
+fn test_type() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    set_up_keys(con);
+    let _: () = redis::cmd("SET")
+        .arg(&["expired", "value", "PX", "1"])
+        .query(con)
+        .unwrap();
+    thread::sleep(Duration::from_millis(10));
+
+    for (key, type_name) in [
+        ("string", "string"),
+        ("list", "list"),
+        ("hash", "hash"),
+        ("zset", "zset"),
+        ("missing", "none"),
+        ("expired", "none"),
+    ] {
+        let reply: String = redis::cmd("TYPE").arg(key).query(con).unwrap();
+        assert_eq!(reply, type_name, "{key}");
+    }
+}
+
+#[test]
+fn test_wrongtype_everywhere() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    set_up_keys(con);
+
+    // Commands along with the type of the key they act on
+    let commands: &[(&str, &[&str])] = &[
+        ("string", &["GET"]),
+        ("string", &["SET", "_", "x", "GET"]),
+        ("string", &["INCR"]),
+        ("string", &["DECRBY", "_", "2"]),
+        ("list", &["LPUSH", "_", "x"]),
+        ("list", &["RPUSH", "_", "x"]),
+        ("list", &["LPOP"]),
+        ("list", &["LLEN"]),
+        ("list", &["LRANGE", "_", "0", "-1"]),
+        ("list", &["BLPOP", "_", "0"]),
+        ("hash", &["HSET", "_", "f", "v"]),
+        ("hash", &["HGET", "_", "f"]),
+        ("hash", &["HDEL", "_", "f"]),
+        ("hash", &["HGETALL"]),
+        ("hash", &["HLEN"]),
+        ("hash", &["HEXISTS", "_", "f"]),
+        ("hash", &["HSCAN", "_", "0"]),
+        ("zset", &["ZADD", "_", "1", "m"]),
+        ("zset", &["ZSCORE", "_", "m"]),
+        ("zset", &["ZRANK", "_", "m"]),
+        ("zset", &["ZRANGE", "_", "0", "-1"]),
+        ("zset", &["ZSCAN", "_", "0"]),
+    ];
+    for &(key_type, command) in commands {
+        for key in ["string", "list", "hash", "zset"] {
+            if key == key_type {
+                continue;
+            }
+            let mut cmd = redis::cmd(command[0]);
+            // The key goes in place of `_`, or right after the command name
+            if command.len() == 1 {
+                cmd.arg(key);
+            }
+            for &arg in &command[1..] {
+                cmd.arg(if arg == "_" { key } else { arg });
+            }
+            let err = cmd.query::<redis::Value>(con).unwrap_err();
+            assert_eq!(err.code(), Some("WRONGTYPE"), "{command:?} on {key}");
+        }
+    }
+
+    // Failing commands leave the keys untouched
+    let reply: String = con.get("string").unwrap();
+    assert_eq!(reply, "value");
+    let reply: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(reply, ["a"]);
+    let reply: String = con.hget("hash", "field").unwrap();
+    assert_eq!(reply, "value");
+    let reply: f64 = con.zscore("zset", "member").unwrap();
+    assert!((reply - 1.0).abs() < f64::EPSILON);
+}
+
+#[test]
+fn test_wrongtype_doesnt_touch_watched_keys() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = con.set("string", "value").unwrap();
+
+    let _: () = redis::cmd("WATCH").arg("string").query(con).unwrap();
+    let err = con.lpush::<_, _, usize>("string", "x").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = con.hset::<_, _, _, usize>("string", "f", "v").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let _: () = redis::cmd("MULTI").query(con).unwrap();
+    let _: () = redis::cmd("GET").arg("string").query(con).unwrap();
+    let reply: Option<Vec<String>> = redis::cmd("EXEC").query(con).unwrap();
+    assert_eq!(reply, Some(vec!["value".to_string()]));
+}
+
+#[test]
+fn test_lpop_invalid_count() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: usize = con.rpush("list", &["a", "b"]).unwrap();
+
+    for count in ["many", "-1"] {
+        let err = redis::cmd("LPOP")
+            .arg(&["list", count])
+            .query::<redis::Value>(con)
+            .unwrap_err();
+        assert_eq!(
+            err.detail(),
+            Some("value is out of range, must be positive")
+        );
+    }
+    // The server is still up and the list untouched
+    let reply: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(reply, ["a", "b"]);
+}
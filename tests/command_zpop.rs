@@ -0,0 +1,140 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+fn zpop(con: &mut redis::Connection, command: &str, args: &[&str]) -> Vec<String> {
+    redis::cmd(command).arg(args).query(con).unwrap()
+}
+
+// Run BZPOPMIN/BZPOPMAX on a new connection in a separate thread
+fn spawn_blocking_zpop(
+    port: &str,
+    command: &'static str,
+    keys: &'static [&'static str],
+) -> thread::JoinHandle<Option<(String, String, f64)>> {
+    let mut con = utils::get_connection(port);
+    thread::spawn(move || {
+        redis::cmd(command)
+            .arg(keys)
+            .arg(0)
+            .query(&mut con)
+            .unwrap()
+    })
+}
+
+#[test]
+fn test_zpop_count() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con
+        .zadd_multiple("zset", &[(1, "a"), (2, "b"), (3, "c"), (4, "d")])
+        .unwrap();
+
+    assert_eq!(zpop(con, "ZPOPMIN", &["zset"]), ["a", "1"]);
+    assert_eq!(zpop(con, "ZPOPMAX", &["zset", "2"]), ["d", "4", "c", "3"]);
+    // A count of 0 pops nothing
+    assert!(zpop(con, "ZPOPMIN", &["zset", "0"]).is_empty());
+
+    // The key is removed along with the last member, even if the count asks for more
+    assert_eq!(zpop(con, "ZPOPMIN", &["zset", "10"]), ["b", "2"]);
+    let exists: bool = con.exists("zset").unwrap();
+    assert!(!exists);
+    assert!(zpop(con, "ZPOPMAX", &["zset"]).is_empty());
+
+    let err = redis::cmd("ZPOPMIN")
+        .arg(&["zset", "-1"])
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("value is out of range, must be positive")
+    );
+    let _: () = con.set("string", "value").unwrap();
+    let err = redis::cmd("ZPOPMIN")
+        .arg("string")
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_bzpop_available() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let _: usize = con.zadd_multiple("zset1", &[(1, "a"), (2, "b")]).unwrap();
+    let _: usize = con.zadd_multiple("zset2", &[(3, "c"), (4, "d")]).unwrap();
+
+    // The first non-empty sorted set in the order of the keys is popped from
+    let bzpopmax_result: Option<(String, String, f64)> = redis::cmd("BZPOPMAX")
+        .arg(&["empty", "zset2", "zset1"])
+        .arg(0)
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        bzpopmax_result,
+        Some(("zset2".to_string(), "d".to_string(), 4.0))
+    );
+    let bzpopmin_result: Option<(String, String, f64)> = redis::cmd("BZPOPMIN")
+        .arg(&["zset1", "zset2"])
+        .arg(0)
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        bzpopmin_result,
+        Some(("zset1".to_string(), "a".to_string(), 1.0))
+    );
+
+    // The timeout elapses while all the sorted sets are empty
+    let bzpopmin_result: Option<(String, String, f64)> = redis::cmd("BZPOPMIN")
+        .arg(&["empty", "other"])
+        .arg(0.1)
+        .query(con)
+        .unwrap();
+    assert_eq!(bzpopmin_result, None);
+}
+
+#[test]
+fn test_bzpop_woken_by_zadd() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Clients are served in the order they blocked, one member each
+    let first = spawn_blocking_zpop(&test_server.port, "BZPOPMIN", &["zset1", "zset2"]);
+    thread::sleep(Duration::from_millis(100));
+    let second = spawn_blocking_zpop(&test_server.port, "BZPOPMAX", &["zset2"]);
+    thread::sleep(Duration::from_millis(100));
+    // A list doesn't serve clients blocked on sorted sets
+    let _: usize = con.rpush("zset1", "x").unwrap();
+
+    let zadd_result: usize = con
+        .zadd_multiple("zset2", &[(1, "a"), (2, "b"), (3, "c")])
+        .unwrap();
+    assert_eq!(zadd_result, 3);
+    assert_eq!(
+        first.join().unwrap(),
+        Some(("zset2".to_string(), "a".to_string(), 1.0))
+    );
+    assert_eq!(
+        second.join().unwrap(),
+        Some(("zset2".to_string(), "c".to_string(), 3.0))
+    );
+    let zrange_result: Vec<String> = con.zrange("zset2", 0, -1).unwrap();
+    assert_eq!(zrange_result, ["b"]);
+
+    // ZINCRBY also serves the blocked clients, and the key is removed along with the last member
+    let blocked = spawn_blocking_zpop(&test_server.port, "BZPOPMIN", &["zset3"]);
+    thread::sleep(Duration::from_millis(200));
+    let _: f64 = redis::cmd("ZINCRBY")
+        .arg(&["zset3", "5", "m"])
+        .query(con)
+        .unwrap();
+    assert_eq!(
+        blocked.join().unwrap(),
+        Some(("zset3".to_string(), "m".to_string(), 5.0))
+    );
+    let exists: bool = con.exists("zset3").unwrap();
+    assert!(!exists);
+}
@@ -0,0 +1,487 @@
+use redis::Commands;
+
+mod utils;
+
+use utils::send_raw;
+
+fn zadd(con: &mut redis::Connection, args: &[&str]) -> redis::RedisResult<usize> {
+    redis::cmd("ZADD").arg(args).query(con)
+}
+
+fn zrange(con: &mut redis::Connection, args: &[&str]) -> Vec<String> {
+    redis::cmd("ZRANGE").arg(args).query(con).unwrap()
+}
+
+#[test]
+fn test_zadd_zrange() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // Reply is the number of new members
+    let zadd_result = zadd(con, &["board", "10", "alice", "5", "bob"]).unwrap();
+    assert_eq!(zadd_result, 2);
+    let zadd_result = zadd(con, &["board", "7.5", "carol", "5", "bob"]).unwrap();
+    assert_eq!(zadd_result, 1);
+
+    assert_eq!(
+        zrange(con, &["board", "0", "-1"]),
+        ["bob", "carol", "alice"]
+    );
+    assert_eq!(
+        zrange(con, &["board", "0", "-1", "WITHSCORES"]),
+        ["bob", "5", "carol", "7.5", "alice", "10"]
+    );
+    assert_eq!(zrange(con, &["board", "0", "1", "REV"]), ["alice", "carol"]);
+    assert_eq!(
+        zrange(con, &["board", "-1", "-1", "REV", "WITHSCORES"]),
+        ["bob", "5"]
+    );
+    // Out of range indices are clamped like LRANGE
+    assert_eq!(zrange(con, &["board", "1", "100"]), ["carol", "alice"]);
+    assert_eq!(zrange(con, &["board", "5", "10"]), Vec::<String>::new());
+    assert_eq!(zrange(con, &["board", "2", "1"]), Vec::<String>::new());
+    assert_eq!(zrange(con, &["missing", "0", "-1"]), Vec::<String>::new());
+
+    let zscore_result: Option<f64> = con.zscore("board", "carol").unwrap();
+    assert_eq!(zscore_result, Some(7.5));
+    let zscore_result: Option<f64> = con.zscore("board", "missing").unwrap();
+    assert_eq!(zscore_result, None);
+
+    let zrank_result: Option<usize> = con.zrank("board", "alice").unwrap();
+    assert_eq!(zrank_result, Some(2));
+    let zrevrank_result: Option<usize> = con.zrevrank("board", "alice").unwrap();
+    assert_eq!(zrevrank_result, Some(0));
+    let zrank_result: Option<usize> = con.zrank("board", "missing").unwrap();
+    assert_eq!(zrank_result, None);
+    let zrank_result: (usize, f64) = redis::cmd("ZRANK")
+        .arg(&["board", "carol", "WITHSCORE"])
+        .query(con)
+        .unwrap();
+    assert_eq!(zrank_result, (1, 7.5));
+}
+
+#[test]
+fn test_zadd_rescore_moves_rank() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    zadd(con, &["board", "1", "a", "2", "b", "3", "c", "4", "d"]).unwrap();
+
+    // Re-scoring an existing member is not counted as an addition
+    let zadd_result = zadd(con, &["board", "3.5", "a"]).unwrap();
+    assert_eq!(zadd_result, 0);
+    assert_eq!(zrange(con, &["board", "0", "-1"]), ["b", "c", "a", "d"]);
+    let zrank_result: Option<usize> = con.zrank("board", "a").unwrap();
+    assert_eq!(zrank_result, Some(2));
+    let zscore_result: f64 = con.zscore("board", "a").unwrap();
+    assert_eq!(zscore_result, 3.5);
+
+    // Moving to the top and then to the bottom
+    zadd(con, &["board", "100", "b"]).unwrap();
+    assert_eq!(zrange(con, &["board", "0", "-1"]), ["c", "a", "d", "b"]);
+    zadd(con, &["board", "-inf", "d"]).unwrap();
+    assert_eq!(zrange(con, &["board", "0", "-1"]), ["d", "c", "a", "b"]);
+}
+
+#[test]
+fn test_zadd_ties_ordered_by_member() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    zadd(con, &["board", "1", "b", "1", "c", "1", "a", "0", "z"]).unwrap();
+    assert_eq!(zrange(con, &["board", "0", "-1"]), ["z", "a", "b", "c"]);
+
+    // Re-scoring into a tie places the member by its name
+    zadd(con, &["board", "1", "z"]).unwrap();
+    assert_eq!(zrange(con, &["board", "0", "-1"]), ["a", "b", "c", "z"]);
+    zadd(con, &["board", "1", "aa"]).unwrap();
+    assert_eq!(
+        zrange(con, &["board", "0", "-1"]),
+        ["a", "aa", "b", "c", "z"]
+    );
+}
+
+#[test]
+fn test_zadd_options() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    zadd(con, &["board", "10", "a"]).unwrap();
+
+    // NX never updates
+    let zadd_result = zadd(con, &["board", "NX", "20", "a", "5", "b"]).unwrap();
+    assert_eq!(zadd_result, 1);
+    let zscore_result: f64 = con.zscore("board", "a").unwrap();
+    assert_eq!(zscore_result, 10.0);
+
+    // XX never adds
+    let zadd_result = zadd(con, &["board", "XX", "CH", "20", "a", "5", "c"]).unwrap();
+    assert_eq!(zadd_result, 1);
+    let zscore_result: f64 = con.zscore("board", "a").unwrap();
+    assert_eq!(zscore_result, 20.0);
+    let zscore_result: Option<f64> = con.zscore("board", "c").unwrap();
+    assert_eq!(zscore_result, None);
+
+    // GT/LT only restrict updates, new members are still added
+    let zadd_result = zadd(con, &["board", "GT", "CH", "15", "a", "1", "d"]).unwrap();
+    assert_eq!(zadd_result, 1);
+    let zscore_result: f64 = con.zscore("board", "a").unwrap();
+    assert_eq!(zscore_result, 20.0);
+    let zadd_result = zadd(con, &["board", "LT", "CH", "15", "a"]).unwrap();
+    assert_eq!(zadd_result, 1);
+    let zscore_result: f64 = con.zscore("board", "a").unwrap();
+    assert_eq!(zscore_result, 15.0);
+
+    // CH doesn't count a member whose score didn't change
+    let zadd_result = zadd(con, &["board", "CH", "15", "a"]).unwrap();
+    assert_eq!(zadd_result, 0);
+
+    // XX on a missing key doesn't create it
+    zadd(con, &["other", "XX", "1", "a"]).unwrap();
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 1);
+}
+
+#[test]
+fn test_zset_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let cases: &[(&[&str], &str)] = &[
+        (
+            &["board", "NX", "XX", "1", "a"],
+            "XX and NX options at the same time are not compatible",
+        ),
+        (
+            &["board", "GT", "LT", "1", "a"],
+            "GT, LT, and/or NX options at the same time are not compatible",
+        ),
+        (
+            &["board", "NX", "GT", "1", "a"],
+            "GT, LT, and/or NX options at the same time are not compatible",
+        ),
+        (&["board", "1", "a", "2"], "syntax error"),
+        (&["board", "abc", "a"], "value is not a valid float"),
+        (&["board", "nan", "a"], "value is not a valid float"),
+        // Nothing is added when any score is invalid
+        (&["board", "1", "a", "x", "b"], "value is not a valid float"),
+    ];
+    for &(args, message) in cases {
+        let err = zadd(con, args).unwrap_err();
+        assert_eq!(err.code(), Some("ERR"), "{args:?}");
+        assert_eq!(err.detail(), Some(message), "{args:?}");
+    }
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, 0);
+
+    let _: () = con.set("string", "value").unwrap();
+    assert_eq!(
+        zadd(con, &["string", "1", "a"]).unwrap_err().code(),
+        Some("WRONGTYPE")
+    );
+    let err = con.zscore::<_, _, Option<f64>>("string", "a").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = con.zrank::<_, _, Option<usize>>("string", "a").unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+    let err = redis::cmd("ZRANGE")
+        .arg(&["string", "0", "-1"])
+        .query::<Vec<String>>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
+
+#[test]
+fn test_zset_resp3() {
+    let test_server = utils::start_server_and_get_connection();
+
+    let reply = send_raw(
+        &test_server.port,
+        &[b"ZADD board 1.5 a\r\nHELLO 3\r\nZSCORE board a\r\nZRANGE board 0 -1 WITHSCORES\r\n"],
+    );
+    let reply = String::from_utf8(reply).unwrap();
+    // Scores are doubles and every member is paired with its score
+    assert!(
+        reply.ends_with(",1.5\r\n*1\r\n*2\r\n$1\r\na\r\n,1.5\r\n"),
+        "{reply}"
+    );
+
+    // Infinite scores are spelled like Redis does in both protocols, and finite ones in their shortest form
+    let reply = send_raw(
+        &test_server.port,
+        &[b"ZADD scores +inf a -inf b 0.1 c\r\nZSCORE scores a\r\nZSCORE scores b\r\nZINCRBY scores 0.2 c\r\nHELLO 3\r\nZSCORE scores a\r\nZSCORE scores b\r\n"],
+    );
+    let reply = String::from_utf8(reply).unwrap();
+    assert!(
+        reply.starts_with(":3\r\n$3\r\ninf\r\n$4\r\n-inf\r\n$19\r\n0.30000000000000004\r\n"),
+        "{reply}"
+    );
+    assert!(reply.ends_with(",inf\r\n,-inf\r\n"), "{reply}");
+}
+
+fn query(
+    con: &mut redis::Connection,
+    command: &str,
+    args: &[&str],
+) -> redis::RedisResult<Vec<String>> {
+    redis::cmd(command).arg(args).query(con)
+}
+
+#[test]
+fn test_zrangebyscore() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    zadd(
+        con,
+        &["zset", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e"],
+    )
+    .unwrap();
+
+    // (min, max, expected)
+    let cases: &[(&str, &str, &[&str])] = &[
+        ("-inf", "+inf", &["a", "b", "c", "d", "e"]),
+        ("2", "4", &["b", "c", "d"]),
+        ("(2", "4", &["c", "d"]),
+        ("2", "(4", &["b", "c"]),
+        ("(2", "(3", &[]),
+        ("(1", "+inf", &["b", "c", "d", "e"]),
+        ("4", "2", &[]),
+    ];
+    for &(min, max, expected) in cases {
+        let members = query(con, "ZRANGEBYSCORE", &["zset", min, max]).unwrap();
+        assert_eq!(members, expected, "ZRANGEBYSCORE zset {min} {max}");
+        let mut reversed = expected.to_vec();
+        reversed.reverse();
+        let members = query(con, "ZREVRANGEBYSCORE", &["zset", max, min]).unwrap();
+        assert_eq!(members, reversed, "ZREVRANGEBYSCORE zset {max} {min}");
+    }
+
+    // LIMIT applies after filtering
+    let members = query(
+        con,
+        "ZRANGEBYSCORE",
+        &["zset", "(1", "inf", "LIMIT", "1", "2"],
+    )
+    .unwrap();
+    assert_eq!(members, ["c", "d"]);
+    let members = query(
+        con,
+        "ZREVRANGEBYSCORE",
+        &["zset", "+inf", "-inf", "LIMIT", "1", "-1"],
+    )
+    .unwrap();
+    assert_eq!(members, ["d", "c", "b", "a"]);
+    let members = query(
+        con,
+        "ZRANGEBYSCORE",
+        &["zset", "-inf", "+inf", "LIMIT", "-1", "2"],
+    )
+    .unwrap();
+    assert!(members.is_empty());
+    let members = query(con, "ZRANGEBYSCORE", &["zset", "4", "5", "WITHSCORES"]).unwrap();
+    assert_eq!(members, ["d", "4", "e", "5"]);
+
+    let err = query(con, "ZRANGEBYSCORE", &["zset", "(a", "5"]).unwrap_err();
+    assert_eq!(err.detail(), Some("min or max is not a float"));
+    let err = query(con, "ZRANGEBYSCORE", &["zset", "1", "5", "LIMIT", "1"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let members = query(con, "ZRANGEBYSCORE", &["missing", "-inf", "+inf"]).unwrap();
+    assert!(members.is_empty());
+}
+
+#[test]
+fn test_zrangebylex() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    zadd(
+        con,
+        &["zset", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e"],
+    )
+    .unwrap();
+
+    // (min, max, expected)
+    let cases: &[(&str, &str, &[&str])] = &[
+        ("-", "+", &["a", "b", "c", "d", "e"]),
+        ("[b", "[d", &["b", "c", "d"]),
+        ("(b", "[d", &["c", "d"]),
+        ("[b", "(d", &["b", "c"]),
+        ("(b", "(c", &[]),
+        ("[bb", "+", &["c", "d", "e"]),
+        ("-", "(c", &["a", "b"]),
+        ("+", "-", &[]),
+    ];
+    for &(min, max, expected) in cases {
+        let members = query(con, "ZRANGEBYLEX", &["zset", min, max]).unwrap();
+        assert_eq!(members, expected, "ZRANGEBYLEX zset {min} {max}");
+        let mut reversed = expected.to_vec();
+        reversed.reverse();
+        let members = query(con, "ZREVRANGEBYLEX", &["zset", max, min]).unwrap();
+        assert_eq!(members, reversed, "ZREVRANGEBYLEX zset {max} {min}");
+    }
+
+    let members = query(con, "ZRANGEBYLEX", &["zset", "-", "+", "LIMIT", "3", "10"]).unwrap();
+    assert_eq!(members, ["d", "e"]);
+    let members = query(
+        con,
+        "ZREVRANGEBYLEX",
+        &["zset", "+", "-", "LIMIT", "0", "2"],
+    )
+    .unwrap();
+    assert_eq!(members, ["e", "d"]);
+
+    let err = query(con, "ZRANGEBYLEX", &["zset", "b", "+"]).unwrap_err();
+    assert_eq!(err.detail(), Some("min or max not valid string range item"));
+    let err = query(con, "ZRANGEBYLEX", &["zset", "-", "+", "WITHSCORES"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+}
+
+#[test]
+fn test_zincrby() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // The sorted set and the member are created by the first increment
+    let zincrby_result: f64 = redis::cmd("ZINCRBY")
+        .arg(&["zset", "2.5", "a"])
+        .query(con)
+        .unwrap();
+    assert_eq!(zincrby_result, 2.5);
+    zadd(con, &["zset", "3", "b", "4", "c"]).unwrap();
+    assert_eq!(zrange(con, &["zset", "0", "-1"]), ["a", "b", "c"]);
+
+    // The member moves to its new rank
+    let zincrby_result: String = redis::cmd("ZINCRBY")
+        .arg(&["zset", "2", "a"])
+        .query(con)
+        .unwrap();
+    assert_eq!(zincrby_result, "4.5");
+    assert_eq!(zrange(con, &["zset", "0", "-1"]), ["b", "c", "a"]);
+    let zincrby_result: String = redis::cmd("ZINCRBY")
+        .arg(&["zset", "-10", "c"])
+        .query(con)
+        .unwrap();
+    assert_eq!(zincrby_result, "-6");
+    assert_eq!(zrange(con, &["zset", "0", "-1"]), ["c", "b", "a"]);
+
+    let err = redis::cmd("ZINCRBY")
+        .arg(&["zset", "abc", "a"])
+        .query::<f64>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("value is not a valid float"));
+    let _: f64 = redis::cmd("ZINCRBY")
+        .arg(&["zset", "inf", "a"])
+        .query(con)
+        .unwrap();
+    let err = redis::cmd("ZINCRBY")
+        .arg(&["zset", "-inf", "a"])
+        .query::<f64>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("resulting score is not a number (NaN)"));
+}
+
+#[test]
+fn test_zrem_zcard() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    zadd(con, &["zset", "1", "a", "2", "b", "3", "c"]).unwrap();
+    let zcard_result: usize = con.zcard("zset").unwrap();
+    assert_eq!(zcard_result, 3);
+    let zrem_result: usize = con.zrem("zset", &["a", "c", "missing"]).unwrap();
+    assert_eq!(zrem_result, 2);
+    assert_eq!(zrange(con, &["zset", "0", "-1"]), ["b"]);
+
+    // The key is removed along with the last member
+    let zrem_result: usize = con.zrem("zset", "b").unwrap();
+    assert_eq!(zrem_result, 1);
+    let exists: bool = con.exists("zset").unwrap();
+    assert!(!exists);
+    let zcard_result: usize = con.zcard("zset").unwrap();
+    assert_eq!(zcard_result, 0);
+}
+
+#[test]
+fn test_zcount() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    zadd(con, &["zset", "1", "a", "2", "b", "3", "c", "4", "d"]).unwrap();
+
+    // (min, max, expected)
+    let cases = [
+        ("-inf", "+inf", 4),
+        ("2", "3", 2),
+        ("(2", "3", 1),
+        ("(2", "(3", 0),
+        ("(1", "(4", 2),
+        ("3", "2", 0),
+    ];
+    for (min, max, expected) in cases {
+        let zcount_result: usize = redis::cmd("ZCOUNT")
+            .arg(&["zset", min, max])
+            .query(con)
+            .unwrap();
+        assert_eq!(zcount_result, expected, "ZCOUNT zset {min} {max}");
+    }
+    let zcount_result: usize = redis::cmd("ZCOUNT")
+        .arg(&["missing", "-inf", "+inf"])
+        .query(con)
+        .unwrap();
+    assert_eq!(zcount_result, 0);
+    let err = redis::cmd("ZCOUNT")
+        .arg(&["zset", "(", "1"])
+        .query::<usize>(con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("min or max is not a float"));
+}
+
+#[test]
+fn test_zintercard() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let zintercard = |con: &mut redis::Connection, args: &[&str]| -> redis::RedisResult<usize> {
+        redis::cmd("ZINTERCARD").arg(args).query(con)
+    };
+    zadd(con, &["zset1", "1", "a", "2", "b", "3", "c", "4", "d"]).unwrap();
+    // The members are intersected whatever their scores
+    zadd(con, &["zset2", "30", "c", "40", "d", "50", "e"]).unwrap();
+
+    assert_eq!(zintercard(con, &["2", "zset1", "zset2"]).unwrap(), 2);
+    assert_eq!(zintercard(con, &["1", "zset1"]).unwrap(), 4);
+    assert_eq!(zintercard(con, &["2", "zset1", "missing"]).unwrap(), 0);
+    assert_eq!(
+        zintercard(con, &["2", "zset1", "zset2", "LIMIT", "1"]).unwrap(),
+        1
+    );
+    assert_eq!(
+        zintercard(con, &["2", "zset1", "zset2", "LIMIT", "0"]).unwrap(),
+        2
+    );
+    let _: usize = con
+        .zadd_multiple("large1", &(0..1000).map(|i| (i, i)).collect::<Vec<_>>())
+        .unwrap();
+    let _: usize = con
+        .zadd_multiple("large2", &(500..1000).map(|i| (-i, i)).collect::<Vec<_>>())
+        .unwrap();
+    assert_eq!(zintercard(con, &["2", "large1", "large2"]).unwrap(), 500);
+    assert_eq!(
+        zintercard(con, &["2", "large1", "large2", "limit", "7"]).unwrap(),
+        7
+    );
+
+    let err = zintercard(con, &["0", "zset1"]).unwrap_err();
+    assert_eq!(err.detail(), Some("numkeys should be greater than 0"));
+    let err = zintercard(con, &["3", "zset1", "zset2"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Number of keys can't be greater than number of args")
+    );
+    let err = zintercard(con, &["1", "zset1", "LIMIT", "-1"]).unwrap_err();
+    assert_eq!(err.detail(), Some("LIMIT can't be negative"));
+    let err = zintercard(con, &["1", "zset1", "zset2"]).unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let _: () = con.set("string", "value").unwrap();
+    let err = zintercard(con, &["2", "zset1", "string"]).unwrap_err();
+    assert_eq!(err.code(), Some("WRONGTYPE"));
+}
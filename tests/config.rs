@@ -0,0 +1,258 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+// Send CONFIG with the given arguments
+fn config<T: redis::FromRedisValue>(
+    con: &mut redis::Connection,
+    args: &[&str],
+) -> redis::RedisResult<T> {
+    redis::cmd("CONFIG").arg(args).query(con)
+}
+
+#[test]
+fn test_config_get() {
+    let dir = utils::create_temp_dir("config-get");
+    let port = utils::find_free_tcp_port().to_string();
+    let _server = utils::start_server_with_args(&[
+        &port,
+        "--dir",
+        dir.to_str().unwrap(),
+        "--dbfilename",
+        "test.rdb",
+        "--maxmemory",
+        "1kb",
+    ]);
+    let mut con = utils::get_connection(&port);
+
+    let reply: Vec<String> = config(&mut con, &["GET", "dbfilename"]).unwrap();
+    assert_eq!(reply, ["dbfilename", "test.rdb"]);
+    let reply: Vec<String> = config(&mut con, &["GET", "DIR"]).unwrap();
+    assert_eq!(reply, ["dir", dir.to_str().unwrap()]);
+    let reply: Vec<String> = config(&mut con, &["GET", "maxmemory*"]).unwrap();
+    assert_eq!(
+        reply,
+        ["maxmemory", "1024", "maxmemory-policy", "noeviction"]
+    );
+    let reply: Vec<String> = config(&mut con, &["GET", "append[^f]*", "save"]).unwrap();
+    assert_eq!(reply, ["appendonly", "no", "save", ""]);
+    let reply: Vec<String> = config(&mut con, &["GET", "port", "p?rt"]).unwrap();
+    assert_eq!(reply, ["port", port.as_str()]);
+    let reply: Vec<String> = config(&mut con, &["GET", "no-such-parameter"]).unwrap();
+    assert!(reply.is_empty());
+
+    let err = config::<String>(&mut con, &["GET"]).unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+    let err = config::<String>(&mut con, &["REWRITE"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("unknown subcommand 'REWRITE'. Try CONFIG HELP.")
+    );
+}
+
+#[test]
+fn test_config_set() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let reply: String = config(
+        con,
+        &[
+            "SET",
+            "maxmemory",
+            "100mb",
+            "maxmemory-policy",
+            "allkeys-lru",
+        ],
+    )
+    .unwrap();
+    assert_eq!(reply, "OK");
+    let reply: Vec<String> = config(con, &["GET", "maxmemory*"]).unwrap();
+    assert_eq!(
+        reply,
+        ["maxmemory", "104857600", "maxmemory-policy", "allkeys-lru"]
+    );
+
+    // Either all the parameters are set or none is
+    let err = config::<String>(
+        con,
+        &["SET", "maxmemory", "1gb", "maxmemory-policy", "sometimes"],
+    )
+    .unwrap_err();
+    assert!(err
+        .detail()
+        .unwrap()
+        .starts_with("CONFIG SET failed (possibly related to argument 'maxmemory-policy')"));
+    let reply: Vec<String> = config(con, &["GET", "maxmemory"]).unwrap();
+    assert_eq!(reply, ["maxmemory", "104857600"]);
+
+    let err = config::<String>(con, &["SET", "maxmemory", "lots"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("CONFIG SET failed (possibly related to argument 'maxmemory') - argument must be a memory value")
+    );
+    let err = config::<String>(con, &["SET", "port", "1234"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some(
+            "CONFIG SET failed (possibly related to argument 'port') - can't set immutable config"
+        )
+    );
+    let err = config::<String>(con, &["SET", "no-such-parameter", "1"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Unknown option or number of arguments for CONFIG SET - 'no-such-parameter'")
+    );
+    let err = config::<String>(con, &["SET", "maxmemory"]).unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+}
+
+#[test]
+fn test_config_io_threads() {
+    let port = utils::find_free_tcp_port().to_string();
+    let _server = utils::start_server_with_args(&[&port, "--io-threads", "2"]);
+    let mut con = utils::get_connection(&port);
+
+    let reply: Vec<String> = config(&mut con, &["GET", "io-threads"]).unwrap();
+    assert_eq!(reply, ["io-threads", "2"]);
+    let err = config::<String>(&mut con, &["SET", "io-threads", "4"]).unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some(
+            "CONFIG SET failed (possibly related to argument 'io-threads') - can't set immutable config"
+        )
+    );
+
+    // More clients than threads are served at once, even while some of them are blocked
+    let blocked: Vec<_> = (0..8)
+        .map(|i| {
+            let mut con = utils::get_connection(&port);
+            thread::spawn(move || {
+                let popped: (String, String) = redis::cmd("BLPOP")
+                    .arg(&[format!("list{i}"), "0".to_owned()])
+                    .query(&mut con)
+                    .unwrap();
+                popped.1
+            })
+        })
+        .collect();
+    thread::sleep(Duration::from_millis(200));
+    for i in 0..8 {
+        let _: usize = con.rpush(format!("list{i}"), i).unwrap();
+    }
+    for (i, blocked) in blocked.into_iter().enumerate() {
+        assert_eq!(blocked.join().unwrap(), i.to_string());
+    }
+}
+
+#[test]
+fn test_config_client_output_buffer_limit() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let reply: Vec<String> = config(con, &["GET", "client-output-buffer-limit"]).unwrap();
+    assert_eq!(
+        reply,
+        [
+            "client-output-buffer-limit",
+            "normal 0 0 0 slave 268435456 67108864 60 pubsub 33554432 8388608 60"
+        ]
+    );
+    // Only the given classes change
+    let reply: String = config(
+        con,
+        &[
+            "SET",
+            "client-output-buffer-limit",
+            "pubsub 1mb 512kb 10 replica 1000 0 0",
+        ],
+    )
+    .unwrap();
+    assert_eq!(reply, "OK");
+    let reply: Vec<String> = config(con, &["GET", "client-output-buffer-limit"]).unwrap();
+    assert_eq!(
+        reply,
+        [
+            "client-output-buffer-limit",
+            "normal 0 0 0 slave 1000 0 0 pubsub 1048576 524288 10"
+        ]
+    );
+
+    for (value, detail) in [
+        (
+            "pubsub 1mb 512kb",
+            "Wrong number of arguments in buffer limit configuration.",
+        ),
+        (
+            "master 1mb 512kb 10",
+            "Invalid client class specified in buffer limit configuration.",
+        ),
+        (
+            "pubsub 1mb lots 10",
+            "Error in hard, soft or soft_seconds setting in buffer limit configuration.",
+        ),
+    ] {
+        let err = config::<String>(con, &["SET", "client-output-buffer-limit", value]).unwrap_err();
+        assert_eq!(
+            err.detail(),
+            Some(
+                format!(
+                    "CONFIG SET failed (possibly related to argument 'client-output-buffer-limit') - {detail}"
+                )
+                .as_str()
+            ),
+            "{value}"
+        );
+    }
+}
+
+#[test]
+fn test_config_set_persistence() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let dir = utils::create_temp_dir("config-set");
+
+    // SAVE writes the RDB file to the configured location
+    let reply: String = config(
+        con,
+        &[
+            "SET",
+            "dir",
+            dir.to_str().unwrap(),
+            "dbfilename",
+            "moved.rdb",
+        ],
+    )
+    .unwrap();
+    assert_eq!(reply, "OK");
+    let _: () = con.set("foo", "bar").unwrap();
+    let _: () = redis::cmd("SAVE").query(con).unwrap();
+    assert!(dir.join("moved.rdb").is_file());
+
+    let missing_dir = dir.join("missing");
+    let err = config::<String>(con, &["SET", "dir", missing_dir.to_str().unwrap()]).unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+    let err = config::<String>(con, &["SET", "dbfilename", "../escape.rdb"]).unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+}
+
+#[test]
+fn test_config_set_notify_keyspace_events() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let mut subscriber_con = utils::get_connection(&test_server.port);
+    let mut subscriber = subscriber_con.as_pubsub();
+    subscriber.psubscribe("__keyevent@0__:*").unwrap();
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("ignored", "1").unwrap();
+    let reply: String = config(con, &["SET", "notify-keyspace-events", "Eg$lshzxetK"]).unwrap();
+    assert_eq!(reply, "OK");
+    let reply: Vec<String> = config(con, &["GET", "notify-keyspace-events"]).unwrap();
+    assert_eq!(reply, ["notify-keyspace-events", "AKE"]);
+    let _: () = con.set("foo", "bar").unwrap();
+
+    let message = subscriber.get_message().unwrap();
+    assert_eq!(message.get_channel_name(), "__keyevent@0__:set");
+    assert_eq!(message.get_payload::<String>().unwrap(), "foo");
+}
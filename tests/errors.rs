@@ -0,0 +1,109 @@
+use redis::Value;
+
+mod utils;
+
+// Full error message replied to the command
+fn error(con: &mut redis::Connection, args: &[&str]) -> String {
+    let err = redis::cmd(args[0])
+        .arg(&args[1..])
+        .query::<Value>(con)
+        .unwrap_err();
+    format!("{} {}", err.code().unwrap(), err.detail().unwrap())
+}
+
+#[test]
+fn test_wrong_number_of_arguments() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let cases: &[(&[&str], &str)] = &[
+        (&["SET", "foo"], "set"),
+        (&["get"], "get"),
+        (&["GET", "a", "b"], "get"),
+        (&["LLEN"], "llen"),
+        (&["DBSIZE", "x"], "dbsize"),
+        (&["ECHO"], "echo"),
+        (&["ECHO", "a", "b"], "echo"),
+        (&["HSET", "h", "f"], "hset"),
+        // The arity allows these, but the arguments don't go in pairs
+        (&["HSET", "h", "f", "v", "g"], "hset"),
+        (&["ZRANGE", "z", "0"], "zrange"),
+        (&["BLPOP", "list"], "blpop"),
+        (&["SADD", "s"], "sadd"),
+        (&["ZADD", "z", "1"], "zadd"),
+        (&["WAIT", "1"], "wait"),
+        (&["CONFIG", "GET"], "config|get"),
+        (&["OBJECT", "ENCODING"], "object|encoding"),
+    ];
+    for &(args, name) in cases {
+        assert_eq!(
+            error(con, args),
+            format!("ERR wrong number of arguments for '{name}' command"),
+            "{args:?}"
+        );
+    }
+
+    // The same errors are replied inside a transaction, where the command is rejected right away
+    let _: () = redis::cmd("MULTI").query(con).unwrap();
+    assert_eq!(
+        error(con, &["SET", "foo"]),
+        "ERR wrong number of arguments for 'set' command"
+    );
+    let err = redis::cmd("EXEC").query::<Value>(con).unwrap_err();
+    assert_eq!(err.code(), Some("EXECABORT"));
+    // The arguments of a queued HSET only turn out to be wrong when it runs
+    let _: () = redis::cmd("MULTI").query(con).unwrap();
+    let _: () = redis::cmd("HSET")
+        .arg(&["h", "f", "v", "g"])
+        .query(con)
+        .unwrap();
+    let replies: Vec<Value> = redis::cmd("EXEC").query(con).unwrap();
+    assert_eq!(
+        replies,
+        [Value::ServerError(
+            "ERR wrong number of arguments for 'hset' command".to_owned()
+        )]
+    );
+}
+
+#[test]
+fn test_unknown_command() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    assert_eq!(
+        error(con, &["FOO"]),
+        "ERR unknown command 'FOO', with args beginning with: "
+    );
+    assert_eq!(
+        error(con, &["foo", "a", "b c"]),
+        "ERR unknown command 'foo', with args beginning with: 'a' 'b c' "
+    );
+    // The quoted arguments are cut after 128 characters
+    let long_arg = "x".repeat(200);
+    assert_eq!(
+        error(con, &["foo", "abc", &long_arg, "never"]),
+        format!(
+            "ERR unknown command 'foo', with args beginning with: 'abc' '{}' ",
+            "x".repeat(122)
+        )
+    );
+}
+
+#[test]
+fn test_line_breaks_in_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    // The line breaks sent by the client are replaced, so they can't end the error and inject a reply of their own
+    assert_eq!(
+        error(con, &["foo\r\n+OK", "a\nb"]),
+        "ERR unknown command 'foo  +OK', with args beginning with: 'a b' "
+    );
+    assert_eq!(
+        error(con, &["CLIENT", "x\r\n:1"]),
+        "ERR unknown subcommand 'x  :1'. Try CLIENT HELP."
+    );
+    let pong: String = redis::cmd("PING").query(con).unwrap();
+    assert_eq!(pong, "PONG");
+}
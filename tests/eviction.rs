@@ -0,0 +1,190 @@
+use redis::Commands;
+
+mod utils;
+
+// Limit the memory used and pick the eviction policy
+fn set_maxmemory(con: &mut redis::Connection, maxmemory: &str, policy: &str) {
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "maxmemory", maxmemory, "maxmemory-policy", policy])
+        .query(con)
+        .unwrap();
+}
+
+// Set the key to expire in the given number of seconds
+fn set_with_ttl(con: &mut redis::Connection, key: impl redis::ToRedisArgs, val: &str, ttl: u64) {
+    let _: () = redis::cmd("SET")
+        .arg(key)
+        .arg(val)
+        .arg("EX")
+        .arg(ttl)
+        .query(con)
+        .unwrap();
+}
+
+#[test]
+fn test_noeviction() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    set_maxmemory(con, "1kb", "noeviction");
+
+    let val = "x".repeat(100);
+    let mut stored = 0;
+    let err = loop {
+        match con.set::<_, _, ()>(format!("key{stored}"), &val) {
+            Ok(()) => stored += 1,
+            Err(err) => break err,
+        }
+        assert!(stored < 100, "the memory limit is never reached");
+    };
+    assert_eq!(err.code(), Some("OOM"));
+    assert_eq!(
+        err.detail(),
+        Some("command not allowed when used memory > 'maxmemory'.")
+    );
+    // Nothing was evicted, and the commands which don't use more memory still run
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert_eq!(dbsize, stored);
+    let get_result: String = con.get("key0").unwrap();
+    assert_eq!(get_result, val);
+    let err = con.rpush::<_, _, i64>("list", "a").unwrap_err();
+    assert_eq!(err.code(), Some("OOM"));
+    let err = redis::pipe()
+        .atomic()
+        .cmd("GET")
+        .arg("key0")
+        .cmd("SET")
+        .arg(&["other", "a"])
+        .query::<redis::Value>(con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("OOM"));
+    let del_result: i64 = con.del(&["key0", "key1"]).unwrap();
+    assert_eq!(del_result, 2);
+    let _: () = con.set("other", "a").unwrap();
+
+    // No limit at all
+    set_maxmemory(con, "0", "noeviction");
+    for i in 0..100 {
+        let _: () = con.set(format!("unlimited{i}"), &val).unwrap();
+    }
+}
+
+#[test]
+fn test_allkeys_lru() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    set_maxmemory(con, "1kb", "allkeys-lru");
+
+    let val = "x".repeat(100);
+    let _: () = con.set("hot", &val).unwrap();
+    for i in 0..50 {
+        let _: String = con.get("hot").unwrap();
+        let _: () = con.set(format!("key{i}"), &val).unwrap();
+    }
+    // The key read before every write is never the least recently used one
+    let exists_result: bool = con.exists("hot").unwrap();
+    assert!(exists_result);
+    let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+    assert!((2..10).contains(&dbsize), "{dbsize}");
+    let exists_result: bool = con.exists("key49").unwrap();
+    assert!(exists_result);
+}
+
+#[test]
+fn test_allkeys_random() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    set_maxmemory(con, "1kb", "allkeys-random");
+
+    let val = "x".repeat(100);
+    for i in 0..50 {
+        let _: () = con.set(format!("key{i}"), &val).unwrap();
+        let _: () = redis::cmd("SELECT").arg(i % 2).query(con).unwrap();
+    }
+    // The limit is on the memory used by all the databases
+    let mut dbsize = 0;
+    for db in 0..2 {
+        let _: () = redis::cmd("SELECT").arg(db).query(con).unwrap();
+        let db_dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+        dbsize += db_dbsize;
+    }
+    assert!((1..10).contains(&dbsize), "{dbsize}");
+}
+
+#[test]
+fn test_volatile_policies() {
+    for policy in ["volatile-lru", "volatile-ttl", "volatile-random"] {
+        let mut test_server = utils::start_server_and_get_connection();
+        let con = &mut test_server.connection;
+        set_maxmemory(con, "1kb", policy);
+
+        let val = "x".repeat(100);
+        for i in 0..3 {
+            let _: () = con.set(format!("persistent{i}"), &val).unwrap();
+        }
+        for i in 0..30 {
+            set_with_ttl(con, format!("volatile{i}"), &val, 100);
+        }
+        // Only the keys with a TTL are evicted
+        for i in 0..3 {
+            let exists_result: bool = con.exists(format!("persistent{i}")).unwrap();
+            assert!(exists_result, "{policy}");
+        }
+        let dbsize: usize = redis::cmd("DBSIZE").query(con).unwrap();
+        assert!((4..10).contains(&dbsize), "{policy}: {dbsize}");
+
+        // Once there is no key with a TTL left to evict, it's out of memory
+        let mut stored = 0;
+        let err = loop {
+            match con.set::<_, _, ()>(format!("more{stored}"), &val) {
+                Ok(()) => stored += 1,
+                Err(err) => break err,
+            }
+            assert!(stored < 100, "{policy}: the memory limit is never reached");
+        };
+        assert_eq!(err.code(), Some("OOM"), "{policy}");
+    }
+}
+
+#[test]
+fn test_volatile_ttl_evicts_the_shortest_ttl() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    let val = "x".repeat(100);
+    set_with_ttl(con, "long", &val, 1000);
+    for i in 0..4 {
+        set_with_ttl(con, format!("short{i}"), &val, 10 + i);
+    }
+    set_maxmemory(con, "1kb", "volatile-ttl");
+    for i in 0..20 {
+        set_with_ttl(con, format!("key{i}"), &val, 500);
+    }
+    for i in 0..4 {
+        let exists_result: bool = con.exists(format!("short{i}")).unwrap();
+        assert!(!exists_result);
+    }
+    let exists_result: bool = con.exists("long").unwrap();
+    assert!(exists_result);
+}
+
+#[test]
+fn test_eviction_notification() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "notify-keyspace-events", "Ee"])
+        .query(con)
+        .unwrap();
+    let mut subscriber = utils::get_connection(&test_server.port);
+    let mut pubsub = subscriber.as_pubsub();
+    pubsub.subscribe("__keyevent@0__:evicted").unwrap();
+
+    let _: () = con.set("cold", "x".repeat(500)).unwrap();
+    set_maxmemory(con, "1kb", "allkeys-lru");
+    let _: () = con.set("new", "x".repeat(500)).unwrap();
+    // Keys are evicted before running a write, once the limit was exceeded by the previous one
+    let _: () = con.set("trigger", "a").unwrap();
+    let message = pubsub.get_message().unwrap();
+    let payload: String = message.get_payload().unwrap();
+    assert_eq!(payload, "cold");
+}
@@ -0,0 +1,91 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+// Whether the connection still gets replies from the server
+fn is_connected(con: &mut redis::Connection) -> bool {
+    redis::cmd("PING").query::<String>(con).is_ok()
+}
+
+#[test]
+fn test_idle_timeout_config() {
+    let port = utils::find_free_tcp_port().to_string();
+    let _server = utils::start_server_with_args(&[&port, "--tcp-keepalive", "60"]);
+    let mut con = utils::get_connection(&port);
+
+    let reply: Vec<String> = redis::cmd("CONFIG")
+        .arg(&["GET", "t*"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(reply, ["timeout", "0", "tcp-keepalive", "60"]);
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "timeout", "10", "tcp-keepalive", "0"])
+        .query(&mut con)
+        .unwrap();
+    let reply: Vec<String> = redis::cmd("CONFIG")
+        .arg(&["GET", "t*"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(reply, ["timeout", "10", "tcp-keepalive", "0"]);
+
+    let err = redis::cmd("CONFIG")
+        .arg(&["SET", "timeout", "-1"])
+        .query::<()>(&mut con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("CONFIG SET failed (possibly related to argument 'timeout') - argument must be between 0 and 2147483647 inclusive")
+    );
+}
+
+#[test]
+fn test_idle_clients_are_disconnected() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "timeout", "1"])
+        .query(con)
+        .unwrap();
+
+    let mut idle_con = utils::get_connection(&test_server.port);
+    let mut busy_con = utils::get_connection(&test_server.port);
+    let mut subscriber_con = utils::get_connection(&test_server.port);
+    let mut subscriber = subscriber_con.as_pubsub();
+    subscriber.subscribe("channel").unwrap();
+    let mut blocked_con = utils::get_connection(&test_server.port);
+    let blocked = thread::spawn(move || {
+        let popped: Option<(String, String)> = redis::cmd("BLPOP")
+            .arg(&["list", "0"])
+            .query(&mut blocked_con)
+            .unwrap();
+        popped
+    });
+
+    // A client sending commands more often than the timeout is never idle
+    for _ in 0..6 {
+        thread::sleep(Duration::from_millis(300));
+        assert!(is_connected(&mut busy_con));
+    }
+    assert!(!is_connected(&mut idle_con));
+
+    // The clients blocked by a command or waiting for messages aren't idle
+    let _: usize = busy_con.rpush("list", "a").unwrap();
+    assert_eq!(
+        blocked.join().unwrap(),
+        Some(("list".to_string(), "a".to_string()))
+    );
+    let _: usize = busy_con.publish("channel", "message").unwrap();
+    let message = subscriber.get_message().unwrap();
+    let payload: String = message.get_payload().unwrap();
+    assert_eq!(payload, "message");
+
+    // Without a timeout, idle clients stay connected
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "timeout", "0"])
+        .query(&mut busy_con)
+        .unwrap();
+    let mut idle_con = utils::get_connection(&test_server.port);
+    thread::sleep(Duration::from_millis(1500));
+    assert!(is_connected(&mut idle_con));
+}
@@ -0,0 +1,116 @@
+use redis::Commands;
+use std::{thread, time::Duration};
+
+mod utils;
+
+// Start a server publishing the given classes of keyspace events
+fn start_server_with_events(flags: &str) -> (utils::ChildGuard, String) {
+    let port = utils::find_free_tcp_port().to_string();
+    let server = utils::start_server_with_args(&[&port, "--notify-keyspace-events", flags]);
+    (server, port)
+}
+
+// Read the next messages as channel/payload pairs
+fn read_messages(subscriber: &mut redis::PubSub, count: usize) -> Vec<(String, String)> {
+    (0..count)
+        .map(|_| {
+            let message = subscriber.get_message().unwrap();
+            (
+                message.get_channel_name().to_string(),
+                message.get_payload().unwrap(),
+            )
+        })
+        .collect()
+}
+
+#[test]
+fn test_keyevent_notifications() {
+    let (_server, port) = start_server_with_events("EA");
+    let mut con = utils::get_connection(&port);
+    let mut subscriber_con = utils::get_connection(&port);
+    let mut subscriber = subscriber_con.as_pubsub();
+    subscriber.psubscribe("__key*__:*").unwrap();
+
+    let _: () = redis::cmd("SET")
+        .arg(&["foo", "bar", "EX", "100"])
+        .query(&mut con)
+        .unwrap();
+    let _: i64 = con.incr("counter", 2).unwrap();
+    // Failed writes aren't reported
+    let _ = con.incr::<_, _, i64>("foo", 1).unwrap_err();
+    let _: usize = con.rpush("list", "a").unwrap();
+    let _: String = con.lpop("list", None).unwrap();
+    let _: usize = con.hset("hash", "field", "value").unwrap();
+    let _: usize = con.hdel("hash", "field").unwrap();
+    let _: usize = redis::cmd("ZADD")
+        .arg(&["zset", "1", "a"])
+        .query(&mut con)
+        .unwrap();
+    let _: i64 = con.persist("foo").unwrap();
+    let _: () = redis::cmd("SET")
+        .arg(&["short", "lived", "PX", "50"])
+        .query(&mut con)
+        .unwrap();
+
+    let keyevent = |event: &str, key: &str| (format!("__keyevent@0__:{event}"), key.to_string());
+    assert_eq!(
+        read_messages(&mut subscriber, 14),
+        [
+            keyevent("set", "foo"),
+            keyevent("expire", "foo"),
+            keyevent("incrby", "counter"),
+            keyevent("rpush", "list"),
+            keyevent("lpop", "list"),
+            keyevent("del", "list"),
+            keyevent("hset", "hash"),
+            keyevent("hdel", "hash"),
+            keyevent("del", "hash"),
+            keyevent("zadd", "zset"),
+            keyevent("persist", "foo"),
+            keyevent("set", "short"),
+            keyevent("expire", "short"),
+            // Removed by the active expiry
+            keyevent("expired", "short"),
+        ]
+    );
+}
+
+#[test]
+fn test_keyspace_notifications_of_enabled_classes() {
+    let (_server, port) = start_server_with_events("Kl");
+    let mut con = utils::get_connection(&port);
+    let mut subscriber_con = utils::get_connection(&port);
+    let mut subscriber = subscriber_con.as_pubsub();
+    subscriber.psubscribe("__key*__:*").unwrap();
+
+    // Only the events on lists are published, and only to the keyspace channels
+    let _: () = con.set("foo", "bar").unwrap();
+    let _: usize = con.lpush("list", &["a", "b"]).unwrap();
+    let blocked = thread::spawn(move || {
+        let mut blocked_con = utils::get_connection(&port);
+        let _: (String, String) = redis::cmd("BRPOP")
+            .arg(&["queue", "0"])
+            .query(&mut blocked_con)
+            .unwrap();
+    });
+    thread::sleep(Duration::from_millis(200));
+    let _: usize = con.lpush("queue", "x").unwrap();
+    blocked.join().unwrap();
+
+    let keyspace = |key: &str, event: &str| (format!("__keyspace@0__:{key}"), event.to_string());
+    assert_eq!(
+        read_messages(&mut subscriber, 3),
+        [
+            keyspace("list", "lpush"),
+            // The pushed element is handed over to the blocked client right away
+            keyspace("queue", "lpush"),
+            keyspace("queue", "rpop"),
+        ]
+    );
+}
+
+#[test]
+fn test_invalid_notify_keyspace_events() {
+    let (mut server, _) = start_server_with_events("KEQ");
+    assert!(!server.exit_status().unwrap().success());
+}
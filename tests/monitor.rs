@@ -0,0 +1,119 @@
+use redis::{Commands, Value};
+use std::{
+    io::{BufRead, BufReader, Write},
+    net::TcpStream,
+    time::Duration,
+};
+
+mod utils;
+
+// Connection in monitor mode, reading the streamed lines
+fn start_monitor(port: &str) -> BufReader<TcpStream> {
+    let mut stream = TcpStream::connect(format!("127.0.0.1:{port}")).unwrap();
+    stream
+        .set_read_timeout(Some(Duration::from_secs(5)))
+        .unwrap();
+    stream.write_all(b"MONITOR\r\n").unwrap();
+    let mut monitor = BufReader::new(stream);
+    assert_eq!(read_line(&mut monitor), "+OK");
+    monitor
+}
+
+fn read_line(monitor: &mut BufReader<TcpStream>) -> String {
+    let mut line = String::new();
+    monitor.read_line(&mut line).unwrap();
+    line.trim_end_matches("\r\n").to_owned()
+}
+
+// Streamed line without its timestamp and client address, checking them along the way
+fn read_command(monitor: &mut BufReader<TcpStream>) -> String {
+    let line = read_line(monitor);
+    let line = line.strip_prefix('+').unwrap();
+    let (timestamp, rest) = line.split_once(" [").unwrap();
+    let (seconds, micros) = timestamp.split_once('.').unwrap();
+    assert!(
+        seconds.parse::<u64>().is_ok() && micros.len() == 6,
+        "{line}"
+    );
+    let (client, command) = rest.split_once("] ").unwrap();
+    let (db, addr) = client.split_once(' ').unwrap();
+    assert!(addr.starts_with("127.0.0.1:"), "{line}");
+    format!("{db} {command}")
+}
+
+#[test]
+fn test_monitor() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let mut monitor = start_monitor(&test_server.port);
+    let con = &mut test_server.connection;
+
+    let _: () = con.set("foo", "a \"b\"\n\x01").unwrap();
+    let _: Option<String> = con.get("foo").unwrap();
+    // Secrets are hidden
+    let _ = redis::cmd("HELLO")
+        .arg(&["3", "AUTH", "user", "password"])
+        .query::<Value>(con);
+    // Commands administering the server aren't streamed
+    let _: () = redis::cmd("DEBUG").arg(&["SLEEP", "0"]).query(con).unwrap();
+    let _: () = redis::cmd("SELECT").arg(1).query(con).unwrap();
+    let _: Option<String> = con.get("foo").unwrap();
+    // Commands which can't run aren't streamed either
+    let _ = redis::cmd("NOSUCHCOMMAND").query::<Value>(con);
+    let _: () = redis::cmd("PING").query(con).unwrap();
+    // The commands of a transaction are streamed when it is executed
+    let _: (String,) = redis::pipe()
+        .atomic()
+        .cmd("SET")
+        .arg(&["foo", "bar"])
+        .query(con)
+        .unwrap();
+
+    assert_eq!(
+        read_command(&mut monitor),
+        r#"0 "SET" "foo" "a \"b\"\n\x01""#
+    );
+    assert_eq!(read_command(&mut monitor), r#"0 "GET" "foo""#);
+    assert_eq!(
+        read_command(&mut monitor),
+        r#"0 "HELLO" "3" "AUTH" "(redacted)" "(redacted)""#
+    );
+    assert_eq!(read_command(&mut monitor), r#"0 "SELECT" "1""#);
+    assert_eq!(read_command(&mut monitor), r#"1 "GET" "foo""#);
+    assert_eq!(read_command(&mut monitor), r#"1 "PING""#);
+    assert_eq!(read_command(&mut monitor), r#"1 "MULTI""#);
+    assert_eq!(read_command(&mut monitor), r#"1 "EXEC""#);
+    assert_eq!(read_command(&mut monitor), r#"1 "SET" "foo" "bar""#);
+}
+
+#[test]
+fn test_monitor_mode() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let mut monitor = start_monitor(&test_server.port);
+
+    // Only QUIT and RESET run in monitor mode
+    monitor.get_mut().write_all(b"GET foo\r\n").unwrap();
+    assert_eq!(
+        read_line(&mut monitor),
+        "-ERR Can't execute 'get': only QUIT / RESET are allowed in monitor mode"
+    );
+    monitor
+        .get_mut()
+        .write_all(b"RESET\r\nGET foo\r\n")
+        .unwrap();
+    assert_eq!(read_line(&mut monitor), "+RESET");
+    assert_eq!(read_line(&mut monitor), "$-1");
+    // Nothing is streamed anymore
+    let _: () = test_server.connection.set("foo", "bar").unwrap();
+    monitor.get_mut().write_all(b"PING\r\n").unwrap();
+    assert_eq!(read_line(&mut monitor), "+PONG");
+
+    let err = redis::pipe()
+        .atomic()
+        .cmd("MONITOR")
+        .query::<Value>(&mut test_server.connection)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Command not allowed inside a transaction")
+    );
+}
@@ -0,0 +1,485 @@
+use redis::Commands;
+use std::{collections::HashMap, env, fs, path::Path, thread, time::Duration};
+
+mod utils;
+
+// Options of the servers, saving their keyspace to test.rdb
+const RDB_OPTIONS: &[&str] = &["--dbfilename", "test.rdb"];
+
+#[test]
+fn test_save_and_load() {
+    let dir = utils::create_temp_dir("save");
+
+    {
+        let (_server, mut con) = utils::start_server_in_dir(&dir, RDB_OPTIONS);
+        let _: () = con.set("string", "value").unwrap();
+        let _: () = redis::cmd("SET")
+            .arg(&["volatile", "value", "EX", "100"])
+            .query(&mut con)
+            .unwrap();
+        let _: usize = con.rpush("list", &["a", "b", "c"]).unwrap();
+        let _: usize = con.hset("hash", "field", "value").unwrap();
+        let _: usize = redis::cmd("ZADD")
+            .arg(&["zset", "1.5", "a", "-2", "b"])
+            .query(&mut con)
+            .unwrap();
+        // Enough entries for more than one listpack, the last one with other fields
+        for seq in 1..=150 {
+            let _: String = redis::cmd("XADD")
+                .arg(&["stream", &format!("1-{seq}"), "field", &seq.to_string()])
+                .query(&mut con)
+                .unwrap();
+        }
+        let _: String = redis::cmd("XADD")
+            .arg(&["stream", "2-0", "other", "x", "more", "y"])
+            .query(&mut con)
+            .unwrap();
+        // Keys which are already expired are not saved
+        let _: () = redis::cmd("SET")
+            .arg(&["expired", "value", "PX", "1"])
+            .query(&mut con)
+            .unwrap();
+        thread::sleep(Duration::from_millis(10));
+
+        let save_result: String = redis::cmd("SAVE").query(&mut con).unwrap();
+        assert_eq!(save_result, "OK");
+    }
+    let contents = fs::read(dir.join("test.rdb")).unwrap();
+    assert!(contents.starts_with(b"REDIS0011"));
+
+    // A new server loads the keys from the file
+    let (_server, mut con) = utils::start_server_in_dir(&dir, RDB_OPTIONS);
+    let dbsize: usize = redis::cmd("DBSIZE").query(&mut con).unwrap();
+    assert_eq!(dbsize, 6);
+    let get_result: String = con.get("string").unwrap();
+    assert_eq!(get_result, "value");
+    let ttl: i64 = con.ttl("volatile").unwrap();
+    assert!((98..=100).contains(&ttl), "{ttl}");
+    let ttl: i64 = con.ttl("string").unwrap();
+    assert_eq!(ttl, -1);
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["a", "b", "c"]);
+    let hgetall_result: HashMap<String, String> = con.hgetall("hash").unwrap();
+    assert_eq!(
+        hgetall_result,
+        HashMap::from([("field".to_string(), "value".to_string())])
+    );
+    let zrange_result: Vec<(String, f64)> = redis::cmd("ZRANGE")
+        .arg(&["zset", "0", "-1", "WITHSCORES"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(
+        zrange_result,
+        [("b".to_string(), -2.0), ("a".to_string(), 1.5)]
+    );
+    let xlen: usize = redis::cmd("XLEN").arg("stream").query(&mut con).unwrap();
+    assert_eq!(xlen, 151);
+    type Entries = Vec<(String, Vec<String>)>;
+    let xrange_result: Entries = redis::cmd("XRANGE")
+        .arg(&["stream", "1-150", "+"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(
+        xrange_result,
+        [
+            (
+                "1-150".to_string(),
+                vec!["field".to_string(), "150".to_string()]
+            ),
+            (
+                "2-0".to_string(),
+                ["other", "x", "more", "y"].map(String::from).to_vec()
+            ),
+        ]
+    );
+    // The last ID is kept, so that new entries still come after it
+    let err = redis::cmd("XADD")
+        .arg(&["stream", "2-0", "field", "value"])
+        .query::<String>(&mut con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("The ID specified in XADD is equal or smaller than the target stream top item")
+    );
+}
+
+// Type, value and whether it has a TTL of every key of the current database, in the order of the keys
+fn dataset(con: &mut redis::Connection) -> Vec<(String, String, String, bool)> {
+    let mut keys: Vec<String> = con.keys("*").unwrap();
+    keys.sort();
+    keys.into_iter()
+        .map(|key| {
+            let key_type: String = redis::cmd("TYPE").arg(&key).query(con).unwrap();
+            let value = match key_type.as_str() {
+                "string" => con
+                    .get::<_, Vec<u8>>(&key)
+                    .map(|value| format!("{value:?}")),
+                "list" => con
+                    .lrange::<_, Vec<String>>(&key, 0, -1)
+                    .map(|list| format!("{list:?}")),
+                "hash" => con.hgetall::<_, HashMap<String, String>>(&key).map(|hash| {
+                    let mut fields: Vec<_> = hash.into_iter().collect();
+                    fields.sort();
+                    format!("{fields:?}")
+                }),
+                "set" => con.smembers::<_, Vec<String>>(&key).map(|mut set| {
+                    set.sort();
+                    format!("{set:?}")
+                }),
+                "zset" => con
+                    .zrange_withscores::<_, Vec<(String, f64)>>(&key, 0, -1)
+                    .map(|zset| format!("{zset:?}")),
+                _ => redis::cmd("XRANGE")
+                    .arg(&[&key, "-", "+"])
+                    .query::<Vec<(String, Vec<String>)>>(con)
+                    .map(|entries| format!("{entries:?}")),
+            };
+            let ttl: i64 = con.pttl(&key).unwrap();
+            (key, key_type, value.unwrap(), ttl > 0)
+        })
+        .collect()
+}
+
+#[test]
+fn test_debug_reload() {
+    let dir = utils::create_temp_dir("debug-reload");
+    let (_server, mut con) = utils::start_server_in_dir(&dir, RDB_OPTIONS);
+    let _: () = con.set("string", "value").unwrap();
+    let _: () = con.set("binary", b"\x00\xffbytes").unwrap();
+    let _: () = con.set("integer", 12345).unwrap();
+    let _: () = redis::cmd("SET")
+        .arg(&["volatile", "value", "EX", "100"])
+        .query(&mut con)
+        .unwrap();
+    let _: usize = con.rpush("list", &["a", "b", "c"]).unwrap();
+    let long_list: Vec<String> = (0..200).map(|i| format!("element:{i}")).collect();
+    let _: usize = con.rpush("long_list", &long_list).unwrap();
+    let _: usize = con.hset("hash", "field", "value").unwrap();
+    let _: usize = con.sadd("set", &["x", "y", "z"]).unwrap();
+    let _: usize = con.sadd("intset", &[1, 2, 3]).unwrap();
+    let _: usize = redis::cmd("ZADD")
+        .arg(&["zset", "1.5", "a", "-2", "b", "inf", "c"])
+        .query(&mut con)
+        .unwrap();
+    for seq in 1..=3 {
+        let _: String = redis::cmd("XADD")
+            .arg(&["stream", &format!("1-{seq}"), "field", &seq.to_string()])
+            .query(&mut con)
+            .unwrap();
+    }
+    let _: () = redis::cmd("SELECT").arg(1).query(&mut con).unwrap();
+    let _: () = con.set("other_db", "value").unwrap();
+    let before_other_db = dataset(&mut con);
+    let _: () = redis::cmd("SELECT").arg(0).query(&mut con).unwrap();
+    let before = dataset(&mut con);
+    assert_eq!(before.len(), 11);
+
+    let reload_result: String = redis::cmd("DEBUG").arg("RELOAD").query(&mut con).unwrap();
+    assert_eq!(reload_result, "OK");
+    assert!(dir.join("test.rdb").exists());
+
+    // Every key comes back with the same type, value and TTL
+    assert_eq!(dataset(&mut con), before);
+    let ttl: i64 = con.ttl("volatile").unwrap();
+    assert!((98..=100).contains(&ttl), "{ttl}");
+    let _: () = redis::cmd("SELECT").arg(1).query(&mut con).unwrap();
+    assert_eq!(dataset(&mut con), before_other_db);
+
+    // The keys written after the reload aren't in the file until the next one
+    let _: () = redis::cmd("SELECT").arg(0).query(&mut con).unwrap();
+    let _: () = con.set("new", "value").unwrap();
+    let _: usize = con.del("string").unwrap();
+    let reload_result: String = redis::cmd("DEBUG").arg("RELOAD").query(&mut con).unwrap();
+    assert_eq!(reload_result, "OK");
+    let get_result: Option<String> = con.get("new").unwrap();
+    assert_eq!(get_result.as_deref(), Some("value"));
+    let exists: bool = con.exists("string").unwrap();
+    assert!(!exists);
+
+    let err = redis::cmd("DEBUG")
+        .arg(&["RELOAD", "NOSAVE", "extra"])
+        .query::<String>(&mut con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("wrong number of arguments for 'debug|reload' command")
+    );
+}
+
+#[test]
+fn test_save_and_load_consumer_groups() {
+    let dir = utils::create_temp_dir("save-groups");
+    type PendingEntries = Vec<(String, String, i64, i64)>;
+
+    {
+        let (_server, mut con) = utils::start_server_in_dir(&dir, RDB_OPTIONS);
+        for id in ["1-0", "2-0", "3-0"] {
+            let _: String = redis::cmd("XADD")
+                .arg(&["stream", id, "field", id])
+                .query(&mut con)
+                .unwrap();
+        }
+        let _: () = redis::cmd("XGROUP")
+            .arg(&["CREATE", "stream", "group", "0"])
+            .query(&mut con)
+            .unwrap();
+        let _: redis::Value = redis::cmd("XREADGROUP")
+            .arg(&[
+                "GROUP", "group", "alice", "COUNT", "2", "STREAMS", "stream", ">",
+            ])
+            .query(&mut con)
+            .unwrap();
+        let _: Vec<String> = redis::cmd("XCLAIM")
+            .arg(&[
+                "stream",
+                "group",
+                "bob",
+                "0",
+                "2-0",
+                "RETRYCOUNT",
+                "5",
+                "JUSTID",
+            ])
+            .query(&mut con)
+            .unwrap();
+        // A group of an empty stream, with a consumer which never got anything
+        let _: () = redis::cmd("XGROUP")
+            .arg(&["CREATE", "empty", "other", "$", "MKSTREAM"])
+            .query(&mut con)
+            .unwrap();
+        let _: usize = redis::cmd("XGROUP")
+            .arg(&["CREATECONSUMER", "empty", "other", "carol"])
+            .query(&mut con)
+            .unwrap();
+
+        let save_result: String = redis::cmd("SAVE").query(&mut con).unwrap();
+        assert_eq!(save_result, "OK");
+    }
+
+    let (_server, mut con) = utils::start_server_in_dir(&dir, RDB_OPTIONS);
+    let pending: PendingEntries = redis::cmd("XPENDING")
+        .arg(&["stream", "group", "-", "+", "10"])
+        .query(&mut con)
+        .unwrap();
+    let pending: Vec<_> = pending
+        .into_iter()
+        .map(|(id, consumer, _, delivery_count)| (id, consumer, delivery_count))
+        .collect();
+    assert_eq!(
+        pending,
+        [
+            ("1-0".to_string(), "alice".to_string(), 1),
+            ("2-0".to_string(), "bob".to_string(), 5),
+        ]
+    );
+    // The group still delivers the entries after the last one it delivered
+    let xreadgroup_result: Vec<(String, Vec<(String, Vec<String>)>)> = redis::cmd("XREADGROUP")
+        .arg(&["GROUP", "group", "alice", "STREAMS", "stream", ">"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(
+        xreadgroup_result,
+        [(
+            "stream".to_string(),
+            vec![(
+                "3-0".to_string(),
+                vec!["field".to_string(), "3-0".to_string()]
+            )]
+        )]
+    );
+    let created: usize = redis::cmd("XGROUP")
+        .arg(&["CREATECONSUMER", "empty", "other", "carol"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(created, 0);
+}
+
+#[test]
+fn test_bgsave() {
+    let dir = utils::create_temp_dir("bgsave");
+
+    {
+        let (_server, mut con) = utils::start_server_in_dir(&dir, RDB_OPTIONS);
+        let _: () = con.set("foo", "bar").unwrap();
+        let bgsave_result: String = redis::cmd("BGSAVE").query(&mut con).unwrap();
+        assert_eq!(bgsave_result, "Background saving started");
+
+        // Wait for the background save to finish
+        let rdb_path = dir.join("test.rdb");
+        for _ in 0..50 {
+            if rdb_path.exists() {
+                break;
+            }
+            thread::sleep(Duration::from_millis(20));
+        }
+        assert!(rdb_path.exists());
+        // The temporary file was renamed to the RDB file
+        assert_eq!(fs::read_dir(&dir).unwrap().count(), 1);
+
+        let err = redis::cmd("BGSAVE")
+            .arg("NOW")
+            .query::<String>(&mut con)
+            .unwrap_err();
+        assert_eq!(err.detail(), Some("syntax error"));
+    }
+
+    let (_server, mut con) = utils::start_server_in_dir(&dir, RDB_OPTIONS);
+    let get_result: String = con.get("foo").unwrap();
+    assert_eq!(get_result, "bar");
+}
+
+#[test]
+fn test_save_points() {
+    let dir = utils::create_temp_dir("save-points");
+    let rdb_path = dir.join("test.rdb");
+    let port = utils::find_free_tcp_port().to_string();
+    let _server = utils::start_server_with_args(&[
+        &port,
+        "--dir",
+        dir.to_str().unwrap(),
+        "--dbfilename",
+        "test.rdb",
+        "--save",
+        "1 3",
+    ]);
+    let mut con = utils::get_connection(&port);
+    let config_result: Vec<String> = redis::cmd("CONFIG")
+        .arg(&["GET", "save"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(config_result, ["save", "1 3"]);
+    let started_at: u64 = redis::cmd("LASTSAVE").query(&mut con).unwrap();
+
+    // Not enough changes were made yet, however long ago the server started
+    let _: () = con.set("a", "1").unwrap();
+    let _: () = con.set("b", "2").unwrap();
+    thread::sleep(Duration::from_millis(1500));
+    assert!(!rdb_path.exists());
+    let _: () = con.set("c", "3").unwrap();
+    for _ in 0..50 {
+        if rdb_path.exists() {
+            break;
+        }
+        thread::sleep(Duration::from_millis(20));
+    }
+    assert!(rdb_path.exists());
+    let saved_at: u64 = redis::cmd("LASTSAVE").query(&mut con).unwrap();
+    assert!(saved_at > started_at, "{saved_at} {started_at}");
+
+    // The save points can be changed at runtime, and removed altogether
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "save", ""])
+        .query(&mut con)
+        .unwrap();
+    fs::remove_file(&rdb_path).unwrap();
+    for i in 0..5 {
+        let _: () = con.set("d", i).unwrap();
+    }
+    thread::sleep(Duration::from_millis(1500));
+    assert!(!rdb_path.exists());
+    let err = redis::cmd("CONFIG")
+        .arg(&["SET", "save", "1"])
+        .query::<()>(&mut con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("CONFIG SET failed (possibly related to argument 'save') - Invalid save parameters")
+    );
+}
+
+#[test]
+fn test_start_without_rdb_file() {
+    let dir = utils::create_temp_dir("empty");
+
+    let (server, mut con) = utils::start_server_in_dir(&dir, RDB_OPTIONS);
+    let dbsize: usize = redis::cmd("DBSIZE").query(&mut con).unwrap();
+    assert_eq!(dbsize, 0);
+
+    // Saving an empty store writes a file which can be loaded back
+    let _: () = redis::cmd("SAVE").query(&mut con).unwrap();
+    drop(server);
+    let (_server, mut con) = utils::start_server_in_dir(&dir, RDB_OPTIONS);
+    let dbsize: usize = redis::cmd("DBSIZE").query(&mut con).unwrap();
+    assert_eq!(dbsize, 0);
+}
+
+#[test]
+fn test_load_redis_dump() {
+    let dir = utils::create_temp_dir("fixture");
+    // Laid out like a file written by Redis 7.2: integer encoded aux fields and strings,
+    // an LZF compressed string, expiries in milliseconds and in seconds, an LRU idle time and resizedb hints
+    let fixture =
+        Path::new(&env::var("CARGO_MANIFEST_DIR").unwrap()).join("tests/fixtures/redis7.rdb");
+    fs::copy(fixture, dir.join("test.rdb")).unwrap();
+
+    let (_server, mut con) = utils::start_server_in_dir(&dir, RDB_OPTIONS);
+    // The key whose expiry in seconds is in the past isn't loaded
+    let dbsize: usize = redis::cmd("DBSIZE").query(&mut con).unwrap();
+    assert_eq!(dbsize, 10);
+    let get_result: Option<String> = con.get("stale").unwrap();
+    assert_eq!(get_result, None);
+
+    let cases = [
+        ("greeting", "hello"),
+        ("small_int", "-100"),
+        ("medium_int", "-2000"),
+        ("large_int", "1000000"),
+        ("session", "token"),
+        ("idle", "value"),
+    ];
+    for (key, value) in cases {
+        let get_result: String = con.get(key).unwrap();
+        assert_eq!(get_result, value, "{key}");
+    }
+    let get_result: String = con.get("compressed").unwrap();
+    assert_eq!(get_result, "abc".repeat(17)[..50]);
+    // Integer encoded strings are still integers
+    let incr_result: i64 = con.incr("medium_int", 1).unwrap();
+    assert_eq!(incr_result, -1999);
+
+    // The expiry in milliseconds is at the start of the year 2100
+    let ttl: i64 = con.ttl("session").unwrap();
+    assert!(ttl > 365 * 24 * 60 * 60, "{ttl}");
+    let ttl: i64 = con.ttl("greeting").unwrap();
+    assert_eq!(ttl, -1);
+
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["a", "b", "7"]);
+    let hgetall_result: HashMap<String, String> = con.hgetall("hash").unwrap();
+    assert_eq!(
+        hgetall_result,
+        HashMap::from([
+            ("field".to_string(), "value".to_string()),
+            ("number".to_string(), "42".to_string())
+        ])
+    );
+    let zrange_result: Vec<(String, f64)> = redis::cmd("ZRANGE")
+        .arg(&["zset", "0", "-1", "WITHSCORES"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(
+        zrange_result,
+        [("bob".to_string(), -3.0), ("alice".to_string(), 1.5)]
+    );
+}
+
+#[test]
+fn test_load_unsupported_type() {
+    let dir = utils::create_temp_dir("unsupported");
+    // A hash stored as a listpack, which was added in Redis 7.0, followed by an empty checksum
+    let mut contents = b"REDIS0011\xfe\x00\x10\x04hash".to_vec();
+    contents.extend_from_slice(&[0xFF; 9]);
+    fs::write(dir.join("test.rdb"), contents).unwrap();
+
+    // The server refuses to start instead of losing keys
+    let port = utils::find_free_tcp_port().to_string();
+    let mut server = utils::start_server_with_args(&[
+        &port,
+        "--dir",
+        dir.to_str().unwrap(),
+        "--dbfilename",
+        "test.rdb",
+    ]);
+    let exit_status = server.exit_status().expect("server should have exited");
+    assert!(!exit_status.success());
+}
@@ -0,0 +1,285 @@
+mod utils;
+
+use redis::Commands;
+use std::{
+    io::{Read, Write},
+    net::TcpStream,
+    thread,
+    time::Duration,
+};
+use utils::send_raw;
+
+#[test]
+fn test_command_parsing() {
+    let test_server = utils::start_server_and_get_connection();
+
+    // (name, input, expected reply)
+    let cases: &[(&str, &[u8], &[u8])] = &[
+        ("array", b"*1\r\n$4\r\nPING\r\n", b"+PONG\r\n"),
+        ("lowercase command", b"*1\r\n$4\r\nping\r\n", b"+PONG\r\n"),
+        ("inline", b"PING\r\n", b"+PONG\r\n"),
+        ("inline without CR", b"PING\n", b"+PONG\r\n"),
+        (
+            "inline with extra spaces",
+            b"  ECHO   hey  \r\n",
+            b"$3\r\nhey\r\n",
+        ),
+        ("empty lines are skipped", b"\r\n\r\nPING\r\n", b"+PONG\r\n"),
+        (
+            "binary safe bulk string",
+            b"*2\r\n$4\r\nECHO\r\n$4\r\na\r\nb\r\n",
+            b"$4\r\na\r\nb\r\n",
+        ),
+        (
+            "empty bulk string",
+            b"*2\r\n$4\r\nECHO\r\n$0\r\n\r\n",
+            b"$0\r\n\r\n",
+        ),
+        (
+            "pipelined commands",
+            b"*1\r\n$4\r\nPING\r\n*2\r\n$4\r\nECHO\r\n$3\r\nhey\r\nPING\r\n",
+            b"+PONG\r\n$3\r\nhey\r\n+PONG\r\n",
+        ),
+        (
+            "pipelined set and get",
+            b"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n",
+            b"+OK\r\n$1\r\nv\r\n",
+        ),
+        (
+            "empty array is skipped",
+            b"*0\r\n*1\r\n$4\r\nPING\r\n",
+            b"+PONG\r\n",
+        ),
+    ];
+
+    for &(name, input, expected) in cases {
+        let reply = send_raw(&test_server.port, &[input]);
+        assert_eq!(
+            reply,
+            expected,
+            "case `{name}`: got {:?}",
+            String::from_utf8_lossy(&reply)
+        );
+    }
+}
+
+#[test]
+fn test_command_split_across_reads() {
+    let test_server = utils::start_server_and_get_connection();
+
+    let cases: &[(&str, &[&[u8]], &[u8])] = &[
+        (
+            "split inside length prefix",
+            &[b"*", b"2\r\n$4\r\nEC", b"HO\r\n$3\r\nhey\r\n"],
+            b"$3\r\nhey\r\n",
+        ),
+        (
+            "split inside bulk payload",
+            &[b"*2\r\n$4\r\nECHO\r\n$5\r\nhe", b"llo\r\n"],
+            b"$5\r\nhello\r\n",
+        ),
+        (
+            "split between CR and LF",
+            &[b"*1\r\n$4\r\nPING\r", b"\n"],
+            b"+PONG\r\n",
+        ),
+        ("split inline command", &[b"PI", b"NG\r\n"], b"+PONG\r\n"),
+    ];
+
+    for &(name, chunks, expected) in cases {
+        let reply = send_raw(&test_server.port, chunks);
+        assert_eq!(
+            reply,
+            expected,
+            "case `{name}`: got {:?}",
+            String::from_utf8_lossy(&reply)
+        );
+    }
+}
+
+#[test]
+fn test_malformed_commands_reply_error_and_close_connection() {
+    let test_server = utils::start_server_and_get_connection();
+
+    // The error is replied for the malformed command and the connection is closed, while the commands before it are
+    // still served
+    let cases: &[(&str, &[u8], &[u8])] = &[
+        (
+            "invalid multibulk length",
+            b"*abc\r\n",
+            b"-ERR Protocol error: invalid multibulk length\r\n",
+        ),
+        (
+            "negative multibulk length",
+            b"*-5\r\n",
+            b"-ERR Protocol error: invalid multibulk length\r\n",
+        ),
+        (
+            "invalid bulk length",
+            b"*1\r\n$abc\r\nPING\r\n",
+            b"-ERR Protocol error: invalid bulk length\r\n",
+        ),
+        (
+            "negative bulk length",
+            b"*1\r\n$-5\r\n",
+            b"-ERR Protocol error: invalid bulk length\r\n",
+        ),
+        (
+            "bulk length too short",
+            b"*1\r\n$2\r\nPING\r\n",
+            b"-ERR Protocol error: invalid bulk length\r\n",
+        ),
+        (
+            "null bulk string argument",
+            b"*2\r\n$4\r\nECHO\r\n$-1\r\n",
+            b"-ERR Protocol error: invalid bulk length\r\n",
+        ),
+        (
+            "nested array",
+            b"*1\r\n*1\r\n$4\r\nPING\r\n",
+            b"-ERR Protocol error: expected '$', got '*'\r\n",
+        ),
+        // The client closing the connection in the middle of a command isn't a protocol error
+        ("truncated command", b"*2\r\n$4\r\nECHO\r\n", b""),
+        (
+            "too big inline request",
+            &[b'a'; 70 * 1024],
+            b"-ERR Protocol error: too big inline request\r\n",
+        ),
+        (
+            "unknown type byte in bulk string position",
+            b"*1\r\n+PING\r\n",
+            b"-ERR Protocol error: expected '$', got '+'\r\n",
+        ),
+        (
+            "valid command before malformed one",
+            b"PING\r\n*1\r\n$x\r\nPING\r\n",
+            b"+PONG\r\n-ERR Protocol error: invalid bulk length\r\n",
+        ),
+    ];
+
+    for &(name, input, expected) in cases {
+        let reply = send_raw(&test_server.port, &[input]);
+        assert_eq!(
+            reply,
+            expected,
+            "case `{name}`: got {:?}",
+            String::from_utf8_lossy(&reply)
+        );
+    }
+}
+
+#[test]
+fn test_inline_quoted_arguments() {
+    let test_server = utils::start_server_and_get_connection();
+
+    let cases: &[(&str, &[u8], &[u8])] = &[
+        (
+            "set and get",
+            b"SET foo bar\r\nGET foo\r\n",
+            b"+OK\r\n$3\r\nbar\r\n",
+        ),
+        (
+            "double quotes keep spaces",
+            b"ECHO \"hello world\"\r\n",
+            b"$11\r\nhello world\r\n",
+        ),
+        (
+            "single quotes keep spaces",
+            b"ECHO 'hello world'\r\n",
+            b"$11\r\nhello world\r\n",
+        ),
+        ("empty quoted argument", b"ECHO \"\"\r\n", b"$0\r\n\r\n"),
+        (
+            "quotes inside an argument",
+            b"ECHO foo\"bar baz\"\r\n",
+            b"$10\r\nfoobar baz\r\n",
+        ),
+        (
+            "escapes in double quotes",
+            b"ECHO \"a\\tb\\nc\\\\d\\\"e\"\r\n",
+            b"$9\r\na\tb\nc\\d\"e\r\n",
+        ),
+        (
+            "hex escapes in double quotes",
+            b"ECHO \"\\x41\\x7a\\x00\\xg\"\r\n",
+            b"$5\r\nAz\x00xg\r\n",
+        ),
+        (
+            "escaped quote in single quotes",
+            b"ECHO 'it\\'s \\n'\r\n",
+            b"$7\r\nit's \\n\r\n",
+        ),
+        (
+            "quoted key and value",
+            b"SET \"my key\" 'my value'\r\nGET \"my key\"\r\n",
+            b"+OK\r\n$8\r\nmy value\r\n",
+        ),
+    ];
+
+    for &(name, input, expected) in cases {
+        let reply = send_raw(&test_server.port, &[input]);
+        assert_eq!(
+            reply,
+            expected,
+            "case `{name}`: got {:?}",
+            String::from_utf8_lossy(&reply)
+        );
+    }
+}
+
+#[test]
+fn test_inline_unbalanced_quotes() {
+    let test_server = utils::start_server_and_get_connection();
+
+    // The error is replied and the connection closed, so the commands after it are never run
+    let cases: &[(&str, &[u8])] = &[
+        ("unclosed double quote", b"ECHO \"hello\r\nPING\r\n"),
+        ("unclosed single quote", b"ECHO 'hello\r\nPING\r\n"),
+        ("escaped closing quote", b"ECHO \"hello\\\"\r\nPING\r\n"),
+        (
+            "closing quote followed by a character",
+            b"ECHO \"hello\"world\r\nPING\r\n",
+        ),
+    ];
+
+    for &(name, input) in cases {
+        let reply = send_raw(&test_server.port, &[input]);
+        assert_eq!(
+            reply,
+            b"-ERR Protocol error: unbalanced quotes in request\r\n",
+            "case `{name}`: got {:?}",
+            String::from_utf8_lossy(&reply)
+        );
+    }
+}
+
+#[test]
+fn test_client_not_reading_replies() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let value = "x".repeat(1024 * 1024);
+    let _: () = test_server.connection.set("large", &value).unwrap();
+
+    // The commands of a client which doesn't read its replies are all run, rather than waiting for the replies to be
+    // read, and other clients are served in the meantime
+    let mut client = TcpStream::connect(format!("127.0.0.1:{}", test_server.port)).unwrap();
+    let mut pipeline = b"GET large\r\n".repeat(50);
+    pipeline.extend_from_slice(b"SET done 1\r\n");
+    client.write_all(&pipeline).unwrap();
+    let is_done = (0..50).any(|_| {
+        thread::sleep(Duration::from_millis(100));
+        test_server.connection.exists("done").unwrap()
+    });
+    assert!(is_done);
+
+    // The replies are still all written once it reads them
+    let reply = format!("${}\r\n{value}\r\n", value.len());
+    let mut expected = reply.repeat(50).into_bytes();
+    expected.extend_from_slice(b"+OK\r\n");
+    let mut output = vec![0; expected.len()];
+    client
+        .set_read_timeout(Some(Duration::from_secs(5)))
+        .unwrap();
+    client.read_exact(&mut output).unwrap();
+    assert!(output == expected);
+}
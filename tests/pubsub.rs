@@ -0,0 +1,269 @@
+use redis::Commands;
+use std::{
+    io::{Read, Write},
+    net::TcpStream,
+    thread,
+    time::Duration,
+};
+
+mod utils;
+
+#[test]
+fn test_publish_subscribe() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let mut subscriber_con = utils::get_connection(&test_server.port);
+    let mut other_subscriber_con = utils::get_connection(&test_server.port);
+
+    let mut subscriber = subscriber_con.as_pubsub();
+    subscriber.subscribe(&["news", "sports"]).unwrap();
+    let mut other_subscriber = other_subscriber_con.as_pubsub();
+    other_subscriber.subscribe("news").unwrap();
+
+    let receivers: usize = test_server.connection.publish("news", "hello").unwrap();
+    assert_eq!(receivers, 2);
+    let receivers: usize = test_server.connection.publish("sports", "goal").unwrap();
+    assert_eq!(receivers, 1);
+    let receivers: usize = test_server.connection.publish("weather", "rain").unwrap();
+    assert_eq!(receivers, 0);
+
+    let message = subscriber.get_message().unwrap();
+    assert_eq!(message.get_channel_name(), "news");
+    assert_eq!(message.get_payload::<String>().unwrap(), "hello");
+    let message = subscriber.get_message().unwrap();
+    assert_eq!(message.get_channel_name(), "sports");
+    assert_eq!(message.get_payload::<String>().unwrap(), "goal");
+    let message = other_subscriber.get_message().unwrap();
+    assert_eq!(message.get_channel_name(), "news");
+    assert_eq!(message.get_payload::<String>().unwrap(), "hello");
+
+    subscriber.unsubscribe("news").unwrap();
+    let receivers: usize = test_server.connection.publish("news", "again").unwrap();
+    assert_eq!(receivers, 1);
+
+    // The subscribers are removed once they disconnect
+    drop(other_subscriber_con);
+    thread::sleep(Duration::from_millis(100));
+    let receivers: usize = test_server.connection.publish("news", "again").unwrap();
+    assert_eq!(receivers, 0);
+}
+
+#[test]
+fn test_subscriber_mode() {
+    let test_server = utils::start_server_and_get_connection();
+    let reply = utils::send_raw(
+        &test_server.port,
+        &[b"SUBSCRIBE a b\r\nGET foo\r\nPING\r\nSUBSCRIBE a\r\nUNSUBSCRIBE\r\nGET foo\r\nUNSUBSCRIBE\r\n"],
+    );
+    assert_eq!(
+        String::from_utf8(reply).unwrap(),
+        concat!(
+            "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n",
+            "*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n",
+            "-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n",
+            "*2\r\n$4\r\npong\r\n$0\r\n\r\n",
+            "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:2\r\n",
+            "*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:1\r\n",
+            "*3\r\n$11\r\nunsubscribe\r\n$1\r\nb\r\n:0\r\n",
+            // Subscriber mode ends along with the last subscription
+            "$-1\r\n",
+            "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n",
+        )
+    );
+
+    // Subscribing isn't allowed inside a transaction
+    let reply = utils::send_raw(
+        &test_server.port,
+        &[b"MULTI\r\nSUBSCRIBE a\r\nEXEC\r\nQUIT\r\nPING\r\n"],
+    );
+    assert_eq!(
+        String::from_utf8(reply).unwrap(),
+        concat!(
+            "+OK\r\n",
+            "-ERR Command not allowed inside a transaction\r\n",
+            "-EXECABORT Transaction discarded because of previous errors.\r\n",
+            "+OK\r\n",
+        )
+    );
+}
+
+#[test]
+fn test_multi_channel_subscription_replies() {
+    let test_server = utils::start_server_and_get_connection();
+    // Every channel is confirmed by a frame of its own, with the number of subscriptions so far; a channel subscribed
+    // to again isn't counted twice
+    let reply = utils::send_raw(
+        &test_server.port,
+        &[b"SUBSCRIBE a b c\r\nSUBSCRIBE c d\r\nUNSUBSCRIBE b x\r\nUNSUBSCRIBE\r\n"],
+    );
+    assert_eq!(
+        String::from_utf8(reply).unwrap(),
+        concat!(
+            "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n",
+            "*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n",
+            "*3\r\n$9\r\nsubscribe\r\n$1\r\nc\r\n:3\r\n",
+            "*3\r\n$9\r\nsubscribe\r\n$1\r\nc\r\n:3\r\n",
+            "*3\r\n$9\r\nsubscribe\r\n$1\r\nd\r\n:4\r\n",
+            "*3\r\n$11\r\nunsubscribe\r\n$1\r\nb\r\n:3\r\n",
+            "*3\r\n$11\r\nunsubscribe\r\n$1\r\nx\r\n:3\r\n",
+            // Without arguments, every channel is unsubscribed from in the order it was subscribed to
+            "*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:2\r\n",
+            "*3\r\n$11\r\nunsubscribe\r\n$1\r\nc\r\n:1\r\n",
+            "*3\r\n$11\r\nunsubscribe\r\n$1\r\nd\r\n:0\r\n",
+        )
+    );
+
+    // The patterns count too, and a client subscribed to no channel gets a single frame without one
+    let reply = utils::send_raw(
+        &test_server.port,
+        &[b"PSUBSCRIBE p*\r\nSUBSCRIBE a\r\nUNSUBSCRIBE\r\nUNSUBSCRIBE\r\n"],
+    );
+    assert_eq!(
+        String::from_utf8(reply).unwrap(),
+        concat!(
+            "*3\r\n$10\r\npsubscribe\r\n$2\r\np*\r\n:1\r\n",
+            "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:2\r\n",
+            "*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:1\r\n",
+            "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:1\r\n",
+        )
+    );
+}
+
+#[test]
+fn test_slow_subscriber() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let mut subscriber = TcpStream::connect(format!("127.0.0.1:{}", test_server.port)).unwrap();
+    subscriber.write_all(b"SUBSCRIBE slow\r\n").unwrap();
+    thread::sleep(Duration::from_millis(100));
+
+    // Publishing doesn't wait for a subscriber which doesn't read its messages; it is disconnected instead
+    let message = "x".repeat(64 * 1024);
+    let disconnected = (0..5000).any(|_| {
+        let receivers: usize = test_server.connection.publish("slow", &message).unwrap();
+        receivers == 0
+    });
+    assert!(disconnected);
+
+    subscriber
+        .set_read_timeout(Some(Duration::from_secs(5)))
+        .unwrap();
+    let mut output = Vec::new();
+    subscriber.read_to_end(&mut output).unwrap();
+    assert!(output.starts_with(b"*3\r\n$9\r\nsubscribe\r\n$4\r\nslow\r\n:1\r\n"));
+}
+
+// Subscribe to the channel without ever reading, and publish messages of 64 KB to it until the subscriber is
+// disconnected, returning the number of messages it received
+fn publish_until_disconnected(test_server: &mut utils::TestServer, channel: &str) -> usize {
+    let mut subscriber = TcpStream::connect(format!("127.0.0.1:{}", test_server.port)).unwrap();
+    subscriber
+        .write_all(format!("SUBSCRIBE {channel}\r\n").as_bytes())
+        .unwrap();
+    thread::sleep(Duration::from_millis(100));
+    let message = "x".repeat(64 * 1024);
+    (0..5000)
+        .position(|_| {
+            let receivers: usize = test_server.connection.publish(channel, &message).unwrap();
+            receivers == 0
+        })
+        .unwrap()
+}
+
+#[test]
+fn test_subscriber_output_buffer_limit() {
+    let mut test_server = utils::start_server_and_get_connection();
+
+    // The default hard limit of 32 MB is only reached once the socket buffers are full too
+    assert!(publish_until_disconnected(&mut test_server, "default") >= 512);
+
+    // A lower hard limit disconnects the subscriber sooner, with at most the socket buffers in between
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "client-output-buffer-limit", "pubsub 1mb 0 0"])
+        .query(&mut test_server.connection)
+        .unwrap();
+    let received = publish_until_disconnected(&mut test_server, "hard");
+    assert!((8..448).contains(&received), "{received}");
+
+    // Staying above the soft limit for longer than allowed disconnects the subscriber too
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "client-output-buffer-limit", "pubsub 0 1mb 1"])
+        .query(&mut test_server.connection)
+        .unwrap();
+    let mut subscriber = TcpStream::connect(format!("127.0.0.1:{}", test_server.port)).unwrap();
+    subscriber.write_all(b"SUBSCRIBE soft\r\n").unwrap();
+    thread::sleep(Duration::from_millis(100));
+    let message = "x".repeat(64 * 1024);
+    for _ in 0..448 {
+        let receivers: usize = test_server.connection.publish("soft", &message).unwrap();
+        assert_eq!(receivers, 1);
+    }
+    thread::sleep(Duration::from_millis(1100));
+    let receivers: usize = test_server.connection.publish("soft", &message).unwrap();
+    assert_eq!(receivers, 0);
+}
+
+#[test]
+fn test_pattern_subscriptions() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let mut subscriber_con = utils::get_connection(&test_server.port);
+    let mut subscriber = subscriber_con.as_pubsub();
+    subscriber
+        .psubscribe(&["news.*", "h?llo", "[a-c]x", "[^a]y", "esc\\*"])
+        .unwrap();
+    subscriber.subscribe("news.tech").unwrap();
+
+    // Both the channel and the pattern subscription count
+    let receivers: usize = test_server.connection.publish("news.tech", "rust").unwrap();
+    assert_eq!(receivers, 2);
+    let message = subscriber.get_message().unwrap();
+    assert!(!message.from_pattern());
+    assert_eq!(message.get_channel_name(), "news.tech");
+    let message = subscriber.get_message().unwrap();
+    assert_eq!(message.get_pattern::<String>().unwrap(), "news.*");
+    assert_eq!(message.get_channel_name(), "news.tech");
+    assert_eq!(message.get_payload::<String>().unwrap(), "rust");
+
+    for (channel, receivers) in [
+        ("news.", 1),
+        ("news", 0),
+        ("hello", 1),
+        ("hallo", 1),
+        ("heello", 0),
+        ("bx", 1),
+        ("dx", 0),
+        ("by", 1),
+        ("ay", 0),
+        ("esc*", 1),
+        ("escape", 0),
+    ] {
+        let published: usize = test_server.connection.publish(channel, "x").unwrap();
+        assert_eq!(published, receivers, "{channel}");
+    }
+    for _ in 0..6 {
+        subscriber.get_message().unwrap();
+    }
+
+    subscriber.punsubscribe("news.*").unwrap();
+    let receivers: usize = test_server.connection.publish("news.tech", "go").unwrap();
+    assert_eq!(receivers, 1);
+}
+
+#[test]
+fn test_pattern_subscriber_mode() {
+    let test_server = utils::start_server_and_get_connection();
+    let reply = utils::send_raw(
+        &test_server.port,
+        &[b"PSUBSCRIBE a* b*\r\nSUBSCRIBE c\r\nUNSUBSCRIBE\r\nPUNSUBSCRIBE a*\r\nPUNSUBSCRIBE\r\nPUNSUBSCRIBE\r\n"],
+    );
+    assert_eq!(
+        String::from_utf8(reply).unwrap(),
+        concat!(
+            "*3\r\n$10\r\npsubscribe\r\n$2\r\na*\r\n:1\r\n",
+            "*3\r\n$10\r\npsubscribe\r\n$2\r\nb*\r\n:2\r\n",
+            "*3\r\n$9\r\nsubscribe\r\n$1\r\nc\r\n:3\r\n",
+            "*3\r\n$11\r\nunsubscribe\r\n$1\r\nc\r\n:2\r\n",
+            "*3\r\n$12\r\npunsubscribe\r\n$2\r\na*\r\n:1\r\n",
+            "*3\r\n$12\r\npunsubscribe\r\n$2\r\nb*\r\n:0\r\n",
+            "*3\r\n$12\r\npunsubscribe\r\n$-1\r\n:0\r\n",
+        )
+    );
+}
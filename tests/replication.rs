@@ -0,0 +1,535 @@
+use redis::Commands;
+use std::{
+    env, fs,
+    io::{BufRead, BufReader, Read, Write},
+    net::{TcpListener, TcpStream},
+    path::Path,
+    thread,
+    time::Duration,
+};
+
+mod utils;
+
+// One side of a replication link, driven by the test
+struct FakePeer {
+    reader: BufReader<TcpStream>,
+    writer: TcpStream,
+}
+
+impl FakePeer {
+    fn accept(listener: &TcpListener) -> Self {
+        Self::new(listener.accept().unwrap().0)
+    }
+
+    fn connect(port: &str) -> Self {
+        Self::new(TcpStream::connect(format!("127.0.0.1:{port}")).unwrap())
+    }
+
+    fn new(stream: TcpStream) -> Self {
+        stream
+            .set_read_timeout(Some(Duration::from_secs(5)))
+            .unwrap();
+        Self {
+            reader: BufReader::new(stream.try_clone().unwrap()),
+            writer: stream,
+        }
+    }
+
+    fn read_line(&mut self) -> String {
+        let mut line = String::new();
+        self.reader.read_line(&mut line).unwrap();
+        line.trim_end().to_string()
+    }
+
+    // Read a command sent by the other side as an array of bulk strings
+    fn read_command(&mut self) -> Vec<String> {
+        let len: usize = self.read_line()[1..].parse().unwrap();
+        (0..len)
+            .map(|_| {
+                let arg_len: usize = self.read_line()[1..].parse().unwrap();
+                let mut arg = vec![0; arg_len + 2];
+                self.reader.read_exact(&mut arg).unwrap();
+                String::from_utf8(arg[..arg_len].to_vec()).unwrap()
+            })
+            .collect()
+    }
+
+    fn expect_command(&mut self, expected: &[&str], reply: &[u8]) {
+        assert_eq!(self.read_command(), expected);
+        self.writer.write_all(reply).unwrap();
+    }
+
+    // Send commands to the other side, returning the number of bytes sent
+    fn propagate(&mut self, commands: &[&[&str]]) -> usize {
+        let mut bytes = Vec::new();
+        for command in commands {
+            bytes.extend(format!("*{}\r\n", command.len()).as_bytes());
+            for arg in *command {
+                bytes.extend(format!("${}\r\n{arg}\r\n", arg.len()).as_bytes());
+            }
+        }
+        self.writer.write_all(&bytes).unwrap();
+        bytes.len()
+    }
+}
+
+// Start a replica of the master listening on the given port
+fn start_replica(master_port: u16) -> (utils::ChildGuard, String, redis::Connection) {
+    let port = utils::find_free_tcp_port().to_string();
+    let replica =
+        utils::start_server_with_args(&[&port, "--replicaof", &format!("127.0.0.1 {master_port}")]);
+    let connection = utils::get_connection(&port);
+    (replica, port, connection)
+}
+
+fn handshake(master: &mut FakePeer, replica_port: &str) {
+    master.expect_command(&["PING"], b"+PONG\r\n");
+    master.expect_command(&["REPLCONF", "listening-port", replica_port], b"+OK\r\n");
+    master.expect_command(&["REPLCONF", "capa", "psync2"], b"+OK\r\n");
+}
+
+#[test]
+fn test_replica_full_resync() {
+    let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+    let (_replica, replica_port, mut con) = start_replica(listener.local_addr().unwrap().port());
+
+    let mut master = FakePeer::accept(&listener);
+    handshake(&mut master, &replica_port);
+    assert_eq!(master.read_command(), ["PSYNC", "?", "-1"]);
+    // The snapshot of the master is sent as a bulk string without a trailing "\r\n"
+    let rdb = fs::read(
+        Path::new(&env::var("CARGO_MANIFEST_DIR").unwrap()).join("tests/fixtures/redis7.rdb"),
+    )
+    .unwrap();
+    master
+        .writer
+        .write_all(
+            format!(
+                "+FULLRESYNC 8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb 0\r\n${}\r\n",
+                rdb.len()
+            )
+            .as_bytes(),
+        )
+        .unwrap();
+    master.writer.write_all(&rdb).unwrap();
+
+    // The offset counts the bytes of every command received after the snapshot
+    let mut offset = master.propagate(&[
+        &["SET", "foo", "bar"],
+        &["PING"],
+        &["MULTI"],
+        &["INCR", "counter"],
+        &["RPUSH", "list", "c"],
+        &["EXEC"],
+    ]);
+    let getack_len = master.propagate(&[&["REPLCONF", "GETACK", "*"]]);
+    assert_eq!(
+        master.read_command(),
+        ["REPLCONF", "ACK", &offset.to_string()]
+    );
+    offset += getack_len;
+    offset += master.propagate(&[&["HSET", "hash", "other", "1"]]);
+    master.propagate(&[&["REPLCONF", "GETACK", "*"]]);
+    assert_eq!(
+        master.read_command(),
+        ["REPLCONF", "ACK", &offset.to_string()]
+    );
+
+    // The keys of the snapshot are loaded along with the writes applied after it
+    let get_result: String = con.get("greeting").unwrap();
+    assert_eq!(get_result, "hello");
+    let get_result: String = con.get("foo").unwrap();
+    assert_eq!(get_result, "bar");
+    let counter: i64 = con.get("counter").unwrap();
+    assert_eq!(counter, 1);
+    let lrange_result: Vec<String> = con.lrange("list", 0, -1).unwrap();
+    assert_eq!(lrange_result, ["a", "b", "7", "c"]);
+    let hlen: usize = con.hlen("hash").unwrap();
+    assert_eq!(hlen, 3);
+
+    // Clients can only read from the replica
+    let err = con.set::<_, _, ()>("foo", "baz").unwrap_err();
+    assert_eq!(err.code(), Some("READONLY"));
+    let _: () = redis::cmd("MULTI").query(&mut con).unwrap();
+    let err = con.incr::<_, _, ()>("counter", 1).unwrap_err();
+    assert_eq!(err.code(), Some("READONLY"));
+    let err = redis::cmd("EXEC").query::<()>(&mut con).unwrap_err();
+    assert_eq!(err.code(), Some("EXECABORT"));
+    let get_result: String = con.get("foo").unwrap();
+    assert_eq!(get_result, "bar");
+}
+
+#[test]
+fn test_replica_partial_resync() {
+    let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+    let (_replica, replica_port, mut con) = start_replica(listener.local_addr().unwrap().port());
+
+    let mut master = FakePeer::accept(&listener);
+    handshake(&mut master, &replica_port);
+    assert_eq!(master.read_command(), ["PSYNC", "?", "-1"]);
+    // An empty RDB file without a checksum
+    let rdb = b"REDIS0011\xff\x00\x00\x00\x00\x00\x00\x00\x00";
+    master
+        .writer
+        .write_all(format!("+FULLRESYNC abc 100\r\n${}\r\n", rdb.len()).as_bytes())
+        .unwrap();
+    master.writer.write_all(rdb).unwrap();
+    let offset = 100 + master.propagate(&[&["SET", "foo", "1"]]);
+
+    // The replica resumes the stream from its offset after the link breaks
+    drop(master);
+    let mut master = FakePeer::accept(&listener);
+    handshake(&mut master, &replica_port);
+    assert_eq!(
+        master.read_command(),
+        ["PSYNC", "abc", &(offset + 1).to_string()]
+    );
+    master.writer.write_all(b"+CONTINUE def\r\n").unwrap();
+    let resumed_len = master.propagate(&[&["INCR", "foo"]]);
+    master.propagate(&[&["REPLCONF", "GETACK", "*"]]);
+    assert_eq!(
+        master.read_command(),
+        ["REPLCONF", "ACK", &(offset + resumed_len).to_string()]
+    );
+
+    // The keys from before the link broke are kept
+    let get_result: i64 = con.get("foo").unwrap();
+    assert_eq!(get_result, 2);
+
+    // The new replication ID is used for the next resynchronization
+    drop(master);
+    let mut master = FakePeer::accept(&listener);
+    handshake(&mut master, &replica_port);
+    assert_eq!(master.read_command()[..2], ["PSYNC", "def"]);
+}
+
+#[test]
+fn test_master_full_resync() {
+    let port = utils::find_free_tcp_port().to_string();
+    let _master = utils::start_server(&port);
+    let mut con = utils::get_connection(&port);
+    let _: () = con.set("before", "sync").unwrap();
+
+    let mut replica = FakePeer::connect(&port);
+    replica.propagate(&[&["PING"]]);
+    assert_eq!(replica.read_line(), "+PONG");
+    replica.propagate(&[&["REPLCONF", "listening-port", "6380"]]);
+    assert_eq!(replica.read_line(), "+OK");
+    replica.propagate(&[&["REPLCONF", "capa", "psync2"]]);
+    assert_eq!(replica.read_line(), "+OK");
+    replica.propagate(&[&["PSYNC", "?", "-1"]]);
+    let reply = replica.read_line();
+    let reply_args: Vec<&str> = reply.split(' ').collect();
+    assert_eq!(reply_args[0], "+FULLRESYNC");
+    assert_eq!(reply_args[1].len(), 40);
+    let offset: usize = reply_args[2].parse().unwrap();
+    let rdb_len: usize = replica.read_line()[1..].parse().unwrap();
+    let mut rdb = vec![0; rdb_len];
+    replica.reader.read_exact(&mut rdb).unwrap();
+    assert!(rdb.starts_with(b"REDIS"));
+    assert!(rdb.windows(6).any(|window| window == b"before"));
+
+    // Writes are forwarded verbatim, while reads and failed writes are not
+    let _: () = con.set("foo", "bar").unwrap();
+    let _: Option<String> = con.get("foo").unwrap();
+    let _ = con.incr::<_, _, i64>("foo", 1).unwrap_err();
+    let _: usize = con.rpush("list", "a").unwrap();
+    assert_eq!(replica.read_command(), ["SET", "foo", "bar"]);
+    assert_eq!(replica.read_command(), ["RPUSH", "list", "a"]);
+    // 31 and 32 bytes for the commands above
+    let offset = offset + 31 + 32;
+
+    // A client which didn't write anything has nothing to wait for, even though the replica lags behind
+    let mut reader_con = utils::get_connection(&port);
+    let wait_result: i64 = redis::cmd("WAIT")
+        .arg(&[1, 0])
+        .query(&mut reader_con)
+        .unwrap();
+    assert_eq!(wait_result, 1);
+
+    // WAIT asks the replicas for their offset until enough of them acknowledged the writes
+    let wait = thread::spawn(move || {
+        let wait_result: i64 = redis::cmd("WAIT").arg(&[1, 5000]).query(&mut con).unwrap();
+        (wait_result, con)
+    });
+    assert_eq!(replica.read_command(), ["REPLCONF", "GETACK", "*"]);
+    replica.propagate(&[&["REPLCONF", "ACK", &(offset - 1).to_string()]]);
+    thread::sleep(Duration::from_millis(100));
+    assert!(!wait.is_finished());
+    replica.propagate(&[&["REPLCONF", "ACK", &offset.to_string()]]);
+    let (wait_result, mut con) = wait.join().unwrap();
+    assert_eq!(wait_result, 1);
+
+    // The GETACK sent by the master don't have to be acknowledged
+    let wait_result: i64 = redis::cmd("WAIT").arg(&[1, 0]).query(&mut con).unwrap();
+    assert_eq!(wait_result, 1);
+    let wait_result: i64 = redis::cmd("WAIT").arg(&[2, 100]).query(&mut con).unwrap();
+    assert_eq!(wait_result, 1);
+    assert_eq!(replica.read_command(), ["REPLCONF", "GETACK", "*"]);
+}
+
+// Connect to the master as a replica asking for the stream from the given offset, returning the reply to PSYNC
+fn connect_replica(port: &str, replid: &str, offset: &str) -> (FakePeer, String) {
+    let mut replica = FakePeer::connect(port);
+    replica.propagate(&[&["PING"]]);
+    assert_eq!(replica.read_line(), "+PONG");
+    replica.propagate(&[&["REPLCONF", "listening-port", "6380"]]);
+    assert_eq!(replica.read_line(), "+OK");
+    replica.propagate(&[&["REPLCONF", "capa", "psync2"]]);
+    assert_eq!(replica.read_line(), "+OK");
+    replica.propagate(&[&["PSYNC", replid, offset]]);
+    let reply = replica.read_line();
+    (replica, reply)
+}
+
+#[test]
+fn test_master_partial_resync() {
+    let port = utils::find_free_tcp_port().to_string();
+    let _master = utils::start_server(&port);
+    let mut con = utils::get_connection(&port);
+
+    // Without a backlog, the stream can't be continued
+    let (mut replica, reply) = connect_replica(&port, "?", "-1");
+    let reply_args: Vec<&str> = reply.split(' ').collect();
+    assert_eq!(reply_args[0], "+FULLRESYNC");
+    let replid = reply_args[1].to_owned();
+    let offset: usize = reply_args[2].parse().unwrap();
+    let rdb_len: usize = replica.read_line()[1..].parse().unwrap();
+    let mut rdb = vec![0; rdb_len];
+    replica.reader.read_exact(&mut rdb).unwrap();
+    let _: () = con.set("foo", "bar").unwrap();
+    assert_eq!(replica.read_command(), ["SET", "foo", "bar"]);
+    let offset = offset + 31;
+
+    // The replica misses the writes while it is disconnected, and gets them from the backlog once it reconnects
+    drop(replica);
+    let _: () = con.set("foo", "baz").unwrap();
+    let _: usize = con.rpush("list", "a").unwrap();
+    let (mut replica, reply) = connect_replica(&port, &replid, &(offset + 1).to_string());
+    assert_eq!(reply, format!("+CONTINUE {replid}"));
+    assert_eq!(replica.read_command(), ["SET", "foo", "baz"]);
+    assert_eq!(replica.read_command(), ["RPUSH", "list", "a"]);
+    let info: String = redis::cmd("INFO")
+        .arg("replication")
+        .query(&mut con)
+        .unwrap();
+    assert!(info.contains("repl_backlog_active:1"), "{info}");
+
+    // An offset beyond the stream or another replication ID need a full resynchronization
+    drop(replica);
+    let offset = offset + 31 + 32;
+    let (_, reply) = connect_replica(&port, &replid, &(offset + 2).to_string());
+    assert!(reply.starts_with("+FULLRESYNC"), "{reply}");
+    let (_, reply) = connect_replica(&port, "0123456789", &(offset + 1).to_string());
+    assert!(reply.starts_with("+FULLRESYNC"), "{reply}");
+    let (_, reply) = connect_replica(&port, &replid, &(offset + 1).to_string());
+    assert_eq!(reply, format!("+CONTINUE {replid}"));
+
+    // Once the replication ID changed, the former one continues the stream under the new one up to where it changed
+    let _: () = redis::cmd("DEBUG")
+        .arg("CHANGE-REPL-ID")
+        .query(&mut con)
+        .unwrap();
+    let info: String = redis::cmd("INFO")
+        .arg("replication")
+        .query(&mut con)
+        .unwrap();
+    assert!(
+        info.contains(&format!("master_replid2:{replid}\r\n")),
+        "{info}"
+    );
+    assert!(
+        info.contains(&format!("second_repl_offset:{}\r\n", offset + 1)),
+        "{info}"
+    );
+    let (_, reply) = connect_replica(&port, &replid, &(offset + 1).to_string());
+    let new_replid = reply.strip_prefix("+CONTINUE ").unwrap().to_owned();
+    assert_ne!(new_replid, replid);
+    let _: () = con.set("foo", "bar").unwrap();
+    let (_, reply) = connect_replica(&port, &replid, &(offset + 1 + 31).to_string());
+    assert!(reply.starts_with("+FULLRESYNC"), "{reply}");
+
+    // Only the last bytes of the stream are kept, as many as the backlog size
+    let (replid, offset) = (new_replid.as_str(), offset + 31);
+    let (_, reply) = connect_replica(&port, replid, &(offset + 1).to_string());
+    assert_eq!(reply, format!("+CONTINUE {replid}"));
+    let _: () = redis::cmd("CONFIG")
+        .arg(&["SET", "repl-backlog-size", "1"])
+        .query(&mut con)
+        .unwrap();
+    let _: () = con.set("foo", "qux").unwrap();
+    let (_, reply) = connect_replica(&port, replid, &(offset + 1).to_string());
+    assert!(reply.starts_with("+FULLRESYNC"), "{reply}");
+}
+
+#[test]
+fn test_master_with_replica() {
+    let master_port = utils::find_free_tcp_port();
+    let _master = utils::start_server(&master_port.to_string());
+    let mut master_con = utils::get_connection(&master_port.to_string());
+    let _: () = master_con.set("foo", "1").unwrap();
+    let wait_result: i64 = redis::cmd("WAIT")
+        .arg(&[0, 0])
+        .query(&mut master_con)
+        .unwrap();
+    assert_eq!(wait_result, 0);
+
+    let (_replica, _, mut replica_con) = start_replica(master_port);
+    // Wait for the snapshot to be loaded, so that the replica is connected to the master
+    for _ in 0..50 {
+        if replica_con.get::<_, Option<i64>>("foo").unwrap().is_some() {
+            break;
+        }
+        thread::sleep(Duration::from_millis(20));
+    }
+    let _: i64 = master_con.incr("foo", 1).unwrap();
+    let _: () = redis::cmd("SET")
+        .arg(&["volatile", "value", "EX", "100"])
+        .query(&mut master_con)
+        .unwrap();
+    let wait_result: i64 = redis::cmd("WAIT")
+        .arg(&[1, 5000])
+        .query(&mut master_con)
+        .unwrap();
+    assert_eq!(wait_result, 1);
+
+    // Both the snapshot and the writes after it reached the replica
+    let get_result: i64 = replica_con.get("foo").unwrap();
+    assert_eq!(get_result, 2);
+    let ttl: i64 = replica_con.ttl("volatile").unwrap();
+    assert!((98..=100).contains(&ttl), "{ttl}");
+
+    // A replica can't wait for replicas of its own
+    let err = redis::cmd("WAIT")
+        .arg(&[1, 0])
+        .query::<i64>(&mut replica_con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+}
+
+#[test]
+fn test_waitaof_with_replica() {
+    let master_port = utils::find_free_tcp_port();
+    let _master = utils::start_server(&master_port.to_string());
+    let mut master_con = utils::get_connection(&master_port.to_string());
+
+    // Only a replica with its AOF enabled acknowledges the offset flushed to it
+    let (_replica, _, mut replica_con) = start_replica(master_port);
+    let dir = utils::create_temp_dir("waitaof-replica");
+    let aof_replica_port = utils::find_free_tcp_port().to_string();
+    let _aof_replica = utils::start_server_with_args(&[
+        &aof_replica_port,
+        "--replicaof",
+        &format!("127.0.0.1 {master_port}"),
+        "--dir",
+        dir.to_str().unwrap(),
+        "--appendonly",
+        "yes",
+    ]);
+    let mut aof_replica_con = utils::get_connection(&aof_replica_port);
+    // Wait for the snapshots to be loaded, so that both replicas are connected to the master
+    let _: () = master_con.set("foo", "bar").unwrap();
+    for _ in 0..50 {
+        let replica_keys: usize = replica_con.exists("foo").unwrap();
+        let aof_replica_keys: usize = aof_replica_con.exists("foo").unwrap();
+        if replica_keys + aof_replica_keys == 2 {
+            break;
+        }
+        thread::sleep(Duration::from_millis(20));
+    }
+    let _: () = master_con.set("foo", "baz").unwrap();
+    let wait_result: i64 = redis::cmd("WAIT")
+        .arg(&[2, 5000])
+        .query(&mut master_con)
+        .unwrap();
+    assert_eq!(wait_result, 2);
+    let waitaof_result: (i64, i64) = redis::cmd("WAITAOF")
+        .arg(&[0, 1, 5000])
+        .query(&mut master_con)
+        .unwrap();
+    assert_eq!(waitaof_result, (0, 1));
+    let waitaof_result: (i64, i64) = redis::cmd("WAITAOF")
+        .arg(&[0, 2, 100])
+        .query(&mut master_con)
+        .unwrap();
+    assert_eq!(waitaof_result, (0, 1));
+    let get_result: String = aof_replica_con.get("foo").unwrap();
+    assert_eq!(get_result, "baz");
+    assert!(fs::read_to_string(dir.join("appendonly.aof"))
+        .unwrap()
+        .contains("baz"));
+
+    // A replica can't wait for its own writes
+    let err = redis::cmd("WAITAOF")
+        .arg(&[0, 0, 0])
+        .query::<(i64, i64)>(&mut replica_con)
+        .unwrap_err();
+    assert_eq!(err.code(), Some("ERR"));
+}
+
+// Error replied to FAILOVER with the arguments
+fn failover_error(con: &mut redis::Connection, args: &[&str]) -> String {
+    let err = redis::cmd("FAILOVER")
+        .arg(args)
+        .query::<()>(con)
+        .unwrap_err();
+    format!("{} {}", err.code().unwrap(), err.detail().unwrap())
+}
+
+#[test]
+fn test_failover() {
+    let master_port = utils::find_free_tcp_port();
+    let _master = utils::start_server(&master_port.to_string());
+    let mut master_con = utils::get_connection(&master_port.to_string());
+
+    // The arguments are validated first
+    let cases: &[(&[&str], &str)] = &[
+        (&["TO", "127.0.0.1"], "ERR syntax error"),
+        (
+            &["TIMEOUT", "0"],
+            "ERR FAILOVER timeout must be greater than 0",
+        ),
+        (
+            &["TIMEOUT", "soon"],
+            "ERR value is not an integer or out of range",
+        ),
+        (&["FORCE", "FORCE"], "ERR syntax error"),
+        (
+            &["ABORT", "TIMEOUT", "100"],
+            "ERR FAILOVER abort cannot be used with other arguments.",
+        ),
+        (&["ABORT"], "ERR No failover in progress."),
+        (&[], "ERR FAILOVER requires connected replicas."),
+    ];
+    for &(args, expected) in cases {
+        assert_eq!(failover_error(&mut master_con, args), expected, "{args:?}");
+    }
+
+    let (_replica, replica_port, mut replica_con) = start_replica(master_port);
+    let _: () = master_con.set("foo", "1").unwrap();
+    let wait_result: i64 = redis::cmd("WAIT")
+        .arg(&[1, 5000])
+        .query(&mut master_con)
+        .unwrap();
+    assert_eq!(wait_result, 1);
+    assert_eq!(
+        failover_error(&mut master_con, &["FORCE", "TIMEOUT", "100"]),
+        "ERR FAILOVER with force option requires both a timeout and target HOST and IP."
+    );
+    assert_eq!(
+        failover_error(
+            &mut master_con,
+            &["TO", "127.0.0.1", &master_port.to_string()]
+        ),
+        "ERR FAILOVER target HOST and PORT is not a replica."
+    );
+    // Handing over to the replica itself isn't supported
+    assert_eq!(
+        failover_error(&mut master_con, &["TO", "127.0.0.1", &replica_port]),
+        "ERR FAILOVER is not supported by this server."
+    );
+    assert_eq!(
+        failover_error(&mut replica_con, &[]),
+        "ERR FAILOVER is not valid when server is a replica."
+    );
+}
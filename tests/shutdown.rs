@@ -0,0 +1,104 @@
+use redis::{Commands, Value};
+use std::fs;
+
+mod utils;
+
+// Send SHUTDOWN, which closes the connection without replying when the server exits
+fn shutdown(con: &mut redis::Connection, args: &[&str]) {
+    let result = redis::cmd("SHUTDOWN").arg(args).query::<Value>(con);
+    assert!(result.is_err(), "{result:?}");
+}
+
+#[test]
+fn test_shutdown_saves() {
+    let dir = utils::create_temp_dir("shutdown-save");
+
+    for (i, args) in [&[][..], &["SAVE"], &["save"]].into_iter().enumerate() {
+        let (mut server, mut con) = utils::start_server_in_dir(&dir, &[]);
+        let count: i64 = con.incr("count", 1).unwrap();
+        assert_eq!(count, i64::try_from(i).unwrap() + 1);
+        shutdown(&mut con, args);
+        assert!(server.wait_for_exit().unwrap().success());
+        assert!(dir.join("dump.rdb").exists());
+    }
+
+    // The keyspace is only saved when asked to
+    let (mut server, mut con) = utils::start_server_in_dir(&dir, &[]);
+    let _: () = con.set("count", "10").unwrap();
+    shutdown(&mut con, &["NOSAVE"]);
+    assert!(server.wait_for_exit().unwrap().success());
+    let (_server, mut con) = utils::start_server_in_dir(&dir, &[]);
+    let count: String = con.get("count").unwrap();
+    assert_eq!(count, "3");
+}
+
+#[test]
+fn test_shutdown_on_signal() {
+    let dir = utils::create_temp_dir("shutdown-signal");
+
+    for signal in ["TERM", "INT"] {
+        let (mut server, mut con) = utils::start_server_in_dir(&dir, &[]);
+        let _: () = con.set("signal", signal).unwrap();
+        server.signal(signal);
+        assert!(server.wait_for_exit().unwrap().success(), "{signal}");
+        let (_server, mut con) = utils::start_server_in_dir(&dir, &[]);
+        let saved: String = con.get("signal").unwrap();
+        assert_eq!(saved, signal);
+    }
+}
+
+#[test]
+fn test_shutdown_flushes_the_aof() {
+    let dir = utils::create_temp_dir("shutdown-aof");
+
+    let (mut server, mut con) =
+        utils::start_server_in_dir(&dir, &["--appendonly", "yes", "--appendfsync", "no"]);
+    let _: () = con.set("foo", "bar").unwrap();
+    shutdown(&mut con, &["NOSAVE"]);
+    assert!(server.wait_for_exit().unwrap().success());
+    assert!(!dir.join("dump.rdb").exists());
+
+    let (_server, mut con) = utils::start_server_in_dir(&dir, &["--appendonly", "yes"]);
+    let foo: String = con.get("foo").unwrap();
+    assert_eq!(foo, "bar");
+}
+
+#[test]
+fn test_shutdown_errors() {
+    let dir = utils::create_temp_dir("shutdown-errors");
+    let (mut server, mut con) = utils::start_server_in_dir(&dir, &[]);
+
+    let err = redis::cmd("SHUTDOWN")
+        .arg("LATER")
+        .query::<Value>(&mut con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+    let err = redis::cmd("SHUTDOWN")
+        .arg(&["SAVE", "NOSAVE"])
+        .query::<Value>(&mut con)
+        .unwrap_err();
+    assert_eq!(err.detail(), Some("syntax error"));
+
+    // The server keeps running if the keyspace can't be saved
+    fs::remove_dir_all(&dir).unwrap();
+    let err = redis::cmd("SHUTDOWN").query::<Value>(&mut con).unwrap_err();
+    assert_eq!(err.detail(), Some("Errors trying to SHUTDOWN. Check logs."));
+    let pong: String = redis::cmd("PING").query(&mut con).unwrap();
+    assert_eq!(pong, "PONG");
+    assert!(server.exit_status().is_none());
+
+    // SHUTDOWN is rejected inside a transaction
+    let _: () = redis::cmd("MULTI").query(&mut con).unwrap();
+    let err = redis::cmd("SHUTDOWN")
+        .arg("NOSAVE")
+        .query::<Value>(&mut con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("Command not allowed inside a transaction")
+    );
+    let _: () = redis::cmd("DISCARD").query(&mut con).unwrap();
+
+    shutdown(&mut con, &["NOSAVE"]);
+    assert!(server.wait_for_exit().unwrap().success());
+}
@@ -0,0 +1,186 @@
+use redis::{Commands, Value};
+use std::time::{SystemTime, UNIX_EPOCH};
+
+mod utils;
+
+// The arguments of every entry of SLOWLOG GET, newest first
+fn logged_commands(con: &mut redis::Connection, count: i64) -> Vec<Vec<String>> {
+    let entries: Vec<Value> = redis::cmd("SLOWLOG")
+        .arg(&["GET", &count.to_string()])
+        .query(con)
+        .unwrap();
+    entries
+        .into_iter()
+        .map(|entry| {
+            let Value::Array(fields) = entry else {
+                panic!("unexpected entry {entry:?}");
+            };
+            redis::from_redis_value(&fields[3]).unwrap()
+        })
+        .collect()
+}
+
+fn slowlog_len(con: &mut redis::Connection) -> i64 {
+    redis::cmd("SLOWLOG").arg("LEN").query(con).unwrap()
+}
+
+fn slowlog_reset(con: &mut redis::Connection) {
+    let _: () = redis::cmd("SLOWLOG").arg("RESET").query(con).unwrap();
+}
+
+#[test]
+fn test_slowlog_entries() {
+    let port = utils::find_free_tcp_port().to_string();
+    let _server = utils::start_server_with_args(&[&port, "--slowlog-log-slower-than", "0"]);
+    let mut con = utils::get_connection(&port);
+
+    let _: () = redis::cmd("CLIENT")
+        .arg(&["SETNAME", "slow"])
+        .query(&mut con)
+        .unwrap();
+    slowlog_reset(&mut con);
+    let _: () = con.set("foo", "bar").unwrap();
+    let _: String = con.get("foo").unwrap();
+    // SLOWLOG itself is logged once it ran
+    assert_eq!(slowlog_len(&mut con), 3);
+    assert_eq!(
+        logged_commands(&mut con, 3),
+        [
+            vec!["SLOWLOG", "LEN"],
+            vec!["GET", "foo"],
+            vec!["SET", "foo", "bar"]
+        ]
+    );
+
+    let entries: Vec<Value> = redis::cmd("SLOWLOG")
+        .arg(&["GET", "1"])
+        .query(&mut con)
+        .unwrap();
+    let Value::Array(ref fields) = entries[0] else {
+        panic!("unexpected entry {:?}", entries[0]);
+    };
+    let (id, timestamp, duration): (i64, u64, i64) = (
+        redis::from_redis_value(&fields[0]).unwrap(),
+        redis::from_redis_value(&fields[1]).unwrap(),
+        redis::from_redis_value(&fields[2]).unwrap(),
+    );
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap()
+        .as_secs();
+    assert!(id > 0);
+    assert!(now.abs_diff(timestamp) <= 2, "{timestamp}");
+    assert!(duration >= 0);
+    let args: Vec<String> = redis::from_redis_value(&fields[3]).unwrap();
+    assert_eq!(args, ["SLOWLOG", "GET", "3"]);
+    let client_addr: String = redis::from_redis_value(&fields[4]).unwrap();
+    assert!(client_addr.starts_with("127.0.0.1:"), "{client_addr}");
+    let client_name: String = redis::from_redis_value(&fields[5]).unwrap();
+    assert_eq!(client_name, "slow");
+
+    // The IDs keep growing across resets
+    slowlog_reset(&mut con);
+    let entries: Vec<Value> = redis::cmd("SLOWLOG")
+        .arg(&["GET", "-1"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(entries.len(), 1);
+    let Value::Array(ref fields) = entries[0] else {
+        panic!("unexpected entry {:?}", entries[0]);
+    };
+    assert!(redis::from_redis_value::<i64>(&fields[0]).unwrap() > id);
+}
+
+#[test]
+fn test_slowlog_threshold_and_max_len() {
+    let port = utils::find_free_tcp_port().to_string();
+    let _server = utils::start_server_with_args(&[
+        &port,
+        "--slowlog-log-slower-than",
+        "0",
+        "--slowlog-max-len",
+        "3",
+    ]);
+    let mut con = utils::get_connection(&port);
+
+    // Only the newest entries are kept
+    for i in 0..10 {
+        let _: () = con.set("key", i).unwrap();
+    }
+    assert_eq!(slowlog_len(&mut con), 3);
+    assert_eq!(
+        logged_commands(&mut con, -1),
+        [
+            vec!["SLOWLOG", "LEN"],
+            vec!["SET", "key", "9"],
+            vec!["SET", "key", "8"]
+        ]
+    );
+
+    // Commands faster than the threshold aren't logged, and a negative one disables the log
+    for threshold in ["1000000", "-1"] {
+        let _: () = redis::cmd("CONFIG")
+            .arg(&["SET", "slowlog-log-slower-than", threshold])
+            .query(&mut con)
+            .unwrap();
+        slowlog_reset(&mut con);
+        let _: () = con.set("key", "value").unwrap();
+        assert_eq!(slowlog_len(&mut con), 0);
+    }
+    let reply: Vec<String> = redis::cmd("CONFIG")
+        .arg(&["GET", "slowlog-*"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(
+        reply,
+        ["slowlog-log-slower-than", "-1", "slowlog-max-len", "3"]
+    );
+}
+
+#[test]
+fn test_slowlog_long_commands() {
+    let port = utils::find_free_tcp_port().to_string();
+    let _server = utils::start_server_with_args(&[&port, "--slowlog-log-slower-than", "0"]);
+    let mut con = utils::get_connection(&port);
+
+    let keys: Vec<String> = (0..40).map(|i| format!("key{i}")).collect();
+    let _: usize = con.del(&keys).unwrap();
+    let logged = &logged_commands(&mut con, 1)[0];
+    assert_eq!(logged.len(), 32);
+    assert_eq!(logged[..2], ["DEL", "key0"]);
+    assert_eq!(logged[30], "key29");
+    assert_eq!(logged[31], "... (10 more arguments)");
+
+    let _: () = con.set("key", "x".repeat(200)).unwrap();
+    let logged = &logged_commands(&mut con, 1)[0];
+    assert_eq!(logged[2], "x".repeat(128) + "... (72 more bytes)");
+
+    // Passwords aren't logged
+    let _ = redis::cmd("AUTH").arg("secret").query::<()>(&mut con);
+    assert_eq!(logged_commands(&mut con, 1), [vec!["AUTH", "(redacted)"]]);
+}
+
+#[test]
+fn test_slowlog_errors() {
+    let mut test_server = utils::start_server_and_get_connection();
+    let con = &mut test_server.connection;
+
+    for (args, expected) in [
+        (
+            &["GET", "-2"][..],
+            "count should be greater than or equal to -1",
+        ),
+        (&["GET", "x"], "value is not an integer or out of range"),
+        (
+            &["LEN", "x"],
+            "wrong number of arguments for 'slowlog|len' command",
+        ),
+        (&["FOO"], "unknown subcommand 'FOO'. Try SLOWLOG HELP."),
+    ] {
+        let err = redis::cmd("SLOWLOG")
+            .arg(args)
+            .query::<Value>(con)
+            .unwrap_err();
+        assert_eq!(err.detail(), Some(expected));
+    }
+}
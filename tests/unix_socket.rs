@@ -0,0 +1,102 @@
+use redis::Commands;
+use std::{
+    fs,
+    io::{Read, Write},
+    net::Shutdown,
+    os::unix::net::UnixStream,
+    path::Path,
+    time::Duration,
+};
+
+mod utils;
+
+// Send the raw bytes over the Unix socket, then read everything the server replies until it closes the connection
+fn send_unix(path: &Path, request: &[u8]) -> String {
+    let mut stream = UnixStream::connect(path).unwrap();
+    stream
+        .set_read_timeout(Some(Duration::from_secs(5)))
+        .unwrap();
+    stream.write_all(request).unwrap();
+    stream.shutdown(Shutdown::Write).unwrap();
+
+    let mut reply = String::new();
+    stream.read_to_string(&mut reply).unwrap();
+    reply
+}
+
+#[test]
+fn test_unix_socket_along_with_tcp() {
+    let dir = utils::create_temp_dir("unix-socket");
+    let socket = dir.join("redis.sock");
+    let port = utils::find_free_tcp_port().to_string();
+    let _server = utils::start_server_with_args(&[&port, "--unixsocket", socket.to_str().unwrap()]);
+    let mut con = utils::get_connection(&port);
+
+    // Both listeners are served by the same server
+    let reply = send_unix(&socket, b"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n");
+    assert_eq!(reply, "+OK\r\n");
+    let val: String = con.get("foo").unwrap();
+    assert_eq!(val, "bar");
+    let _: () = con.set("foo", "baz").unwrap();
+    let reply = send_unix(&socket, b"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n");
+    assert_eq!(reply, "$3\r\nbaz\r\n");
+
+    let reply: Vec<String> = redis::cmd("CONFIG")
+        .arg(&["GET", "unixsocket"])
+        .query(&mut con)
+        .unwrap();
+    assert_eq!(reply, ["unixsocket", socket.to_str().unwrap()]);
+    let err = redis::cmd("CONFIG")
+        .arg(&["SET", "unixsocket", "/tmp/other.sock"])
+        .query::<()>(&mut con)
+        .unwrap_err();
+    assert_eq!(
+        err.detail(),
+        Some("CONFIG SET failed (possibly related to argument 'unixsocket') - can't set immutable config")
+    );
+
+    // Clients on the Unix socket have its path as their address, with port 0
+    let list: String = redis::cmd("CLIENT").arg("LIST").query(&mut con).unwrap();
+    assert!(list.contains(" flags=N "), "{list}");
+    let reply = send_unix(&socket, b"*2\r\n$6\r\nCLIENT\r\n$4\r\nLIST\r\n");
+    let addr = format!("{}:0", socket.display());
+    let line = reply
+        .lines()
+        .find(|line| line.contains(" flags=U "))
+        .unwrap();
+    assert!(
+        line.contains(&format!(" addr={addr} laddr={addr} ")),
+        "{line}"
+    );
+}
+
+#[test]
+fn test_unix_socket_only() {
+    let dir = utils::create_temp_dir("unix-socket-only");
+    let socket = dir.join("redis.sock");
+    // A socket left behind by a previous server is replaced
+    fs::write(&socket, b"").unwrap();
+    let mut server = utils::start_server_with_args(&[
+        "--port",
+        "0",
+        "--unixsocket",
+        socket.to_str().unwrap(),
+        "--dir",
+        dir.to_str().unwrap(),
+    ]);
+
+    let reply = send_unix(&socket, b"*1\r\n$4\r\nPING\r\n");
+    assert_eq!(reply, "+PONG\r\n");
+
+    // The socket is removed when the server shuts down
+    let reply = send_unix(&socket, b"*2\r\n$8\r\nSHUTDOWN\r\n$6\r\nNOSAVE\r\n");
+    assert_eq!(reply, "");
+    assert!(server.wait_for_exit().unwrap().success());
+    assert!(!socket.exists());
+}
+
+#[test]
+fn test_unix_socket_required_without_tcp() {
+    let mut server = utils::start_server_with_args(&["--port", "0"]);
+    assert!(!server.exit_status().unwrap().success());
+}
@@ -1,7 +1,10 @@
 use std::{
-    env,
-    net::TcpListener,
-    process::{Child, Command},
+    env, fs,
+    io::{Read, Write},
+    net::{Shutdown, TcpListener, TcpStream},
+    ops::Deref,
+    path::{Path, PathBuf},
+    process::{Child, Command, ExitStatus},
     thread,
     time::Duration,
 };
@@ -20,6 +23,37 @@ impl Drop for ChildGuard {
     }
 }
 
+impl ChildGuard {
+    // Exit status of the child process, if it has already exited
+    #[allow(dead_code)]
+    pub fn exit_status(&mut self) -> Option<ExitStatus> {
+        self.0.try_wait().unwrap()
+    }
+
+    // Wait up to 5 seconds for the child process to exit, returning its exit status if it did
+    #[allow(dead_code)]
+    pub fn wait_for_exit(&mut self) -> Option<ExitStatus> {
+        for _ in 0..50 {
+            if let Some(status) = self.exit_status() {
+                return Some(status);
+            }
+            thread::sleep(Duration::from_millis(100));
+        }
+        None
+    }
+
+    // Send a signal such as `TERM` to the child process
+    #[allow(dead_code)]
+    pub fn signal(&self, signal: &str) {
+        let status = Command::new("kill")
+            .arg(format!("-{signal}"))
+            .arg(self.0.id().to_string())
+            .status()
+            .unwrap();
+        assert!(status.success());
+    }
+}
+
 // Find a free port on the machine
 pub fn find_free_tcp_port() -> u16 {
     let socket = TcpListener::bind("127.0.0.1:0").unwrap();
@@ -27,7 +61,13 @@ pub fn find_free_tcp_port() -> u16 {
 }
 
 // Start the redis server on the specified port
+#[allow(dead_code)]
 pub fn start_server(port: &str) -> ChildGuard {
+    start_server_with_args(&[port])
+}
+
+// Start the redis server with the given command line arguments
+pub fn start_server_with_args(args: &[&str]) -> ChildGuard {
     let binary_path = env::var("CARGO_MANIFEST_DIR").unwrap()
         + "/target/debug/"
         + env::var("CARGO_PKG_NAME").unwrap().as_ref();
@@ -35,7 +75,7 @@ pub fn start_server(port: &str) -> ChildGuard {
 
     // Adjust path if your binary is named differently
     let child = Command::new(binary_path)
-        .arg(port)
+        .args(args)
         // .stdout(std::process::Stdio::null())
         .spawn()
         .expect("Failed to spawn server");
@@ -49,16 +89,99 @@ pub fn start_server(port: &str) -> ChildGuard {
 #[allow(dead_code)]
 pub struct TestServer {
     server: ChildGuard,
+    pub port: String,
     pub connection: redis::Connection,
 }
 
-pub fn start_server_and_get_connection() -> TestServer {
-    let port = &find_free_tcp_port().to_string();
-    let server = start_server(port);
+// Open a new connection to the server running on the specified port
+#[allow(dead_code)]
+pub fn get_connection(port: &str) -> redis::Connection {
     let client = redis::Client::open(format!("redis://127.0.0.1:{port}/")).unwrap();
+    client.get_connection().unwrap()
+}
+
+#[allow(dead_code)]
+pub fn start_server_and_get_connection() -> TestServer {
+    let port = find_free_tcp_port().to_string();
+    let server = start_server(&port);
+    let connection = get_connection(&port);
 
     TestServer {
         server,
-        connection: client.get_connection().unwrap(),
+        port,
+        connection,
     }
 }
+
+// Send the raw bytes in the given chunks, close the write half and return everything the server replied with
+#[allow(dead_code)]
+pub fn send_raw(port: &str, chunks: &[&[u8]]) -> Vec<u8> {
+    let mut stream = TcpStream::connect(format!("127.0.0.1:{port}")).unwrap();
+    stream
+        .set_read_timeout(Some(Duration::from_secs(5)))
+        .unwrap();
+    for chunk in chunks {
+        stream.write_all(chunk).unwrap();
+        stream.flush().unwrap();
+        // Give the server a chance to read the chunk on its own
+        thread::sleep(Duration::from_millis(50));
+    }
+    stream.shutdown(Shutdown::Write).unwrap();
+
+    let mut reply = Vec::new();
+    stream.read_to_end(&mut reply).unwrap();
+    reply
+}
+
+// Temporary directory of a test, which is removed along with its files when the guard goes out of scope
+pub struct TempDir(PathBuf);
+
+impl Drop for TempDir {
+    // The test may have removed the directory itself
+    fn drop(&mut self) {
+        let _ = fs::remove_dir_all(&self.0);
+    }
+}
+
+impl Deref for TempDir {
+    type Target = Path;
+
+    fn deref(&self) -> &Path {
+        &self.0
+    }
+}
+
+impl AsRef<Path> for TempDir {
+    fn as_ref(&self) -> &Path {
+        &self.0
+    }
+}
+
+// Create a new empty directory for the files written by a test server
+#[allow(dead_code)]
+pub fn create_temp_dir(name: &str) -> TempDir {
+    let dir = env::temp_dir().join(format!(
+        "codecrafters-redis-{name}-{}",
+        find_free_tcp_port()
+    ));
+    let _ = fs::remove_dir_all(&dir);
+    fs::create_dir_all(&dir).unwrap();
+    TempDir(dir)
+}
+
+// Start a server with the given options, storing its files in the given directory
+#[allow(dead_code)]
+pub fn start_server_in_dir(dir: &Path, options: &[&str]) -> (ChildGuard, redis::Connection) {
+    let port = find_free_tcp_port().to_string();
+    let mut args = vec![port.as_str(), "--dir", dir.to_str().unwrap()];
+    args.extend_from_slice(options);
+    let server = start_server_with_args(&args);
+    let connection = get_connection(&port);
+    (server, connection)
+}
+
+// Number of keys in the database of the connection
+#[allow(dead_code)]
+pub fn dbsize(con: &mut redis::Connection) -> usize {
+    redis::cmd("DBSIZE").query(con).unwrap()
+}